@@ -0,0 +1,179 @@
+// Package a2s queries game servers over the Steam query protocol, the way
+// the server browser does. Only A2S_INFO is implemented: the name, map and
+// player counts a server advertises, measured apart from RCON.
+package a2s
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	headerSingle = 0xFFFFFFFF
+	headerSplit  = 0xFFFFFFFE
+
+	requestInfo   = 0x54
+	responseInfo  = 0x49
+	responseChall = 0x41
+
+	// maxPacket is the largest response of a single packet
+	maxPacket = 1400
+	// maxChallenges is how often a server may answer with a challenge
+	// before giving up
+	maxChallenges = 2
+)
+
+var (
+	ErrSplitResponse = errors.New("split responses are not supported")
+	ErrBadResponse   = errors.New("malformed query response")
+)
+
+// Info is a server's answer to A2S_INFO
+type Info struct {
+	Name       string `json:"name"`
+	Map        string `json:"map"`
+	Folder     string `json:"folder"`
+	Game       string `json:"game"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players"`
+	Bots       int    `json:"bots"`
+	Version    string `json:"version"`
+	Password   bool   `json:"password"`
+	VAC        bool   `json:"vac"`
+	// PingMS is the round trip of the query
+	PingMS int64 `json:"ping_ms"`
+}
+
+// QueryInfo asks the server at addr, host:port of its query port, for its
+// info. A server that answers with a challenge is asked again with it.
+func QueryInfo(addr string, timeout time.Duration) (Info, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return Info{}, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return Info{}, err
+	}
+
+	var challenge []byte
+	buf := make([]byte, maxPacket)
+	for i := 0; i <= maxChallenges; i++ {
+		sent := time.Now()
+		if _, err := conn.Write(infoRequest(challenge)); err != nil {
+			return Info{}, err
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			return Info{}, err
+		}
+		ping := time.Since(sent)
+
+		packet := buf[:n]
+		if len(packet) < 5 {
+			return Info{}, fmt.Errorf("%w: %d bytes", ErrBadResponse, len(packet))
+		}
+		switch binary.LittleEndian.Uint32(packet) {
+		case headerSingle:
+		case headerSplit:
+			return Info{}, ErrSplitResponse
+		default:
+			return Info{}, fmt.Errorf("%w: unknown header", ErrBadResponse)
+		}
+
+		switch packet[4] {
+		case responseChall:
+			if len(packet) < 9 {
+				return Info{}, fmt.Errorf("%w: short challenge", ErrBadResponse)
+			}
+			challenge = append([]byte(nil), packet[5:9]...)
+		case responseInfo:
+			info, err := parseInfo(packet[5:])
+			if err != nil {
+				return Info{}, err
+			}
+			info.PingMS = ping.Milliseconds()
+			return info, nil
+		default:
+			return Info{}, fmt.Errorf("%w: response type 0x%02x", ErrBadResponse, packet[4])
+		}
+	}
+	return Info{}, fmt.Errorf("%w: challenged %d times", ErrBadResponse, maxChallenges+1)
+}
+
+func infoRequest(challenge []byte) []byte {
+	req := []byte{0xFF, 0xFF, 0xFF, 0xFF, requestInfo}
+	req = append(req, "Source Engine Query\x00"...)
+	return append(req, challenge...)
+}
+
+// parseInfo reads the fields of an A2S_INFO response after its type byte,
+// the extra data at its end is left out
+func parseInfo(data []byte) (Info, error) {
+	r := reader{data: data}
+	var info Info
+	r.byte() // protocol
+	info.Name = r.string()
+	info.Map = r.string()
+	info.Folder = r.string()
+	info.Game = r.string()
+	r.skip(2) // app ID
+	info.Players = int(r.byte())
+	info.MaxPlayers = int(r.byte())
+	info.Bots = int(r.byte())
+	r.skip(2) // server type, environment
+	info.Password = r.byte() == 1
+	info.VAC = r.byte() == 1
+	info.Version = r.string()
+	if r.err != nil {
+		return Info{}, r.err
+	}
+	return info, nil
+}
+
+// reader reads the fields of a response, the first read past its end sets
+// err
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) byte() byte {
+	if !r.need(1) {
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *reader) skip(n int) {
+	if r.need(n) {
+		r.data = r.data[n:]
+	}
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data, 0)
+	if end < 0 {
+		r.err = fmt.Errorf("%w: unterminated string", ErrBadResponse)
+		return ""
+	}
+	s := string(r.data[:end])
+	r.data = r.data[end+1:]
+	return s
+}
+
+func (r *reader) need(n int) bool {
+	if r.err == nil && len(r.data) < n {
+		r.err = fmt.Errorf("%w: truncated", ErrBadResponse)
+	}
+	return r.err == nil
+}
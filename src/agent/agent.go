@@ -0,0 +1,232 @@
+package agent
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/state"
+)
+
+// A manager runs standalone, as the control plane of other managers, as an
+// agent of a control plane, or both. Agents register over HTTP and send
+// their registration again as heartbeat. The control plane forwards
+// requests for an agent's maps to the agent's own API.
+
+const (
+	bucketAgents = "agents"
+
+	// TokenHeader carries the shared token between agents and control plane
+	TokenHeader = "X-Agent-Token"
+	// UserHeader, RoleHeader and TenantHeader carry the caller of a
+	// forwarded request, agents only trust them next to a valid token
+	UserHeader   = "X-Forwarded-User"
+	RoleHeader   = "X-Forwarded-Role"
+	TenantHeader = "X-Forwarded-Tenant"
+
+	defaultHeartbeatSeconds = 30
+	// missedHeartbeats is how many heartbeats an agent may miss before it
+	// is considered offline
+	missedHeartbeats = 3
+)
+
+var (
+	ErrAgentNotFound  = errors.New("agent not found")
+	ErrInvalidAgent   = errors.New("invalid agent registration")
+	ErrAgentsDisabled = errors.New("no agent token configured")
+	ErrInvalidToken   = errors.New("invalid agent token")
+
+	agentNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// Config is read from config/agent_config.json. Token is shared by the
+// control plane and its agents, without it no agent can register. ControlURL
+// turns this manager into an agent that registers as Name, reachable at
+// AdvertiseURL.
+type Config struct {
+	Token            string `json:"token"`
+	ControlURL       string `json:"control_url,omitempty"`
+	Name             string `json:"name,omitempty"`
+	AdvertiseURL     string `json:"advertise_url,omitempty"`
+	HeartbeatSeconds int    `json:"heartbeat_seconds,omitempty"`
+}
+
+// Registration is what an agent sends to the control plane
+type Registration struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Maps    []string `json:"maps"`
+	Version string   `json:"version,omitempty"`
+}
+
+// Host is a registered agent
+type Host struct {
+	Registration
+	Registered       time.Time `json:"registered"`
+	LastSeen         time.Time `json:"last_seen"`
+	HeartbeatSeconds int       `json:"heartbeat_seconds"`
+	Online           bool      `json:"online"`
+}
+
+// LoadConfig reads the agent config, a missing file disables agents
+func LoadConfig(configFile string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, fmt.Errorf("failed to read agent config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse agent config: %w", err)
+	}
+	if config.HeartbeatSeconds <= 0 {
+		config.HeartbeatSeconds = defaultHeartbeatSeconds
+	}
+	if config.ControlURL != "" {
+		if config.Token == "" || config.Name == "" || config.AdvertiseURL == "" {
+			return config, errors.New("agent config: control_url needs token, name and advertise_url")
+		}
+		if err := validateURL(config.ControlURL); err != nil {
+			return config, fmt.Errorf("agent config: control_url %w", err)
+		}
+		if err := validateURL(config.AdvertiseURL); err != nil {
+			return config, fmt.Errorf("agent config: advertise_url %w", err)
+		}
+	}
+	return config, nil
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
+
+// ValidToken reports whether token is the configured agent token
+func (c Config) ValidToken(token string) bool {
+	return c.Token != "" && subtle.ConstantTimeCompare([]byte(c.Token), []byte(token)) == 1
+}
+
+// Registry keeps the agents registered with the control plane. Hosts are
+// persisted, so they are known again after a restart and come back online
+// with their next heartbeat.
+type Registry struct {
+	config Config
+	hosts  map[string]*Host
+	mu     sync.Mutex
+}
+
+func NewRegistry(config Config) (*Registry, error) {
+	reg := &Registry{config: config, hosts: make(map[string]*Host)}
+	err := state.ForEach(bucketAgents, func(key string, value []byte) error {
+		var host Host
+		if err := json.Unmarshal(value, &host); err != nil {
+			log.Printf("Skipping unreadable agent %s: %v", key, err)
+			return nil
+		}
+		reg.hosts[host.Name] = &host
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load agents: %w", err)
+	}
+	return reg, nil
+}
+
+// Register adds an agent or refreshes its heartbeat
+func (reg *Registry) Register(token string, registration Registration) (Host, error) {
+	if reg.config.Token == "" {
+		return Host{}, ErrAgentsDisabled
+	}
+	if !reg.config.ValidToken(token) {
+		return Host{}, ErrInvalidToken
+	}
+	if !agentNamePattern.MatchString(registration.Name) {
+		return Host{}, fmt.Errorf("%w: name must be 1-64 letters, digits, '.', '_' or '-'", ErrInvalidAgent)
+	}
+	if err := validateURL(registration.URL); err != nil {
+		return Host{}, fmt.Errorf("%w: url %v", ErrInvalidAgent, err)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	host, exists := reg.hosts[registration.Name]
+	if !exists {
+		host = &Host{Registered: time.Now()}
+		reg.hosts[registration.Name] = host
+		log.Printf("Agent %s registered from %s", registration.Name, registration.URL)
+	} else if !reg.onlineLocked(host) {
+		log.Printf("Agent %s is back online", registration.Name)
+	}
+	host.Registration = registration
+	host.LastSeen = time.Now()
+	host.HeartbeatSeconds = reg.config.HeartbeatSeconds
+	if err := state.Put(bucketAgents, host.Name, host); err != nil {
+		log.Printf("Failed to persist agent %s: %v", host.Name, err)
+	}
+	return reg.snapshotLocked(host), nil
+}
+
+func (reg *Registry) onlineLocked(host *Host) bool {
+	interval := time.Duration(reg.config.HeartbeatSeconds) * time.Second
+	return time.Since(host.LastSeen) < missedHeartbeats*interval
+}
+
+func (reg *Registry) snapshotLocked(host *Host) Host {
+	snapshot := *host
+	snapshot.Online = reg.onlineLocked(host)
+	return snapshot
+}
+
+// Hosts returns the registered agents sorted by name
+func (reg *Registry) Hosts() []Host {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	hosts := make([]Host, 0, len(reg.hosts))
+	for _, host := range reg.hosts {
+		hosts = append(hosts, reg.snapshotLocked(host))
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts
+}
+
+// Host returns a registered agent
+func (reg *Registry) Host(name string) (Host, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	host, exists := reg.hosts[name]
+	if !exists {
+		return Host{}, fmt.Errorf("%w: %s", ErrAgentNotFound, name)
+	}
+	return reg.snapshotLocked(host), nil
+}
+
+// Remove forgets an agent, it registers again with its next heartbeat
+// unless it was shut down or its token changed
+func (reg *Registry) Remove(name string) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, exists := reg.hosts[name]; !exists {
+		return fmt.Errorf("%w: %s", ErrAgentNotFound, name)
+	}
+	if err := state.Delete(bucketAgents, name); err != nil {
+		return err
+	}
+	delete(reg.hosts, name)
+	return nil
+}
@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// StartHeartbeat registers this manager with the control plane and repeats
+// the registration every heartbeat. maps returns the maps managed here.
+func StartHeartbeat(config Config, maps func() []string) {
+	if config.ControlURL == "" {
+		return
+	}
+	go func() {
+		online := false
+		ticker := time.NewTicker(time.Duration(config.HeartbeatSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			err := register(config, Registration{Name: config.Name, URL: config.AdvertiseURL, Maps: maps()})
+			switch {
+			case err != nil && online:
+				log.Printf("Lost control plane %s: %v", config.ControlURL, err)
+			case err != nil && !online:
+				log.Printf("Failed to register with control plane %s: %v", config.ControlURL, err)
+			case err == nil && !online:
+				log.Printf("Registered as agent %s with control plane %s", config.Name, config.ControlURL)
+			}
+			online = err == nil
+			<-ticker.C
+		}
+	}()
+}
+
+func register(config Config, registration Registration) error {
+	body, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(config.ControlURL, "/")+"/v1/agents/register", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TokenHeader, config.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("control plane returned %s", resp.Status)
+	}
+	return nil
+}
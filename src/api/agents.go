@@ -0,0 +1,193 @@
+package api
+
+import (
+	"asa_servermanager_api/agent"
+	"asa_servermanager_api/tracing"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+var (
+	agent_conf = "config/agent_config.json"
+
+	agentConfig agent.Config
+	agents      *agent.Registry
+
+	// routeTable is the route table the control plane authorizes forwarded
+	// requests against, agents run the same API
+	routeTable []route
+	// routeLookup matches paths to the patterns of routeTable
+	routeLookup *http.ServeMux
+)
+
+func agentsError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, agent.ErrInvalidToken):
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+	case errors.Is(err, agent.ErrAgentsDisabled):
+		respondError(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+	case errors.Is(err, agent.ErrInvalidAgent):
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	case errors.Is(err, agent.ErrAgentNotFound):
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	default:
+		log.Printf("Failed to update agents: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
+
+// RegisterAgent adds an agent to the control plane or refreshes its
+// heartbeat
+func RegisterAgent(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var registration agent.Registration
+	if !decodeBody(w, r, &registration) {
+		return
+	}
+	host, err := agents.Register(r.Header.Get(agent.TokenHeader), registration)
+	if err != nil {
+		agentsError(w, err)
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Agent registered", "agent": host})
+}
+
+func ListAgents(w http.ResponseWriter, r *http.Request) {
+	respondOK(w, map[string]interface{}{"agents": agents.Hosts()})
+}
+
+func RemoveAgent(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := agents.Remove(name); err != nil {
+		agentsError(w, err)
+		return
+	}
+	logf(r, "Agent %s removed", name)
+	respondOK(w, map[string]interface{}{"status": "Agent removed", "name": name})
+}
+
+// findRoute returns the route serving a request and sets the request's
+// path values. Routes sharing a pattern are told apart by method, a route
+// serving any method is alone on its pattern.
+func findRoute(r *http.Request) (route, bool) {
+	if routeLookup == nil {
+		return route{}, false
+	}
+	_, pattern := routeLookup.Handler(r)
+	if pattern == "" {
+		return route{}, false
+	}
+	var candidates []route
+	for _, rt := range routeTable {
+		if rt.Path == pattern {
+			candidates = append(candidates, rt)
+		}
+	}
+	for _, rt := range candidates {
+		if rt.Method == r.Method || rt.AnyMethod || (len(candidates) == 1 && rt.Legacy) ||
+			(rt.Method == http.MethodGet && r.Method == http.MethodHead) {
+			for name, value := range pathValues(pattern, r.URL.Path) {
+				r.SetPathValue(name, value)
+			}
+			return rt, true
+		}
+	}
+	return route{}, false
+}
+
+// pathValues returns the wildcards of a pattern matched by path
+func pathValues(pattern string, path string) map[string]string {
+	values := make(map[string]string)
+	patternSegments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, segment := range patternSegments {
+		if !strings.HasPrefix(segment, "{") || i >= len(pathSegments) {
+			continue
+		}
+		name := strings.Trim(segment, "{}")
+		if rest, ok := strings.CutSuffix(name, "..."); ok {
+			values[rest] = strings.Join(pathSegments[i:], "/")
+			break
+		}
+		values[name] = pathSegments[i]
+	}
+	return values
+}
+
+// ProxyHost forwards /hosts/{host}/{path} to the agent's own API. The
+// control plane authorizes the caller against the route first and passes
+// the caller on to the agent.
+func ProxyHost(w http.ResponseWriter, r *http.Request) {
+	name, path := r.PathValue("host"), "/"+r.PathValue("path")
+
+	host, err := agents.Host(name)
+	if err != nil {
+		agentsError(w, err)
+		return
+	}
+	if !host.Online {
+		respondError(w, http.StatusBadGateway, ErrCodeBadGateway, fmt.Sprintf("agent %s is offline since %s", name, host.LastSeen.Format("2006-01-02 15:04:05")))
+		return
+	}
+	target, err := url.Parse(host.URL)
+	if err != nil {
+		respondError(w, http.StatusBadGateway, ErrCodeBadGateway, fmt.Sprintf("agent %s has an invalid url: %v", name, err))
+		return
+	}
+
+	forwarded := r.Clone(r.Context())
+	forwarded.URL.Path = path
+	forwarded.URL.RawPath = ""
+	rt, ok := findRoute(forwarded)
+	local := strings.TrimPrefix(path, apiVersionPrefix)
+	if !ok || strings.HasPrefix(local, "/hosts/") || strings.HasPrefix(local, "/agents") {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no such endpoint on agents: "+path)
+		return
+	}
+	authorizeMiddleware(rt, func(w http.ResponseWriter, r *http.Request) {
+		caller, err := callerFromRequest(r)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+			return
+		}
+		role, err := effectiveRole(caller)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to load permissions")
+			return
+		}
+
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.SetXForwarded()
+				pr.Out.Header.Del(apiKeyHeader)
+				pr.Out.Header.Del("Cookie")
+				pr.Out.Header.Set(agent.TokenHeader, agentConfig.Token)
+				pr.Out.Header.Set(agent.UserHeader, caller.Name)
+				pr.Out.Header.Set(agent.RoleHeader, role)
+				if caller.Tenant != "" {
+					pr.Out.Header.Set(agent.TenantHeader, caller.Tenant)
+				}
+				if info := requestInfoFrom(r); info != nil {
+					pr.Out.Header.Set(requestIDHeader, info.ID)
+				}
+				tracing.Inject(r.Context(), pr.Out.Header)
+			},
+			// Stream /events and large downloads as they arrive
+			FlushInterval: -1,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logf(r, "Failed to forward %s to agent %s: %v", path, name, err)
+				respondError(w, http.StatusBadGateway, ErrCodeBadGateway, fmt.Sprintf("agent %s did not respond", name))
+			},
+		}
+		proxy.ServeHTTP(w, r)
+	})(w, forwarded)
+}
@@ -1,62 +1,358 @@
 package api
 
 import (
+	"asa_servermanager_api/agent"
 	"asa_servermanager_api/backup"
+	"asa_servermanager_api/broadcast"
+	"asa_servermanager_api/cheats"
+	"asa_servermanager_api/cluster"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/firewall"
+	"asa_servermanager_api/gamelog"
+	"asa_servermanager_api/incidents"
+	"asa_servermanager_api/inidrift"
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/logalerts"
+	"asa_servermanager_api/macros"
+	"asa_servermanager_api/monitor"
+	"asa_servermanager_api/notify"
 	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/provision"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/rules"
+	"asa_servermanager_api/selfbackup"
+	"asa_servermanager_api/tenants"
+	"asa_servermanager_api/tracing"
+	"asa_servermanager_api/updater"
+	"asa_servermanager_api/wipe"
+	"context"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
 var (
-	limiter      = rate.NewLimiter(rate.Every(time.Second), 10)
-	limiterMutex sync.Mutex
+	limiter = newClientLimiter(defaultRateLimitConfig())
+
+	backups   *backup.BackupManager
+	processes *processmanager.ProcessManager
+
+	// listener is an already bound listener, e.g. one passed in by systemd
+	listener net.Listener
+	ready    = make(chan struct{})
+
+	// server is the API's HTTP server once it is listening, guarded by
+	// serverMu. stopStreams is closed when it shuts down, event streams
+	// never finish on their own.
+	server          *http.Server
+	serverMu        sync.Mutex
+	shutdownTimeout = defaultShutdownTimeoutSeconds * time.Second
+	stopStreams     = make(chan struct{})
 )
 
-func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		limiterMutex.Lock()
-		defer limiterMutex.Unlock()
+const defaultPort = 8080
+
+// SetListener makes SetupRoutes serve on l instead of binding the port of
+// the server config
+func SetListener(l net.Listener) {
+	listener = l
+}
+
+// Ready is closed once the managers are up and the API is listening
+func Ready() <-chan struct{} {
+	return ready
+}
+
+// SetupRoutes starts the managers and serves the API until Shutdown is
+// called. It returns the error that stopped the API from listening or
+// serving, nil after a shutdown.
+func SetupRoutes() error {
+	serverConfig, err := loadServerConfig(server_conf)
+	if err != nil {
+		log.Fatalf("Failed to load server config: %v", err)
+	}
+	setupRateLimiter(serverConfig.RateLimit)
+	apiKeys = serverConfig.APIKeys
+	allowAnonymous = serverConfig.AllowAnonymous
+	corsConfig = serverConfig.CORS
+	unversionedSunset, _ = serverConfig.legacySunset()
+	dryrun.SetGlobal(serverConfig.DryRun)
+	if serverConfig.DryRun {
+		log.Printf("Dry run: stops, restores, retention cleanups, wild dino wipes and updates only report what they would do")
+	}
+	if err := rcon.Configure(serverConfig.RCON); err != nil {
+		log.Fatalf("Failed to load server config: %v", err)
+	}
+	if err := tracing.Setup(serverConfig.Tracing); err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
 
-		if !limiter.Allow() {
-			http.Error(w, "Rate limit exceeded. Try again later.", http.StatusTooManyRequests)
-			return
+	if err := notify.Load("config/notify_config.json"); err != nil {
+		log.Fatalf("Failed to load notification config: %v", err)
+	}
+	if err := notify.LoadWebhooks(webhooks_conf); err != nil {
+		log.Fatalf("Failed to load webhooks: %v", err)
+	}
+	if err := broadcast.Load("config/broadcast_config.json"); err != nil {
+		log.Fatalf("Failed to load broadcast config: %v", err)
+	}
+	if err := firewall.Load("config/firewall_config.json"); err != nil {
+		log.Fatalf("Failed to load firewall config: %v", err)
+	}
+	if err := tenants.Load(tenant_conf); err != nil {
+		log.Fatalf("Failed to load tenant config: %v", err)
+	}
+	for _, key := range apiKeys {
+		if err := tenants.Validate(key.Tenant); err != nil {
+			log.Fatalf("API key %s: %v", key.Name, err)
 		}
-		next(w, r)
 	}
-}
 
-func SetupRoutes() {
+	jobs.RecoverInterrupted()
 
 	process_conf := "config/process_config.json"
 	pm, err := processmanager.NewProcessManager(process_conf)
 	if err != nil {
 		log.Fatalf("Failed to create process manager: %v", err)
 	}
+	logScanner, err = logalerts.NewScanner(logalerts_conf)
+	if err != nil {
+		log.Fatalf("Failed to initialize log alerts: %v", err)
+	}
+	pm.ConsoleLine = func(mapName string, line string) {
+		logScanner.Console(mapName, line)
+		consoleLines.publish(mapName, line)
+	}
 	pm.StartAllProcesses()
+	processes = pm
 
 	backup_conf := "config/backup_config.json"
 	bm, err := backup.NewBackupManager(backup_conf)
 	if err != nil {
 		log.Fatalf("Failed to initialize BackupManager: %v", err)
 	}
+	backups = bm
+	bm.Starting = func(mapName string) bool {
+		ms, ok := pm.State(mapName)
+		return ok && ms.Actual == processmanager.ActualRunning && ms.Readiness == processmanager.ReadinessStarting
+	}
+	bm.Environment = backupEnvironment
+	pm.Rollback = func(mapName string, lastReady time.Time) (string, error) {
+		restore, err := bm.RestorePointInTime(mapName, lastReady, dryrun.Global())
+		if err == nil && restore.Preview != nil {
+			return "", fmt.Errorf("dry run, %s would have been restored", restore.Archive)
+		}
+		return restore.Archive, err
+	}
+	pm.Recover = func(job *jobs.Handle, mapName string, before time.Time) (interface{}, error) {
+		recovery, err := bm.RecoverSave(job, mapName, before)
+		return recovery, err
+	}
+	if bm.TakesSnapshot(backup.TriggerRestart) {
+		pm.Snapshot = func(job *jobs.Handle, mapName string) error {
+			_, err := bm.SnapshotForJob(job, mapName, backup.TriggerRestart)
+			return err
+		}
+	}
+	loadKnownMaps(process_conf)
+
+	clusters, err = cluster.NewClusterManager(cluster_conf, pm, bm)
+	if err != nil {
+		log.Fatalf("Failed to initialize ClusterManager: %v", err)
+	}
+	clusters.Start()
+	provisioner, err = provision.NewProvisioner(provision_conf, pm, bm)
+	if err != nil {
+		log.Fatalf("Failed to initialize Provisioner: %v", err)
+	}
+	provisioner.OnRegistered = func(string) { loadKnownMaps(process_conf) }
+
+	stats, err = monitor.NewMonitor(monitor_conf, pm)
+	if err != nil {
+		log.Fatalf("Failed to initialize Monitor: %v", err)
+	}
+	stats.Start()
+
+	updates, err = updater.NewUpdater(update_conf, pm)
+	if err != nil {
+		log.Fatalf("Failed to initialize Updater: %v", err)
+	}
+	updates.Snapshot = func(job *jobs.Handle, mapName string) error {
+		_, err := bm.SnapshotForJob(job, mapName, backup.TriggerUpdate)
+		return err
+	}
+	updates.HourlyPlayers = func(mapName string, span time.Duration) (map[int]float64, error) {
+		population, err := stats.Population(mapName, span, 0)
+		if err != nil {
+			return nil, err
+		}
+		hours := make(map[int]float64, len(population.Hours))
+		for _, hour := range population.Hours {
+			hours[hour.Hour] = hour.Average
+		}
+		return hours, nil
+	}
+	updates.Start()
+
+	wipes, err = wipe.NewWiper(wipe_conf, pm)
+	if err != nil {
+		log.Fatalf("Failed to initialize Wiper: %v", err)
+	}
+	wipes.Start()
+
+	driftWatcher, err = inidrift.NewWatcher(drift_conf, pm)
+	if err != nil {
+		log.Fatalf("Failed to initialize INI drift watcher: %v", err)
+	}
+	driftWatcher.Start()
+
+	gameLogs, err = gamelog.NewCollector(gamelog_conf, pm)
+	if err != nil {
+		log.Fatalf("Failed to initialize game log collector: %v", err)
+	}
+	gameLogs.Start()
+
+	selfBackups, err = selfbackup.NewManager(selfbackup_conf, pm)
+	if err != nil {
+		log.Fatalf("Failed to initialize self-backups: %v", err)
+	}
+	selfBackups.Start()
+
+	cheatProxy, err = cheats.NewProxy(cheats_conf)
+	if err != nil {
+		log.Fatalf("Failed to initialize cheats: %v", err)
+	}
+
+	macroManager, err = macros.NewManager(macros_conf, pm)
+	if err != nil {
+		log.Fatalf("Failed to initialize macros: %v", err)
+	}
+	macroManager.BeforeIni = func(mapName string, file string, data []byte) error {
+		_, err := backupIni(mapName, file, data)
+		return err
+	}
+	macroManager.Start()
+
+	ruleEngine, err = rules.NewEngine(rules_conf, pm, bm)
+	if err != nil {
+		log.Fatalf("Failed to initialize rule engine: %v", err)
+	}
+	ruleEngine.RunMacro = func(name string, mapName string) error {
+		_, err := macroManager.RunAndWait(name, mapName)
+		return err
+	}
+	ruleEngine.PlanMacro = macroManager.Plan
+	ruleEngine.Start()
+	incidentCollector, err = incidents.NewCollector(incidents_conf, pm)
+	if err != nil {
+		log.Fatalf("Failed to initialize incident collector: %v", err)
+	}
+	for _, pattern := range incidentCollector.LogPatterns() {
+		if !logScanner.HasPattern(pattern) {
+			log.Fatalf("Incidents config: unknown log pattern %s", pattern)
+		}
+	}
+	incidentCollector.Start()
+
+	logScanner.OnAlert = func(alert logalerts.Alert) {
+		ruleEngine.LogAlert(alert.Pattern, alert.Map, alert.Line)
+		incidentCollector.LogAlert(alert.Pattern, alert.Map, alert.Line)
+	}
+	logScanner.Start()
+
 	err = bm.StartOrResumeBackups()
 	if err != nil {
 		log.Fatalf("Failed to start or resume backups: %v", err)
 	}
+	bm.StartDiskGuard()
+
+	agentConfig, err = agent.LoadConfig(agent_conf)
+	if err != nil {
+		log.Fatalf("Failed to load agent config: %v", err)
+	}
+	agents, err = agent.NewRegistry(agentConfig)
+	if err != nil {
+		log.Fatalf("Failed to initialize agent registry: %v", err)
+	}
+	agent.StartHeartbeat(agentConfig, func() []string {
+		var maps []string
+		for _, ms := range pm.States() {
+			maps = append(maps, ms.Map)
+		}
+		return maps
+	})
+
+	routeTable = apiRoutes()
+	registerRoutes(http.DefaultServeMux, routeTable)
+	http.HandleFunc("/openapi.json", OpenAPISpec)
+	http.HandleFunc("/docs", SwaggerDocs)
+	http.HandleFunc("/docs/", SwaggerDocs)
+	http.HandleFunc("/", Dashboard)
+	http.Handle("/dashboard/", http.FileServer(http.FS(dashboardUI)))
 
-	http.HandleFunc("/start", rateLimitMiddleware(StartProcess))
-	http.HandleFunc("/stop", rateLimitMiddleware(StopProcess))
-	http.HandleFunc("/list", rateLimitMiddleware(ListFiles))
-	http.HandleFunc("/restore", rateLimitMiddleware(RestoreFile))
-	http.HandleFunc("/backup", rateLimitMiddleware(ManualBackup))
-	http.HandleFunc("/backupon", rateLimitMiddleware(ScheduleBackupOn))
-	http.HandleFunc("/backupoff", rateLimitMiddleware(ScheduleBackupOff))
-	http.HandleFunc("/rcon", rateLimitMiddleware(RconComs))
-	http.HandleFunc("/logs", rateLimitMiddleware(GetMapLogs))
+	if listener == nil {
+		listenAddr := ":" + strconv.Itoa(serverConfig.Port)
+		l, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+		}
+		listener = l
+	}
 
-	http.ListenAndServe(":8080", nil)
+	timeouts := serverConfig.Timeouts
+	srv := &http.Server{
+		Handler:           requestMiddleware(serverConfig.AccessLog, corsMiddleware(serverConfig.CORS, http.DefaultServeMux)),
+		ReadHeaderTimeout: timeouts.readHeader(),
+		ReadTimeout:       timeouts.read(),
+		WriteTimeout:      timeouts.write(),
+		IdleTimeout:       timeouts.idle(),
+	}
+	srv.RegisterOnShutdown(func() { close(stopStreams) })
+	if serverConfig.GRPCPort != 0 {
+		if err := serveGRPC(serverConfig.GRPCPort); err != nil {
+			return err
+		}
+	}
+	serverMu.Lock()
+	server = srv
+	shutdownTimeout = timeouts.shutdown()
+	serverMu.Unlock()
+	close(ready)
+	log.Printf("API listening on %s", listener.Addr())
+
+	if err := srv.Serve(listener); err != http.ErrServerClosed {
+		return fmt.Errorf("API server failed: %w", err)
+	}
+	return nil
+}
+
+// Shutdown stops the API from accepting requests and waits for the requests
+// in flight to finish, at most for the shutdown timeout of the server
+// config. Event streams are closed.
+func Shutdown(ctx context.Context) error {
+	serverMu.Lock()
+	srv, timeout := server, shutdownTimeout
+	serverMu.Unlock()
+	if srv == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	log.Printf("Shutting down the API, waiting up to %s for requests in flight", timeout)
+	err := srv.Shutdown(ctx)
+	stopGRPC(ctx)
+	if err != nil {
+		srv.Close()
+		tracing.Shutdown(context.Background())
+		return fmt.Errorf("requests were still in flight after %s: %w", timeout, err)
+	}
+	if err := tracing.Shutdown(ctx); err != nil {
+		log.Printf("Failed to export the last spans: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"asa_servermanager_api/agent"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/users"
+)
+
+// APIKey grants a named client a role, see config/rcon_permissions.json.
+// Keys of a tenant only reach the tenant's maps.
+type APIKey struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Role   string `json:"role"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// sessionCookie holds the token of a dashboard login
+const sessionCookie = "asa_session"
+
+var (
+	apiKeys []APIKey
+	// allowAnonymous gives callers without a login or API key the default
+	// role of the RCON permissions, see allow_anonymous in the server config
+	allowAnonymous bool
+
+	// twoFactorExempt are the routes a user who must enroll in two-factor
+	// authentication can still use
+	twoFactorExempt = map[string]bool{"/session": true, "/logout": true, "/2fa/enroll": true, "/2fa/confirm": true}
+
+	errUnknownAPIKey = errors.New("unknown API key")
+)
+
+// identify returns the caller of a request and, for session logins, the
+// logged in user. An agent token wins over an API key, which wins over a
+// session cookie.
+func identify(r *http.Request) (rcon.Caller, *users.User, error) {
+	addr := limiter.clientIP(r).String()
+
+	// Requests forwarded by the control plane carry their original caller
+	if token := r.Header.Get(agent.TokenHeader); token != "" {
+		if !agentConfig.ValidToken(token) {
+			return rcon.Caller{}, nil, agent.ErrInvalidToken
+		}
+		return rcon.Caller{Name: r.Header.Get(agent.UserHeader), Role: r.Header.Get(agent.RoleHeader), Addr: addr, Tenant: r.Header.Get(agent.TenantHeader)}, nil, nil
+	}
+
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		for _, k := range apiKeys {
+			if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+				return rcon.Caller{Name: k.Name, Role: k.Role, Addr: addr, Tenant: k.Tenant}, nil, nil
+			}
+		}
+		return rcon.Caller{}, nil, errUnknownAPIKey
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		user, err := users.Lookup(cookie.Value)
+		if err != nil {
+			return rcon.Caller{}, nil, err
+		}
+		return rcon.Caller{Name: user.Name, Role: user.Role, Addr: addr, Tenant: user.Tenant}, &user, nil
+	}
+	return rcon.Caller{Name: "anonymous", Addr: addr}, nil, nil
+}
+
+// callerFromRequest identifies the client by its API key or session, with
+// its effective role. Requests without either get no role unless the
+// server config allows anonymous callers.
+func callerFromRequest(r *http.Request) (rcon.Caller, error) {
+	caller, _, err := identify(r)
+	if err != nil {
+		return caller, err
+	}
+	caller.Role, err = effectiveRole(caller)
+	return caller, err
+}
+
+// effectiveRole is the caller's role. Callers without one get the default
+// role of the RCON permissions if the operator allowed anonymous callers
+// and no API key or user exists yet, no role otherwise.
+func effectiveRole(caller rcon.Caller) (string, error) {
+	if caller.Role != "" || !allowAnonymous || len(apiKeys) > 0 {
+		return caller.Role, nil
+	}
+	// Once accounts exist, callers have to use them
+	exist, err := users.Exist()
+	if err != nil || exist {
+		return "", err
+	}
+	perms, err := rcon.LoadPermissions()
+	if err != nil {
+		return "", err
+	}
+	return perms.DefaultRole, nil
+}
+
+// routeMap returns the map a request acts on, if the route takes one
+func routeMap(rt route, r *http.Request) string {
+	for _, p := range rt.Params {
+		if p.Name == "map" {
+			return paramValue(p, r)
+		}
+	}
+	return ""
+}
+
+// otherMaps returns the maps named by the other parameters of a request,
+// such as the map a backup is restored into
+func otherMaps(rt route, r *http.Request) []string {
+	var maps []string
+	for _, p := range rt.Params {
+		if value := paramValue(p, r); p.Map && value != "" {
+			maps = append(maps, value)
+		}
+	}
+	return maps
+}
+
+func paramValue(p param, r *http.Request) string {
+	if p.In == "path" {
+		return r.PathValue(p.Name)
+	}
+	return r.URL.Query().Get(p.Name)
+}
+
+// routeCluster returns the cluster a request acts on, if the route takes one
+func routeCluster(rt route, r *http.Request) string {
+	for _, p := range rt.Params {
+		if p.Name != "cluster" {
+			continue
+		}
+		if p.In == "path" {
+			return r.PathValue("cluster")
+		}
+		return r.URL.Query().Get("cluster")
+	}
+	return ""
+}
+
+// checkScope reports why a caller may not use a route, empty if it may.
+// Callers limited to some maps only reach the routes of those maps, of
+// clusters made of them and, for tenants, the scoped routes.
+func checkScope(rt route, r *http.Request, scope callerScope) string {
+	for _, mapName := range otherMaps(rt, r) {
+		if !scope.allows(mapName) {
+			return "no access to map " + mapName
+		}
+	}
+	if mapName := routeMap(rt, r); mapName != "" {
+		if !scope.allows(mapName) {
+			return "no access to map " + mapName
+		}
+		return ""
+	}
+	if name := routeCluster(rt, r); name != "" && scope.restricted() {
+		maps, err := clusters.Maps(name)
+		if err == nil && !scope.allowsAll(maps) {
+			return "no access to every map of cluster " + name
+		}
+		return ""
+	}
+	if scope.tenant != "" && !rt.Scoped {
+		return "not available to callers of tenant " + scope.tenant
+	}
+	return ""
+}
+
+// authorizeMiddleware checks the caller's role against the route's role
+// and the maps the caller is limited to before calling the handler
+func authorizeMiddleware(rt route, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := requestInfoFrom(r)
+		if info != nil {
+			info.Route = rt.Method + " " + rt.Path
+		}
+		caller, user, err := identify(r)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+			return
+		}
+		if info != nil {
+			info.Caller = caller.Name
+		}
+		if rt.Role != "" {
+			role, err := effectiveRole(caller)
+			if err != nil {
+				log.Printf("Failed to authorize %s: %v", caller.Name, err)
+				respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to load permissions")
+				return
+			}
+			if role == "" {
+				respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("requires the %s role, send an API key or log in", rt.Role))
+				return
+			}
+			if !users.HasRole(role, rt.Role) {
+				respondError(w, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("requires the %s role", rt.Role))
+				return
+			}
+		}
+		if user != nil && !twoFactorExempt[strings.TrimPrefix(rt.Path, apiVersionPrefix)] {
+			enroll, err := users.NeedsEnrollment(*user)
+			if err != nil {
+				log.Printf("Failed to read two-factor policy: %v", err)
+				respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read two-factor policy")
+				return
+			}
+			if enroll {
+				respondError(w, http.StatusForbidden, ErrCodeTwoFactorRequired, "your role requires two-factor authentication, enroll at /v1/2fa/enroll first")
+				return
+			}
+		}
+		if denied := checkScope(rt, r, scopeOf(caller, user)); denied != "" {
+			respondError(w, http.StatusForbidden, ErrCodeForbidden, denied)
+			return
+		}
+		next(w, r)
+	}
+}
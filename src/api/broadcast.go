@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"asa_servermanager_api/broadcast"
+	"asa_servermanager_api/rcon"
+)
+
+// BroadcastTest renders a template and sends it in-game. Template defaults
+// to the event's template on the map.
+type BroadcastTest struct {
+	Event    string            `json:"event"`
+	Template *string           `json:"template,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
+}
+
+func GetBroadcastTemplates(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	respondOK(w, map[string]interface{}{
+		"map":       mapName,
+		"templates": broadcast.Templates(mapName),
+		"variables": []string{broadcast.VarMap, broadcast.VarMinutesRemaining, broadcast.VarSecondsRemaining, broadcast.VarReason},
+	})
+}
+
+// TestBroadcast sends a rendering of a template on a map, so a template can
+// be checked before the event it belongs to happens
+func TestBroadcast(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	var test BroadcastTest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&test); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	tmpl := broadcast.Template(test.Event, mapName)
+	if test.Template != nil {
+		tmpl = *test.Template
+	}
+	if err := broadcast.Validate(test.Event, tmpl); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	// Sample values for the variables the request leaves out
+	vars := broadcast.Minutes(5).With(broadcast.VarReason, "Test broadcast")
+	for k, v := range test.Vars {
+		vars[k] = v
+	}
+	message, unknown := broadcast.RenderTemplate(tmpl, mapName, vars)
+	message = rcon.SanitizeText(message)
+	if message == "" {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "the template of "+test.Event+" is empty, nothing is broadcast")
+		return
+	}
+
+	if _, err := rcon.ExecuteAs(caller, mapName, "broadcast "+message); err != nil {
+		logf(r, "Failed to send test broadcast on map %s: %v", mapName, err)
+		switch {
+		case errors.Is(err, rcon.ErrMapNotConfigured):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, rcon.ErrRequestFailed):
+			respondError(w, http.StatusBadGateway, ErrCodeBadGateway, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Broadcast sent", "map": mapName, "event": test.Event, "message": message, "unknown_variables": unknown})
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"asa_servermanager_api/bundle"
+)
+
+// maxBundleBytes caps the size of an imported config bundle
+const maxBundleBytes = 32 << 20
+
+// ExportConfig downloads the manager's configuration as a bundle. It holds
+// the config files as they are, API keys and RCON passwords included.
+func ExportConfig(w http.ResponseWriter, r *http.Request) {
+	b, err := bundle.Export()
+	if err != nil {
+		logf(r, "Failed to export the configuration: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to export the configuration")
+		return
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		logf(r, "Failed to encode the config bundle: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to export the configuration")
+		return
+	}
+
+	logf(r, "Exported the configuration, %d config files", len(b.Files))
+	// Written as is, the redaction of responses would break the secrets
+	// the bundle has to carry
+	name := "asa_config_" + b.Created.Format("2006-01-02_15-04-05") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Write(append(data, '\n'))
+}
+
+// ImportConfig validates a bundle from ExportConfig and applies it, the
+// config files take effect when the manager is restarted
+func ImportConfig(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var b bundle.Bundle
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBundleBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&b); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid config bundle: "+err.Error())
+		return
+	}
+	if err := b.Validate(); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	if isDryRun(r) {
+		respondPlan(w, b.Plan())
+		return
+	}
+
+	result, err := bundle.Import(b)
+	if err != nil {
+		logf(r, "Failed to import the config bundle: %v", err)
+		if errors.Is(err, bundle.ErrInvalid) {
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to import the config bundle: "+err.Error())
+		return
+	}
+	logf(r, "Imported a config bundle of %s, %d config files", b.Host, len(result.Files))
+	respondOK(w, map[string]interface{}{"status": "Configuration imported, restart the manager to apply the config files", "result": result})
+}
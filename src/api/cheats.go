@@ -0,0 +1,60 @@
+package api
+
+import (
+	"asa_servermanager_api/cheats"
+	"asa_servermanager_api/rcon"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var (
+	cheats_conf = "config/cheats_config.json"
+
+	cheatProxy *cheats.Proxy
+)
+
+func ListCheats(w http.ResponseWriter, r *http.Request) {
+	respondOK(w, map[string]interface{}{"cheats": cheatProxy.Cheats(), "per_minute": cheatProxy.PerMinute()})
+}
+
+// RunCheat sends one of the curated admin cheats to a player of a map,
+// attributed to the caller in the audit log
+func RunCheat(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	var req cheats.Request
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	result, err := cheatProxy.Run(caller, mapName, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, cheats.ErrInvalidRequest), errors.Is(err, rcon.ErrInvalidCommand):
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		case errors.Is(err, cheats.ErrUnknownCheat), errors.Is(err, rcon.ErrMapNotConfigured):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, cheats.ErrRateLimited):
+			respondError(w, http.StatusTooManyRequests, ErrCodeRateLimited, err.Error())
+		case errors.Is(err, rcon.ErrRequestFailed):
+			respondError(w, http.StatusBadGateway, ErrCodeBadGateway, err.Error())
+		default:
+			logf(r, "Failed to run cheat %s on %s: %v", req.Cheat, mapName, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	logf(r, "Ran cheat %s on player %s of %s", req.Cheat, req.Player, mapName)
+	respondOK(w, map[string]interface{}{"status": "Cheat executed", "result": result})
+}
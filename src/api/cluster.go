@@ -0,0 +1,174 @@
+package api
+
+import (
+	"asa_servermanager_api/cluster"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	cluster_conf = "config/cluster_config.json"
+
+	clusters *cluster.ClusterManager
+
+	errUnknownCluster = errors.New("unknown cluster")
+)
+
+func validateClusterName(value string) error {
+	if len(value) > maxMapNameLength || !mapNamePattern.MatchString(value) {
+		return fmt.Errorf("cluster name must be 1-%d letters, digits, '-' or '_'", maxMapNameLength)
+	}
+	if clusters != nil {
+		for _, name := range clusters.Clusters() {
+			if name == value {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s", errUnknownCluster, value)
+}
+
+func validateMessage(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("message must not be blank")
+	}
+	if len(value) > maxCommandLength {
+		return fmt.Errorf("message must not be longer than %d characters", maxCommandLength)
+	}
+	return nil
+}
+
+func ListClusters(w http.ResponseWriter, r *http.Request) {
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	statuses := make([]cluster.Status, 0)
+	for _, name := range clusters.Clusters() {
+		if scope.restricted() {
+			maps, err := clusters.Maps(name)
+			if err != nil || !scope.allowsAll(maps) {
+				continue
+			}
+		}
+		status, err := clusters.Status(name)
+		if err != nil {
+			logf(r, "Failed to get status of cluster %s: %v", name, err)
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Clusters retrieved", "clusters": statuses})
+}
+
+func ClusterStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("cluster")
+
+	status, err := clusters.Status(name)
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Cluster status retrieved", "cluster": status})
+}
+
+func ClusterRestart(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("cluster")
+
+	progress, err := clusters.RollingRestart(name)
+	if err != nil {
+		if errors.Is(err, cluster.ErrRestartInProgress) {
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+			return
+		}
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Rolling restart started", "cluster": name, "restart": progress})
+}
+
+func ClusterBroadcast(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("cluster")
+	message := r.URL.Query().Get("message")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	failed, err := clusters.Broadcast(caller, name, message)
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Message broadcast", "cluster": name, "failed": failed})
+}
+
+func ClusterBackup(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("cluster")
+	full := r.URL.Query().Get("full") == "true"
+
+	jobs, err := clusters.Backup(name, full)
+	if err != nil {
+		logf(r, "Failed to queue backup of cluster %s: %v", name, err)
+		if errors.Is(err, cluster.ErrClusterNotFound) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Cluster backup initiated", "cluster": name, "jobs": jobs})
+}
+
+func ClusterTransfers(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("cluster")
+	player := r.URL.Query().Get("player")
+
+	report, err := clusters.Transfers(name, player)
+	if err != nil {
+		switch {
+		case errors.Is(err, cluster.ErrClusterNotFound), errors.Is(err, cluster.ErrNoClusterDir):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		default:
+			logf(r, "Failed to list transfers of cluster %s: %v", name, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the cluster directory")
+		}
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Transfers retrieved", "transfers": report})
+}
+
+// RestoreClusterTransfer recovers a lost character or item upload from a
+// backup of the cluster directory
+func RestoreClusterTransfer(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	name := r.URL.Query().Get("cluster")
+	zipName := r.URL.Query().Get("zip")
+	file := r.URL.Query().Get("file")
+
+	restored, err := clusters.RestoreTransfer(name, zipName, file)
+	if err != nil {
+		logf(r, "Failed to restore transfer %s of cluster %s: %v", file, name, err)
+		if errors.Is(err, cluster.ErrClusterNotFound) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, conflictCode(err), err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Transfer restored", "cluster": name, "files": restored})
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/tracing"
+	"fmt"
+	"time"
+)
+
+const server_conf = "config/server_config.json"
+
+const (
+	defaultReadHeaderTimeoutSeconds = 10
+	defaultReadTimeoutSeconds       = 60
+	defaultIdleTimeoutSeconds       = 120
+	defaultShutdownTimeoutSeconds   = 30
+)
+
+// ServerConfig holds settings of the HTTP API itself
+type ServerConfig struct {
+	// Port the API listens on, 8080 by default
+	Port      int             `json:"port,omitempty"`
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	APIKeys   []APIKey        `json:"api_keys"`
+	Timeouts  TimeoutConfig   `json:"timeouts"`
+	CORS      CORSConfig      `json:"cors"`
+	AccessLog AccessLogConfig `json:"access_log"`
+	Tracing   tracing.Config  `json:"tracing"`
+	// RCON bounds how long commands wait on a server and when a map's
+	// RCON is marked unavailable
+	RCON rcon.ClientConfig `json:"rcon"`
+	// LegacySunset is the date, YYYY-MM-DD, the unversioned paths and the
+	// query endpoints are announced to be removed on, by default a year
+	// after they were deprecated
+	LegacySunset string `json:"legacy_sunset,omitempty"`
+	// DryRun makes every stop, restore, retention cleanup, wild dino wipe
+	// and update only report what it would do, including scheduled ones
+	// and the actions of rules
+	DryRun bool `json:"dry_run,omitempty"`
+	// AllowAnonymous gives callers without a login or API key the
+	// default_role of rcon_permissions.json. Without it they get no role.
+	AllowAnonymous bool `json:"allow_anonymous,omitempty"`
+	// GRPCPort is the port of the gRPC API, see proto/manager.proto. It is
+	// only served when set.
+	GRPCPort int `json:"grpc_port,omitempty"`
+}
+
+// TimeoutConfig limits how long the API waits on clients, in seconds
+type TimeoutConfig struct {
+	// ReadHeader is the time to read a request's headers, default 10
+	ReadHeader int `json:"read_header,omitempty"`
+	// Read is the time to read a whole request, default 60
+	Read int `json:"read,omitempty"`
+	// Write is the time to write a response, 0 (the default) for no
+	// limit. Restores and downloads of large backups can take long, event
+	// streams and WebSockets are not limited by it.
+	Write int `json:"write,omitempty"`
+	// Idle is how long a keep-alive connection is kept open between
+	// requests, default 120
+	Idle int `json:"idle,omitempty"`
+	// Shutdown is how long a shutdown waits for requests in flight,
+	// default 30
+	Shutdown int `json:"shutdown,omitempty"`
+}
+
+// seconds converts a timeout setting, 0 takes the fallback
+func seconds(value int, fallback int) time.Duration {
+	if value == 0 {
+		value = fallback
+	}
+	return time.Duration(value) * time.Second
+}
+
+func (c TimeoutConfig) readHeader() time.Duration {
+	return seconds(c.ReadHeader, defaultReadHeaderTimeoutSeconds)
+}
+
+func (c TimeoutConfig) read() time.Duration {
+	return seconds(c.Read, defaultReadTimeoutSeconds)
+}
+
+func (c TimeoutConfig) write() time.Duration {
+	return seconds(c.Write, 0)
+}
+
+func (c TimeoutConfig) idle() time.Duration {
+	return seconds(c.Idle, defaultIdleTimeoutSeconds)
+}
+
+func (c TimeoutConfig) shutdown() time.Duration {
+	return seconds(c.Shutdown, defaultShutdownTimeoutSeconds)
+}
+
+// legacySunset returns the sunset date of the deprecated routes
+func (c ServerConfig) legacySunset() (time.Time, error) {
+	if c.LegacySunset == "" {
+		return unversionedDeprecated.AddDate(1, 0, 0), nil
+	}
+	sunset, err := time.Parse("2006-01-02", c.LegacySunset)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("legacy_sunset must be a date like 2027-10-16")
+	}
+	return sunset, nil
+}
+
+// loadServerConfig reads the server config, a missing file yields defaults.
+// ASA_API_* variables override it, e.g. ASA_API_PORT.
+func loadServerConfig(filename string) (ServerConfig, error) {
+	config := ServerConfig{Port: defaultPort, RateLimit: defaultRateLimitConfig()}
+
+	if err := configfile.LoadOptional(filename, "ASA_API", &config); err != nil {
+		return config, fmt.Errorf("failed to load server config: %w", err)
+	}
+	if config.Port <= 0 || config.Port > 65535 {
+		return config, fmt.Errorf("server config: port %d is not a port number", config.Port)
+	}
+	t := config.Timeouts
+	if t.ReadHeader < 0 || t.Read < 0 || t.Write < 0 || t.Idle < 0 || t.Shutdown < 0 {
+		return config, fmt.Errorf("server config: timeouts must not be negative")
+	}
+	if config.AccessLog.SlowMs < 0 {
+		return config, fmt.Errorf("server config: access_log: slow_ms must not be negative")
+	}
+	if _, err := config.legacySunset(); err != nil {
+		return config, fmt.Errorf("server config: %w", err)
+	}
+	if err := config.CORS.validate(); err != nil {
+		return config, fmt.Errorf("server config: cors: %w", err)
+	}
+	return config, nil
+}
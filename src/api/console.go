@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"asa_servermanager_api/rcon"
+)
+
+// maxConsoleCommandLength is longer than for /rcon, console commands are
+// sent as typed, e.g. broadcasts with their whole message
+const maxConsoleCommandLength = 1000
+
+var consoleIDParam = param{Name: "id", In: "path", Description: "Console session ID", Required: true, Type: "string"}
+
+// ConsoleCommand is a command sent in a console session
+type ConsoleCommand struct {
+	Command string `json:"command"`
+}
+
+// ConsoleMessage is a message of the console WebSocket to the client. Type
+// is "history" once after connecting, then "response" or "error" for every
+// command sent.
+type ConsoleMessage struct {
+	Type     string          `json:"type"`
+	Exchange *rcon.Exchange  `json:"exchange,omitempty"`
+	History  []rcon.Exchange `json:"history,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func validateConsoleCommand(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("command must not be blank")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("command must be a single line")
+	}
+	if utf8.RuneCountInString(value) > maxConsoleCommandLength {
+		return fmt.Errorf("command must not be longer than %d characters", maxConsoleCommandLength)
+	}
+	return nil
+}
+
+func consoleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rcon.ErrInvalidCommand):
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	case errors.Is(err, rcon.ErrCommandDenied):
+		respondError(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+	case errors.Is(err, rcon.ErrMapNotConfigured), errors.Is(err, rcon.ErrSessionNotFound):
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, rcon.ErrTooManySessions):
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+	case errors.Is(err, rcon.ErrRequestFailed):
+		respondError(w, http.StatusBadGateway, ErrCodeBadGateway, err.Error())
+	default:
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
+
+// findConsole returns the caller's session named by the request path
+func findConsole(w http.ResponseWriter, r *http.Request) (*rcon.Session, bool) {
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return nil, false
+	}
+	session, err := rcon.FindSession(caller, r.PathValue("id"))
+	if err != nil {
+		consoleError(w, err)
+		return nil, false
+	}
+	return session, true
+}
+
+func OpenConsole(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	session, err := rcon.OpenSession(caller, mapName)
+	if err != nil {
+		logf(r, "Failed to open rcon session on %s for %s: %v", mapName, caller.Name, err)
+		consoleError(w, err)
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Console opened", "session": session.Info()})
+}
+
+func ListConsoles(w http.ResponseWriter, r *http.Request) {
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"sessions": rcon.ListSessions(caller)})
+}
+
+func GetConsole(w http.ResponseWriter, r *http.Request) {
+	session, ok := findConsole(w, r)
+	if !ok {
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"session": session.Info(), "history": session.History()})
+}
+
+// SendConsoleCommand runs a command in a session and waits for the whole
+// response
+func SendConsoleCommand(w http.ResponseWriter, r *http.Request) {
+	session, ok := findConsole(w, r)
+	if !ok {
+		return
+	}
+
+	var command ConsoleCommand
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&command); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if err := validateConsoleCommand(command.Command); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	exchange, err := session.Execute(command.Command)
+	if err != nil {
+		consoleError(w, err)
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Command executed", "exchange": exchange})
+}
+
+func CloseConsole(w http.ResponseWriter, r *http.Request) {
+	session, ok := findConsole(w, r)
+	if !ok {
+		return
+	}
+	session.Close()
+	logf(r, "Closed rcon session %s", session.ID())
+
+	respondOK(w, map[string]interface{}{"status": "Console closed", "session": session.Info()})
+}
+
+// ConsoleSocket attaches a WebSocket to a session. Every text message from
+// the client is a command, the responses come back as ConsoleMessages in
+// the order the commands were sent.
+func ConsoleSocket(w http.ResponseWriter, r *http.Request) {
+	session, ok := findConsole(w, r)
+	if !ok {
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	send := func(message ConsoleMessage) error {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return conn.writeText(data)
+	}
+	if err := send(ConsoleMessage{Type: "history", History: session.History()}); err != nil {
+		conn.close(wsCloseNormal, "")
+		return
+	}
+
+	for {
+		data, err := conn.readMessage()
+		if err != nil {
+			if !errors.Is(err, errWebSocketClosed) {
+				logf(r, "Console socket of rcon session %s failed: %v", session.ID(), err)
+			}
+			conn.close(wsCloseNormal, "")
+			return
+		}
+
+		command := string(data)
+		var message ConsoleMessage
+		if err := validateConsoleCommand(command); err != nil {
+			message = ConsoleMessage{Type: "error", Error: err.Error()}
+		} else if exchange, err := session.Execute(command); err != nil && exchange.Command == "" {
+			// Denied or the session was closed, nothing was sent
+			message = ConsoleMessage{Type: "error", Error: err.Error()}
+			if errors.Is(err, rcon.ErrSessionNotFound) {
+				send(message)
+				conn.close(wsCloseNormal, "session closed")
+				return
+			}
+		} else {
+			message = ConsoleMessage{Type: "response", Exchange: &exchange}
+		}
+		if err := send(message); err != nil {
+			conn.close(wsCloseNormal, "")
+			return
+		}
+	}
+}
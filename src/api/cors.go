@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const defaultCORSMaxAgeSeconds = 600
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	defaultCORSHeaders = []string{"Content-Type", apiKeyHeader}
+	// corsExposedHeaders are response headers scripts on other origins may read
+	corsExposedHeaders = []string{"Retry-After", "Content-Disposition", "Allow", requestIDHeader, totalCountHeader, "Deprecation", "Sunset", "Link"}
+)
+
+// CORSConfig lets web pages served from other origins, such as a dashboard
+// hosted elsewhere, call the API. Without allowed origins no CORS headers
+// are sent and browsers keep such pages from reading responses.
+type CORSConfig struct {
+	// AllowedOrigins lists origins like "https://dash.example.com", "*"
+	// allows every origin
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods defaults to GET, HEAD, POST, PUT, PATCH and DELETE
+	AllowedMethods []string `json:"allowed_methods"`
+	// AllowedHeaders are the request headers pages may send, default
+	// Content-Type and X-API-Key
+	AllowedHeaders []string `json:"allowed_headers"`
+	// AllowCredentials lets pages send the session cookie. The cookie is
+	// SameSite=Strict, so it only reaches the API from the same site.
+	AllowCredentials bool `json:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight, in seconds,
+	// default 600
+	MaxAge int `json:"max_age,omitempty"`
+}
+
+var corsConfig CORSConfig
+
+func (c CORSConfig) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return fmt.Errorf("allow_credentials cannot be used with the origin *")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" {
+			return fmt.Errorf("allowed origin %q must be a scheme and host like https://dash.example.com", origin)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max_age must not be negative")
+	}
+	return nil
+}
+
+func (c CORSConfig) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+func (c CORSConfig) headers() []string {
+	if len(c.AllowedHeaders) == 0 {
+		return defaultCORSHeaders
+	}
+	return c.AllowedHeaders
+}
+
+func (c CORSConfig) maxAge() int {
+	if c.MaxAge == 0 {
+		return defaultCORSMaxAgeSeconds
+	}
+	return c.MaxAge
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for an
+// origin, empty if it is not allowed
+func (c CORSConfig) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// listsOrigin reports whether an origin is allowed by name, "*" does not
+// count. WebSockets are only accepted from such origins.
+func (c CORSConfig) listsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed != "*" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware adds the CORS headers for allowed origins and answers
+// preflight requests itself, before rate limits and authorization, as
+// browsers send them without credentials. Preflights from other origins
+// get no CORS headers and never reach a handler.
+func corsMiddleware(config CORSConfig, next http.Handler) http.Handler {
+	methods := strings.Join(config.methods(), ", ")
+	headers := strings.Join(config.headers(), ", ")
+	exposed := strings.Join(corsExposedHeaders, ", ")
+	maxAge := strconv.Itoa(config.maxAge())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := config.allowOrigin(origin)
+		if allowed != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			if config.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if !preflight {
+			if allowed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposed)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowed != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed dashboard
+var dashboardUI embed.FS
+
+// Dashboard serves the web dashboard at the root. Every other path that no
+// route claims gets the usual not found error.
+func Dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no such endpoint: "+r.URL.Path)
+		return
+	}
+	page, err := dashboardUI.ReadFile("dashboard/index.html")
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
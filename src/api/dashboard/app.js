@@ -0,0 +1,518 @@
+"use strict";
+
+// The dashboard only talks to the public API, see /docs
+
+const consoleInterval = 5000;
+const playersInterval = 30000;
+const maxEvents = 200;
+
+const streamedEvents = [
+  "process.state", "process.hang", "process.alert", "process.drained", "process.port_conflict",
+  "job", "backup.job", "backup.progress", "backup.completed", "backup.failed",
+  "rcon.command", "player.joined", "player.left", "gamelog",
+  "update.available", "update.completed", "update.failed",
+  "cluster.transfer", "config.drift",
+];
+
+const $ = (id) => document.getElementById(id);
+
+// Endpoints of the manager serving the dashboard, everything else goes to
+// the selected host
+const localPaths = ["/session", "/login", "/logout", "/2fa/", "/agents", "/users"];
+
+let host = "";
+let selected = "";
+let consoleTimer = null;
+// rconSession is the console session of the selected map, opened with the
+// first command
+let rconSession = null;
+let events = null;
+const players = {};
+
+// hostPath routes a path to the /v1 API of the manager or of the selected
+// agent through /hosts
+function hostPath(path) {
+  if (!host || localPaths.some((p) => path === p || path.startsWith(p))) {
+    return `/v1${path}`;
+  }
+  return `/v1/hosts/${encodeURIComponent(host)}/v1${path}`;
+}
+
+// mapPath returns the path of a map's resource
+function mapPath(mapName, path = "") {
+  return `/maps/${encodeURIComponent(mapName)}${path}`;
+}
+
+// api calls the manager and returns the response body, failures throw the
+// error message of the envelope
+async function api(path, params = {}, method = "GET", payload = undefined) {
+  const query = new URLSearchParams(params).toString();
+  const headers = {};
+  const key = localStorage.getItem("apiKey");
+  if (key) {
+    headers["X-API-Key"] = key;
+  }
+  const init = { method, headers };
+  if (payload !== undefined) {
+    headers["Content-Type"] = "application/json";
+    init.body = JSON.stringify(payload);
+  }
+  const url = hostPath(path);
+  const resp = await fetch(query ? `${url}?${query}` : url, init);
+  const body = await resp.json().catch(() => ({}));
+  if (!resp.ok || !body.success) {
+    const err = new Error(body.error ? body.error.message : `${resp.status} ${resp.statusText}`);
+    err.code = body.error && body.error.code;
+    throw err;
+  }
+  return body;
+}
+
+function el(tag, text, className) {
+  const node = document.createElement(tag);
+  if (text !== undefined) {
+    node.textContent = text;
+  }
+  if (className) {
+    node.className = className;
+  }
+  return node;
+}
+
+function button(label, onClick) {
+  const node = el("button", label);
+  node.type = "button";
+  node.addEventListener("click", (event) => {
+    event.stopPropagation();
+    onClick(node);
+  });
+  return node;
+}
+
+function formatTime(value) {
+  const time = new Date(value);
+  return isNaN(time) || time.getFullYear() < 2000 ? "" : time.toLocaleString();
+}
+
+function formatSize(bytes) {
+  const units = ["B", "KB", "MB", "GB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return `${bytes.toFixed(i ? 1 : 0)} ${units[i]}`;
+}
+
+function logEvent(text, isError) {
+  const item = el("li", undefined, isError ? "error" : "");
+  item.append(el("time", new Date().toLocaleTimeString()), text);
+  const list = $("events");
+  list.prepend(item);
+  while (list.children.length > maxEvents) {
+    list.lastChild.remove();
+  }
+}
+
+// run calls an action endpoint with the button disabled and logs the outcome
+async function run(node, what, path, params, method) {
+  node.disabled = true;
+  try {
+    const body = await api(path, params, method);
+    logEvent(body.status || `${what} done`);
+    return body;
+  } catch (err) {
+    logEvent(`${what} failed: ${err.message}`, true);
+  } finally {
+    node.disabled = false;
+  }
+}
+
+async function loadSession() {
+  let session = null;
+  try {
+    session = await api("/session");
+  } catch (err) {
+    logEvent(`Session: ${err.message}`, true);
+  }
+  const user = session && session.user;
+  $("login-form").hidden = Boolean(user);
+  $("session").hidden = !user;
+  $("session-name").textContent = user ? `${user.name} (${user.role})` : "";
+  $("enroll").hidden = !user || user.two_factor;
+  if (session && session.two_factor_required) {
+    logEvent("Your role requires two-factor authentication, use Set up 2FA", true);
+  }
+}
+
+async function login(event) {
+  event.preventDefault();
+  const code = $("login-code");
+  try {
+    await api("/login", {}, "POST", {
+      name: $("login-name").value,
+      password: $("login-password").value,
+      code: code.value.trim(),
+    });
+    $("login-password").value = "";
+    code.value = "";
+    code.hidden = true;
+    await loadSession();
+    loadMaps().then(loadPlayers);
+  } catch (err) {
+    if (err.code === "two_factor_required") {
+      code.hidden = false;
+      code.focus();
+      return;
+    }
+    logEvent(`Login failed: ${err.message}`, true);
+  }
+}
+
+// enroll walks the user through adding a TOTP secret to an authenticator
+// app and shows the recovery codes once
+async function enroll() {
+  try {
+    const { enrollment } = await api("/2fa/enroll", {}, "POST");
+    const code = prompt(`Add this key to your authenticator app, then enter the code it shows.\n\n${enrollment.secret}\n\n${enrollment.uri}`);
+    if (!code) {
+      return;
+    }
+    const body = await api("/2fa/confirm", {}, "POST", { code: code.trim() });
+    alert(`Two-factor authentication enabled. Store these recovery codes safely, they are only shown once:\n\n${body.recovery_codes.join("\n")}`);
+    loadSession();
+  } catch (err) {
+    logEvent(`2FA setup failed: ${err.message}`, true);
+  }
+}
+
+async function logout() {
+  try {
+    await api("/logout", {}, "POST");
+  } catch (err) {
+    logEvent(`Logout failed: ${err.message}`, true);
+  }
+  loadSession();
+}
+
+async function loadStatus() {
+  try {
+    const body = await api("/status");
+    const update = body.update || {};
+    if (update.updating) {
+      $("update").textContent = "Updating servers";
+    } else if (update.pending) {
+      $("update").textContent = `Server update ${update.latest_build} pending`;
+    } else {
+      $("update").textContent = "";
+    }
+  } catch (err) {
+    $("update").textContent = "";
+  }
+}
+
+async function loadMaps() {
+  let processes;
+  try {
+    processes = (await api("/processes")).processes;
+  } catch (err) {
+    logEvent(`Failed to load maps: ${err.message}`, true);
+    return;
+  }
+
+  const rows = $("map-rows");
+  rows.replaceChildren();
+  for (const ms of processes) {
+    const row = el("tr");
+    if (ms.map === selected) {
+      row.className = "selected";
+    }
+    const state = el("td");
+    state.append(el("span", ms.actual, `badge ${ms.actual}`));
+    const count = players[ms.map];
+    const actions = el("td");
+    actions.append(
+      button("Start", (node) => run(node, `Start ${ms.map}`, mapPath(ms.map, "/start"), {}, "POST")),
+      " ",
+      button("Stop", (node) => {
+        if (confirm(`Stop ${ms.map}?`)) {
+          run(node, `Stop ${ms.map}`, mapPath(ms.map, "/stop"), {}, "POST");
+        }
+      }),
+    );
+    row.append(
+      el("td", ms.map),
+      el("td", ms.desired),
+      state,
+      el("td", formatTime(ms.since)),
+      el("td", count === undefined ? "" : String(count)),
+      el("td", String(ms.crashes)),
+      actions,
+    );
+    row.addEventListener("click", () => select(ms.map));
+    rows.append(row);
+  }
+}
+
+async function loadPlayers() {
+  const names = Array.from($("map-rows").children, (row) => row.firstChild.textContent);
+  for (const name of names) {
+    try {
+      const body = await api(mapPath(name, "/stats"), { range: "1m" });
+      if (body.players) {
+        players[name] = body.players.length;
+      }
+    } catch (err) {
+      delete players[name];
+    }
+  }
+  loadMaps();
+}
+
+function select(name) {
+  selected = name;
+  $("detail").hidden = false;
+  $("detail-title").textContent = name;
+  $("rcon-output").textContent = "";
+  closeRconSession();
+  for (const row of $("map-rows").children) {
+    row.classList.toggle("selected", row.firstChild.textContent === name);
+  }
+
+  clearInterval(consoleTimer);
+  loadConsole();
+  consoleTimer = setInterval(loadConsole, consoleInterval);
+  loadBackups();
+}
+
+async function loadConsole() {
+  const out = $("console");
+  try {
+    const body = await api(mapPath(selected, "/logs"));
+    const atBottom = out.scrollTop + out.clientHeight >= out.scrollHeight - 4;
+    out.textContent = body.logs;
+    $("console-file").textContent = body.truncated ? "(last part of the log)" : "";
+    if (atBottom) {
+      out.scrollTop = out.scrollHeight;
+    }
+  } catch (err) {
+    out.textContent = err.message;
+  }
+}
+
+async function loadBackups() {
+  const rows = $("backup-rows");
+  rows.replaceChildren();
+  let archives;
+  try {
+    archives = (await api(mapPath(selected, "/backups"))).archives;
+  } catch (err) {
+    const row = el("tr");
+    const cell = el("td", err.message, "error");
+    cell.colSpan = 5;
+    row.append(cell);
+    rows.append(row);
+    return;
+  }
+
+  const mapName = selected;
+  for (const archive of archives) {
+    const row = el("tr");
+    const actions = el("td");
+    actions.append(button("Restore", async (node) => {
+      let changes = "";
+      try {
+        const { summary } = (await api(mapPath(mapName, `/backups/${encodeURIComponent(archive.name)}/preview`))).preview;
+        changes = Object.entries(summary).map(([status, count]) => `${count} ${status}`).join(", ");
+      } catch (err) {
+        logEvent(`Preview of ${archive.name} failed: ${err.message}`, true);
+        return;
+      }
+      if (confirm(`Restore ${archive.name} into ${mapName}? Files: ${changes}. Stop the server first.`)) {
+        run(node, `Restore ${archive.name}`, mapPath(mapName, `/backups/${encodeURIComponent(archive.name)}/restore`), {}, "POST");
+      }
+    }));
+    row.append(
+      el("td", archive.name),
+      el("td", archive.type),
+      el("td", formatSize(archive.size)),
+      el("td", formatTime(archive.created)),
+      actions,
+    );
+    rows.append(row);
+  }
+}
+
+async function sendRcon(event) {
+  event.preventDefault();
+  const input = $("rcon-command");
+  const out = $("rcon-output");
+  const command = input.value.trim();
+  if (!command || !selected) {
+    return;
+  }
+  out.textContent += `> ${command}\n`;
+  try {
+    const { exchange } = await rconCommand(command);
+    out.textContent += exchange.error ? `error: ${exchange.error}\n` : `${exchange.response}\n`;
+    input.value = "";
+  } catch (err) {
+    out.textContent += `error: ${err.message}\n`;
+  }
+  out.scrollTop = out.scrollHeight;
+}
+
+// rconCommand sends a command in the selected map's console session,
+// opening a new session if there is none or it expired
+async function rconCommand(command) {
+  for (let attempt = 0; ; attempt++) {
+    if (!rconSession) {
+      rconSession = (await api("/rcon/sessions", { map: selected }, "POST")).session.id;
+    }
+    try {
+      return await api(`/rcon/sessions/${rconSession}`, {}, "POST", { command });
+    } catch (err) {
+      if (err.code !== "not_found" || attempt > 0) {
+        throw err;
+      }
+      rconSession = null;
+    }
+  }
+}
+
+function closeRconSession() {
+  if (rconSession) {
+    api(`/rcon/sessions/${rconSession}`, {}, "DELETE").catch(() => {});
+    rconSession = null;
+  }
+}
+
+// showBackupProgress shows a running backup of the selected map next to the
+// backup button, an empty progress hides it
+function showBackupProgress(progress) {
+  const node = $("backup-progress");
+  if (!progress) {
+    node.textContent = "";
+    return;
+  }
+  let text = `${progress.phase} ${progress.percent}%`;
+  if (progress.eta_seconds > 0) {
+    text += `, ${progress.eta_seconds}s left`;
+  }
+  node.textContent = text;
+}
+
+function handleEvent(event) {
+  const payload = JSON.parse(event.data);
+  const data = payload.data || {};
+  // Progress arrives every second, it is shown instead of logged
+  if (payload.event === "backup.progress") {
+    if (data.map === selected) {
+      showBackupProgress(data.progress);
+    }
+    return;
+  }
+  logEvent(payload.message);
+
+  switch (payload.event) {
+  case "process.state":
+    loadMaps();
+    break;
+  case "backup.job":
+    if (data.map === selected && data.job && data.job.status !== "running") {
+      showBackupProgress(null);
+      if (data.job.status === "done") {
+        loadBackups();
+      }
+    }
+    break;
+  case "player.joined":
+  case "player.left":
+    loadPlayers();
+    break;
+  case "update.available":
+  case "update.completed":
+    loadStatus();
+    break;
+  }
+}
+
+function connectEvents() {
+  if (events) {
+    events.close();
+  }
+  const source = new EventSource(hostPath("/events"));
+  events = source;
+  source.onopen = () => {
+    $("connection").textContent = "live";
+    $("connection").className = "badge running";
+    loadMaps();
+  };
+  source.onerror = () => {
+    $("connection").textContent = "offline";
+    $("connection").className = "badge stopped";
+  };
+  for (const name of streamedEvents) {
+    source.addEventListener(name, handleEvent);
+  }
+}
+
+// loadHosts lists this manager and its agents, the selector stays hidden
+// without agents
+async function loadHosts() {
+  const select = $("host");
+  let body;
+  try {
+    body = await api("/agents");
+  } catch (err) {
+    return;
+  }
+  select.replaceChildren(new Option("this manager", ""));
+  for (const agent of body.agents) {
+    const option = new Option(agent.online ? agent.name : `${agent.name} (offline)`, agent.name);
+    option.disabled = !agent.online;
+    select.append(option);
+  }
+  select.value = host;
+  select.parentElement.hidden = body.agents.length === 0;
+}
+
+function selectHost() {
+  closeRconSession();
+  host = $("host").value;
+  selected = "";
+  clearInterval(consoleTimer);
+  $("detail").hidden = true;
+  loadStatus();
+  loadMaps().then(loadPlayers);
+  connectEvents();
+}
+
+function init() {
+  const key = $("api-key");
+  key.value = localStorage.getItem("apiKey") || "";
+  key.addEventListener("change", () => {
+    if (key.value) {
+      localStorage.setItem("apiKey", key.value);
+    } else {
+      localStorage.removeItem("apiKey");
+    }
+  });
+  $("login-form").addEventListener("submit", login);
+  $("logout").addEventListener("click", logout);
+  $("enroll").addEventListener("click", enroll);
+  $("rcon-form").addEventListener("submit", sendRcon);
+  $("host").addEventListener("change", selectHost);
+  $("backup-now").addEventListener("click", (event) => {
+    run(event.target, `Backup of ${selected}`, mapPath(selected, "/backups"), {}, "POST");
+  });
+
+  loadSession();
+  loadHosts();
+  loadStatus();
+  loadMaps().then(loadPlayers);
+  setInterval(loadPlayers, playersInterval);
+  connectEvents();
+}
+
+init();
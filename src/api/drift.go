@@ -0,0 +1,201 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/inidrift"
+)
+
+var (
+	drift_conf = "config/drift_config.json"
+
+	driftWatcher *inidrift.Watcher
+
+	iniBackupPattern = regexp.MustCompile(`^(GameUserSettings|Game)\.ini\.\d{8}_\d{6}\.\d{3}\.bak$`)
+)
+
+// IniBackup is a copy of an INI file taken before the manager changed it
+type IniBackup struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+func validateIniBackup(value string) error {
+	if !iniBackupPattern.MatchString(value) {
+		return errors.New("backup must be the name of an INI backup such as Game.ini.20240101_120000.000.bak")
+	}
+	return nil
+}
+
+// iniBackups lists the backups of a map's INI file, newest first
+func iniBackups(mapName string, file string) ([]IniBackup, error) {
+	entries, err := os.ReadDir(filepath.Join(iniBackupDir, mapName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	backups := []IniBackup{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !iniBackupPattern.MatchString(name) || !strings.HasPrefix(name, file+".") {
+			continue
+		}
+		backup := IniBackup{Name: name}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, file+"."), ".bak")
+		backup.Time, _ = time.ParseInLocation("20060102_150405.000", stamp, time.Local)
+		if info, err := entry.Info(); err == nil {
+			backup.Size = info.Size()
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+func GetIniDrift(w http.ResponseWriter, r *http.Request) {
+	drifts, err := inidrift.Drifts(r.URL.Query().Get("map"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"drifts": drifts, "watching": driftWatcher.Enabled()})
+}
+
+// CheckIniDrift compares a map's INI files with their baselines now
+// instead of at the next check
+func CheckIniDrift(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	drifts, err := driftWatcher.Check(mapName)
+	if err != nil {
+		logf(r, "Failed to check the INI files of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "INI files checked", "map": mapName, "drifts": drifts})
+}
+
+// AcceptIniDrift makes the current content of a map's INI file its
+// known-good baseline
+func AcceptIniDrift(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	file := r.URL.Query().Get("file")
+
+	path, err := iniPath(mapName, file)
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	if err := inidrift.Accept(mapName, file, path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, file+" does not exist")
+			return
+		}
+		logf(r, "Failed to accept %s of %s: %v", file, mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	logf(r, "Accepted the current %s of map %s as its baseline", file, mapName)
+	respondOK(w, map[string]interface{}{"status": "Drift accepted", "map": mapName, "file": file})
+}
+
+func ListIniBackups(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	file := r.URL.Query().Get("file")
+
+	backups, err := iniBackups(mapName, file)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	baseline, found, err := inidrift.GetBaseline(mapName, file)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	response := map[string]interface{}{"map": mapName, "file": file, "backups": backups}
+	if found {
+		response["baseline"] = map[string]interface{}{"time": baseline.Time, "source": baseline.Source}
+	}
+	respondOK(w, response)
+}
+
+// RevertIni writes a backup of a map's INI file back, by default its
+// known-good baseline. The current file is backed up first.
+func RevertIni(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	file := r.URL.Query().Get("file")
+	backup := r.URL.Query().Get("backup")
+
+	path, err := iniPath(mapName, file)
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+
+	var content []byte
+	if backup == "" {
+		baseline, found, err := inidrift.GetBaseline(mapName, file)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+			return
+		}
+		if !found {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, file+" has no baseline yet")
+			return
+		}
+		content = []byte(baseline.Content)
+	} else {
+		if !strings.HasPrefix(backup, file+".") {
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("backup %s is not a backup of %s", backup, file))
+			return
+		}
+		content, err = os.ReadFile(filepath.Join(iniBackupDir, mapName, backup))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				respondError(w, http.StatusNotFound, ErrCodeNotFound, "backup "+backup+" does not exist")
+				return
+			}
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+			return
+		}
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read "+file)
+		return
+	}
+	snapshot, ok := snapshotBeforeIni(w, mapName)
+	if !ok {
+		return
+	}
+	backupPath := ""
+	if current != nil {
+		if backupPath, err = backupIni(mapName, file, current); err != nil {
+			logf(r, "Failed to back up %s: %v", path, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to back up "+file)
+			return
+		}
+	}
+	if err := inidrift.Write(mapName, file, path, content); err != nil {
+		logf(r, "Failed to write %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to write "+file)
+		return
+	}
+
+	source := backup
+	if source == "" {
+		source = "baseline"
+	}
+	logf(r, "Reverted %s of map %s to %s", file, mapName, source)
+	respondOK(w, map[string]interface{}{"status": "Config reverted", "map": mapName, "file": file, "reverted_to": source, "backup": filepath.Base(backupPath), "snapshot": snapshot})
+}
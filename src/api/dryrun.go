@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net/http"
+
+	"asa_servermanager_api/dryrun"
+)
+
+// dryRunParam asks a destructive operation what it would do
+var dryRunParam = param{Name: "dry_run", Description: "Only return what would be done: files deleted, commands sent and processes killed. Always on while the server config sets dry_run.", Type: "boolean", Validate: validateBool}
+
+// isDryRun reports whether a request only asks what it would do, with
+// dry_run or because every operation is a dry run
+func isDryRun(r *http.Request) bool {
+	return dryrun.Global() || r.URL.Query().Get("dry_run") == "true"
+}
+
+// respondPlan answers a dry run with what the operation would have done
+func respondPlan(w http.ResponseWriter, plan dryrun.Plan) {
+	respondOK(w, map[string]interface{}{"status": "Dry run, nothing was changed", "dry_run": true, "plan": plan})
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/steamcmd"
+)
+
+// environmentSettings are the INI settings recorded in backup manifests,
+// those that change how a save plays
+var environmentSettings = []struct {
+	file, section string
+	keys          []string
+}{
+	{ini.GameUserSettings, "ServerSettings", []string{
+		"DifficultyOffset", "OverrideOfficialDifficulty", "ServerPVE", "ServerHardcore", "XPMultiplier",
+		"TamingSpeedMultiplier", "HarvestAmountMultiplier", "MaxTamedDinos",
+	}},
+	{ini.Game, "/Script/ShooterGame.ShooterGameMode", []string{
+		"MatingIntervalMultiplier", "EggHatchSpeedMultiplier", "BabyMatureSpeedMultiplier",
+		"bDisableStructurePlacementCollision", "bAllowUnlimitedRespecs",
+	}},
+}
+
+// backupEnvironment returns the build, mods, arguments and key settings of
+// a map's server for backup manifests. Mod files are those of the last
+// start that got ready, when mod checks are on.
+func backupEnvironment(mapName string) *backup.Environment {
+	config, ok := processes.Config(mapName)
+	if !ok {
+		return nil
+	}
+	env := &backup.Environment{Settings: make(map[string]string)}
+	env.Build, _ = steamcmd.InstalledBuild(config.InstallDir())
+
+	var active map[int]backup.EnvironmentMod
+	if updates != nil {
+		active = make(map[int]backup.EnvironmentMod)
+		for _, mod := range updates.ActiveMods(mapName) {
+			active[mod.ID] = backup.EnvironmentMod{ID: mod.ID, Name: mod.Name, FileID: mod.FileID, File: mod.File}
+		}
+	}
+	for _, id := range config.ModIDs() {
+		mod, found := active[id]
+		if !found {
+			mod = backup.EnvironmentMod{ID: id}
+		}
+		env.Mods = append(env.Mods, mod)
+	}
+
+	for _, arg := range config.CommandArgs() {
+		env.Args = append(env.Args, maskArg(arg))
+	}
+
+	for _, group := range environmentSettings {
+		data, err := os.ReadFile(filepath.Join(config.IniDir(), group.file))
+		if err != nil {
+			continue
+		}
+		f := ini.Parse(data)
+		for _, key := range group.keys {
+			if values := f.Get(group.section, key); len(values) > 0 {
+				env.Settings[group.file+"/"+group.section+"/"+key] = values[len(values)-1]
+			}
+		}
+	}
+	return env
+}
+
+// maskArg hides the passwords in a map URL or flag
+func maskArg(arg string) string {
+	options := strings.Split(arg, "?")
+	for i, option := range options {
+		key, value, found := strings.Cut(option, "=")
+		if found && value != "" && ini.IsSecret(strings.TrimLeft(key, "-")) {
+			options[i] = key + "=" + secretMask
+		}
+	}
+	return strings.Join(options, "?")
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"asa_servermanager_api/notify"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// eventsBuffer is how many events a slow client may fall behind before
+	// it starts missing them
+	eventsBuffer = 64
+	// eventsKeepAlive is how often an idle stream sends a comment so
+	// proxies do not close it
+	eventsKeepAlive = 30 * time.Second
+)
+
+// StreamEvents sends manager events to the client as server-sent events
+// until it disconnects
+func StreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "streaming is not supported")
+		return
+	}
+
+	var events []string
+	if value := r.URL.Query().Get("events"); value != "" {
+		for _, event := range strings.Split(value, ",") {
+			if event = strings.TrimSpace(event); event != "" {
+				events = append(events, event)
+			}
+		}
+	}
+	mapName := r.URL.Query().Get("map")
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	ch, unsubscribe := notify.Subscribe(events, eventsBuffer)
+	defer unsubscribe()
+
+	// Streams outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logf(r, "Failed to clear the write deadline of an event stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stopStreams:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case payload := <-ch:
+			if mapName != "" && payload.Data["map"] != mapName {
+				continue
+			}
+			// Restricted callers only get the events of their maps
+			if eventMap, _ := payload.Data["map"].(string); scope.restricted() && !scope.allows(eventMap) {
+				continue
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				logf(r, "Failed to encode %s event: %v", payload.Event, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", payload.ID, payload.Event, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
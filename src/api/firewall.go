@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"asa_servermanager_api/firewall"
+)
+
+func GetFirewall(w http.ResponseWriter, r *http.Request) {
+	applied, err := firewall.Applied()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"enabled": firewall.Enabled(), "rules": applied})
+}
+
+// SyncFirewall creates or updates the firewall rules of a map's ports, with
+// dry_run it returns the commands without running them
+func SyncFirewall(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	mapName := r.PathValue("name")
+	dryRun := isDryRun(r)
+
+	config, exists := processes.Config(mapName)
+	if !exists {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "map "+mapName+" has no process configuration")
+		return
+	}
+	result, err := firewall.Sync(config, dryRun)
+	if err != nil {
+		if errors.Is(err, firewall.ErrDisabled) {
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error()+", use dry_run to preview the commands")
+			return
+		}
+		logf(r, "Failed to update the firewall rules of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Firewall rules updated", "result": result})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/gamelog"
+	"asa_servermanager_api/rcon"
+)
+
+var (
+	gamelog_conf = "config/gamelog_config.json"
+
+	gameLogs *gamelog.Collector
+
+	gameLogSort = sortKeys[gamelog.Event]{
+		"time": func(a, b gamelog.Event) int { return a.Time.Compare(b.Time) },
+		"map":  func(a, b gamelog.Event) int { return strings.Compare(a.Map, b.Map) },
+		"type": func(a, b gamelog.Event) int { return strings.Compare(a.Type, b.Type) },
+		"player": func(a, b gamelog.Event) int {
+			return strings.Compare(strings.ToLower(a.Player), strings.ToLower(b.Player))
+		},
+	}
+)
+
+func SearchGameLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := gamelog.Filter{Map: query.Get("map"), Player: query.Get("player"), Type: query.Get("type")}
+	// The validators checked the formats
+	filter.Since, _ = time.Parse(time.RFC3339, query.Get("since"))
+	filter.Until, _ = time.Parse(time.RFC3339, query.Get("until"))
+	// limit predates paging and caps the events paged through
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	if filter.Limit == 0 {
+		filter.Limit = gamelog.NoLimit
+	}
+
+	events, err := gamelog.Search(filter)
+	if err != nil {
+		logf(r, "Failed to search game log: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the game log")
+		return
+	}
+
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	events = scoped(scope, events, func(e gamelog.Event) string { return e.Map })
+	page, info := listPage(w, r, events, gameLogSort, "-time")
+	respondOK(w, map[string]interface{}{"events": page, "pagination": info, "collecting": gameLogs.Enabled()})
+}
+
+// PollGameLog collects a map's game log now instead of at the next poll
+func PollGameLog(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	if !isRunning(mapName) {
+		respondError(w, http.StatusConflict, ErrCodeConflict, "map "+mapName+" is not running")
+		return
+	}
+	events, err := gameLogs.Poll(mapName)
+	if err != nil {
+		if errors.Is(err, rcon.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusBadGateway, ErrCodeBadGateway, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Game log collected", "map": mapName, "events": events})
+}
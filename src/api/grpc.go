@@ -0,0 +1,554 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/proto/managerpb"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/tenants"
+	"asa_servermanager_api/users"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcAPIKey is the metadata entry holding the caller's API key
+const grpcAPIKey = "x-api-key"
+
+var (
+	// grpcRoles are the roles the RPCs require, as their HTTP routes do
+	grpcRoles = map[string]string{
+		managerpb.Manager_StartProcess_FullMethodName:  users.RoleModerator,
+		managerpb.Manager_StopProcess_FullMethodName:   users.RoleModerator,
+		managerpb.Manager_QueueBackup_FullMethodName:   users.RoleModerator,
+		managerpb.Manager_RestoreBackup_FullMethodName: users.RoleAdmin,
+	}
+
+	// grpcServer is the gRPC API once it is listening, guarded by serverMu
+	grpcServer *grpc.Server
+
+	// consoleLines passes the console output of the servers on to the log
+	// streams
+	consoleLines = &consoleFeed{subscribers: make(map[chan string]string)}
+)
+
+type grpcCallerKey struct{}
+
+// managerService implements the gRPC API over the same managers as the
+// HTTP API
+type managerService struct {
+	managerpb.UnimplementedManagerServer
+}
+
+// serveGRPC serves the gRPC API on port until Shutdown is called
+func serveGRPC(port int) error {
+	listenAddr := fmt.Sprintf(":%d", port)
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(grpcUnaryAuth), grpc.StreamInterceptor(grpcStreamAuth))
+	managerpb.RegisterManagerServer(srv, &managerService{})
+
+	serverMu.Lock()
+	grpcServer = srv
+	serverMu.Unlock()
+	log.Printf("gRPC API listening on %s", l.Addr())
+	go func() {
+		if err := srv.Serve(l); err != nil {
+			log.Printf("gRPC API server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopGRPC stops the gRPC API, waiting for the calls in flight until ctx
+// is done. Log and event streams end with the HTTP streams.
+func stopGRPC(ctx context.Context) {
+	serverMu.Lock()
+	srv := grpcServer
+	serverMu.Unlock()
+	if srv == nil {
+		return
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+// grpcRequest turns the metadata of a call into a request, so calls are
+// identified and rate limited like the HTTP requests
+func grpcRequest(ctx context.Context) *http.Request {
+	r := &http.Request{Header: make(http.Header)}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(grpcAPIKey); len(keys) > 0 {
+			r.Header.Set(apiKeyHeader, keys[0])
+		}
+	}
+	return r
+}
+
+// grpcAuthorize checks the caller of a call against the role of its RPC
+// and returns the context carrying the caller
+func grpcAuthorize(ctx context.Context, method string) (context.Context, error) {
+	r := grpcRequest(ctx)
+	if ok, _ := limiter.allow(r); !ok {
+		return nil, status.Error(codes.ResourceExhausted, "Rate limit exceeded. Try again later.")
+	}
+	caller, _, err := identify(r)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	caller.Role, err = effectiveRole(caller)
+	if err != nil {
+		log.Printf("Failed to authorize %s: %v", caller.Name, err)
+		return nil, status.Error(codes.Internal, "failed to load permissions")
+	}
+	if required := grpcRoles[method]; required != "" {
+		if caller.Role == "" {
+			return nil, status.Errorf(codes.Unauthenticated, "requires the %s role, send an API key", required)
+		}
+		if !users.HasRole(caller.Role, required) {
+			return nil, status.Errorf(codes.PermissionDenied, "requires the %s role", required)
+		}
+	}
+	return context.WithValue(ctx, grpcCallerKey{}, caller), nil
+}
+
+func grpcUnaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcAuthorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcAuthorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authorizedStream is a stream whose context carries the caller
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func grpcCaller(ctx context.Context) rcon.Caller {
+	caller, _ := ctx.Value(grpcCallerKey{}).(rcon.Caller)
+	return caller
+}
+
+// grpcMap validates the map of a call and checks the caller may reach it
+func grpcMap(ctx context.Context, mapName string) error {
+	if err := validateMapName(mapName); err != nil {
+		return status.Errorf(codes.InvalidArgument, "map: %v", err)
+	}
+	if !scopeOf(grpcCaller(ctx), nil).allows(mapName) {
+		return status.Error(codes.PermissionDenied, "no access to map "+mapName)
+	}
+	return nil
+}
+
+// grpcError turns the status and error code the HTTP API answers with into
+// a gRPC status
+func grpcError(httpStatus int, code string, message string) error {
+	switch {
+	case code == ErrCodeQuotaExceeded:
+		return status.Error(codes.ResourceExhausted, message)
+	case httpStatus == http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, message)
+	case httpStatus == http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, message)
+	case httpStatus == http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, message)
+	case httpStatus == http.StatusNotFound:
+		return status.Error(codes.NotFound, message)
+	case httpStatus == http.StatusConflict:
+		return status.Error(codes.FailedPrecondition, message)
+	case httpStatus == http.StatusBadGateway:
+		return status.Error(codes.Unavailable, message)
+	}
+	return status.Error(codes.Internal, message)
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func mapStateToProto(ms processmanager.MapState) *managerpb.MapState {
+	state := &managerpb.MapState{
+		Map:        ms.Map,
+		Desired:    string(ms.Desired),
+		Actual:     string(ms.Actual),
+		Pid:        int32(ms.PID),
+		Since:      timestamp(ms.Since),
+		Crashes:    int32(ms.Crashes),
+		Readiness:  string(ms.Readiness),
+		ReadySince: timestamp(ms.ReadySince),
+	}
+	for _, t := range ms.Transitions {
+		state.Transitions = append(state.Transitions, &managerpb.Transition{
+			Time: timestamp(t.Time), Desired: string(t.Desired), Actual: string(t.Actual), Reason: t.Reason,
+		})
+	}
+	return state
+}
+
+func backupJobToProto(job backup.BackupJob) *managerpb.BackupJob {
+	return &managerpb.BackupJob{
+		Id:       job.ID,
+		Map:      job.Map,
+		Type:     job.Type,
+		Status:   job.Status,
+		Archive:  job.Archive,
+		Error:    job.Error,
+		Queued:   timestamp(job.Queued),
+		Started:  timestamp(job.Started),
+		Finished: timestamp(job.Finished),
+		Reason:   job.Reason,
+	}
+}
+
+func (s *managerService) ListProcesses(ctx context.Context, req *managerpb.ListProcessesRequest) (*managerpb.ListProcessesResponse, error) {
+	resp := &managerpb.ListProcessesResponse{}
+	if req.Map == "" {
+		states := scoped(scopeOf(grpcCaller(ctx), nil), processes.States(), func(ms processmanager.MapState) string { return ms.Map })
+		for _, ms := range states {
+			resp.Processes = append(resp.Processes, mapStateToProto(ms))
+		}
+		return resp, nil
+	}
+	if err := grpcMap(ctx, req.Map); err != nil {
+		return nil, err
+	}
+	ms, exists := processes.State(req.Map)
+	if !exists {
+		return nil, status.Error(codes.NotFound, "no process configuration for map "+req.Map)
+	}
+	resp.Processes = append(resp.Processes, mapStateToProto(ms))
+	return resp, nil
+}
+
+func (s *managerService) StartProcess(ctx context.Context, req *managerpb.MapRequest) (*managerpb.ProcessResponse, error) {
+	if err := grpcMap(ctx, req.Map); err != nil {
+		return nil, err
+	}
+	res, err := processes.EnableProcess(req.Map)
+	if err != nil {
+		httpStatus, code := processError(err)
+		return nil, grpcError(httpStatus, code, err.Error())
+	}
+	if err := backups.StartBackupSchedule(req.Map); err != nil {
+		log.Printf("Failed to start backup schedule for map '%s': %v", req.Map, err)
+	}
+	return &managerpb.ProcessResponse{Status: "Process started", Map: req.Map, Logs: res}, nil
+}
+
+func (s *managerService) StopProcess(ctx context.Context, req *managerpb.MapRequest) (*managerpb.ProcessResponse, error) {
+	if err := grpcMap(ctx, req.Map); err != nil {
+		return nil, err
+	}
+	if dryrun.Global() {
+		plan, err := processes.PlanStop(req.Map)
+		if err != nil {
+			httpStatus, code := processError(err)
+			return nil, grpcError(httpStatus, code, err.Error())
+		}
+		data, err := json.Marshal(plan)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &managerpb.ProcessResponse{Status: "Dry run, nothing was changed", Map: req.Map, DryRun: true, PlanJson: string(data)}, nil
+	}
+
+	res, err := processes.DisableProcess(req.Map)
+	if err != nil {
+		httpStatus, code := processError(err)
+		return nil, grpcError(httpStatus, code, err.Error())
+	}
+	return &managerpb.ProcessResponse{Status: "Process stopped", Map: req.Map, Logs: res}, nil
+}
+
+func (s *managerService) QueueBackup(ctx context.Context, req *managerpb.QueueBackupRequest) (*managerpb.BackupJob, error) {
+	if err := grpcMap(ctx, req.Map); err != nil {
+		return nil, err
+	}
+	job, err := backups.QueueBackup(req.Map, req.Full)
+	if err != nil {
+		log.Printf("Failed to queue backup for map %s: %v", req.Map, err)
+		if errors.Is(err, tenants.ErrQuotaExceeded) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return backupJobToProto(job), nil
+}
+
+func (s *managerService) ListBackupJobs(ctx context.Context, req *managerpb.ListBackupJobsRequest) (*managerpb.ListBackupJobsResponse, error) {
+	if req.Map != "" {
+		if err := grpcMap(ctx, req.Map); err != nil {
+			return nil, err
+		}
+	}
+	resp := &managerpb.ListBackupJobsResponse{}
+	list := scoped(scopeOf(grpcCaller(ctx), nil), backups.BackupJobs(req.Map), func(job backup.BackupJob) string { return job.Map })
+	for _, job := range list {
+		resp.Jobs = append(resp.Jobs, backupJobToProto(job))
+	}
+	return resp, nil
+}
+
+func (s *managerService) ListBackups(ctx context.Context, req *managerpb.MapRequest) (*managerpb.ListBackupsResponse, error) {
+	if err := grpcMap(ctx, req.Map); err != nil {
+		return nil, err
+	}
+	archives, err := backups.ListBackups(req.Map, "")
+	if err != nil {
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		log.Printf("Failed to list backups of map %s: %v", req.Map, err)
+		return nil, status.Error(codes.Internal, "failed to list backups")
+	}
+	resp := &managerpb.ListBackupsResponse{}
+	for _, archive := range archives {
+		resp.Archives = append(resp.Archives, &managerpb.Archive{
+			Name: archive.Name, Type: archive.Type, Size: archive.Size, Created: timestamp(archive.Created), Tags: archive.Tags, Note: archive.Note,
+		})
+	}
+	return resp, nil
+}
+
+func (s *managerService) RestoreBackup(ctx context.Context, req *managerpb.RestoreBackupRequest) (*managerpb.RestoreBackupResponse, error) {
+	if err := grpcMap(ctx, req.Map); err != nil {
+		return nil, err
+	}
+	if err := validateArchiveName(req.Archive); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "archive: %v", err)
+	}
+	if req.File != "" {
+		if err := validateFilePath(req.File); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "file: %v", err)
+		}
+	}
+	restoreError := func(err error) error {
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		return grpcError(http.StatusConflict, conflictCode(err), err.Error())
+	}
+
+	if dryrun.Global() {
+		preview, err := backups.PreviewRestore(req.Map, req.Archive, req.File)
+		if err != nil {
+			log.Printf("Failed to preview restore of %s for map %s: %v", req.Archive, req.Map, err)
+			return nil, restoreError(err)
+		}
+		data, err := json.Marshal(preview)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &managerpb.RestoreBackupResponse{DryRun: true, PreviewJson: string(data)}, nil
+	}
+
+	log.Printf("Restoring file %s from zip %s in map %s for %s", req.File, req.Archive, req.Map, grpcCaller(ctx).Name)
+	warnings := backups.EnvironmentWarnings(req.Map, req.Archive, req.Map)
+	restored, err := backups.RestoreBackup(req.Map, req.Archive, req.File)
+	if err != nil {
+		log.Printf("Failed to restore %s for map %s: %v", req.Archive, req.Map, err)
+		return nil, restoreError(err)
+	}
+	return &managerpb.RestoreBackupResponse{Files: restored, Warnings: warnings}, nil
+}
+
+func (s *managerService) ExecuteRcon(ctx context.Context, req *managerpb.RconRequest) (*managerpb.RconResponse, error) {
+	if err := grpcMap(ctx, req.Map); err != nil {
+		return nil, err
+	}
+	if err := validateCommand(req.Command); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "command: %v", err)
+	}
+	response, err := rcon.RconCommand(grpcCaller(ctx), req.Map, req.Command)
+	if err != nil {
+		switch {
+		case errors.Is(err, rcon.ErrInvalidCommand):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, rcon.ErrCommandDenied):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, rcon.ErrMapNotConfigured):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case errors.Is(err, rcon.ErrRequestFailed):
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &managerpb.RconResponse{Response: response}, nil
+}
+
+func (s *managerService) GetStatus(ctx context.Context, req *managerpb.GetStatusRequest) (*managerpb.StatusResponse, error) {
+	update := updates.Status()
+	resp := &managerpb.StatusResponse{
+		UpdatePending: update.Pending,
+		LatestBuild:   update.LatestBuild,
+		Updating:      update.Updating,
+		NextWindow:    timestamp(update.NextWindow),
+		DryRun:        dryrun.Global(),
+	}
+	states := scoped(scopeOf(grpcCaller(ctx), nil), processes.States(), func(ms processmanager.MapState) string { return ms.Map })
+	for _, ms := range states {
+		resp.Processes = append(resp.Processes, mapStateToProto(ms))
+	}
+	return resp, nil
+}
+
+func (s *managerService) StreamLogs(req *managerpb.StreamLogsRequest, stream managerpb.Manager_StreamLogsServer) error {
+	ctx := stream.Context()
+	if err := grpcMap(ctx, req.Map); err != nil {
+		return err
+	}
+	if !processes.HasMap(req.Map) {
+		return status.Error(codes.NotFound, "no process configuration for map "+req.Map)
+	}
+
+	// Subscribing first may repeat a line written while the current log is
+	// read, but loses none
+	lines, unsubscribe := consoleLines.subscribe(req.Map, eventsBuffer)
+	defer unsubscribe()
+
+	current, _, err := processmanager.RetrieveLogs(req.Map, "")
+	if err != nil && !errors.Is(err, processmanager.ErrLogNotFound) {
+		log.Printf("Failed to retrieve logs of map %s: %v", req.Map, err)
+		return status.Error(codes.Internal, "failed to read logs")
+	}
+	for _, line := range strings.Split(strings.TrimRight(current, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if err := stream.Send(&managerpb.LogLine{Line: strings.TrimRight(line, "\r")}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stopStreams:
+			return nil
+		case line := <-lines:
+			if err := stream.Send(&managerpb.LogLine{Time: timestamppb.Now(), Line: line}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *managerService) StreamEvents(req *managerpb.StreamEventsRequest, stream managerpb.Manager_StreamEventsServer) error {
+	ctx := stream.Context()
+	if req.Map != "" {
+		if err := grpcMap(ctx, req.Map); err != nil {
+			return err
+		}
+	}
+	scope := scopeOf(grpcCaller(ctx), nil)
+
+	ch, unsubscribe := notify.Subscribe(req.Events, eventsBuffer)
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stopStreams:
+			return nil
+		case payload := <-ch:
+			if req.Map != "" && payload.Data["map"] != req.Map {
+				continue
+			}
+			// Restricted callers only get the events of their maps
+			if eventMap, _ := payload.Data["map"].(string); scope.restricted() && !scope.allows(eventMap) {
+				continue
+			}
+			data, err := json.Marshal(payload.Data)
+			if err != nil {
+				log.Printf("Failed to encode %s event: %v", payload.Event, err)
+				continue
+			}
+			event := &managerpb.Event{Id: payload.ID, Event: payload.Event, Time: timestamp(payload.Time), Message: payload.Message, DataJson: string(data)}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// consoleFeed passes console lines on to the subscribers of their map.
+// Subscribers that fall behind miss lines rather than hold up the server.
+type consoleFeed struct {
+	mu          sync.Mutex
+	subscribers map[chan string]string
+}
+
+func (f *consoleFeed) subscribe(mapName string, buffer int) (<-chan string, func()) {
+	ch := make(chan string, buffer)
+	f.mu.Lock()
+	f.subscribers[ch] = mapName
+	f.mu.Unlock()
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.subscribers, ch)
+		f.mu.Unlock()
+	}
+}
+
+func (f *consoleFeed) publish(mapName string, line string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch, subscribed := range f.subscribers {
+		if subscribed != mapName {
+			continue
+		}
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
@@ -2,126 +2,652 @@ package api
 
 import (
 	"asa_servermanager_api/backup"
+	"asa_servermanager_api/maplock"
 	"asa_servermanager_api/processmanager"
 	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/tenants"
+	"cmp"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	process_conf = "config/process_config.json"
-	backup_conf  = "config/backup_config.json"
+
+	archiveSort = sortKeys[backup.Archive]{
+		"created": func(a, b backup.Archive) int { return a.Created.Compare(b.Created) },
+		"name":    func(a, b backup.Archive) int { return strings.Compare(a.Name, b.Name) },
+		"size":    func(a, b backup.Archive) int { return cmp.Compare(a.Size, b.Size) },
+		"type":    func(a, b backup.Archive) int { return strings.Compare(a.Type, b.Type) },
+	}
 )
 
 func StartProcess(w http.ResponseWriter, r *http.Request) {
 	mapName := r.URL.Query().Get("map")
 
-	pm, err := processmanager.NewProcessManager(process_conf)
-	if err != nil {
-		log.Printf("Failed to create process manager: %v", err)
-	}
-	res := pm.EnableProcess(mapName)
-
-	bm, err := backup.NewBackupManager(backup_conf)
+	res, err := processes.EnableProcess(mapName)
 	if err != nil {
-		log.Printf("Failed to initialize BackupManager: %v", err)
+		status, code := processError(err)
+		respondError(w, status, code, err.Error())
+		return
 	}
 
-	err = bm.StartBackupSchedule(mapName)
+	err = backups.StartBackupSchedule(mapName)
 	if err != nil {
-		log.Printf("Failed to start backup schedule for map 'center': %v", err)
+		logf(r, "Failed to start backup schedule for map '%s': %v", mapName, err)
 	}
 
-	response := map[string]interface{}{
+	respondOK(w, map[string]interface{}{
 		"status": "Process started",
 		"map":    mapName,
 		"logs":   res,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	})
 }
 
 func StopProcess(w http.ResponseWriter, r *http.Request) {
 	mapName := r.URL.Query().Get("map")
+	if r.URL.Query().Get("mode") == "drain" {
+		drainProcess(w, r)
+		return
+	}
+	if isDryRun(r) {
+		plan, err := processes.PlanStop(mapName)
+		if err != nil {
+			status, code := processError(err)
+			respondError(w, status, code, err.Error())
+			return
+		}
+		respondPlan(w, plan)
+		return
+	}
 
-	pm, err := processmanager.NewProcessManager(process_conf)
+	res, err := processes.DisableProcess(mapName)
 	if err != nil {
-		log.Printf("Failed to create process manager: %v", err)
+		status, code := processError(err)
+		respondError(w, status, code, err.Error())
+		return
 	}
-	res := pm.DisableProcess(mapName)
 
-	response := map[string]interface{}{
-		"status": "Process started",
+	respondOK(w, map[string]interface{}{
+		"status": "Process stopped",
 		"map":    mapName,
 		"logs":   res,
+	})
+}
+
+// drainProcess stops a map once its players have left, in the background
+func drainProcess(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	maxWait, _ := strconv.Atoi(r.URL.Query().Get("max_wait"))
+	opts := processmanager.DrainOptions{
+		MaxWait: time.Duration(maxWait) * time.Second,
+		Message: r.URL.Query().Get("message"),
+	}
+	if isDryRun(r) {
+		plan, err := processes.PlanDrain(mapName, opts)
+		if err != nil {
+			status, code := processError(err)
+			respondError(w, status, code, err.Error())
+			return
+		}
+		respondPlan(w, plan)
+		return
+	}
+
+	job, err := processes.Drain(mapName, opts)
+	if err != nil {
+		status, code := processError(err)
+		respondError(w, status, code, err.Error())
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	logf(r, "Draining map %s in job %s", mapName, job.ID)
+	respondOK(w, map[string]interface{}{
+		"status": "Process draining, it stops once the players have left",
+		"map":    mapName,
+		"job":    job,
+	})
+}
+
+// ProcessStatus returns the desired and actual state of one or every map
+func ProcessStatus(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	if mapName == "" {
+		scope, err := requestScope(r)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+			return
+		}
+		states := scoped(scope, processes.States(), func(ms processmanager.MapState) string { return ms.Map })
+		respondOK(w, map[string]interface{}{"processes": states})
+		return
+	}
+	ms, exists := processes.State(mapName)
+	if !exists {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no process configuration for map "+mapName)
+		return
+	}
+	respondOK(w, map[string]interface{}{"processes": []processmanager.MapState{ms}})
+}
+
+// processError maps a process manager error to a status and error code
+func processError(err error) (int, string) {
+	switch {
+	case errors.Is(err, processmanager.ErrMapNotFound):
+		return http.StatusNotFound, ErrCodeNotFound
+	case errors.Is(err, processmanager.ErrAlreadyRunning), errors.Is(err, processmanager.ErrNotRunning):
+		return http.StatusConflict, ErrCodeConflict
+	case errors.Is(err, tenants.ErrQuotaExceeded):
+		return http.StatusForbidden, ErrCodeQuotaExceeded
+	}
+	return http.StatusInternalServerError, ErrCodeInternal
 }
 
 func ListFiles(w http.ResponseWriter, r *http.Request) {
 	mapName := r.URL.Query().Get("map")
 	fileName := r.URL.Query().Get("file")
 
-	log.Printf("Listing files %s in map %s", fileName, mapName)
-	response := map[string][]string{"files": {"file1.zip", "file2.zip"}}
-	json.NewEncoder(w).Encode(response)
+	archives, err := backups.ListBackups(mapName, fileName)
+	if err != nil {
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		logf(r, "Failed to list backups of map %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to list backups")
+		return
+	}
+
+	tag := r.URL.Query().Get("tag")
+	archiveType := r.URL.Query().Get("type")
+	matching := archives[:0]
+	for _, archive := range archives {
+		if (tag == "" || archive.HasTag(tag)) && (archiveType == "" || archive.Type == archiveType) {
+			matching = append(matching, archive)
+		}
+	}
+
+	page, info := listPage(w, r, matching, archiveSort, "-created")
+	files := make([]string, 0, len(page))
+	for _, archive := range page {
+		files = append(files, archive.Name)
+	}
+	respondOK(w, map[string]interface{}{"map": mapName, "files": files, "archives": page, "pagination": info})
+}
+
+// BackupTags is the body of PUT /backups/tags
+type BackupTags struct {
+	Tags []string `json:"tags"`
+	Note string   `json:"note,omitempty"`
+}
+
+func TagBackup(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPut) {
+		return
+	}
+	mapName := r.URL.Query().Get("map")
+	zipName := r.URL.Query().Get("zip")
+
+	var body BackupTags
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	archive, err := backups.TagBackup(mapName, zipName, body.Tags, body.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, backup.ErrInvalidTag):
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		case errors.Is(err, backup.ErrMapNotConfigured), errors.Is(err, backup.ErrArchiveNotFound):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, maplock.ErrBusy):
+			respondError(w, http.StatusConflict, conflictCode(err), err.Error())
+		default:
+			logf(r, "Failed to tag backup %s of map %s: %v", zipName, mapName, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Backup tags updated", "map": mapName, "archive": archive})
 }
 
 func RestoreFile(w http.ResponseWriter, r *http.Request) {
 	mapName := r.URL.Query().Get("map")
 	zipName := r.URL.Query().Get("zip")
 	fileName := r.URL.Query().Get("file")
-	log.Printf("Restoring file %s from zip %s in map %s", fileName, zipName, mapName)
-	response := map[string]string{"status": "File restored", "map": mapName, "file": fileName}
-	json.NewEncoder(w).Encode(response)
+	staging := r.URL.Query().Get("staging") == "true"
+	targetMap := r.URL.Query().Get("target_map")
+	if (staging && targetMap != "") || (r.URL.Query().Get("dry_run") == "true" && (staging || targetMap != "")) {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "staging, target_map and dry_run cannot be combined")
+		return
+	}
+	if isDryRun(r) {
+		PreviewRestore(w, r)
+		return
+	}
+	if staging || targetMap != "" {
+		restoreElsewhere(w, mapName, zipName, fileName, staging, targetMap)
+		return
+	}
+	logf(r, "Restoring file %s from zip %s in map %s", fileName, zipName, mapName)
+	warnings := backups.EnvironmentWarnings(mapName, zipName, mapName)
+	for _, warning := range warnings {
+		logf(r, "Restore of %s for map %s: %s", zipName, mapName, warning)
+	}
+
+	restored, err := backups.RestoreBackup(mapName, zipName, fileName)
+	if err != nil {
+		logf(r, "Failed to restore %s for map %s: %v", zipName, mapName, err)
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, conflictCode(err), err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "File restored", "map": mapName, "files": restored, "warnings": warnings})
+}
+
+// restoreElsewhere restores into a staging directory or another map's save
+// folder, leaving the map's live save alone
+func restoreElsewhere(w http.ResponseWriter, mapName string, zipName string, fileName string, staging bool, targetMap string) {
+	var result backup.AlternateRestore
+	var err error
+	if staging {
+		result, err = backups.RestoreToStaging(mapName, zipName, fileName)
+	} else {
+		// Overwriting the save of a running server would be undone by its
+		// next save, or corrupt it
+		if ms, ok := processes.State(targetMap); ok && ms.Actual == processmanager.ActualRunning {
+			respondError(w, http.StatusConflict, ErrCodeConflict, "the server of map "+targetMap+" is running, stop it first")
+			return
+		}
+		result, err = backups.RestoreToMap(mapName, zipName, fileName, targetMap)
+	}
+	if err != nil {
+		log.Printf("Failed to restore %s of map %s into %s: %v", zipName, mapName, result.Directory, err)
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, conflictCode(err), err.Error())
+		return
+	}
+
+	// A staged copy is checked against the map's own server
+	target := mapName
+	if targetMap != "" {
+		target = targetMap
+	}
+	warnings := backups.EnvironmentWarnings(mapName, zipName, target)
+	log.Printf("Restored %s of map %s into %s", zipName, mapName, result.Directory)
+	respondOK(w, map[string]interface{}{"status": "Backup restored into " + result.Directory, "map": mapName, "files": result.Files, "restore": result, "warnings": warnings})
+}
+
+func ListStagedRestores(w http.ResponseWriter, r *http.Request) {
+	staged, err := backups.StagedRestores()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Staged restores retrieved", "staged": staged})
+}
+
+func RemoveStagedRestore(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := backups.RemoveStagedRestore(name); err != nil {
+		if errors.Is(err, backup.ErrStagingNotFound) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Staged restore removed", "name": name})
+}
+
+func RestorePointInTime(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	at, _ := time.Parse(time.RFC3339, r.URL.Query().Get("at"))
+	dryRun := isDryRun(r)
+
+	result, err := backups.RestorePointInTime(mapName, at, dryRun)
+	if err != nil {
+		logf(r, "Failed to restore map %s to %s: %v", mapName, at.Format(time.RFC3339), err)
+		if errors.Is(err, backup.ErrMapNotConfigured) || errors.Is(err, backup.ErrNoRestorePoint) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, conflictCode(err), err.Error())
+		return
+	}
+
+	status := "Restored to point in time"
+	if dryRun {
+		status = "Point-in-time restore previewed, nothing was changed"
+	}
+	respondOK(w, map[string]interface{}{"status": status, "map": mapName, "restore": result})
+}
+
+func PreviewRestore(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	zipName := r.URL.Query().Get("zip")
+	fileName := r.URL.Query().Get("file")
+
+	preview, err := backups.PreviewRestore(mapName, zipName, fileName)
+	if err != nil {
+		logf(r, "Failed to preview restore of %s for map %s: %v", zipName, mapName, err)
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Restore previewed, nothing was changed", "map": mapName, "preview": preview})
+}
+
+func VerifyBackups(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	zipName := r.URL.Query().Get("zip")
+
+	results, err := backups.VerifyBackups(mapName, zipName)
+	if err != nil {
+		logf(r, "Failed to verify backups for map %s: %v", mapName, err)
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{
+		"status":  "Backups verified",
+		"map":     mapName,
+		"results": results,
+	})
+}
+
+func GetDedupStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := backups.DedupStats()
+	if err != nil {
+		logf(r, "Failed to read the dedup stores: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Dedup stores retrieved", "stores": stats})
+}
+
+// ReencryptBackups moves a map's archives to the current encryption key
+func ReencryptBackups(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	mapName := r.URL.Query().Get("map")
+
+	result, err := backups.ReencryptBackups(mapName)
+	if err != nil {
+		switch {
+		case errors.Is(err, backup.ErrMapNotConfigured):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, backup.ErrEncryptionDisabled):
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		default:
+			logf(r, "Failed to re-encrypt backups of map %s: %v", mapName, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Backups re-encrypted", "result": result})
+}
+
+// PruneBackups applies a map's retention policy now, with dry_run it lists
+// the files it would delete
+func PruneBackups(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	mapName := r.URL.Query().Get("map")
+	dryRun := isDryRun(r)
+
+	plan, err := backups.PruneBackups(mapName, dryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, backup.ErrMapNotConfigured):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, maplock.ErrBusy):
+			respondError(w, http.StatusConflict, ErrCodeOperationInProgress, err.Error())
+		default:
+			logf(r, "Failed to apply the retention policy of map %s: %v", mapName, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	if dryRun {
+		respondPlan(w, plan)
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Retention policy applied", "map": mapName, "deleted": plan.Deleted})
+}
+
+func SnapshotBackup(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	mapName := r.URL.Query().Get("map")
+	trigger := r.URL.Query().Get("trigger")
+
+	archivePath, err := backups.Snapshot(mapName, trigger)
+	if err != nil {
+		switch {
+		case errors.Is(err, backup.ErrInvalidTrigger):
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		case errors.Is(err, backup.ErrMapNotConfigured):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		default:
+			logf(r, "Failed to take a snapshot of map %s: %v", mapName, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Snapshot taken", "map": mapName, "archive": filepath.Base(archivePath)})
 }
 
 func ManualBackup(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	full := r.URL.Query().Get("full") == "true"
+
+	job, err := backups.QueueBackup(mapName, full)
+	if err != nil {
+		logf(r, "Failed to queue backup for map %s: %v", mapName, err)
+		if errors.Is(err, tenants.ErrQuotaExceeded) {
+			respondError(w, http.StatusForbidden, ErrCodeQuotaExceeded, err.Error())
+			return
+		}
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Manual backup initiated", "map": mapName, "job": job})
+}
 
-	response := map[string]string{"status": "Manual backup initiated"}
-	json.NewEncoder(w).Encode(response)
+func ListBackupJobs(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	list := scoped(scope, backups.BackupJobs(mapName), func(job backup.BackupJob) string { return job.Map })
+	respondOK(w, map[string]interface{}{"status": "Backup jobs retrieved", "jobs": list})
+}
+
+func BackupJobStatus(w http.ResponseWriter, r *http.Request) {
+	jobID := r.URL.Query().Get("job")
+
+	job, ok := backups.BackupJob(jobID)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("job %s not found", jobID))
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Backup job retrieved", "job": job})
+}
+
+func CancelBackupJob(w http.ResponseWriter, r *http.Request) {
+	jobID := r.URL.Query().Get("job")
+
+	job, err := backups.CancelBackupJob(jobID)
+	if err != nil {
+		logf(r, "Failed to cancel backup job %s: %v", jobID, err)
+		if job.ID == "" {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Backup job cancelled", "job": job})
 }
 
 func ScheduleBackupOn(w http.ResponseWriter, r *http.Request) {
 	mapName := r.URL.Query().Get("map")
 
-	response := map[string]string{"status": "Scheduled backup on", "map": mapName}
-	json.NewEncoder(w).Encode(response)
+	if err := backups.StartBackupSchedule(mapName); err != nil {
+		logf(r, "Failed to start backup schedule for map %s: %v", mapName, err)
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	schedule, err := backups.Schedule(mapName)
+	if err != nil {
+		logf(r, "Failed to read backup schedule for map %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Scheduled backup on", "map": mapName, "next_run": schedule.NextRun})
+}
+
+// BackupSchedule returns a map's backup schedule and when it runs next
+func BackupSchedule(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	schedule, err := backups.Schedule(mapName)
+	if err != nil {
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		logf(r, "Failed to read backup schedule for map %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Backup schedule", "schedule": schedule})
 }
 
 func ScheduleBackupOff(w http.ResponseWriter, r *http.Request) {
 	mapName := r.URL.Query().Get("map")
 
-	response := map[string]string{"status": "Scheduled backup off", "map": mapName}
-	json.NewEncoder(w).Encode(response)
+	if err := backups.StopBackupSchedule(mapName); err != nil {
+		logf(r, "Failed to stop backup schedule for map %s: %v", mapName, err)
+		if errors.Is(err, backup.ErrScheduleNotRunning) {
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Scheduled backup off", "map": mapName})
 }
 
-func RconComs(w http.ResponseWriter, r *http.Request) {
+// PauseBackupSchedule skips a map's scheduled backups until it is resumed
+func PauseBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	setBackupSchedulePaused(w, r, true)
+}
+
+// ResumeBackupSchedule runs the scheduled backups of a paused map again
+func ResumeBackupSchedule(w http.ResponseWriter, r *http.Request) {
+	setBackupSchedulePaused(w, r, false)
+}
+
+func setBackupSchedulePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
 	mapName := r.URL.Query().Get("map")
-	rComs := r.URL.Query().Get("command")
-	repz := rcon.RconCommand(mapName, rComs)
-	response := map[string]string{"status": "Command executed", "map": mapName, "data": repz}
-	json.NewEncoder(w).Encode(response)
+
+	change, status := backups.ResumeBackupSchedule, "Backup schedule resumed"
+	if paused {
+		change, status = backups.PauseBackupSchedule, "Backup schedule paused"
+	}
+	schedule, err := change(mapName)
+	if err != nil {
+		switch {
+		case errors.Is(err, backup.ErrMapNotConfigured):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, backup.ErrScheduleNotRunning):
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		default:
+			logf(r, "Failed to change the backup schedule of map %s: %v", mapName, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": status, "schedule": schedule})
 }
 
-func GetMapLogs(w http.ResponseWriter, r *http.Request) {
+func RconComs(w http.ResponseWriter, r *http.Request) {
 	mapName := r.URL.Query().Get("map")
+	rComs := r.URL.Query().Get("command")
 
-	logs, err := processmanager.RetrieveLogs(mapName)
+	caller, err := callerFromRequest(r)
 	if err != nil {
-		log.Printf("Failed to create process manager: %v", err)
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
 	}
 
-	response := map[string]interface{}{
-		"status": "Logs retrieved",
-		"map":    mapName,
-		"logs":   logs,
+	repz, err := rcon.RconCommand(caller, mapName, rComs)
+	if err != nil {
+		switch {
+		case errors.Is(err, rcon.ErrInvalidCommand):
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		case errors.Is(err, rcon.ErrCommandDenied):
+			respondError(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+		case errors.Is(err, rcon.ErrMapNotConfigured):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, rcon.ErrRequestFailed):
+			respondError(w, http.StatusBadGateway, ErrCodeBadGateway, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	body := map[string]interface{}{"status": "Command executed", "map": mapName, "data": repz}
+	if parsed, ok := rcon.Parse(rComs, repz); ok {
+		body["parsed"] = parsed
+	}
+	respondOK(w, body)
 }
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"asa_servermanager_api/incidents"
+)
+
+var (
+	incidents_conf = "config/incidents_config.json"
+
+	incidentCollector *incidents.Collector
+)
+
+// ListIncidents lists the crash bundles, newest first
+func ListIncidents(w http.ResponseWriter, r *http.Request) {
+	found, err := incidentCollector.List(r.URL.Query().Get("map"))
+	if err != nil {
+		logf(r, "Failed to list incidents: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to list the incidents")
+		return
+	}
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	found = scoped(scope, found, func(i incidents.Incident) string { return i.Map })
+	respondOK(w, map[string]interface{}{"incidents": found, "collecting": incidentCollector.Enabled()})
+}
+
+// DownloadIncident sends the zip of an incident as an attachment
+func DownloadIncident(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	id := r.URL.Query().Get("id")
+
+	path, err := incidentCollector.Open(mapName, id)
+	if err != nil {
+		if errors.Is(err, incidents.ErrIncidentNotFound) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		logf(r, "Failed to open incident %s of %s: %v", id, mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the incident")
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the incident")
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the incident")
+		return
+	}
+
+	name := fmt.Sprintf("incident_%s_%s.zip", mapName, id)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
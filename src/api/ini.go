@@ -0,0 +1,229 @@
+package api
+
+import (
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/inidrift"
+	"asa_servermanager_api/secrets"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	iniBackupDir = "./data/ini_backups"
+	secretMask   = "********"
+)
+
+// IniChange is one edit of a PATCH /config/ini request. Op is "set"
+// (default), "add" for another occurrence of a repeatable key, or "delete".
+type IniChange struct {
+	Op      string `json:"op,omitempty"`
+	Section string `json:"section"`
+	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+}
+
+type IniPatch struct {
+	Changes []IniChange `json:"changes"`
+}
+
+// IniSection is a section of an INI file as returned by the API
+type IniSection struct {
+	Name    string      `json:"name"`
+	Entries []ini.Entry `json:"entries"`
+}
+
+func validateIniFile(value string) error {
+	if !ini.IsEditable(value) {
+		return fmt.Errorf("file must be %s or %s", ini.GameUserSettings, ini.Game)
+	}
+	return nil
+}
+
+func iniPath(mapName string, file string) (string, error) {
+	config, ok := processes.Config(mapName)
+	if !ok {
+		return "", fmt.Errorf("map %s has no process configuration", mapName)
+	}
+	return filepath.Join(config.IniDir(), file), nil
+}
+
+func GetIni(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	file := r.URL.Query().Get("file")
+	sectionName := r.URL.Query().Get("section")
+
+	path, err := iniPath(mapName, file)
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, file+" does not exist yet")
+			return
+		}
+		logf(r, "Failed to read %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read "+file)
+		return
+	}
+
+	f := ini.Parse(data)
+	sections := make([]IniSection, 0, len(f.Sections))
+	for _, s := range f.Sections {
+		if sectionName != "" && !strings.EqualFold(s.Name, sectionName) {
+			continue
+		}
+		entries := s.Entries()
+		if s.Name == "" && len(entries) == 0 {
+			continue
+		}
+		for i := range entries {
+			if ini.IsSecret(entries[i].Key) && entries[i].Value != "" {
+				secrets.Register(entries[i].Value)
+				entries[i].Value = secretMask
+			}
+		}
+		sections = append(sections, IniSection{Name: s.Name, Entries: entries})
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Config retrieved", "map": mapName, "file": file, "sections": sections})
+}
+
+func validateIniChange(file string, change IniChange) (known bool, err error) {
+	switch {
+	case change.Op != "" && change.Op != "set" && change.Op != "add" && change.Op != "delete":
+		return false, fmt.Errorf("op must be set, add or delete")
+	case strings.TrimSpace(change.Section) == "" || strings.ContainsAny(change.Section, "[]\r\n"):
+		return false, fmt.Errorf("section must be a section name without brackets")
+	case strings.TrimSpace(change.Key) == "" || strings.ContainsAny(change.Key, "=[]\r\n;"):
+		return false, fmt.Errorf("key %q is not a valid setting name", change.Key)
+	case strings.ContainsAny(change.Value, "\r\n"):
+		return false, fmt.Errorf("value of %s must not contain line breaks", change.Key)
+	}
+	if change.Op == "delete" {
+		return true, nil
+	}
+	return ini.Validate(file, change.Section, change.Key, change.Value)
+}
+
+// backupIni copies the current INI to data/ini_backups before it is changed
+func backupIni(mapName string, file string, data []byte) (string, error) {
+	dir := filepath.Join(iniBackupDir, mapName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s.%s.bak", file, time.Now().Format("20060102_150405.000")))
+	return path, os.WriteFile(path, data, 0644)
+}
+
+// snapshotBeforeIni takes the map's safety snapshot before one of its INI
+// files is changed and responds with an error if the change must not go
+// ahead without it
+func snapshotBeforeIni(w http.ResponseWriter, mapName string) (string, bool) {
+	snapshot, err := backups.SnapshotBefore(mapName, backup.TriggerIniChange, false)
+	if err != nil {
+		log.Printf("Failed to take a snapshot of map %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return "", false
+	}
+	if snapshot == "" {
+		return "", true
+	}
+	return filepath.Base(snapshot), true
+}
+
+func PatchIni(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	file := r.URL.Query().Get("file")
+
+	var patch IniPatch
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if len(patch.Changes) == 0 {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "changes must not be empty")
+		return
+	}
+
+	unknown := []string{}
+	for _, change := range patch.Changes {
+		if ini.IsSecret(change.Key) {
+			secrets.Register(change.Value)
+		}
+		known, err := validateIniChange(file, change)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+			return
+		}
+		if !known {
+			unknown = append(unknown, change.Section+"/"+change.Key)
+		}
+	}
+
+	path, err := iniPath(mapName, file)
+	if err != nil {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logf(r, "Failed to read %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read "+file)
+		return
+	}
+
+	snapshot, ok := snapshotBeforeIni(w, mapName)
+	if !ok {
+		return
+	}
+	backupPath := ""
+	if data != nil {
+		backupPath, err = backupIni(mapName, file, data)
+		if err != nil {
+			logf(r, "Failed to back up %s: %v", path, err)
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to back up "+file)
+			return
+		}
+	}
+
+	f := ini.Parse(data)
+	for _, change := range patch.Changes {
+		switch change.Op {
+		case "add":
+			f.Add(change.Section, change.Key, change.Value)
+		case "delete":
+			f.Delete(change.Section, change.Key)
+		default:
+			f.Set(change.Section, change.Key, change.Value)
+		}
+	}
+
+	if err := inidrift.Write(mapName, file, path, f.Bytes()); err != nil {
+		logf(r, "Failed to write %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to write "+file)
+		return
+	}
+
+	logf(r, "Applied %d change(s) to %s of map %s", len(patch.Changes), file, mapName)
+	respondOK(w, map[string]interface{}{
+		"status":       "Config updated",
+		"map":          mapName,
+		"file":         file,
+		"backup":       filepath.Base(backupPath),
+		"snapshot":     snapshot,
+		"unknown_keys": unknown,
+	})
+}
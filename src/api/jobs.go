@@ -0,0 +1,104 @@
+package api
+
+import (
+	"asa_servermanager_api/jobs"
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	jobIDParam = param{Name: "id", In: "path", Description: "Job ID", Required: true, Type: "string"}
+
+	jobTypes  = []string{jobs.TypeBackup, jobs.TypeRestore, jobs.TypeUpdate, jobs.TypeRestart, jobs.TypeStop, jobs.TypeRecovery, jobs.TypeMacro}
+	jobStates = []string{jobs.StatePending, jobs.StateRunning, jobs.StateSucceeded, jobs.StateFailed, jobs.StateCancelled, jobs.StateSkipped}
+
+	jobSort = sortKeys[jobs.Job]{
+		"created":          func(a, b jobs.Job) int { return a.Created.Compare(b.Created) },
+		"started":          func(a, b jobs.Job) int { return a.Started.Compare(b.Started) },
+		"finished":         func(a, b jobs.Job) int { return a.Finished.Compare(b.Finished) },
+		"duration_seconds": func(a, b jobs.Job) int { return cmp.Compare(a.DurationSeconds, b.DurationSeconds) },
+		"type":             func(a, b jobs.Job) int { return strings.Compare(a.Type, b.Type) },
+		"state":            func(a, b jobs.Job) int { return strings.Compare(a.State, b.State) },
+	}
+)
+
+func validateOneOf(values []string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if v == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func ListJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := jobs.Filter{Map: query.Get("map"), Type: query.Get("type"), State: query.Get("state")}
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	list := jobs.List(filter)
+	if scope.restricted() {
+		visible := make([]jobs.Job, 0, len(list))
+		for _, job := range list {
+			if scope.allowsJob(job) {
+				visible = append(visible, job)
+			}
+		}
+		list = visible
+	}
+	page, info := listPage(w, r, list, jobSort, "-created")
+	respondOK(w, map[string]interface{}{"status": "Jobs retrieved", "jobs": page, "pagination": info})
+}
+
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	job, ok := jobs.Get(id)
+	if !ok || !scope.allowsJob(job) {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("job %s not found", id))
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Job retrieved", "job": job})
+}
+
+func CancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	if job, ok := jobs.Get(id); ok && !scope.allowsJob(job) {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("job %s not found", id))
+		return
+	}
+	job, err := jobs.Cancel(id)
+	if err != nil {
+		logf(r, "Failed to cancel job %s: %v", id, err)
+		if errors.Is(err, jobs.ErrNotFound) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	}
+
+	logf(r, "Cancelled %s job %s", job.Type, job.ID)
+	respondOK(w, map[string]interface{}{"status": "Job cancelled", "job": job})
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"asa_servermanager_api/logalerts"
+	"net/http"
+)
+
+var (
+	logalerts_conf = "config/log_alerts_config.json"
+
+	logScanner *logalerts.Scanner
+)
+
+// ListLogAlerts returns the log patterns and the alerts they raised since
+// the manager started, newest first
+func ListLogAlerts(w http.ResponseWriter, r *http.Request) {
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	alerts := scoped(scope, logScanner.Alerts(r.URL.Query().Get("map")), func(a logalerts.Alert) string { return a.Map })
+	respondOK(w, map[string]interface{}{"patterns": logScanner.Patterns(), "alerts": alerts})
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"asa_servermanager_api/processmanager"
+)
+
+var (
+	logFileParam = param{Name: "file", Description: "Rotated log file from /logs/index, the current log if omitted", Type: "string", Validate: validateArchiveName}
+	logTimeParam = param{Name: "at", Description: "RFC 3339 time, returns the log that covers it", Type: "string", Validate: validateTime}
+)
+
+func validateTime(value string) error {
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		return fmt.Errorf("must be an RFC 3339 time such as 2024-08-01T18:00:00Z")
+	}
+	return nil
+}
+
+func GetMapLogs(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	file := r.URL.Query().Get("file")
+
+	if at := r.URL.Query().Get("at"); at != "" && file == "" {
+		t, _ := time.Parse(time.RFC3339, at)
+		var err error
+		if file, err = processmanager.FindLog(mapName, t); err != nil {
+			logsError(w, mapName, err)
+			return
+		}
+	}
+
+	logs, truncated, err := processmanager.RetrieveLogs(mapName, file)
+	if err != nil {
+		logsError(w, mapName, err)
+		return
+	}
+
+	respondOK(w, map[string]interface{}{
+		"status":    "Logs retrieved",
+		"map":       mapName,
+		"file":      file,
+		"truncated": truncated,
+		"logs":      logs,
+	})
+}
+
+func GetLogIndex(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	index, err := processmanager.GetLogIndex(mapName)
+	if err != nil {
+		logsError(w, mapName, err)
+		return
+	}
+	if index.Segments == nil {
+		index.Segments = []processmanager.LogSegment{}
+	}
+
+	respondOK(w, map[string]interface{}{
+		"map":           mapName,
+		"current_start": index.CurrentStart,
+		"segments":      index.Segments,
+	})
+}
+
+func logsError(w http.ResponseWriter, mapName string, err error) {
+	if errors.Is(err, processmanager.ErrLogNotFound) {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	log.Printf("Failed to retrieve logs of map %s: %v", mapName, err)
+	respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read logs")
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"asa_servermanager_api/macros"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+var (
+	macros_conf = "config/macros_config.json"
+
+	macroManager *macros.Manager
+
+	macroNameParam = param{Name: "name", In: "path", Description: "Macro name", Required: true, Type: "string"}
+)
+
+func macrosError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, macros.ErrInvalidMacro):
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	case errors.Is(err, macros.ErrMacroNotFound), errors.Is(err, processmanager.ErrMapNotFound):
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, macros.ErrMacroExists):
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+	case errors.Is(err, rcon.ErrCommandDenied), errors.Is(err, errMapAccess):
+		respondError(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+	default:
+		log.Printf("Failed to save macros: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
+
+// decodeMacro reads a macro from the request body. Macros run their RCON
+// commands as the manager, so the caller must be allowed to run them, and
+// only be scheduled on maps the caller may access.
+func decodeMacro(w http.ResponseWriter, r *http.Request) (macros.Macro, error) {
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		return macros.Macro{}, err
+	}
+	scope, err := requestScope(r)
+	if err != nil {
+		return macros.Macro{}, err
+	}
+
+	var macro macros.Macro
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&macro); err != nil {
+		return macros.Macro{}, errors.Join(macros.ErrInvalidMacro, err)
+	}
+	for _, schedule := range macro.Schedules {
+		if err := scope.checkMaps(schedule.Maps); err != nil {
+			return macros.Macro{}, err
+		}
+	}
+
+	perms, err := rcon.LoadPermissions()
+	if err != nil {
+		return macros.Macro{}, err
+	}
+	for _, command := range macro.Commands() {
+		if err := perms.Authorize(caller, command); err != nil {
+			return macros.Macro{}, err
+		}
+	}
+	return macro, nil
+}
+
+func ListMacros(w http.ResponseWriter, r *http.Request) {
+	respondOK(w, map[string]interface{}{"macros": macroManager.Macros()})
+}
+
+func CreateMacro(w http.ResponseWriter, r *http.Request) {
+	macro, err := decodeMacro(w, r)
+	if err != nil {
+		if errors.Is(err, errUnknownAPIKey) {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+			return
+		}
+		macrosError(w, err)
+		return
+	}
+	if err := macroManager.Create(macro); err != nil {
+		macrosError(w, err)
+		return
+	}
+
+	logf(r, "Created macro %s", macro.Name)
+	respondOK(w, map[string]interface{}{"status": "Macro created", "macro": macro})
+}
+
+func GetMacro(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	macro, err := macroManager.Get(name)
+	if err != nil {
+		macrosError(w, err)
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"macro": macro})
+}
+
+// UpdateMacro replaces a macro, the name in the body must match the path
+func UpdateMacro(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	macro, err := decodeMacro(w, r)
+	if err != nil {
+		if errors.Is(err, errUnknownAPIKey) {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+			return
+		}
+		macrosError(w, err)
+		return
+	}
+	if macro.Name == "" {
+		macro.Name = name
+	}
+	if macro.Name != name {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "a macro cannot be renamed, delete and create it instead")
+		return
+	}
+	if err := macroManager.Update(macro); err != nil {
+		macrosError(w, err)
+		return
+	}
+
+	logf(r, "Updated macro %s", macro.Name)
+	respondOK(w, map[string]interface{}{"status": "Macro updated", "macro": macro})
+}
+
+func DeleteMacro(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := macroManager.Delete(name); err != nil {
+		macrosError(w, err)
+		return
+	}
+
+	logf(r, "Deleted macro %s", name)
+	respondOK(w, map[string]interface{}{"status": "Macro deleted", "macro": name})
+}
+
+// RunMacro starts a macro on a map, its job reports the result of each
+// step
+func RunMacro(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	mapName := r.URL.Query().Get("map")
+
+	if isDryRun(r) {
+		plan, err := macroManager.Plan(name, mapName)
+		if err != nil {
+			macrosError(w, err)
+			return
+		}
+		respondPlan(w, plan)
+		return
+	}
+
+	job, err := macroManager.Run(name, mapName)
+	if err != nil {
+		macrosError(w, err)
+		return
+	}
+	logf(r, "Started macro %s on map %s as job %s", name, mapName, job.ID)
+	respondOK(w, map[string]interface{}{"status": "Macro started", "macro": name, "map": mapName, "job": job})
+}
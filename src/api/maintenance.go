@@ -0,0 +1,91 @@
+package api
+
+import (
+	"asa_servermanager_api/maintenance"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+const maxMaintenanceReasonLength = 500
+
+// MaintenanceRequest is the body of POST /maintenance
+type MaintenanceRequest struct {
+	Reason string `json:"reason"`
+}
+
+var maintenanceMapParam = param{Name: "map", Description: "Map to put into or out of maintenance, every map without it", Type: "string", Validate: validateMapName}
+
+// GetMaintenance lists the maps in maintenance and the global maintenance
+func GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	modes, err := maintenance.List()
+	if err != nil {
+		logf(r, "Failed to list maintenance modes: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the maintenance modes")
+		return
+	}
+	respondOK(w, map[string]interface{}{"global": maintenance.Global(), "maintenance": scopedModes(scope, modes)})
+}
+
+// StartMaintenance puts a map, or every map, into maintenance
+func StartMaintenance(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	var req MaintenanceRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if len(req.Reason) > maxMaintenanceReasonLength {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "reason must be at most 500 characters")
+		return
+	}
+
+	mode, err := maintenance.Enable(mapName, req.Reason, caller.Name)
+	if err != nil {
+		logf(r, "Failed to enable maintenance: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to enable maintenance")
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "maintenance enabled", "maintenance": mode})
+}
+
+// EndMaintenance takes a map, or every map, out of maintenance
+func EndMaintenance(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	mode, err := maintenance.Disable(mapName, caller.Name)
+	if errors.Is(err, maintenance.ErrNotActive) {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		logf(r, "Failed to end maintenance: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to end maintenance")
+		return
+	}
+
+	warnings := []string{}
+	if mapName != "" && maintenance.Global() {
+		warnings = append(warnings, "every map is still in maintenance")
+	}
+	respondOK(w, map[string]interface{}{"status": "maintenance ended", "maintenance": mode, "warnings": warnings})
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/firewall"
+	"asa_servermanager_api/inidrift"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxRequestBodyBytes = 1 << 20
+
+// MapRegistration is the body of POST /maps
+type MapRegistration struct {
+	Name            string   `json:"name"`
+	Executable      string   `json:"executable"`
+	Args            []string `json:"args"`
+	RestartInterval int      `json:"restart_interval"`
+
+	Launch *processmanager.LaunchConfig `json:"launch,omitempty"`
+	RCON   *rcon.RconInfo               `json:"rcon,omitempty"`
+	Backup *backup.MapConfig            `json:"backup,omitempty"`
+}
+
+func (reg *MapRegistration) validate() error {
+	if len(reg.Name) > maxMapNameLength || !mapNamePattern.MatchString(reg.Name) {
+		return fmt.Errorf("name must be 1-%d letters, digits, '-' or '_'", maxMapNameLength)
+	}
+	if strings.TrimSpace(reg.Executable) == "" {
+		return errors.New("executable is required")
+	}
+	if reg.RestartInterval < 0 {
+		return errors.New("restart_interval must not be negative")
+	}
+	if reg.Launch != nil {
+		if err := reg.Launch.Validate(); err != nil {
+			return fmt.Errorf("launch: %w", err)
+		}
+	}
+	if reg.RCON != nil {
+		if reg.RCON.IP == "" {
+			return errors.New("rcon.ip is required")
+		}
+		if port, err := strconv.Atoi(reg.RCON.Port); err != nil || port < 1 || port > 65535 {
+			return errors.New("rcon.port must be a port number")
+		}
+	}
+	if reg.Backup != nil {
+		if reg.Backup.ZipDir == "" || reg.Backup.ExtractDir == "" {
+			return errors.New("backup.zip_dir and backup.extract_dir are required")
+		}
+		if err := reg.Backup.ValidateSchedule(); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// requireMethod answers 405 unless the request uses method
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		respondError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "method must be "+method)
+		return false
+	}
+	return true
+}
+
+func RegisterMap(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var reg MapRegistration
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reg); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if err := reg.validate(); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	if validateMapName(reg.Name) == nil {
+		respondError(w, http.StatusConflict, ErrCodeConflict, fmt.Sprintf("map %s is already registered", reg.Name))
+		return
+	}
+
+	if reg.RestartInterval == 0 {
+		reg.RestartInterval = 5
+	}
+	config := processmanager.ProcessConfig{
+		Map:             reg.Name,
+		Executable:      reg.Executable,
+		Args:            reg.Args,
+		RestartInterval: reg.RestartInterval,
+		Launch:          reg.Launch,
+	}
+	if err := processes.RegisterMap(config); err != nil {
+		logf(r, "Failed to register map %s: %v", reg.Name, err)
+		if errors.Is(err, processmanager.ErrMapExists) || errors.Is(err, processmanager.ErrPortConflict) {
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	// The process config is the source of truth, later failures are
+	// reported but leave the map registered
+	warnings := []string{}
+	if reg.Launch != nil {
+		warnings = append(warnings, reg.Launch.Warnings(reg.Args)...)
+	}
+	if reg.RCON != nil {
+		info := *reg.RCON
+		info.Map = reg.Name
+		if err := rcon.SaveRconInfo(info); err != nil {
+			logf(r, "Failed to save rcon config of map %s: %v", reg.Name, err)
+			warnings = append(warnings, err.Error())
+		}
+	}
+	if reg.Backup != nil {
+		if err := backups.RegisterMap(reg.Name, *reg.Backup); err != nil {
+			logf(r, "Failed to save backup config of map %s: %v", reg.Name, err)
+			warnings = append(warnings, err.Error())
+		}
+	}
+	if firewall.Enabled() {
+		if _, err := firewall.Sync(config, false); err != nil {
+			logf(r, "Failed to open the firewall for map %s: %v", reg.Name, err)
+			warnings = append(warnings, err.Error())
+		}
+	}
+	loadKnownMaps(process_conf)
+
+	logf(r, "Registered map %s", reg.Name)
+	respondOK(w, map[string]interface{}{"status": "Map registered", "map": reg.Name, "warnings": warnings})
+}
+
+func UnregisterMap(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodDelete) {
+		return
+	}
+	mapName := r.PathValue("name")
+
+	if err := processes.UnregisterMap(mapName); err != nil && !errors.Is(err, processmanager.ErrMapNotFound) {
+		logf(r, "Failed to unregister map %s: %v", mapName, err)
+		if errors.Is(err, processmanager.ErrAlreadyRunning) {
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	warnings := []string{}
+	if err := backups.UnregisterMap(mapName); err != nil {
+		logf(r, "Failed to remove backup config of map %s: %v", mapName, err)
+		warnings = append(warnings, err.Error())
+	}
+	if err := rcon.RemoveRconInfo(mapName); err != nil {
+		logf(r, "Failed to remove rcon config of map %s: %v", mapName, err)
+		warnings = append(warnings, err.Error())
+	}
+	if err := inidrift.RemoveMap(mapName); err != nil {
+		logf(r, "Failed to remove the INI baselines of map %s: %v", mapName, err)
+		warnings = append(warnings, err.Error())
+	}
+	if firewall.Enabled() {
+		if _, err := firewall.Remove(mapName, false); err != nil {
+			logf(r, "Failed to remove the firewall rules of map %s: %v", mapName, err)
+			warnings = append(warnings, err.Error())
+		}
+	}
+	loadKnownMaps(process_conf)
+
+	logf(r, "Unregistered map %s", mapName)
+	respondOK(w, map[string]interface{}{"status": "Map unregistered", "map": mapName, "warnings": warnings})
+}
+
+// GetPorts lists the ports of every map and whether another program holds
+// them
+func GetPorts(w http.ResponseWriter, r *http.Request) {
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	ports := scoped(scope, processes.Ports(), func(port processmanager.PortStatus) string { return port.Map })
+	conflicts := 0
+	for _, port := range ports {
+		if port.Conflict {
+			conflicts++
+		}
+	}
+	respondOK(w, map[string]interface{}{"ports": ports, "conflicts": conflicts})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"asa_servermanager_api/curseforge"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/updater"
+	"errors"
+	"net/http"
+)
+
+// GetModLocks lists the mod sets a map's server got ready with, newest
+// first, and its flagged mods
+func GetModLocks(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	locks, err := updates.ModLocks(mapName)
+	if err != nil {
+		modError(w, r, mapName, err)
+		return
+	}
+	respondOK(w, map[string]interface{}{"mods": locks})
+}
+
+// RollbackMods sets a map's mods list to the last set its server got ready
+// with and flags the mods added or updated since
+func RollbackMods(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	dropUpdated := r.URL.Query().Get("drop_updated") == "true"
+
+	if isDryRun(r) {
+		rollback, err := updates.PlanModRollback(mapName, dropUpdated)
+		if err != nil {
+			modError(w, r, mapName, err)
+			return
+		}
+		respondPlan(w, rollback.Plan())
+		return
+	}
+
+	rollback, err := updates.RollbackMods(mapName, dropUpdated)
+	if err != nil {
+		modError(w, r, mapName, err)
+		return
+	}
+	logf(r, "Rolled the mods of %s back to %v", mapName, rollback.Mods)
+	respondOK(w, map[string]interface{}{"status": "Mods rolled back, the server loads them when it starts next", "rollback": rollback})
+}
+
+// modError responds with the error of a mod lock request
+func modError(w http.ResponseWriter, r *http.Request, mapName string, err error) {
+	switch {
+	case errors.Is(err, processmanager.ErrMapNotFound), errors.Is(err, updater.ErrNoModLock):
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, updater.ErrModsDisabled):
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+	case errors.Is(err, processmanager.ErrInvalidLaunch):
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	case errors.Is(err, curseforge.ErrUnauthorized):
+		respondError(w, http.StatusBadGateway, ErrCodeBadGateway, err.Error())
+	default:
+		logf(r, "Failed to handle the mods of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"asa_servermanager_api/motd"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MOTDUpdate is the body of PUT /motd
+type MOTDUpdate struct {
+	Message  string `json:"message"`
+	Duration int    `json:"duration,omitempty"`
+}
+
+// DynamicUpdate is the body of PATCH /motd/dynamic, an empty value removes
+// the setting
+type DynamicUpdate struct {
+	Values map[string]string `json:"values"`
+}
+
+// ApplyResult tells how a change reached the server
+type ApplyResult struct {
+	// Reloaded is set when the running server applied the change over RCON
+	Reloaded bool `json:"reloaded"`
+	// RestartRequired is set when the change only takes effect on the next
+	// start of a running server
+	RestartRequired bool   `json:"restart_required"`
+	Error           string `json:"error,omitempty"`
+}
+
+func isRunning(mapName string) bool {
+	ms, ok := processes.State(mapName)
+	return ok && ms.Actual == processmanager.ActualRunning
+}
+
+// usesDynamicConfig reports whether the server is started with a dynamic
+// config, without -UseDynamicConfig ForceUpdateDynamicConfig does nothing
+func usesDynamicConfig(config processmanager.ProcessConfig) bool {
+	for _, arg := range config.CommandArgs() {
+		if strings.EqualFold(arg, "-UseDynamicConfig") {
+			return true
+		}
+	}
+	return false
+}
+
+func motdError(w http.ResponseWriter, err error) {
+	if errors.Is(err, motd.ErrInvalidValue) {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	log.Printf("Failed to update config: %v", err)
+	respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to update the config")
+}
+
+func GetMOTD(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	config, ok := processes.Config(mapName)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no process configuration for map "+mapName)
+		return
+	}
+	current, err := motd.ReadMOTD(config.IniDir())
+	if err != nil {
+		logf(r, "Failed to read MOTD of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the MOTD")
+		return
+	}
+	history, err := motd.History(mapName)
+	if err != nil {
+		logf(r, "Failed to read config history of %s: %v", mapName, err)
+	}
+
+	respondOK(w, map[string]interface{}{"map": mapName, "motd": current, "history": history})
+}
+
+// SetMOTD writes the MOTD to GameUserSettings.ini and sets it on the running
+// server
+func SetMOTD(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	config, ok := processes.Config(mapName)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no process configuration for map "+mapName)
+		return
+	}
+
+	var update MOTDUpdate
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	previous, err := motd.WriteMOTD(mapName, config.IniDir(), motd.MOTD{Message: update.Message, Duration: update.Duration}, caller.Name)
+	if err != nil {
+		motdError(w, err)
+		return
+	}
+
+	var result ApplyResult
+	if isRunning(mapName) {
+		// SetMessageOfTheDay changes the message but not its duration
+		if _, err := rcon.ExecuteAs(caller, mapName, "SetMessageOfTheDay "+update.Message); err != nil {
+			result.Error = err.Error()
+			result.RestartRequired = true
+		} else {
+			result.Reloaded = true
+			result.RestartRequired = update.Duration > 0 && update.Duration != previous.Duration
+		}
+	}
+
+	respondOK(w, map[string]interface{}{"status": "MOTD updated", "map": mapName, "previous": previous, "result": result})
+}
+
+func GetDynamicConfig(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	config, ok := processes.Config(mapName)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no process configuration for map "+mapName)
+		return
+	}
+	values, err := motd.ReadDynamic(mapName)
+	if err != nil {
+		logf(r, "Failed to read dynamic config of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the dynamic config")
+		return
+	}
+	history, err := motd.History(mapName)
+	if err != nil {
+		logf(r, "Failed to read config history of %s: %v", mapName, err)
+	}
+
+	respondOK(w, map[string]interface{}{
+		"map":                mapName,
+		"values":             values,
+		"keys":               motd.DynamicKeys(),
+		"use_dynamic_config": usesDynamicConfig(config),
+		"history":            history,
+	})
+}
+
+// PatchDynamicConfig changes dynamic config values and makes the running
+// server fetch them again
+func PatchDynamicConfig(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	config, ok := processes.Config(mapName)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no process configuration for map "+mapName)
+		return
+	}
+
+	var update DynamicUpdate
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if len(update.Values) == 0 {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "values must not be empty")
+		return
+	}
+
+	previous, err := motd.WriteDynamic(mapName, update.Values, caller.Name)
+	if err != nil {
+		motdError(w, err)
+		return
+	}
+
+	var result ApplyResult
+	switch {
+	case !usesDynamicConfig(config):
+		// The server only reads a dynamic config when started with
+		// -UseDynamicConfig and a customdynamicconfigurl
+		result.RestartRequired = true
+	case isRunning(mapName):
+		if _, err := rcon.ExecuteAs(caller, mapName, "ForceUpdateDynamicConfig"); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Reloaded = true
+		}
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Dynamic config updated", "map": mapName, "previous": previous, "result": result})
+}
+
+// ServeDynamicConfig serves a map's dynamic config as plain text, point the
+// server's customdynamicconfigurl option at /dynamicconfig/<map>
+func ServeDynamicConfig(w http.ResponseWriter, r *http.Request) {
+	mapName := r.PathValue("map")
+
+	data, err := os.ReadFile(motd.DynamicPath(mapName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logf(r, "Failed to read dynamic config of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the dynamic config")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Swagger UI assets in swagger/ are served from /docs/. They are copied
+// from the swagger-ui dist of github.com/swaggo/files by go generate,
+// swagger/LICENSE is the Apache 2.0 license they are distributed under.
+//go:generate sh -c "go mod download github.com/swaggo/files/v2@v2.0.2 && cp $(go env GOMODCACHE)/github.com/swaggo/files/v2@v2.0.2/dist/swagger-ui.css $(go env GOMODCACHE)/github.com/swaggo/files/v2@v2.0.2/dist/swagger-ui-bundle.js swagger/"
+
+//go:embed swagger
+var swaggerUI embed.FS
+
+const apiVersion = "1.0.0"
+
+// buildOpenAPISpec generates an OpenAPI 3 document from the route table
+func buildOpenAPISpec(routes []route) map[string]interface{} {
+	paths := make(map[string]interface{})
+	for _, rt := range routes {
+		if rt.Unversioned {
+			continue
+		}
+		params := make([]interface{}, 0, len(rt.Params))
+		for _, p := range rt.Params {
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          paramLocation(p),
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      map[string]interface{}{"type": p.Type},
+			})
+		}
+
+		properties := map[string]interface{}{"success": map[string]interface{}{"type": "boolean"}}
+		for field, sample := range rt.Response {
+			properties[field] = schemaFor(reflect.TypeOf(sample))
+		}
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "Success",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"type": "object", "properties": properties},
+					},
+				},
+			},
+			"429": errorResponse("Rate limit exceeded"),
+			"500": errorResponse("Internal error"),
+		}
+		for _, p := range rt.Params {
+			responses["400"] = errorResponse("A parameter is missing or invalid")
+			if p.Name == "map" || p.Name == "cluster" {
+				responses["404"] = errorResponse("The " + p.Name + " is unknown")
+			}
+		}
+		if rt.Role != "" {
+			responses["401"] = errorResponse("The API key or session is invalid")
+			responses["403"] = errorResponse("The caller's role is below " + rt.Role + " or the user may not access the map")
+		}
+		for code, description := range rt.Errors {
+			responses[strconv.Itoa(code)] = errorResponse(description)
+		}
+
+		operation := map[string]interface{}{
+			"tags":       []string{rt.Tag},
+			"summary":    rt.Summary,
+			"parameters": params,
+			"responses":  responses,
+		}
+		if rt.Successor != "" {
+			operation["deprecated"] = true
+			operation["description"] = "Kept for compatibility, use " + rt.Successor
+		}
+		if rt.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaFor(reflect.TypeOf(rt.Body))},
+				},
+			}
+			responses["400"] = errorResponse("The request body is invalid")
+		}
+
+		// OpenAPI has no multi-segment wildcards
+		path := strings.ReplaceAll(rt.Path, "...}", "}")
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(rt.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "ASA Server Manager API",
+			"description": "Manage ARK: Survival Ascended server processes, backups and RCON",
+			"version":     apiVersion,
+		},
+		"paths": paths,
+	}
+}
+
+func paramLocation(p param) string {
+	if p.In == "" {
+		return "query"
+	}
+	return p.In
+}
+
+// errorResponse describes the error envelope written by respondError
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"success": map[string]interface{}{"type": "boolean"},
+						"error":   schemaFor(reflect.TypeOf(apiError{})),
+					},
+					"required": []string{"success", "error"},
+				},
+			},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor derives a JSON schema from a Go type following encoding/json rules
+func schemaFor(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+				// encoding/json flattens embedded structs into the parent
+				embedded := schemaFor(f.Type)
+				for k, v := range embedded["properties"].(map[string]interface{}) {
+					properties[k] = v
+				}
+				if fields, ok := embedded["required"].([]string); ok {
+					required = append(required, fields...)
+				}
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = schemaFor(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+func OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(buildOpenAPISpec(apiRoutes())); err != nil {
+		logf(r, "Failed to write OpenAPI spec: %v", err)
+	}
+}
+
+// SwaggerDocs serves the Swagger UI page at /docs and its assets below
+// /docs/
+func SwaggerDocs(w http.ResponseWriter, r *http.Request) {
+	assets, err := fs.Sub(swaggerUI, "swagger")
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if r.URL.Path != "/docs" && r.URL.Path != "/docs/" {
+		http.StripPrefix("/docs/", http.FileServer(http.FS(assets))).ServeHTTP(w, r)
+		return
+	}
+
+	http.ServeFileFS(w, r, assets, "index.html")
+}
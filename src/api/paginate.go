@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// List endpoints return a page of their items. page counts from 1,
+// per_page defaults to 50 and sort names a field of the items, descending
+// with a leading "-". Filters are query parameters named after the field
+// they match. The number of matching items is sent in X-Total-Count.
+const (
+	defaultPerPage   = 50
+	maxPerPage       = 500
+	totalCountHeader = "X-Total-Count"
+)
+
+// pageInfo describes the page of a list response
+type pageInfo struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+	Pages   int `json:"pages"`
+}
+
+// sortKeys compares the items of a list by each field it can be sorted by
+type sortKeys[T any] map[string]func(a, b T) int
+
+func (k sortKeys[T]) names() []string {
+	names := make([]string, 0, len(k))
+	for name := range k {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (k sortKeys[T]) validate(value string) error {
+	if _, ok := k[strings.TrimPrefix(value, "-")]; !ok {
+		return fmt.Errorf("must be one of %s, optionally prefixed with -", strings.Join(k.names(), ", "))
+	}
+	return nil
+}
+
+func validatePerPage(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n <= 0 || n > maxPerPage {
+		return errors.New("must be a whole number from 1 to " + strconv.Itoa(maxPerPage))
+	}
+	return nil
+}
+
+// listParams returns the paging and sort parameters of a list route
+func listParams[T any](keys sortKeys[T], defaultSort string) []param {
+	return []param{
+		{Name: "page", Description: "Page to return, starting at 1", Type: "integer", Validate: validatePositiveInt},
+		{Name: "per_page", Description: fmt.Sprintf("Items per page, default %d, at most %d", defaultPerPage, maxPerPage), Type: "integer", Validate: validatePerPage},
+		{Name: "sort", Description: fmt.Sprintf("Field to sort by: %s. A leading - sorts in descending order, default %s", strings.Join(keys.names(), ", "), defaultSort), Type: "string", Validate: keys.validate},
+	}
+}
+
+// listPage sorts items as the request asks and returns the requested page
+// of them. It sets X-Total-Count and links the first, previous, next and
+// last pages in the Link header.
+func listPage[T any](w http.ResponseWriter, r *http.Request, items []T, keys sortKeys[T], defaultSort string) ([]T, pageInfo) {
+	query := r.URL.Query()
+	// The validators checked the values
+	info := pageInfo{Page: 1, PerPage: defaultPerPage, Total: len(items)}
+	if page, err := strconv.Atoi(query.Get("page")); err == nil {
+		info.Page = page
+	}
+	if perPage, err := strconv.Atoi(query.Get("per_page")); err == nil {
+		info.PerPage = perPage
+	}
+	info.Pages = (info.Total + info.PerPage - 1) / info.PerPage
+
+	order := query.Get("sort")
+	if order == "" {
+		order = defaultSort
+	}
+	name, descending := strings.CutPrefix(order, "-")
+	if compare, ok := keys[name]; ok {
+		items = slices.Clone(items)
+		slices.SortStableFunc(items, func(a, b T) int {
+			if descending {
+				return compare(b, a)
+			}
+			return compare(a, b)
+		})
+	}
+
+	w.Header().Set(totalCountHeader, strconv.Itoa(info.Total))
+	link := func(page int, rel string) {
+		query.Set("page", strconv.Itoa(page))
+		w.Header().Add("Link", fmt.Sprintf("<%s?%s>; rel=%q", r.URL.Path, query.Encode(), rel))
+	}
+	if info.Pages > 0 {
+		link(1, "first")
+		if info.Page > 1 {
+			link(min(info.Page-1, info.Pages), "prev")
+		}
+		if info.Page < info.Pages {
+			link(info.Page+1, "next")
+		}
+		link(info.Pages, "last")
+	}
+
+	start := min((info.Page-1)*info.PerPage, len(items))
+	end := min(start+info.PerPage, len(items))
+	return items[start:end], info
+}
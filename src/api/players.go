@@ -0,0 +1,130 @@
+package api
+
+import (
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/savegame"
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	playerFileParam = param{Name: "file", Description: "Name of an .arkprofile or .arktribe file", Required: true, Type: "string", Validate: validatePlayerFile}
+
+	playerFileSort = sortKeys[savegame.PlayerFile]{
+		"name":     func(a, b savegame.PlayerFile) int { return strings.Compare(a.Name, b.Name) },
+		"kind":     func(a, b savegame.PlayerFile) int { return strings.Compare(a.Kind, b.Kind) },
+		"owner_id": func(a, b savegame.PlayerFile) int { return strings.Compare(a.OwnerID, b.OwnerID) },
+		"size":     func(a, b savegame.PlayerFile) int { return cmp.Compare(a.Size, b.Size) },
+		"mod_time": func(a, b savegame.PlayerFile) int { return a.ModTime.Compare(b.ModTime) },
+	}
+)
+
+func validatePlayerFile(value string) error {
+	_, err := savegame.PlayerFileKind(value)
+	return err
+}
+
+func validatePlayerFileKind(value string) error {
+	if value != savegame.KindProfile && value != savegame.KindTribe {
+		return fmt.Errorf("kind must be %s or %s", savegame.KindProfile, savegame.KindTribe)
+	}
+	return nil
+}
+
+func ListPlayerFiles(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	kind := r.URL.Query().Get("kind")
+	owner := r.URL.Query().Get("owner_id")
+
+	dir, ok := saveDir(mapName)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no backup or launch configuration for map "+mapName)
+		return
+	}
+	files, err := savegame.ListPlayerFiles(dir, kind)
+	if err != nil {
+		logf(r, "Failed to list player files of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to list the player files")
+		return
+	}
+	if owner != "" {
+		owned := files[:0]
+		for _, file := range files {
+			if file.OwnerID == owner {
+				owned = append(owned, file)
+			}
+		}
+		files = owned
+	}
+
+	page, info := listPage(w, r, files, playerFileSort, "-mod_time")
+	respondOK(w, map[string]interface{}{"map": mapName, "files": page, "pagination": info})
+}
+
+// DownloadPlayerFile sends a profile or tribe file as an attachment
+func DownloadPlayerFile(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	name := r.URL.Query().Get("file")
+
+	dir, ok := saveDir(mapName)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no backup or launch configuration for map "+mapName)
+		return
+	}
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, name+" does not exist")
+			return
+		}
+		logf(r, "Failed to open %s of %s: %v", name, mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read "+name)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read "+name)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+// RestorePlayerFile restores one profile or tribe file from a backup, the
+// world save and every other file are left alone. The server keeps these
+// files in memory and writes them on save, so the map must be stopped.
+func RestorePlayerFile(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	mapName := r.URL.Query().Get("map")
+	zipName := r.URL.Query().Get("zip")
+	name := r.URL.Query().Get("file")
+
+	if isRunning(mapName) {
+		respondError(w, http.StatusConflict, ErrCodeConflict, "map "+mapName+" is running, stop it before restoring player files")
+		return
+	}
+
+	logf(r, "Restoring player file %s from zip %s in map %s", name, zipName, mapName)
+	restored, err := backups.RestoreBackup(mapName, zipName, name)
+	if err != nil {
+		logf(r, "Failed to restore %s from %s for map %s: %v", name, zipName, mapName, err)
+		if errors.Is(err, backup.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusConflict, conflictCode(err), err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Player file restored", "map": mapName, "files": restored})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/provision"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var (
+	provision_conf = "config/provision_config.json"
+
+	provisioner *provision.Provisioner
+)
+
+func ProvisionServer(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req provision.Request
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if validateMapName(req.Name) == nil {
+		respondError(w, http.StatusConflict, ErrCodeConflict, "map "+req.Name+" is already registered")
+		return
+	}
+
+	job, err := provisioner.Provision(req)
+	if err != nil {
+		logf(r, "Failed to start provisioning of %s: %v", req.Name, err)
+		switch {
+		case errors.Is(err, provision.ErrInvalidRequest), errors.Is(err, processmanager.ErrInvalidLaunch):
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		case errors.Is(err, processmanager.ErrMapExists), errors.Is(err, processmanager.ErrPortConflict):
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Provisioning started", "job": job})
+}
+
+func ProvisionStatus(w http.ResponseWriter, r *http.Request) {
+	jobID := r.URL.Query().Get("job")
+
+	job, ok := provisioner.Job(jobID)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "provisioning job "+jobID+" not found")
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Provisioning status retrieved", "job": job})
+}
+
+func CloneMap(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req provision.CloneRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if validateMapName(req.Name) == nil {
+		respondError(w, http.StatusConflict, ErrCodeConflict, "map "+req.Name+" is already registered")
+		return
+	}
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	if !scope.allows(req.Source) {
+		respondError(w, http.StatusForbidden, ErrCodeForbidden, "no access to map "+req.Source)
+		return
+	}
+
+	job, err := provisioner.Clone(req)
+	if err != nil {
+		logf(r, "Failed to start cloning %s into %s: %v", req.Source, req.Name, err)
+		switch {
+		case errors.Is(err, provision.ErrInvalidRequest), errors.Is(err, processmanager.ErrInvalidLaunch):
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		case errors.Is(err, provision.ErrSourceNotFound), errors.Is(err, backup.ErrMapNotConfigured), errors.Is(err, backup.ErrNoRestorePoint):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, processmanager.ErrMapExists), errors.Is(err, processmanager.ErrPortConflict):
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"status": "Cloning started", "job": job})
+}
+
+func AdoptMap(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req provision.AdoptRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if validateMapName(req.Name) == nil {
+		respondError(w, http.StatusConflict, ErrCodeConflict, "map "+req.Name+" is already registered")
+		return
+	}
+
+	var adoption provision.Adoption
+	var err error
+	if isDryRun(r) {
+		adoption, err = provisioner.PlanAdoption(req)
+	} else {
+		adoption, err = provisioner.Adopt(req)
+	}
+	if err != nil {
+		logf(r, "Failed to adopt %s as %s: %v", req.Dir, req.Name, err)
+		switch {
+		case errors.Is(err, provision.ErrInvalidRequest), errors.Is(err, processmanager.ErrInvalidLaunch):
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		case errors.Is(err, provision.ErrInstallNotFound):
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		case errors.Is(err, processmanager.ErrMapExists), errors.Is(err, processmanager.ErrPortConflict):
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+	if isDryRun(r) {
+		respondOK(w, map[string]interface{}{"status": "Dry run, nothing was changed", "dry_run": true, "plan": adoption.Plan(), "adoption": adoption})
+		return
+	}
+
+	logf(r, "Adopted %s as map %s", adoption.Dir, req.Name)
+	respondOK(w, map[string]interface{}{"status": "Map adopted", "map": req.Name, "adoption": adoption, "warnings": adoption.Warnings})
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/agent"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+
+	// clients idle for longer than this are forgotten
+	clientIdleTimeout = 10 * time.Minute
+)
+
+// RateLimitConfig configures the per-client token buckets. Clients are
+// identified by IP address, or by API key when an override names the key.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+
+	// Exempt lists IPs, CIDR ranges or API keys that are never limited,
+	// e.g. a dashboard running on the same machine
+	Exempt []string `json:"exempt"`
+
+	// Overrides give single clients, matched like Exempt, their own limits
+	Overrides []RateLimitOverride `json:"overrides"`
+
+	// TrustProxyHeaders takes the client IP from X-Forwarded-For, only
+	// enable it behind a reverse proxy
+	TrustProxyHeaders bool `json:"trust_proxy_headers"`
+}
+
+type RateLimitOverride struct {
+	Match             string  `json:"match"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+func defaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{RequestsPerSecond: 1, Burst: 10}
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type clientLimiter struct {
+	config  RateLimitConfig
+	mu      sync.Mutex
+	clients map[string]*clientBucket
+}
+
+func newClientLimiter(config RateLimitConfig) *clientLimiter {
+	if config.RequestsPerSecond <= 0 {
+		config.RequestsPerSecond = defaultRateLimitConfig().RequestsPerSecond
+	}
+	if config.Burst <= 0 {
+		config.Burst = defaultRateLimitConfig().Burst
+	}
+	return &clientLimiter{config: config, clients: make(map[string]*clientBucket)}
+}
+
+// matches reports whether a pattern from the config names the client
+func matches(pattern string, ip net.IP, apiKey string) bool {
+	if apiKey != "" && pattern == apiKey {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		return err == nil && network.Contains(ip)
+	}
+	if patternIP := net.ParseIP(pattern); patternIP != nil {
+		return patternIP.Equal(ip)
+	}
+	return false
+}
+
+func (cl *clientLimiter) clientIP(r *http.Request) net.IP {
+	if cl.config.TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// allow takes a token from the client's bucket. It returns false and how
+// long to wait when the bucket is empty.
+func (cl *clientLimiter) allow(r *http.Request) (bool, time.Duration) {
+	ip := cl.clientIP(r)
+	apiKey := r.Header.Get(apiKeyHeader)
+
+	for _, pattern := range cl.config.Exempt {
+		if matches(pattern, ip, apiKey) {
+			return true, 0
+		}
+	}
+
+	// Unknown API keys could be made up to get fresh buckets, so only keys
+	// named in an override get a bucket of their own
+	key := "ip:" + ip.String()
+	rps, burst := cl.config.RequestsPerSecond, cl.config.Burst
+	if t, limited := tenantRateLimit(r); limited {
+		// The callers of a tenant share its bucket
+		key = "tenant:" + t.Name
+		rps, burst = t.RateLimit.RequestsPerSecond, t.RateLimit.Burst
+	} else {
+		for _, o := range cl.config.Overrides {
+			if matches(o.Match, ip, apiKey) {
+				if o.Match == apiKey {
+					key = "key:" + apiKey
+				}
+				rps, burst = o.RequestsPerSecond, o.Burst
+				break
+			}
+		}
+	}
+
+	cl.mu.Lock()
+	bucket, ok := cl.clients[key]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+		cl.clients[key] = bucket
+	}
+	bucket.lastSeen = time.Now()
+	cl.mu.Unlock()
+
+	reservation := bucket.limiter.Reserve()
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// cleanup periodically drops the buckets of idle clients
+func (cl *clientLimiter) cleanup() {
+	for range time.Tick(time.Minute) {
+		cl.mu.Lock()
+		for key, bucket := range cl.clients {
+			if time.Since(bucket.lastSeen) > clientIdleTimeout {
+				delete(cl.clients, key)
+			}
+		}
+		cl.mu.Unlock()
+	}
+}
+
+func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Agents and their control plane talk often on behalf of many
+		// clients, the control plane already limited the original caller
+		if agentConfig.ValidToken(r.Header.Get(agent.TokenHeader)) {
+			next(w, r)
+			return
+		}
+		ok, wait := limiter.allow(r)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondError(w, http.StatusTooManyRequests, ErrCodeRateLimited, "Rate limit exceeded. Try again later.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// setupRateLimiter replaces the default limiter with one built from config
+func setupRateLimiter(config RateLimitConfig) {
+	limiter = newClientLimiter(config)
+	go limiter.cleanup()
+	log.Printf("Rate limiting clients to %.2f requests/s with burst %d, %d exemption(s)",
+		limiter.config.RequestsPerSecond, limiter.config.Burst, len(limiter.config.Exempt))
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/rcon"
+)
+
+const (
+	defaultBatchTimeout = 10 * time.Second
+	maxBatchTimeout     = 60 * time.Second
+)
+
+var (
+	errBadBatch  = errors.New("invalid batch")
+	errMapAccess = errors.New("no access to map")
+)
+
+// RconBatch runs one command on several maps at once
+type RconBatch struct {
+	Command string `json:"command"`
+	// Maps are the maps to run the command on, ["all"] is every map with an
+	// RCON configuration
+	Maps []string `json:"maps"`
+	// TimeoutSeconds bounds the wait for each map, default 10
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// RconBatchResult is the outcome of a batch command on one map
+type RconBatchResult struct {
+	Response   string `json:"response"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// batchMaps resolves the maps of a batch. Callers restricted to some maps
+// get the ones they may access for "all".
+func batchMaps(r *http.Request, requested []string) ([]string, error) {
+	scope, err := requestScope(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(requested) == 1 && requested[0] == "all" {
+		infos, err := rcon.LoadRconInfos()
+		if err != nil {
+			return nil, err
+		}
+		var maps []string
+		for _, info := range infos {
+			if scope.allows(info.Map) {
+				maps = append(maps, info.Map)
+			}
+		}
+		sort.Strings(maps)
+		return maps, nil
+	}
+
+	seen := make(map[string]bool)
+	var maps []string
+	for _, mapName := range requested {
+		if err := validateMapName(mapName); err != nil {
+			return nil, fmt.Errorf("%w: %s", errBadBatch, err)
+		}
+		if !scope.allows(mapName) {
+			return nil, fmt.Errorf("%w: %s", errMapAccess, mapName)
+		}
+		if !seen[mapName] {
+			seen[mapName] = true
+			maps = append(maps, mapName)
+		}
+	}
+	return maps, nil
+}
+
+// RconBatchCommand runs a command on several maps concurrently, each with
+// its own timeout, and reports the result per map. Maps that are stopped
+// are reported without being contacted.
+func RconBatchCommand(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	var batch RconBatch
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&batch); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if err := validateCommand(batch.Command); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+	if len(batch.Maps) == 0 {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, `maps must list at least one map or be ["all"]`)
+		return
+	}
+	timeout := defaultBatchTimeout
+	if batch.TimeoutSeconds != 0 {
+		timeout = time.Duration(batch.TimeoutSeconds) * time.Second
+		if timeout < time.Second || timeout > maxBatchTimeout {
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("timeout_seconds must be between 1 and %d", int(maxBatchTimeout.Seconds())))
+			return
+		}
+	}
+
+	maps, err := batchMaps(r, batch.Maps)
+	if err != nil {
+		switch {
+		case errors.Is(err, errBadBatch):
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		case errors.Is(err, errMapAccess):
+			respondError(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	// A command the caller may not run fails on every map, so it is refused
+	// once up front
+	perms, err := rcon.LoadPermissions()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if err := perms.Authorize(caller, batch.Command); err != nil {
+		if errors.Is(err, rcon.ErrInvalidCommand) {
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+			return
+		}
+		respondError(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+		return
+	}
+
+	results := make(map[string]RconBatchResult, len(maps))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, mapName := range maps {
+		if _, managed := processes.Config(mapName); managed && !isRunning(mapName) {
+			mu.Lock()
+			results[mapName] = RconBatchResult{Error: "the server is not running"}
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(mapName string) {
+			defer wg.Done()
+			started := time.Now()
+			response, err := rcon.ExecuteAsTimeout(caller, mapName, batch.Command, timeout)
+			result := RconBatchResult{Response: response, DurationMS: time.Since(started).Milliseconds()}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			results[mapName] = result
+			mu.Unlock()
+		}(mapName)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	logf(r, "Ran %q on %d map(s) for %s, %d failed", batch.Command, len(maps), caller.Name, failed)
+	respondOK(w, map[string]interface{}{"status": "Command executed", "command": batch.Command, "results": results, "failed": failed})
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// healthProbeTimeout bounds the probe of /rcon/health?probe=true
+const healthProbeTimeout = 5 * time.Second
+
+// RconHealth is the RCON health of a map with the state of its process and
+// what the two together suggest is wrong
+type RconHealth struct {
+	rcon.Health
+	Process   string `json:"process"`
+	Diagnosis string `json:"diagnosis"`
+}
+
+// diagnose tells a server that is down from a wrong RCON password or port,
+// going by the newest error and the map's process
+func diagnose(health rcon.Health, ms processmanager.MapState, known bool) string {
+	if len(health.RecentErrors) == 0 || (!health.LastSuccess.IsZero() && health.LastSuccess.After(health.LastFailure)) {
+		if health.LastSuccess.IsZero() {
+			return "No RCON command was sent to the server yet"
+		}
+		return "RCON is answering"
+	}
+	switch last := health.RecentErrors[0]; {
+	case last.Kind == rcon.ErrorAuthFailed:
+		return "The server rejected the RCON password, check the password in the RCON config against ServerAdminPassword"
+	case last.Kind == rcon.ErrorNoResponse:
+		return "The server took the connection but did not answer in time, it may be hanging or overloaded"
+	case !known || ms.Actual != processmanager.ActualRunning:
+		return "The server is not running"
+	case !ms.Ready():
+		return "The server is starting, RCON opens once it has loaded its world"
+	}
+	return "The server is running but RCON cannot be reached, check the RCON port and that RCON is enabled"
+}
+
+// GetRconHealth reports when a map's RCON last answered, how fast, the
+// state of its circuit breaker and its recent errors
+func GetRconHealth(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	if r.URL.Query().Get("probe") == "true" {
+		rcon.Probe(mapName, "ListPlayers", healthProbeTimeout)
+	}
+	health, err := rcon.MapHealth(mapName)
+	if err != nil {
+		if errors.Is(err, rcon.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		logf(r, "Failed to read the RCON health of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	ms, known := processes.State(mapName)
+	result := RconHealth{Health: health, Process: "unknown", Diagnosis: diagnose(health, ms, known)}
+	if known {
+		result.Process = string(ms.Actual)
+	}
+	respondOK(w, map[string]interface{}{"health": result})
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/tracing"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds IDs passed in by clients and proxies
+	maxRequestIDLength = 64
+)
+
+// AccessLogConfig controls the log line written for each request
+type AccessLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// Exclude lists paths that are not logged, e.g. /status polled by a
+	// dashboard
+	Exclude []string `json:"exclude,omitempty"`
+	// SlowMs logs requests taking longer than this many milliseconds even
+	// if access logging is off, 0 disables it. Event streams and consoles
+	// are never slow.
+	SlowMs int `json:"slow_ms,omitempty"`
+}
+
+func (c AccessLogConfig) excluded(path string) bool {
+	for _, excluded := range c.Exclude {
+		if path == excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// requestInfo is what the handlers of a request learn about it for its
+// log line and span
+type requestInfo struct {
+	ID     string
+	Route  string
+	Caller string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// logf logs a line tagged with the ID of the request it belongs to
+func logf(r *http.Request, format string, args ...interface{}) {
+	if info := requestInfoFrom(r); info != nil {
+		format = "[" + info.ID + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+// requestID returns the ID a client or proxy sent, if it is safe to log,
+// or a new one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestIDLength && strings.IndexFunc(id, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.')
+	}) < 0 {
+		return id
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestMiddleware gives every request an ID, returned in the
+// X-Request-ID header and the error envelope, traces it and writes its
+// access log line
+func requestMiddleware(config AccessLogConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{ID: requestID(r)}
+		w.Header().Set(requestIDHeader, info.ID)
+
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		ctx, span := tracing.Start(tracing.Extract(ctx, r.Header), r.Method+" "+r.URL.Path, tracing.KindServer)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		duration := time.Since(start)
+
+		if info.Route != "" {
+			span.SetName(info.Route)
+			span.SetAttribute("http.route", info.Route)
+		}
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("http.response.status_code", sw.status)
+		span.SetAttribute("request.id", info.ID)
+		if info.Caller != "" {
+			span.SetAttribute("enduser.id", info.Caller)
+		}
+		if sw.status >= http.StatusInternalServerError {
+			span.End(errors.New(http.StatusText(sw.status)))
+		} else {
+			span.End(nil)
+		}
+
+		streaming := sw.hijacked || strings.HasPrefix(sw.Header().Get("Content-Type"), "text/event-stream")
+		slow := config.SlowMs > 0 && !streaming && duration >= time.Duration(config.SlowMs)*time.Millisecond
+		if (!config.Enabled || config.excluded(r.URL.Path)) && !slow {
+			return
+		}
+		caller := info.Caller
+		if caller == "" {
+			caller = "-"
+		}
+		line := fmt.Sprintf("access request_id=%s method=%s path=%q status=%d duration_ms=%d bytes=%d caller=%q remote=%s",
+			info.ID, r.Method, r.URL.Path, sw.status, duration.Milliseconds(), sw.bytes, caller, limiter.clientIP(r))
+		if trace := span.TraceID(); trace != "" {
+			line += " trace_id=" + trace
+		}
+		if slow {
+			line += " slow=true"
+		}
+		log.Print(line)
+	})
+}
+
+// statusWriter records the status and size of a response. It passes
+// flushes and hijacks through for event streams and WebSockets.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("websockets are not supported")
+	}
+	sw.status = http.StatusSwitchingProtocols
+	sw.hijacked = true
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/secrets"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Error codes returned in the error envelope
+const (
+	ErrCodeBadRequest        = "bad_request"
+	ErrCodeUnauthorized      = "unauthorized"
+	ErrCodeTwoFactorRequired = "two_factor_required"
+	ErrCodeForbidden         = "forbidden"
+	ErrCodeNotFound          = "not_found"
+	ErrCodeMethodNotAllowed  = "method_not_allowed"
+	ErrCodeConflict          = "conflict"
+	// ErrCodeOperationInProgress is a conflict with another operation on
+	// the map, such as a restore while a backup runs
+	ErrCodeOperationInProgress = "operation_in_progress"
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeInternal            = "internal_error"
+	ErrCodeBadGateway          = "bad_gateway"
+
+	// ErrCodeQuotaExceeded is a request the quota of the map's tenant does
+	// not allow
+	ErrCodeQuotaExceeded = "quota_exceeded"
+)
+
+// apiError is the error member of a failed response
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID finds the request's lines in the log
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeJSON writes body with the known secrets redacted
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(map[string]interface{}{
+			"success": false,
+			"error":   apiError{Code: ErrCodeInternal, Message: "failed to encode response"},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(secrets.RedactBytes(data), '\n')); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// respondOK writes a successful response, the fields of body are kept at
+// the top level next to "success"
+func respondOK(w http.ResponseWriter, body map[string]interface{}) {
+	if body == nil {
+		body = make(map[string]interface{})
+	}
+	body["success"] = true
+	writeJSON(w, http.StatusOK, body)
+}
+
+// conflictCode returns the error code of a 409 response, telling maps busy
+// with another operation apart from other conflicts
+func conflictCode(err error) string {
+	if errors.Is(err, maplock.ErrBusy) {
+		return ErrCodeOperationInProgress
+	}
+	return ErrCodeConflict
+}
+
+// respondError writes the error envelope with the given status
+func respondError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"error":   apiError{Code: code, Message: message, RequestID: w.Header().Get(requestIDHeader)},
+	})
+}
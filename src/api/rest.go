@@ -0,0 +1,115 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// restRoute is the RESTful path of a query endpoint, e.g. POST
+// /maps/{map}/start for GET /start?map=. Both are served by the endpoint's
+// handler: the wildcards of the path are passed on as the query parameters
+// of the same name. The query endpoint stays for the clients using it.
+type restRoute struct {
+	Method string
+	Path   string
+	// Legacy is the query endpoint, "METHOD /path"
+	Legacy string
+}
+
+var restRoutes = []restRoute{
+	{http.MethodPost, "/maps/{map}/start", "GET /start"},
+	{http.MethodPost, "/maps/{map}/stop", "GET /stop"},
+	{http.MethodGet, "/processes", "GET /process/status"},
+	{http.MethodGet, "/maps/{map}/process", "GET /process/status"},
+	{http.MethodGet, "/maps/{map}/logs", "GET /logs"},
+	{http.MethodGet, "/maps/{map}/logs/index", "GET /logs/index"},
+	{http.MethodGet, "/maps/{map}/stats", "GET /stats"},
+	{http.MethodGet, "/maps/{map}/stats/population", "GET /stats/population"},
+	{http.MethodPost, "/maps/{map}/rcon", "GET /rcon"},
+	{http.MethodGet, "/maps/{map}/saveinfo", "GET /saveinfo"},
+
+	{http.MethodGet, "/maps/{map}/backups", "GET /list"},
+	{http.MethodPost, "/maps/{map}/backups", "GET /backup"},
+	{http.MethodPut, "/maps/{map}/backups/schedule", "GET /backupon"},
+	{http.MethodDelete, "/maps/{map}/backups/schedule", "GET /backupoff"},
+	{http.MethodPost, "/maps/{map}/backups/snapshots", "POST /backups/snapshot"},
+	{http.MethodGet, "/maps/{map}/backups/verify", "GET /backups/verify"},
+	{http.MethodPut, "/maps/{map}/backups/{zip}/tags", "PUT /backups/tags"},
+	{http.MethodGet, "/maps/{map}/backups/{zip}/preview", "GET /restore/preview"},
+	{http.MethodPost, "/maps/{map}/backups/{zip}/restore", "GET /restore"},
+	{http.MethodPost, "/maps/{map}/restore/point-in-time", "GET /restore/point-in-time"},
+	{http.MethodGet, "/backup/jobs/{job}", "GET /backup/status"},
+	{http.MethodDelete, "/backup/jobs/{job}", "GET /backup/cancel"},
+
+	{http.MethodGet, "/maps/{map}/ini/{file}", "GET /config/ini"},
+	{http.MethodPatch, "/maps/{map}/ini/{file}", "PATCH /config/ini"},
+	{http.MethodGet, "/maps/{map}/motd", "GET /motd"},
+	{http.MethodPut, "/maps/{map}/motd", "PUT /motd"},
+	{http.MethodGet, "/maps/{map}/wipe", "GET /wipe"},
+	{http.MethodPost, "/maps/{map}/wipe", "POST /wipe"},
+	{http.MethodGet, "/maps/{map}/players/files", "GET /players/files"},
+
+	{http.MethodGet, "/clusters/{cluster}", "GET /cluster/status"},
+	{http.MethodPost, "/clusters/{cluster}/restart", "GET /cluster/restart"},
+	{http.MethodPost, "/clusters/{cluster}/broadcast", "GET /cluster/broadcast"},
+	{http.MethodPost, "/clusters/{cluster}/backup", "GET /cluster/backup"},
+	{http.MethodGet, "/clusters/{cluster}/transfers", "GET /cluster/transfers"},
+}
+
+// restAliases are RESTful paths of query endpoints that stay current, e.g.
+// because they also search across maps
+var restAliases = []restRoute{
+	{http.MethodGet, "/maps/{map}/gamelog", "GET /gamelog"},
+}
+
+// withRESTRoutes adds the RESTful routes to the route table and marks the
+// query endpoints they replace. A RESTful route is a copy of its query
+// endpoint whose parameters named by the path become path parameters.
+func withRESTRoutes(routes []route) []route {
+	index := make(map[string]int, len(routes))
+	for i, rt := range routes {
+		index[rt.Method+" "+rt.Path] = i
+	}
+
+	for n, rest := range append(restRoutes, restAliases...) {
+		i, ok := index[rest.Legacy]
+		if !ok {
+			log.Fatalf("Route %s %s replaces the unknown endpoint %s", rest.Method, rest.Path, rest.Legacy)
+		}
+		legacy := routes[i]
+		if alias := n >= len(restRoutes); !alias && !legacy.Legacy {
+			routes[i].Legacy = true
+			routes[i].Successor = rest.Method + " " + rest.Path
+		}
+
+		rt := legacy
+		rt.Method, rt.Path, rt.Legacy, rt.Successor = rest.Method, rest.Path, false, ""
+		rt.Params = make([]param, 0, len(legacy.Params))
+		var names []string
+		for _, p := range legacy.Params {
+			if strings.Contains(rest.Path, "{"+p.Name+"}") {
+				p.In, p.Required = "path", true
+				names = append(names, p.Name)
+			}
+			rt.Params = append(rt.Params, p)
+		}
+		rt.Handler = pathToQuery(names, legacy.Handler)
+		routes = append(routes, rt)
+	}
+	return routes
+}
+
+// pathToQuery passes the named path values on to next as query parameters,
+// so handlers of query endpoints serve their RESTful paths unchanged
+func pathToQuery(names []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for _, name := range names {
+			query.Set(name, r.PathValue(name))
+		}
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = query.Encode()
+		next(w, r)
+	}
+}
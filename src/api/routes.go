@@ -0,0 +1,1562 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/agent"
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/bundle"
+	"asa_servermanager_api/cheats"
+	"asa_servermanager_api/cluster"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/firewall"
+	"asa_servermanager_api/gamelog"
+	"asa_servermanager_api/incidents"
+	"asa_servermanager_api/inidrift"
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/logalerts"
+	"asa_servermanager_api/macros"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/monitor"
+	"asa_servermanager_api/motd"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/provision"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/rules"
+	"asa_servermanager_api/savegame"
+	"asa_servermanager_api/selfbackup"
+	"asa_servermanager_api/tenants"
+	"asa_servermanager_api/updater"
+	"asa_servermanager_api/users"
+	"asa_servermanager_api/wipe"
+)
+
+// param is a query string parameter of a route
+type param struct {
+	Name        string
+	Description string
+	Required    bool
+	Type        string
+	// In is "query" (default) or "path". A path parameter is the wildcard
+	// of the route's path with the same name.
+	In string
+	// Validate rejects malformed values, see validateMiddleware
+	Validate func(string) error
+	// Map marks a parameter other than map that names a map, the caller
+	// has to be allowed on it too, see checkScope
+	Map bool
+}
+
+// route describes an endpoint. SetupRoutes registers the handlers from this
+// table and the OpenAPI spec is generated from it, so the two cannot drift.
+type route struct {
+	Path    string
+	Method  string
+	Tag     string
+	Summary string
+	Params  []param
+	// Response holds a sample value per field of the success body, the
+	// schema of each field is derived from the sample's Go type
+	Response map[string]interface{}
+	// Errors maps the status codes a handler can fail with to a description
+	Errors map[int]string
+	// Body is a sample of the JSON request body, nil if there is none
+	Body interface{}
+	// Role is the least role a caller needs, empty allows every caller
+	Role    string
+	Handler http.HandlerFunc
+	// Scoped routes without a map parameter are open to callers of a
+	// tenant, their handlers only show such callers the tenant's maps.
+	// Other routes without one manage the whole manager and are not.
+	Scoped bool
+	// AnyMethod serves every method, not only Method
+	AnyMethod bool
+	// Legacy marks a query endpoint replaced by a RESTful route. It is kept
+	// for compatibility and, as it always did, serves every method when it
+	// is alone on its path.
+	Legacy bool
+	// Unversioned is the copy of a /v1 route at its old path, it is left
+	// out of the OpenAPI spec
+	Unversioned bool
+	// Successor is the route, "METHOD /path", replacing a deprecated one.
+	// Responses of deprecated routes carry Deprecation, Sunset and Link
+	// headers.
+	Successor string
+}
+
+var (
+	mapParam     = param{Name: "map", Description: "Map name as configured in process_config.json", Required: true, Type: "string", Validate: validateMapName}
+	clusterParam = param{Name: "cluster", Description: "Cluster name as configured in cluster_config.json", Required: true, Type: "string", Validate: validateClusterName}
+	iniFileParam = param{Name: "file", Description: "GameUserSettings.ini or Game.ini", Required: true, Type: "string", Validate: validateIniFile}
+	mapFilter    = param{Name: "map", Description: "Only list jobs of this map", Type: "string", Validate: validateMapName}
+	archiveParam = param{Name: "zip", Description: "Archive name", Required: true, Type: "string", Validate: validateArchiveName}
+)
+
+func apiRoutes() []route {
+	return versionedRoutes(withRESTRoutes([]route{
+		{
+			Path: "/status", Method: http.MethodGet, Tag: "status",
+			Summary:  "Get manager-wide status such as whether each server is starting or ready, the maps in maintenance mode, pending server updates, when the next maintenance window opens, free disk space per volume and whether every destructive operation is a dry run",
+			Response: map[string]interface{}{"servers": []ServerStatus{}, "maintenance": []maintenance.Mode{}, "update": updater.Status{}, "disk": []backup.VolumeUsage{}, "operations": []maplock.Operation{}, "dry_run": false},
+			Handler:  GetStatus,
+			Scoped:   true,
+		},
+		{
+			Path: "/maintenance", Method: http.MethodGet, Tag: "status",
+			Summary:  "List the maps in maintenance mode and whether every map is",
+			Response: map[string]interface{}{"global": false, "maintenance": []maintenance.Mode{}},
+			Handler:  GetMaintenance,
+			Scoped:   true,
+		},
+		{
+			Path: "/maintenance", Method: http.MethodPost, Tag: "status",
+			Summary:  "Put a map, or every map, into maintenance mode: its server is not restarted automatically, update checks skip it and its backup schedule is paused until maintenance ends",
+			Params:   []param{maintenanceMapParam},
+			Body:     MaintenanceRequest{},
+			Response: map[string]interface{}{"status": "", "maintenance": maintenance.Mode{}},
+			Errors:   map[int]string{http.StatusUnauthorized: "The API key is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  StartMaintenance,
+		},
+		{
+			Path: "/maintenance", Method: http.MethodDelete, Tag: "status",
+			Summary:  "End the maintenance mode of a map, or the global one",
+			Params:   []param{maintenanceMapParam},
+			Response: map[string]interface{}{"status": "", "maintenance": maintenance.Mode{}, "warnings": []string{}},
+			Errors:   map[int]string{http.StatusUnauthorized: "The API key is unknown", http.StatusNotFound: "The map, or every map, is not in maintenance"},
+			Role:     users.RoleAdmin,
+			Handler:  EndMaintenance,
+		},
+		{
+			Path: "/start", Method: http.MethodGet, Tag: "processes",
+			Summary:  "Enable a map's server process and its backup schedule",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "map": "", "logs": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The map is unknown", http.StatusConflict: "The map is already enabled", http.StatusForbidden: "The tenant of the map already runs as many servers as its quota allows"},
+			Role:     users.RoleModerator,
+			Handler:  StartProcess,
+		},
+		{
+			Path: "/stop", Method: http.MethodGet, Tag: "processes",
+			Summary: "Disable a map's server process and shut it down over RCON. In drain mode the players are warned and, with -exclusivejoin, no one else may join; the server stops once it is empty or after the max wait, in a stop job whose result reports the players still online.",
+			Params: []param{
+				mapParam,
+				{Name: "mode", Description: "immediate (default) or drain", Type: "string", Validate: validateOneOf([]string{"immediate", "drain"})},
+				{Name: "max_wait", Description: "Seconds a drain waits for the players to leave, default the map's drain_timeout_seconds or 600", Type: "integer", Validate: validatePositiveInt},
+				{Name: "message", Description: "Message broadcast to the players during a drain", Type: "string", Validate: validateMessage},
+				dryRunParam,
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "logs": "", "job": jobs.Job{}, "dry_run": false, "plan": dryrun.Plan{}},
+			Errors: map[int]string{
+				http.StatusNotFound: "The map is unknown",
+				http.StatusConflict: "The map is not running or already draining",
+			},
+			Role:    users.RoleModerator,
+			Handler: StopProcess,
+		},
+		{
+			Path: "/process/status", Method: http.MethodGet, Tag: "processes",
+			Summary:  "Get the desired and actual state of the maps' server processes and their recent transitions",
+			Params:   []param{{Name: "map", Description: "Only return this map", Type: "string", Validate: validateMapName}},
+			Response: map[string]interface{}{"processes": []processmanager.MapState{}},
+			Handler:  ProcessStatus,
+			Scoped:   true,
+		},
+		{
+			Path: "/list", Method: http.MethodGet, Tag: "backups",
+			Summary: "List backup archives of a map, newest first, with their tags and notes",
+			Params: append([]param{
+				mapParam,
+				{Name: "file", Description: "Only list archives containing this file", Type: "string", Validate: validateFilePath},
+				{Name: "tag", Description: "Only list archives with this tag, regardless of case", Type: "string"},
+				{Name: "type", Description: "Only list full or incremental archives", Type: "string", Validate: validateOneOf([]string{backup.BackupTypeFull, backup.BackupTypeIncremental})},
+			}, listParams(archiveSort, "-created")...),
+			Response: map[string]interface{}{"map": "", "files": []string{}, "archives": []backup.Archive{}, "pagination": pageInfo{}},
+			Errors:   map[int]string{http.StatusNotFound: "The map has no backup configuration"},
+			Handler:  ListFiles,
+		},
+		{
+			Path: "/restore", Method: http.MethodGet, Tag: "backups",
+			Summary: "Restore a backup archive, or a single file from it, into the map's extract directory, a new staging directory or the extract directory of another map",
+			Params: []param{
+				mapParam,
+				archiveParam,
+				{Name: "file", Description: "Restore only this file from the archive", Type: "string", Validate: validateFilePath},
+				{Name: "dry_run", Description: "Only return the preview of /restore/preview, nothing is restored. Always on while the server config sets dry_run.", Type: "boolean", Validate: validateBool},
+				{Name: "staging", Description: "Restore into a new directory under the staging directory instead of the live save folder", Type: "boolean", Validate: validateBool},
+				{Name: "target_map", Description: "Restore into the extract directory of this map instead, its server must be stopped", Type: "string", Validate: validateMapName, Map: true},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "files": []string{}, "warnings": []string{}, "preview": backup.RestorePreview{}, "restore": backup.AlternateRestore{}},
+			Errors: map[int]string{
+				http.StatusBadRequest: "staging, target_map and dry_run were combined",
+				http.StatusNotFound:   "The map or target map has no backup configuration",
+				http.StatusConflict:   "The archive chain is missing, corrupt or could not be extracted, the target map's server is running, or another operation on the map is in progress (operation_in_progress)",
+			},
+			Role:    users.RoleAdmin,
+			Handler: RestoreFile,
+		},
+		{
+			Path: "/restore/staging", Method: http.MethodGet, Tag: "backups",
+			Summary:  "List the staging directories backups were restored into, newest first",
+			Response: map[string]interface{}{"status": "", "staged": []backup.StagedRestore{}},
+			Handler:  ListStagedRestores,
+		},
+		{
+			Path: "/restore/staging/{name}", Method: http.MethodDelete, Tag: "backups",
+			Summary: "Remove a staging directory",
+			Params: []param{
+				{Name: "name", In: "path", Description: "Staging directory name", Required: true, Type: "string", Validate: validateArchiveName},
+			},
+			Response: map[string]interface{}{"status": "", "name": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The staging directory does not exist"},
+			Role:     users.RoleAdmin,
+			Handler:  RemoveStagedRestore,
+		},
+		{
+			Path: "/restore/point-in-time", Method: http.MethodGet, Tag: "backups",
+			Summary: "Restore a map to a point in time from the newest backup taken at or before it, applying its full backup and incrementals in order",
+			Params: []param{
+				mapParam,
+				{Name: "at", Description: "RFC 3339 time to restore to", Required: true, Type: "string", Validate: validateTime},
+				{Name: "dry_run", Description: "Only resolve the archives and preview the restore, nothing is restored. Always on while the server config sets dry_run.", Type: "boolean", Validate: validateBool},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "restore": backup.PointInTimeRestore{}},
+			Errors: map[int]string{
+				http.StatusNotFound: "The map has no backup configuration or no complete backup at or before the time",
+				http.StatusConflict: "The archive chain is corrupt or could not be extracted, or another operation on the map is in progress (operation_in_progress)",
+			},
+			Role:    users.RoleAdmin,
+			Handler: RestorePointInTime,
+		},
+		{
+			Path: "/restore/preview", Method: http.MethodGet, Tag: "backups",
+			Summary: "Compare the files restoring an archive would write with the map's current files: missing, different, touched (same hash, other mtime), identical, removed and extra",
+			Params: []param{
+				mapParam,
+				archiveParam,
+				{Name: "file", Description: "Compare only this file from the archive", Type: "string", Validate: validateFilePath},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "preview": backup.RestorePreview{}},
+			Errors: map[int]string{
+				http.StatusNotFound: "The map has no backup configuration",
+				http.StatusConflict: "The archive chain is missing or could not be read",
+			},
+			Handler: PreviewRestore,
+		},
+		{
+			Path: "/backups/verify", Method: http.MethodGet, Tag: "backups",
+			Summary: "Verify the checksums of a map's backup archives. Archives from before manifests were written only have their zip checksums checked, " +
+				"they are reported as unverified (legacy) and can still be restored.",
+			Params: []param{
+				mapParam,
+				{Name: "zip", Description: "Verify only this archive", Type: "string", Validate: validateArchiveName},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "results": []backup.VerifyResult{}},
+			Errors:   map[int]string{http.StatusNotFound: "The map or archive is unknown"},
+			Handler:  VerifyBackups,
+		},
+		{
+			Path: "/backups/dedup", Method: http.MethodGet, Tag: "backups",
+			Summary:  "Describe the blob stores of dedup backups: archives, blobs, stored and logical bytes",
+			Response: map[string]interface{}{"status": "", "stores": []backup.DedupStats{}},
+			Handler:  GetDedupStats,
+		},
+		{
+			Path: "/backups/reencrypt", Method: http.MethodPost, Tag: "backups",
+			Summary:  "Encrypt a map's archives with the current backup key after a key rotation, replacing the copies on remote targets",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "result": backup.ReencryptResult{}},
+			Errors: map[int]string{
+				http.StatusNotFound:         "The map has no backup configuration",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "Backup encryption is not enabled",
+			},
+			Role:    users.RoleAdmin,
+			Handler: ReencryptBackups,
+		},
+		{
+			Path: "/backups/tags", Method: http.MethodPut, Tag: "backups",
+			Summary:  "Replace the tags and note of a backup archive. Tagged archives are never removed by retention, send no tags to release one.",
+			Params:   []param{mapParam, archiveParam},
+			Body:     BackupTags{},
+			Response: map[string]interface{}{"status": "", "map": "", "archive": backup.Archive{}},
+			Errors: map[int]string{
+				http.StatusBadRequest:       "A tag is empty, too long or contains control characters, there are too many tags or the note is too long",
+				http.StatusNotFound:         "The map has no backup configuration or the archive does not exist",
+				http.StatusMethodNotAllowed: "The request is not a PUT",
+				http.StatusConflict:         "A backup or restore of the map is in progress",
+			},
+			Role:    users.RoleModerator,
+			Handler: TagBackup,
+		},
+		{
+			Path: "/backups/retention", Method: http.MethodPost, Tag: "backups",
+			Summary:  "Apply a map's retention policy now instead of after its next backup, deleting the expired backup chains",
+			Params:   []param{mapParam, dryRunParam},
+			Response: map[string]interface{}{"status": "", "map": "", "deleted": []string{}, "dry_run": false, "plan": dryrun.Plan{}},
+			Errors: map[int]string{
+				http.StatusNotFound:         "The map has no backup configuration",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "Another operation on the map is in progress (operation_in_progress)",
+			},
+			Role:    users.RoleAdmin,
+			Handler: PruneBackups,
+		},
+		{
+			Path: "/backups/snapshot", Method: http.MethodPost, Tag: "backups",
+			Summary: "Take a safety snapshot of a map before an operation the manager does not run itself, such as a mod install. Snapshots are full backups kept for the snapshot grace period regardless of retention.",
+			Params: []param{
+				mapParam,
+				{Name: "trigger", Description: "Operation the snapshot is taken before, e.g. mod_install", Required: true, Type: "string"},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "archive": ""},
+			Errors: map[int]string{
+				http.StatusBadRequest:       "The trigger is not 1 to 32 lowercase letters, digits or underscores",
+				http.StatusNotFound:         "The map has no backup configuration",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+			},
+			Role:    users.RoleModerator,
+			Handler: SnapshotBackup,
+		},
+		{
+			Path: "/saveinfo", Method: http.MethodGet, Tag: "backups",
+			Summary:  "Describe a map's current save: world version, game time and day, save time and file sizes",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"map": "", "save": savegame.Info{}},
+			Errors:   map[int]string{http.StatusNotFound: "The map is unknown or has no save yet"},
+			Handler:  GetSaveInfo,
+		},
+		{
+			Path: "/backup", Method: http.MethodGet, Tag: "backups",
+			Summary: "Queue a manual backup of a map",
+			Params: []param{
+				mapParam,
+				{Name: "full", Description: "Take a full instead of an incremental backup", Type: "boolean", Validate: validateBool},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "job": backup.BackupJob{}},
+			Errors:   map[int]string{http.StatusNotFound: "The map has no backup configuration", http.StatusForbidden: "The quota of the map's tenant allows no backup now"},
+			Role:     users.RoleModerator,
+			Handler:  ManualBackup,
+		},
+		{
+			Path: "/backup/jobs", Method: http.MethodGet, Tag: "backups",
+			Summary:  "List recent backup jobs",
+			Params:   []param{mapFilter},
+			Response: map[string]interface{}{"status": "", "jobs": []backup.BackupJob{}},
+			Handler:  ListBackupJobs,
+			Scoped:   true,
+		},
+		{
+			Path: "/backup/status", Method: http.MethodGet, Tag: "backups",
+			Summary:  "Get a backup job, with files, bytes, percent complete and ETA while it runs and its duration and compressed size once done",
+			Params:   []param{{Name: "job", Description: "Job ID", Required: true, Type: "string"}},
+			Response: map[string]interface{}{"status": "", "job": backup.BackupJob{}},
+			Errors:   map[int]string{http.StatusNotFound: "The job is unknown"},
+			Handler:  BackupJobStatus,
+		},
+		{
+			Path: "/backup/cancel", Method: http.MethodGet, Tag: "backups",
+			Summary:  "Cancel a backup job that has not started yet",
+			Params:   []param{{Name: "job", Description: "Job ID", Required: true, Type: "string"}},
+			Response: map[string]interface{}{"status": "", "job": backup.BackupJob{}},
+			Errors:   map[int]string{http.StatusNotFound: "The job is unknown", http.StatusConflict: "The job already started"},
+			Role:     users.RoleModerator,
+			Handler:  CancelBackupJob,
+		},
+		{
+			Path: "/backupon", Method: http.MethodGet, Tag: "backups",
+			Summary:  "Turn a map's scheduled backups on",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "map": "", "next_run": time.Time{}},
+			Errors:   map[int]string{http.StatusNotFound: "The map has no backup configuration"},
+			Role:     users.RoleModerator,
+			Handler:  ScheduleBackupOn,
+		},
+		{
+			Path: "/backup/schedule", Method: http.MethodGet, Tag: "backups",
+			Summary:  "Get a map's backup schedule, its cron expression and time zone or interval, whether it is on and when it runs next",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "schedule": backup.ScheduleStatus{}},
+			Errors:   map[int]string{http.StatusNotFound: "The map has no backup configuration"},
+			Handler:  BackupSchedule,
+		},
+		{
+			Path: "/backupschedule/pause", Method: http.MethodPost, Tag: "backups",
+			Summary:  "Pause a map's backup schedule. Unlike /backupoff the schedule keeps running and its runs are recorded as skipped jobs until it is resumed.",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "schedule": backup.ScheduleStatus{}},
+			Errors: map[int]string{
+				http.StatusNotFound:         "The map has no backup configuration",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "The map's backup schedule is off",
+			},
+			Role:    users.RoleModerator,
+			Handler: PauseBackupSchedule,
+		},
+		{
+			Path: "/backupschedule/resume", Method: http.MethodPost, Tag: "backups",
+			Summary:  "Resume a paused backup schedule from its next run",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "schedule": backup.ScheduleStatus{}},
+			Errors: map[int]string{
+				http.StatusNotFound:         "The map has no backup configuration",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "The map's backup schedule is off",
+			},
+			Role:    users.RoleModerator,
+			Handler: ResumeBackupSchedule,
+		},
+		{
+			Path: "/backupoff", Method: http.MethodGet, Tag: "backups",
+			Summary:  "Turn a map's scheduled backups off",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "map": ""},
+			Errors:   map[int]string{http.StatusConflict: "The map has no running backup schedule"},
+			Role:     users.RoleModerator,
+			Handler:  ScheduleBackupOff,
+		},
+		{
+			Path: "/jobs", Method: http.MethodGet, Tag: "jobs",
+			Summary: "List recent backups, restores, updates, restarts and drain stops with their state, log and timing, newest first",
+			Params: append([]param{
+				mapFilter,
+				{Name: "type", Description: "Only list jobs of this type: backup, restore, update, restart, stop or recovery", Type: "string", Validate: validateOneOf(jobTypes)},
+				{Name: "state", Description: "Only list jobs in this state: pending, running, succeeded, failed, cancelled or skipped", Type: "string", Validate: validateOneOf(jobStates)},
+			}, listParams(jobSort, "-created")...),
+			Response: map[string]interface{}{"status": "", "jobs": []jobs.Job{}, "pagination": pageInfo{}},
+			Handler:  ListJobs,
+			Scoped:   true,
+		},
+		{
+			Path: "/jobs/{id}", Method: http.MethodGet, Tag: "jobs",
+			Summary:  "Get a job with its log",
+			Params:   []param{jobIDParam},
+			Response: map[string]interface{}{"status": "", "job": jobs.Job{}},
+			Errors:   map[int]string{http.StatusNotFound: "The job is unknown"},
+			Handler:  GetJob,
+			Scoped:   true,
+		},
+		{
+			Path: "/jobs/{id}", Method: http.MethodDelete, Tag: "jobs",
+			Summary:  "Cancel a job while it is cancellable: queued backups, updates and restarts waiting for their map and drain stops waiting for players to leave. The job stays in the history.",
+			Params:   []param{jobIDParam},
+			Response: map[string]interface{}{"status": "", "job": jobs.Job{}},
+			Errors:   map[int]string{http.StatusNotFound: "The job is unknown", http.StatusConflict: "The job has begun or finished and cannot be cancelled"},
+			Role:     users.RoleModerator,
+			Handler:  CancelJob,
+			Scoped:   true,
+		},
+		{
+			Path: "/rcon", Method: http.MethodGet, Tag: "rcon",
+			Summary: "Run an RCON command on a map's server. The responses of ListPlayers, GetChat, GetGameLog and ShowMessageOfTheDay are also returned typed, in parsed.",
+			Params: []param{
+				mapParam,
+				{Name: "command", Description: "RCON command, e.g. ListPlayers", Required: true, Type: "string", Validate: validateCommand},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "data": ""},
+			Errors: map[int]string{
+				http.StatusBadRequest:   "The command is malformed, e.g. holds | or an unbalanced quote, or its arguments do not match the command's rule",
+				http.StatusUnauthorized: "The X-API-Key header holds an unknown key",
+				http.StatusForbidden:    "The caller's role may not run the command",
+				http.StatusNotFound:     "The map has no RCON configuration",
+				http.StatusBadGateway:   "The server could not be reached or rejected the command",
+			},
+			Handler: RconComs,
+		},
+		{
+			Path: "/rcon/health", Method: http.MethodGet, Tag: "rcon",
+			Summary: "Get the RCON health of a map: when it last answered and how fast, its circuit breaker and its recent errors, with a diagnosis that tells a server that is down from a wrong password or port",
+			Params: []param{
+				mapParam,
+				{Name: "probe", Description: "Send ListPlayers first to get a fresh result", Type: "boolean", Validate: validateBool},
+			},
+			Response: map[string]interface{}{"health": RconHealth{}},
+			Errors:   map[int]string{http.StatusNotFound: "The map has no RCON configuration"},
+			Role:     users.RoleModerator,
+			Handler:  GetRconHealth,
+		},
+		{
+			Path: "/rcon/broadcast", Method: http.MethodPost, Tag: "rcon",
+			Summary:  "Run an RCON command on several maps, or all with [\"all\"], concurrently with a timeout per map, e.g. to announce maintenance everywhere at once",
+			Body:     RconBatch{},
+			Response: map[string]interface{}{"status": "", "command": "", "results": map[string]RconBatchResult{}, "failed": 0},
+			Errors: map[int]string{
+				http.StatusBadRequest:       "The command, a map or the timeout is invalid",
+				http.StatusForbidden:        "The caller's role may not run the command or the caller may not access a map",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+			},
+			Handler: RconBatchCommand,
+			Scoped:  true,
+		},
+		{
+			Path: "/cheats", Method: http.MethodGet, Tag: "rcon",
+			Summary:  "List the admin cheats moderators may run on players and how many per minute",
+			Response: map[string]interface{}{"cheats": []cheats.Cheat{}, "per_minute": 0},
+			Role:     users.RoleModerator,
+			Handler:  ListCheats,
+		},
+		{
+			Path: "/cheats", Method: http.MethodPost, Tag: "rcon",
+			Summary:  "Run one of the curated admin cheats on a player, after EnableCheats with the map's admin password. The audit log records the caller and the player.",
+			Params:   []param{mapParam},
+			Body:     cheats.Request{},
+			Response: map[string]interface{}{"status": "", "result": cheats.Result{}},
+			Errors: map[int]string{
+				http.StatusBadRequest:      "The player or a param of the cheat is invalid",
+				http.StatusUnauthorized:    "The X-API-Key header holds an unknown key",
+				http.StatusNotFound:        "The cheat is unknown or the map has no RCON configuration",
+				http.StatusTooManyRequests: "The caller ran too many cheats in the last minute",
+				http.StatusBadGateway:      "The server could not be reached or rejected the command",
+			},
+			Role:    users.RoleModerator,
+			Handler: RunCheat,
+		},
+		{
+			Path: "/audit", Method: http.MethodGet, Tag: "rcon",
+			Summary: "Page through the RCON audit log, callers of a tenant get the log of their tenant's maps",
+			Params: append([]param{
+				{Name: "map", Description: "Only list the commands sent to this map", Type: "string", Validate: validateMapName},
+			}, listParams(auditSort, "-time")...),
+			Response: map[string]interface{}{"entries": []rcon.AuditEntry{}, "pagination": pageInfo{}},
+			Errors:   map[int]string{http.StatusInternalServerError: "The audit log could not be read"},
+			Role:     users.RoleAdmin,
+			Handler:  GetAuditLog,
+			Scoped:   true,
+		},
+		{
+			Path: "/rcon/password", Method: http.MethodPost, Tag: "rcon",
+			Summary:  "Rotate a map's RCON password in the RCON config, the secrets file and ServerAdminPassword of its GameUserSettings.ini",
+			Params:   []param{mapParam},
+			Body:     PasswordRotation{},
+			Response: map[string]interface{}{"status": "", "map": "", "ini": "", "sealed": false},
+			Errors: map[int]string{
+				http.StatusNotFound:         "The map has no RCON configuration",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "The password is set by an environment variable",
+			},
+			Role:    users.RoleAdmin,
+			Handler: RotateRconPassword,
+		},
+		{
+			Path: "/rcon/sessions", Method: http.MethodPost, Tag: "rcon",
+			Summary:  "Open an RCON console session on a map: one connection kept open for a series of commands, sent as typed and answered in full however long the response",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "session": rcon.SessionInfo{}},
+			Errors: map[int]string{
+				http.StatusNotFound:   "The map has no RCON configuration",
+				http.StatusConflict:   "Too many console sessions are open",
+				http.StatusBadGateway: "The server could not be reached or rejected the password",
+			},
+			Handler: OpenConsole,
+		},
+		{
+			Path: "/rcon/sessions", Method: http.MethodGet, Tag: "rcon",
+			Summary:  "List the caller's open console sessions",
+			Response: map[string]interface{}{"sessions": []rcon.SessionInfo{}},
+			Handler:  ListConsoles,
+			Scoped:   true,
+		},
+		{
+			Path: "/rcon/sessions/{id}", Method: http.MethodGet, Tag: "rcon",
+			Summary:  "Get one of the caller's console sessions and the commands sent in it",
+			Params:   []param{consoleIDParam},
+			Response: map[string]interface{}{"session": rcon.SessionInfo{}, "history": []rcon.Exchange{}},
+			Errors:   map[int]string{http.StatusNotFound: "The session is unknown, expired or another caller's"},
+			Handler:  GetConsole,
+			Scoped:   true,
+		},
+		{
+			Path: "/rcon/sessions/{id}", Method: http.MethodPost, Tag: "rcon",
+			Summary:  "Run a command in a console session and wait for its whole response",
+			Params:   []param{consoleIDParam},
+			Body:     ConsoleCommand{},
+			Response: map[string]interface{}{"status": "", "exchange": rcon.Exchange{}},
+			Errors: map[int]string{
+				http.StatusBadRequest: "The command is blank, too long, malformed or its arguments do not match the command's rule",
+				http.StatusForbidden:  "The caller's role may not run the command",
+				http.StatusNotFound:   "The session is unknown, expired or another caller's",
+				http.StatusBadGateway: "The server could not be reached or did not answer",
+			},
+			Handler: SendConsoleCommand,
+			Scoped:  true,
+		},
+		{
+			Path: "/rcon/sessions/{id}", Method: http.MethodDelete, Tag: "rcon",
+			Summary:  "Close a console session",
+			Params:   []param{consoleIDParam},
+			Response: map[string]interface{}{"status": "", "session": rcon.SessionInfo{}},
+			Errors:   map[int]string{http.StatusNotFound: "The session is unknown, expired or another caller's"},
+			Handler:  CloseConsole,
+			Scoped:   true,
+		},
+		{
+			Path: "/rcon/console/{id}", Method: http.MethodGet, Tag: "rcon",
+			Summary:  "Attach a WebSocket to a console session. Each text message sent is a command, each message received is a ConsoleMessage: the session's history first, then a response or error per command.",
+			Params:   []param{consoleIDParam},
+			Response: map[string]interface{}{"message": ConsoleMessage{}},
+			Errors: map[int]string{
+				http.StatusBadRequest: "The request is not a WebSocket handshake or comes from another origin",
+				http.StatusNotFound:   "The session is unknown, expired or another caller's",
+			},
+			Handler: ConsoleSocket,
+			Scoped:  true,
+		},
+		{
+			Path: "/broadcast/templates", Method: http.MethodGet, Tag: "rcon",
+			Summary:  "Get the templates of the automated broadcasts, with a map's overrides applied if a map is given",
+			Params:   []param{{Name: "map", Description: "Apply the overrides of this map", Type: "string", Validate: validateMapName}},
+			Response: map[string]interface{}{"map": "", "templates": map[string]string{}, "variables": []string{}},
+			Handler:  GetBroadcastTemplates,
+			Scoped:   true,
+		},
+		{
+			Path: "/broadcast/test", Method: http.MethodPost, Tag: "rcon",
+			Summary:  "Render a broadcast template, the map's template of the event by default, and send it in-game",
+			Params:   []param{mapParam},
+			Body:     BroadcastTest{},
+			Response: map[string]interface{}{"status": "", "map": "", "event": "", "message": "", "unknown_variables": []string{}},
+			Errors: map[int]string{
+				http.StatusBadRequest: "The event is unknown or the template is invalid or empty",
+				http.StatusNotFound:   "The map has no RCON configuration",
+				http.StatusBadGateway: "The server could not be reached or rejected the broadcast",
+			},
+			Role:    users.RoleModerator,
+			Handler: TestBroadcast,
+		},
+		{
+			Path: "/logs", Method: http.MethodGet, Tag: "processes",
+			Summary:  "Get the end of a map's console output, from the current or a rotated log",
+			Params:   []param{mapParam, logFileParam, logTimeParam},
+			Response: map[string]interface{}{"status": "", "map": "", "file": "", "truncated": false, "logs": ""},
+			Handler:  GetMapLogs,
+		},
+		{
+			Path: "/logs/index", Method: http.MethodGet, Tag: "processes",
+			Summary:  "List the rotated console logs of a map",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"map": "", "current_start": time.Time{}, "segments": []processmanager.LogSegment{}},
+			Handler:  GetLogIndex,
+		},
+		{
+			Path: "/incidents", Method: http.MethodGet, Tag: "processes",
+			Summary: "List the diagnostic bundles collected when servers crashed, newest first",
+			Params: []param{
+				{Name: "map", Description: "Only list incidents of this map", Type: "string", Validate: validateMapName},
+			},
+			Response: map[string]interface{}{"incidents": []incidents.Incident{}, "collecting": false},
+			Handler:  ListIncidents,
+			Scoped:   true,
+		},
+		{
+			Path: "/incidents/download", Method: http.MethodGet, Tag: "processes",
+			Summary: "Download the zip of an incident: the end of the console output, the Saved/Logs files and the crash dumps",
+			Params: []param{mapParam,
+				{Name: "id", Description: "ID of the incident as listed by /incidents", Required: true, Type: "string"},
+			},
+			Errors:  map[int]string{http.StatusNotFound: "The map has no incident with this ID"},
+			Handler: DownloadIncident,
+		},
+		{
+			Path: "/stats", Method: http.MethodGet, Tag: "processes",
+			Summary:  "Get the CPU, memory, disk and I/O history of a map's server process and the alerts it triggered",
+			Params:   []param{mapParam, statsRangeParam},
+			Response: map[string]interface{}{"map": "", "range": "", "samples": []monitor.Sample{}, "alerts": []monitor.AlertEvent{}, "liveness": monitor.Liveness{}, "players": []rcon.Player{}},
+			Errors:   map[int]string{http.StatusNotFound: "No samples have been recorded for the map yet"},
+			Handler:  GetStats,
+		},
+		{
+			Path: "/stats/uptime", Method: http.MethodGet, Tag: "processes",
+			Summary: "Get a map's availability over a range from its recorded state changes: uptime percentage, number of outages, mean time to recover and longest outage. A map is up while its server is ready, disabled maps do not count.",
+			Params: []param{
+				mapParam,
+				{Name: "range", Description: "How far back to report, e.g. 24h or 30d (default 30d, at most 90d)", Type: "string", Validate: validateUptimeRange},
+			},
+			Response: map[string]interface{}{"uptime": processmanager.Uptime{}},
+			Handler:  GetUptime,
+		},
+		{
+			Path: "/stats/population", Method: http.MethodGet, Tag: "processes",
+			Summary: "Get a map's player count history in buckets for graphs, and its average player count per hour of the day with the quietest hour, e.g. to pick a restart or maintenance window",
+			Params: []param{
+				mapParam,
+				{Name: "range", Description: "How far back to return, e.g. 12h or 7d (default 7d)", Type: "string", Validate: validatePopulationRange},
+				{Name: "bucket", Description: "Bucket size, e.g. 15m or 1h (default 15m up to 1d, 1h up to 7d, 6h beyond)", Type: "string", Validate: validateBucket},
+			},
+			Response: map[string]interface{}{"population": monitor.Population{}},
+			Errors: map[int]string{
+				http.StatusBadRequest: "The bucket is longer than the range",
+				http.StatusNotFound:   "Population history is not enabled in monitor_config.json",
+			},
+			Handler: GetPopulation,
+		},
+		{
+			Path: "/clusters", Method: http.MethodGet, Tag: "clusters",
+			Summary:  "List clusters with the aggregated status of their maps",
+			Response: map[string]interface{}{"status": "", "clusters": []cluster.Status{}},
+			Handler:  ListClusters,
+			Scoped:   true,
+		},
+		{
+			Path: "/cluster/status", Method: http.MethodGet, Tag: "clusters",
+			Summary:  "Get the aggregated status of a cluster and its rolling restart",
+			Params:   []param{clusterParam},
+			Response: map[string]interface{}{"status": "", "cluster": cluster.Status{}},
+			Handler:  ClusterStatus,
+		},
+		{
+			Path: "/cluster/restart", Method: http.MethodGet, Tag: "clusters",
+			Summary:  "Restart the maps of a cluster one at a time",
+			Params:   []param{clusterParam},
+			Response: map[string]interface{}{"status": "", "cluster": "", "restart": cluster.RestartProgress{}},
+			Errors:   map[int]string{http.StatusConflict: "A rolling restart of the cluster is already running"},
+			Role:     users.RoleModerator,
+			Handler:  ClusterRestart,
+		},
+		{
+			Path: "/cluster/broadcast", Method: http.MethodGet, Tag: "clusters",
+			Summary: "Broadcast a message on every map of a cluster",
+			Params: []param{
+				clusterParam,
+				{Name: "message", Description: "Message to broadcast", Required: true, Type: "string", Validate: validateMessage},
+			},
+			Response: map[string]interface{}{"status": "", "cluster": "", "failed": map[string]string{}},
+			Errors:   map[int]string{http.StatusUnauthorized: "The X-API-Key header holds an unknown key"},
+			Role:     users.RoleModerator,
+			Handler:  ClusterBroadcast,
+		},
+		{
+			Path: "/cluster/backup", Method: http.MethodGet, Tag: "clusters",
+			Summary: "Queue backups of every map of a cluster and of the cluster directory",
+			Params: []param{
+				clusterParam,
+				{Name: "full", Description: "Take full instead of incremental backups", Type: "boolean", Validate: validateBool},
+			},
+			Response: map[string]interface{}{"status": "", "cluster": "", "jobs": []backup.BackupJob{}},
+			Role:     users.RoleModerator,
+			Handler:  ClusterBackup,
+		},
+		{
+			Path: "/cluster/transfers", Method: http.MethodGet, Tag: "clusters",
+			Summary: "List the pending character and item transfers of a cluster, flag stuck or corrupt ones and list the backups to recover them from",
+			Params: []param{
+				clusterParam,
+				{Name: "player", Description: "Only list transfers of this EOS ID", Type: "string"},
+			},
+			Response: map[string]interface{}{"status": "", "transfers": cluster.TransferReport{}},
+			Errors:   map[int]string{http.StatusNotFound: "The cluster is unknown or has no cluster_dir"},
+			Handler:  ClusterTransfers,
+		},
+		{
+			Path: "/cluster/transfers/restore", Method: http.MethodPost, Tag: "clusters",
+			Summary: "Restore a single transfer file from a backup of the cluster directory",
+			Params: []param{
+				clusterParam,
+				archiveParam,
+				{Name: "file", Description: "Transfer file relative to the cluster directory", Required: true, Type: "string", Validate: validateFilePath},
+			},
+			Response: map[string]interface{}{"status": "", "cluster": "", "files": []string{}},
+			Errors: map[int]string{
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "The cluster has no directory backup or the file is not in the archive chain, or another operation on the map is in progress",
+			},
+			Role:    users.RoleAdmin,
+			Handler: RestoreClusterTransfer,
+		},
+		{
+			Path: "/maps", Method: http.MethodPost, Tag: "maps",
+			Summary:  "Register a new server instance and persist its process, RCON and backup configuration",
+			Body:     MapRegistration{},
+			Response: map[string]interface{}{"status": "", "map": "", "warnings": []string{}},
+			Errors: map[int]string{
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "A map with this name is already registered",
+			},
+			Role:    users.RoleAdmin,
+			Handler: RegisterMap,
+		},
+		{
+			Path: "/maps/{name}", Method: http.MethodDelete, Tag: "maps",
+			Summary: "Unregister a stopped server instance, its backup archives are kept",
+			Params: []param{
+				{Name: "name", In: "path", Description: "Map name", Required: true, Type: "string", Validate: validateMapName, Map: true},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "warnings": []string{}},
+			Errors: map[int]string{
+				http.StatusNotFound:         "The map is unknown",
+				http.StatusMethodNotAllowed: "The request is not a DELETE",
+				http.StatusConflict:         "The map is still running",
+			},
+			Role:    users.RoleAdmin,
+			Handler: UnregisterMap,
+		},
+		{
+			Path: "/ports", Method: http.MethodGet, Tag: "maps",
+			Summary:  "List the game, query and RCON ports of every map and whether another program on the host holds them",
+			Response: map[string]interface{}{"ports": []processmanager.PortStatus{}, "conflicts": 0},
+			Handler:  GetPorts,
+			Scoped:   true,
+		},
+		{
+			Path: "/firewall", Method: http.MethodGet, Tag: "maps",
+			Summary:  "List the firewall rules the manager created for each map",
+			Response: map[string]interface{}{"enabled": false, "rules": map[string][]firewall.Rule{}},
+			Role:     users.RoleAdmin,
+			Handler:  GetFirewall,
+		},
+		{
+			Path: "/firewall/{name}", Method: http.MethodPost, Tag: "maps",
+			Summary: "Create or update the firewall rules of a map's game and query ports, and its RCON port if allowed",
+			Params: []param{
+				{Name: "name", In: "path", Description: "Map name", Required: true, Type: "string", Validate: validateMapName, Map: true},
+				{Name: "dry_run", Description: "Only return the commands, nothing is changed. Always on while the server config sets dry_run.", Type: "boolean", Validate: validateBool},
+			},
+			Response: map[string]interface{}{"status": "", "result": firewall.Result{}},
+			Errors: map[int]string{
+				http.StatusNotFound:         "The map is unknown",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "Firewall management is not enabled and dry_run is not set",
+			},
+			Role:    users.RoleAdmin,
+			Handler: SyncFirewall,
+		},
+		{
+			Path: "/provision", Method: http.MethodPost, Tag: "maps",
+			Summary:  "Create a new server instance: directories, SteamCMD download, ini files and registration. Ports left out are assigned from the configured ranges.",
+			Body:     provision.Request{},
+			Response: map[string]interface{}{"status": "", "job": provision.Job{}},
+			Errors: map[int]string{
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "A map with this name is already registered or being provisioned, a port is taken or no free port is left in a range",
+			},
+			Role:    users.RoleAdmin,
+			Handler: ProvisionServer,
+		},
+		{
+			Path: "/provision/status", Method: http.MethodGet, Tag: "maps",
+			Summary:  "Get the progress of a provisioning job",
+			Params:   []param{{Name: "job", Description: "Job ID", Required: true, Type: "string"}},
+			Response: map[string]interface{}{"status": "", "job": provision.Job{}},
+			Errors:   map[int]string{http.StatusNotFound: "The job is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  ProvisionStatus,
+		},
+		{
+			Path: "/maps/clone", Method: http.MethodPost, Tag: "maps",
+			Summary:  "Create a new server instance from a backup of another map: the save is restored, the source's ini files are copied with session name, passwords and ports rewritten, and the instance is registered and optionally started. Track it with /provision/status.",
+			Body:     provision.CloneRequest{},
+			Response: map[string]interface{}{"status": "", "job": provision.Job{}},
+			Errors: map[int]string{
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusForbidden:        "The caller may not access the source map",
+				http.StatusNotFound:         "The source map or the backup is unknown, or the source has no backups",
+				http.StatusConflict:         "A map with this name is already registered or being provisioned, a port is taken or no free port is left in a range",
+			},
+			Role:    users.RoleAdmin,
+			Handler: CloneMap,
+		},
+		{
+			Path: "/maps/adopt", Method: http.MethodPost, Tag: "maps",
+			Summary:  "Bring an existing, hand-managed server installation under management: the map, ports, session name and RCON settings are detected from its start script, INI files and saves, and process, RCON and backup config entries are created. The installation is not changed and the server is not started.",
+			Params:   []param{{Name: "dry_run", Description: "Only return the detected settings and the config entries, nothing is registered. Always on while the server config sets dry_run.", Type: "boolean", Validate: validateBool}},
+			Body:     provision.AdoptRequest{},
+			Response: map[string]interface{}{"status": "", "map": "", "adoption": provision.Adoption{}, "warnings": []string{}, "plan": dryrun.Plan{}},
+			Errors: map[int]string{
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusNotFound:         "No server installation was found in the directory",
+				http.StatusConflict:         "A map with this name is already registered or being provisioned, the installation is already managed, or a port is used by another map",
+			},
+			Role:    users.RoleAdmin,
+			Handler: AdoptMap,
+		},
+		{
+			Path: "/config/ini", Method: http.MethodGet, Tag: "config",
+			Summary: "Read the settings of a map's GameUserSettings.ini or Game.ini, passwords are masked",
+			Params: []param{
+				mapParam,
+				iniFileParam,
+				{Name: "section", Description: "Only return this section", Type: "string"},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "file": "", "sections": []IniSection{}},
+			Handler:  GetIni,
+		},
+		{
+			Path: "/config/ini", Method: http.MethodPatch, Tag: "config",
+			Summary:  "Change settings of a map's INI file, the previous file is backed up first",
+			Params:   []param{mapParam, iniFileParam},
+			Body:     IniPatch{},
+			Response: map[string]interface{}{"status": "", "map": "", "file": "", "backup": "", "snapshot": "", "unknown_keys": []string{}},
+			Role:     users.RoleAdmin,
+			Handler:  PatchIni,
+		},
+		{
+			Path: "/config/ini/drift", Method: http.MethodGet, Tag: "config",
+			Summary:  "List the INI files changed outside the manager with a diff against their known-good baseline",
+			Params:   []param{{Name: "map", Description: "Only list drifts of this map", Type: "string", Validate: validateMapName}},
+			Response: map[string]interface{}{"drifts": []inidrift.Drift{}, "watching": false},
+			Handler:  GetIniDrift,
+		},
+		{
+			Path: "/config/ini/drift/check", Method: http.MethodPost, Tag: "config",
+			Summary:  "Compare a map's INI files with their baselines now",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "map": "", "drifts": []inidrift.Drift{}},
+			Role:     users.RoleModerator,
+			Handler:  CheckIniDrift,
+		},
+		{
+			Path: "/config/ini/drift/accept", Method: http.MethodPost, Tag: "config",
+			Summary:  "Accept the current content of a map's INI file as its known-good baseline",
+			Params:   []param{mapParam, iniFileParam},
+			Response: map[string]interface{}{"status": "", "map": "", "file": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The map is unknown or the file does not exist"},
+			Role:     users.RoleAdmin,
+			Handler:  AcceptIniDrift,
+		},
+		{
+			Path: "/config/ini/backups", Method: http.MethodGet, Tag: "config",
+			Summary:  "List the backups of a map's INI file and when its baseline was recorded",
+			Params:   []param{mapParam, iniFileParam},
+			Response: map[string]interface{}{"map": "", "file": "", "backups": []IniBackup{}, "baseline": map[string]interface{}{}},
+			Role:     users.RoleAdmin,
+			Handler:  ListIniBackups,
+		},
+		{
+			Path: "/config/ini/revert", Method: http.MethodPost, Tag: "config",
+			Summary: "Write the known-good baseline or a backup of a map's INI file back, the current file is backed up first",
+			Params: []param{
+				mapParam,
+				iniFileParam,
+				{Name: "backup", Description: "Backup to restore, default the baseline", Type: "string", Validate: validateIniBackup},
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "file": "", "reverted_to": "", "backup": "", "snapshot": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The map, its baseline or the backup is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  RevertIni,
+		},
+		{
+			Path: "/config/export", Method: http.MethodGet, Tag: "config",
+			Summary: "Download the config files, users, backup schedules, enabled servers, maintenance modes and INI baselines as one bundle, to move the manager or recover it. The bundle holds API keys and RCON passwords.",
+			Errors:  map[int]string{http.StatusInternalServerError: "A config file or the state could not be read"},
+			Role:    users.RoleAdmin,
+			Handler: ExportConfig,
+		},
+		{
+			Path: "/config/import", Method: http.MethodPost, Tag: "config",
+			Summary:  "Validate a bundle from /config/export and apply it, after saving the current configuration to data/config_backups. The config files take effect once the manager is restarted.",
+			Params:   []param{dryRunParam},
+			Body:     bundle.Bundle{},
+			Response: map[string]interface{}{"status": "", "result": bundle.Result{}},
+			Errors: map[int]string{
+				http.StatusBadRequest:       "The bundle is invalid, e.g. of another version or without an enabled admin",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+			},
+			Role:    users.RoleAdmin,
+			Handler: ImportConfig,
+		},
+		{
+			Path: "/selfbackup", Method: http.MethodGet, Tag: "config",
+			Summary:  "List the backups of the manager itself, its state database, config files and player lists, with the next run and the outcome of the last",
+			Response: map[string]interface{}{"selfbackup": selfbackup.Status{}},
+			Role:     users.RoleAdmin,
+			Handler:  SelfBackupStatus,
+		},
+		{
+			Path: "/selfbackup/run", Method: http.MethodPost, Tag: "config",
+			Summary:  "Back the manager itself up now and copy the archive to the self-backup targets",
+			Response: map[string]interface{}{"status": "", "archive": selfbackup.Archive{}},
+			Errors: map[int]string{
+				http.StatusConflict:            "Self-backups are not enabled",
+				http.StatusInternalServerError: "The backup or an upload failed",
+				http.StatusMethodNotAllowed:    "The request is not a POST",
+			},
+			Role:    users.RoleAdmin,
+			Handler: RunSelfBackup,
+		},
+		{
+			Path: "/players/files", Method: http.MethodGet, Tag: "players",
+			Summary: "List a map's player profile and tribe files with their owner IDs",
+			Params: append([]param{
+				mapParam,
+				{Name: "kind", Description: "Only list profile or tribe files", Type: "string", Validate: validatePlayerFileKind},
+				{Name: "owner_id", Description: "Only list the files of this player or tribe ID", Type: "string"},
+			}, listParams(playerFileSort, "-mod_time")...),
+			Response: map[string]interface{}{"map": "", "files": []savegame.PlayerFile{}, "pagination": pageInfo{}},
+			Handler:  ListPlayerFiles,
+		},
+		{
+			Path: "/players/files/download", Method: http.MethodGet, Tag: "players",
+			Summary: "Download a player profile or tribe file",
+			Params:  []param{mapParam, playerFileParam},
+			Errors:  map[int]string{http.StatusNotFound: "The map or file is unknown"},
+			Role:    users.RoleModerator,
+			Handler: DownloadPlayerFile,
+		},
+		{
+			Path: "/players/files/restore", Method: http.MethodPost, Tag: "players",
+			Summary:  "Restore a single player profile or tribe file from a backup archive without touching the world save",
+			Params:   []param{mapParam, archiveParam, playerFileParam},
+			Response: map[string]interface{}{"status": "", "map": "", "files": []string{}},
+			Errors: map[int]string{
+				http.StatusNotFound:         "The map has no backup configuration",
+				http.StatusMethodNotAllowed: "The request is not a POST",
+				http.StatusConflict:         "The map is running, or the file is not in the archive chain, or another operation on the map is in progress",
+			},
+			Role:    users.RoleAdmin,
+			Handler: RestorePlayerFile,
+		},
+		{
+			Path: "/wipe", Method: http.MethodGet, Tag: "processes",
+			Summary:  "Get the next scheduled wild dino wipe of a map and its past wipes",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"wipe": wipe.Status{}},
+			Handler:  GetWipeStatus,
+		},
+		{
+			Path: "/mods", Method: http.MethodGet, Tag: "processes",
+			Summary:  "Get the mod sets a map's server got ready with, newest first, with their CurseForge files, and the mods flagged as incompatible",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"mods": updater.ModLocks{}},
+			Errors:   map[int]string{http.StatusNotFound: "The map is unknown", http.StatusConflict: "Mod checks are not enabled in update_config.json"},
+			Handler:  GetModLocks,
+		},
+		{
+			Path: "/mods/rollback", Method: http.MethodPost, Tag: "processes",
+			Summary: "Set a map's mods list to the last set its server got ready with and flag the mods added or updated since as incompatible. Updated mods cannot be pinned to an older file, ASA always downloads the newest one.",
+			Params: []param{
+				mapParam,
+				{Name: "drop_updated", Description: "Also remove the mods updated since from the list", Type: "boolean", Validate: validateBool},
+				dryRunParam,
+			},
+			Response: map[string]interface{}{"status": "", "rollback": updater.ModRollback{}, "dry_run": false, "plan": dryrun.Plan{}},
+			Errors: map[int]string{
+				http.StatusBadRequest: "The map sets no mods in its launch options or arguments",
+				http.StatusNotFound:   "The map is unknown or never got ready with mods",
+				http.StatusConflict:   "Mod checks are not enabled in update_config.json",
+				http.StatusBadGateway: "CurseForge rejected the API key",
+			},
+			Role:    users.RoleAdmin,
+			Handler: RollbackMods,
+		},
+		{
+			Path: "/wipe", Method: http.MethodPost, Tag: "processes",
+			Summary: "Wipe the wild dinos of a map, by default after broadcasting the in-game warnings",
+			Params: []param{
+				mapParam,
+				{Name: "warn", Description: "Broadcast the warnings first and wipe in the background (default true)", Type: "boolean", Validate: validateBool},
+				dryRunParam,
+			},
+			Response: map[string]interface{}{"status": "", "map": "", "at": time.Time{}, "dry_run": false, "plan": dryrun.Plan{}},
+			Errors: map[int]string{
+				http.StatusUnauthorized: "The API key is unknown",
+				http.StatusForbidden:    "The caller may not run DestroyWildDinos",
+				http.StatusConflict:     "The map is not running or a wipe is already in progress",
+				http.StatusBadGateway:   "The server could not be reached, or most wild dinos were still counted after the wipe",
+			},
+			Role:    users.RoleAdmin,
+			Handler: TriggerWipe,
+		},
+		{
+			Path: "/gamelog", Method: http.MethodGet, Tag: "players",
+			Summary: "Search the collected game logs: joins, leaves, deaths, tribe log entries and admin commands, newest first",
+			Params: append([]param{
+				{Name: "map", Description: "Only return events of this map", Type: "string", Validate: validateMapName},
+				{Name: "player", Description: "Only return events naming this player, matched case-insensitively against the player and the message", Type: "string"},
+				{Name: "type", Description: "Only return events of this type: join, leave, death, tribe, admin or other", Type: "string", Validate: validateOneOf(gamelog.Types)},
+				{Name: "since", Description: "Only return events at or after this RFC 3339 time", Type: "string", Validate: validateTime},
+				{Name: "until", Description: "Only return events at or before this RFC 3339 time", Type: "string", Validate: validateTime},
+				{Name: "limit", Description: "Maximum number of events to page through, at most 5000. Without it every matching event is paged through.", Type: "integer", Validate: validatePositiveInt},
+			}, listParams(gameLogSort, "-time")...),
+			Response: map[string]interface{}{"events": []gamelog.Event{}, "pagination": pageInfo{}, "collecting": false},
+			Handler:  SearchGameLog,
+			Scoped:   true,
+		},
+		{
+			Path: "/log-alerts", Method: http.MethodGet, Tag: "players",
+			Summary: "List the log patterns and the alerts they raised on console and game log lines since the manager started, newest first",
+			Params: []param{
+				{Name: "map", Description: "Only return alerts of this map", Type: "string", Validate: validateMapName},
+			},
+			Response: map[string]interface{}{"patterns": []logalerts.Pattern{}, "alerts": []logalerts.Alert{}},
+			Role:     users.RoleModerator,
+			Handler:  ListLogAlerts,
+			Scoped:   true,
+		},
+		{
+			Path: "/gamelog/poll", Method: http.MethodPost, Tag: "players",
+			Summary:  "Collect a map's game log now instead of at the next poll and return the new events",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"status": "", "map": "", "events": []gamelog.Event{}},
+			Errors: map[int]string{
+				http.StatusNotFound:   "The map has no RCON configuration",
+				http.StatusConflict:   "The map is not running",
+				http.StatusBadGateway: "The server could not be reached",
+			},
+			Role:    users.RoleModerator,
+			Handler: PollGameLog,
+		},
+		{
+			Path: "/rules", Method: http.MethodGet, Tag: "rules",
+			Summary:  "List the automation rules with their recent firings",
+			Response: map[string]interface{}{"rules": []rules.RuleStatus{}},
+			Handler:  ListRules,
+		},
+		{
+			Path: "/rules", Method: http.MethodPost, Tag: "rules",
+			Summary:  "Create an automation rule: a trigger such as a crash or an empty server and the actions it runs",
+			Body:     rules.Rule{},
+			Response: map[string]interface{}{"status": "", "rule": rules.Rule{}},
+			Errors: map[int]string{
+				http.StatusUnauthorized: "The API key is unknown",
+				http.StatusForbidden:    "The caller may not run an RCON command of the rule or access one of its maps",
+				http.StatusConflict:     "A rule with this name already exists",
+			},
+			Role:    users.RoleAdmin,
+			Handler: CreateRule,
+		},
+		{
+			Path: "/rules/{name}", Method: http.MethodGet, Tag: "rules",
+			Summary:  "Get an automation rule with its recent firings",
+			Params:   []param{ruleNameParam},
+			Response: map[string]interface{}{"rule": rules.RuleStatus{}},
+			Errors:   map[int]string{http.StatusNotFound: "The rule is unknown"},
+			Handler:  GetRule,
+		},
+		{
+			Path: "/rules/{name}", Method: http.MethodPut, Tag: "rules",
+			Summary:  "Replace an automation rule",
+			Params:   []param{ruleNameParam},
+			Body:     rules.Rule{},
+			Response: map[string]interface{}{"status": "", "rule": rules.Rule{}},
+			Errors: map[int]string{
+				http.StatusUnauthorized: "The API key is unknown",
+				http.StatusForbidden:    "The caller may not run an RCON command of the rule or access one of its maps",
+				http.StatusNotFound:     "The rule is unknown",
+			},
+			Role:    users.RoleAdmin,
+			Handler: UpdateRule,
+		},
+		{
+			Path: "/rules/{name}", Method: http.MethodDelete, Tag: "rules",
+			Summary:  "Delete an automation rule",
+			Params:   []param{ruleNameParam},
+			Response: map[string]interface{}{"status": "", "rule": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The rule is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  DeleteRule,
+		},
+		{
+			Path: "/macros", Method: http.MethodGet, Tag: "macros",
+			Summary:  "List the macros with when their schedules run next",
+			Response: map[string]interface{}{"macros": []macros.MacroStatus{}},
+			Handler:  ListMacros,
+		},
+		{
+			Path: "/macros", Method: http.MethodPost, Tag: "macros",
+			Summary:  "Create a macro: a sequence of RCON commands, broadcasts and INI changes with delays between them",
+			Body:     macros.Macro{},
+			Response: map[string]interface{}{"status": "", "macro": macros.Macro{}},
+			Errors: map[int]string{
+				http.StatusUnauthorized: "The API key is unknown",
+				http.StatusForbidden:    "The caller may not run an RCON command of the macro or access a map it is scheduled on",
+				http.StatusConflict:     "A macro with this name already exists",
+			},
+			Role:    users.RoleAdmin,
+			Handler: CreateMacro,
+		},
+		{
+			Path: "/macros/{name}", Method: http.MethodGet, Tag: "macros",
+			Summary:  "Get a macro with when its schedules run next",
+			Params:   []param{macroNameParam},
+			Response: map[string]interface{}{"macro": macros.MacroStatus{}},
+			Errors:   map[int]string{http.StatusNotFound: "The macro is unknown"},
+			Handler:  GetMacro,
+		},
+		{
+			Path: "/macros/{name}", Method: http.MethodPut, Tag: "macros",
+			Summary:  "Replace a macro, runs in progress keep their steps",
+			Params:   []param{macroNameParam},
+			Body:     macros.Macro{},
+			Response: map[string]interface{}{"status": "", "macro": macros.Macro{}},
+			Errors: map[int]string{
+				http.StatusUnauthorized: "The API key is unknown",
+				http.StatusForbidden:    "The caller may not run an RCON command of the macro or access a map it is scheduled on",
+				http.StatusNotFound:     "The macro is unknown",
+			},
+			Role:    users.RoleAdmin,
+			Handler: UpdateMacro,
+		},
+		{
+			Path: "/macros/{name}", Method: http.MethodDelete, Tag: "macros",
+			Summary:  "Delete a macro",
+			Params:   []param{macroNameParam},
+			Response: map[string]interface{}{"status": "", "macro": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The macro is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  DeleteMacro,
+		},
+		{
+			Path: "/macros/{name}/run", Method: http.MethodPost, Tag: "macros",
+			Summary:  "Run a macro on a map as a job, the job's result lists what each step did",
+			Params:   []param{macroNameParam, mapParam, dryRunParam},
+			Response: map[string]interface{}{"status": "", "macro": "", "map": "", "job": jobs.Job{}},
+			Errors:   map[int]string{http.StatusNotFound: "The macro or map is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  RunMacro,
+		},
+		{
+			Path: "/webhooks", Method: http.MethodGet, Tag: "webhooks",
+			Summary:  "List the outgoing webhooks, secrets are masked",
+			Response: map[string]interface{}{"webhooks": []notify.Webhook{}},
+			Role:     users.RoleAdmin,
+			Handler:  ListWebhooks,
+		},
+		{
+			Path: "/webhooks", Method: http.MethodPost, Tag: "webhooks",
+			Summary:  "Subscribe a URL to events such as process.started, backup.completed or player.joined, deliveries are signed with the secret",
+			Body:     notify.Webhook{},
+			Response: map[string]interface{}{"status": "", "webhook": notify.Webhook{}},
+			Errors:   map[int]string{http.StatusConflict: "A webhook with this name already exists"},
+			Role:     users.RoleAdmin,
+			Handler:  CreateWebhook,
+		},
+		{
+			Path: "/webhooks/deliveries", Method: http.MethodGet, Tag: "webhooks",
+			Summary:  "List the recent deliveries of a webhook with their status and attempts",
+			Params:   []param{{Name: "webhook", Description: "Webhook name", Required: true, Type: "string"}},
+			Response: map[string]interface{}{"webhook": "", "deliveries": []notify.Delivery{}},
+			Errors:   map[int]string{http.StatusNotFound: "The webhook is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  WebhookDeliveries,
+		},
+		{
+			Path: "/webhooks/{name}", Method: http.MethodPut, Tag: "webhooks",
+			Summary:  "Replace an outgoing webhook",
+			Params:   []param{webhookNameParam},
+			Body:     notify.Webhook{},
+			Response: map[string]interface{}{"status": "", "webhook": notify.Webhook{}},
+			Errors:   map[int]string{http.StatusNotFound: "The webhook is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  UpdateWebhook,
+		},
+		{
+			Path: "/webhooks/{name}", Method: http.MethodDelete, Tag: "webhooks",
+			Summary:  "Delete an outgoing webhook and its delivery history",
+			Params:   []param{webhookNameParam},
+			Response: map[string]interface{}{"status": "", "webhook": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The webhook is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  DeleteWebhook,
+		},
+		{
+			Path: "/events", Method: http.MethodGet, Tag: "webhooks",
+			Summary: "Stream manager events as server-sent events (text/event-stream): process state changes, backup jobs and their progress, RCON command results and player joins. Each event's data is the JSON payload shown.",
+			Params: []param{
+				{Name: "events", Description: "Comma separated event types to stream, a trailing * matches a prefix, e.g. process.*,backup.job (default all)", Type: "string"},
+				{Name: "map", Description: "Only stream events of this map", Type: "string", Validate: validateMapName},
+			},
+			Response: map[string]interface{}{"event": notify.Payload{}},
+			Handler:  StreamEvents,
+			Scoped:   true,
+		},
+		{
+			Path: "/whitelist", Method: http.MethodGet, Tag: "players",
+			Summary:  "List the EOS IDs in a map's exclusive join list",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"map": "", "ids": []string{}},
+			Handler:  GetWhitelist,
+		},
+		{
+			Path: "/whitelist", Method: http.MethodPost, Tag: "players",
+			Summary:  "Add EOS IDs to the exclusive join list of a map or every map of a cluster",
+			Params:   []param{whitelistMapParam, whitelistClusterParam},
+			Body:     WhitelistChange{},
+			Response: map[string]interface{}{"status": "", "maps": []WhitelistResult{}},
+			Errors:   map[int]string{http.StatusUnauthorized: "The API key is unknown"},
+			Role:     users.RoleModerator,
+			Handler:  AddToWhitelist,
+		},
+		{
+			Path: "/whitelist", Method: http.MethodDelete, Tag: "players",
+			Summary:  "Remove EOS IDs from the exclusive join list of a map or every map of a cluster",
+			Params:   []param{whitelistMapParam, whitelistClusterParam},
+			Body:     WhitelistChange{},
+			Response: map[string]interface{}{"status": "", "maps": []WhitelistResult{}},
+			Errors:   map[int]string{http.StatusUnauthorized: "The API key is unknown"},
+			Role:     users.RoleModerator,
+			Handler:  RemoveFromWhitelist,
+		},
+		{
+			Path: "/motd", Method: http.MethodGet, Tag: "config",
+			Summary:  "Get a map's message of the day and the history of its MOTD and dynamic config changes",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"map": "", "motd": motd.MOTD{}, "history": []motd.Change{}},
+			Handler:  GetMOTD,
+		},
+		{
+			Path: "/motd", Method: http.MethodPut, Tag: "config",
+			Summary:  "Set a map's message of the day in GameUserSettings.ini and on the running server",
+			Params:   []param{mapParam},
+			Body:     MOTDUpdate{},
+			Response: map[string]interface{}{"status": "", "map": "", "previous": motd.MOTD{}, "result": ApplyResult{}},
+			Errors:   map[int]string{http.StatusUnauthorized: "The API key is unknown"},
+			Role:     users.RoleModerator,
+			Handler:  SetMOTD,
+		},
+		{
+			Path: "/motd/dynamic", Method: http.MethodGet, Tag: "config",
+			Summary:  "Get a map's dynamic config values and the settings that can be changed",
+			Params:   []param{mapParam},
+			Response: map[string]interface{}{"map": "", "values": map[string]string{}, "keys": []string{}, "use_dynamic_config": false, "history": []motd.Change{}},
+			Handler:  GetDynamicConfig,
+		},
+		{
+			Path: "/motd/dynamic", Method: http.MethodPatch, Tag: "config",
+			Summary:  "Change a map's dynamic config values and make the running server reload them",
+			Params:   []param{mapParam},
+			Body:     DynamicUpdate{},
+			Response: map[string]interface{}{"status": "", "map": "", "previous": map[string]string{}, "result": ApplyResult{}},
+			Errors:   map[int]string{http.StatusUnauthorized: "The API key is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  PatchDynamicConfig,
+		},
+		{
+			Path: "/dynamicconfig/{map}", Method: http.MethodGet, Tag: "config",
+			Summary: "Serve a map's dynamic config file, for use as the server's customdynamicconfigurl",
+			Params: []param{
+				{Name: "map", In: "path", Description: "Map name", Required: true, Type: "string", Validate: validateMapName},
+			},
+			Handler: ServeDynamicConfig,
+		},
+		{
+			Path: "/agents/register", Method: http.MethodPost, Tag: "agents",
+			Summary:  "Register an agent with this control plane, agents repeat it as heartbeat. Requires the shared token in the X-Agent-Token header.",
+			Body:     agent.Registration{},
+			Response: map[string]interface{}{"status": "", "agent": agent.Host{}},
+			Errors: map[int]string{
+				http.StatusUnauthorized:     "The agent token is wrong",
+				http.StatusForbidden:        "No agent token is configured, so agents are disabled",
+				http.StatusMethodNotAllowed: "The method is not POST",
+			},
+			Handler: RegisterAgent,
+		},
+		{
+			Path: "/agents", Method: http.MethodGet, Tag: "agents",
+			Summary:  "List the agents registered with this control plane and whether they are online",
+			Response: map[string]interface{}{"agents": []agent.Host{}},
+			Handler:  ListAgents,
+		},
+		{
+			Path: "/agents/{name}", Method: http.MethodDelete, Tag: "agents",
+			Summary:  "Forget an agent, a running agent registers again with its next heartbeat",
+			Params:   []param{{Name: "name", In: "path", Description: "Agent name", Required: true, Type: "string"}},
+			Response: map[string]interface{}{"status": "", "name": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The agent is unknown"},
+			Role:     users.RoleAdmin,
+			Handler:  RemoveAgent,
+		},
+		{
+			Path: "/hosts/{host}/{path...}", Method: http.MethodGet, Tag: "agents",
+			Summary: "Forward any request to an agent's API, e.g. POST /v1/hosts/machine-2/v1/maps/TheIsland_WP/start. Every method is forwarded, " +
+				"the caller is authorized against the endpoint's role here and passed on to the agent.",
+			Params: []param{
+				{Name: "host", In: "path", Description: "Agent name", Required: true, Type: "string"},
+				{Name: "path", In: "path", Description: "Endpoint path on the agent", Required: true, Type: "string"},
+			},
+			Errors: map[int]string{
+				http.StatusNotFound:   "The agent or endpoint is unknown",
+				http.StatusBadGateway: "The agent is offline or did not respond",
+			},
+			Handler:   ProxyHost,
+			AnyMethod: true,
+		},
+		{
+			Path: "/login", Method: http.MethodPost, Tag: "users",
+			Summary:  "Log in with a user's password, the session token is returned as an HTTP-only cookie",
+			Body:     Credentials{},
+			Response: map[string]interface{}{"status": "", "user": users.User{}, "expires": time.Time{}},
+			Errors: map[int]string{
+				http.StatusUnauthorized:     "The name, password or two-factor code is wrong, or the user is disabled. The code two_factor_required asks for a two-factor code.",
+				http.StatusMethodNotAllowed: "The method is not POST",
+			},
+			Handler: Login,
+		},
+		{
+			Path: "/logout", Method: http.MethodPost, Tag: "users",
+			Summary:  "End the session of the request",
+			Response: map[string]interface{}{"status": ""},
+			Errors:   map[int]string{http.StatusMethodNotAllowed: "The method is not POST"},
+			Handler:  Logout,
+			Scoped:   true,
+		},
+		{
+			Path: "/session", Method: http.MethodGet, Tag: "users",
+			Summary:  "Describe the caller: name, effective role and, for session logins, the user",
+			Response: map[string]interface{}{"name": "", "role": "", "user": users.User{}, "two_factor_required": false},
+			Errors:   map[int]string{http.StatusUnauthorized: "The API key or session is invalid"},
+			Handler:  GetSession,
+			Scoped:   true,
+		},
+		{
+			Path: "/2fa/enroll", Method: http.MethodPost, Tag: "users",
+			Summary:  "Start two-factor enrollment for the logged in user: returns a TOTP secret and otpauth:// URI for an authenticator app",
+			Response: map[string]interface{}{"status": "", "enrollment": users.Enrollment{}},
+			Errors: map[int]string{
+				http.StatusConflict:         "Two-factor authentication is already enabled",
+				http.StatusMethodNotAllowed: "The method is not POST",
+			},
+			Handler: BeginTwoFactor,
+			Scoped:  true,
+		},
+		{
+			Path: "/2fa/confirm", Method: http.MethodPost, Tag: "users",
+			Summary:  "Confirm the enrollment with a code from the authenticator app, returns one-time recovery codes",
+			Body:     TwoFactorCode{},
+			Response: map[string]interface{}{"status": "", "recovery_codes": []string{}},
+			Errors: map[int]string{
+				http.StatusConflict:         "No enrollment is in progress or two-factor authentication is already enabled",
+				http.StatusMethodNotAllowed: "The method is not POST",
+			},
+			Handler: ConfirmTwoFactor,
+			Scoped:  true,
+		},
+		{
+			Path: "/2fa/recovery-codes", Method: http.MethodPost, Tag: "users",
+			Summary:  "Replace the logged in user's recovery codes, requires a current authenticator code",
+			Body:     TwoFactorCode{},
+			Response: map[string]interface{}{"status": "", "recovery_codes": []string{}},
+			Errors: map[int]string{
+				http.StatusConflict:         "Two-factor authentication is not enabled",
+				http.StatusMethodNotAllowed: "The method is not POST",
+			},
+			Handler: RegenerateRecoveryCodes,
+			Scoped:  true,
+		},
+		{
+			Path: "/2fa/policy", Method: http.MethodGet, Tag: "users",
+			Summary:  "List the roles that must use two-factor authentication",
+			Response: map[string]interface{}{"policy": TwoFactorPolicy{}},
+			Role:     users.RoleAdmin,
+			Handler:  GetTwoFactorPolicy,
+		},
+		{
+			Path: "/2fa/policy", Method: http.MethodPut, Tag: "users",
+			Summary:  "Require two-factor authentication for roles, e.g. moderator and admin. Their users without it can only enroll until they do.",
+			Body:     TwoFactorPolicy{},
+			Response: map[string]interface{}{"status": "", "policy": TwoFactorPolicy{}},
+			Role:     users.RoleAdmin,
+			Handler:  SetTwoFactorPolicy,
+		},
+		{
+			Path: "/users", Method: http.MethodGet, Tag: "users",
+			Summary:  "List the user accounts",
+			Response: map[string]interface{}{"users": []users.User{}},
+			Role:     users.RoleAdmin,
+			Handler:  ListUsers,
+		},
+		{
+			Path: "/users", Method: http.MethodPost, Tag: "users",
+			Summary: "Create a user with a role (viewer, moderator or admin) and optionally limit it to some maps. " +
+				"The role also applies to RCON commands. Callers without a login or API key have no role unless allow_anonymous gives them default_role.",
+			Body:     NewUser{},
+			Response: map[string]interface{}{"status": "", "user": users.User{}},
+			Errors:   map[int]string{http.StatusConflict: "A user with this name already exists"},
+			Role:     users.RoleAdmin,
+			Handler:  CreateUser,
+		},
+		{
+			Path: "/users/{name}", Method: http.MethodPatch, Tag: "users",
+			Summary:  "Change a user's role, maps or password, disable it or reset its two-factor authentication. Disabling or a new password ends the user's sessions.",
+			Params:   []param{userNameParam},
+			Body:     users.Changes{},
+			Response: map[string]interface{}{"status": "", "user": users.User{}},
+			Errors:   map[int]string{http.StatusNotFound: "The user is unknown", http.StatusConflict: "The change would leave no enabled admin"},
+			Role:     users.RoleAdmin,
+			Handler:  UpdateUser,
+		},
+		{
+			Path: "/users/{name}", Method: http.MethodDelete, Tag: "users",
+			Summary:  "Delete a user and end its sessions",
+			Params:   []param{userNameParam},
+			Response: map[string]interface{}{"status": "", "name": ""},
+			Errors:   map[int]string{http.StatusNotFound: "The user is unknown", http.StatusConflict: "The user is the last enabled admin"},
+			Role:     users.RoleAdmin,
+			Handler:  DeleteUser,
+		},
+		{
+			Path: "/tenants", Method: http.MethodGet, Tag: "users",
+			Summary:  "List the tenants, the groups of maps their users and API keys are limited to, their rate limits and quotas",
+			Response: map[string]interface{}{"tenants": []tenants.Tenant{}},
+			Role:     users.RoleAdmin,
+			Handler:  ListTenants,
+		},
+		{
+			Path: "/tenants/{name}/usage", Method: http.MethodGet, Tag: "users",
+			Summary:  "Report how much backup storage and how many servers a tenant uses next to its quota, callers of a tenant only get their own",
+			Params:   []param{tenantNameParam},
+			Response: map[string]interface{}{"usage": TenantUsage{}},
+			Errors:   map[int]string{http.StatusNotFound: "The tenant is unknown"},
+			Role:     users.RoleViewer,
+			Handler:  GetTenantUsage,
+			Scoped:   true,
+		},
+	}))
+}
+
+// registerRoutes adds the routes to mux. Routes sharing a path are
+// dispatched by method, other methods are answered with 405. GET routes
+// also serve HEAD, OPTIONS lists the methods. A route that serves any
+// method, or a legacy query endpoint, is alone on its path and takes every
+// method.
+func registerRoutes(mux *http.ServeMux, routes []route) {
+	var patterns []string
+	byPattern := make(map[string][]route)
+	lookup := http.NewServeMux()
+	for _, rt := range routes {
+		if _, seen := byPattern[rt.Path]; !seen {
+			patterns = append(patterns, rt.Path)
+			lookup.HandleFunc(rt.Path, http.NotFound)
+		}
+		byPattern[rt.Path] = append(byPattern[rt.Path], rt)
+	}
+	routeLookup = lookup
+
+	for _, pattern := range patterns {
+		group := byPattern[pattern]
+		if len(group) == 1 && (group[0].AnyMethod || group[0].Legacy) {
+			mux.HandleFunc(pattern, rateLimitMiddleware(deprecationMiddleware(group[0], authorizeMiddleware(group[0], validateMiddleware(group[0], group[0].Handler)))))
+			continue
+		}
+
+		handlers := make(map[string]http.HandlerFunc)
+		var methods []string
+		for _, rt := range group {
+			handlers[rt.Method] = deprecationMiddleware(rt, authorizeMiddleware(rt, validateMiddleware(rt, rt.Handler)))
+			methods = append(methods, rt.Method)
+		}
+		if get, ok := handlers[http.MethodGet]; ok {
+			if _, ok := handlers[http.MethodHead]; !ok {
+				handlers[http.MethodHead] = get
+				methods = append(methods, http.MethodHead)
+			}
+		}
+		allow := strings.Join(methods, ", ")
+		mux.HandleFunc(pattern, rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			handler, ok := handlers[r.Method]
+			if r.Method == http.MethodOptions && !ok {
+				w.Header().Set("Allow", allow+", OPTIONS")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if !ok {
+				w.Header().Set("Allow", allow)
+				respondError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "method must be one of "+allow)
+				return
+			}
+			handler(w, r)
+		}))
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/rules"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+var (
+	rules_conf = "config/rules_config.json"
+
+	ruleEngine *rules.Engine
+
+	ruleNameParam = param{Name: "name", In: "path", Description: "Rule name", Required: true, Type: "string"}
+)
+
+func rulesError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rules.ErrInvalidRule):
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	case errors.Is(err, rules.ErrRuleNotFound):
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, rules.ErrRuleExists):
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+	case errors.Is(err, rcon.ErrCommandDenied), errors.Is(err, errMapAccess):
+		respondError(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+	default:
+		log.Printf("Failed to save rules: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
+
+// decodeRule reads a rule from the request body. Rules run their RCON
+// commands as the manager, so the caller must be allowed to run them, and
+// on maps the caller may access. Callers limited to some maps have to name
+// them.
+func decodeRule(w http.ResponseWriter, r *http.Request) (rules.Rule, error) {
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		return rules.Rule{}, err
+	}
+	scope, err := requestScope(r)
+	if err != nil {
+		return rules.Rule{}, err
+	}
+
+	var rule rules.Rule
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rule); err != nil {
+		return rules.Rule{}, errors.Join(rules.ErrInvalidRule, err)
+	}
+	if len(rule.Maps) == 0 && scope.restricted() {
+		return rules.Rule{}, fmt.Errorf("%w: the rule has to name the maps it applies to", errMapAccess)
+	}
+	if err := scope.checkMaps(rule.Maps); err != nil {
+		return rules.Rule{}, err
+	}
+
+	perms, err := rcon.LoadPermissions()
+	if err != nil {
+		return rules.Rule{}, err
+	}
+	for _, action := range rule.Actions {
+		if action.Type != rules.ActionRcon {
+			continue
+		}
+		if err := perms.Authorize(caller, action.Command); err != nil {
+			return rules.Rule{}, err
+		}
+	}
+	return rule, nil
+}
+
+func ListRules(w http.ResponseWriter, r *http.Request) {
+	respondOK(w, map[string]interface{}{"rules": ruleEngine.Rules()})
+}
+
+func CreateRule(w http.ResponseWriter, r *http.Request) {
+	rule, err := decodeRule(w, r)
+	if err != nil {
+		if errors.Is(err, errUnknownAPIKey) {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+			return
+		}
+		rulesError(w, err)
+		return
+	}
+	if err := ruleEngine.Create(rule); err != nil {
+		rulesError(w, err)
+		return
+	}
+
+	logf(r, "Created rule %s", rule.Name)
+	respondOK(w, map[string]interface{}{"status": "Rule created", "rule": rule})
+}
+
+func GetRule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	rule, err := ruleEngine.Get(name)
+	if err != nil {
+		rulesError(w, err)
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"rule": rule})
+}
+
+// UpdateRule replaces a rule, the name in the body must match the path
+func UpdateRule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	rule, err := decodeRule(w, r)
+	if err != nil {
+		if errors.Is(err, errUnknownAPIKey) {
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+			return
+		}
+		rulesError(w, err)
+		return
+	}
+	if rule.Name == "" {
+		rule.Name = name
+	}
+	if rule.Name != name {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "a rule cannot be renamed, delete and create it instead")
+		return
+	}
+	if err := ruleEngine.Update(rule); err != nil {
+		rulesError(w, err)
+		return
+	}
+
+	logf(r, "Updated rule %s", rule.Name)
+	respondOK(w, map[string]interface{}{"status": "Rule updated", "rule": rule})
+}
+
+func DeleteRule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := ruleEngine.Delete(name); err != nil {
+		rulesError(w, err)
+		return
+	}
+
+	logf(r, "Deleted rule %s", name)
+	respondOK(w, map[string]interface{}{"status": "Rule deleted", "rule": name})
+}
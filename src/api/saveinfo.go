@@ -0,0 +1,41 @@
+package api
+
+import (
+	"asa_servermanager_api/savegame"
+	"errors"
+	"net/http"
+)
+
+// saveDir returns where a map's saves are, the backup configuration's
+// extract_dir or the directory derived from its launch config
+func saveDir(mapName string) (string, bool) {
+	if dir, ok := backups.SaveDir(mapName); ok && dir != "" {
+		return dir, true
+	}
+	if config, ok := processes.Config(mapName); ok && config.SaveDir() != "" {
+		return config.SaveDir(), true
+	}
+	return "", false
+}
+
+func GetSaveInfo(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	dir, ok := saveDir(mapName)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no backup or launch configuration for map "+mapName)
+		return
+	}
+	info, err := savegame.Read(dir)
+	if err != nil {
+		if errors.Is(err, savegame.ErrNoSave) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		logf(r, "Failed to read save info of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the save")
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"map": mapName, "save": info})
+}
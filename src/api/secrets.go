@@ -0,0 +1,117 @@
+package api
+
+import (
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/inidrift"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/secrets"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminPasswordKey is the GameUserSettings.ini setting RCON logs in with
+const adminPasswordKey = "ServerAdminPassword"
+
+// PasswordRotation is the body of POST /rcon/password
+type PasswordRotation struct {
+	Password string `json:"password"`
+}
+
+func RotateRconPassword(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	mapName := r.URL.Query().Get("map")
+
+	var body PasswordRotation
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	// The password also ends up in the server's launch URL
+	if len(body.Password) < 8 || strings.ContainsAny(body.Password, "?\" \r\n") {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "password must be at least 8 characters without spaces, quotes or '?'")
+		return
+	}
+	secrets.Register(body.Password)
+
+	if _, err := rcon.LoadRconInfo(mapName); err != nil {
+		if errors.Is(err, rcon.ErrMapNotConfigured) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if rcon.PassOverridden(mapName) {
+		respondError(w, http.StatusConflict, ErrCodeConflict, "the password of "+mapName+" is set by ASA_RCON_"+strings.ToUpper(mapName)+"_PASS")
+		return
+	}
+
+	// The INI is written first so a failure leaves both passwords as they were
+	iniFile, restoreIni, err := setAdminPassword(mapName, body.Password)
+	if err != nil {
+		logf(r, "Failed to update %s of map %s: %v", ini.GameUserSettings, mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to update "+ini.GameUserSettings)
+		return
+	}
+	if err := rcon.SetPassword(mapName, body.Password); err != nil {
+		logf(r, "Failed to rotate rcon password of map %s: %v", mapName, err)
+		if restoreIni != nil {
+			restoreIni()
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	logf(r, "Rotated rcon password of map %s", mapName)
+	respondOK(w, map[string]interface{}{
+		"status": "Password rotated, restart the server to apply it",
+		"map":    mapName,
+		"ini":    iniFile,
+		"sealed": secrets.Enabled(),
+	})
+}
+
+// setAdminPassword writes the password to the map's GameUserSettings.ini
+// and returns the file's name and a func that undoes the change. Maps
+// without a process configuration have no INI to update.
+func setAdminPassword(mapName string, pass string) (string, func(), error) {
+	path, err := iniPath(mapName, ini.GameUserSettings)
+	if err != nil {
+		return "", nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, err
+	}
+	if data != nil {
+		if _, err := backupIni(mapName, ini.GameUserSettings, data); err != nil {
+			return "", nil, err
+		}
+	}
+
+	f := ini.Parse(data)
+	f.Set("ServerSettings", adminPasswordKey, pass)
+	if err := inidrift.Write(mapName, ini.GameUserSettings, path, f.Bytes()); err != nil {
+		return "", nil, err
+	}
+	restore := func() {
+		var err error
+		if data == nil {
+			err = os.Remove(path)
+		} else {
+			err = inidrift.Write(mapName, ini.GameUserSettings, path, data)
+		}
+		if err != nil {
+			log.Printf("Failed to restore %s: %v", path, err)
+		}
+	}
+	return ini.GameUserSettings, restore, nil
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"asa_servermanager_api/selfbackup"
+)
+
+var (
+	selfbackup_conf = "config/selfbackup_config.json"
+
+	selfBackups *selfbackup.Manager
+)
+
+// SelfBackupStatus lists the backups of the manager itself and how the
+// last one went
+func SelfBackupStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := selfBackups.Status()
+	if err != nil {
+		logf(r, "Failed to list self-backups: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to list the self-backups")
+		return
+	}
+	respondOK(w, map[string]interface{}{"selfbackup": status})
+}
+
+// RunSelfBackup backs the manager up now
+func RunSelfBackup(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	archive, err := selfBackups.Run()
+	if err != nil {
+		if errors.Is(err, selfbackup.ErrDisabled) {
+			respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+			return
+		}
+		logf(r, "Self-backup failed: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	logf(r, "Backed up the manager to %s", archive.Name)
+	respondOK(w, map[string]interface{}{"status": "Manager backed up", "archive": archive})
+}
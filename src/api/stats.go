@@ -0,0 +1,149 @@
+package api
+
+import (
+	"asa_servermanager_api/monitor"
+	"asa_servermanager_api/processmanager"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	monitor_conf = "config/monitor_config.json"
+
+	stats *monitor.Monitor
+
+	statsRangeParam = param{Name: "range", Description: "How far back to return samples, e.g. 15m or 1h (default 1h)", Type: "string", Validate: validateRange}
+)
+
+const (
+	defaultStatsRange      = time.Hour
+	defaultPopulationRange = 7 * 24 * time.Hour
+	defaultUptimeRange     = 30 * 24 * time.Hour
+)
+
+func validateRange(value string) error {
+	span, err := time.ParseDuration(value)
+	if err != nil || span <= 0 {
+		return errors.New("must be a positive duration such as 15m or 1h")
+	}
+	if stats != nil && span > stats.History() {
+		return fmt.Errorf("must not exceed the kept history of %s", stats.History())
+	}
+	return nil
+}
+
+func validatePopulationRange(value string) error {
+	span, err := monitor.ParseSpan(value)
+	if err != nil {
+		return errors.New("must be a positive duration such as 12h or 7d")
+	}
+	if stats != nil && span > stats.PopulationRetention() {
+		return fmt.Errorf("must not exceed the kept history of %d days", int(stats.PopulationRetention().Hours()/24))
+	}
+	return nil
+}
+
+func validateUptimeRange(value string) error {
+	span, err := monitor.ParseSpan(value)
+	if err != nil {
+		return errors.New("must be a positive duration such as 12h or 30d")
+	}
+	if span > processmanager.AvailabilityRetention {
+		return fmt.Errorf("must not exceed the kept history of %d days", int(processmanager.AvailabilityRetention.Hours()/24))
+	}
+	return nil
+}
+
+func validateBucket(value string) error {
+	span, err := monitor.ParseSpan(value)
+	if err != nil || span < time.Minute {
+		return errors.New("must be a duration of at least 1m such as 15m or 1h")
+	}
+	return nil
+}
+
+// GetPopulation returns the player count history of a map in buckets and
+// its average player count per hour of the day
+func GetPopulation(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	span := defaultPopulationRange
+	if value := r.URL.Query().Get("range"); value != "" {
+		span, _ = monitor.ParseSpan(value)
+	}
+	var bucket time.Duration
+	if value := r.URL.Query().Get("bucket"); value != "" {
+		bucket, _ = monitor.ParseSpan(value)
+	}
+	if bucket > span {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "bucket must not be longer than the range")
+		return
+	}
+
+	population, err := stats.Population(mapName, span, bucket)
+	if err != nil {
+		if errors.Is(err, monitor.ErrPopulationDisabled) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"population": population})
+}
+
+// GetStats returns the resource usage history of a map's server process
+func GetStats(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	span := defaultStatsRange
+	if value := r.URL.Query().Get("range"); value != "" {
+		span, _ = time.ParseDuration(value)
+	}
+
+	samples, alerts, err := stats.Stats(mapName, span)
+	if err != nil {
+		if errors.Is(err, monitor.ErrNoStats) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	body := map[string]interface{}{
+		"map":     mapName,
+		"range":   span.String(),
+		"samples": samples,
+		"alerts":  alerts,
+	}
+	if liveness, ok := stats.Liveness(mapName); ok {
+		body["liveness"] = liveness
+	}
+	if players, ok := stats.Players(mapName); ok {
+		body["players"] = players
+	}
+	respondOK(w, body)
+}
+
+// GetUptime returns a map's availability over a range: its uptime, outages
+// and how long they took to recover from
+func GetUptime(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	span := defaultUptimeRange
+	if value := r.URL.Query().Get("range"); value != "" {
+		span, _ = monitor.ParseSpan(value)
+	}
+
+	uptime, err := processes.Uptime(mapName, span)
+	if err != nil {
+		if errors.Is(err, processmanager.ErrMapNotFound) {
+			respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	respondOK(w, map[string]interface{}{"uptime": uptime})
+}
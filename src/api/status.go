@@ -0,0 +1,91 @@
+package api
+
+import (
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/monitor"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/updater"
+	"net/http"
+	"time"
+)
+
+var (
+	update_conf = "config/update_config.json"
+
+	updates *updater.Updater
+)
+
+// ServerStatus is the state of a map's server in /status. Running servers
+// are "starting" until they have loaded their world and "ready" after,
+// others report their process state. Maintenance is set for maps in
+// maintenance mode. Query is what the server advertises on its Steam
+// query port and Listing its entry in the EOS server list, for maps the
+// monitor checks. RCON is set while the map's RCON is marked unavailable.
+type ServerStatus struct {
+	Map         string    `json:"map"`
+	State       string    `json:"state"`
+	Since       time.Time `json:"since"`
+	Maintenance bool      `json:"maintenance,omitempty"`
+
+	Query   *monitor.Query   `json:"query,omitempty"`
+	Listing *monitor.Listing `json:"listing,omitempty"`
+
+	RCON *rcon.Availability `json:"rcon,omitempty"`
+}
+
+func serverStatuses() []ServerStatus {
+	states := processes.States()
+	servers := make([]ServerStatus, 0, len(states))
+	for _, ms := range states {
+		server := ServerStatus{Map: ms.Map, State: string(ms.Actual), Since: ms.Since, Maintenance: maintenance.Active(ms.Map)}
+		if ms.Actual == processmanager.ActualRunning && ms.Readiness != "" {
+			server.State = string(ms.Readiness)
+			if ms.Ready() {
+				server.Since = ms.ReadySince
+			}
+		}
+		if query, ok := stats.Query(ms.Map); ok {
+			server.Query = &query
+		}
+		if listing, ok := stats.Listing(ms.Map); ok {
+			server.Listing = &listing
+		}
+		if availability := rcon.MapAvailability(ms.Map); !availability.Available {
+			server.RCON = &availability
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// GetStatus reports manager-wide state: the servers and whether they are
+// ready, the maintenance modes, pending server updates, the free space of
+// the volumes holding backups and saves, the operations currently holding
+// a map's lock and whether every operation is a dry run
+func GetStatus(w http.ResponseWriter, r *http.Request) {
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	modes, err := maintenance.List()
+	if err != nil {
+		logf(r, "Failed to list maintenance modes: %v", err)
+	}
+	update := updates.Status()
+	if scope.restricted() {
+		modes = scopedModes(scope, modes)
+		update.Maps = scoped(scope, update.Maps, func(m updater.MapUpdate) string { return m.Map })
+		if update.Mods != nil {
+			update.Mods.Maps = scoped(scope, update.Mods.Maps, func(m updater.MapMods) string { return m.Map })
+		}
+		// The last run may have updated maps of others
+		update.LastResult = nil
+	}
+	servers := scoped(scope, serverStatuses(), func(s ServerStatus) string { return s.Map })
+	operations := scoped(scope, maplock.Held(), func(op maplock.Operation) string { return op.Map })
+	respondOK(w, map[string]interface{}{"servers": servers, "maintenance": modes, "update": update, "disk": backups.DiskUsage(), "operations": operations, "dry_run": dryrun.Global()})
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/tenants"
+	"asa_servermanager_api/users"
+)
+
+var (
+	tenant_conf = "config/tenant_config.json"
+
+	tenantNameParam = param{Name: "name", In: "path", Description: "Tenant name", Required: true, Type: "string"}
+)
+
+// TenantUsage is what a tenant uses of its quota
+type TenantUsage struct {
+	Tenant string         `json:"tenant"`
+	Quota  *tenants.Quota `json:"quota,omitempty"`
+	// BackupBytes is the size of the backup archives of the tenant's maps
+	BackupBytes int64 `json:"backup_bytes"`
+	// Servers is how many of the tenant's servers are enabled
+	Servers int `json:"servers"`
+}
+
+var auditSort = sortKeys[rcon.AuditEntry]{
+	"time":    func(a, b rcon.AuditEntry) int { return a.Time.Compare(b.Time) },
+	"map":     func(a, b rcon.AuditEntry) int { return strings.Compare(a.Map, b.Map) },
+	"command": func(a, b rcon.AuditEntry) int { return strings.Compare(a.Command, b.Command) },
+}
+
+// callerScope is what a caller may see and act on: the maps of its tenant,
+// narrowed further by a user's map list. Callers without either see every
+// map.
+type callerScope struct {
+	tenant string
+	user   *users.User
+}
+
+func scopeOf(caller rcon.Caller, user *users.User) callerScope {
+	return callerScope{tenant: caller.Tenant, user: user}
+}
+
+// requestScope returns the scope of a request's caller
+func requestScope(r *http.Request) (callerScope, error) {
+	caller, user, err := identify(r)
+	return scopeOf(caller, user), err
+}
+
+// restricted reports whether the caller is kept from some maps
+func (s callerScope) restricted() bool {
+	return s.tenant != "" || s.user != nil && (s.user.Tenant != "" || len(s.user.Maps) > 0)
+}
+
+func (s callerScope) allows(mapName string) bool {
+	if s.tenant != "" && !tenants.Owns(s.tenant, mapName) {
+		return false
+	}
+	return s.user == nil || s.user.CanAccess(mapName)
+}
+
+// allowsAll reports whether the caller may access every one of maps
+func (s callerScope) allowsAll(maps []string) bool {
+	for _, m := range maps {
+		if !s.allows(m) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkMaps returns an error naming the first of maps the caller may not
+// access
+func (s callerScope) checkMaps(maps []string) error {
+	for _, m := range maps {
+		if !s.allows(m) {
+			return fmt.Errorf("%w %s", errMapAccess, m)
+		}
+	}
+	return nil
+}
+
+// allowsJob reports whether the caller may see a job, jobs without maps
+// are the manager's own
+func (s callerScope) allowsJob(job jobs.Job) bool {
+	if !s.restricted() {
+		return true
+	}
+	return len(job.Maps) > 0 && s.allowsAll(job.Maps)
+}
+
+// scoped returns the items of the maps the caller may access
+func scoped[T any](s callerScope, items []T, mapOf func(T) string) []T {
+	if !s.restricted() {
+		return items
+	}
+	filtered := make([]T, 0, len(items))
+	for _, item := range items {
+		if s.allows(mapOf(item)) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// scopedModes returns the maintenance modes of the maps the caller may
+// access and the global one, which applies to every map
+func scopedModes(s callerScope, modes []maintenance.Mode) []maintenance.Mode {
+	if !s.restricted() {
+		return modes
+	}
+	filtered := make([]maintenance.Mode, 0, len(modes))
+	for _, mode := range modes {
+		if mode.Map == "" || s.allows(mode.Map) {
+			filtered = append(filtered, mode)
+		}
+	}
+	return filtered
+}
+
+// tenantRateLimit returns the tenant of an authenticated caller if the
+// tenant has a rate limit of its own
+func tenantRateLimit(r *http.Request) (tenants.Tenant, bool) {
+	caller, _, err := identify(r)
+	if err != nil || caller.Tenant == "" {
+		return tenants.Tenant{}, false
+	}
+	t, err := tenants.Get(caller.Tenant)
+	if err != nil || t.RateLimit == nil {
+		return tenants.Tenant{}, false
+	}
+	return t, true
+}
+
+// ListTenants lists the tenants and their maps
+func ListTenants(w http.ResponseWriter, r *http.Request) {
+	respondOK(w, map[string]interface{}{"tenants": tenants.List()})
+}
+
+// GetAuditLog returns the RCON audit log, callers of a tenant get the
+// tenant's own log
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	caller, user, err := identify(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	scope := scopeOf(caller, user)
+	mapName := r.URL.Query().Get("map")
+
+	entries, err := rcon.AuditLog(caller.Tenant)
+	if err != nil {
+		logf(r, "Failed to read the RCON audit log: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the audit log")
+		return
+	}
+	filtered := make([]rcon.AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		if scope.allows(entry.Map) && (mapName == "" || entry.Map == mapName) {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	page, info := listPage(w, r, filtered, auditSort, "-time")
+	respondOK(w, map[string]interface{}{"entries": page, "pagination": info})
+}
+
+// GetTenantUsage reports what a tenant uses of its quota. Callers of a
+// tenant only get their own.
+func GetTenantUsage(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	scope, err := requestScope(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	t, err := tenants.Get(name)
+	if err != nil || scope.tenant != "" && scope.tenant != name {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("%v: %s", tenants.ErrUnknownTenant, name))
+		return
+	}
+
+	used, err := backups.BackupUsage(t.Maps)
+	if err != nil {
+		logf(r, "Failed to measure the backups of tenant %s: %v", name, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to measure the backups")
+		return
+	}
+	respondOK(w, map[string]interface{}{"usage": TenantUsage{Tenant: t.Name, Quota: t.Quota, BackupBytes: used, Servers: processes.Enabled(t.Maps)}})
+}
@@ -0,0 +1,319 @@
+package api
+
+import (
+	"asa_servermanager_api/users"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+var userNameParam = param{Name: "name", In: "path", Description: "User name", Required: true, Type: "string"}
+
+// Credentials is the body of a login
+type Credentials struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	// Code is a TOTP or recovery code, required once the user enabled
+	// two-factor authentication
+	Code string `json:"code,omitempty"`
+}
+
+// NewUser is the body of a user creation
+type NewUser struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password"`
+	Role     string   `json:"role"`
+	Maps     []string `json:"maps,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+}
+
+func usersError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, users.ErrInvalidUser):
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	case errors.Is(err, users.ErrUserNotFound):
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, users.ErrInvalidCode):
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	case errors.Is(err, users.ErrUserExists), errors.Is(err, users.ErrLastAdmin),
+		errors.Is(err, users.ErrAlreadyEnrolled), errors.Is(err, users.ErrNotEnrolled), errors.Is(err, users.ErrNotEnrolling):
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+	default:
+		log.Printf("Failed to update users: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Login checks a user's password and starts a session. The token is set as
+// an HTTP-only cookie, which the dashboard sends with every request.
+func Login(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var creds Credentials
+	if !decodeBody(w, r, &creds) {
+		return
+	}
+
+	user, err := users.Authenticate(creds.Name, creds.Password, creds.Code)
+	if err != nil {
+		if errors.Is(err, users.ErrTwoFactorRequired) {
+			respondError(w, http.StatusUnauthorized, ErrCodeTwoFactorRequired, err.Error())
+			return
+		}
+		if errors.Is(err, users.ErrInvalidCredentials) {
+			logf(r, "Failed login for %q from %s", creds.Name, limiter.clientIP(r))
+			respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+			return
+		}
+		usersError(w, err)
+		return
+	}
+	token, session, err := users.NewSession(user.Name)
+	if err != nil {
+		usersError(w, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	logf(r, "User %s logged in from %s", user.Name, limiter.clientIP(r))
+	respondOK(w, map[string]interface{}{"status": "Logged in", "user": user, "expires": session.Expires})
+}
+
+// Logout ends the session of the request, if any
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		if err := users.EndSession(cookie.Value); err != nil {
+			logf(r, "Failed to end session: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	respondOK(w, map[string]interface{}{"status": "Logged out"})
+}
+
+// GetSession describes the caller, the user is only set for session logins
+func GetSession(w http.ResponseWriter, r *http.Request) {
+	caller, user, err := identify(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+	role, err := effectiveRole(caller)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to load permissions")
+		return
+	}
+
+	body := map[string]interface{}{"name": caller.Name, "role": role}
+	if user != nil {
+		enroll, err := users.NeedsEnrollment(*user)
+		if err != nil {
+			usersError(w, err)
+			return
+		}
+		body["user"] = user
+		body["two_factor_required"] = enroll
+	}
+	respondOK(w, body)
+}
+
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	list, err := users.List()
+	if err != nil {
+		usersError(w, err)
+		return
+	}
+	respondOK(w, map[string]interface{}{"users": list})
+}
+
+func CreateUser(w http.ResponseWriter, r *http.Request) {
+	var body NewUser
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	user, err := users.Create(users.User{Name: body.Name, Role: body.Role, Maps: body.Maps, Tenant: body.Tenant}, body.Password)
+	if err != nil {
+		usersError(w, err)
+		return
+	}
+
+	caller, _ := callerFromRequest(r)
+	logf(r, "User %s (%s) created by %s", user.Name, user.Role, caller.Name)
+	respondOK(w, map[string]interface{}{"status": "User created", "user": user})
+}
+
+// UpdateUser changes the role, maps, password, disabled flag or two-factor
+// authentication of a user, omitted fields are kept
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var changes users.Changes
+	if !decodeBody(w, r, &changes) {
+		return
+	}
+	user, err := users.Update(name, changes)
+	if err != nil {
+		usersError(w, err)
+		return
+	}
+
+	caller, _ := callerFromRequest(r)
+	logf(r, "User %s updated by %s", name, caller.Name)
+	respondOK(w, map[string]interface{}{"status": "User updated", "user": user})
+}
+
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := users.Delete(name); err != nil {
+		usersError(w, err)
+		return
+	}
+
+	caller, _ := callerFromRequest(r)
+	logf(r, "User %s deleted by %s", name, caller.Name)
+	respondOK(w, map[string]interface{}{"status": "User deleted", "name": name})
+}
+
+// TwoFactorCode is the body of the two-factor confirmations
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorPolicy lists the roles that must use two-factor authentication
+type TwoFactorPolicy struct {
+	Roles []string `json:"roles"`
+}
+
+// sessionUser returns the logged in user of a request, API keys and
+// anonymous callers have none
+func sessionUser(w http.ResponseWriter, r *http.Request) (*users.User, bool) {
+	_, user, err := identify(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return nil, false
+	}
+	if user == nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "two-factor authentication needs a user login, see /login")
+		return nil, false
+	}
+	return user, true
+}
+
+// BeginTwoFactor creates a TOTP secret for the logged in user, to be added
+// to an authenticator app and confirmed with ConfirmTwoFactor
+func BeginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	enrollment, err := users.BeginEnrollment(user.Name)
+	if err != nil {
+		usersError(w, err)
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Enter a code from your authenticator app to confirm", "enrollment": enrollment})
+}
+
+// ConfirmTwoFactor enables two-factor authentication for the logged in user
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	var body TwoFactorCode
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	codes, err := users.ConfirmEnrollment(user.Name, body.Code)
+	if err != nil {
+		usersError(w, err)
+		return
+	}
+
+	logf(r, "User %s enabled two-factor authentication", user.Name)
+	respondOK(w, map[string]interface{}{"status": "Two-factor authentication enabled, store the recovery codes safely", "recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the logged in user's recovery codes
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	user, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	var body TwoFactorCode
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	codes, err := users.RegenerateRecoveryCodes(user.Name, body.Code)
+	if err != nil {
+		usersError(w, err)
+		return
+	}
+	respondOK(w, map[string]interface{}{"status": "Recovery codes replaced", "recovery_codes": codes})
+}
+
+func GetTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	roles, err := users.TwoFactorRoles()
+	if err != nil {
+		usersError(w, err)
+		return
+	}
+	respondOK(w, map[string]interface{}{"policy": TwoFactorPolicy{Roles: roles}})
+}
+
+// SetTwoFactorPolicy sets the roles that must use two-factor
+// authentication, their users can only enroll until they do
+func SetTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy TwoFactorPolicy
+	if !decodeBody(w, r, &policy) {
+		return
+	}
+	if err := users.SetTwoFactorRoles(policy.Roles); err != nil {
+		usersError(w, err)
+		return
+	}
+
+	caller, _ := callerFromRequest(r)
+	logf(r, "Two-factor authentication required for roles %v by %s", policy.Roles, caller.Name)
+	respondOK(w, map[string]interface{}{"status": "Two-factor policy updated", "policy": policy})
+}
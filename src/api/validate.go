@@ -0,0 +1,166 @@
+package api
+
+import (
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	maxMapNameLength  = 64
+	maxCommandLength  = 256
+	maxFileNameLength = 255
+	maxFilePathLength = 1024
+)
+
+var (
+	mapNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	drivePattern   = regexp.MustCompile(`^[A-Za-z]:`)
+
+	errUnknownMap = errors.New("unknown map")
+
+	knownMaps   = make(map[string]bool)
+	knownMapsMu sync.RWMutex
+)
+
+// loadKnownMaps collects the maps of the process, backup and RCON configs.
+// Map parameters naming none of them are rejected before reaching a handler.
+func loadKnownMaps(processConfigFile string) {
+	maps := make(map[string]bool)
+
+	configs, err := processmanager.LoadProcessConfigs(processConfigFile)
+	if err != nil {
+		log.Printf("Failed to load process config for validation: %v", err)
+	}
+	for _, config := range configs {
+		maps[config.Map] = true
+	}
+
+	if backups != nil {
+		for _, mapName := range backups.Maps() {
+			maps[mapName] = true
+		}
+	}
+
+	infos, err := rcon.LoadRconInfos()
+	if err != nil {
+		log.Printf("Failed to load rcon config for validation: %v", err)
+	}
+	for _, info := range infos {
+		maps[info.Map] = true
+	}
+
+	knownMapsMu.Lock()
+	knownMaps = maps
+	knownMapsMu.Unlock()
+}
+
+func validateMapName(value string) error {
+	if len(value) > maxMapNameLength || !mapNamePattern.MatchString(value) {
+		return fmt.Errorf("map name must be 1-%d letters, digits, '-' or '_'", maxMapNameLength)
+	}
+
+	knownMapsMu.RLock()
+	defer knownMapsMu.RUnlock()
+	if !knownMaps[value] {
+		return fmt.Errorf("%w: %s", errUnknownMap, value)
+	}
+	return nil
+}
+
+func validateCommand(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("command must not be blank")
+	}
+	if utf8.RuneCountInString(value) > maxCommandLength {
+		return fmt.Errorf("command must not be longer than %d characters", maxCommandLength)
+	}
+	return nil
+}
+
+// validateArchiveName only accepts bare file names, archives are always
+// looked up in the map's backup directory
+func validateArchiveName(value string) error {
+	if len(value) > maxFileNameLength {
+		return fmt.Errorf("archive name must not be longer than %d characters", maxFileNameLength)
+	}
+	if strings.ContainsAny(value, "/\\\x00") || value == "." || value == ".." || drivePattern.MatchString(value) {
+		return errors.New("archive name must be a file name without a path")
+	}
+	return nil
+}
+
+// validateFilePath accepts relative paths inside an archive that cannot
+// escape the directory they are restored to
+func validateFilePath(value string) error {
+	if len(value) > maxFilePathLength {
+		return fmt.Errorf("file path must not be longer than %d characters", maxFilePathLength)
+	}
+	if strings.ContainsRune(value, 0) {
+		return errors.New("file path contains invalid characters")
+	}
+	if strings.HasPrefix(value, "/") || strings.HasPrefix(value, "\\") || drivePattern.MatchString(value) {
+		return errors.New("file path must be relative")
+	}
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return errors.New("file path must not contain '..'")
+		}
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if value != "true" && value != "false" {
+		return errors.New("must be true or false")
+	}
+	return nil
+}
+
+func validatePositiveInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+		return errors.New("must be a positive whole number")
+	}
+	return nil
+}
+
+// validateMiddleware checks the query and path parameters of a request
+// against the route's declared parameters before calling the handler
+func validateMiddleware(rt route, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for _, p := range rt.Params {
+			value := query.Get(p.Name)
+			if p.In == "path" {
+				value = r.PathValue(p.Name)
+			}
+			if value == "" {
+				if p.Required {
+					respondError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("missing required parameter %q", p.Name))
+					return
+				}
+				continue
+			}
+			if p.Validate == nil {
+				continue
+			}
+			if err := p.Validate(value); err != nil {
+				if errors.Is(err, errUnknownMap) || errors.Is(err, errUnknownCluster) {
+					respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+					return
+				}
+				respondError(w, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("invalid parameter %q: %v", p.Name, err))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
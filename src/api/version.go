@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersionPrefix is the namespace of the current API. Routes are also
+// served at their old, unversioned paths until those are removed.
+const apiVersionPrefix = "/v1"
+
+var (
+	// unversionedDeprecated is when the unversioned paths and the query
+	// endpoints were deprecated
+	unversionedDeprecated = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	// unversionedSunset is when they are removed, see
+	// ServerConfig.LegacySunset
+	unversionedSunset time.Time
+)
+
+// versionedRoutes moves the routes into the /v1 namespace and keeps a
+// deprecated copy at their old paths. Legacy query endpoints are not part
+// of /v1, they stay at their paths and point to their RESTful successor in
+// /v1.
+func versionedRoutes(routes []route) []route {
+	versioned := make([]route, 0, 2*len(routes))
+	for _, rt := range routes {
+		if rt.Legacy {
+			method, path, _ := strings.Cut(rt.Successor, " ")
+			rt.Successor = method + " " + apiVersionPrefix + path
+			versioned = append(versioned, rt)
+			continue
+		}
+		current := rt
+		current.Path = apiVersionPrefix + rt.Path
+		rt.Unversioned = true
+		rt.Successor = rt.Method + " " + current.Path
+		versioned = append(versioned, current, rt)
+	}
+	return versioned
+}
+
+// deprecationMiddleware marks the responses of a deprecated route with
+// the Deprecation and Sunset headers and links the request's successor
+func deprecationMiddleware(rt route, next http.HandlerFunc) http.HandlerFunc {
+	if rt.Successor == "" {
+		return next
+	}
+	_, successor, _ := strings.Cut(rt.Successor, " ")
+	deprecation := "@" + strconv.FormatInt(unversionedDeprecated.Unix(), 10)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", deprecation)
+		w.Header().Set("Sunset", unversionedSunset.Format(http.TimeFormat))
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successorURL(successor, r)))
+		next(w, r)
+	}
+}
+
+// successorURL fills the wildcards of a successor path with the request's
+// path values, or with its query parameters of the same name, which are
+// then left out of the query
+func successorURL(pattern string, r *http.Request) string {
+	query := r.URL.Query()
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") {
+			continue
+		}
+		name, rest := strings.CutSuffix(strings.Trim(segment, "{}"), "...")
+		value := r.PathValue(name)
+		if value == "" {
+			value = query.Get(name)
+			query.Del(name)
+		}
+		if !rest {
+			value = url.PathEscape(value)
+		}
+		segments[i] = value
+	}
+	path := strings.Join(segments, "/")
+	if encoded := query.Encode(); encoded != "" {
+		path += "?" + encoded
+	}
+	return path
+}
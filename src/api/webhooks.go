@@ -0,0 +1,109 @@
+package api
+
+import (
+	"asa_servermanager_api/notify"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+var (
+	webhooks_conf = "config/webhooks_config.json"
+
+	webhookNameParam = param{Name: "name", In: "path", Description: "Webhook name", Required: true, Type: "string"}
+)
+
+func webhooksError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, notify.ErrInvalidWebhook):
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+	case errors.Is(err, notify.ErrWebhookNotFound):
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, notify.ErrWebhookExists):
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+	default:
+		log.Printf("Failed to save webhooks: %v", err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
+
+func decodeWebhook(w http.ResponseWriter, r *http.Request) (notify.Webhook, bool) {
+	var hook notify.Webhook
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&hook); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return notify.Webhook{}, false
+	}
+	return hook, true
+}
+
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	respondOK(w, map[string]interface{}{"webhooks": notify.Webhooks()})
+}
+
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+	created, err := notify.CreateWebhook(hook)
+	if err != nil {
+		webhooksError(w, err)
+		return
+	}
+
+	logf(r, "Created webhook %s", hook.Name)
+	respondOK(w, map[string]interface{}{"status": "Webhook created", "webhook": created})
+}
+
+// UpdateWebhook replaces a webhook, the name in the body must match the
+// path and an omitted secret keeps the current one
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	hook, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+	if hook.Name == "" {
+		hook.Name = name
+	}
+	if hook.Name != name {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "a webhook cannot be renamed, delete and create it instead")
+		return
+	}
+	updated, err := notify.UpdateWebhook(hook)
+	if err != nil {
+		webhooksError(w, err)
+		return
+	}
+
+	logf(r, "Updated webhook %s", hook.Name)
+	respondOK(w, map[string]interface{}{"status": "Webhook updated", "webhook": updated})
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	if err := notify.DeleteWebhook(name); err != nil {
+		webhooksError(w, err)
+		return
+	}
+
+	logf(r, "Deleted webhook %s", name)
+	respondOK(w, map[string]interface{}{"status": "Webhook deleted", "webhook": name})
+}
+
+func WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("webhook")
+
+	deliveries, err := notify.Deliveries(name)
+	if err != nil {
+		webhooksError(w, err)
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"webhook": name, "deliveries": deliveries})
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/whitelist"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// WhitelistChange is the body of POST and DELETE /whitelist
+type WhitelistChange struct {
+	IDs []string `json:"ids"`
+}
+
+// WhitelistResult is the outcome of a whitelist change on one map
+type WhitelistResult struct {
+	Map string `json:"map"`
+	// Count is the number of IDs in the list after the change
+	Count int `json:"count"`
+	// Reloaded is set when the running server applied the change over
+	// RCON, otherwise it takes effect on the next start
+	Reloaded bool   `json:"reloaded"`
+	Error    string `json:"error,omitempty"`
+}
+
+var (
+	whitelistMapParam     = param{Name: "map", Description: "Map whose list to change, either map or cluster is required", Type: "string", Validate: validateMapName}
+	whitelistClusterParam = param{Name: "cluster", Description: "Change the lists of every map of this cluster", Type: "string", Validate: validateClusterName}
+)
+
+func whitelistPath(mapName string) (string, bool) {
+	config, ok := processes.Config(mapName)
+	if !ok {
+		return "", false
+	}
+	return whitelist.Path(config.Executable), true
+}
+
+func GetWhitelist(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	path, ok := whitelistPath(mapName)
+	if !ok {
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, "no process configuration for map "+mapName)
+		return
+	}
+	ids, err := whitelist.Read(path)
+	if err != nil {
+		logf(r, "Failed to read whitelist of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the whitelist")
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"map": mapName, "ids": ids})
+}
+
+// AddToWhitelist and RemoveFromWhitelist change the exclusive join list of
+// a map or of all maps of a cluster
+func AddToWhitelist(w http.ResponseWriter, r *http.Request) {
+	changeWhitelist(w, r, true)
+}
+
+func RemoveFromWhitelist(w http.ResponseWriter, r *http.Request) {
+	changeWhitelist(w, r, false)
+}
+
+func changeWhitelist(w http.ResponseWriter, r *http.Request, add bool) {
+	mapName := r.URL.Query().Get("map")
+	clusterName := r.URL.Query().Get("cluster")
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	var change WhitelistChange
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&change); err != nil {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if len(change.IDs) == 0 {
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "ids must not be empty")
+		return
+	}
+	ids := make([]string, len(change.IDs))
+	for i, id := range change.IDs {
+		if ids[i], err = whitelist.NormalizeID(id); err != nil {
+			respondError(w, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+			return
+		}
+	}
+
+	var maps []string
+	switch {
+	case mapName != "" && clusterName != "":
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "map and cluster are mutually exclusive")
+		return
+	case mapName != "":
+		maps = []string{mapName}
+	case clusterName != "":
+		// validateClusterName already rejected unknown clusters
+		maps, _ = clusters.Maps(clusterName)
+	default:
+		respondError(w, http.StatusBadRequest, ErrCodeBadRequest, "map or cluster is required")
+		return
+	}
+
+	// AllowPlayerToJoinNoCheck and DisallowPlayerToJoinNoCheck change the
+	// list of a running server without a restart
+	command := "DisallowPlayerToJoinNoCheck "
+	if add {
+		command = "AllowPlayerToJoinNoCheck "
+	}
+
+	results := make([]WhitelistResult, 0, len(maps))
+	for _, m := range maps {
+		result := WhitelistResult{Map: m}
+		path, ok := whitelistPath(m)
+		if !ok {
+			result.Error = "no process configuration"
+			results = append(results, result)
+			continue
+		}
+
+		var list []string
+		if add {
+			list, err = whitelist.Update(path, ids, nil)
+		} else {
+			list, err = whitelist.Update(path, nil, ids)
+		}
+		if err != nil {
+			logf(r, "Failed to update whitelist of %s: %v", m, err)
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Count = len(list)
+
+		if ms, ok := processes.State(m); ok && ms.Actual == processmanager.ActualRunning {
+			result.Reloaded = true
+			for _, id := range ids {
+				if _, err := rcon.ExecuteAs(caller, m, command+id); err != nil {
+					result.Reloaded = false
+					result.Error = err.Error()
+					if errors.Is(err, rcon.ErrCommandDenied) {
+						break
+					}
+				}
+			}
+		}
+		results = append(results, result)
+	}
+
+	status := "Players removed from the whitelist"
+	if add {
+		status = "Players added to the whitelist"
+	}
+	respondOK(w, map[string]interface{}{"status": status, "maps": results})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/wipe"
+	"errors"
+	"net/http"
+)
+
+var (
+	wipe_conf = "config/wipe_config.json"
+
+	wipes *wipe.Wiper
+)
+
+func GetWipeStatus(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+
+	status, err := wipes.Status(mapName)
+	if err != nil {
+		logf(r, "Failed to read wipe history of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to read the wipe history")
+		return
+	}
+
+	respondOK(w, map[string]interface{}{"wipe": status})
+}
+
+// TriggerWipe destroys the wild dinos of a map, after the in-game warnings
+// unless warn is false
+func TriggerWipe(w http.ResponseWriter, r *http.Request) {
+	mapName := r.URL.Query().Get("map")
+	warn := r.URL.Query().Get("warn") != "false"
+
+	caller, err := callerFromRequest(r)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	if isDryRun(r) {
+		plan, err := wipes.Plan(caller, mapName, warn)
+		if err != nil {
+			wipeError(w, r, mapName, err)
+			return
+		}
+		respondPlan(w, plan)
+		return
+	}
+
+	at, err := wipes.Trigger(caller, mapName, warn)
+	if err != nil {
+		wipeError(w, r, mapName, err)
+		return
+	}
+
+	status := "Wild dinos wiped"
+	if warn {
+		status = "Wild dino wipe scheduled"
+	}
+	respondOK(w, map[string]interface{}{"status": status, "map": mapName, "at": at})
+}
+
+// wipeError responds with the error of a failed wipe
+func wipeError(w http.ResponseWriter, r *http.Request, mapName string, err error) {
+	switch {
+	case errors.Is(err, processmanager.ErrMapNotFound):
+		respondError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	case errors.Is(err, rcon.ErrCommandDenied):
+		respondError(w, http.StatusForbidden, ErrCodeForbidden, err.Error())
+	case errors.Is(err, wipe.ErrInProgress), errors.Is(err, wipe.ErrNotRunning):
+		respondError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+	case errors.Is(err, rcon.ErrRequestFailed), errors.Is(err, wipe.ErrNoEffect):
+		respondError(w, http.StatusBadGateway, ErrCodeBadGateway, err.Error())
+	default:
+		logf(r, "Failed to wipe wild dinos of %s: %v", mapName, err)
+		respondError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+	}
+}
@@ -0,0 +1,260 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	FormatZip    = "zip"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+	FormatDedup  = "dedup"
+)
+
+var archiveFormats = []string{FormatZip, FormatTarGz, FormatTarZst, FormatDedup}
+
+// archiveWriter adds files to a backup archive of any supported format
+type archiveWriter interface {
+	Add(name string, info os.FileInfo, r io.Reader) (int64, error)
+	Close() error
+}
+
+// archiveFormat normalizes a configured format, defaulting to zip
+func archiveFormat(format string) string {
+	for _, f := range archiveFormats {
+		if strings.EqualFold(format, f) {
+			return f
+		}
+	}
+	return FormatZip
+}
+
+// isArchive reports whether a file name is a backup archive
+func isArchive(name string) bool {
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, "."+f) {
+			return true
+		}
+	}
+	return false
+}
+
+// reserveArchive creates an empty archive of the map in dir, named
+// <map>_<timestamp>_<kind>.<format>, and returns its path. The name is
+// taken exclusively, so backups started within the same second get a
+// numbered suffix instead of overwriting each other.
+func reserveArchive(dir string, mapName string, kind string, format string) (string, error) {
+	stem := fmt.Sprintf("%s_%s_%s", mapName, time.Now().Format("20060102_150405"), kind)
+	for n := 1; n <= 100; n++ {
+		name := stem
+		if n > 1 {
+			name = fmt.Sprintf("%s_%d", stem, n)
+		}
+		archivePath := filepath.Join(dir, name+"."+format)
+		file, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create archive: %w", err)
+		}
+		return archivePath, file.Close()
+	}
+	return "", fmt.Errorf("failed to create archive: too many archives named %s", stem)
+}
+
+// findArchives returns the paths of the map's backup archives in dir. Maps
+// may share a directory, so archives of other maps are left out.
+func findArchives(dir string, mapName string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	owns := isMapArchive(mapName)
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isArchive(entry.Name()) && owns(entry.Name()) {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return paths, nil
+}
+
+func newArchiveWriter(format string, level int, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case FormatTarGz:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip compression level %d: %w", level, err)
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case FormatTarZst:
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(encoderLevel))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	default:
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return nil, fmt.Errorf("invalid zip compression level %d", level)
+		}
+		zw := zip.NewWriter(w)
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+		return &zipArchiveWriter{zw: zw}, nil
+	}
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) Add(name string, info os.FileInfo, r io.Reader) (int64, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create zip header: %w", err)
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create entry in zip file: %w", err)
+	}
+	return io.Copy(w, r)
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchiveWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (a *tarArchiveWriter) Add(name string, info os.FileInfo, r io.Reader) (int64, error) {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create tar header: %w", err)
+	}
+	header.Name = name
+
+	if err := a.tw.WriteHeader(header); err != nil {
+		return 0, fmt.Errorf("failed to create entry in tar file: %w", err)
+	}
+	// The header announces the size, so never write more than that even if
+	// the file grows while it is being archived.
+	return io.Copy(a.tw, io.LimitReader(r, info.Size()))
+}
+
+func (a *tarArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		a.compressor.Close()
+		return err
+	}
+	return a.compressor.Close()
+}
+
+// walkArchive calls fn for every regular file in an archive, in order.
+// Encrypted archives are decrypted on the fly.
+func walkArchive(archivePath string, fn func(name string, modTime time.Time, r io.Reader) error) error {
+	if !isArchive(archivePath) {
+		return fmt.Errorf("unsupported archive format: %s", filepath.Base(archivePath))
+	}
+	if strings.HasSuffix(archivePath, "."+FormatDedup) {
+		return walkDedup(archivePath, func(entry ManifestEntry, r io.Reader) error {
+			return fn(entry.Name, entry.ModTime, r)
+		})
+	}
+	file, content, size, err := openArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.HasSuffix(archivePath, "."+FormatZip) {
+		reader, err := zip.NewReader(content, size)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range reader.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			err = fn(f.Name, f.Modified, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	compressed := io.NewSectionReader(content, 0, size)
+	var decompressed io.Reader
+	if strings.HasSuffix(archivePath, "."+FormatTarGz) {
+		gz, err := gzip.NewReader(compressed)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		decompressed = gz
+	} else {
+		zr, err := zstd.NewReader(compressed)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		decompressed = zr
+	}
+
+	tr := tar.NewReader(decompressed)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, header.ModTime, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -1,19 +1,46 @@
 package backup
 
 import (
-	"archive/zip"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"asa_servermanager_api/broadcast"
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/savegame"
+	"asa_servermanager_api/state"
 )
 
 // BackupConfig defines the configuration for backups
 type BackupConfig struct {
 	Maps map[string]MapConfig `json:"maps"`
+
+	// MaxParallel limits how many backups run at the same time, default 1
+	MaxParallel int `json:"max_parallel"`
+
+	DiskGuard DiskGuardConfig `json:"disk_guard"`
+
+	Encryption EncryptionConfig `json:"encryption"`
+
+	// DedupDir is the blob store of dedup archives, default
+	// data/backup_store
+	DedupDir string `json:"dedup_dir,omitempty"`
+
+	// StagingDir holds restores made for inspection, default
+	// data/restore_staging
+	StagingDir string `json:"staging_dir,omitempty"`
+
+	Snapshots SnapshotConfig `json:"snapshots"`
 }
 
 type MapConfig struct {
@@ -23,36 +50,165 @@ type MapConfig struct {
 	SpecificFiles   []string `json:"specific_files"`
 	IntervalMinutes int      `json:"interval_minutes"`
 	RetentionDays   int      `json:"retention_days"`
+	FullBackupHours int      `json:"full_backup_hours"`
+	MaxBackups      int      `json:"max_backups"`
+	MaxTotalSizeMB  int64    `json:"max_total_size_mb"`
+
+	// Cron schedules the backups with a cron expression such as
+	// "0 4 * * *" instead of every IntervalMinutes. It is evaluated in
+	// Timezone, an IANA name like "Europe/Berlin", by default local time.
+	Cron     string `json:"cron,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+
+	// Format is "zip" (default), "tar.gz", "tar.zst" or "dedup", which
+	// stores each distinct file once in the blob store shared by all maps.
+	// CompressionLevel is format specific, 0 selects the format's default.
+	Format           string `json:"format,omitempty"`
+	CompressionLevel int    `json:"compression_level,omitempty"`
+
+	Hooks *HookConfig `json:"hooks,omitempty"`
+
+	RemoteTargets []StorageConfig `json:"remote_targets,omitempty"`
 }
 
+var (
+	ErrMapNotConfigured   = errors.New("no configuration found for map")
+	ErrScheduleNotRunning = errors.New("no running backup schedule for map")
+	ErrNoRestorePoint     = errors.New("no complete backup")
+)
+
 type BackupManager struct {
 	config     BackupConfig
 	configFile string
-	schedulers map[string]*time.Ticker
+	schedulers map[string]*backupSchedule
+	queue      *jobQueue
 	mu         sync.Mutex
+
+	// Starting reports whether a map's server is still loading. Backups of
+	// it skip the RCON hooks, the server neither answers nor has changed
+	// its save yet.
+	Starting func(mapName string) bool
+	// Environment returns the build, mods, arguments and key settings of a
+	// map's server, recorded in the manifests
+	Environment func(mapName string) *Environment
 }
 
 func NewBackupManager(configFile string) (*BackupManager, error) {
 	bm := &BackupManager{
 		configFile: configFile,
-		schedulers: make(map[string]*time.Ticker),
+		schedulers: make(map[string]*backupSchedule),
 	}
 	err := bm.loadConfig()
 	if err != nil {
 		return nil, err
 	}
+	bm.queue = newJobQueue(bm.config.MaxParallel)
 	return bm, nil
 }
 
-func (bm *BackupManager) loadConfig() error {
-	file, err := os.Open(bm.configFile)
+// mapConfig returns the backup configuration of a map
+func (bm *BackupManager) mapConfig(mapName string) (MapConfig, bool) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	config, ok := bm.config.Maps[mapName]
+	return config, ok
+}
+
+// SaveDir returns the directory a map's saves are backed up from
+func (bm *BackupManager) SaveDir(mapName string) (string, bool) {
+	config, ok := bm.mapConfig(mapName)
+	return config.ExtractDir, ok
+}
+
+// Maps returns the names of the maps with a backup configuration
+func (bm *BackupManager) Maps() []string {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	maps := make([]string, 0, len(bm.config.Maps))
+	for mapName := range bm.config.Maps {
+		maps = append(maps, mapName)
+	}
+	return maps
+}
+
+// RegisterMap adds a map's backup configuration and persists it
+func (bm *BackupManager) RegisterMap(mapName string, config MapConfig) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if _, exists := bm.config.Maps[mapName]; exists {
+		return fmt.Errorf("backup configuration for map %s already exists", mapName)
+	}
+	if err := config.ValidateSchedule(); err != nil {
+		return err
+	}
+	if bm.config.Maps == nil {
+		bm.config.Maps = make(map[string]MapConfig)
+	}
+	err := bm.updateConfigFile(func(file *BackupConfig) {
+		if file.Maps == nil {
+			file.Maps = make(map[string]MapConfig)
+		}
+		file.Maps[mapName] = config
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	bm.config.Maps[mapName] = config
+	return nil
+}
+
+// UnregisterMap stops a map's backup schedule and removes its backup
+// configuration. Existing archives are kept.
+func (bm *BackupManager) UnregisterMap(mapName string) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if _, exists := bm.config.Maps[mapName]; !exists {
+		return nil
+	}
+	if schedule, ok := bm.schedulers[mapName]; ok {
+		close(schedule.stop)
+		delete(bm.schedulers, mapName)
+	}
+
+	err := bm.updateConfigFile(func(file *BackupConfig) {
+		delete(file.Maps, mapName)
+	})
+	if err != nil {
+		return err
+	}
+	delete(bm.config.Maps, mapName)
+	if err := state.Delete(state.BucketBackupSchedules, mapName); err != nil {
+		log.Printf("Failed to remove backup schedule state of %s: %v", mapName, err)
+	}
+	return nil
+}
+
+// loadConfig reads the backup config, ASA_BACKUP_* variables override it
+func (bm *BackupManager) loadConfig() error {
+	if err := configfile.Load(bm.configFile, "ASA_BACKUP", &bm.config); err != nil {
+		return err
+	}
+	for mapName, config := range bm.config.Maps {
+		if err := config.ValidateSchedule(); err != nil {
+			return fmt.Errorf("backup schedule of map %s: %w", mapName, err)
+		}
+	}
+	return loadKeys(bm.config.Encryption)
+}
 
-	decoder := json.NewDecoder(file)
-	return decoder.Decode(&bm.config)
+// updateConfigFile rewrites the backup config file as read, without the
+// environment overrides, so they never end up in the file
+func (bm *BackupManager) updateConfigFile(fn func(*BackupConfig)) error {
+	var file BackupConfig
+	if err := configfile.Read(bm.configFile, &file); err != nil {
+		return fmt.Errorf("failed to read backup config: %w", err)
+	}
+	fn(&file)
+	return configfile.Save(bm.configFile, file)
 }
 
 func (bm *BackupManager) StartBackupSchedule(mapName string) error {
@@ -61,182 +217,382 @@ func (bm *BackupManager) StartBackupSchedule(mapName string) error {
 
 	config, ok := bm.config.Maps[mapName]
 	if !ok {
-		return fmt.Errorf("no configuration found for map: %s", mapName)
+		return fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
 	}
 
 	// Mark the map as having an active backup schedule
-	saveFilePath := fmt.Sprintf("./data/%s.save", mapName)
-	err := os.WriteFile(saveFilePath, []byte("true"), 0644)
+	err := state.SetScheduleEnabled(mapName, true)
 	if err != nil {
-		return fmt.Errorf("failed to write active schedule file: %w", err)
+		return fmt.Errorf("failed to persist active schedule: %w", err)
 	}
 
-	bm.startNewBackup(mapName, config)
+	if _, running := bm.schedulers[mapName]; running {
+		return nil
+	}
+
+	// Interval schedules back up right away
+	next := time.Now()
+	if config.Cron != "" {
+		if next, err = config.nextRun(next); err != nil {
+			return err
+		}
+	}
+	schedule := &backupSchedule{stop: make(chan struct{}), next: next}
+	bm.schedulers[mapName] = schedule
+	go bm.runSchedule(mapName, config, schedule)
 	return nil
 }
 
-func (bm *BackupManager) resumeBackup(mapName string, config MapConfig, lastBackupFile string) {
-	ticker := time.NewTicker(time.Duration(config.IntervalMinutes) * time.Minute)
-	bm.schedulers[mapName] = ticker
-
-	go func() {
-		for range ticker.C {
-			bm.IncrementalBackup(mapName, config)
+// scheduledBackup queues a backup of the schedule, unless the schedule is
+// paused, the map is in maintenance or its tenant's quota is used up.
+// Skipped backups are recorded in the job history.
+func (bm *BackupManager) scheduledBackup(mapName string) {
+	reason := ""
+	if schedule, err := state.Schedule(mapName); err != nil {
+		log.Printf("Failed to read schedule state for %s: %v", mapName, err)
+	} else if schedule.Paused {
+		reason = "backup schedule is paused"
+	}
+	if reason == "" && maintenance.Active(mapName) {
+		reason = "map is in maintenance"
+	}
+	if reason == "" {
+		if err := bm.checkQuota(mapName); err != nil {
+			reason = err.Error()
 		}
-	}()
+	}
+	if reason != "" {
+		log.Printf("Skipping the scheduled backup of map '%s': %s", mapName, reason)
+		bm.queue.skip(mapName, reason)
+		return
+	}
+	bm.QueueBackup(mapName, false)
+}
+
+// IncrementalBackup archives the files that changed since the previous backup
+// of the map, or starts a new chain with a full backup when one is due.
+func (bm *BackupManager) IncrementalBackup(mapName string, config MapConfig) error {
+	_, err := bm.runBackup(mapName, config, false, nil)
+	return err
 }
 
-func (bm *BackupManager) startNewBackup(mapName string, config MapConfig) {
-	ticker := time.NewTicker(time.Duration(config.IntervalMinutes) * time.Minute)
-	bm.schedulers[mapName] = ticker
+func (bm *BackupManager) runBackup(mapName string, config MapConfig, forceFull bool, progress *progressTracker) (string, error) {
+	starting := bm.Starting != nil && bm.Starting(mapName)
+	if starting {
+		log.Printf("Server of map %s is still loading, backing up without RCON hooks", mapName)
+	} else if err := runPreBackupHooks(mapName, config); err != nil {
+		if config.Hooks.AbortOnFailure {
+			return "", fmt.Errorf("backup of map %s aborted: %w", mapName, err)
+		}
+		log.Printf("Pre-backup hooks for map %s failed, backing up anyway: %v", mapName, err)
+	}
 
-	go func() {
-		bm.IncrementalBackup(mapName, config)
-		for range ticker.C {
-			bm.IncrementalBackup(mapName, config)
+	// Directory backups, such as a cluster's, have no server to tell
+	if _, isMap := bm.mapConfig(mapName); isMap && !starting {
+		if err := broadcast.Send(broadcast.EventBackup, mapName, broadcast.Vars{}); err != nil {
+			log.Printf("Failed to announce backup of map %s: %v", mapName, err)
 		}
-	}()
+	}
+	zipFilePath, err := bm.createBackup(mapName, config, forceFull, progress)
+	if err != nil {
+		return "", err
+	}
+
+	runPostBackupHooks(mapName, config, zipFilePath, !starting)
+	if zipFilePath == "" {
+		return "", nil
+	}
+
+	// Uploads can take a long time, so they run without holding the lock
+	if len(config.RemoteTargets) > 0 {
+		progress.setPhase(PhaseUploading)
+	}
+	bm.replicate(mapName, config, zipFilePath)
+	return zipFilePath, nil
 }
 
-func (bm *BackupManager) IncrementalBackup(mapName string, config MapConfig) error {
-	bm.mu.Lock()
-	defer bm.mu.Unlock()
+func (bm *BackupManager) createBackup(mapName string, config MapConfig, forceFull bool, progress *progressTracker) (string, error) {
+	// Backups wait for other operations on the map, such as an update
+	release, err := maplock.Acquire(context.Background(), mapName, maplock.OpBackup)
+	if err != nil {
+		return "", err
+	}
+	defer release()
 
-	timestamp := time.Now().Format("20060102_150405")
-	zipFileName := fmt.Sprintf("%s_%s.zip", mapName, timestamp)
-	zipFilePath := filepath.Join(config.ZipDir, zipFileName)
+	chain, err := loadChainState(mapName)
+	if err != nil {
+		return "", err
+	}
+	full := forceFull || needsFullBackup(config, chain)
 
-	zipFile, err := os.Create(zipFilePath)
+	files, err := collectBackupFiles(config)
 	if err != nil {
-		return fmt.Errorf("failed to create zip file: %w", err)
+		return "", err
 	}
-	defer zipFile.Close()
+	progress.setScanned(len(files))
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+	changed, deleted, err := changedFiles(config, files, chain, full)
+	if err != nil {
+		return "", err
+	}
+	if !full && len(changed) == 0 && len(deleted) == 0 {
+		log.Printf("No changes since last backup of map %s, skipping", mapName)
+		return "", saveChainState(mapName, chain)
+	}
 
-	for _, ext := range config.FileExtensions {
-		err := filepath.Walk(config.ExtractDir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() && filepath.Ext(info.Name()) == ext {
-				err := bm.addFileToZip(zipWriter, path)
-				if err != nil {
-					return err
-				}
-			}
-			return nil
-		})
-		if err != nil {
-			return fmt.Errorf("failed to add files with extension %s to zip: %w", ext, err)
+	var sourceBytes int64
+	for _, filePath := range changed {
+		if info, err := os.Stat(filePath); err == nil {
+			sourceBytes += info.Size()
 		}
 	}
+	if err := bm.ensureSpace(mapName, config, sourceBytes); err != nil {
+		return "", err
+	}
 
-	for _, file := range config.SpecificFiles {
-		filePath := filepath.Join(config.ExtractDir, file)
-		if _, err := os.Stat(filePath); err == nil {
-			err := bm.addFileToZip(zipWriter, filePath)
-			if err != nil {
-				return fmt.Errorf("failed to add specific file %s to zip: %w", file, err)
-			}
-		}
+	backupType := BackupTypeIncremental
+	if full {
+		backupType = BackupTypeFull
+		chain.Files = make(map[string]FileState)
 	}
 
-	lastBackupFile := fmt.Sprintf("./data/%s_saved.txt", mapName)
-	err = os.WriteFile(lastBackupFile, []byte(timestamp), 0644)
+	format := archiveFormat(config.Format)
+	zipFilePath, err := reserveArchive(config.ZipDir, mapName, backupType, format)
 	if err != nil {
-		return fmt.Errorf("failed to write last backup timestamp: %w", err)
+		return "", err
 	}
+	zipFileName := filepath.Base(zipFilePath)
 
-	// Call RemoveOldBackups after creating the new backup
-	err = bm.RemoveOldBackups(mapName, config)
+	entries, keyID, err := bm.writeArchive(zipFilePath, format, config, changed, progress)
 	if err != nil {
-		return fmt.Errorf("failed to remove old backups: %w", err)
+		os.Remove(zipFilePath)
+		return "", err
 	}
 
-	return nil
-}
+	manifest := &Manifest{Map: mapName, Type: backupType, Files: entries, Deleted: deleted, KeyID: keyID}
+	if !full {
+		manifest.Base = chain.BaseArchive
+		manifest.Parent = chain.LastArchive
+	}
+	if info, err := savegame.Read(config.ExtractDir); err == nil {
+		manifest.Save = &info
+	} else if !errors.Is(err, savegame.ErrNoSave) {
+		log.Printf("Failed to read save info of map %s: %v", mapName, err)
+	}
+	manifest.Environment = bm.environment(mapName)
+	if err := writeManifest(zipFilePath, manifest); err != nil {
+		return "", err
+	}
 
-func (bm *BackupManager) addFileToZip(zipWriter *zip.Writer, filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+	// Only advance the chain once the archive is complete
+	for _, entry := range entries {
+		chain.Files[entry.Name] = FileState{Size: entry.Size, ModTime: entry.ModTime, SHA256: entry.SHA256}
+	}
+	for _, name := range deleted {
+		delete(chain.Files, name)
+	}
+	if full {
+		chain.BaseArchive = zipFileName
+		chain.LastFull = manifest.Created
+	}
+	chain.LastArchive = zipFileName
+	if err := saveChainState(mapName, chain); err != nil {
+		return "", err
 	}
-	defer file.Close()
 
-	w, err := zipWriter.Create(filepath.Base(filePath))
+	err = state.SetLastBackup(mapName, manifest.Created)
 	if err != nil {
-		return fmt.Errorf("failed to create entry in zip file: %w", err)
+		return "", fmt.Errorf("failed to write last backup timestamp: %w", err)
 	}
 
-	_, err = io.Copy(w, file)
+	// Call RemoveOldBackups after creating the new backup
+	err = bm.RemoveOldBackups(mapName, config)
 	if err != nil {
-		return fmt.Errorf("failed to write file to zip: %w", err)
+		return "", fmt.Errorf("failed to remove old backups: %w", err)
 	}
 
-	return nil
+	return zipFilePath, nil
 }
 
-func (bm *BackupManager) RemoveOldBackups(mapName string, config MapConfig) error {
-	retentionDuration := time.Duration(config.RetentionDays) * 24 * time.Hour
-	now := time.Now()
+// collectBackupFiles returns every file under ExtractDir matching one of the
+// configured extensions plus the specific files, without duplicates. The
+// extension "*" matches every file.
+func collectBackupFiles(config MapConfig) ([]string, error) {
+	extensions := make(map[string]bool)
+	for _, ext := range config.FileExtensions {
+		extensions[ext] = true
+	}
+
+	var files []string
+	seen := make(map[string]bool)
 
-	err := filepath.Walk(config.ZipDir, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(config.ExtractDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && filepath.Ext(info.Name()) == ".zip" && info.ModTime().Add(retentionDuration).Before(now) {
-			err := os.Remove(path)
-			if err != nil {
-				return fmt.Errorf("failed to remove old backup: %w", err)
-			}
+		if !info.IsDir() && (extensions["*"] || extensions[filepath.Ext(info.Name())]) {
+			files = append(files, path)
+			seen[path] = true
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", config.ExtractDir, err)
+	}
+
+	for _, file := range config.SpecificFiles {
+		filePath := filepath.Join(config.ExtractDir, file)
+		if seen[filePath] {
+			continue
+		}
+		if _, err := os.Stat(filePath); err == nil {
+			files = append(files, filePath)
+			seen[filePath] = true
+		}
+	}
+
+	return files, nil
+}
+
+// writeArchive writes the files to a new archive, encrypted with the current
+// key if encryption is enabled, and returns their entries and the key's ID
+func (bm *BackupManager) writeArchive(archivePath string, format string, config MapConfig, files []string, progress *progressTracker) ([]ManifestEntry, string, error) {
+	if progress != nil {
+		var total int64
+		for _, filePath := range files {
+			if info, err := os.Stat(filePath); err == nil {
+				total += info.Size()
+			}
+		}
+		progress.startArchiving(len(files), total)
+	}
 
+	archiveFile, err := os.Create(archivePath)
 	if err != nil {
-		return fmt.Errorf("failed to clean up old backups: %w", err)
+		return nil, "", fmt.Errorf("failed to create archive: %w", err)
 	}
+	defer archiveFile.Close()
 
-	return nil
+	var out io.Writer = archiveFile
+	keyID, aead, encrypt := encryptionKey()
+	var enc *encryptWriter
+	if encrypt {
+		enc, err = newEncryptWriter(archiveFile, keyID, aead)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encrypt archive: %w", err)
+		}
+		out = enc
+	}
+
+	var archive archiveWriter
+	var store string
+	if format == FormatDedup {
+		if store, err = bm.dedupDir(); err == nil {
+			archive, err = newDedupWriter(store, config.CompressionLevel, progress.writer(out), progress)
+		}
+	} else {
+		archive, err = newArchiveWriter(format, config.CompressionLevel, progress.writer(out))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var entries []ManifestEntry
+	for _, filePath := range files {
+		entry, err := bm.addFileToArchive(archive, config.ExtractDir, filePath, progress)
+		if err != nil {
+			archive.Close()
+			return nil, "", fmt.Errorf("failed to add %s to archive: %w", filePath, err)
+		}
+		entries = append(entries, entry)
+		progress.fileDone()
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to finalize archive: %w", err)
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return nil, "", fmt.Errorf("failed to finalize encrypted archive: %w", err)
+		}
+	}
+	if err := archiveFile.Close(); err != nil {
+		return nil, "", err
+	}
+	if store != "" {
+		if err := registerIndex(archivePath, store); err != nil {
+			return nil, "", fmt.Errorf("failed to register dedup archive: %w", err)
+		}
+	}
+	return entries, keyID, nil
+}
+
+// addFileToArchive stores a file under its path relative to baseDir so files
+// with the same name in different directories don't overwrite each other.
+func (bm *BackupManager) addFileToArchive(archive archiveWriter, baseDir string, filePath string, progress *progressTracker) (ManifestEntry, error) {
+	var entry ManifestEntry
+
+	relPath, err := filepath.Rel(baseDir, filePath)
+	if err != nil {
+		return entry, fmt.Errorf("failed to resolve relative path: %w", err)
+	}
+	entry.Name = filepath.ToSlash(relPath)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return entry, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return entry, fmt.Errorf("failed to stat file: %w", err)
+	}
+	entry.ModTime = info.ModTime()
+
+	h := sha256.New()
+	entry.Size, err = archive.Add(entry.Name, info, progress.reader(io.TeeReader(file, h)))
+	if err != nil {
+		return entry, fmt.Errorf("failed to write file to archive: %w", err)
+	}
+	entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	return entry, nil
 }
 
 func (bm *BackupManager) StopBackupSchedule(mapName string) error {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	ticker, ok := bm.schedulers[mapName]
+	schedule, ok := bm.schedulers[mapName]
 	if !ok {
-		return fmt.Errorf("no running backup schedule for map: %s", mapName)
+		return fmt.Errorf("%w: %s", ErrScheduleNotRunning, mapName)
 	}
 
-	ticker.Stop()
+	close(schedule.stop)
 	delete(bm.schedulers, mapName)
 
 	// Mark the map as not having an active backup schedule
-	saveFilePath := fmt.Sprintf("./data/%s.save", mapName)
-	err := os.WriteFile(saveFilePath, []byte("false"), 0644)
+	err := state.SetScheduleEnabled(mapName, false)
 	if err != nil {
-		return fmt.Errorf("failed to write inactive schedule file: %w", err)
+		return fmt.Errorf("failed to persist inactive schedule: %w", err)
 	}
 
 	return nil
 }
 
 func (bm *BackupManager) StartOrResumeBackups() error {
-	for mapName := range bm.config.Maps {
-		saveFile := fmt.Sprintf("./data/%s.save", mapName) // Corrected path
-		if _, err := os.Stat(saveFile); err == nil {
-			data, err := os.ReadFile(saveFile)
+	bm.queue.recoverInterrupted()
+
+	for _, mapName := range bm.Maps() {
+		schedule, err := state.Schedule(mapName)
+		if err != nil {
+			return fmt.Errorf("failed to read schedule state for %s: %w", mapName, err)
+		}
+		if schedule.Enabled {
+			err := bm.StartBackupSchedule(mapName)
 			if err != nil {
-				return fmt.Errorf("failed to read save file for %s: %w", mapName, err)
-			}
-			if string(data) == "true" {
-				err := bm.StartBackupSchedule(mapName)
-				if err != nil {
-					return fmt.Errorf("failed to resume backup schedule for %s: %w", mapName, err)
-				}
+				return fmt.Errorf("failed to resume backup schedule for %s: %w", mapName, err)
 			}
 		}
 	}
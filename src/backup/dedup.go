@@ -0,0 +1,486 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/state"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Dedup archives store every distinct file once. The content of each file
+// goes to a blob in a store shared by all maps, named by its SHA-256 and
+// compressed with zstd, encrypted like archives when encryption is enabled.
+// The archive itself is only an index of the files and their blobs.
+// Unchanged saves backed up every 30 minutes then take no space besides
+// the index.
+//
+// Blobs no index references any more are removed by collectGarbage once
+// retention removed a dedup archive. The indexes are found through the
+// state store, so directory backups such as a cluster's are counted too.
+
+const (
+	bucketDedupIndexes = "backup_dedup"
+
+	defaultDedupDir = "data/backup_store"
+	// dedupGrace protects blobs a running backup wrote or reused but has
+	// not referenced in an index yet
+	dedupGrace = 6 * time.Hour
+)
+
+// dedupMu keeps garbage collections apart
+var dedupMu sync.Mutex
+
+// dedupIndex is the content of a dedup archive
+type dedupIndex struct {
+	Store string          `json:"store"`
+	Files []ManifestEntry `json:"files"`
+}
+
+// DedupStats describes a blob store. StoredBytes is the size of its blobs,
+// LogicalBytes the size of the files of all archives using it.
+type DedupStats struct {
+	Store        string `json:"store"`
+	Archives     int    `json:"archives"`
+	Blobs        int    `json:"blobs"`
+	StoredBytes  int64  `json:"stored_bytes"`
+	LogicalBytes int64  `json:"logical_bytes"`
+	Unreferenced int    `json:"unreferenced"`
+}
+
+// dedupDir returns the blob store of dedup archives
+func (bm *BackupManager) dedupDir() (string, error) {
+	bm.mu.Lock()
+	dir := bm.config.DedupDir
+	bm.mu.Unlock()
+	if dir == "" {
+		dir = defaultDedupDir
+	}
+	return filepath.Abs(dir)
+}
+
+func blobPath(store string, sum string) string {
+	return filepath.Join(store, "blobs", sum[:2], sum)
+}
+
+// dedupWriter adds files to the blob store and writes the index on Close
+type dedupWriter struct {
+	store    string
+	level    zstd.EncoderLevel
+	w        io.Writer
+	progress *progressTracker
+	index    dedupIndex
+}
+
+func newDedupWriter(store string, level int, w io.Writer, progress *progressTracker) (*dedupWriter, error) {
+	if err := os.MkdirAll(filepath.Join(store, "tmp"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob store: %w", err)
+	}
+	encoderLevel := zstd.SpeedDefault
+	if level != 0 {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+	return &dedupWriter{store: store, level: encoderLevel, w: w, progress: progress, index: dedupIndex{Store: store, Files: []ManifestEntry{}}}, nil
+}
+
+func (d *dedupWriter) Add(name string, info os.FileInfo, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(d.store, "tmp"), "blob-*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create blob: %w", err)
+	}
+	n, sum, err := d.writeBlob(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return n, fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	target := blobPath(d.store, sum)
+	if _, err := os.Stat(target); err == nil {
+		// Already stored, touching the blob keeps it from being collected
+		// before the index referencing it is written
+		os.Remove(tmp.Name())
+		now := time.Now()
+		if err := os.Chtimes(target, now, now); err != nil {
+			return n, fmt.Errorf("failed to reuse blob: %w", err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			os.Remove(tmp.Name())
+			return n, fmt.Errorf("failed to create blob directory: %w", err)
+		}
+		if err := os.Rename(tmp.Name(), target); err != nil {
+			os.Remove(tmp.Name())
+			return n, fmt.Errorf("failed to store blob: %w", err)
+		}
+	}
+
+	d.index.Files = append(d.index.Files, ManifestEntry{Name: name, Size: n, ModTime: info.ModTime(), SHA256: sum})
+	return n, nil
+}
+
+// writeBlob compresses and, with encryption enabled, encrypts a file's
+// content and returns its size and SHA-256
+func (d *dedupWriter) writeBlob(file *os.File, r io.Reader) (int64, string, error) {
+	var out io.Writer = d.progress.writer(file)
+	var enc *encryptWriter
+	if keyID, aead, encrypt := encryptionKey(); encrypt {
+		var err error
+		if enc, err = newEncryptWriter(out, keyID, aead); err != nil {
+			return 0, "", err
+		}
+		out = enc
+	}
+	zw, err := zstd.NewWriter(out, zstd.WithEncoderLevel(d.level))
+	if err != nil {
+		return 0, "", err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(zw, h), r)
+	if err != nil {
+		zw.Close()
+		return n, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return n, "", err
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return n, "", err
+		}
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (d *dedupWriter) Close() error {
+	return json.NewEncoder(d.w).Encode(d.index)
+}
+
+// registerIndex records a dedup archive for garbage collection
+func registerIndex(archivePath string, store string) error {
+	archivePath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return err
+	}
+	return state.Put(bucketDedupIndexes, archivePath, store)
+}
+
+func readDedupIndex(archivePath string) (dedupIndex, error) {
+	var index dedupIndex
+	file, content, size, err := openArchive(archivePath)
+	if err != nil {
+		return index, err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(io.NewSectionReader(content, 0, size)).Decode(&index); err != nil {
+		return index, fmt.Errorf("invalid dedup index: %w", err)
+	}
+	return index, nil
+}
+
+// blobReader reads the content of a blob
+type blobReader struct {
+	*zstd.Decoder
+	file *os.File
+}
+
+func (b blobReader) Close() error {
+	b.Decoder.Close()
+	return b.file.Close()
+}
+
+func openBlob(store string, sum string) (io.ReadCloser, error) {
+	if len(sum) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid blob %q", sum)
+	}
+	file, content, size, err := openArchive(blobPath(store, sum))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zstd.NewReader(io.NewSectionReader(content, 0, size))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return blobReader{Decoder: zr, file: file}, nil
+}
+
+// walkDedup calls fn for every file of a dedup archive, in order
+func walkDedup(archivePath string, fn func(entry ManifestEntry, r io.Reader) error) error {
+	index, err := readDedupIndex(archivePath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range index.Files {
+		blob, err := openBlob(index.Store, entry.SHA256)
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		err = fn(entry, blob)
+		blob.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryInfo describes an archived file to archive writers
+type entryInfo struct {
+	entry ManifestEntry
+}
+
+func (i entryInfo) Name() string       { return path.Base(i.entry.Name) }
+func (i entryInfo) Size() int64        { return i.entry.Size }
+func (i entryInfo) Mode() fs.FileMode  { return 0644 }
+func (i entryInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i entryInfo) IsDir() bool        { return false }
+func (i entryInfo) Sys() interface{}   { return nil }
+
+// exportDedup writes the files of a dedup archive to a self-contained
+// tar.zst next to it, encrypted with the current key if enabled, and
+// returns its path
+func exportDedup(archivePath string) (string, error) {
+	out, err := os.CreateTemp(filepath.Dir(archivePath), filepath.Base(archivePath)+"-*.export")
+	if err != nil {
+		return "", fmt.Errorf("failed to create export: %w", err)
+	}
+	fail := func(err error) (string, error) {
+		out.Close()
+		os.Remove(out.Name())
+		return "", fmt.Errorf("failed to export %s: %w", filepath.Base(archivePath), err)
+	}
+
+	var w io.Writer = out
+	var enc *encryptWriter
+	if keyID, aead, encrypt := encryptionKey(); encrypt {
+		if enc, err = newEncryptWriter(out, keyID, aead); err != nil {
+			return fail(err)
+		}
+		w = enc
+	}
+	archive, err := newArchiveWriter(FormatTarZst, 0, w)
+	if err != nil {
+		return fail(err)
+	}
+	err = walkDedup(archivePath, func(entry ManifestEntry, r io.Reader) error {
+		_, err := archive.Add(entry.Name, entryInfo{entry}, r)
+		return err
+	})
+	if err != nil {
+		archive.Close()
+		return fail(err)
+	}
+	if err := archive.Close(); err != nil {
+		return fail(err)
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return fail(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// reencryptBlobs encrypts the blobs of a dedup archive that are not
+// encrypted with keyID yet
+func reencryptBlobs(archivePath string, keyID string) error {
+	index, err := readDedupIndex(archivePath)
+	if err != nil {
+		return err
+	}
+	done := make(map[string]bool)
+	for _, entry := range index.Files {
+		if done[entry.SHA256] {
+			continue
+		}
+		done[entry.SHA256] = true
+		blob := blobPath(index.Store, entry.SHA256)
+		current, err := archiveKeyID(blob)
+		if err != nil {
+			return err
+		}
+		if current == keyID {
+			continue
+		}
+		if err := reencryptFile(blob); err != nil {
+			return fmt.Errorf("blob %s: %w", entry.SHA256, err)
+		}
+	}
+	return nil
+}
+
+// dedupScan is what the indexes of a store reference
+type dedupScan struct {
+	stats DedupStats
+	refs  map[string]bool
+	// complete is false if an index could not be read, its blobs are
+	// unknown and nothing may be collected
+	complete bool
+}
+
+// scanDedup reads every registered index and the blobs of their stores.
+// Indexes whose archive was removed are unregistered.
+func scanDedup() (map[string]*dedupScan, error) {
+	registered := make(map[string]string)
+	err := state.ForEach(bucketDedupIndexes, func(key string, value []byte) error {
+		var store string
+		if err := json.Unmarshal(value, &store); err != nil {
+			return fmt.Errorf("invalid dedup index entry %s: %w", key, err)
+		}
+		registered[key] = store
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scans := make(map[string]*dedupScan)
+	for archivePath, store := range registered {
+		scan, ok := scans[store]
+		if !ok {
+			scan = &dedupScan{stats: DedupStats{Store: store}, refs: make(map[string]bool), complete: true}
+			scans[store] = scan
+		}
+		index, err := readDedupIndex(archivePath)
+		if os.IsNotExist(err) {
+			if err := state.Delete(bucketDedupIndexes, archivePath); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to read dedup index %s: %v", archivePath, err)
+			scan.complete = false
+			continue
+		}
+		scan.stats.Archives++
+		for _, entry := range index.Files {
+			scan.refs[entry.SHA256] = true
+			scan.stats.LogicalBytes += entry.Size
+		}
+	}
+
+	for store, scan := range scans {
+		err := walkBlobs(store, func(sum string, info fs.FileInfo) {
+			scan.stats.Blobs++
+			scan.stats.StoredBytes += info.Size()
+			if !scan.refs[sum] {
+				scan.stats.Unreferenced++
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scans, nil
+}
+
+func walkBlobs(store string, fn func(sum string, info fs.FileInfo)) error {
+	err := filepath.WalkDir(filepath.Join(store, "blobs"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".reencrypt") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		fn(entry.Name(), info)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan blob store %s: %w", store, err)
+	}
+	return nil
+}
+
+// collectGarbage removes the blobs no dedup archive references any more
+func collectGarbage() {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+
+	scans, err := scanDedup()
+	if err != nil {
+		log.Printf("Failed to collect unreferenced backup blobs: %v", err)
+		return
+	}
+	cutoff := time.Now().Add(-dedupGrace)
+	for store, scan := range scans {
+		if !scan.complete {
+			log.Printf("Not collecting blobs of %s, an index could not be read", store)
+			continue
+		}
+		var removed int
+		var freed int64
+		err := walkBlobs(store, func(sum string, info fs.FileInfo) {
+			if scan.refs[sum] || info.ModTime().After(cutoff) {
+				return
+			}
+			if err := os.Remove(blobPath(store, sum)); err != nil {
+				log.Printf("Failed to remove blob %s: %v", sum, err)
+				return
+			}
+			removed++
+			freed += info.Size()
+		})
+		if err != nil {
+			log.Printf("Failed to collect unreferenced backup blobs: %v", err)
+			continue
+		}
+
+		// Blobs of backups that failed halfway
+		if entries, err := os.ReadDir(filepath.Join(store, "tmp")); err == nil {
+			for _, entry := range entries {
+				if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+					os.Remove(filepath.Join(store, "tmp", entry.Name()))
+				}
+			}
+		}
+		if removed > 0 {
+			log.Printf("Removed %d unreferenced blob(s) from %s, freeing %d MB", removed, store, freed>>20)
+		}
+	}
+}
+
+// DedupStats describes every blob store in use
+func (bm *BackupManager) DedupStats() ([]DedupStats, error) {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+
+	scans, err := scanDedup()
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]DedupStats, 0, len(scans))
+	for _, scan := range scans {
+		stats = append(stats, scan.stats)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Store < stats[j].Store })
+	return stats, nil
+}
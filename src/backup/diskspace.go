@@ -0,0 +1,362 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/notify"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const (
+	defaultMinFreeMB            = 1024
+	defaultAlertFreePercent     = 10
+	defaultCheckIntervalMinutes = 5
+
+	// compressionSamples is how many recent archives the compression ratio
+	// is estimated from
+	compressionSamples = 5
+)
+
+var ErrInsufficientSpace = errors.New("not enough free disk space")
+
+// DiskGuardConfig protects the volumes holding backups and save data from
+// running full
+type DiskGuardConfig struct {
+	// MinFreeMB must remain free on the backup volume after a backup,
+	// default 1024
+	MinFreeMB int64 `json:"min_free_mb"`
+	// AlertFreePercent alerts when a volume has less free space, default 10
+	AlertFreePercent float64 `json:"alert_free_percent"`
+	// CleanupFreePercent removes the oldest backup chains ahead of the
+	// retention policy while a backup volume has less free space, 0
+	// disables the early cleanup
+	CleanupFreePercent float64 `json:"cleanup_free_percent"`
+	// CheckIntervalMinutes is how often the volumes are checked, default 5
+	CheckIntervalMinutes int `json:"check_interval_minutes"`
+}
+
+func (c DiskGuardConfig) minFree() uint64 {
+	if c.MinFreeMB <= 0 {
+		return defaultMinFreeMB << 20
+	}
+	return uint64(c.MinFreeMB) << 20
+}
+
+func (c DiskGuardConfig) alertPercent() float64 {
+	if c.AlertFreePercent <= 0 {
+		return defaultAlertFreePercent
+	}
+	return c.AlertFreePercent
+}
+
+func (c DiskGuardConfig) checkInterval() time.Duration {
+	if c.CheckIntervalMinutes <= 0 {
+		return defaultCheckIntervalMinutes * time.Minute
+	}
+	return time.Duration(c.CheckIntervalMinutes) * time.Minute
+}
+
+// VolumeUsage is the free space of a volume holding backups or save data
+type VolumeUsage struct {
+	Volume      string   `json:"volume"`
+	Maps        []string `json:"maps"`
+	Roles       []string `json:"roles"`
+	TotalMB     float64  `json:"total_mb"`
+	FreeMB      float64  `json:"free_mb"`
+	FreePercent float64  `json:"free_percent"`
+	Low         bool     `json:"low"`
+	Error       string   `json:"error,omitempty"`
+
+	// backupMaps are the maps whose backups are on the volume
+	backupMaps []string
+}
+
+// volumeOf returns the mount point holding path, or path itself if the
+// partitions cannot be listed
+func volumeOf(path string, partitions []disk.PartitionStat) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	best := ""
+	for _, partition := range partitions {
+		mount := partition.Mountpoint
+		if !pathHasPrefix(abs, mount) || len(mount) <= len(best) {
+			continue
+		}
+		best = mount
+	}
+	if best == "" {
+		return abs
+	}
+	return best
+}
+
+func pathHasPrefix(path string, prefix string) bool {
+	if runtime.GOOS == "windows" {
+		path, prefix = strings.ToLower(path), strings.ToLower(prefix)
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, string(filepath.Separator)) ||
+		path[len(prefix)] == filepath.Separator || (runtime.GOOS == "windows" && strings.HasSuffix(prefix, ":"))
+}
+
+// DiskUsage reports the free space of every volume holding a map's backups
+// (role "backups") or save data (role "saves")
+func (bm *BackupManager) DiskUsage() []VolumeUsage {
+	bm.mu.Lock()
+	maps := make(map[string]MapConfig, len(bm.config.Maps))
+	for mapName, config := range bm.config.Maps {
+		maps[mapName] = config
+	}
+	guard := bm.config.DiskGuard
+	bm.mu.Unlock()
+
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		log.Printf("Failed to list disk partitions: %v", err)
+	}
+
+	byVolume := make(map[string]*VolumeUsage)
+	var paths []string
+	add := func(mapName string, dir string, role string) {
+		if dir == "" {
+			return
+		}
+		volume := volumeOf(dir, partitions)
+		usage, ok := byVolume[volume]
+		if !ok {
+			usage = &VolumeUsage{Volume: volume}
+			byVolume[volume] = usage
+			paths = append(paths, dir)
+		}
+		if !contains(usage.Maps, mapName) {
+			usage.Maps = append(usage.Maps, mapName)
+		}
+		if !contains(usage.Roles, role) {
+			usage.Roles = append(usage.Roles, role)
+		}
+		if role == "backups" {
+			usage.backupMaps = append(usage.backupMaps, mapName)
+		}
+	}
+	for mapName, config := range maps {
+		add(mapName, config.ZipDir, "backups")
+		add(mapName, config.ExtractDir, "saves")
+	}
+
+	volumes := make([]VolumeUsage, 0, len(byVolume))
+	for _, dir := range paths {
+		usage := byVolume[volumeOf(dir, partitions)]
+		stat, err := disk.Usage(dir)
+		if err != nil {
+			usage.Error = err.Error()
+		} else {
+			usage.TotalMB = float64(stat.Total) / (1 << 20)
+			usage.FreeMB = float64(stat.Free) / (1 << 20)
+			if stat.Total > 0 {
+				usage.FreePercent = float64(stat.Free) * 100 / float64(stat.Total)
+			}
+			usage.Low = usage.FreePercent < guard.alertPercent()
+		}
+		sort.Strings(usage.Maps)
+		volumes = append(volumes, *usage)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Volume < volumes[j].Volume })
+	return volumes
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// compressionRatio estimates archive size per byte of source from the
+// manifests of recent archives, 1 when there are none
+func compressionRatio(mapName string, config MapConfig) float64 {
+	archives, err := listArchives(mapName, config)
+	if err != nil {
+		return 1
+	}
+	var source, compressed int64
+	for i, n := len(archives)-1, 0; i >= 0 && n < compressionSamples; i-- {
+		manifest, err := ReadManifest(archives[i].Path)
+		if err != nil || manifest.Size == 0 {
+			continue
+		}
+		for _, entry := range manifest.Files {
+			source += entry.Size
+		}
+		compressed += manifest.Size
+		n++
+	}
+	if source == 0 || compressed == 0 {
+		return 1
+	}
+	return min(1, float64(compressed)/float64(source))
+}
+
+// ensureSpace checks that the backup volume can hold an archive of the
+// files, removing the oldest backup chains of the map early if it cannot.
+// The map lock must be held.
+func (bm *BackupManager) ensureSpace(mapName string, config MapConfig, sourceBytes int64) error {
+	bm.mu.Lock()
+	guard := bm.config.DiskGuard
+	bm.mu.Unlock()
+
+	needed := uint64(float64(sourceBytes)*compressionRatio(mapName, config)) + guard.minFree()
+	for {
+		usage, err := disk.Usage(config.ZipDir)
+		if err != nil {
+			// Unknown free space must not stop backups
+			log.Printf("Failed to check free space of %s: %v", config.ZipDir, err)
+			return nil
+		}
+		if usage.Free >= needed {
+			return nil
+		}
+
+		removed, err := bm.removeOldestChain(mapName, config)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			message := fmt.Sprintf("backup of %s needs %d MB but %s has %d MB free", mapName, needed>>20, config.ZipDir, usage.Free>>20)
+			notify.Publish(notify.EventDiskLow, message, map[string]interface{}{"map": mapName, "dir": config.ZipDir, "free_mb": usage.Free >> 20, "needed_mb": needed >> 20})
+			return fmt.Errorf("%w: %s", ErrInsufficientSpace, message)
+		}
+	}
+}
+
+// removeOldestChain removes the map's oldest backup chain unless it is the
+// newest one starting with a full backup, is tagged or is a snapshot in its
+// grace period, and reports whether it did
+func (bm *BackupManager) removeOldestChain(mapName string, config MapConfig) (bool, error) {
+	archives, err := listArchives(mapName, config)
+	if err != nil {
+		return false, fmt.Errorf("failed to list backups: %w", err)
+	}
+	chains := groupChains(archives)
+	protected := protectedChain(chains)
+	grace := bm.snapshotGrace()
+
+	for i, chain := range chains {
+		if i == protected || chain.exempt(grace) {
+			continue
+		}
+		// Nothing is freed in a dry run, report the chain as not removed
+		// so the cleanup does not go on
+		if dryrun.Global() {
+			plan := dryrun.Plan{Operation: "early backup cleanup", Map: mapName, Deleted: chainFiles(chain)}
+			plan.Log()
+			return false, nil
+		}
+		dedup := false
+		for _, archive := range chain.Archives {
+			if err := removeArchive(archive.Path); err != nil {
+				return false, fmt.Errorf("failed to remove old backup: %w", err)
+			}
+			log.Printf("Removed backup %s of map %s early to free disk space", archive.Name, mapName)
+			dedup = dedup || strings.HasSuffix(archive.Name, "."+FormatDedup)
+		}
+		// Dedup archives only free space once their blobs are collected
+		if dedup {
+			collectGarbage()
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// StartDiskGuard checks the volumes periodically, alerting once when one
+// runs low and cleaning up backups early when configured
+func (bm *BackupManager) StartDiskGuard() {
+	bm.mu.Lock()
+	interval := bm.config.DiskGuard.checkInterval()
+	bm.mu.Unlock()
+
+	go func() {
+		low := make(map[string]bool)
+		for {
+			bm.checkDiskSpace(low)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func (bm *BackupManager) checkDiskSpace(low map[string]bool) {
+	bm.mu.Lock()
+	guard := bm.config.DiskGuard
+	bm.mu.Unlock()
+
+	for _, volume := range bm.DiskUsage() {
+		if volume.Error != "" {
+			continue
+		}
+		if guard.CleanupFreePercent > 0 && volume.FreePercent < guard.CleanupFreePercent && len(volume.backupMaps) > 0 {
+			bm.cleanupVolume(volume, guard.CleanupFreePercent)
+		}
+
+		if !volume.Low {
+			low[volume.Volume] = false
+			continue
+		}
+		if low[volume.Volume] {
+			continue
+		}
+		low[volume.Volume] = true
+		message := fmt.Sprintf("volume %s has %.0f MB (%.1f%%) free, used by %s", volume.Volume, volume.FreeMB, volume.FreePercent, strings.Join(volume.Maps, ", "))
+		log.Printf("Disk space low: %s", message)
+		notify.Publish(notify.EventDiskLow, message, map[string]interface{}{"volume": volume.Volume, "maps": volume.Maps, "free_mb": volume.FreeMB, "free_percent": volume.FreePercent})
+	}
+}
+
+// cleanupVolume removes the oldest backup chains of the maps backing up to
+// a volume, one chain per map at a time, until it has percent free again
+func (bm *BackupManager) cleanupVolume(volume VolumeUsage, percent float64) {
+	for {
+		removedAny := false
+		for _, mapName := range volume.backupMaps {
+			config, ok := bm.mapConfig(mapName)
+			if !ok {
+				continue
+			}
+			usage, err := disk.Usage(config.ZipDir)
+			if err != nil || usage.Total == 0 || float64(usage.Free)*100/float64(usage.Total) >= percent {
+				return
+			}
+
+			// Maps busy with a backup or restore are left for the next check
+			release, err := maplock.TryAcquire(mapName, maplock.OpCleanup)
+			if err != nil {
+				continue
+			}
+			removed, err := bm.removeOldestChain(mapName, config)
+			release()
+			if err != nil {
+				log.Printf("Early cleanup of map %s failed: %v", mapName, err)
+				continue
+			}
+			removedAny = removedAny || removed
+		}
+		if !removedAny {
+			return
+		}
+	}
+}
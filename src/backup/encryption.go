@@ -0,0 +1,509 @@
+package backup
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/secrets"
+)
+
+// Archives can be encrypted at rest with AES-256-GCM, so the copies on
+// remote targets can't be read by the storage provider. An encrypted
+// archive keeps its name and format suffix and is laid out as
+//
+//	magic | key ID length | key ID | nonce prefix | sealed chunks
+//
+// Every chunk but the last holds encChunkSize bytes. The last chunk is
+// always shorter, possibly empty, and sealed as the final one, so a
+// truncated archive fails to decrypt. The fixed chunk size gives the
+// random access zip archives need.
+
+const (
+	encMagic       = "ASABAK1\n"
+	encChunkSize   = 64 << 10
+	encNoncePrefix = 8
+	encKeySize     = 32
+)
+
+var (
+	ErrEncryptionDisabled = errors.New("backup encryption is not enabled")
+	ErrUnknownKey         = errors.New("archive is encrypted with an unknown key")
+	errDecrypt            = errors.New("archive failed to decrypt, wrong key or corrupt data")
+)
+
+// EncryptionConfig encrypts new archives with the key KeyID names. To
+// rotate keys add a new key, point KeyID at it and keep the old key listed
+// until ReencryptBackups has moved every archive to the new one.
+type EncryptionConfig struct {
+	Enabled bool   `json:"enabled"`
+	KeyID   string `json:"key_id"`
+	// Keys maps key IDs to 32 byte keys, base64 encoded, or to secret:<name>
+	// references to such keys in the secrets file
+	Keys map[string]string `json:"keys,omitempty"`
+}
+
+// ReencryptResult lists the archives of a map that were encrypted with the
+// current key
+type ReencryptResult struct {
+	Map         string   `json:"map"`
+	KeyID       string   `json:"key_id"`
+	Reencrypted []string `json:"reencrypted"`
+	Skipped     int      `json:"skipped"`
+}
+
+var (
+	keysMu sync.RWMutex
+	// keys holds every configured key, currentKey the one new archives are
+	// encrypted with, empty while encryption is disabled
+	keys       map[string]cipher.AEAD
+	currentKey string
+)
+
+// loadKeys resolves the configured keys
+func loadKeys(config EncryptionConfig) error {
+	loaded := make(map[string]cipher.AEAD, len(config.Keys))
+	for id, value := range config.Keys {
+		if id == "" || len(id) > 255 {
+			return fmt.Errorf("invalid backup key ID %q", id)
+		}
+		resolved, err := secrets.Resolve(value)
+		if err != nil {
+			return fmt.Errorf("failed to resolve backup key %s: %w", id, err)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(resolved))
+		if err != nil || len(raw) != encKeySize {
+			return fmt.Errorf("backup key %s must be %d bytes, base64 encoded", id, encKeySize)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		loaded[id] = aead
+	}
+
+	current := ""
+	if config.Enabled {
+		if _, ok := loaded[config.KeyID]; !ok {
+			return fmt.Errorf("backup encryption key_id %q is not one of the keys", config.KeyID)
+		}
+		current = config.KeyID
+	}
+
+	keysMu.Lock()
+	keys = loaded
+	currentKey = current
+	keysMu.Unlock()
+	return nil
+}
+
+// encryptionKey returns the key new archives are encrypted with, ok is
+// false while encryption is disabled
+func encryptionKey() (string, cipher.AEAD, bool) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	if currentKey == "" {
+		return "", nil, false
+	}
+	return currentKey, keys[currentKey], true
+}
+
+func lookupKey(id string) (cipher.AEAD, bool) {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	aead, ok := keys[id]
+	return aead, ok
+}
+
+func encHeader(keyID string, prefix []byte) []byte {
+	header := append([]byte(encMagic), byte(len(keyID)))
+	header = append(header, keyID...)
+	return append(header, prefix...)
+}
+
+func chunkNonce(prefix []byte, index uint64) []byte {
+	nonce := make([]byte, encNoncePrefix+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encNoncePrefix:], uint32(index))
+	return nonce
+}
+
+// chunkAAD binds every chunk to the header and marks the final one
+func chunkAAD(header []byte, final bool) []byte {
+	aad := append([]byte{}, header...)
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// encryptWriter seals everything written to it in chunks, Close writes the
+// final chunk
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	out     []byte
+	counter uint64
+}
+
+func newEncryptWriter(w io.Writer, keyID string, aead cipher.AEAD) (*encryptWriter, error) {
+	prefix := make([]byte, encNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header := encHeader(keyID, prefix)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header, prefix: prefix, buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		take := min(len(p), encChunkSize-len(e.buf))
+		e.buf = append(e.buf, p[:take]...)
+		p = p[take:]
+		n += take
+		// Full chunks are sealed right away, so the final chunk is always
+		// shorter than the others
+		if len(e.buf) == encChunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	if e.counter > 1<<32-1 {
+		return errors.New("archive too large to encrypt")
+	}
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.prefix, e.counter), e.buf, chunkAAD(e.header, final))
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// readEncHeader reads the header of an encrypted archive, ok is false for
+// archives that are not encrypted
+func readEncHeader(r io.ReaderAt) (keyID string, prefix []byte, header []byte, ok bool, err error) {
+	start := make([]byte, len(encMagic)+1)
+	if _, err := r.ReadAt(start, 0); err != nil {
+		if err == io.EOF {
+			return "", nil, nil, false, nil
+		}
+		return "", nil, nil, false, err
+	}
+	if string(start[:len(encMagic)]) != encMagic {
+		return "", nil, nil, false, nil
+	}
+
+	rest := make([]byte, int(start[len(encMagic)])+encNoncePrefix)
+	if _, err := r.ReadAt(rest, int64(len(start))); err != nil {
+		return "", nil, nil, true, fmt.Errorf("truncated encryption header: %w", err)
+	}
+	keyID = string(rest[:len(rest)-encNoncePrefix])
+	prefix = rest[len(rest)-encNoncePrefix:]
+	return keyID, prefix, append(start, rest...), true, nil
+}
+
+// archiveKeyID returns the ID of the key an archive is encrypted with,
+// empty if it is not encrypted
+func archiveKeyID(archivePath string) (string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	keyID, _, _, _, err := readEncHeader(file)
+	return keyID, err
+}
+
+// decryptReader gives random access to the plaintext of an encrypted
+// archive, decrypting one chunk at a time. It is not safe for concurrent
+// use.
+type decryptReader struct {
+	r      io.ReaderAt
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunks int64
+	last   int
+	size   int64
+
+	cached int64
+	plain  []byte
+	sealed []byte
+}
+
+func newDecryptReader(r io.ReaderAt, size int64) (*decryptReader, bool, error) {
+	keyID, prefix, header, ok, err := readEncHeader(r)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	aead, known := lookupKey(keyID)
+	if !known {
+		return nil, true, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	sealedChunk := int64(encChunkSize + aead.Overhead())
+	body := size - int64(len(header))
+	last := body % sealedChunk
+	if body < 0 || last < int64(aead.Overhead()) {
+		return nil, true, errors.New("encrypted archive is truncated")
+	}
+	d := &decryptReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		chunks: body/sealedChunk + 1,
+		last:   int(last),
+		cached: -1,
+		sealed: make([]byte, sealedChunk),
+	}
+	d.size = (d.chunks-1)*encChunkSize + last - int64(aead.Overhead())
+	return d, true, nil
+}
+
+func (d *decryptReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= d.size {
+			return n, io.EOF
+		}
+		index := off / encChunkSize
+		if err := d.load(index); err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], d.plain[off-index*encChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+func (d *decryptReader) load(index int64) error {
+	if d.cached == index {
+		return nil
+	}
+	sealedChunk := int64(encChunkSize + d.aead.Overhead())
+	final := index == d.chunks-1
+	sealed := d.sealed
+	if final {
+		sealed = sealed[:d.last]
+	}
+	if _, err := d.r.ReadAt(sealed, int64(len(d.header))+index*sealedChunk); err != nil && err != io.EOF {
+		return err
+	}
+
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(d.prefix, uint64(index)), sealed, chunkAAD(d.header, final))
+	if err != nil {
+		d.cached = -1
+		return errDecrypt
+	}
+	d.plain = plain
+	d.cached = index
+	return nil
+}
+
+// openArchive opens an archive for reading, decrypting it if needed. The
+// returned file must be closed.
+func openArchive(archivePath string) (*os.File, io.ReaderAt, int64, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, 0, err
+	}
+
+	d, encrypted, err := newDecryptReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, 0, err
+	}
+	if encrypted {
+		return file, d, d.size, nil
+	}
+	return file, file, info.Size(), nil
+}
+
+// ReencryptBackups encrypts every archive of a map that is not encrypted
+// with the current key yet, after a key rotation or once encryption was
+// enabled. Copies on remote targets are replaced too.
+func (bm *BackupManager) ReencryptBackups(mapName string) (ReencryptResult, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return ReencryptResult{}, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	keyID, _, enabled := encryptionKey()
+	if !enabled {
+		return ReencryptResult{}, ErrEncryptionDisabled
+	}
+
+	// Restores and backups must not read an archive while it is replaced
+	release, err := maplock.Acquire(context.Background(), mapName, maplock.OpBackup)
+	if err != nil {
+		return ReencryptResult{}, err
+	}
+	defer release()
+
+	archives, err := findArchives(config.ZipDir, mapName)
+	if err != nil {
+		return ReencryptResult{}, err
+	}
+	result := ReencryptResult{Map: mapName, KeyID: keyID, Reencrypted: []string{}}
+	for _, archivePath := range archives {
+		current, err := archiveKeyID(archivePath)
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s: %w", filepath.Base(archivePath), err)
+		}
+		// The blobs of a dedup archive may be shared with archives that
+		// still use an old key
+		if strings.HasSuffix(archivePath, "."+FormatDedup) {
+			if err := reencryptBlobs(archivePath, keyID); err != nil {
+				return result, fmt.Errorf("failed to re-encrypt the blobs of %s: %w", filepath.Base(archivePath), err)
+			}
+		}
+		if current == keyID {
+			result.Skipped++
+			continue
+		}
+		if err := reencryptArchive(archivePath); err != nil {
+			return result, fmt.Errorf("failed to re-encrypt %s: %w", filepath.Base(archivePath), err)
+		}
+		result.Reencrypted = append(result.Reencrypted, filepath.Base(archivePath))
+	}
+
+	if len(result.Reencrypted) > 0 {
+		log.Printf("Re-encrypted %d backup(s) of map %s with key %s", len(result.Reencrypted), mapName, keyID)
+		bm.replaceRemote(mapName, config, result.Reencrypted)
+	}
+	return result, nil
+}
+
+// reencryptArchive replaces an archive with a copy encrypted with the
+// current key and updates its checksum and manifest
+func reencryptArchive(archivePath string) error {
+	keyID, _, _ := encryptionKey()
+	if err := reencryptFile(archivePath); err != nil {
+		return err
+	}
+
+	sum, size, err := writeChecksum(archivePath)
+	if err != nil {
+		return err
+	}
+	manifest, err := ReadManifest(archivePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	manifest.Size = size
+	manifest.SHA256 = sum
+	manifest.KeyID = keyID
+	return saveManifest(archivePath, manifest)
+}
+
+// reencryptFile replaces an archive or blob with a copy encrypted with the
+// current key
+func reencryptFile(path string) error {
+	keyID, aead, _ := encryptionKey()
+
+	file, plain, size, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tmpPath := path + ".reencrypt"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	enc, err := newEncryptWriter(out, keyID, aead)
+	if err == nil {
+		_, err = io.Copy(enc, io.NewSectionReader(plain, 0, size))
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// replaceRemote uploads re-encrypted archives again to the remote targets
+// that still hold them. Archives the remote retention already removed are
+// not uploaded again.
+func (bm *BackupManager) replaceRemote(mapName string, config MapConfig, names []string) {
+	// Remote copies of dedup archives have a name of their own
+	reencrypted := make(map[string]string, len(names))
+	for _, name := range names {
+		reencrypted[remoteName(name)] = name
+	}
+
+	for _, target := range config.RemoteTargets {
+		store, err := NewStorage(target)
+		if err != nil {
+			log.Printf("Invalid remote target for map %s: %v", mapName, err)
+			continue
+		}
+		objects, err := store.List()
+		if err != nil {
+			log.Printf("Failed to list backups on %s: %v", store, err)
+			continue
+		}
+		for _, obj := range objects {
+			name := filepath.Base(obj.Name)
+			local, ok := reencrypted[name]
+			if !ok {
+				continue
+			}
+			if err := bm.upload(store, target, filepath.Join(config.ZipDir, local)); err != nil {
+				log.Printf("Failed to replace %s on %s: %v", name, store, err)
+			}
+		}
+	}
+}
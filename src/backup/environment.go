@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Environment is the server a backup was taken on. Restores compare it with
+// the server of the map now and warn about the differences, a save may not
+// load on another build or without its mods.
+type Environment struct {
+	Build string           `json:"build,omitempty"`
+	Mods  []EnvironmentMod `json:"mods,omitempty"`
+	Args  []string         `json:"args,omitempty"`
+	// Settings are key INI settings by "<file>/<section>/<key>"
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// EnvironmentMod is a mod the server loaded, FileID is 0 when its version
+// is not known
+type EnvironmentMod struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name,omitempty"`
+	FileID int    `json:"file_id,omitempty"`
+	File   string `json:"file,omitempty"`
+}
+
+func (m EnvironmentMod) String() string {
+	name := fmt.Sprint(m.ID)
+	if m.Name != "" {
+		name = fmt.Sprintf("%s (%d)", m.Name, m.ID)
+	}
+	if m.File != "" {
+		name += " " + m.File
+	}
+	return name
+}
+
+// Differences describes how the environment of a backup differs from the
+// current one, e.g. "this save was made on build X". Unknown values are not
+// compared.
+func (e *Environment) Differences(current *Environment) []string {
+	if e == nil || current == nil {
+		return nil
+	}
+	var diffs []string
+	if e.Build != "" && current.Build != "" && e.Build != current.Build {
+		diffs = append(diffs, fmt.Sprintf("this save was made on build %s, the server is on build %s", e.Build, current.Build))
+	}
+
+	was, now := modsByID(e.Mods), modsByID(current.Mods)
+	for _, mod := range e.Mods {
+		other, found := now[mod.ID]
+		switch {
+		case !found:
+			diffs = append(diffs, fmt.Sprintf("this save was made with mod %s, the server does not load it", mod))
+		case mod.FileID != 0 && other.FileID != 0 && mod.FileID != other.FileID:
+			diffs = append(diffs, fmt.Sprintf("this save was made with mod %s, the server loads %s", mod, other.File))
+		}
+	}
+	for _, mod := range current.Mods {
+		if _, found := was[mod.ID]; !found {
+			diffs = append(diffs, fmt.Sprintf("the server loads mod %s, this save was made without it", mod))
+		}
+	}
+
+	if len(e.Args) > 0 && len(current.Args) > 0 && strings.Join(e.Args, " ") != strings.Join(current.Args, " ") {
+		diffs = append(diffs, fmt.Sprintf("this save was made with the arguments %q, the server starts with %q", strings.Join(e.Args, " "), strings.Join(current.Args, " ")))
+	}
+
+	keys := make([]string, 0, len(e.Settings))
+	for key := range e.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, found := current.Settings[key]; found && value != e.Settings[key] {
+			diffs = append(diffs, fmt.Sprintf("this save was made with %s=%s, it is %s now", key, e.Settings[key], value))
+		}
+	}
+	return diffs
+}
+
+func modsByID(mods []EnvironmentMod) map[int]EnvironmentMod {
+	byID := make(map[int]EnvironmentMod, len(mods))
+	for _, mod := range mods {
+		byID[mod.ID] = mod
+	}
+	return byID
+}
+
+// environment returns the current environment of a map, nil if it is not
+// known
+func (bm *BackupManager) environment(mapName string) *Environment {
+	if bm.Environment == nil {
+		return nil
+	}
+	return bm.Environment(mapName)
+}
+
+// EnvironmentWarnings compares the environment an archive of a map was
+// taken on with that of target's server now
+func (bm *BackupManager) EnvironmentWarnings(mapName string, archiveName string, target string) []string {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return nil
+	}
+	manifest, err := ReadManifest(filepath.Join(config.ZipDir, filepath.Base(archiveName)))
+	if err != nil {
+		return nil
+	}
+	return manifest.Environment.Differences(bm.environment(target))
+}
@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"asa_servermanager_api/rcon"
+)
+
+const (
+	defaultSaveWaitSeconds   = 60
+	defaultHookScriptSeconds = 300
+	saveFlushPollInterval    = 2 * time.Second
+)
+
+// HookConfig defines what runs around a map's backup
+type HookConfig struct {
+	// SaveWorld sends saveworld over RCON and waits for the save files to
+	// stop changing before the archive is created.
+	SaveWorld       bool `json:"save_world"`
+	SaveWaitSeconds int  `json:"save_wait_seconds"`
+
+	PreCommands  []string `json:"pre_commands"`
+	PostCommands []string `json:"post_commands"`
+
+	// PostScript is executed after an archive is created with the archive
+	// path as its only argument.
+	PostScript string `json:"post_script"`
+
+	// AbortOnFailure skips the backup when a pre-backup hook fails instead of
+	// archiving whatever is on disk.
+	AbortOnFailure bool `json:"abort_on_failure"`
+}
+
+// runPreBackupHooks runs the pre-backup RCON commands and the world save
+func runPreBackupHooks(mapName string, config MapConfig) error {
+	hooks := config.Hooks
+	if hooks == nil {
+		return nil
+	}
+
+	for _, command := range hooks.PreCommands {
+		if _, err := rcon.Execute(mapName, command); err != nil {
+			return fmt.Errorf("pre-backup command %q failed: %w", command, err)
+		}
+	}
+
+	if hooks.SaveWorld {
+		since := time.Now()
+		if _, err := rcon.Execute(mapName, "saveworld"); err != nil {
+			return fmt.Errorf("saveworld failed: %w", err)
+		}
+		wait := time.Duration(hooks.SaveWaitSeconds) * time.Second
+		if wait <= 0 {
+			wait = defaultSaveWaitSeconds * time.Second
+		}
+		if err := waitForSaveFlush(config, since, wait); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runPostBackupHooks runs the post-backup RCON commands, unless the server
+// cannot take them yet, and the script. Failures are logged only, the
+// archive already exists at this point.
+func runPostBackupHooks(mapName string, config MapConfig, archivePath string, rconReady bool) {
+	hooks := config.Hooks
+	if hooks == nil {
+		return
+	}
+
+	if rconReady {
+		for _, command := range hooks.PostCommands {
+			if _, err := rcon.Execute(mapName, command); err != nil {
+				log.Printf("Post-backup command %q for map %s failed: %v", command, mapName, err)
+			}
+		}
+	}
+
+	if hooks.PostScript != "" && archivePath != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultHookScriptSeconds*time.Second)
+		defer cancel()
+
+		output, err := exec.CommandContext(ctx, hooks.PostScript, archivePath).CombinedOutput()
+		if err != nil {
+			log.Printf("Post-backup script for map %s failed: %v\n%s", mapName, err, output)
+			return
+		}
+		log.Printf("Post-backup script for map %s finished: %s", mapName, output)
+	}
+}
+
+// waitForSaveFlush waits until the save files have been written after since
+// and then stay unchanged for one poll interval, or until the timeout.
+func waitForSaveFlush(config MapConfig, since time.Time, timeout time.Duration) error {
+	if len(config.SpecificFiles) == 0 {
+		time.Sleep(timeout)
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	var lastSize int64 = -1
+	var lastMod time.Time
+
+	for time.Now().Before(deadline) {
+		time.Sleep(saveFlushPollInterval)
+
+		size, mod := saveFilesSnapshot(config)
+		if mod.After(since) && size == lastSize && mod.Equal(lastMod) {
+			return nil
+		}
+		lastSize, lastMod = size, mod
+	}
+
+	return fmt.Errorf("save files were not flushed within %v", timeout)
+}
+
+// saveFilesSnapshot returns the combined size and newest mtime of the
+// specific files, which hold the world save.
+func saveFilesSnapshot(config MapConfig) (int64, time.Time) {
+	var size int64
+	var newest time.Time
+
+	for _, file := range config.SpecificFiles {
+		info, err := os.Stat(filepath.Join(config.ExtractDir, file))
+		if err != nil {
+			continue
+		}
+		size += info.Size()
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return size, newest
+}
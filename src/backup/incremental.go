@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"asa_servermanager_api/state"
+)
+
+const (
+	BackupTypeFull        = "full"
+	BackupTypeIncremental = "incremental"
+
+	defaultFullBackupHours = 24
+)
+
+// FileState is what the last backup knew about a file
+type FileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// chainState is persisted per map in the state store and links incrementals
+// to the full backup they build on.
+type chainState struct {
+	BaseArchive string               `json:"base_archive"`
+	LastArchive string               `json:"last_archive"`
+	LastFull    time.Time            `json:"last_full"`
+	Files       map[string]FileState `json:"files"`
+}
+
+func loadChainState(mapName string) (*chainState, error) {
+	chain := &chainState{Files: make(map[string]FileState)}
+
+	if _, err := state.Get(state.BucketBackupChains, mapName, chain); err != nil {
+		return nil, fmt.Errorf("failed to read backup state: %w", err)
+	}
+	if chain.Files == nil {
+		chain.Files = make(map[string]FileState)
+	}
+	return chain, nil
+}
+
+func saveChainState(mapName string, chain *chainState) error {
+	if err := state.Put(state.BucketBackupChains, mapName, chain); err != nil {
+		return fmt.Errorf("failed to write backup state: %w", err)
+	}
+	return nil
+}
+
+// needsFullBackup decides whether the next backup starts a new chain
+func needsFullBackup(config MapConfig, chain *chainState) bool {
+	if chain.BaseArchive == "" || chain.LastArchive == "" {
+		return true
+	}
+	for _, name := range []string{chain.BaseArchive, chain.LastArchive} {
+		if _, err := os.Stat(filepath.Join(config.ZipDir, name)); err != nil {
+			return true
+		}
+	}
+
+	hours := config.FullBackupHours
+	if hours <= 0 {
+		hours = defaultFullBackupHours
+	}
+	return time.Since(chain.LastFull) >= time.Duration(hours)*time.Hour
+}
+
+// changedFiles compares the current files against the chain state and
+// returns the files that must go into the next archive plus the relative
+// paths of files that disappeared since the last backup. Files whose mtime
+// changed but whose content did not are only updated in the state.
+func changedFiles(config MapConfig, files []string, chain *chainState, full bool) ([]string, []string, error) {
+	var changed []string
+	present := make(map[string]bool)
+
+	for _, filePath := range files {
+		relPath, err := filepath.Rel(config.ExtractDir, filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := filepath.ToSlash(relPath)
+		present[name] = true
+
+		if full {
+			changed = append(changed, filePath)
+			continue
+		}
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to stat %s: %w", filePath, err)
+		}
+
+		prev, ok := chain.Files[name]
+		if ok && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime()) {
+			continue
+		}
+		if ok && prev.Size == info.Size() {
+			sum, _, err := fileSHA256(filePath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to hash %s: %w", filePath, err)
+			}
+			if sum == prev.SHA256 {
+				prev.ModTime = info.ModTime()
+				chain.Files[name] = prev
+				continue
+			}
+		}
+		changed = append(changed, filePath)
+	}
+
+	var deleted []string
+	if !full {
+		for name := range chain.Files {
+			if !present[name] {
+				deleted = append(deleted, name)
+			}
+		}
+	}
+
+	return changed, deleted, nil
+}
+
+// FullBackup starts a new backup chain regardless of the full backup interval
+func (bm *BackupManager) FullBackup(mapName string, config MapConfig) error {
+	_, err := bm.runBackup(mapName, config, true, nil)
+	return err
+}
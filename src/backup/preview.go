@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Status of a file in a restore preview
+const (
+	// DiffMissing files are in the archive but not on disk, restoring creates them
+	DiffMissing = "missing"
+	// DiffDifferent files have other contents on disk, restoring overwrites them
+	DiffDifferent = "different"
+	// DiffTouched files have the same contents but another mtime
+	DiffTouched = "touched"
+	// DiffIdentical files are on disk as they are in the archive
+	DiffIdentical = "identical"
+	// DiffRemoved files were deleted before the backup, restoring removes them
+	DiffRemoved = "removed"
+	// DiffExtra files are backed up files on disk the archive does not
+	// know of, restoring leaves them alone
+	DiffExtra = "extra"
+)
+
+// FileDiff compares a file of an archive chain with the file on disk
+type FileDiff struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+
+	ArchiveSize    int64     `json:"archive_size,omitempty"`
+	ArchiveModTime time.Time `json:"archive_mod_time,omitempty"`
+	ArchiveSHA256  string    `json:"archive_sha256,omitempty"`
+	LocalSize      int64     `json:"local_size,omitempty"`
+	LocalModTime   time.Time `json:"local_mod_time,omitempty"`
+	LocalSHA256    string    `json:"local_sha256,omitempty"`
+}
+
+// RestorePreview lists what restoring an archive would change
+type RestorePreview struct {
+	Map     string         `json:"map"`
+	Archive string         `json:"archive"`
+	Chain   []string       `json:"chain"`
+	Files   []FileDiff     `json:"files"`
+	Summary map[string]int `json:"summary"`
+
+	// Environment is the server the archive was taken on, Warnings how the
+	// map's server differs from it now
+	Environment *Environment `json:"environment,omitempty"`
+	Warnings    []string     `json:"warnings,omitempty"`
+}
+
+// PreviewRestore compares the files restoring an archive would write with
+// the map's current files without changing anything. When fileName is set
+// only that file is compared.
+func (bm *BackupManager) PreviewRestore(mapName string, archiveName string, fileName string) (RestorePreview, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return RestorePreview{}, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	return bm.previewRestore(mapName, config, archiveName, fileName)
+}
+
+func (bm *BackupManager) previewRestore(mapName string, config MapConfig, archiveName string, fileName string) (RestorePreview, error) {
+	preview := RestorePreview{Map: mapName, Archive: filepath.Base(archiveName), Files: []FileDiff{}, Summary: make(map[string]int)}
+
+	archivePath := filepath.Join(config.ZipDir, filepath.Base(archiveName))
+	if _, err := os.Stat(archivePath); err != nil {
+		return preview, fmt.Errorf("backup %s not found: %w", archiveName, err)
+	}
+	chain, err := resolveChain(config, archivePath)
+	if err != nil {
+		return preview, err
+	}
+	for _, link := range chain {
+		preview.Chain = append(preview.Chain, filepath.Base(link))
+	}
+	if manifest, err := ReadManifest(archivePath); err == nil && manifest.Environment != nil {
+		preview.Environment = manifest.Environment
+		preview.Warnings = manifest.Environment.Differences(bm.environment(mapName))
+	}
+
+	// Replay the chain like a restore does to learn the final contents
+	wanted := filepath.ToSlash(fileName)
+	files := make(map[string]ManifestEntry)
+	deleted := make(map[string]bool)
+	for _, link := range chain {
+		entries, removed, err := chainEntries(link)
+		if err != nil {
+			return preview, err
+		}
+		for _, entry := range entries {
+			if wanted == "" || entry.Name == wanted {
+				files[entry.Name] = entry
+				delete(deleted, entry.Name)
+			}
+		}
+		if wanted != "" {
+			continue
+		}
+		for _, name := range removed {
+			delete(files, name)
+			deleted[name] = true
+		}
+	}
+	if wanted != "" && len(files) == 0 {
+		return preview, fmt.Errorf("file %s not found in backup %s", fileName, archiveName)
+	}
+
+	for name, entry := range files {
+		target, err := safeExtractPath(config.ExtractDir, name)
+		if err != nil {
+			return preview, err
+		}
+		diff, err := compareFile(entry, target)
+		if err != nil {
+			return preview, err
+		}
+		preview.Files = append(preview.Files, diff)
+	}
+	for name := range deleted {
+		target, err := safeExtractPath(config.ExtractDir, name)
+		if err != nil {
+			return preview, err
+		}
+		if info, err := os.Stat(target); err == nil {
+			preview.Files = append(preview.Files, FileDiff{Name: name, Status: DiffRemoved, LocalSize: info.Size(), LocalModTime: info.ModTime()})
+		}
+	}
+	if wanted == "" {
+		current, err := collectBackupFiles(config)
+		if err != nil {
+			return preview, err
+		}
+		for _, filePath := range current {
+			relPath, err := filepath.Rel(config.ExtractDir, filePath)
+			if err != nil {
+				continue
+			}
+			name := filepath.ToSlash(relPath)
+			if _, ok := files[name]; ok || deleted[name] {
+				continue
+			}
+			if info, err := os.Stat(filePath); err == nil {
+				preview.Files = append(preview.Files, FileDiff{Name: name, Status: DiffExtra, LocalSize: info.Size(), LocalModTime: info.ModTime()})
+			}
+		}
+	}
+
+	sort.Slice(preview.Files, func(i, j int) bool { return preview.Files[i].Name < preview.Files[j].Name })
+	for _, diff := range preview.Files {
+		preview.Summary[diff.Status]++
+	}
+	return preview, nil
+}
+
+// chainEntries returns the files an archive holds and the files it records
+// as deleted. Archives without a manifest are read and hashed.
+func chainEntries(archivePath string) ([]ManifestEntry, []string, error) {
+	if manifest, err := ReadManifest(archivePath); err == nil {
+		return manifest.Files, manifest.Deleted, nil
+	}
+
+	var entries []ManifestEntry
+	err := walkArchive(archivePath, func(name string, modTime time.Time, r io.Reader) error {
+		h := sha256.New()
+		size, err := io.Copy(h, r)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, ManifestEntry{Name: name, Size: size, ModTime: modTime, SHA256: hex.EncodeToString(h.Sum(nil))})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup %s: %w", filepath.Base(archivePath), err)
+	}
+	return entries, nil, nil
+}
+
+// compareFile compares an archived file with the file at target. Files of
+// the same size and mtime are taken as identical without hashing them,
+// like incremental backups do.
+func compareFile(entry ManifestEntry, target string) (FileDiff, error) {
+	diff := FileDiff{Name: entry.Name, ArchiveSize: entry.Size, ArchiveModTime: entry.ModTime, ArchiveSHA256: entry.SHA256}
+
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		diff.Status = DiffMissing
+		return diff, nil
+	}
+	if err != nil {
+		return diff, fmt.Errorf("failed to stat %s: %w", entry.Name, err)
+	}
+	diff.LocalSize = info.Size()
+	diff.LocalModTime = info.ModTime()
+
+	sameTime := info.ModTime().Equal(entry.ModTime)
+	switch {
+	case info.Size() != entry.Size:
+		diff.Status = DiffDifferent
+	case sameTime:
+		diff.Status = DiffIdentical
+	default:
+		sum, _, err := fileSHA256(target)
+		if err != nil {
+			return diff, fmt.Errorf("failed to hash %s: %w", entry.Name, err)
+		}
+		diff.LocalSHA256 = sum
+		if sum == entry.SHA256 {
+			diff.Status = DiffTouched
+		} else {
+			diff.Status = DiffDifferent
+		}
+	}
+	return diff, nil
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/notify"
+)
+
+const (
+	PhaseScanning  = "scanning"
+	PhaseArchiving = "archiving"
+	PhaseUploading = "uploading"
+
+	// progressInterval limits how often progress is streamed
+	progressInterval = time.Second
+)
+
+// Progress of a running backup job. Percent and the ETA are based on the
+// bytes read from the files to archive.
+type Progress struct {
+	Phase           string  `json:"phase"`
+	FilesScanned    int     `json:"files_scanned"`
+	FilesTotal      int     `json:"files_total"`
+	FilesDone       int     `json:"files_done"`
+	BytesTotal      int64   `json:"bytes_total"`
+	BytesRead       int64   `json:"bytes_read"`
+	BytesCompressed int64   `json:"bytes_compressed"`
+	Percent         float64 `json:"percent"`
+	ETASeconds      int     `json:"eta_seconds"`
+}
+
+// progressTracker collects the progress of a job while it runs. The
+// counters are updated without locks from the backup, the job's Progress
+// is refreshed from them at most every progressInterval. A nil tracker
+// ignores all updates, for backups that are not run as a job.
+type progressTracker struct {
+	q      *jobQueue
+	job    *BackupJob
+	handle *jobs.Handle
+
+	phase      atomic.Value
+	scanned    atomic.Int64
+	filesTotal atomic.Int64
+	filesDone  atomic.Int64
+	bytesTotal atomic.Int64
+	bytesRead  atomic.Int64
+	compressed atomic.Int64
+
+	archiveStarted time.Time
+	// lastReport is the UnixNano time of the last report
+	lastReport atomic.Int64
+}
+
+func newProgressTracker(q *jobQueue, job *BackupJob, handle *jobs.Handle) *progressTracker {
+	p := &progressTracker{q: q, job: job, handle: handle}
+	p.phase.Store(PhaseScanning)
+	return p
+}
+
+// setScanned records the files found in the save directory
+func (p *progressTracker) setScanned(n int) {
+	if p == nil {
+		return
+	}
+	p.scanned.Store(int64(n))
+	p.report(false)
+}
+
+// startArchiving records the files that go into the archive and their size
+func (p *progressTracker) startArchiving(files int, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.archiveStarted = time.Now()
+	p.filesTotal.Store(int64(files))
+	p.bytesTotal.Store(bytes)
+	p.phase.Store(PhaseArchiving)
+	p.handle.Logf("Archiving %d of %d file(s), %d bytes", files, p.scanned.Load(), bytes)
+	p.report(true)
+}
+
+func (p *progressTracker) fileDone() {
+	if p == nil {
+		return
+	}
+	p.filesDone.Add(1)
+	p.report(false)
+}
+
+func (p *progressTracker) setPhase(phase string) {
+	if p == nil {
+		return
+	}
+	p.phase.Store(phase)
+	p.handle.Logf("Started %s", phase)
+	p.report(true)
+}
+
+// reader counts the bytes read from a file being archived
+func (p *progressTracker) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &countingReader{r: r, n: &p.bytesRead, onRead: func() { p.report(false) }}
+}
+
+// writer counts the compressed bytes written to the archive
+func (p *progressTracker) writer(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return &countingWriter{w: w, n: &p.compressed}
+}
+
+// snapshot returns the current progress
+func (p *progressTracker) snapshot() Progress {
+	progress := Progress{
+		Phase:           p.phase.Load().(string),
+		FilesScanned:    int(p.scanned.Load()),
+		FilesTotal:      int(p.filesTotal.Load()),
+		FilesDone:       int(p.filesDone.Load()),
+		BytesTotal:      p.bytesTotal.Load(),
+		BytesRead:       p.bytesRead.Load(),
+		BytesCompressed: p.compressed.Load(),
+	}
+	switch {
+	case progress.Phase == PhaseUploading:
+		progress.Percent = 100
+	case progress.BytesTotal > 0:
+		// Files can grow while they are archived
+		progress.Percent = min(100, float64(progress.BytesRead)*100/float64(progress.BytesTotal))
+	}
+	if progress.BytesRead > 0 && progress.BytesRead < progress.BytesTotal && !p.archiveStarted.IsZero() {
+		elapsed := time.Since(p.archiveStarted)
+		remaining := time.Duration(float64(elapsed) * float64(progress.BytesTotal-progress.BytesRead) / float64(progress.BytesRead))
+		progress.ETASeconds = int(remaining.Round(time.Second) / time.Second)
+	}
+	progress.Percent = float64(int(progress.Percent*10)) / 10
+	return progress
+}
+
+// report copies the progress to the job and streams it, unless the last
+// report was less than progressInterval ago and force is false
+func (p *progressTracker) report(force bool) {
+	now := time.Now().UnixNano()
+	last := p.lastReport.Load()
+	if !force && (now-last < int64(progressInterval) || !p.lastReport.CompareAndSwap(last, now)) {
+		return
+	}
+	p.lastReport.Store(now)
+
+	p.q.mu.Lock()
+	defer p.q.mu.Unlock()
+
+	progress := p.snapshot()
+	p.job.Progress = &progress
+	notify.Stream(notify.EventBackupProgress,
+		fmt.Sprintf("%s backup of %s is %.1f%% done", p.job.Type, p.job.Map, progress.Percent),
+		map[string]interface{}{"map": p.job.Map, "job": p.job.ID, "progress": progress})
+}
+
+type countingReader struct {
+	r      io.Reader
+	n      *atomic.Int64
+	onRead func()
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	c.onRead()
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n.Add(int64(n))
+	return n, err
+}
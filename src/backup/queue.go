@@ -0,0 +1,406 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/state"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+	// JobSkipped is a scheduled backup that did not run
+	JobSkipped = "skipped"
+
+	maxJobHistory = 200
+
+	bucketBackupJobs = "backup_jobs"
+)
+
+// BackupJob is a single queued, running or finished backup
+type BackupJob struct {
+	ID       string    `json:"id"`
+	Map      string    `json:"map"`
+	Type     string    `json:"type"`
+	Status   string    `json:"status"`
+	Archive  string    `json:"archive,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Queued   time.Time `json:"queued"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+
+	// Progress is set while the job runs
+	Progress *Progress `json:"progress,omitempty"`
+	// DurationSeconds and CompressedSize are set once the job is done
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	CompressedSize  int64   `json:"compressed_size,omitempty"`
+}
+
+type queuedJob struct {
+	job *BackupJob
+	// handle records the backup in the job history of all operations
+	handle *jobs.Handle
+	run    func(progress *progressTracker) (string, error)
+}
+
+// jobQueue runs backups in submission order with at most maxParallel of them
+// running at the same time.
+type jobQueue struct {
+	mu          sync.Mutex
+	maxParallel int
+	running     int
+	pending     []*queuedJob
+	jobs        []*BackupJob
+}
+
+func newJobQueue(maxParallel int) *jobQueue {
+	if maxParallel <= 0 {
+		maxParallel = 1
+	}
+	return &jobQueue{maxParallel: maxParallel}
+}
+
+func (q *jobQueue) submit(mapName string, backupType string, run func(progress *progressTracker) (string, error)) *BackupJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// A map never needs more than one backup waiting in the queue
+	for _, p := range q.pending {
+		if p.job.Map == mapName {
+			return p.job
+		}
+	}
+
+	handle := jobs.New(jobs.TypeBackup, mapName)
+	job := &BackupJob{
+		ID:     handle.ID(),
+		Map:    mapName,
+		Type:   backupType,
+		Status: JobQueued,
+		Queued: time.Now(),
+	}
+	q.jobs = append(q.jobs, job)
+	if len(q.jobs) > maxJobHistory {
+		q.jobs = q.jobs[len(q.jobs)-maxJobHistory:]
+	}
+
+	q.pending = append(q.pending, &queuedJob{job: job, handle: handle, run: run})
+	q.persistLocked(job)
+	handle.Logf("Queued %s backup", backupType)
+	handle.SetCancel(func() error {
+		_, err := q.cancel(job.ID)
+		return err
+	})
+	q.dispatchLocked()
+	return job
+}
+
+// skip records a scheduled backup that did not run, and the reason why, in
+// the backup job history and in the history of all operations
+func (q *jobQueue) skip(mapName string, reason string) BackupJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	handle := jobs.New(jobs.TypeBackup, mapName)
+	handle.Logf("Skipped scheduled backup: %s", reason)
+	handle.Finish(fmt.Errorf("%w: %s", jobs.ErrSkipped, reason))
+
+	now := time.Now()
+	job := &BackupJob{
+		ID:       handle.ID(),
+		Map:      mapName,
+		Type:     BackupTypeIncremental,
+		Status:   JobSkipped,
+		Reason:   reason,
+		Queued:   now,
+		Finished: now,
+	}
+	q.jobs = append(q.jobs, job)
+	if len(q.jobs) > maxJobHistory {
+		q.jobs = q.jobs[len(q.jobs)-maxJobHistory:]
+	}
+	q.persistLocked(job)
+	q.trimHistoryLocked()
+	return *job
+}
+
+// persistLocked records the current state of a job in the job history and
+// streams it to live subscribers
+func (q *jobQueue) persistLocked(job *BackupJob) {
+	if err := state.Put(bucketBackupJobs, job.ID, job); err != nil {
+		log.Printf("Failed to persist backup job %s: %v", job.ID, err)
+	}
+	notify.Stream(notify.EventBackupJob, fmt.Sprintf("%s backup of %s is %s", job.Type, job.Map, job.Status), map[string]interface{}{"map": job.Map, "job": *job})
+}
+
+// trimHistoryLocked drops the oldest finished jobs beyond maxJobHistory
+func (q *jobQueue) trimHistoryLocked() {
+	jobs, err := storedJobs()
+	if err != nil || len(jobs) <= maxJobHistory {
+		return
+	}
+	for _, job := range jobs[maxJobHistory:] {
+		if job.Status == JobQueued || job.Status == JobRunning {
+			continue
+		}
+		if err := state.Delete(bucketBackupJobs, job.ID); err != nil {
+			log.Printf("Failed to remove backup job %s from history: %v", job.ID, err)
+		}
+	}
+}
+
+// storedJobs returns the persisted job history, newest first
+func storedJobs() ([]BackupJob, error) {
+	var jobs []BackupJob
+	err := state.ForEach(bucketBackupJobs, func(key string, value []byte) error {
+		var job BackupJob
+		if err := json.Unmarshal(value, &job); err != nil {
+			return nil
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Queued.After(jobs[j].Queued) })
+	return jobs, err
+}
+
+// recoverInterrupted marks jobs that were queued or running when the
+// manager stopped as failed.
+func (q *jobQueue) recoverInterrupted() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs, err := storedJobs()
+	if err != nil {
+		log.Printf("Failed to read backup job history: %v", err)
+		return
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Status != JobQueued && job.Status != JobRunning {
+			continue
+		}
+		if q.known(job.ID) {
+			continue
+		}
+		job.Status = JobFailed
+		job.Error = "interrupted by manager restart"
+		job.Finished = time.Now()
+		q.persistLocked(job)
+	}
+}
+
+func (q *jobQueue) known(id string) bool {
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *jobQueue) dispatchLocked() {
+	for q.running < q.maxParallel && len(q.pending) > 0 {
+		next := q.pending[0]
+		q.pending = q.pending[1:]
+		q.running++
+
+		next.job.Status = JobRunning
+		next.job.Started = time.Now()
+		q.persistLocked(next.job)
+		// A running backup is not interrupted, it would leave a partial archive
+		next.handle.NoCancel()
+		next.handle.Start()
+		progress := newProgressTracker(q, next.job, next.handle)
+
+		go func(next *queuedJob) {
+			archivePath, err := next.run(progress)
+
+			q.mu.Lock()
+			defer q.mu.Unlock()
+
+			next.job.Finished = time.Now()
+			next.job.DurationSeconds = next.job.Finished.Sub(next.job.Started).Round(100 * time.Millisecond).Seconds()
+			next.job.Progress = nil
+			if err != nil {
+				next.job.Status = JobFailed
+				next.job.Error = err.Error()
+				log.Printf("Backup job %s failed: %v", next.job.ID, err)
+			} else {
+				next.job.Status = JobDone
+				if archivePath != "" {
+					next.job.Archive = filepath.Base(archivePath)
+					if info, err := os.Stat(archivePath); err == nil {
+						next.job.CompressedSize = info.Size()
+					}
+					next.handle.Logf("Created %s (%d bytes)", next.job.Archive, next.job.CompressedSize)
+				} else {
+					next.handle.Logf("Nothing changed since the previous backup")
+				}
+			}
+			next.handle.Finish(err)
+
+			q.persistLocked(next.job)
+			q.trimHistoryLocked()
+			publishJob(*next.job)
+
+			q.running--
+			q.dispatchLocked()
+		}(next)
+	}
+}
+
+// cancel removes a job that has not started yet from the queue
+func (q *jobQueue) cancel(id string) (*BackupJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, p := range q.pending {
+		if p.job.ID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			p.job.Status = JobCancelled
+			p.job.Finished = time.Now()
+			q.persistLocked(p.job)
+			p.handle.Finish(jobs.ErrCancelled)
+			return p.job, nil
+		}
+	}
+
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job, fmt.Errorf("job %s is %s and can no longer be cancelled", id, job.Status)
+		}
+	}
+	return nil, fmt.Errorf("job %s not found", id)
+}
+
+// get returns a job, running jobs with their current progress
+func (q *jobQueue) get(id string) (BackupJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return *job, true
+		}
+	}
+	var job BackupJob
+	found, err := state.Get(bucketBackupJobs, id, &job)
+	if err != nil {
+		log.Printf("Failed to read backup job %s: %v", id, err)
+	}
+	return job, found
+}
+
+// list returns the job history, newest first, optionally filtered by map.
+// Jobs of this run are served from memory if the state store is unavailable.
+func (q *jobQueue) list(mapName string) []BackupJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs, err := storedJobs()
+	if err != nil {
+		log.Printf("Failed to read backup job history: %v", err)
+		jobs = jobs[:0]
+		for i := len(q.jobs) - 1; i >= 0; i-- {
+			jobs = append(jobs, *q.jobs[i])
+		}
+	}
+
+	filtered := make([]BackupJob, 0, len(jobs))
+	for _, job := range jobs {
+		if mapName == "" || job.Map == mapName {
+			filtered = append(filtered, job)
+		}
+	}
+	return filtered
+}
+
+// QueueBackup adds a backup of a map to the job queue, unless the quota of
+// the map's tenant is used up
+func (bm *BackupManager) QueueBackup(mapName string, full bool) (BackupJob, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return BackupJob{}, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	if err := bm.checkQuota(mapName); err != nil {
+		return BackupJob{}, err
+	}
+
+	return bm.queueBackup(mapName, config, full), nil
+}
+
+// QueueDirectoryBackup queues a backup of a directory that is not a map,
+// such as a cluster's transfer directory. name is used for the archive
+// names and the job like a map name.
+func (bm *BackupManager) QueueDirectoryBackup(name string, config MapConfig, full bool) (BackupJob, error) {
+	if config.ExtractDir == "" || config.ZipDir == "" {
+		return BackupJob{}, fmt.Errorf("backup of %s needs a source and a backup directory", name)
+	}
+	return bm.queueBackup(name, config, full), nil
+}
+
+func (bm *BackupManager) queueBackup(name string, config MapConfig, full bool) BackupJob {
+	backupType := BackupTypeIncremental
+	if full {
+		backupType = BackupTypeFull
+	}
+
+	job := bm.queue.submit(name, backupType, func(progress *progressTracker) (string, error) {
+		return bm.runBackup(name, config, full, progress)
+	})
+
+	bm.queue.mu.Lock()
+	defer bm.queue.mu.Unlock()
+	return *job
+}
+
+// BackupJobs lists recent backup jobs, optionally only those of one map
+func (bm *BackupManager) BackupJobs(mapName string) []BackupJob {
+	return bm.queue.list(mapName)
+}
+
+// BackupJob returns a backup job by ID
+func (bm *BackupManager) BackupJob(id string) (BackupJob, bool) {
+	return bm.queue.get(id)
+}
+
+// CancelBackupJob cancels a backup that is still waiting in the queue
+func (bm *BackupManager) CancelBackupJob(id string) (BackupJob, error) {
+	job, err := bm.queue.cancel(id)
+	if job == nil {
+		return BackupJob{}, err
+	}
+
+	bm.queue.mu.Lock()
+	defer bm.queue.mu.Unlock()
+	return *job, err
+}
+
+// publishJob notifies subscribers of a finished backup job
+func publishJob(job BackupJob) {
+	data := map[string]interface{}{"map": job.Map, "job": job.ID, "type": job.Type, "archive": job.Archive}
+	if job.Status == JobFailed {
+		data["error"] = job.Error
+		notify.Publish(notify.EventBackupFailed, fmt.Sprintf("%s backup of %s failed: %s", job.Type, job.Map, job.Error), data)
+		return
+	}
+	if job.Archive == "" {
+		// Nothing changed since the previous backup
+		return
+	}
+	notify.Publish(notify.EventBackupCompleted, fmt.Sprintf("%s backup of %s completed: %s", job.Type, job.Map, job.Archive), data)
+}
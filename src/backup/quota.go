@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"asa_servermanager_api/tenants"
+)
+
+// BackupUsage returns the size of the backup archives of maps, maps
+// without a backup config have none
+func (bm *BackupManager) BackupUsage(maps []string) (int64, error) {
+	var used int64
+	for _, mapName := range maps {
+		archives, err := bm.ListBackups(mapName, "")
+		if errors.Is(err, ErrMapNotConfigured) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to list backups of %s: %w", mapName, err)
+		}
+		for _, archive := range archives {
+			used += archive.Size
+		}
+	}
+	return used, nil
+}
+
+// lastBackup returns when the newest backup of a map that ran started,
+// the zero time if none did
+func (bm *BackupManager) lastBackup(mapName string) time.Time {
+	for _, job := range bm.queue.list(mapName) {
+		if job.Status == JobRunning || job.Status == JobDone {
+			return job.Started
+		}
+	}
+	return time.Time{}
+}
+
+// checkQuota reports why the quota of the tenant owning a map does not
+// allow another backup of it now
+func (bm *BackupManager) checkQuota(mapName string) error {
+	t, quota, ok := tenants.QuotaOf(mapName)
+	if !ok {
+		return nil
+	}
+	if quota.BackupIntervalMinutes > 0 {
+		interval := time.Duration(quota.BackupIntervalMinutes) * time.Minute
+		if last := bm.lastBackup(mapName); time.Since(last) < interval {
+			return fmt.Errorf("%w: tenant %s allows one backup of map %s every %d minutes, the next one from %s",
+				tenants.ErrQuotaExceeded, t.Name, mapName, quota.BackupIntervalMinutes, last.Add(interval).Format(time.RFC3339))
+		}
+	}
+	if quota.BackupBytes > 0 {
+		used, err := bm.BackupUsage(t.Maps)
+		if err != nil {
+			return err
+		}
+		if used >= quota.BackupBytes {
+			return fmt.Errorf("%w: backups of tenant %s use %d of %d bytes", tenants.ErrQuotaExceeded, t.Name, used, quota.BackupBytes)
+		}
+	}
+	return nil
+}
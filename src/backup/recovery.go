@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/jobs"
+)
+
+const (
+	// TriggerCrashRecovery is the snapshot a crash-looping map's save is
+	// kept in before it is rolled back
+	TriggerCrashRecovery = "crash_recovery"
+	// TagCorruptSave marks that snapshot, which retention keeps
+	TagCorruptSave = "corrupt-save"
+)
+
+// SaveRecovery is the outcome of rolling back a crash-looping map
+type SaveRecovery struct {
+	Map     string    `json:"map"`
+	Archive string    `json:"archive"`
+	Created time.Time `json:"created"`
+	// Chain lists the archives applied, the full backup first
+	Chain []string `json:"chain"`
+	Files []string `json:"files"`
+	// CorruptSave is the snapshot of the save the server crashed on
+	CorruptSave string `json:"corrupt_save"`
+	// Skipped are newer backups that failed verification
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// RecoverSave rolls a map back to its newest backup taken at or before
+// before whose archives all pass verification. The current save is kept
+// first in a snapshot tagged corrupt-save, the recovery is aborted if that
+// fails. Every step is logged to job. A global dry run stops with an
+// error once the archive is picked.
+func (bm *BackupManager) RecoverSave(job *jobs.Handle, mapName string, before time.Time) (SaveRecovery, error) {
+	result := SaveRecovery{Map: mapName}
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return result, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+
+	archive, chain, skipped, err := newestVerified(job, mapName, config, before)
+	result.Skipped = skipped
+	if err != nil {
+		return result, err
+	}
+	result.Archive = archive.Name
+	result.Created = archive.ModTime
+	for _, link := range chain {
+		result.Chain = append(result.Chain, filepath.Base(link))
+	}
+	job.Logf("Rolling back to %s from %s", archive.Name, archive.ModTime.Format(time.RFC3339))
+	if dryrun.Global() {
+		return result, fmt.Errorf("dry run, %s would have been restored", archive.Name)
+	}
+
+	snapshot, err := bm.snapshot(mapName, config, TriggerCrashRecovery, false)
+	if err != nil {
+		return result, fmt.Errorf("failed to keep the current save, it is not rolled back: %w", err)
+	}
+	result.CorruptSave = filepath.Base(snapshot)
+	note := fmt.Sprintf("Save the server crash-looped on, replaced by %s", archive.Name)
+	if _, err := bm.TagBackup(mapName, result.CorruptSave, []string{TagCorruptSave}, note); err != nil {
+		// Untagged it is still kept for the snapshot grace period
+		log.Printf("Failed to tag snapshot %s of map %s: %v", result.CorruptSave, mapName, err)
+		job.Logf("Failed to tag %s: %v", result.CorruptSave, err)
+	}
+	job.Logf("Kept the current save in %s", result.CorruptSave)
+
+	result.Files, err = bm.restore(mapName, config, archive.Name, "")
+	if err != nil {
+		return result, err
+	}
+	job.Logf("Restored %d file(s) from %d archive(s)", len(result.Files), len(chain))
+	return result, nil
+}
+
+// newestVerified returns the newest archive created at or before before
+// whose chain is complete and verifies, with that chain oldest first. It
+// also returns the newer archives that were skipped. Crash recovery
+// snapshots are never picked.
+func newestVerified(job *jobs.Handle, mapName string, config MapConfig, before time.Time) (archiveInfo, []string, []string, error) {
+	archives, err := listArchives(mapName, config)
+	if err != nil {
+		return archiveInfo{}, nil, nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	var skipped []string
+	for i := len(archives) - 1; i >= 0; i-- {
+		archive := archives[i]
+		if archive.ModTime.After(before) || archive.Snapshot == TriggerCrashRecovery {
+			continue
+		}
+		chain, err := resolveChain(config, archive.Path)
+		if err != nil {
+			job.Logf("Skipping %s: %v", archive.Name, err)
+			skipped = append(skipped, archive.Name)
+			continue
+		}
+		valid := true
+		for _, link := range chain {
+			if result := VerifyArchive(link); !result.Valid {
+				job.Logf("Skipping %s, %s failed verification: %v", archive.Name, result.Archive, result.Errors)
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			skipped = append(skipped, archive.Name)
+			continue
+		}
+		return archive, chain, skipped, nil
+	}
+	return archiveInfo{}, nil, skipped, fmt.Errorf("%w: no verified backup at or before %s", ErrNoRestorePoint, before.Format(time.RFC3339))
+}
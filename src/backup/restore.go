@@ -0,0 +1,291 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/maplock"
+)
+
+// RestoreBackup restores an archive of a map into its ExtractDir. Incremental
+// archives are restored by applying their full backup and every incremental
+// up to and including the requested one. When fileName is set only that
+// file is restored. Archives that fail verification are refused.
+func (bm *BackupManager) RestoreBackup(mapName string, archiveName string, fileName string) ([]string, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	return bm.restore(mapName, config, archiveName, fileName)
+}
+
+// RestoreDirectoryBackup restores an archive made by QueueDirectoryBackup
+// into config.ExtractDir, like RestoreBackup does for maps
+func (bm *BackupManager) RestoreDirectoryBackup(name string, config MapConfig, archiveName string, fileName string) ([]string, error) {
+	if config.ExtractDir == "" || config.ZipDir == "" {
+		return nil, fmt.Errorf("restore of %s needs a source and a backup directory", name)
+	}
+	return bm.restore(name, config, archiveName, fileName)
+}
+
+func (bm *BackupManager) restore(mapName string, config MapConfig, archiveName string, fileName string) ([]string, error) {
+	return bm.restoreInto(mapName, config, archiveName, fileName, config.ExtractDir, mapName)
+}
+
+// restoreInto restores an archive of a map into dir while holding the locks
+// of the given maps. It runs as a job that cannot be cancelled, a partly
+// restored map would be inconsistent.
+func (bm *BackupManager) restoreInto(mapName string, config MapConfig, archiveName string, fileName string, dir string, locks ...string) (restored []string, err error) {
+	job := jobs.New(jobs.TypeRestore, mapName)
+	job.Start()
+	defer func() { job.Finish(err) }()
+	if fileName != "" {
+		job.Logf("Restoring %s from %s", fileName, archiveName)
+	} else {
+		job.Logf("Restoring %s", archiveName)
+	}
+	if dir != config.ExtractDir {
+		job.Logf("Restoring into %s", dir)
+	}
+
+	archivePath := filepath.Join(config.ZipDir, filepath.Base(archiveName))
+	if _, err := os.Stat(archivePath); err != nil {
+		return nil, fmt.Errorf("backup %s not found: %w", archiveName, err)
+	}
+
+	chain, err := resolveChain(config, archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, link := range chain {
+		result := VerifyArchive(link)
+		if !result.Valid {
+			return nil, fmt.Errorf("backup %s is corrupt and cannot be restored: %v", filepath.Base(link), result.Errors)
+		}
+		if result.Status == VerifyStatusLegacy {
+			job.Logf("%s has no manifest, only the archive's own checksums were verified", filepath.Base(link))
+		}
+	}
+
+	for _, lockMap := range locks {
+		release, err := maplock.TryAcquire(lockMap, maplock.OpRestore)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	// A restore over a map's saves takes a snapshot of them first
+	if len(locks) > 0 {
+		if current, ok := bm.mapConfig(locks[0]); ok && current.ExtractDir == dir {
+			snapshot, err := bm.SnapshotBefore(locks[0], TriggerRestore, true)
+			if err != nil {
+				return nil, err
+			}
+			if snapshot != "" {
+				job.Logf("Took snapshot %s", filepath.Base(snapshot))
+			}
+		}
+	}
+
+	// The archives are read from the map's ZipDir and written to dir
+	target := config
+	target.ExtractDir = dir
+	wanted := filepath.ToSlash(fileName)
+	seen := make(map[string]bool)
+
+	for _, link := range chain {
+		job.Logf("Applying %s", filepath.Base(link))
+		files, err := restoreArchive(target, link, wanted)
+		if err != nil {
+			return restored, err
+		}
+		for _, name := range files {
+			if !seen[name] {
+				seen[name] = true
+				restored = append(restored, name)
+			}
+		}
+
+		if wanted != "" {
+			continue
+		}
+		manifest, err := ReadManifest(link)
+		if err != nil {
+			continue
+		}
+		for _, name := range manifest.Deleted {
+			path, err := safeExtractPath(dir, name)
+			if err != nil {
+				return restored, err
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return restored, fmt.Errorf("failed to remove %s: %w", name, err)
+			}
+		}
+	}
+
+	if wanted != "" && len(restored) == 0 {
+		return nil, fmt.Errorf("file %s not found in backup %s", fileName, archiveName)
+	}
+
+	log.Printf("Restored %d file(s) from %d archive(s) ending at %s for map %s into %s", len(restored), len(chain), archiveName, mapName, dir)
+	job.Logf("Restored %d file(s) from %d archive(s)", len(restored), len(chain))
+	return restored, nil
+}
+
+// resolveChain returns the archives needed to restore archivePath, oldest
+// first, by following the parent links of incremental manifests back to the
+// full backup. Archives without a manifest are treated as full backups.
+func resolveChain(config MapConfig, archivePath string) ([]string, error) {
+	chain := []string{archivePath}
+	current := archivePath
+
+	for {
+		manifest, err := ReadManifest(current)
+		if err != nil || manifest.Type != BackupTypeIncremental {
+			return chain, nil
+		}
+		if manifest.Parent == "" {
+			return nil, fmt.Errorf("incremental backup %s has no parent", manifest.Archive)
+		}
+
+		parent := filepath.Join(config.ZipDir, filepath.Base(manifest.Parent))
+		if _, err := os.Stat(parent); err != nil {
+			return nil, fmt.Errorf("backup chain of %s is broken, %s is missing", manifest.Archive, manifest.Parent)
+		}
+		chain = append([]string{parent}, chain...)
+		current = parent
+	}
+}
+
+func restoreArchive(config MapConfig, archivePath string, wanted string) ([]string, error) {
+	var restored []string
+	err := walkArchive(archivePath, func(name string, modTime time.Time, r io.Reader) error {
+		if wanted != "" && name != wanted {
+			return nil
+		}
+		target, err := safeExtractPath(config.ExtractDir, name)
+		if err != nil {
+			return err
+		}
+		if err := extractFile(r, modTime, target); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+		restored = append(restored, name)
+		return nil
+	})
+	if err != nil {
+		return restored, fmt.Errorf("failed to read backup %s: %w", filepath.Base(archivePath), err)
+	}
+	return restored, nil
+}
+
+// safeExtractPath maps an archive entry to a path inside dir, recreating the
+// directory tree and rejecting entries that would escape it.
+func safeExtractPath(dir string, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(name) {
+		return "", fmt.Errorf("invalid path in archive: %s", name)
+	}
+	return target, nil
+}
+
+func extractFile(r io.Reader, modTime time.Time, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if modTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(target, modTime, modTime)
+}
+
+// PointInTimeRestore describes a restore to the newest backup taken at or
+// before a point in time
+type PointInTimeRestore struct {
+	Map     string    `json:"map"`
+	Target  time.Time `json:"target"`
+	Archive string    `json:"archive"`
+	Created time.Time `json:"created"`
+	// Chain lists the archives applied, the full backup first
+	Chain []string `json:"chain"`
+	Files []string `json:"files"`
+	// Preview is set instead of Files for dry runs
+	Preview *RestorePreview `json:"preview,omitempty"`
+}
+
+// resolvePointInTime returns the newest archive created at or before at
+// whose chain is complete, with that chain oldest first
+func resolvePointInTime(mapName string, config MapConfig, at time.Time) (archiveInfo, []string, error) {
+	archives, err := listArchives(mapName, config)
+	if err != nil {
+		return archiveInfo{}, nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	for i := len(archives) - 1; i >= 0; i-- {
+		if archives[i].ModTime.After(at) {
+			continue
+		}
+		chain, err := resolveChain(config, archives[i].Path)
+		if err != nil {
+			log.Printf("Skipping %s for point-in-time restore: %v", archives[i].Name, err)
+			continue
+		}
+		return archives[i], chain, nil
+	}
+	return archiveInfo{}, nil, fmt.Errorf("%w at or before %s", ErrNoRestorePoint, at.Format(time.RFC3339))
+}
+
+// RestorePointInTime restores the state of a map at a point in time from the
+// newest backup taken at or before it. The full backup and incrementals
+// leading up to that backup are applied in order. A dry run only previews
+// the restore.
+func (bm *BackupManager) RestorePointInTime(mapName string, at time.Time, dryRun bool) (PointInTimeRestore, error) {
+	result := PointInTimeRestore{Map: mapName, Target: at}
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return result, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+
+	archive, chain, err := resolvePointInTime(mapName, config, at)
+	if err != nil {
+		return result, err
+	}
+	result.Archive = archive.Name
+	result.Created = archive.ModTime
+	for _, link := range chain {
+		result.Chain = append(result.Chain, filepath.Base(link))
+	}
+
+	if dryRun {
+		preview, err := bm.previewRestore(mapName, config, archive.Name, "")
+		if err != nil {
+			return result, err
+		}
+		result.Preview = &preview
+		return result, nil
+	}
+
+	result.Files, err = bm.restore(mapName, config, archive.Name, "")
+	return result, err
+}
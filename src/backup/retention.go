@@ -0,0 +1,308 @@
+package backup
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/maplock"
+)
+
+// archiveInfo is a backup archive found in a map's ZipDir
+type archiveInfo struct {
+	Path    string
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Type    string
+	Chain   string
+	KeyID   string
+	// Snapshot is the operation a snapshot was taken before
+	Snapshot string
+	Tags     []string
+	Note     string
+}
+
+// backupChain is a full backup together with the incrementals built on it.
+// Retention always removes whole chains, since an incremental is useless
+// without the archives before it.
+type backupChain struct {
+	Archives []archiveInfo
+	Size     int64
+	Newest   time.Time
+	HasFull  bool
+	// Snapshot chains consist of a single snapshot
+	Snapshot bool
+	// Tagged chains contain a tagged archive
+	Tagged bool
+}
+
+// exempt reports whether retention must keep the chain: it contains a
+// tagged archive or is a snapshot still in its grace period
+func (c *backupChain) exempt(grace time.Duration) bool {
+	return c.Tagged || c.Snapshot && time.Since(c.Newest) < grace
+}
+
+// protectedChain returns the index of the newest chain starting with a full
+// backup that is not a snapshot, or -1
+func protectedChain(chains []*backupChain) int {
+	for i := len(chains) - 1; i >= 0; i-- {
+		if chains[i].HasFull && !chains[i].Snapshot {
+			return i
+		}
+	}
+	return -1
+}
+
+func listArchives(mapName string, config MapConfig) ([]archiveInfo, error) {
+	paths, err := findArchives(config.ZipDir, mapName)
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make([]archiveInfo, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		archive := archiveInfo{
+			Path:    path,
+			Name:    info.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Type:    BackupTypeFull,
+			Chain:   info.Name(),
+		}
+		if manifest, err := ReadManifest(path); err == nil {
+			archive.ModTime = manifest.Created
+			archive.KeyID = manifest.KeyID
+			archive.Snapshot = manifest.Snapshot
+			archive.Tags = manifest.Tags
+			archive.Note = manifest.Note
+			if manifest.Type == BackupTypeIncremental {
+				archive.Type = BackupTypeIncremental
+				archive.Chain = manifest.Base
+			}
+		}
+		archives = append(archives, archive)
+	}
+
+	sort.Slice(archives, func(i, j int) bool { return archives[i].ModTime.Before(archives[j].ModTime) })
+	return archives, nil
+}
+
+// Archive is a backup archive as reported by ListArchives
+type Archive struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	// KeyID names the key the archive is encrypted with
+	KeyID string `json:"key_id,omitempty"`
+	// Snapshot names the operation a snapshot was taken before
+	Snapshot string `json:"snapshot,omitempty"`
+	// Tags and Note describe the archive, tagged archives are never
+	// removed by retention
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+}
+
+// ListArchives returns the archives of name in config.ZipDir, newest first
+func ListArchives(name string, config MapConfig) ([]Archive, error) {
+	found, err := listArchives(name, config)
+	if err != nil {
+		return nil, err
+	}
+	archives := make([]Archive, 0, len(found))
+	for i := len(found) - 1; i >= 0; i-- {
+		archives = append(archives, Archive{Name: found[i].Name, Type: found[i].Type, Size: found[i].Size, Created: found[i].ModTime, KeyID: found[i].KeyID, Snapshot: found[i].Snapshot, Tags: found[i].Tags, Note: found[i].Note})
+	}
+	return archives, nil
+}
+
+// ListBackups returns a map's archives, newest first. A non-empty file
+// limits them to archives whose manifest lists that file.
+func (bm *BackupManager) ListBackups(mapName string, file string) ([]Archive, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	archives, err := ListArchives(mapName, config)
+	if err != nil || file == "" {
+		return archives, err
+	}
+
+	file = filepath.ToSlash(file)
+	matching := archives[:0]
+	for _, archive := range archives {
+		manifest, err := ReadManifest(filepath.Join(config.ZipDir, archive.Name))
+		if err != nil {
+			continue
+		}
+		for _, entry := range manifest.Files {
+			if filepath.ToSlash(entry.Name) == file {
+				matching = append(matching, archive)
+				break
+			}
+		}
+	}
+	return matching, nil
+}
+
+// groupChains returns the backup chains of a map, oldest first
+func groupChains(archives []archiveInfo) []*backupChain {
+	byName := make(map[string]*backupChain)
+	var chains []*backupChain
+
+	for _, archive := range archives {
+		chain, ok := byName[archive.Chain]
+		if !ok {
+			chain = &backupChain{}
+			byName[archive.Chain] = chain
+			chains = append(chains, chain)
+		}
+		chain.Archives = append(chain.Archives, archive)
+		chain.Size += archive.Size
+		if archive.ModTime.After(chain.Newest) {
+			chain.Newest = archive.ModTime
+		}
+		if archive.Type == BackupTypeFull {
+			chain.HasFull = true
+		}
+		chain.Snapshot = archive.Snapshot != ""
+		chain.Tagged = chain.Tagged || len(archive.Tags) > 0
+	}
+
+	sort.Slice(chains, func(i, j int) bool { return chains[i].Newest.Before(chains[j].Newest) })
+	return chains
+}
+
+// expiredChains returns the chains the map's retention policy removes:
+// chains older than RetentionDays, beyond MaxBackups or over
+// MaxTotalSizeMB, oldest first. The newest chain that starts with a full
+// backup is never among them, so there is always a restore point left.
+// Chains with a tagged archive and snapshots in their grace period are
+// neither removed nor counted.
+func (bm *BackupManager) expiredChains(mapName string, config MapConfig) ([]*backupChain, error) {
+	archives, err := listArchives(mapName, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	chains := groupChains(archives)
+	protected := protectedChain(chains)
+	grace := bm.snapshotGrace()
+
+	var count int
+	var totalSize int64
+	for _, chain := range chains {
+		if chain.exempt(grace) {
+			continue
+		}
+		count += len(chain.Archives)
+		totalSize += chain.Size
+	}
+
+	cutoff := time.Now().Add(-time.Duration(config.RetentionDays) * 24 * time.Hour)
+	maxSize := config.MaxTotalSizeMB * 1024 * 1024
+
+	var expired []*backupChain
+	for i, chain := range chains {
+		if i == protected || chain.exempt(grace) {
+			continue
+		}
+
+		old := config.RetentionDays > 0 && chain.Newest.Before(cutoff)
+		overCount := config.MaxBackups > 0 && count > config.MaxBackups
+		overSize := maxSize > 0 && totalSize > maxSize
+		if !old && !overCount && !overSize {
+			continue
+		}
+		expired = append(expired, chain)
+		count -= len(chain.Archives)
+		totalSize -= chain.Size
+	}
+	return expired, nil
+}
+
+// RemoveOldBackups applies the map's retention policy, see expiredChains.
+// During a global dry run the archives are only logged.
+func (bm *BackupManager) RemoveOldBackups(mapName string, config MapConfig) error {
+	plan, err := bm.removeOldBackups(mapName, config, dryrun.Global())
+	if err == nil && dryrun.Global() && len(plan.Deleted) > 0 {
+		plan.Log()
+	}
+	return err
+}
+
+// removeOldBackups removes the expired chains of a map, or with dryRun
+// only lists their files
+func (bm *BackupManager) removeOldBackups(mapName string, config MapConfig, dryRun bool) (dryrun.Plan, error) {
+	plan := dryrun.Plan{Operation: "backup retention", Map: mapName}
+	expired, err := bm.expiredChains(mapName, config)
+	if err != nil {
+		return plan, err
+	}
+	if dryRun {
+		for _, chain := range expired {
+			plan.Deleted = append(plan.Deleted, chainFiles(chain)...)
+		}
+		return plan, nil
+	}
+
+	dedup := false
+	for _, chain := range expired {
+		plan.Deleted = append(plan.Deleted, chainFiles(chain)...)
+		for _, archive := range chain.Archives {
+			if err := removeArchive(archive.Path); err != nil {
+				return plan, fmt.Errorf("failed to remove old backup: %w", err)
+			}
+			log.Printf("Removed old backup %s of map %s", archive.Name, mapName)
+			dedup = dedup || strings.HasSuffix(archive.Name, "."+FormatDedup)
+		}
+	}
+
+	if dedup {
+		collectGarbage()
+	}
+	return plan, nil
+}
+
+// chainFiles returns the archives of a chain and the files stored next to
+// them, the files removing the chain deletes
+func chainFiles(chain *backupChain) []string {
+	var files []string
+	for _, archive := range chain.Archives {
+		files = append(files, archive.Path)
+		for _, sidecar := range archiveSidecars(archive.Path) {
+			if _, err := os.Stat(sidecar); err == nil {
+				files = append(files, sidecar)
+			}
+		}
+	}
+	return files
+}
+
+// PruneBackups applies a map's retention policy now instead of after its
+// next backup. With dryRun it returns the files it would delete. Unused
+// blobs of deduplicated archives are collected afterwards and not listed.
+func (bm *BackupManager) PruneBackups(mapName string, dryRun bool) (dryrun.Plan, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return dryrun.Plan{}, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	if dryRun {
+		return bm.removeOldBackups(mapName, config, true)
+	}
+	release, err := maplock.TryAcquire(mapName, maplock.OpCleanup)
+	if err != nil {
+		return dryrun.Plan{}, err
+	}
+	defer release()
+	return bm.removeOldBackups(mapName, config, false)
+}
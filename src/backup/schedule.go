@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"asa_servermanager_api/cron"
+	"asa_servermanager_api/state"
+)
+
+// backupSchedule is the running backup schedule of a map
+type backupSchedule struct {
+	stop chan struct{}
+	// next is when the next scheduled backup runs, guarded by bm.mu
+	next time.Time
+}
+
+// ScheduleStatus describes the backup schedule of a map
+type ScheduleStatus struct {
+	Map             string    `json:"map"`
+	Enabled         bool      `json:"enabled"`
+	Paused          bool      `json:"paused"`
+	Cron            string    `json:"cron,omitempty"`
+	Timezone        string    `json:"timezone,omitempty"`
+	IntervalMinutes int       `json:"interval_minutes,omitempty"`
+	NextRun         time.Time `json:"next_run,omitempty"`
+}
+
+// location returns the time zone the cron expression is evaluated in
+func (c MapConfig) location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", c.Timezone)
+	}
+	return loc, nil
+}
+
+// ValidateSchedule checks that the map has a cron expression that fires,
+// in a known time zone, or a positive interval
+func (c MapConfig) ValidateSchedule() error {
+	if c.Cron == "" {
+		if c.Timezone != "" {
+			return errors.New("timezone requires cron")
+		}
+		if c.IntervalMinutes <= 0 {
+			return errors.New("interval_minutes must be positive unless cron is set")
+		}
+		return nil
+	}
+	next, err := c.nextRun(time.Now())
+	if err != nil {
+		return err
+	}
+	if next.IsZero() {
+		return fmt.Errorf("cron expression %q never fires", c.Cron)
+	}
+	return nil
+}
+
+// nextRun returns when the scheduled backup after t runs, the zero time if
+// the cron expression never fires
+func (c MapConfig) nextRun(t time.Time) (time.Time, error) {
+	if c.Cron == "" {
+		return t.Add(time.Duration(c.IntervalMinutes) * time.Minute), nil
+	}
+	schedule, err := cron.Parse(c.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := c.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(t.In(loc)), nil
+}
+
+// runSchedule queues the scheduled backups of a map until the schedule is
+// stopped. Interval schedules back up right away, cron schedules wait for
+// their first run. The config is read again before every run, so changes
+// apply from the next one.
+func (bm *BackupManager) runSchedule(mapName string, config MapConfig, schedule *backupSchedule) {
+	if config.Cron == "" {
+		bm.scheduledBackup(mapName)
+	}
+	for {
+		config, ok := bm.mapConfig(mapName)
+		if !ok {
+			return
+		}
+		next, err := config.nextRun(time.Now())
+		if err != nil || next.IsZero() {
+			log.Printf("Backup schedule of map '%s' has no next run, stopping it: %v", mapName, err)
+			return
+		}
+		bm.mu.Lock()
+		schedule.next = next
+		bm.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-schedule.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			bm.scheduledBackup(mapName)
+		}
+	}
+}
+
+// Schedule returns the backup schedule of a map and when it runs next
+func (bm *BackupManager) Schedule(mapName string) (ScheduleStatus, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	config, ok := bm.config.Maps[mapName]
+	if !ok {
+		return ScheduleStatus{}, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	status := ScheduleStatus{Map: mapName, Cron: config.Cron, Timezone: config.Timezone}
+	if config.Cron == "" {
+		status.IntervalMinutes = config.IntervalMinutes
+	}
+	persisted, err := state.Schedule(mapName)
+	if err != nil {
+		return ScheduleStatus{}, fmt.Errorf("failed to read schedule state for %s: %w", mapName, err)
+	}
+	status.Enabled = persisted.Enabled
+	status.Paused = persisted.Paused
+	if schedule, running := bm.schedulers[mapName]; running {
+		status.NextRun = schedule.next
+	}
+	return status, nil
+}
+
+// PauseBackupSchedule keeps a map's backup schedule running but skips its
+// backups until it is resumed. Unlike turning the schedule off, the pause
+// survives restarts of the manager and leaves the backup chain to continue
+// where it stopped.
+func (bm *BackupManager) PauseBackupSchedule(mapName string) (ScheduleStatus, error) {
+	return bm.setPaused(mapName, true)
+}
+
+// ResumeBackupSchedule runs the backups of a paused schedule again, from
+// its next run on
+func (bm *BackupManager) ResumeBackupSchedule(mapName string) (ScheduleStatus, error) {
+	return bm.setPaused(mapName, false)
+}
+
+func (bm *BackupManager) setPaused(mapName string, paused bool) (ScheduleStatus, error) {
+	status, err := bm.Schedule(mapName)
+	if err != nil {
+		return ScheduleStatus{}, err
+	}
+	if !status.Enabled {
+		return ScheduleStatus{}, fmt.Errorf("%w: %s", ErrScheduleNotRunning, mapName)
+	}
+	if err := state.SetSchedulePaused(mapName, paused); err != nil {
+		return ScheduleStatus{}, fmt.Errorf("failed to persist paused schedule: %w", err)
+	}
+	if paused {
+		log.Printf("Paused the backup schedule of map '%s'", mapName)
+	} else {
+		log.Printf("Resumed the backup schedule of map '%s'", mapName)
+	}
+	status.Paused = paused
+	return status, nil
+}
@@ -0,0 +1,211 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/savegame"
+)
+
+// Operations that take a safety snapshot of a map before they run
+const (
+	TriggerModInstall = "mod_install"
+	TriggerUpdate     = "update"
+	TriggerIniChange  = "ini_change"
+	TriggerRestore    = "restore"
+	// TriggerRestart snapshots are only taken when listed in the triggers
+	TriggerRestart = "restart"
+
+	defaultSnapshotGraceHours = 72
+)
+
+var (
+	snapshotTriggers = []string{TriggerModInstall, TriggerUpdate, TriggerIniChange, TriggerRestore}
+
+	validTrigger = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+	ErrInvalidTrigger = errors.New("trigger must be 1 to 32 lowercase letters, digits or underscores")
+)
+
+// SnapshotConfig controls the backups taken before risky operations
+type SnapshotConfig struct {
+	Enabled bool `json:"enabled"`
+	// Triggers limits the snapshots to some operations, default all of
+	// mod_install, update, ini_change and restore. Listing restart also
+	// takes one before every restart.
+	Triggers []string `json:"triggers,omitempty"`
+	// GraceHours is how long a snapshot is kept regardless of the map's
+	// retention policy, default 72
+	GraceHours int `json:"grace_hours"`
+	// AbortOnFailure cancels the operation when its snapshot fails,
+	// otherwise it runs without one
+	AbortOnFailure bool `json:"abort_on_failure"`
+}
+
+func (c SnapshotConfig) grace() time.Duration {
+	if c.GraceHours <= 0 {
+		return defaultSnapshotGraceHours * time.Hour
+	}
+	return time.Duration(c.GraceHours) * time.Hour
+}
+
+func (c SnapshotConfig) takes(trigger string) bool {
+	if !c.Enabled {
+		return false
+	}
+	if len(c.Triggers) == 0 {
+		return slices.Contains(snapshotTriggers, trigger)
+	}
+	return slices.Contains(c.Triggers, trigger)
+}
+
+// TakesSnapshot reports whether snapshots are taken before an operation
+func (bm *BackupManager) TakesSnapshot(trigger string) bool {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.config.Snapshots.takes(trigger)
+}
+
+// snapshotGrace returns how long snapshots are exempt from retention
+func (bm *BackupManager) snapshotGrace() time.Duration {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.config.Snapshots.grace()
+}
+
+// Snapshot takes a full backup of a map outside its backup chain, tagged
+// with the operation it was taken for. Snapshots are kept for the grace
+// period regardless of the retention policy.
+func (bm *BackupManager) Snapshot(mapName string, trigger string) (string, error) {
+	if !validTrigger.MatchString(trigger) {
+		return "", ErrInvalidTrigger
+	}
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	return bm.snapshot(mapName, config, trigger, false)
+}
+
+// SnapshotBefore takes a snapshot of a map before a risky operation if
+// snapshots are enabled for it. locked tells that the caller holds the
+// map's lock already. A failed snapshot is only returned as an error when
+// the operation should be aborted, maps without a backup configuration
+// are skipped.
+func (bm *BackupManager) SnapshotBefore(mapName string, trigger string, locked bool) (string, error) {
+	bm.mu.Lock()
+	snapshots := bm.config.Snapshots
+	bm.mu.Unlock()
+	if !snapshots.takes(trigger) {
+		return "", nil
+	}
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return "", nil
+	}
+
+	archivePath, err := bm.snapshot(mapName, config, trigger, locked)
+	if err != nil {
+		if snapshots.AbortOnFailure {
+			return "", fmt.Errorf("snapshot of map %s before %s failed: %w", mapName, trigger, err)
+		}
+		log.Printf("Snapshot of map %s before %s failed, continuing without one: %v", mapName, trigger, err)
+		return "", nil
+	}
+	return archivePath, nil
+}
+
+func (bm *BackupManager) snapshot(mapName string, config MapConfig, trigger string, locked bool) (archivePath string, err error) {
+	job := jobs.New(jobs.TypeBackup, mapName)
+	job.NoCancel()
+	job.Start()
+	defer func() { job.Finish(err) }()
+	job.Logf("Taking a snapshot before %s", trigger)
+
+	if !locked {
+		release, err := maplock.Acquire(context.Background(), mapName, maplock.OpBackup)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+	}
+
+	files, err := collectBackupFiles(config)
+	if err != nil {
+		return "", err
+	}
+	var sourceBytes int64
+	for _, filePath := range files {
+		if info, err := os.Stat(filePath); err == nil {
+			sourceBytes += info.Size()
+		}
+	}
+	if err := bm.ensureSpace(mapName, config, sourceBytes); err != nil {
+		return "", err
+	}
+
+	format := archiveFormat(config.Format)
+	archivePath, err = reserveArchive(config.ZipDir, mapName, "snapshot", format)
+	if err != nil {
+		return "", err
+	}
+	entries, keyID, err := bm.writeArchive(archivePath, format, config, files, nil)
+	if err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+
+	// Snapshots are full backups, but never the base of incrementals
+	manifest := &Manifest{Map: mapName, Type: BackupTypeFull, Files: entries, KeyID: keyID, Snapshot: trigger}
+	if info, err := savegame.Read(config.ExtractDir); err == nil {
+		manifest.Save = &info
+	} else if !errors.Is(err, savegame.ErrNoSave) {
+		log.Printf("Failed to read save info of map %s: %v", mapName, err)
+	}
+	if err := writeManifest(archivePath, manifest); err != nil {
+		removeArchive(archivePath)
+		return "", err
+	}
+
+	log.Printf("Took snapshot %s of map %s before %s", filepath.Base(archivePath), mapName, trigger)
+	job.Logf("Created %s", filepath.Base(archivePath))
+	return archivePath, nil
+}
+
+// SnapshotForJob takes a snapshot of a map for a restart or update job,
+// once the world was saved and before the server stops. The snapshot is
+// verified and linked to the job, so the job record names the archive to
+// roll back to. The caller holds the map's lock. Like SnapshotBefore it
+// only returns an error when the operation should be aborted.
+func (bm *BackupManager) SnapshotForJob(job *jobs.Handle, mapName string, trigger string) (string, error) {
+	archivePath, err := bm.SnapshotBefore(mapName, trigger, true)
+	if err != nil || archivePath == "" {
+		return "", err
+	}
+
+	if result := VerifyArchive(archivePath); !result.Valid {
+		err := fmt.Errorf("snapshot %s of map %s failed verification: %s", filepath.Base(archivePath), mapName, strings.Join(result.Errors, "; "))
+		bm.mu.Lock()
+		abort := bm.config.Snapshots.AbortOnFailure
+		bm.mu.Unlock()
+		if abort {
+			return "", err
+		}
+		log.Printf("%v, continuing without a snapshot", err)
+		job.Logf("Snapshot %s failed verification, continuing without one", filepath.Base(archivePath))
+		return "", nil
+	}
+	job.Logf("Took verified snapshot %s of map %s", filepath.Base(archivePath), mapName)
+	job.AddBackup(filepath.Base(archivePath))
+	return archivePath, nil
+}
@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultStagingDir = "data/restore_staging"
+
+var ErrStagingNotFound = errors.New("staged restore not found")
+
+// AlternateRestore is a restore into a directory other than the map's own
+// save folder: a staging directory or the save folder of another map
+type AlternateRestore struct {
+	Map       string   `json:"map"`
+	Archive   string   `json:"archive"`
+	TargetMap string   `json:"target_map,omitempty"`
+	Directory string   `json:"directory"`
+	Files     []string `json:"files"`
+}
+
+// StagedRestore is a directory a backup was restored into for inspection
+type StagedRestore struct {
+	Name      string    `json:"name"`
+	Directory string    `json:"directory"`
+	Created   time.Time `json:"created"`
+	Files     int       `json:"files"`
+	Size      int64     `json:"size"`
+}
+
+// stagingDir returns the directory staged restores are created in
+func (bm *BackupManager) stagingDir() (string, error) {
+	bm.mu.Lock()
+	dir := bm.config.StagingDir
+	bm.mu.Unlock()
+	if dir == "" {
+		dir = defaultStagingDir
+	}
+	return filepath.Abs(dir)
+}
+
+// archiveStem is an archive's name without its format suffix
+func archiveStem(archiveName string) string {
+	name := filepath.Base(archiveName)
+	for _, f := range archiveFormats {
+		if stem, ok := strings.CutSuffix(name, "."+f); ok {
+			return stem
+		}
+	}
+	return name
+}
+
+// RestoreToStaging restores an archive of a map into a new directory under
+// the staging directory, named after the archive and the time of the
+// restore. The map's own save folder is left alone.
+func (bm *BackupManager) RestoreToStaging(mapName string, archiveName string, fileName string) (AlternateRestore, error) {
+	result := AlternateRestore{Map: mapName, Archive: archiveName}
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return result, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	staging, err := bm.stagingDir()
+	if err != nil {
+		return result, err
+	}
+
+	result.Directory = filepath.Join(staging, archiveStem(archiveName)+"_"+time.Now().Format("20060102_150405"))
+	if _, err := os.Stat(result.Directory); err == nil {
+		return result, fmt.Errorf("staging directory %s already exists", result.Directory)
+	}
+	result.Files, err = bm.restoreInto(mapName, config, archiveName, fileName, result.Directory, mapName)
+	if err != nil {
+		os.RemoveAll(result.Directory)
+	}
+	return result, err
+}
+
+// RestoreToMap restores an archive of a map into the save folder of another
+// map, for example to run a test server from production data
+func (bm *BackupManager) RestoreToMap(mapName string, archiveName string, fileName string, targetMap string) (AlternateRestore, error) {
+	result := AlternateRestore{Map: mapName, Archive: archiveName, TargetMap: targetMap}
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return result, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	target, ok := bm.mapConfig(targetMap)
+	if !ok {
+		return result, fmt.Errorf("%w: %s", ErrMapNotConfigured, targetMap)
+	}
+
+	result.Directory = target.ExtractDir
+	locks := []string{targetMap}
+	if targetMap != mapName {
+		locks = append(locks, mapName)
+	}
+	var err error
+	result.Files, err = bm.restoreInto(mapName, config, archiveName, fileName, target.ExtractDir, locks...)
+	return result, err
+}
+
+// StagedRestores lists the staging directories, newest first
+func (bm *BackupManager) StagedRestores() ([]StagedRestore, error) {
+	staging, err := bm.stagingDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []StagedRestore{}, nil
+		}
+		return nil, err
+	}
+
+	staged := []StagedRestore{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		restore := StagedRestore{Name: entry.Name(), Directory: filepath.Join(staging, entry.Name()), Created: info.ModTime()}
+		filepath.WalkDir(restore.Directory, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				restore.Files++
+				restore.Size += info.Size()
+			}
+			return nil
+		})
+		staged = append(staged, restore)
+	}
+	sort.Slice(staged, func(i, j int) bool { return staged[i].Created.After(staged[j].Created) })
+	return staged, nil
+}
+
+// RemoveStagedRestore deletes a staging directory
+func (bm *BackupManager) RemoveStagedRestore(name string) error {
+	staging, err := bm.stagingDir()
+	if err != nil {
+		return err
+	}
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("%w: %s", ErrStagingNotFound, name)
+	}
+	dir := filepath.Join(staging, name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%w: %s", ErrStagingNotFound, name)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	log.Printf("Removed staged restore %s", name)
+	return nil
+}
+
+// RestoreToDirectory restores an archive of a map into dir, for a new
+// instance cloned from the map
+func (bm *BackupManager) RestoreToDirectory(mapName string, archiveName string, dir string) ([]string, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	return bm.restoreInto(mapName, config, archiveName, "", dir, mapName)
+}
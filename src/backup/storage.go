@@ -0,0 +1,270 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StorageConfig describes a remote target that finished backups are copied to
+type StorageConfig struct {
+	Type string `json:"type"` // "s3", "sftp" or "share"
+
+	// S3-compatible storage (AWS, MinIO, Backblaze B2)
+	Endpoint  string `json:"endpoint,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	PathStyle bool   `json:"path_style,omitempty"`
+
+	// SFTP
+	Host     string `json:"host,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// HostKey pins the server's public key, in authorized_keys format.
+	// KnownHosts is a known_hosts file to check it against instead. One of
+	// them is required.
+	HostKey    string `json:"host_key,omitempty"`
+	KnownHosts string `json:"known_hosts,omitempty"`
+
+	// Network share or any mounted directory
+	Path string `json:"path,omitempty"`
+
+	// Prefix is the remote directory / key prefix backups are stored under
+	Prefix string `json:"prefix,omitempty"`
+
+	RetentionDays       int `json:"retention_days"`
+	MaxRetries          int `json:"max_retries"`
+	RetryBackoffSeconds int `json:"retry_backoff_seconds"`
+}
+
+// ErrTooLarge is returned for archives a target cannot take, retrying
+// doesn't help
+var ErrTooLarge = errors.New("archive is too large for the remote target")
+
+// RemoteObject is a backup archive stored on a remote target
+type RemoteObject struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is implemented by every remote backup backend
+type Storage interface {
+	Upload(localPath string, name string) error
+	Delete(name string) error
+	List() ([]RemoteObject, error)
+	String() string
+}
+
+func NewStorage(config StorageConfig) (Storage, error) {
+	switch strings.ToLower(config.Type) {
+	case "s3":
+		return newS3Storage(config)
+	case "sftp":
+		return newSFTPStorage(config)
+	case "share":
+		return newShareStorage(config)
+	default:
+		return nil, fmt.Errorf("unknown storage type: %q", config.Type)
+	}
+}
+
+// withRetry calls fn until it succeeds or the configured number of retries is
+// exhausted, doubling the wait between attempts.
+func withRetry(config StorageConfig, what string, fn func() error) error {
+	backoff := time.Duration(config.RetryBackoffSeconds) * time.Second
+	if backoff <= 0 {
+		backoff = 5 * time.Second
+	}
+
+	var err error
+	for attempt := 0; attempt <= config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying %s in %v (attempt %d/%d): %v", what, backoff, attempt, config.MaxRetries, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = fn(); err == nil || errors.Is(err, ErrTooLarge) {
+			return err
+		}
+	}
+	return err
+}
+
+// replicate uploads a finished archive to every remote target of the map and
+// applies the remote retention policy of each target.
+func (bm *BackupManager) replicate(mapName string, config MapConfig, archivePath string) {
+	for _, target := range config.RemoteTargets {
+		store, err := NewStorage(target)
+		if err != nil {
+			log.Printf("Invalid remote target for map %s: %v", mapName, err)
+			continue
+		}
+
+		name := remoteName(filepath.Base(archivePath))
+		if err := bm.upload(store, target, archivePath); err != nil {
+			log.Printf("Failed to upload %s to %s: %v", name, store, err)
+			continue
+		}
+		log.Printf("Uploaded %s to %s", name, store)
+
+		if err := RemoveOldRemoteBackups(store, target, isMapArchive(mapName)); err != nil {
+			log.Printf("Failed to clean up old backups on %s: %v", store, err)
+		}
+	}
+}
+
+// upload copies an archive to a remote target. Dedup archives only
+// reference blobs in the local store, so a self-contained tar.zst is
+// exported from them for the upload.
+func (bm *BackupManager) upload(store Storage, target StorageConfig, archivePath string) error {
+	uploadPath := archivePath
+	if strings.HasSuffix(archivePath, "."+FormatDedup) {
+		exported, err := exportDedup(archivePath)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(exported)
+		uploadPath = exported
+	}
+
+	return UploadFile(store, target, uploadPath, remoteName(filepath.Base(archivePath)))
+}
+
+// UploadFile copies a file to a remote target under name, retrying as the
+// target is configured to
+func UploadFile(store Storage, target StorageConfig, localPath string, name string) error {
+	return withRetry(target, "upload to "+store.String(), func() error {
+		return store.Upload(localPath, name)
+	})
+}
+
+// isMapArchive returns whether an archive name is one of the map's, named
+// <map>_<timestamp>_<type> by reserveArchive, or <map>_<timestamp> by
+// older versions. The timestamp tells the archives of "island" from those
+// of "island_2".
+func isMapArchive(mapName string) func(name string) bool {
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(mapName) + `_\d{8}_\d{6}[_.]`)
+	return pattern.MatchString
+}
+
+// remoteName is the name an archive is stored under on remote targets
+func remoteName(name string) string {
+	if base, ok := strings.CutSuffix(name, "."+FormatDedup); ok {
+		return base + "." + FormatTarZst
+	}
+	return name
+}
+
+// RemoveOldRemoteBackups deletes the remote archives that are older than
+// the target's retention period and for which owns returns true. Targets
+// are shared, e.g. by the maps of one prefix, so other archives are left
+// alone. A retention of 0 keeps remote archives forever.
+func RemoveOldRemoteBackups(store Storage, config StorageConfig, owns func(name string) bool) error {
+	if config.RetentionDays <= 0 {
+		return nil
+	}
+
+	objects, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list remote backups: %w", err)
+	}
+
+	cutoff := time.Now().Add(-time.Duration(config.RetentionDays) * 24 * time.Hour)
+	for _, obj := range objects {
+		if owns(obj.Name) && obj.ModTime.Before(cutoff) {
+			err := withRetry(config, "delete of "+obj.Name, func() error {
+				return store.Delete(obj.Name)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to remove remote backup %s: %w", obj.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+type shareStorage struct {
+	dir string
+}
+
+func newShareStorage(config StorageConfig) (*shareStorage, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("share storage requires a path")
+	}
+	return &shareStorage{dir: filepath.Join(config.Path, config.Prefix)}, nil
+}
+
+func (s *shareStorage) String() string {
+	return "share:" + s.dir
+}
+
+func (s *shareStorage) Upload(localPath string, name string) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create share directory: %w", err)
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer src.Close()
+
+	// Copy to a temporary name first so a half-written file never looks like
+	// a complete backup on the share.
+	dstPath := filepath.Join(s.dir, name)
+	tmpPath := dstPath + ".part"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file: %w", err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy archive: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close remote file: %w", err)
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+func (s *shareStorage) Delete(name string) error {
+	return os.Remove(filepath.Join(s.dir, filepath.Base(name)))
+}
+
+func (s *shareStorage) List() ([]RemoteObject, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var objects []RemoteObject
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, RemoteObject{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].ModTime.Before(objects[j].ModTime) })
+	return objects, nil
+}
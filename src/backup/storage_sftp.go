@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type sftpStorage struct {
+	host   string
+	dir    string
+	config *ssh.ClientConfig
+}
+
+func newSFTPStorage(config StorageConfig) (*sftpStorage, error) {
+	if config.Host == "" || config.User == "" {
+		return nil, fmt.Errorf("sftp storage requires host and user")
+	}
+
+	var auth []ssh.AuthMethod
+	if config.KeyFile != "" {
+		key, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sftp key file: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sftp key file: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp storage requires a password or key_file")
+	}
+
+	hostKeyCallback, err := sftpHostKeyCallback(config)
+	if err != nil {
+		return nil, err
+	}
+
+	host := config.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+
+	return &sftpStorage{
+		host: host,
+		dir:  path.Clean("/" + strings.Trim(config.Prefix, "/")),
+		config: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// sftpHostKeyCallback checks the server's key against host_key or the
+// known_hosts file. Unchecked servers are refused, a spoofed one would get
+// the password and every archive.
+func sftpHostKeyCallback(config StorageConfig) (ssh.HostKeyCallback, error) {
+	switch {
+	case config.HostKey != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(config.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sftp host_key: %w", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	case config.KnownHosts != "":
+		callback, err := knownhosts.New(config.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sftp known_hosts: %w", err)
+		}
+		return callback, nil
+	default:
+		return nil, fmt.Errorf("sftp storage requires host_key or known_hosts")
+	}
+}
+
+func (s *sftpStorage) String() string {
+	return "sftp://" + s.host + s.dir
+}
+
+func (s *sftpStorage) connect() (*ssh.Client, *sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", s.host, s.config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to %s: %w", s.host, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+	return conn, client, nil
+}
+
+func (s *sftpStorage) Upload(localPath string, name string) error {
+	conn, client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer client.Close()
+
+	if err := client.MkdirAll(s.dir); err != nil {
+		return fmt.Errorf("failed to create remote directory: %w", err)
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer src.Close()
+
+	dstPath := path.Join(s.dir, name)
+	tmpPath := dstPath + ".part"
+	dst, err := client.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		client.Remove(tmpPath)
+		return fmt.Errorf("failed to upload archive: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		client.Remove(tmpPath)
+		return fmt.Errorf("failed to close remote file: %w", err)
+	}
+
+	client.Remove(dstPath)
+	return client.Rename(tmpPath, dstPath)
+}
+
+func (s *sftpStorage) Delete(name string) error {
+	conn, client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer client.Close()
+
+	return client.Remove(path.Join(s.dir, path.Base(name)))
+}
+
+func (s *sftpStorage) List() ([]RemoteObject, error) {
+	conn, client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer client.Close()
+
+	entries, err := client.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var objects []RemoteObject
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		objects = append(objects, RemoteObject{Name: entry.Name(), Size: entry.Size(), ModTime: entry.ModTime()})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].ModTime.Before(objects[j].ModTime) })
+	return objects, nil
+}
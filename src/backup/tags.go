@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"asa_servermanager_api/maplock"
+)
+
+const (
+	maxTags       = 20
+	maxTagLength  = 64
+	maxNoteLength = 2000
+)
+
+var (
+	ErrArchiveNotFound = errors.New("backup not found")
+	ErrInvalidTag      = errors.New("invalid backup tag")
+)
+
+// normalizeTags trims the tags, drops duplicates and checks their length.
+// Tags are compared without regard to case.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("%w: at most %d tags per backup", ErrInvalidTag, maxTags)
+	}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "":
+			return nil, fmt.Errorf("%w: tags must not be empty", ErrInvalidTag)
+		case len(tag) > maxTagLength:
+			return nil, fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidTag, tag, maxTagLength)
+		case strings.IndexFunc(tag, unicode.IsControl) >= 0:
+			return nil, fmt.Errorf("%w: %q contains control characters", ErrInvalidTag, tag)
+		}
+		if !slices.ContainsFunc(normalized, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// HasTag reports whether the archive carries a tag, regardless of case
+func (a Archive) HasTag(tag string) bool {
+	return slices.ContainsFunc(a.Tags, func(t string) bool { return strings.EqualFold(t, tag) })
+}
+
+// TagBackup replaces the tags and note of an archive. They are stored in
+// its manifest, and tagged archives are kept by retention until their tags
+// are removed.
+func (bm *BackupManager) TagBackup(mapName string, archiveName string, tags []string, note string) (Archive, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return Archive{}, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return Archive{}, err
+	}
+	note = strings.TrimSpace(note)
+	if len(note) > maxNoteLength {
+		return Archive{}, fmt.Errorf("%w: the note is longer than %d characters", ErrInvalidTag, maxNoteLength)
+	}
+
+	// Re-encryption rewrites manifests as well
+	release, err := maplock.TryAcquire(mapName, maplock.OpBackup)
+	if err != nil {
+		return Archive{}, err
+	}
+	defer release()
+
+	archivePath := filepath.Join(config.ZipDir, filepath.Base(archiveName))
+	if _, err := os.Stat(archivePath); err != nil {
+		return Archive{}, fmt.Errorf("%w: %s", ErrArchiveNotFound, archiveName)
+	}
+	manifest, err := ReadManifest(archivePath)
+	if err != nil {
+		return Archive{}, fmt.Errorf("backup %s has no readable manifest to store tags in: %w", archiveName, err)
+	}
+	manifest.Tags = tags
+	manifest.Note = note
+	if err := saveManifest(archivePath, manifest); err != nil {
+		return Archive{}, err
+	}
+	log.Printf("Tagged backup %s of map %s with %v", manifest.Archive, mapName, tags)
+
+	archives, err := ListArchives(mapName, config)
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, archive := range archives {
+		if archive.Name == filepath.Base(archivePath) {
+			return archive, nil
+		}
+	}
+	return Archive{}, fmt.Errorf("%w: %s", ErrArchiveNotFound, archiveName)
+}
@@ -0,0 +1,284 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/savegame"
+)
+
+// ManifestEntry describes a single file stored in a backup archive
+type ManifestEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// Manifest is written next to every archive as <archive>.manifest.json
+type Manifest struct {
+	Map     string          `json:"map"`
+	Archive string          `json:"archive"`
+	Type    string          `json:"type"`
+	Base    string          `json:"base,omitempty"`
+	Parent  string          `json:"parent,omitempty"`
+	Created time.Time       `json:"created"`
+	Size    int64           `json:"size"`
+	SHA256  string          `json:"sha256"`
+	Files   []ManifestEntry `json:"files"`
+	Deleted []string        `json:"deleted,omitempty"`
+
+	// Save describes the world at the time of the backup
+	Save *savegame.Info `json:"save,omitempty"`
+	// Environment is the server the backup was taken on
+	Environment *Environment `json:"environment,omitempty"`
+
+	// KeyID names the key the archive is encrypted with, empty if it is not
+	// encrypted
+	KeyID string `json:"key_id,omitempty"`
+
+	// Snapshot names the operation a snapshot was taken before, empty for
+	// scheduled and manual backups
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Tags and Note are attached through the API, tagged archives are kept
+	// by retention
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+}
+
+// Verification outcomes of an archive
+const (
+	VerifyStatusValid = "valid"
+	// VerifyStatusLegacy is an archive from before manifests were written.
+	// Only the checksums the archive format carries were checked.
+	VerifyStatusLegacy  = "unverified (legacy)"
+	VerifyStatusNoKey   = "unverified (unknown key)"
+	VerifyStatusCorrupt = "corrupt"
+)
+
+// VerifyResult is the outcome of re-validating an archive. Valid archives
+// can be restored, legacy ones included.
+type VerifyResult struct {
+	Archive string   `json:"archive"`
+	Valid   bool     `json:"valid"`
+	Status  string   `json:"status"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func manifestPath(archivePath string) string { return archivePath + ".manifest.json" }
+func checksumPath(archivePath string) string { return archivePath + ".sha256" }
+func corruptPath(archivePath string) string  { return archivePath + ".corrupt" }
+
+// archiveSidecars lists every file that belongs to an archive besides the
+// archive itself, so retention can remove them together.
+func archiveSidecars(archivePath string) []string {
+	return []string{manifestPath(archivePath), checksumPath(archivePath), corruptPath(archivePath)}
+}
+
+func removeArchive(archivePath string) error {
+	if err := os.Remove(archivePath); err != nil {
+		return err
+	}
+	for _, sidecar := range archiveSidecars(archivePath) {
+		if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %v", sidecar, err)
+		}
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// writeChecksum hashes an archive and stores the checksum file next to it
+func writeChecksum(archivePath string) (string, int64, error) {
+	sum, size, err := fileSHA256(archivePath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to hash archive: %w", err)
+	}
+
+	checksum := fmt.Sprintf("%s  %s\n", sum, filepath.Base(archivePath))
+	if err := os.WriteFile(checksumPath(archivePath), []byte(checksum), 0644); err != nil {
+		return "", 0, fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return sum, size, nil
+}
+
+// writeManifest hashes a finished archive, fills in the archive fields of the
+// manifest and stores both the checksum file and the manifest.
+func writeManifest(archivePath string, manifest *Manifest) error {
+	sum, size, err := writeChecksum(archivePath)
+	if err != nil {
+		return err
+	}
+
+	manifest.Archive = filepath.Base(archivePath)
+	manifest.Created = time.Now()
+	manifest.Size = size
+	manifest.SHA256 = sum
+	return saveManifest(archivePath, manifest)
+}
+
+func saveManifest(archivePath string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(manifestPath(archivePath), data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadManifest loads the manifest stored next to an archive
+func ReadManifest(archivePath string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(archivePath))
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// IsCorrupt reports whether an archive has been flagged by a failed verification
+func IsCorrupt(archivePath string) bool {
+	_, err := os.Stat(corruptPath(archivePath))
+	return err == nil
+}
+
+// VerifyArchive re-hashes an archive and every file inside it, compares them
+// against the manifest and flags the archive as corrupt on any mismatch.
+// Archives without a manifest predate them, they are only read through to
+// check the archive's own checksums.
+func VerifyArchive(archivePath string) VerifyResult {
+	result := VerifyResult{Archive: filepath.Base(archivePath)}
+	fail := func(format string, args ...interface{}) {
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+	}
+
+	manifest, err := ReadManifest(archivePath)
+	legacy := errors.Is(err, os.ErrNotExist)
+	if err != nil && !legacy {
+		fail("manifest unavailable: %v", err)
+	}
+
+	sum, size, err := fileSHA256(archivePath)
+	if err != nil {
+		fail("failed to hash archive: %v", err)
+	} else if manifest != nil && (manifest.SHA256 != sum || manifest.Size != size) {
+		fail("archive checksum mismatch: expected %s, got %s", manifest.SHA256, sum)
+	}
+
+	expected := make(map[string]ManifestEntry)
+	if manifest != nil {
+		for _, entry := range manifest.Files {
+			expected[entry.Name] = entry
+		}
+	}
+
+	err = walkArchive(archivePath, func(name string, modTime time.Time, r io.Reader) error {
+		// Readers validate their own checksums (zip CRC32) once fully read
+		h := sha256.New()
+		entrySize, err := io.Copy(h, r)
+		if err != nil {
+			fail("%s: %v", name, err)
+			return nil
+		}
+		if manifest == nil {
+			return nil
+		}
+		want, ok := expected[name]
+		if !ok {
+			fail("%s: not listed in manifest", name)
+			return nil
+		}
+		delete(expected, name)
+		if want.SHA256 != hex.EncodeToString(h.Sum(nil)) || want.Size != entrySize {
+			fail("%s: checksum mismatch", name)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrUnknownKey) {
+		// Without the key the archive can't be checked, that doesn't make
+		// it corrupt
+		fail("failed to read archive: %v", err)
+		result.Status = VerifyStatusNoKey
+		return result
+	}
+	if err != nil {
+		fail("failed to read archive: %v", err)
+	} else {
+		for name := range expected {
+			fail("%s: missing from archive", name)
+		}
+	}
+
+	result.Valid = len(result.Errors) == 0
+	if result.Valid {
+		result.Status = VerifyStatusValid
+		if legacy {
+			result.Status = VerifyStatusLegacy
+		}
+		os.Remove(corruptPath(archivePath))
+	} else {
+		result.Status = VerifyStatusCorrupt
+		reason := strings.Join(result.Errors, "\n")
+		if err := os.WriteFile(corruptPath(archivePath), []byte(reason), 0644); err != nil {
+			log.Printf("Failed to flag %s as corrupt: %v", archivePath, err)
+		}
+		log.Printf("Backup %s failed verification: %s", archivePath, reason)
+	}
+	return result
+}
+
+// VerifyBackups verifies a single archive of a map, or all of them when
+// archiveName is empty.
+func (bm *BackupManager) VerifyBackups(mapName string, archiveName string) ([]VerifyResult, error) {
+	config, ok := bm.mapConfig(mapName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMapNotConfigured, mapName)
+	}
+
+	if archiveName != "" {
+		archivePath := filepath.Join(config.ZipDir, filepath.Base(archiveName))
+		if _, err := os.Stat(archivePath); err != nil {
+			return nil, fmt.Errorf("backup %s not found: %w", archiveName, err)
+		}
+		return []VerifyResult{VerifyArchive(archivePath)}, nil
+	}
+
+	archives, err := findArchives(config.ZipDir, mapName)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]VerifyResult, 0, len(archives))
+	for _, archivePath := range archives {
+		results = append(results, VerifyArchive(archivePath))
+	}
+	return results, nil
+}
@@ -0,0 +1,223 @@
+// Package broadcast renders the in-game messages the manager sends on its
+// own, such as restart, update and wipe warnings, from templates. Templates
+// are set per event and may be overridden per map. Variables are written
+// in braces, e.g. "Restarting {map} in {minutes_remaining} minute(s)".
+package broadcast
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/rcon"
+)
+
+// Events that broadcast a message
+const (
+	EventRestart = "restart"
+	EventUpdate  = "update"
+	EventWipe    = "wipe"
+	EventBackup  = "backup"
+	EventDrain   = "drain"
+)
+
+// Variables every template may use, others are set by the event
+const (
+	VarMap              = "map"
+	VarMinutesRemaining = "minutes_remaining"
+	VarSecondsRemaining = "seconds_remaining"
+	VarReason           = "reason"
+)
+
+// maxLength keeps a rendered message within one RCON packet
+const maxLength = 1000
+
+var (
+	ErrUnknownEvent    = errors.New("unknown broadcast event")
+	ErrInvalidTemplate = errors.New("invalid broadcast template")
+
+	varPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+	// defaults are used for events without a configured template. An empty
+	// template sends nothing.
+	defaults = map[string]string{
+		EventRestart: "Server restarting in {seconds_remaining} seconds",
+		EventUpdate:  "Server update in {minutes_remaining} minute(s), the server will restart",
+		EventWipe:    "Wild dinos will be wiped in {minutes_remaining} minute(s)",
+		EventBackup:  "",
+		EventDrain:   "{reason} (shutdown in {minutes_remaining} minute(s) at the latest)",
+	}
+)
+
+// Config holds the templates by event, Maps overrides them for one map
+type Config struct {
+	Templates map[string]string            `json:"templates,omitempty"`
+	Maps      map[string]map[string]string `json:"maps,omitempty"`
+}
+
+// Vars are the values of a template's variables
+type Vars map[string]string
+
+// Minutes returns vars with minutes_remaining and seconds_remaining set
+func Minutes(minutes int) Vars {
+	return Vars{VarMinutesRemaining: strconv.Itoa(minutes), VarSecondsRemaining: strconv.Itoa(minutes * 60)}
+}
+
+// Seconds returns vars with seconds_remaining and minutes_remaining set,
+// minutes rounded up
+func Seconds(seconds int) Vars {
+	return Vars{VarSecondsRemaining: strconv.Itoa(seconds), VarMinutesRemaining: strconv.Itoa((seconds + 59) / 60)}
+}
+
+// With returns a copy of vars with key set to value
+func (v Vars) With(key string, value string) Vars {
+	c := make(Vars, len(v)+1)
+	for k, val := range v {
+		c[k] = val
+	}
+	c[key] = value
+	return c
+}
+
+var (
+	config   Config
+	configMu sync.RWMutex
+)
+
+// Events lists the events that broadcast a message
+func Events() []string {
+	events := make([]string, 0, len(defaults))
+	for event := range defaults {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// Load reads the templates, a missing file keeps the defaults
+func Load(configFile string) error {
+	var loaded Config
+	if err := configfile.Read(configFile, &loaded); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read broadcast config: %w", err)
+	}
+	for event, tmpl := range loaded.Templates {
+		if err := Validate(event, tmpl); err != nil {
+			return err
+		}
+	}
+	for mapName, templates := range loaded.Maps {
+		for event, tmpl := range templates {
+			if err := Validate(event, tmpl); err != nil {
+				return fmt.Errorf("map %s: %w", mapName, err)
+			}
+		}
+	}
+
+	configMu.Lock()
+	config = loaded
+	configMu.Unlock()
+	return nil
+}
+
+// Validate checks a template of an event
+func Validate(event string, tmpl string) error {
+	if _, ok := defaults[event]; !ok {
+		return fmt.Errorf("%w: %s, expected one of %s", ErrUnknownEvent, event, strings.Join(Events(), ", "))
+	}
+	if strings.ContainsAny(tmpl, "\r\n") {
+		return fmt.Errorf("%w: template of %s must be a single line", ErrInvalidTemplate, event)
+	}
+	if len(tmpl) > maxLength {
+		return fmt.Errorf("%w: template of %s must not be longer than %d characters", ErrInvalidTemplate, event, maxLength)
+	}
+	return nil
+}
+
+// Template returns the template of an event on a map: the map's override,
+// the configured template or the default, in that order
+func Template(event string, mapName string) string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	if tmpl, ok := config.Maps[mapName][event]; ok {
+		return tmpl
+	}
+	if tmpl, ok := config.Templates[event]; ok {
+		return tmpl
+	}
+	return defaults[event]
+}
+
+// Templates returns the template of every event on a map, the configured
+// templates if mapName is empty
+func Templates(mapName string) map[string]string {
+	templates := make(map[string]string, len(defaults))
+	for event := range defaults {
+		templates[event] = Template(event, mapName)
+	}
+	return templates
+}
+
+// RenderTemplate fills in the variables of a template. Variables without a
+// value are left as they are and returned as unknown.
+func RenderTemplate(tmpl string, mapName string, vars Vars) (string, []string) {
+	var unknown []string
+	message := varPattern.ReplaceAllStringFunc(tmpl, func(match string) string {
+		name := match[1 : len(match)-1]
+		if name == VarMap && vars[VarMap] == "" {
+			return mapName
+		}
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		unknown = append(unknown, name)
+		return match
+	})
+	return message, unknown
+}
+
+// Render returns the message of an event on a map, "" if the event's
+// template is empty
+func Render(event string, mapName string, vars Vars) string {
+	message, _ := RenderTemplate(Template(event, mapName), mapName, vars)
+	return strings.TrimSpace(message)
+}
+
+// Send broadcasts the message of an event on a map. Events with an empty
+// template send nothing.
+func Send(event string, mapName string, vars Vars) error {
+	return send(rcon.Execute, event, mapName, vars)
+}
+
+// SendAs is Send on behalf of caller, like the operation it announces
+func SendAs(caller rcon.Caller, event string, mapName string, vars Vars) error {
+	return send(func(m string, c string) (string, error) { return rcon.ExecuteAs(caller, m, c) }, event, mapName, vars)
+}
+
+// Command returns the RCON command Send would run, "" if it sends nothing
+func Command(event string, mapName string, vars Vars) string {
+	// Variables such as the reason may come from API callers
+	message := rcon.SanitizeText(Render(event, mapName, vars))
+	if message == "" {
+		return ""
+	}
+	return "broadcast " + message
+}
+
+func send(execute func(m string, c string) (string, error), event string, mapName string, vars Vars) error {
+	command := Command(event, mapName, vars)
+	if command == "" {
+		return nil
+	}
+	_, err := execute(mapName, command)
+	return err
+}
@@ -0,0 +1,292 @@
+// Package bundle exports the manager's configuration to a single JSON
+// document and imports it again, to move the manager to a new host or to
+// recover it. A bundle holds the files of the config directory and the
+// state worth keeping: users, the backup schedules, which servers are
+// enabled, maintenance modes and the drift baselines of INI files. Job
+// histories, sessions and other state tied to this host are left out.
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/state"
+	"asa_servermanager_api/users"
+)
+
+// Version is the format of the bundles this manager writes and reads
+const Version = 1
+
+const (
+	configDir = "config"
+	// backupDir keeps the configuration replaced by an import
+	backupDir = "./data/config_backups"
+
+	// bucketUsers is handled by the users package
+	bucketUsers = "users"
+)
+
+var (
+	ErrInvalid = errors.New("invalid config bundle")
+
+	// buckets are the buckets of the state store a bundle carries, named
+	// like the constants of their packages
+	buckets = []string{bucketUsers, "auth_settings", state.BucketBackupSchedules, state.BucketProcesses, "maintenance", "ini_baselines"}
+
+	fileExtensions = []string{".json", ".yaml", ".yml", ".toml", ".enc"}
+)
+
+// Bundle is the exported configuration of a manager
+type Bundle struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Host    string    `json:"host,omitempty"`
+	// Files are the config files by name, secrets.enc included
+	Files map[string][]byte `json:"files"`
+	// State are the exported records of the state store, by bucket and key
+	State map[string]map[string]json.RawMessage `json:"state"`
+}
+
+// Result is the outcome of an import
+type Result struct {
+	Files []string `json:"files"`
+	// Records counts the imported records by bucket
+	Records map[string]int `json:"records"`
+	// Backup is the bundle of the configuration the import replaced
+	Backup string `json:"backup,omitempty"`
+}
+
+// Export collects the configuration of the manager
+func Export() (Bundle, error) {
+	b := Bundle{Version: Version, Created: time.Now(), Files: map[string][]byte{}, State: map[string]map[string]json.RawMessage{}}
+	b.Host, _ = os.Hostname()
+
+	entries, err := os.ReadDir(configDir)
+	if err != nil && !os.IsNotExist(err) {
+		return Bundle{}, fmt.Errorf("failed to list config files: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || validFileName(entry.Name()) != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(configDir, entry.Name()))
+		if err != nil {
+			return Bundle{}, fmt.Errorf("failed to read config file %s: %w", entry.Name(), err)
+		}
+		b.Files[entry.Name()] = data
+	}
+
+	for _, bucket := range buckets {
+		records, err := exportBucket(bucket)
+		if err != nil {
+			return Bundle{}, fmt.Errorf("failed to export %s: %w", bucket, err)
+		}
+		b.State[bucket] = records
+	}
+	return b, nil
+}
+
+func exportBucket(bucket string) (map[string]json.RawMessage, error) {
+	if bucket == bucketUsers {
+		return users.Export()
+	}
+	records := map[string]json.RawMessage{}
+	err := state.ForEach(bucket, func(key string, value []byte) error {
+		records[key] = append(json.RawMessage(nil), value...)
+		return nil
+	})
+	if err != nil || bucket != state.BucketProcesses {
+		return records, err
+	}
+
+	// PIDs of this host mean nothing on another
+	for key, value := range records {
+		var ps state.ProcessState
+		if err := json.Unmarshal(value, &ps); err != nil {
+			return nil, fmt.Errorf("failed to decode process %s: %w", key, err)
+		}
+		ps.PID = 0
+		if records[key], err = json.Marshal(ps); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// validFileName checks that a config file name stays in the config
+// directory and has a config format
+func validFileName(name string) error {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: config file name %q", ErrInvalid, name)
+	}
+	for _, ext := range fileExtensions {
+		if strings.EqualFold(filepath.Ext(name), ext) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: config file %s is not %s", ErrInvalid, name, strings.Join(fileExtensions, ", "))
+}
+
+// Validate checks a bundle before it is imported
+func (b Bundle) Validate() error {
+	if b.Version != Version {
+		return fmt.Errorf("%w: version %d, this manager reads version %d", ErrInvalid, b.Version, Version)
+	}
+	if len(b.Files) == 0 {
+		return fmt.Errorf("%w: no config files", ErrInvalid)
+	}
+	for name, data := range b.Files {
+		if err := validFileName(name); err != nil {
+			return err
+		}
+		if strings.EqualFold(filepath.Ext(name), ".json") && !json.Valid(data) {
+			return fmt.Errorf("%w: config file %s is not valid JSON", ErrInvalid, name)
+		}
+	}
+	for bucket, records := range b.State {
+		if !carried(bucket) {
+			return fmt.Errorf("%w: unknown state %s", ErrInvalid, bucket)
+		}
+		for key, value := range records {
+			if key == "" || !json.Valid(value) {
+				return fmt.Errorf("%w: record %q of %s is not valid JSON", ErrInvalid, key, bucket)
+			}
+		}
+	}
+	if err := users.ValidateImport(b.State[bucketUsers]); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return nil
+}
+
+func carried(bucket string) bool {
+	for _, b := range buckets {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan returns what importing the bundle would do
+func (b Bundle) Plan() dryrun.Plan {
+	plan := dryrun.Plan{Operation: "config import"}
+	plan.Stepf("save the current configuration to %s", backupDir)
+	for _, name := range sortedKeys(b.Files) {
+		plan.Stepf("write %s", filepath.ToSlash(filepath.Join(configDir, name)))
+	}
+	for _, bucket := range sortedKeys(b.State) {
+		plan.Stepf("replace %s with %d record(s)", bucket, len(b.State[bucket]))
+	}
+	if len(b.State[bucketUsers]) > 0 {
+		plan.Stepf("end every session")
+	}
+	return plan
+}
+
+// Import validates a bundle and applies it. The current configuration is
+// saved to a bundle first. Config files missing from the bundle are kept,
+// the state it carries is replaced. The manager applies the config files
+// when it is restarted.
+func Import(b Bundle) (Result, error) {
+	if err := b.Validate(); err != nil {
+		return Result{}, err
+	}
+
+	current, err := Export()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to save the current configuration: %w", err)
+	}
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return Result{}, fmt.Errorf("failed to save the current configuration: %w", err)
+	}
+	result := Result{Records: map[string]int{}}
+	result.Backup = filepath.Join(backupDir, "config_"+current.Created.Format("2006-01-02_15-04-05")+".json")
+	if err := configfile.WriteJSON(result.Backup, current); err != nil {
+		return Result{}, fmt.Errorf("failed to save the current configuration: %w", err)
+	}
+
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return Result{}, err
+	}
+	for _, name := range sortedKeys(b.Files) {
+		if err := configfile.WriteFile(filepath.Join(configDir, name), b.Files[name]); err != nil {
+			return result, err
+		}
+		result.Files = append(result.Files, name)
+	}
+	for _, bucket := range sortedKeys(b.State) {
+		if err := importBucket(bucket, b.State[bucket]); err != nil {
+			return result, fmt.Errorf("failed to import %s: %w", bucket, err)
+		}
+		result.Records[bucket] = len(b.State[bucket])
+	}
+	log.Printf("Imported a config bundle from %s of %s, the replaced configuration is saved to %s", b.Host, b.Created.Format(time.RFC3339), result.Backup)
+	return result, nil
+}
+
+func importBucket(bucket string, records map[string]json.RawMessage) error {
+	if bucket == bucketUsers {
+		return users.Import(records)
+	}
+	var stale []string
+	err := state.ForEach(bucket, func(key string, _ []byte) error {
+		if _, kept := records[key]; !kept {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range stale {
+		if err := state.Delete(bucket, key); err != nil {
+			return err
+		}
+	}
+	for key, value := range records {
+		if bucket == state.BucketProcesses {
+			var err error
+			if value, err = keepPID(key, value); err != nil {
+				return err
+			}
+		}
+		if err := state.Put(bucket, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepPID keeps the PID of a server running on this host, so it is still
+// monitored
+func keepPID(mapName string, value json.RawMessage) (json.RawMessage, error) {
+	current, err := state.Process(mapName)
+	if err != nil || current.PID == 0 {
+		return value, err
+	}
+	var ps state.ProcessState
+	if err := json.Unmarshal(value, &ps); err != nil {
+		return nil, fmt.Errorf("failed to decode process %s: %w", mapName, err)
+	}
+	ps.PID = current.PID
+	return json.Marshal(ps)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,309 @@
+// Package cheats lets moderators run a curated set of admin cheats on the
+// players of a map, such as teleports and items, without RCON access of
+// their own. Each cheat is sent after EnableCheats with the map's admin
+// password and written to the RCON audit log with the moderator and the
+// player. Moderators are limited to a number of cheats per minute.
+package cheats
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/rcon"
+
+	"golang.org/x/time/rate"
+)
+
+// Param kinds
+const (
+	// KindInt is a whole number, e.g. a quantity or a player ID
+	KindInt = "int"
+	// KindNumber is a decimal number, e.g. an item quality
+	KindNumber = "number"
+	// KindID is letters, digits, - and _
+	KindID = "id"
+	// KindWord is anything without whitespace or quotes, e.g. a blueprint
+	// path
+	KindWord = "word"
+)
+
+const defaultPerMinute = 10
+
+var (
+	ErrUnknownCheat   = errors.New("unknown cheat")
+	ErrInvalidRequest = errors.New("invalid cheat request")
+	ErrRateLimited    = errors.New("too many cheats")
+)
+
+var (
+	namePattern        = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+	placeholderPattern = regexp.MustCompile(`\{([a-z0-9_]+)\}`)
+	idPattern          = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// Param is a value a cheat takes besides the player. A param with a
+// Default may be left out.
+type Param struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Cheat is an admin command moderators may run. {player} in Command is the
+// player's ID, {<param>} the value of a param.
+type Cheat struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Command     string  `json:"command"`
+	Params      []Param `json:"params,omitempty"`
+}
+
+type CheatsConfig struct {
+	Cheats []Cheat `json:"cheats"`
+	// PerMinute is how many cheats a moderator may run per minute, across
+	// maps, default 10
+	PerMinute int `json:"per_minute,omitempty"`
+}
+
+// defaultCheats are the cheats of ASA that act on another player and work
+// over RCON. God and Ghost only act on the admin who types them in game,
+// they need the command of a server plugin.
+var defaultCheats = []Cheat{
+	{
+		Name:        "teleport_to_player",
+		Description: "Teleport the player to another player",
+		Command:     "TeleportPlayerIDToPlayerID {player} {target}",
+		Params:      []Param{{Name: "target", Kind: KindInt, Description: "Player ID of the player to teleport to"}},
+	},
+	{
+		Name:        "give_item",
+		Description: "Give the player an item",
+		Command:     "GiveItemToPlayer {player} {blueprint} {quantity} {quality} 0",
+		Params: []Param{
+			{Name: "blueprint", Kind: KindWord, Description: "Blueprint path of the item"},
+			{Name: "quantity", Kind: KindInt, Default: "1"},
+			{Name: "quality", Kind: KindNumber, Default: "0"},
+		},
+	},
+	{
+		Name:        "give_exp",
+		Description: "Give the player experience",
+		Command:     "GiveExpToPlayer {player} {amount} 0 1",
+		Params:      []Param{{Name: "amount", Kind: KindInt}},
+	},
+	{
+		Name:        "give_creative_mode",
+		Description: "Toggle creative mode of the player",
+		Command:     "GiveCreativeModeToPlayer {player}",
+	},
+}
+
+// Request asks for a cheat on a player. Player is the ID the cheat's
+// command takes, usually the in-game player ID.
+type Request struct {
+	Cheat  string            `json:"cheat"`
+	Player string            `json:"player"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Result is a cheat that was sent and the server's response
+type Result struct {
+	Cheat    string `json:"cheat"`
+	Map      string `json:"map"`
+	Player   string `json:"player"`
+	Command  string `json:"command"`
+	Response string `json:"response"`
+}
+
+type Proxy struct {
+	cheats    map[string]Cheat
+	perMinute int
+
+	limiters map[string]*rate.Limiter
+	mu       sync.Mutex
+}
+
+func (p Param) check(value string) error {
+	switch p.Kind {
+	case KindInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%s must be a whole number, got %q", p.Name, value)
+		}
+	case KindNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s must be a number, got %q", p.Name, value)
+		}
+	case KindID:
+		if !idPattern.MatchString(value) {
+			return fmt.Errorf("%s must be letters, digits, - and _, got %q", p.Name, value)
+		}
+	case KindWord:
+		if value == "" || strings.ContainsAny(value, "\"|") || strings.IndexFunc(value, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+			return fmt.Errorf("%s must not be empty or contain whitespace, quotes or |, got %q", p.Name, value)
+		}
+	}
+	return nil
+}
+
+func (c Cheat) validate() error {
+	if !namePattern.MatchString(c.Name) {
+		return fmt.Errorf("cheat name %q must be 1-64 lowercase letters, digits or '_'", c.Name)
+	}
+	if !strings.Contains(c.Command, "{player}") {
+		return fmt.Errorf("cheat %s: command must target {player}", c.Name)
+	}
+	declared := map[string]bool{"player": true}
+	for _, p := range c.Params {
+		switch {
+		case p.Name == "player" || !namePattern.MatchString(p.Name):
+			return fmt.Errorf("cheat %s: invalid param name %q", c.Name, p.Name)
+		case declared[p.Name]:
+			return fmt.Errorf("cheat %s: param %s is declared twice", c.Name, p.Name)
+		case p.Kind != KindInt && p.Kind != KindNumber && p.Kind != KindID && p.Kind != KindWord:
+			return fmt.Errorf("cheat %s: param %s has unknown kind %q", c.Name, p.Name, p.Kind)
+		}
+		if p.Default != "" {
+			if err := p.check(p.Default); err != nil {
+				return fmt.Errorf("cheat %s: default of %w", c.Name, err)
+			}
+		}
+		declared[p.Name] = true
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(c.Command, -1) {
+		if !declared[match[1]] {
+			return fmt.Errorf("cheat %s: command uses undeclared param {%s}", c.Name, match[1])
+		}
+	}
+	if _, err := rcon.ParseCommand(c.Command); err != nil {
+		return fmt.Errorf("cheat %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+// NewProxy reads the cheats config, a missing file or one without cheats
+// yields the default cheats
+func NewProxy(configFile string) (*Proxy, error) {
+	var config CheatsConfig
+	if err := configfile.Read(configFile, &config); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read cheats config: %w", err)
+	}
+	if len(config.Cheats) == 0 {
+		config.Cheats = defaultCheats
+	}
+	if config.PerMinute < 0 {
+		return nil, fmt.Errorf("cheats config: per_minute must not be negative")
+	}
+	if config.PerMinute == 0 {
+		config.PerMinute = defaultPerMinute
+	}
+
+	p := &Proxy{cheats: make(map[string]Cheat), perMinute: config.PerMinute, limiters: make(map[string]*rate.Limiter)}
+	for _, cheat := range config.Cheats {
+		if err := cheat.validate(); err != nil {
+			return nil, fmt.Errorf("cheats config: %w", err)
+		}
+		if _, exists := p.cheats[cheat.Name]; exists {
+			return nil, fmt.Errorf("cheats config: cheat %s is listed twice", cheat.Name)
+		}
+		p.cheats[cheat.Name] = cheat
+	}
+	return p, nil
+}
+
+// Cheats returns the cheats moderators may run, sorted by name
+func (p *Proxy) Cheats() []Cheat {
+	cheats := make([]Cheat, 0, len(p.cheats))
+	for _, cheat := range p.cheats {
+		cheats = append(cheats, cheat)
+	}
+	sort.Slice(cheats, func(i, j int) bool { return cheats[i].Name < cheats[j].Name })
+	return cheats
+}
+
+// PerMinute is how many cheats a moderator may run per minute
+func (p *Proxy) PerMinute() int {
+	return p.perMinute
+}
+
+// command fills the command of a request's cheat
+func (p *Proxy) command(req Request) (string, error) {
+	cheat, ok := p.cheats[req.Cheat]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownCheat, req.Cheat)
+	}
+	if !idPattern.MatchString(req.Player) {
+		return "", fmt.Errorf("%w: player must be an ID of letters, digits, - and _", ErrInvalidRequest)
+	}
+
+	values := map[string]string{"player": req.Player}
+	for _, param := range cheat.Params {
+		value, given := req.Params[param.Name]
+		if !given || value == "" {
+			if param.Default == "" {
+				return "", fmt.Errorf("%w: %s needs the param %s", ErrInvalidRequest, cheat.Name, param.Name)
+			}
+			value = param.Default
+		}
+		if err := param.check(value); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+		values[param.Name] = value
+	}
+	for name := range req.Params {
+		if _, known := values[name]; !known || name == "player" {
+			return "", fmt.Errorf("%w: %s takes no param %s", ErrInvalidRequest, cheat.Name, name)
+		}
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(cheat.Command, func(placeholder string) string {
+		return values[strings.Trim(placeholder, "{}")]
+	}), nil
+}
+
+// allow takes one of the caller's cheats of the minute. It returns how long
+// to wait when none is left.
+func (p *Proxy) allow(caller rcon.Caller) (bool, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	limiter, ok := p.limiters[caller.Name]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(p.perMinute)), p.perMinute)
+		p.limiters[caller.Name] = limiter
+	}
+	reservation := limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// Run sends a cheat to a map on behalf of caller
+func (p *Proxy) Run(caller rcon.Caller, mapName string, req Request) (Result, error) {
+	command, err := p.command(req)
+	if err != nil {
+		return Result{}, err
+	}
+	if ok, wait := p.allow(caller); !ok {
+		return Result{}, fmt.Errorf("%w: %d per minute, try again in %s", ErrRateLimited, p.perMinute, wait.Round(time.Second))
+	}
+
+	response, err := rcon.ExecuteCheat(caller, mapName, req.Player, command)
+	if err != nil {
+		return Result{}, err
+	}
+	log.Printf("%s ran cheat %s on player %s of map '%s'", caller.Name, req.Cheat, req.Player, mapName)
+	return Result{Cheat: req.Cheat, Map: mapName, Player: req.Player, Command: command, Response: strings.TrimSpace(response)}, nil
+}
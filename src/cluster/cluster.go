@@ -0,0 +1,395 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/broadcast"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/state"
+)
+
+const (
+	defaultRestartDelaySeconds = 60
+	defaultStopTimeoutSeconds  = 300
+)
+
+var (
+	ErrClusterNotFound   = errors.New("cluster not found")
+	ErrRestartInProgress = errors.New("rolling restart already in progress")
+)
+
+// ClusterConfig groups maps that share a ClusterDirOverride so players can
+// transfer between them
+type ClusterConfig struct {
+	Name       string   `json:"name"`
+	ClusterID  string   `json:"cluster_id"`
+	ClusterDir string   `json:"cluster_dir"`
+	Maps       []string `json:"maps"`
+
+	// RestartDelaySeconds is the pause between maps during a rolling
+	// restart, StopTimeoutSeconds how long a map may take to stop or start
+	RestartDelaySeconds int `json:"restart_delay_seconds"`
+	StopTimeoutSeconds  int `json:"stop_timeout_seconds"`
+
+	// RestartWarningSeconds broadcasts a warning this long before each map
+	// of a rolling restart goes down, 0 restarts without warning
+	RestartWarningSeconds int `json:"restart_warning_seconds"`
+
+	// Backup configures the backup of ClusterDir, its extract_dir is always
+	// ClusterDir. Without it cluster backups only cover the maps.
+	Backup *backup.MapConfig `json:"backup,omitempty"`
+
+	// StuckTransferHours flags transfer files older than this as stuck,
+	// default 24
+	StuckTransferHours int `json:"stuck_transfer_hours,omitempty"`
+}
+
+// MapStatus is the state of one map of a cluster
+type MapStatus struct {
+	Map            string                `json:"map"`
+	Running        bool                  `json:"running"`
+	State          processmanager.Actual `json:"state,omitempty"`
+	PID            int                   `json:"pid,omitempty"`
+	Enabled        bool                  `json:"enabled"`
+	BackupSchedule bool                  `json:"backup_schedule"`
+	LastBackup     time.Time             `json:"last_backup,omitempty"`
+}
+
+// RestartProgress tracks a rolling restart
+type RestartProgress struct {
+	Running  bool              `json:"running"`
+	Current  string            `json:"current,omitempty"`
+	Done     []string          `json:"done"`
+	Failed   map[string]string `json:"failed,omitempty"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished,omitempty"`
+}
+
+// Status aggregates the state of all maps of a cluster
+type Status struct {
+	Cluster string           `json:"cluster"`
+	Total   int              `json:"total"`
+	Running int              `json:"running"`
+	Maps    []MapStatus      `json:"maps"`
+	Restart *RestartProgress `json:"restart,omitempty"`
+}
+
+type ClusterManager struct {
+	clusters map[string]ClusterConfig
+	pm       *processmanager.ProcessManager
+	bm       *backup.BackupManager
+	restarts map[string]*RestartProgress
+	// reported holds the transfer problems already notified, by path
+	reported map[string]string
+	mu       sync.Mutex
+}
+
+func NewClusterManager(configFile string, pm *processmanager.ProcessManager, bm *backup.BackupManager) (*ClusterManager, error) {
+	cm := &ClusterManager{
+		clusters: make(map[string]ClusterConfig),
+		pm:       pm,
+		bm:       bm,
+		restarts: make(map[string]*RestartProgress),
+		reported: make(map[string]string),
+	}
+
+	configs, err := LoadClusterConfigs(configFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range configs {
+		for _, mapName := range config.Maps {
+			if !pm.HasMap(mapName) {
+				log.Printf("Cluster '%s' lists map '%s' without a process configuration", config.Name, mapName)
+			}
+		}
+		cm.clusters[config.Name] = config
+	}
+	return cm, nil
+}
+
+// LoadClusterConfigs reads the cluster definitions, a missing file means
+// no clusters are configured
+func LoadClusterConfigs(filename string) ([]ClusterConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cluster config: %w", err)
+	}
+
+	var configs []ClusterConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster config: %w", err)
+	}
+	return configs, nil
+}
+
+// Clusters returns the names of all configured clusters
+func (cm *ClusterManager) Clusters() []string {
+	names := make([]string, 0, len(cm.clusters))
+	for name := range cm.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Maps returns the maps of a cluster
+func (cm *ClusterManager) Maps(name string) ([]string, error) {
+	config, err := cm.cluster(name)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), config.Maps...), nil
+}
+
+func (cm *ClusterManager) cluster(name string) (ClusterConfig, error) {
+	config, ok := cm.clusters[name]
+	if !ok {
+		return ClusterConfig{}, fmt.Errorf("%w: %s", ErrClusterNotFound, name)
+	}
+	return config, nil
+}
+
+// Status reports the process and backup state of every map of a cluster
+func (cm *ClusterManager) Status(name string) (Status, error) {
+	config, err := cm.cluster(name)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Cluster: name, Total: len(config.Maps), Maps: make([]MapStatus, 0, len(config.Maps))}
+	for _, mapName := range config.Maps {
+		ms := MapStatus{Map: mapName}
+		if ps, err := state.Process(mapName); err == nil {
+			ms.Enabled = ps.Enabled
+			if pid, running := cm.pm.Running(mapName); running {
+				ms.Running = true
+				ms.PID = pid
+			}
+		}
+		if ps, exists := cm.pm.State(mapName); exists {
+			ms.State = ps.Actual
+		}
+		if bs, err := state.Schedule(mapName); err == nil {
+			ms.BackupSchedule = bs.Enabled
+			ms.LastBackup = bs.LastBackup
+		}
+		if ms.Running {
+			status.Running++
+		}
+		status.Maps = append(status.Maps, ms)
+	}
+
+	cm.mu.Lock()
+	if progress, ok := cm.restarts[name]; ok {
+		snapshot := *progress
+		snapshot.Done = append([]string(nil), progress.Done...)
+		snapshot.Failed = make(map[string]string, len(progress.Failed))
+		for k, v := range progress.Failed {
+			snapshot.Failed[k] = v
+		}
+		status.Restart = &snapshot
+	}
+	cm.mu.Unlock()
+
+	return status, nil
+}
+
+// Broadcast sends a message to every map of a cluster on behalf of caller.
+// It returns the error of each map the message could not be sent to.
+func (cm *ClusterManager) Broadcast(caller rcon.Caller, name string, message string) (map[string]string, error) {
+	config, err := cm.cluster(name)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, mapName := range config.Maps {
+		wg.Add(1)
+		go func(mapName string) {
+			defer wg.Done()
+			if _, err := rcon.ExecuteAs(caller, mapName, "broadcast "+message); err != nil {
+				mu.Lock()
+				failed[mapName] = err.Error()
+				mu.Unlock()
+			}
+		}(mapName)
+	}
+	wg.Wait()
+	return failed, nil
+}
+
+// Backup queues a backup of every map of a cluster that has a backup
+// configuration and of the cluster directory
+func (cm *ClusterManager) Backup(name string, full bool) ([]backup.BackupJob, error) {
+	config, err := cm.cluster(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []backup.BackupJob
+	for _, mapName := range config.Maps {
+		job, err := cm.bm.QueueBackup(mapName, full)
+		if err != nil {
+			log.Printf("Skipping backup of map '%s' in cluster '%s': %v", mapName, name, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	if dirConfig, ok := config.dirBackup(); ok {
+		job, err := cm.bm.QueueDirectoryBackup(config.backupName(), dirConfig, full)
+		if err != nil {
+			return jobs, fmt.Errorf("failed to queue backup of cluster directory: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// dirBackup returns the backup configuration of the cluster directory,
+// false if the cluster has none
+func (c ClusterConfig) dirBackup() (backup.MapConfig, bool) {
+	if c.Backup == nil || c.ClusterDir == "" {
+		return backup.MapConfig{}, false
+	}
+	dirConfig := *c.Backup
+	dirConfig.ExtractDir = c.ClusterDir
+	if len(dirConfig.FileExtensions) == 0 && len(dirConfig.SpecificFiles) == 0 {
+		dirConfig.FileExtensions = []string{"*"}
+	}
+	// Transfers are not tied to a single server, so no RCON hooks
+	dirConfig.Hooks = nil
+	return dirConfig, true
+}
+
+// backupName is the name cluster directory backups are made under
+func (c ClusterConfig) backupName() string {
+	return "cluster_" + c.Name
+}
+
+// Start backs up the directory of every cluster whose backup sets
+// interval_minutes, independent of the maps' schedules, and watches the
+// cluster directories for stuck and corrupt transfers
+func (cm *ClusterManager) Start() {
+	for _, name := range cm.Clusters() {
+		config := cm.clusters[name]
+		dirConfig, ok := config.dirBackup()
+		if !ok || dirConfig.IntervalMinutes <= 0 {
+			continue
+		}
+		go func() {
+			ticker := time.NewTicker(time.Duration(dirConfig.IntervalMinutes) * time.Minute)
+			for range ticker.C {
+				if maintenance.Global() {
+					continue
+				}
+				if _, err := cm.bm.QueueDirectoryBackup(config.backupName(), dirConfig, false); err != nil {
+					log.Printf("Failed to queue scheduled backup of cluster '%s': %v", config.Name, err)
+				}
+			}
+		}()
+		log.Printf("Backing up cluster '%s' every %d minutes", name, dirConfig.IntervalMinutes)
+	}
+
+	go cm.watchTransfers()
+}
+
+// RollingRestart restarts the maps of a cluster one at a time in the
+// background so players can move to another map while one is down
+func (cm *ClusterManager) RollingRestart(name string) (RestartProgress, error) {
+	config, err := cm.cluster(name)
+	if err != nil {
+		return RestartProgress{}, err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if progress, ok := cm.restarts[name]; ok && progress.Running {
+		return *progress, fmt.Errorf("%w: %s", ErrRestartInProgress, name)
+	}
+
+	progress := &RestartProgress{Running: true, Done: []string{}, Failed: make(map[string]string), Started: time.Now()}
+	cm.restarts[name] = progress
+	go cm.rollingRestart(config, progress)
+	return *progress, nil
+}
+
+func (cm *ClusterManager) rollingRestart(config ClusterConfig, progress *RestartProgress) {
+	delay := time.Duration(config.RestartDelaySeconds) * time.Second
+	if config.RestartDelaySeconds <= 0 {
+		delay = defaultRestartDelaySeconds * time.Second
+	}
+	timeout := time.Duration(config.StopTimeoutSeconds) * time.Second
+	if config.StopTimeoutSeconds <= 0 {
+		timeout = defaultStopTimeoutSeconds * time.Second
+	}
+
+	log.Printf("Starting rolling restart of cluster '%s'", config.Name)
+	for i, mapName := range config.Maps {
+		cm.mu.Lock()
+		progress.Current = mapName
+		cm.mu.Unlock()
+
+		err := cm.restartMap(config, mapName, timeout)
+
+		cm.mu.Lock()
+		if err != nil {
+			log.Printf("Rolling restart of map '%s' failed: %v", mapName, err)
+			progress.Failed[mapName] = err.Error()
+		} else {
+			progress.Done = append(progress.Done, mapName)
+		}
+		cm.mu.Unlock()
+
+		if i < len(config.Maps)-1 {
+			time.Sleep(delay)
+		}
+	}
+
+	cm.mu.Lock()
+	progress.Running = false
+	progress.Current = ""
+	progress.Finished = time.Now()
+	cm.mu.Unlock()
+	log.Printf("Rolling restart of cluster '%s' finished, %d failed", config.Name, len(progress.Failed))
+}
+
+func (cm *ClusterManager) restartMap(config ClusterConfig, mapName string, timeout time.Duration) error {
+	if ps, err := state.Process(mapName); err == nil && !ps.Enabled {
+		log.Printf("Map '%s' is not enabled, skipping it in the rolling restart", mapName)
+		return nil
+	}
+	if maintenance.Active(mapName) {
+		log.Printf("Map '%s' is in maintenance, skipping it in the rolling restart", mapName)
+		return nil
+	}
+
+	if config.RestartWarningSeconds > 0 {
+		vars := broadcast.Seconds(config.RestartWarningSeconds).With(broadcast.VarReason, "rolling restart of cluster "+config.Name)
+		if err := broadcast.Send(broadcast.EventRestart, mapName, vars); err != nil {
+			log.Printf("Failed to announce restart of map '%s': %v", mapName, err)
+		}
+		time.Sleep(time.Duration(config.RestartWarningSeconds) * time.Second)
+	}
+
+	if _, err := rcon.Execute(mapName, "saveworld"); err != nil {
+		log.Printf("Failed to save map '%s' before restart: %v", mapName, err)
+	}
+	return cm.pm.RestartProcess(mapName, timeout)
+}
@@ -0,0 +1,231 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/notify"
+)
+
+const (
+	defaultStuckTransferHours = 24
+
+	// incompleteAfter is how long a temporary upload file may exist before
+	// it is considered an interrupted transfer
+	incompleteAfter = 10 * time.Minute
+
+	transferCheckInterval = 15 * time.Minute
+)
+
+var ErrNoClusterDir = errors.New("cluster has no cluster_dir")
+
+// Transfer problems
+const (
+	ProblemStuck      = "stuck"
+	ProblemEmpty      = "empty"
+	ProblemIncomplete = "incomplete"
+)
+
+// Transfer is a character, dino or item upload waiting in the cluster
+// directory. PlayerID is the uploader's EOS ID, taken from the file name.
+type Transfer struct {
+	PlayerID string    `json:"player_id"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Problem  string    `json:"problem,omitempty"`
+}
+
+// TransferReport lists the pending transfers of a cluster and the backups
+// they can be recovered from
+type TransferReport struct {
+	Cluster   string           `json:"cluster"`
+	Dir       string           `json:"dir"`
+	Transfers []Transfer       `json:"transfers"`
+	Problems  int              `json:"problems"`
+	Backups   []backup.Archive `json:"backups"`
+}
+
+// transferDir is where the servers write uploads. ASA puts them in a
+// subdirectory named after the cluster ID when there is one.
+func (c ClusterConfig) transferDir() string {
+	if c.ClusterID != "" {
+		dir := filepath.Join(c.ClusterDir, c.ClusterID)
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir
+		}
+	}
+	return c.ClusterDir
+}
+
+func (c ClusterConfig) stuckAfter() time.Duration {
+	if c.StuckTransferHours > 0 {
+		return time.Duration(c.StuckTransferHours) * time.Hour
+	}
+	return defaultStuckTransferHours * time.Hour
+}
+
+// Transfers reports the pending transfers of a cluster, only those of
+// player if it is set
+func (cm *ClusterManager) Transfers(name string, player string) (TransferReport, error) {
+	config, err := cm.cluster(name)
+	if err != nil {
+		return TransferReport{}, err
+	}
+	if config.ClusterDir == "" {
+		return TransferReport{}, fmt.Errorf("%w: %s", ErrNoClusterDir, name)
+	}
+
+	transfers, err := scanTransfers(config)
+	if err != nil {
+		return TransferReport{}, err
+	}
+	report := TransferReport{Cluster: name, Dir: config.transferDir(), Transfers: []Transfer{}, Backups: []backup.Archive{}}
+	for _, t := range transfers {
+		if player != "" && !strings.EqualFold(t.PlayerID, player) {
+			continue
+		}
+		if t.Problem != "" {
+			report.Problems++
+		}
+		report.Transfers = append(report.Transfers, t)
+	}
+
+	if dirConfig, ok := config.dirBackup(); ok {
+		archives, err := backup.ListArchives(config.backupName(), dirConfig)
+		if err != nil {
+			log.Printf("Failed to list backups of cluster '%s': %v", name, err)
+		}
+		for _, archive := range archives {
+			if strings.HasPrefix(archive.Name, config.backupName()+"_") {
+				report.Backups = append(report.Backups, archive)
+			}
+		}
+	}
+	return report, nil
+}
+
+// RestoreTransfer puts a transfer file back into the cluster directory from
+// a backup of it, file is relative to cluster_dir
+func (cm *ClusterManager) RestoreTransfer(name string, archive string, file string) ([]string, error) {
+	config, err := cm.cluster(name)
+	if err != nil {
+		return nil, err
+	}
+	dirConfig, ok := config.dirBackup()
+	if !ok {
+		return nil, fmt.Errorf("cluster %s has no backup of its cluster directory", name)
+	}
+	if !strings.HasPrefix(archive, config.backupName()+"_") {
+		return nil, fmt.Errorf("backup %s is not a backup of cluster %s", archive, name)
+	}
+
+	restored, err := cm.bm.RestoreDirectoryBackup(config.backupName(), dirConfig, archive, file)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Restored transfer %s of cluster '%s' from %s", file, name, archive)
+	return restored, nil
+}
+
+func scanTransfers(config ClusterConfig) ([]Transfer, error) {
+	dir := config.transferDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cluster directory: %w", err)
+	}
+	rel, _ := filepath.Rel(config.ClusterDir, dir)
+
+	var transfers []Transfer
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		name := entry.Name()
+		t := Transfer{
+			PlayerID: strings.TrimSuffix(name, filepath.Ext(name)),
+			File:     filepath.ToSlash(filepath.Join(rel, name)),
+			Size:     fi.Size(),
+			ModTime:  fi.ModTime(),
+		}
+		age := now.Sub(fi.ModTime())
+		switch {
+		case strings.EqualFold(filepath.Ext(name), ".tmp"):
+			if age < incompleteAfter {
+				// Still being written
+				continue
+			}
+			t.Problem = ProblemIncomplete
+		case fi.Size() == 0:
+			t.Problem = ProblemEmpty
+		case age > config.stuckAfter():
+			t.Problem = ProblemStuck
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, nil
+}
+
+// watchTransfers logs and notifies each transfer problem once
+func (cm *ClusterManager) watchTransfers() {
+	for {
+		for _, name := range cm.Clusters() {
+			config := cm.clusters[name]
+			if config.ClusterDir != "" {
+				cm.checkTransfers(config)
+			}
+		}
+		time.Sleep(transferCheckInterval)
+	}
+}
+
+func (cm *ClusterManager) checkTransfers(config ClusterConfig) {
+	transfers, err := scanTransfers(config)
+	if err != nil {
+		log.Printf("Failed to check transfers of cluster '%s': %v", config.Name, err)
+		return
+	}
+
+	prefix := config.Name + "/"
+	current := make(map[string]bool)
+	var found []Transfer
+
+	cm.mu.Lock()
+	for _, t := range transfers {
+		if t.Problem == "" {
+			continue
+		}
+		key := prefix + t.File
+		current[key] = true
+		if cm.reported[key] != t.Problem {
+			cm.reported[key] = t.Problem
+			found = append(found, t)
+		}
+	}
+	for key := range cm.reported {
+		if strings.HasPrefix(key, prefix) && !current[key] {
+			delete(cm.reported, key)
+		}
+	}
+	cm.mu.Unlock()
+
+	for _, t := range found {
+		message := fmt.Sprintf("transfer %s of player %s in cluster %s is %s", t.File, t.PlayerID, config.Name, t.Problem)
+		log.Printf("Transfer '%s' of player '%s' in cluster '%s' is %s", t.File, t.PlayerID, config.Name, t.Problem)
+		notify.Send(notify.EventTransferProblem, message)
+	}
+}
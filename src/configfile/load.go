@@ -0,0 +1,283 @@
+package configfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config files may be JSON, YAML or TOML. Callers name the JSON file, e.g.
+// config/rcon_config.json, and a config/rcon_config.yaml or .toml next to
+// it is used when the JSON file does not exist. TOML has no top-level
+// arrays, so list configs put their entries under a single key, e.g.
+// [[maps]].
+//
+// Environment variables override settings of a loaded file, so secrets
+// can stay out of it: ASA_RCON_ISLAND_PASS sets "pass" of the rcon entry
+// whose map is island. The name after the prefix is matched against keys
+// case-insensitively, list entries by their map or their index.
+
+var (
+	formatExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+	// ignoredEnv holds the variables that matched no setting
+	ignoredEnv sync.Map
+)
+
+// ErrReadOnlyFormat is returned when a TOML config would have to be
+// rewritten
+var ErrReadOnlyFormat = errors.New("TOML configs cannot be changed by the manager, use JSON or YAML")
+
+// Resolve returns the file a config is read from: path itself if it
+// exists, otherwise the first of its YAML and TOML variants that does
+func Resolve(path string) string {
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range formatExtensions {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext
+		}
+	}
+	return path
+}
+
+// Read decodes the config at path, in the format of its extension, into v
+// using v's JSON field names. A missing file returns the os error, so
+// os.IsNotExist works on it.
+func Read(path string, v interface{}) error {
+	tree, err := readTree(Resolve(path))
+	if err != nil {
+		return err
+	}
+	return decodeTree(unwrapList(tree, v), v)
+}
+
+// Load is Read with the environment variables starting with prefix_
+// applied on top of the file
+func Load(path string, prefix string, v interface{}) error {
+	tree, err := readTree(Resolve(path))
+	if err != nil {
+		return err
+	}
+	tree = applyEnv(unwrapList(tree, v), prefix, os.Environ())
+	return decodeTree(tree, v)
+}
+
+// LoadOptional is Load for configs with defaults, a missing file keeps the
+// values already in v and still applies the environment variables
+func LoadOptional(path string, prefix string, v interface{}) error {
+	tree, err := readTree(Resolve(path))
+	if os.IsNotExist(err) {
+		// Start from v's defaults, so only the overrides change them
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	tree = applyEnv(unwrapList(tree, v), prefix, os.Environ())
+	return decodeTree(tree, v)
+}
+
+// readTree parses a config into generic JSON values
+func readTree(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		// Round-trip YAML's ints and nested maps into JSON's types
+		data, err = json.Marshal(tree)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		tree = nil
+		fallthrough
+	case ".json":
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".toml":
+		table, err := parseTOML(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		tree = table
+	default:
+		return nil, fmt.Errorf("unsupported config format %s", path)
+	}
+	return tree, nil
+}
+
+// unwrapList returns the list of a table holding a single list when v is
+// a slice, that is how TOML and YAML files may wrap list configs
+func unwrapList(tree interface{}, v interface{}) interface{} {
+	table, ok := tree.(map[string]interface{})
+	if !ok || len(table) != 1 {
+		return tree
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Slice {
+		return tree
+	}
+	for _, value := range table {
+		if list, ok := value.([]interface{}); ok {
+			return list
+		}
+	}
+	return tree
+}
+
+// decodeTree decodes generic values into v
+func decodeTree(tree interface{}, v interface{}) error {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Save writes v to the file the config at path is read from, in that
+// file's format. JSON configs keep being written by WriteJSON.
+func Save(path string, v interface{}) error {
+	path = Resolve(path)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", path, err)
+		}
+		var tree interface{}
+		if err := json.Unmarshal(data, &tree); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", path, err)
+		}
+		out, err := yaml.Marshal(tree)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", path, err)
+		}
+		return WriteFile(path, out)
+	case ".toml":
+		return fmt.Errorf("%w: %s", ErrReadOnlyFormat, path)
+	default:
+		return WriteJSON(path, v)
+	}
+}
+
+// applyEnv sets the values of the variables named prefix_<path>
+func applyEnv(tree interface{}, prefix string, environ []string) interface{} {
+	prefix = strings.ToUpper(prefix) + "_"
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+			continue
+		}
+		tokens := strings.Split(strings.TrimPrefix(name, prefix), "_")
+		updated, ok := setPath(tree, tokens, value, true)
+		if !ok {
+			// Some configs are read for every request, warn once
+			if _, warned := ignoredEnv.LoadOrStore(name, true); !warned {
+				log.Printf("Ignoring %s: no such setting", name)
+			}
+			continue
+		}
+		tree = updated
+	}
+	return tree
+}
+
+// setPath sets the setting named by tokens, trying the longest key first
+// since keys contain underscores too. Missing keys are only added to the
+// top level and to list entries, elsewhere they are more likely a
+// misspelled map name than a setting.
+func setPath(node interface{}, tokens []string, raw string, addMissing bool) (interface{}, bool) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for i := len(tokens); i > 0; i-- {
+			want := strings.Join(tokens[:i], "_")
+			for key, child := range n {
+				if !strings.EqualFold(key, want) {
+					continue
+				}
+				if i == len(tokens) {
+					n[key] = convert(child, raw)
+					return n, true
+				}
+				if updated, ok := setPath(child, tokens[i:], raw, false); ok {
+					n[key] = updated
+					return n, true
+				}
+			}
+		}
+		if addMissing && len(tokens) > 0 {
+			n[strings.ToLower(strings.Join(tokens, "_"))] = convert(nil, raw)
+			return n, true
+		}
+	case []interface{}:
+		for i := len(tokens); i > 0; i-- {
+			want := strings.Join(tokens[:i], "_")
+			for j, child := range n {
+				if !matchesEntry(child, j, want) || i == len(tokens) {
+					continue
+				}
+				if updated, ok := setPath(child, tokens[i:], raw, true); ok {
+					n[j] = updated
+					return n, true
+				}
+			}
+		}
+	}
+	return node, false
+}
+
+// matchesEntry reports whether a list entry is named by want, by its map
+// or its index
+func matchesEntry(entry interface{}, index int, want string) bool {
+	if table, ok := entry.(map[string]interface{}); ok {
+		if name, ok := table["map"].(string); ok && strings.EqualFold(name, want) {
+			return true
+		}
+	}
+	return want == strconv.Itoa(index)
+}
+
+// convert parses raw as the type of the value it replaces, new values and
+// lists or tables are parsed as JSON if they are valid JSON
+func convert(old interface{}, raw string) interface{} {
+	switch old.(type) {
+	case string:
+		return raw
+	case float64:
+		if f, err := strconv.ParseFloat(raw, 64); err == nil {
+			return f
+		}
+	case bool:
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err == nil {
+		return v
+	}
+	return raw
+}
@@ -0,0 +1,440 @@
+package configfile
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML parses the part of TOML config files use: tables, arrays of
+// tables, dotted keys, strings, numbers, booleans, arrays and inline
+// tables. Dates are kept as strings.
+func parseTOML(input string) (map[string]interface{}, error) {
+	p := &tomlParser{input: input, line: 1}
+	root := make(map[string]interface{})
+	current := root
+
+	for {
+		p.skipSpaceAndComments(true)
+		if p.eof() {
+			return root, nil
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(p.rest(), "[["):
+			p.pos += 2
+			current, err = p.tableHeader(root, "]]", true)
+		case p.peek() == '[':
+			p.pos++
+			current, err = p.tableHeader(root, "]", false)
+		default:
+			err = p.keyValue(current)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+	}
+}
+
+type tomlParser struct {
+	input string
+	pos   int
+	line  int
+}
+
+func (p *tomlParser) eof() bool    { return p.pos >= len(p.input) }
+func (p *tomlParser) rest() string { return p.input[p.pos:] }
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// skipSpaceAndComments skips blanks and comments, and newlines too when
+// multiline is set
+func (p *tomlParser) skipSpaceAndComments(multiline bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && multiline:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpaceAndComments(false)
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return fmt.Errorf("unexpected %q after value", p.peek())
+	}
+	return nil
+}
+
+// tableHeader reads [a.b] or [[a.b]] and returns the table it opens
+func (p *tomlParser) tableHeader(root map[string]interface{}, closing string, array bool) (map[string]interface{}, error) {
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaceAndComments(false)
+	if !strings.HasPrefix(p.rest(), closing) {
+		return nil, fmt.Errorf("table header must end with %s", closing)
+	}
+	p.pos += len(closing)
+
+	parent, err := descend(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	if array {
+		list, _ := parent[last].([]interface{})
+		if existing, ok := parent[last]; ok && list == nil {
+			return nil, fmt.Errorf("%s is not an array of tables: %v", last, existing)
+		}
+		table := make(map[string]interface{})
+		parent[last] = append(list, table)
+		return table, nil
+	}
+	return descend(parent, []string{last})
+}
+
+// descend walks to the table named by keys, creating missing tables. The
+// last entry of an array of tables stands for the array.
+func descend(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch next := table[key].(type) {
+		case nil:
+			child := make(map[string]interface{})
+			table[key] = child
+			table = child
+		case map[string]interface{}:
+			table = next
+		case []interface{}:
+			if len(next) == 0 {
+				return nil, fmt.Errorf("%s is not a table", key)
+			}
+			last, ok := next[len(next)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%s is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpaceAndComments(false)
+	if p.peek() != '=' {
+		return errors.New("expected '=' after key")
+	}
+	p.pos++
+	p.skipSpaceAndComments(false)
+
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := descend(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return fmt.Errorf("key %s is defined twice", last)
+	}
+	parent[last] = value
+	return nil
+}
+
+// key reads a bare, quoted or dotted key
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpaceAndComments(false)
+		var part string
+		switch c := p.peek(); {
+		case c == '"':
+			s, err := p.basicString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		case c == '\'':
+			s, err := p.literalString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, errors.New("expected a key")
+			}
+			part = p.input[start:p.pos]
+		}
+		keys = append(keys, part)
+
+		p.skipSpaceAndComments(false)
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	switch c := p.peek(); {
+	case strings.HasPrefix(p.rest(), `"""`):
+		return p.multilineString(`"""`)
+	case strings.HasPrefix(p.rest(), `'''`):
+		return p.multilineString(`'''`)
+	case c == '"':
+		return p.basicString()
+	case c == '\'':
+		return p.literalString()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case strings.HasPrefix(p.rest(), "true"):
+		p.pos += 4
+		return true, nil
+	case strings.HasPrefix(p.rest(), "false"):
+		p.pos += 5
+		return false, nil
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.peek())) {
+		p.pos++
+	}
+	token := p.input[start:p.pos]
+	if token == "" {
+		return nil, errors.New("expected a value")
+	}
+	number := strings.ReplaceAll(token, "_", "")
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return float64(i), nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	// Dates and times
+	if token[0] >= '0' && token[0] <= '9' && strings.ContainsAny(token, "-:") {
+		// A local date-time may have a space instead of the T
+		if len(token) == 10 && strings.HasPrefix(p.rest(), " ") && len(p.rest()) > 1 && p.rest()[1] >= '0' && p.rest()[1] <= '9' {
+			p.pos++
+			timeStart := p.pos
+			for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.peek())) {
+				p.pos++
+			}
+			token += "T" + p.input[timeStart:p.pos]
+		}
+		return token, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++ // opening quote
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", errors.New("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) escape(b *strings.Builder) error {
+	if p.eof() {
+		return errors.New("unterminated escape")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.input) {
+			return errors.New("short unicode escape")
+		}
+		code, err := strconv.ParseUint(p.input[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return errors.New("invalid unicode escape")
+		}
+		p.pos += size
+		b.WriteRune(rune(code))
+	default:
+		return fmt.Errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+func (p *tomlParser) literalString() (string, error) {
+	p.pos++ // opening quote
+	end := strings.IndexAny(p.rest(), "'\n")
+	if end < 0 || p.rest()[end] != '\'' {
+		return "", errors.New("unterminated string")
+	}
+	s := p.rest()[:end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// multilineString reads """...""" and ”'...”', a newline right after the
+// opening quotes is dropped
+func (p *tomlParser) multilineString(quotes string) (string, error) {
+	p.pos += len(quotes)
+	if strings.HasPrefix(p.rest(), "\r\n") {
+		p.pos += 2
+		p.line++
+	} else if strings.HasPrefix(p.rest(), "\n") {
+		p.pos++
+		p.line++
+	}
+
+	end := strings.Index(p.rest(), quotes)
+	if end < 0 {
+		return "", errors.New("unterminated multi-line string")
+	}
+	raw := p.rest()[:end]
+	p.pos += end + len(quotes)
+	p.line += strings.Count(raw, "\n")
+	if quotes == "'''" {
+		return raw, nil
+	}
+
+	var b strings.Builder
+	inner := &tomlParser{input: raw}
+	for !inner.eof() {
+		c := inner.peek()
+		inner.pos++
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		// A backslash at the end of a line joins it with the next
+		// non-blank character
+		if trimmed := strings.TrimLeft(inner.rest(), " \t\r"); strings.HasPrefix(trimmed, "\n") {
+			inner.pos = len(inner.input) - len(strings.TrimLeft(trimmed, " \t\r\n"))
+			continue
+		}
+		if err := inner.escape(&b); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func (p *tomlParser) array() ([]interface{}, error) {
+	p.pos++ // [
+	values := []interface{}{}
+	for {
+		p.skipSpaceAndComments(true)
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipSpaceAndComments(true)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return values, nil
+		default:
+			return nil, errors.New("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable() (map[string]interface{}, error) {
+	p.pos++ // {
+	table := make(map[string]interface{})
+	p.skipSpaceAndComments(false)
+	if p.peek() == '}' {
+		p.pos++
+		return table, nil
+	}
+	for {
+		if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpaceAndComments(false)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, errors.New("expected ',' or '}' in inline table")
+		}
+	}
+}
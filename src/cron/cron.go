@@ -0,0 +1,182 @@
+// Package cron parses cron expressions and computes when they fire next.
+// It supports the five standard fields, minute hour day-of-month month
+// day-of-week, with *, lists, ranges, steps and the names of months and
+// weekdays, as well as @yearly, @monthly, @weekly, @daily and @hourly.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalid = errors.New("invalid cron expression")
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	expr    string
+	minutes uint64
+	hours   uint64
+	days    uint64
+	months  uint64
+	weekday uint64
+	// anyDay and anyWeekday record an unrestricted field. Like in cron, a
+	// day matches either field when both are restricted.
+	anyDay     bool
+	anyWeekday bool
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField  = field{name: "minute", min: 0, max: 59}
+	hourField    = field{name: "hour", min: 0, max: 23}
+	dayField     = field{name: "day of month", min: 1, max: 31}
+	monthField   = field{name: "month", min: 1, max: 12, names: map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}}
+	weekdayField = field{name: "day of week", min: 0, max: 7, names: map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression such as "0 4 * * *" or "@daily"
+func Parse(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: want 5 fields, minute hour day-of-month month day-of-week", ErrInvalid, expr)
+	}
+
+	s := &Schedule{expr: expr, anyDay: strings.HasPrefix(fields[2], "*"), anyWeekday: strings.HasPrefix(fields[4], "*")}
+	var err error
+	for i, target := range []*uint64{&s.minutes, &s.hours, &s.days, &s.months, &s.weekday} {
+		f := []field{minuteField, hourField, dayField, monthField, weekdayField}[i]
+		if *target, err = f.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalid, expr, err)
+		}
+	}
+	// 7 is Sunday too
+	if s.weekday&(1<<7) != 0 {
+		s.weekday |= 1
+	}
+	return s, nil
+}
+
+// parse returns the values of a field as a bit set
+func (f field) parse(value string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(value, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s step %q must be a positive number", f.name, stepPart)
+			}
+			step = n
+		}
+
+		low, high := f.min, f.max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = f.value(from); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = f.value(to); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" runs from 5 to the end of the range
+				high = f.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("%s range %q ends before it starts", f.name, rangePart)
+			}
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (f field) value(value string) (int, error) {
+	if n, ok := f.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("%s %q must be from %d to %d", f.name, value, f.min, f.max)
+	}
+	return n, nil
+}
+
+// String returns the expression as it was parsed
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekday&(1<<t.Weekday()) != 0
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// Next returns the first time after t the schedule fires, in the location
+// of t. It returns the zero time if it never fires, e.g. on February 30.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every combination of the fields comes up within a few years, leap
+	// days within eight
+	limit := t.AddDate(8, 0, 0)
+	for t.Before(limit) {
+		if s.months&(1<<t.Month()) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hours&(1<<t.Hour()) == 0 {
+			// Adding an hour instead of setting it steps through daylight
+			// saving changes
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+			continue
+		}
+		if s.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,121 @@
+// Package curseforge looks up mods in the CurseForge API, where ASA's mods
+// are published. Servers download the newest file of their mods when they
+// start.
+package curseforge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBaseURL = "https://api.curseforge.com"
+
+	// maxModsPerRequest keeps the lookups below the limits of the API
+	maxModsPerRequest = 100
+)
+
+var ErrUnauthorized = errors.New("CurseForge rejected the API key")
+
+// Mod is the newest file of a mod
+type Mod struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// FileID and File identify the newest file, FileDate is when it was
+	// uploaded
+	FileID   int       `json:"file_id"`
+	File     string    `json:"file"`
+	FileDate time.Time `json:"file_date"`
+}
+
+type Client struct {
+	apiKey  string
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a client for the API at baseURL, api.curseforge.com
+// if empty
+func NewClient(apiKey string, baseURL string) (*Client, error) {
+	if apiKey == "" {
+		return nil, errors.New("a CurseForge API key is required")
+	}
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	return &Client{apiKey: apiKey, baseURL: strings.TrimSuffix(baseURL, "/"), http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Mods returns the newest files of mods by ID. Mods CurseForge does not
+// know are left out.
+func (c *Client) Mods(ids []int) (map[int]Mod, error) {
+	mods := make(map[int]Mod, len(ids))
+	for start := 0; start < len(ids); start += maxModsPerRequest {
+		end := start + maxModsPerRequest
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := c.lookup(ids[start:end], mods); err != nil {
+			return nil, err
+		}
+	}
+	return mods, nil
+}
+
+func (c *Client) lookup(ids []int, mods map[int]Mod) error {
+	body, err := json.Marshal(map[string]interface{}{"modIds": ids})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/v1/mods", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("x-api-key", c.apiKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CurseForge mod lookup returned %s", resp.Status)
+	}
+
+	var result struct {
+		Data []struct {
+			ID          int    `json:"id"`
+			Name        string `json:"name"`
+			MainFileID  int    `json:"mainFileId"`
+			LatestFiles []struct {
+				ID          int       `json:"id"`
+				DisplayName string    `json:"displayName"`
+				FileDate    time.Time `json:"fileDate"`
+			} `json:"latestFiles"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode CurseForge mods: %w", err)
+	}
+	for _, data := range result.Data {
+		mod := Mod{ID: data.ID, Name: data.Name, FileID: data.MainFileID}
+		for _, file := range data.LatestFiles {
+			if file.ID == data.MainFileID {
+				mod.File = file.DisplayName
+				mod.FileDate = file.FileDate
+			}
+		}
+		mods[data.ID] = mod
+	}
+	return nil
+}
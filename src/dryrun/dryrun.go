@@ -0,0 +1,66 @@
+// Package dryrun lets destructive operations report what they would do
+// instead of doing it. A dry run is asked for per request, or for every
+// operation of the manager, scheduled ones and the actions of rules
+// included, with the dry_run switch of the server config. This makes it
+// safe to try out automation against live servers.
+package dryrun
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+var global atomic.Bool
+
+// SetGlobal turns the dry run of every destructive operation on or off
+func SetGlobal(enabled bool) {
+	global.Store(enabled)
+}
+
+// Global reports whether every destructive operation is a dry run
+func Global() bool {
+	return global.Load()
+}
+
+// Plan is what an operation would have done
+type Plan struct {
+	Operation string `json:"operation"`
+	Map       string `json:"map,omitempty"`
+	// Deleted are the files that would be deleted
+	Deleted []string `json:"deleted,omitempty"`
+	// Commands are the RCON commands that would be sent, in order
+	Commands []string `json:"commands,omitempty"`
+	// Killed are the processes that would be killed
+	Killed []string `json:"killed,omitempty"`
+	// Steps are the other things that would be done, in order
+	Steps []string `json:"steps,omitempty"`
+}
+
+// Stepf adds a step to the plan
+func (p *Plan) Stepf(format string, args ...interface{}) {
+	p.Steps = append(p.Steps, fmt.Sprintf(format, args...))
+}
+
+// Log writes the plan to the log, for dry runs of scheduled operations
+// that nobody gets a response for
+func (p Plan) Log() {
+	var parts []string
+	for _, list := range []struct {
+		name  string
+		items []string
+	}{{"would delete", p.Deleted}, {"would send", p.Commands}, {"would kill", p.Killed}, {"steps", p.Steps}} {
+		if len(list.items) > 0 {
+			parts = append(parts, list.name+": "+strings.Join(list.items, "; "))
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "nothing to do")
+	}
+	target := ""
+	if p.Map != "" {
+		target = " of map '" + p.Map + "'"
+	}
+	log.Printf("Dry run of %s%s: %s", p.Operation, target, strings.Join(parts, ", "))
+}
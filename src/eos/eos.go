@@ -0,0 +1,192 @@
+// Package eos looks servers up in the Epic Online Services server list,
+// which is where ASA's crossplay server browser finds them. It uses the
+// matchmaking web API with a client credentials token.
+package eos
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBaseURL = "https://api.epicgames.dev"
+
+	// maxResults is the most sessions one lookup returns, more than one
+	// host runs
+	maxResults = 200
+	// tokenMargin renews a token this long before it expires
+	tokenMargin = time.Minute
+)
+
+var ErrUnauthorized = errors.New("EOS rejected the client credentials")
+
+// Config holds the credentials of an EOS client allowed to read the
+// sessions of a deployment
+type Config struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	DeploymentID string `json:"deployment_id"`
+	// BaseURL is the EOS web API, api.epicgames.dev if empty
+	BaseURL string `json:"base_url,omitempty"`
+}
+
+// Session is a server listed in EOS
+type Session struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Map  string `json:"map,omitempty"`
+	// Address is the address players connect to, host:port
+	Address    string `json:"address"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"max_players,omitempty"`
+}
+
+// Port returns the game port of the session, 0 if its address has none
+func (s Session) Port() int {
+	_, port, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+type Client struct {
+	config Config
+	http   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewClient(config Config) (*Client, error) {
+	if config.ClientID == "" || config.ClientSecret == "" || config.DeploymentID == "" {
+		return nil, errors.New("client_id, client_secret and deployment_id are required")
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = defaultBaseURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	return &Client{config: config, http: &http.Client{Timeout: 15 * time.Second}}, nil
+}
+
+// Sessions returns the sessions listed with a public IP address
+func (c *Client) Sessions(ip string) ([]Session, error) {
+	token, err := c.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"criteria":   []map[string]interface{}{{"key": "attributes.ADDRESS_s", "op": "EQUAL", "value": ip}},
+		"maxResults": maxResults,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.config.BaseURL+"/wildcard/matchmaking/v1/"+url.PathEscape(c.config.DeploymentID)+"/filter", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Let the next lookup get a new token
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		return nil, ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("EOS session lookup returned %s", resp.Status)
+	}
+
+	var result struct {
+		Sessions []struct {
+			ID           string                 `json:"id"`
+			TotalPlayers int                    `json:"totalPlayers"`
+			Attributes   map[string]interface{} `json:"attributes"`
+			Settings     struct {
+				MaxPublicPlayers int `json:"maxPublicPlayers"`
+			} `json:"settings"`
+		} `json:"sessions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode EOS sessions: %w", err)
+	}
+	sessions := make([]Session, 0, len(result.Sessions))
+	for _, s := range result.Sessions {
+		sessions = append(sessions, Session{
+			ID:         s.ID,
+			Name:       attribute(s.Attributes, "CUSTOMSERVERNAME_s"),
+			Map:        attribute(s.Attributes, "MAPNAME_s"),
+			Address:    attribute(s.Attributes, "ADDRESSBOUND_s"),
+			Players:    s.TotalPlayers,
+			MaxPlayers: s.Settings.MaxPublicPlayers,
+		})
+	}
+	return sessions, nil
+}
+
+func attribute(attributes map[string]interface{}, key string) string {
+	value, _ := attributes[key].(string)
+	return value
+}
+
+// accessToken returns a client credentials token, getting a new one when
+// the last is about to expire
+func (c *Client) accessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Until(c.expires) > tokenMargin {
+		return c.token, nil
+	}
+	form := url.Values{"grant_type": {"client_credentials"}, "deployment_id": {c.config.DeploymentID}}
+	req, err := http.NewRequest(http.MethodPost, c.config.BaseURL+"/auth/v1/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.config.ClientID, c.config.ClientSecret)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return "", ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("EOS token request returned %s", resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode EOS token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("EOS returned no access token")
+	}
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return c.token, nil
+}
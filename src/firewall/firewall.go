@@ -0,0 +1,328 @@
+// Package firewall opens the ports of the servers in the host firewall:
+// Windows Firewall through netsh, ufw or nftables on Linux. The rules it
+// created are recorded per map, so changed ports replace them and an
+// unregistered map has them removed. In dry-run mode the commands are only
+// returned.
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/state"
+)
+
+// Backends
+const (
+	BackendAuto     = "auto"
+	BackendNetsh    = "netsh"
+	BackendUFW      = "ufw"
+	BackendNftables = "nftables"
+)
+
+const (
+	bucketFirewall = "firewall"
+
+	commandTimeout = 30 * time.Second
+
+	defaultNftTable = "inet filter"
+	defaultNftChain = "input"
+	// The nftables ports are kept in these sets of the configured table,
+	// one rule per set accepts them
+	nftUDPSet = "asa_udp_ports"
+	nftTCPSet = "asa_tcp_ports"
+)
+
+var (
+	ErrDisabled       = errors.New("firewall management is not enabled")
+	ErrInvalidBackend = errors.New("invalid firewall backend")
+)
+
+// Config enables the rules, a missing file leaves the firewall alone
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	Backend string `json:"backend"`
+	// DryRun logs the commands instead of running them
+	DryRun bool `json:"dry_run"`
+	// AllowRCON opens the RCON ports too, by default RCON stays reachable
+	// from this host only
+	AllowRCON bool `json:"allow_rcon"`
+	// NftTable and NftChain are the table and input chain of the
+	// nftables rules, default "inet filter" and "input"
+	NftTable string `json:"nft_table,omitempty"`
+	NftChain string `json:"nft_chain,omitempty"`
+}
+
+// Rule allows incoming traffic to a port of a map
+type Rule struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// Result is the outcome of updating the rules of a map
+type Result struct {
+	Map      string   `json:"map"`
+	Backend  string   `json:"backend"`
+	DryRun   bool     `json:"dry_run"`
+	Rules    []Rule   `json:"rules"`
+	Commands []string `json:"commands"`
+}
+
+var (
+	config   Config
+	configMu sync.RWMutex
+
+	// mu serializes changes of the rules
+	mu sync.Mutex
+)
+
+// Load reads the firewall config
+func Load(configFile string) error {
+	var loaded Config
+	if err := configfile.Read(configFile, &loaded); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read firewall config: %w", err)
+	}
+	if loaded.Backend == "" {
+		loaded.Backend = BackendAuto
+	}
+	switch loaded.Backend {
+	case BackendAuto, BackendNetsh, BackendUFW, BackendNftables:
+	default:
+		return fmt.Errorf("%w: %s, use auto, netsh, ufw or nftables", ErrInvalidBackend, loaded.Backend)
+	}
+	if loaded.NftTable == "" {
+		loaded.NftTable = defaultNftTable
+	}
+	if loaded.NftChain == "" {
+		loaded.NftChain = defaultNftChain
+	}
+	if len(strings.Fields(loaded.NftTable)) != 2 || len(strings.Fields(loaded.NftChain)) != 1 {
+		return errors.New("nft_table must be a family and name such as \"inet filter\" and nft_chain a chain name")
+	}
+
+	configMu.Lock()
+	config = loaded
+	configMu.Unlock()
+	return nil
+}
+
+func current() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+// Enabled reports whether rules are managed
+func Enabled() bool {
+	return current().Enabled
+}
+
+// backend resolves the auto backend for this host
+func (c Config) backend() string {
+	if c.Backend != BackendAuto && c.Backend != "" {
+		return c.Backend
+	}
+	if runtime.GOOS == "windows" {
+		return BackendNetsh
+	}
+	if _, err := exec.LookPath("ufw"); err == nil {
+		return BackendUFW
+	}
+	return BackendNftables
+}
+
+// Rules returns the rules a map's config needs
+func Rules(pc processmanager.ProcessConfig) []Rule {
+	allowRCON := current().AllowRCON
+	rules := []Rule{}
+	for name, port := range pc.Ports() {
+		if name == "rcon_port" && !allowRCON {
+			continue
+		}
+		rules = append(rules, Rule{Name: name, Port: port, Protocol: processmanager.PortProtocol(name)})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Port < rules[j].Port })
+	return rules
+}
+
+// Applied returns the rules recorded for every map
+func Applied() (map[string][]Rule, error) {
+	applied := make(map[string][]Rule)
+	err := state.ForEach(bucketFirewall, func(key string, value []byte) error {
+		var rules []Rule
+		if err := json.Unmarshal(value, &rules); err != nil {
+			return fmt.Errorf("invalid firewall rules of %s: %w", key, err)
+		}
+		applied[key] = rules
+		return nil
+	})
+	return applied, err
+}
+
+// Sync makes the rules of a map match its ports. Only the differences to
+// the recorded rules are changed. dryRun returns the commands without
+// running them, it also works while rules are not managed.
+func Sync(pc processmanager.ProcessConfig, dryRun bool) (Result, error) {
+	return update(pc.Map, Rules(pc), dryRun)
+}
+
+// Remove deletes the rules of a map
+func Remove(mapName string, dryRun bool) (Result, error) {
+	return update(mapName, []Rule{}, dryRun)
+}
+
+func update(mapName string, rules []Rule, dryRun bool) (Result, error) {
+	c := current()
+	dryRun = dryRun || c.DryRun
+	if !c.Enabled && !dryRun {
+		return Result{}, ErrDisabled
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var previous []Rule
+	if _, err := state.Get(bucketFirewall, mapName, &previous); err != nil {
+		return Result{}, err
+	}
+	added, removed := diff(previous, rules)
+
+	result := Result{Map: mapName, Backend: c.backend(), DryRun: dryRun, Rules: rules, Commands: []string{}}
+	commands := c.commands(result.Backend, mapName, added, removed)
+	for _, args := range commands {
+		result.Commands = append(result.Commands, strings.Join(args, " "))
+	}
+	if dryRun {
+		for _, command := range result.Commands {
+			log.Printf("Firewall dry run for %s: %s", mapName, command)
+		}
+		return result, nil
+	}
+
+	for _, args := range commands {
+		if err := run(args); err != nil {
+			return result, err
+		}
+	}
+	if len(rules) == 0 {
+		return result, state.Delete(bucketFirewall, mapName)
+	}
+	if len(commands) > 0 {
+		log.Printf("Updated firewall rules of %s with %s: %d added, %d removed", mapName, result.Backend, len(added), len(removed))
+	}
+	return result, state.Put(bucketFirewall, mapName, rules)
+}
+
+// diff returns the rules to add and to remove
+func diff(previous, rules []Rule) (added, removed []Rule) {
+	key := func(r Rule) string { return r.Name + "/" + strconv.Itoa(r.Port) + "/" + r.Protocol }
+	had := make(map[string]bool, len(previous))
+	for _, r := range previous {
+		had[key(r)] = true
+	}
+	wanted := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		wanted[key(r)] = true
+		if !had[key(r)] {
+			added = append(added, r)
+		}
+	}
+	for _, r := range previous {
+		if !wanted[key(r)] {
+			removed = append(removed, r)
+		}
+	}
+	return added, removed
+}
+
+// ruleName names the netsh rule and ufw comment of a map's port
+func ruleName(mapName string, r Rule) string {
+	return "ASA-" + mapName + "-" + r.Name
+}
+
+// commands returns the commands that remove and then add rules
+func (c Config) commands(backend string, mapName string, added, removed []Rule) [][]string {
+	var commands [][]string
+	switch backend {
+	case BackendNetsh:
+		for _, r := range removed {
+			commands = append(commands, []string{"netsh", "advfirewall", "firewall", "delete", "rule", "name=" + ruleName(mapName, r)})
+		}
+		for _, r := range added {
+			commands = append(commands, []string{"netsh", "advfirewall", "firewall", "add", "rule", "name=" + ruleName(mapName, r),
+				"dir=in", "action=allow", "protocol=" + strings.ToUpper(r.Protocol), "localport=" + strconv.Itoa(r.Port)})
+		}
+	case BackendUFW:
+		for _, r := range removed {
+			commands = append(commands, []string{"ufw", "delete", "allow", fmt.Sprintf("%d/%s", r.Port, r.Protocol)})
+		}
+		for _, r := range added {
+			commands = append(commands, []string{"ufw", "allow", fmt.Sprintf("%d/%s", r.Port, r.Protocol), "comment", ruleName(mapName, r)})
+		}
+	case BackendNftables:
+		table := strings.Fields(c.NftTable)
+		if len(added) > 0 {
+			commands = append(commands, c.nftSetup(table)...)
+		}
+		for _, r := range removed {
+			commands = append(commands, append([]string{"nft", "delete", "element"}, append(table, nftSet(r), fmt.Sprintf("{ %d }", r.Port))...))
+		}
+		for _, r := range added {
+			commands = append(commands, append([]string{"nft", "add", "element"}, append(table, nftSet(r), fmt.Sprintf("{ %d }", r.Port))...))
+		}
+	}
+	return commands
+}
+
+func nftSet(r Rule) string {
+	if r.Protocol == "tcp" {
+		return nftTCPSet
+	}
+	return nftUDPSet
+}
+
+// nftSetup creates the port sets and the rules accepting them unless the
+// chain already has them
+func (c Config) nftSetup(table []string) [][]string {
+	var commands [][]string
+	for _, set := range []string{nftUDPSet, nftTCPSet} {
+		commands = append(commands, append([]string{"nft", "add", "set"}, append(table, set, "{ type inet_service; }")...))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	listing, _ := exec.CommandContext(ctx, "nft", append([]string{"list", "chain"}, append(table, c.NftChain)...)...).Output()
+	for _, protocol := range []string{"udp", "tcp"} {
+		set := nftSet(Rule{Protocol: protocol})
+		if !strings.Contains(string(listing), "@"+set) {
+			commands = append(commands, append([]string{"nft", "insert", "rule"}, append(table, c.NftChain, protocol, "dport", "@"+set, "accept")...))
+		}
+	}
+	return commands
+}
+
+func run(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+// Package gamelog collects the game log of the running servers over RCON
+// and keeps it searchable in the state store. Each line becomes an Event
+// classified as a join, leave, death, tribe log entry or admin command.
+package gamelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/state"
+)
+
+const (
+	bucketGameLog = "gamelog"
+
+	defaultIntervalSeconds = 60
+	defaultRetentionDays   = 30
+	defaultMaxEvents       = 10000
+	pollTimeout            = 10 * time.Second
+	pruneInterval          = time.Hour
+
+	// DefaultLimit is how many events Search returns without a limit
+	DefaultLimit = 200
+	maxLimit     = 5000
+	// NoLimit makes Search return every matching event
+	NoLimit = -1
+)
+
+// Event types
+const (
+	TypeJoin  = "join"
+	TypeLeave = "leave"
+	TypeDeath = "death"
+	TypeTribe = "tribe"
+	TypeAdmin = "admin"
+	TypeOther = "other"
+)
+
+// Types lists the event types
+var Types = []string{TypeJoin, TypeLeave, TypeDeath, TypeTribe, TypeAdmin, TypeOther}
+
+var (
+	joinPattern  = regexp.MustCompile(`^(.+?) joined this ARK!?$`)
+	leavePattern = regexp.MustCompile(`^(.+?) left this ARK!?$`)
+	adminPattern = regexp.MustCompile(`^AdminCmd: (.*?) \(PlayerName: (.*?),`)
+	deathPattern = regexp.MustCompile(`^(.+?) (?:was killed|died|starved to death|drowned|was crushed)`)
+	// levelSuffix is the level and tribe after a name in death messages
+	levelSuffix = regexp.MustCompile(` - Lvl \d+.*$`)
+)
+
+// Config enables the collection, a missing file leaves it off
+type Config struct {
+	Enabled         bool `json:"enabled"`
+	IntervalSeconds int  `json:"interval_seconds"`
+	// RetentionDays and MaxEvents, per map, bound what is kept
+	RetentionDays int `json:"retention_days"`
+	MaxEvents     int `json:"max_events"`
+}
+
+// Event is a line of a map's game log
+type Event struct {
+	ID      string    `json:"id"`
+	Map     string    `json:"map"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Player  string    `json:"player,omitempty"`
+	Tribe   string    `json:"tribe,omitempty"`
+	TribeID string    `json:"tribe_id,omitempty"`
+	Message string    `json:"message"`
+}
+
+// Filter selects events, zero values match everything
+type Filter struct {
+	Map string
+	// Player matches the event's player or its message, case-insensitively
+	Player string
+	Type   string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+type Collector struct {
+	config Config
+	pm     *processmanager.ProcessManager
+
+	mu  sync.Mutex
+	seq int
+}
+
+func NewCollector(configFile string, pm *processmanager.ProcessManager) (*Collector, error) {
+	var config Config
+	if err := configfile.Read(configFile, &config); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read game log config: %w", err)
+	}
+	if config.IntervalSeconds <= 0 {
+		config.IntervalSeconds = defaultIntervalSeconds
+	}
+	if config.RetentionDays <= 0 {
+		config.RetentionDays = defaultRetentionDays
+	}
+	if config.MaxEvents <= 0 {
+		config.MaxEvents = defaultMaxEvents
+	}
+	return &Collector{config: config, pm: pm}, nil
+}
+
+// Enabled reports whether the game logs are collected
+func (c *Collector) Enabled() bool {
+	return c.config.Enabled
+}
+
+// Start polls the game log of every running map in the background
+func (c *Collector) Start() {
+	if !c.config.Enabled {
+		return
+	}
+	log.Printf("Collecting game logs every %d seconds, keeping %d days", c.config.IntervalSeconds, c.config.RetentionDays)
+	go func() {
+		lastPrune := time.Time{}
+		for {
+			for _, ms := range c.pm.States() {
+				if !ms.Ready() {
+					continue
+				}
+				if _, err := c.Poll(ms.Map); err != nil {
+					// The server may have stopped since
+					continue
+				}
+			}
+			if time.Since(lastPrune) >= pruneInterval {
+				lastPrune = time.Now()
+				c.prune()
+			}
+			time.Sleep(time.Duration(c.config.IntervalSeconds) * time.Second)
+		}
+	}()
+}
+
+// Poll fetches the lines logged on a map since the last poll and stores
+// them
+func (c *Collector) Poll(mapName string) ([]Event, error) {
+	entries, err := rcon.GetGameLog(mapName, pollTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(entries))
+	now := time.Now()
+	for _, entry := range entries {
+		event := Classify(entry)
+		event.Map = mapName
+		if event.Time.IsZero() {
+			event.Time = now
+		}
+		event.ID = c.key(event)
+		if err := state.Put(bucketGameLog, event.ID, event); err != nil {
+			log.Printf("Failed to store game log event of %s: %v", mapName, err)
+			continue
+		}
+		notify.Stream(notify.EventGameLog, fmt.Sprintf("%s: %s", mapName, event.Message), map[string]interface{}{"map": mapName, "event": event})
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// key orders a map's events by time, the sequence keeps events of the
+// same instant apart
+func (c *Collector) key(event Event) string {
+	c.mu.Lock()
+	c.seq = (c.seq + 1) % 1000000
+	seq := c.seq
+	c.mu.Unlock()
+	return fmt.Sprintf("%s/%s/%06d", event.Map, event.Time.UTC().Format("20060102T150405.000000000"), seq)
+}
+
+// Classify turns a game log entry into an event, without its map
+func Classify(entry rcon.GameLogEntry) Event {
+	event := Event{Time: entry.Time, Type: TypeOther, Tribe: entry.Tribe, TribeID: entry.TribeID, Message: entry.Message}
+	switch {
+	case adminPattern.MatchString(entry.Message):
+		event.Type = TypeAdmin
+		event.Player = adminPattern.FindStringSubmatch(entry.Message)[2]
+	case joinPattern.MatchString(entry.Message):
+		event.Type = TypeJoin
+		event.Player = joinPattern.FindStringSubmatch(entry.Message)[1]
+	case leavePattern.MatchString(entry.Message):
+		event.Type = TypeLeave
+		event.Player = leavePattern.FindStringSubmatch(entry.Message)[1]
+	case deathPattern.MatchString(entry.Message):
+		event.Type = TypeDeath
+		event.Player = levelSuffix.ReplaceAllString(deathPattern.FindStringSubmatch(entry.Message)[1], "")
+	case entry.Tribe != "":
+		event.Type = TypeTribe
+	}
+	return event
+}
+
+// Search returns the stored events matching the filter, newest first
+func Search(filter Filter) ([]Event, error) {
+	limit := filter.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, maxLimit)
+	player := strings.ToLower(filter.Player)
+
+	var events []Event
+	err := state.ForEach(bucketGameLog, func(key string, value []byte) error {
+		if filter.Map != "" && !strings.HasPrefix(key, filter.Map+"/") {
+			return nil
+		}
+		var event Event
+		if err := json.Unmarshal(value, &event); err != nil {
+			return nil
+		}
+		if filter.Type != "" && event.Type != filter.Type {
+			return nil
+		}
+		if !filter.Since.IsZero() && event.Time.Before(filter.Since) {
+			return nil
+		}
+		if !filter.Until.IsZero() && event.Time.After(filter.Until) {
+			return nil
+		}
+		if player != "" && !strings.Contains(strings.ToLower(event.Player), player) && !strings.Contains(strings.ToLower(event.Message), player) {
+			return nil
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+	if limit != NoLimit && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// prune drops the events past the retention and the oldest events of maps
+// with more than MaxEvents
+func (c *Collector) prune() {
+	cutoff := time.Now().AddDate(0, 0, -c.config.RetentionDays)
+	byMap := make(map[string][]Event)
+	var expired []string
+	err := state.ForEach(bucketGameLog, func(key string, value []byte) error {
+		var event Event
+		if err := json.Unmarshal(value, &event); err != nil {
+			expired = append(expired, key)
+			return nil
+		}
+		if event.Time.Before(cutoff) {
+			expired = append(expired, key)
+			return nil
+		}
+		byMap[event.Map] = append(byMap[event.Map], event)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read game log for pruning: %v", err)
+		return
+	}
+	for _, events := range byMap {
+		if len(events) <= c.config.MaxEvents {
+			continue
+		}
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+		for _, event := range events[:len(events)-c.config.MaxEvents] {
+			expired = append(expired, event.ID)
+		}
+	}
+
+	for _, key := range expired {
+		if err := state.Delete(bucketGameLog, key); err != nil {
+			log.Printf("Failed to remove game log event %s: %v", key, err)
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("Pruned %d game log event(s)", len(expired))
+	}
+}
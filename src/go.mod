@@ -4,5 +4,25 @@ go 1.22.5
 
 require (
 	github.com/gorcon/rcon v1.3.5
+	github.com/klauspost/compress v1.17.9
+	github.com/pkg/sftp v1.13.6
+	github.com/shirou/gopsutil/v3 v3.24.5
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/crypto v0.32.0
+	golang.org/x/sys v0.29.0
 	golang.org/x/time v0.6.0
+	google.golang.org/grpc v1.71.1
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/go-ole/go-ole v1.2.6 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/tklauser/go-sysconf v0.3.12 // indirect
+	github.com/tklauser/numcpus v0.6.1 // indirect
+	github.com/yusufpapurcu/wmi v1.2.4 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
 )
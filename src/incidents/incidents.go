@@ -0,0 +1,476 @@
+// Package incidents collects a diagnostic bundle each time a server
+// crashes: the end of its console output, the logs ASA writes to
+// Saved/Logs and the crash dumps in Saved/Crashes, in one zip per incident.
+// The bundles are kept per map with their own retention, to attach to bug
+// reports for Wildcard or mod authors.
+package incidents
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+)
+
+// Triggers of an incident
+const (
+	TriggerCrash      = "crash"
+	TriggerLogPattern = "log_pattern"
+)
+
+const (
+	defaultDir       = "./data/incidents"
+	defaultStdoutKB  = 512
+	defaultMaxFileMB = 100
+	defaultKeep      = 20
+
+	// fatalWait is how long an incident of a log pattern waits for the
+	// server to exit, so the dump it writes is in the bundle
+	fatalWait = time.Minute
+	// lookback is how long before an incident the logs and dumps that are
+	// collected may have been written
+	lookback = time.Hour
+
+	idLayout     = "2006-01-02_15-04-05"
+	bundleSuffix = ".zip"
+	manifestName = "incident.json"
+)
+
+var ErrIncidentNotFound = errors.New("incident not found")
+
+// Config controls the collection, a missing file collects with the
+// defaults
+type Config struct {
+	// Enabled turns the collection off when false
+	Enabled *bool `json:"enabled,omitempty"`
+	// Dir keeps the bundles, in a directory per map
+	Dir string `json:"dir,omitempty"`
+	// StdoutKB is how much of the end of the console output a bundle
+	// holds, default 512, at most 1024
+	StdoutKB int `json:"stdout_kb,omitempty"`
+	// MaxFileMB leaves out larger logs and dumps, default 100
+	MaxFileMB int `json:"max_file_mb,omitempty"`
+	// Keep is how many bundles are kept per map, default 20
+	Keep int `json:"keep,omitempty"`
+	// LogPatterns are the log alert patterns that mark a crash, e.g. a
+	// fatal error the server hangs on instead of exiting
+	LogPatterns []string `json:"log_patterns,omitempty"`
+}
+
+// Incident is a collected bundle. Skipped lists the files that were left
+// out and why.
+type Incident struct {
+	ID      string    `json:"id"`
+	Map     string    `json:"map"`
+	Time    time.Time `json:"time"`
+	Trigger string    `json:"trigger"`
+	Reason  string    `json:"reason"`
+	Files   []string  `json:"files"`
+	Skipped []string  `json:"skipped,omitempty"`
+	Size    int64     `json:"size"`
+}
+
+// pending is an incident of a log pattern waiting for the server to exit
+type pending struct {
+	reason string
+	timer  *time.Timer
+}
+
+type Collector struct {
+	config Config
+	pm     *processmanager.ProcessManager
+
+	// collectMu serializes the collections, mu guards pending
+	collectMu sync.Mutex
+	mu        sync.Mutex
+	pending   map[string]*pending
+}
+
+func NewCollector(configFile string, pm *processmanager.ProcessManager) (*Collector, error) {
+	var config Config
+	if err := configfile.Read(configFile, &config); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read incidents config: %w", err)
+	}
+	if config.Dir == "" {
+		config.Dir = defaultDir
+	}
+	if config.StdoutKB < 0 || config.StdoutKB > processmanager.MaxLogReadBytes/1024 {
+		return nil, fmt.Errorf("incidents config: stdout_kb must be between 1 and %d", processmanager.MaxLogReadBytes/1024)
+	}
+	if config.StdoutKB == 0 {
+		config.StdoutKB = defaultStdoutKB
+	}
+	if config.MaxFileMB < 0 || config.Keep < 0 {
+		return nil, fmt.Errorf("incidents config: max_file_mb and keep must not be negative")
+	}
+	if config.MaxFileMB == 0 {
+		config.MaxFileMB = defaultMaxFileMB
+	}
+	if config.Keep == 0 {
+		config.Keep = defaultKeep
+	}
+	return &Collector{config: config, pm: pm, pending: make(map[string]*pending)}, nil
+}
+
+// Enabled reports whether incidents are collected
+func (c *Collector) Enabled() bool {
+	return c.config.Enabled == nil || *c.config.Enabled
+}
+
+// LogPatterns returns the log alert patterns that mark a crash
+func (c *Collector) LogPatterns() []string {
+	return c.config.LogPatterns
+}
+
+// Start collects an incident each time a server crashes
+func (c *Collector) Start() {
+	if !c.Enabled() {
+		return
+	}
+	events, _ := notify.Subscribe([]string{notify.EventProcessCrashed}, 16)
+	go func() {
+		for payload := range events {
+			mapName, _ := payload.Data["map"].(string)
+			reason, _ := payload.Data["reason"].(string)
+			if mapName != "" {
+				go c.crashed(mapName, reason, payload.Time)
+			}
+		}
+	}()
+}
+
+// crashed collects the incident of a crash, together with the incident of
+// a log pattern that is waiting for it
+func (c *Collector) crashed(mapName string, reason string, at time.Time) {
+	c.mu.Lock()
+	if p, ok := c.pending[mapName]; ok && p.timer.Stop() {
+		delete(c.pending, mapName)
+		reason = fmt.Sprintf("%s, after %s", reason, p.reason)
+	}
+	c.mu.Unlock()
+
+	if _, err := c.Collect(mapName, TriggerCrash, reason, at); err != nil {
+		log.Printf("Failed to collect incident of map '%s': %v", mapName, err)
+	}
+}
+
+// LogAlert starts an incident when one of the log patterns that mark a
+// crash matched. It is collected when the server exits, or after a minute
+// if it does not.
+func (c *Collector) LogAlert(pattern string, mapName string, line string) {
+	if !c.Enabled() || !contains(c.config.LogPatterns, pattern) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pending[mapName]; ok {
+		return
+	}
+	p := &pending{reason: fmt.Sprintf("log pattern %s matched: %s", pattern, line)}
+	p.timer = time.AfterFunc(fatalWait, func() {
+		c.mu.Lock()
+		if c.pending[mapName] == p {
+			delete(c.pending, mapName)
+		}
+		c.mu.Unlock()
+		if _, err := c.Collect(mapName, TriggerLogPattern, p.reason, time.Now()); err != nil {
+			log.Printf("Failed to collect incident of map '%s': %v", mapName, err)
+		}
+	})
+	c.pending[mapName] = p
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Collect writes the bundle of an incident of a map at the given time
+func (c *Collector) Collect(mapName string, trigger string, reason string, at time.Time) (Incident, error) {
+	config, ok := c.pm.Config(mapName)
+	if !ok {
+		return Incident{}, fmt.Errorf("%w: %s", processmanager.ErrMapNotFound, mapName)
+	}
+
+	c.collectMu.Lock()
+	defer c.collectMu.Unlock()
+
+	dir := filepath.Join(c.config.Dir, mapName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Incident{}, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	incident := Incident{ID: at.Format(idLayout), Map: mapName, Time: at, Trigger: trigger, Reason: reason, Files: []string{}}
+	path := filepath.Join(dir, incident.ID+bundleSuffix)
+	if err := c.write(path, &incident, config); err != nil {
+		os.Remove(path)
+		return Incident{}, err
+	}
+	if info, err := os.Stat(path); err == nil {
+		incident.Size = info.Size()
+	}
+	log.Printf("Collected incident %s of map '%s' with %d files (%d bytes): %s", incident.ID, mapName, len(incident.Files), incident.Size, reason)
+
+	c.prune(mapName)
+
+	notify.Publish(notify.EventIncident, fmt.Sprintf("collected incident %s of map %s: %s", incident.ID, mapName, reason), map[string]interface{}{
+		"map": mapName, "id": incident.ID, "trigger": trigger, "reason": reason,
+	})
+	return incident, nil
+}
+
+// write creates the bundle at path and fills the files of the incident
+func (c *Collector) write(path string, incident *Incident, config processmanager.ProcessConfig) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+	zw := zip.NewWriter(file)
+
+	if stdout, err := c.consoleTail(incident.Map, incident.Time); err != nil {
+		incident.Skipped = append(incident.Skipped, "stdout.log: "+err.Error())
+	} else {
+		w, err := zw.Create("stdout.log")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, stdout); err != nil {
+			return err
+		}
+		incident.Files = append(incident.Files, "stdout.log")
+	}
+
+	saved := filepath.Join(config.InstallDir(), "ShooterGame", "Saved")
+	since := incident.Time.Add(-lookback)
+	for _, sub := range []string{"Logs", "Crashes"} {
+		root := filepath.Join(saved, sub)
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil || info.ModTime().Before(since) {
+				return nil
+			}
+			rel, err := filepath.Rel(saved, path)
+			if err != nil {
+				return nil
+			}
+			name := "Saved/" + filepath.ToSlash(rel)
+			if info.Size() > int64(c.config.MaxFileMB)<<20 {
+				incident.Skipped = append(incident.Skipped, fmt.Sprintf("%s: %d MB is over max_file_mb", name, info.Size()>>20))
+				return nil
+			}
+			if err := addFile(zw, name, path); err != nil {
+				incident.Skipped = append(incident.Skipped, fmt.Sprintf("%s: %v", name, err))
+				return nil
+			}
+			incident.Files = append(incident.Files, name)
+			return nil
+		})
+		if err != nil {
+			incident.Skipped = append(incident.Skipped, fmt.Sprintf("Saved/%s: %v", sub, err))
+		}
+	}
+
+	w, err := zw.Create(manifestName)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(incident); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// consoleTail returns the end of the console output of the run that ended
+// at t. The log may have been rotated by a restart since.
+func (c *Collector) consoleTail(mapName string, t time.Time) (string, error) {
+	name, err := processmanager.FindLog(mapName, t)
+	if errors.Is(err, processmanager.ErrLogNotFound) {
+		index, indexErr := processmanager.GetLogIndex(mapName)
+		if indexErr != nil {
+			return "", indexErr
+		}
+		if index.CurrentStart.IsZero() {
+			// The start of the current log was not recorded
+			name, err = "", nil
+		}
+		for _, segment := range index.Segments {
+			if segment.Start.Before(t) {
+				name, err = segment.File, nil
+			}
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	output, _, err := processmanager.RetrieveLogs(mapName, name)
+	if err != nil {
+		return "", err
+	}
+	if max := c.config.StdoutKB * 1024; len(output) > max {
+		output = output[len(output)-max:]
+		// Start at a line boundary
+		if i := strings.IndexByte(output, '\n'); i >= 0 {
+			output = output[i+1:]
+		}
+	}
+	return output, nil
+}
+
+func addFile(zw *zip.Writer, name string, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// prune deletes the oldest bundles of a map beyond Keep
+func (c *Collector) prune(mapName string) {
+	incidents, err := c.list(mapName)
+	if err != nil {
+		log.Printf("Failed to list incidents of map '%s': %v", mapName, err)
+		return
+	}
+	for i := c.config.Keep; i < len(incidents); i++ {
+		path := filepath.Join(c.config.Dir, mapName, incidents[i].ID+bundleSuffix)
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove incident %s of map '%s': %v", incidents[i].ID, mapName, err)
+		}
+	}
+}
+
+// List returns the incidents of a map, or of every map if mapName is
+// empty, newest first
+func (c *Collector) List(mapName string) ([]Incident, error) {
+	if mapName != "" {
+		return c.list(mapName)
+	}
+	entries, err := os.ReadDir(c.config.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Incident{}, nil
+		}
+		return nil, err
+	}
+	incidents := []Incident{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		found, err := c.list(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, found...)
+	}
+	sort.Slice(incidents, func(i, j int) bool { return incidents[i].Time.After(incidents[j].Time) })
+	return incidents, nil
+}
+
+// list returns the incidents of a map from the manifests of its bundles
+func (c *Collector) list(mapName string) ([]Incident, error) {
+	dir := filepath.Join(c.config.Dir, mapName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Incident{}, nil
+		}
+		return nil, err
+	}
+	incidents := []Incident{}
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), bundleSuffix)
+		if entry.IsDir() || !validID(id) || entry.Name() != id+bundleSuffix {
+			continue
+		}
+		incident, err := readManifest(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Printf("Failed to read incident %s of map '%s': %v", id, mapName, err)
+			continue
+		}
+		incident.ID, incident.Map = id, mapName
+		if info, err := entry.Info(); err == nil {
+			incident.Size = info.Size()
+		}
+		incidents = append(incidents, incident)
+	}
+	sort.Slice(incidents, func(i, j int) bool { return incidents[i].Time.After(incidents[j].Time) })
+	return incidents, nil
+}
+
+func validID(id string) bool {
+	t, err := time.ParseInLocation(idLayout, id, time.Local)
+	return err == nil && t.Format(idLayout) == id
+}
+
+func readManifest(path string) (Incident, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return Incident{}, err
+	}
+	defer zr.Close()
+
+	f, err := zr.Open(manifestName)
+	if err != nil {
+		return Incident{}, err
+	}
+	defer f.Close()
+	var incident Incident
+	if err := json.NewDecoder(f).Decode(&incident); err != nil {
+		return Incident{}, fmt.Errorf("invalid %s: %w", manifestName, err)
+	}
+	return incident, nil
+}
+
+// Open returns the path of an incident's bundle
+func (c *Collector) Open(mapName string, id string) (string, error) {
+	if !validID(id) {
+		return "", fmt.Errorf("%w: %s", ErrIncidentNotFound, id)
+	}
+	path := filepath.Join(c.config.Dir, mapName, id+bundleSuffix)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%w: %s of map %s", ErrIncidentNotFound, id, mapName)
+		}
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,217 @@
+// Package ini reads and edits ARK's GameUserSettings.ini and Game.ini while
+// keeping comments, ordering, duplicate keys and line endings intact.
+package ini
+
+import (
+	"bytes"
+	"strings"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Line is one line of a section. Lines that are not Key=Value pairs, such
+// as comments and blank lines, only have Raw set. Entries keep Raw until
+// they are modified so untouched lines are written back byte for byte.
+type Line struct {
+	Key   string
+	Value string
+	Raw   string
+	Entry bool
+}
+
+// Section is a bracketed section such as [/Script/ShooterGame.ShooterGameMode].
+// Lines before the first header belong to a section with an empty name.
+type Section struct {
+	Name  string
+	Lines []Line
+}
+
+type File struct {
+	Sections []*Section
+	bom      bool
+	newline  string
+}
+
+// Entry is a key and value of a section
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Parse reads an INI file. Parsing never fails, unrecognised lines are
+// kept verbatim.
+func Parse(data []byte) *File {
+	f := &File{newline: "\n"}
+	if bytes.HasPrefix(data, utf8BOM) {
+		f.bom = true
+		data = data[len(utf8BOM):]
+	}
+	text := string(data)
+	if strings.Contains(text, "\r\n") {
+		f.newline = "\r\n"
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+
+	current := &Section{}
+	f.Sections = append(f.Sections, current)
+	if text == "" {
+		return f
+	}
+
+	for _, raw := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(raw)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			current = &Section{Name: trimmed[1 : len(trimmed)-1]}
+			f.Sections = append(f.Sections, current)
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#") {
+			current.Lines = append(current.Lines, Line{Raw: raw})
+			continue
+		}
+		key, value, ok := strings.Cut(raw, "=")
+		if !ok {
+			current.Lines = append(current.Lines, Line{Raw: raw})
+			continue
+		}
+		current.Lines = append(current.Lines, Line{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value), Raw: raw, Entry: true})
+	}
+	return f
+}
+
+// Bytes renders the file with its original BOM and line endings
+func (f *File) Bytes() []byte {
+	var buf bytes.Buffer
+	if f.bom {
+		buf.Write(utf8BOM)
+	}
+	for i, section := range f.Sections {
+		if section.Name != "" || i > 0 {
+			buf.WriteString("[" + section.Name + "]" + f.newline)
+		}
+		for _, line := range section.Lines {
+			if line.Entry && line.Raw == "" {
+				buf.WriteString(line.Key + "=" + line.Value)
+			} else {
+				buf.WriteString(line.Raw)
+			}
+			buf.WriteString(f.newline)
+		}
+	}
+	return buf.Bytes()
+}
+
+// Section returns a section by name, names are case-insensitive like in
+// Unreal Engine
+func (f *File) Section(name string) *Section {
+	for _, section := range f.Sections {
+		if strings.EqualFold(section.Name, name) {
+			return section
+		}
+	}
+	return nil
+}
+
+func (f *File) ensureSection(name string) *Section {
+	if section := f.Section(name); section != nil {
+		return section
+	}
+	// Keep a blank line between the previous section and the new header
+	if last := f.Sections[len(f.Sections)-1]; len(last.Lines) > 0 {
+		if end := last.Lines[len(last.Lines)-1]; end.Entry || strings.TrimSpace(end.Raw) != "" {
+			last.Lines = append(last.Lines, Line{})
+		}
+	}
+	section := &Section{Name: name}
+	f.Sections = append(f.Sections, section)
+	return section
+}
+
+// Entries returns the key/value pairs of a section in file order
+func (s *Section) Entries() []Entry {
+	entries := make([]Entry, 0, len(s.Lines))
+	for _, line := range s.Lines {
+		if line.Entry {
+			entries = append(entries, Entry{Key: line.Key, Value: line.Value})
+		}
+	}
+	return entries
+}
+
+// Get returns every value of a key, keys may repeat in ARK's files
+func (f *File) Get(section string, key string) []string {
+	s := f.Section(section)
+	if s == nil {
+		return nil
+	}
+	var values []string
+	for _, line := range s.Lines {
+		if line.Entry && strings.EqualFold(line.Key, key) {
+			values = append(values, line.Value)
+		}
+	}
+	return values
+}
+
+// Set replaces the first occurrence of a key and drops any duplicates, or
+// appends the key when the section does not have it yet
+func (f *File) Set(section string, key string, value string) {
+	s := f.ensureSection(section)
+	found := false
+	kept := s.Lines[:0]
+	for _, line := range s.Lines {
+		if line.Entry && strings.EqualFold(line.Key, key) {
+			if found {
+				continue
+			}
+			found = true
+			if line.Value != value {
+				line.Value = value
+				line.Raw = ""
+			}
+		}
+		kept = append(kept, line)
+	}
+	s.Lines = kept
+	if !found {
+		s.insert(Line{Key: key, Value: value, Entry: true})
+	}
+}
+
+// Add appends another occurrence of a key, used for list settings such as
+// ConfigOverrideItemMaxQuantity
+func (f *File) Add(section string, key string, value string) {
+	f.ensureSection(section).insert(Line{Key: key, Value: value, Entry: true})
+}
+
+// Delete removes every occurrence of a key and reports whether there was one
+func (f *File) Delete(section string, key string) bool {
+	s := f.Section(section)
+	if s == nil {
+		return false
+	}
+	deleted := false
+	kept := s.Lines[:0]
+	for _, line := range s.Lines {
+		if line.Entry && strings.EqualFold(line.Key, key) {
+			deleted = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	s.Lines = kept
+	return deleted
+}
+
+// insert adds a line after the section's last entry so trailing blank
+// lines keep separating it from the next section
+func (s *Section) insert(line Line) {
+	at := len(s.Lines)
+	for at > 0 && !s.Lines[at-1].Entry && strings.TrimSpace(s.Lines[at-1].Raw) == "" {
+		at--
+	}
+	s.Lines = append(s.Lines, Line{})
+	copy(s.Lines[at+1:], s.Lines[at:])
+	s.Lines[at] = line
+}
@@ -0,0 +1,121 @@
+package ini
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	GameUserSettings = "GameUserSettings.ini"
+	Game             = "Game.ini"
+
+	sectionServerSettings = "ServerSettings"
+	sectionSession        = "SessionSettings"
+	sectionGameSession    = "/Script/Engine.GameSession"
+	sectionGameMode       = "/Script/ShooterGame.ShooterGameMode"
+)
+
+// Kind is the value type of a known setting
+type Kind string
+
+const (
+	KindBool   Kind = "bool"
+	KindInt    Kind = "int"
+	KindFloat  Kind = "float"
+	KindString Kind = "string"
+)
+
+type keyRef struct {
+	file, section, key string
+}
+
+// knownKeys lists commonly used settings and their types. Keys that are not
+// listed can still be written, they are only not type checked.
+var knownKeys = map[keyRef]Kind{}
+
+func init() {
+	register := func(file, section string, kind Kind, keys ...string) {
+		for _, key := range keys {
+			knownKeys[keyRef{file, strings.ToLower(section), strings.ToLower(key)}] = kind
+		}
+	}
+
+	register(GameUserSettings, sectionServerSettings, KindString,
+		"ServerPassword", "ServerAdminPassword", "SpectatorPassword", "ActiveMods", "ActiveMapMod")
+	register(GameUserSettings, sectionServerSettings, KindInt,
+		"RCONPort", "MaxPlayers", "RCONServerGameLogBuffer", "KickIdlePlayersPeriod", "MaxTamedDinos")
+	register(GameUserSettings, sectionServerSettings, KindBool,
+		"RCONEnabled", "ServerPVE", "ServerHardcore", "ServerCrosshair", "ShowMapPlayerLocation",
+		"AllowThirdPersonPlayer", "AlwaysNotifyPlayerJoined", "AlwaysNotifyPlayerLeft", "NoTributeDownloads",
+		"AllowFlyerCarryPvE", "DisableStructureDecayPvE", "DisableDinoDecayPvE", "EnablePvPGamma",
+		"AllowCaveBuildingPvE", "PreventDownloadSurvivors", "PreventDownloadItems", "PreventDownloadDinos",
+		"PreventUploadSurvivors", "PreventUploadItems", "PreventUploadDinos", "ServerForceNoHUD")
+	register(GameUserSettings, sectionServerSettings, KindFloat,
+		"DifficultyOffset", "OverrideOfficialDifficulty", "XPMultiplier", "TamingSpeedMultiplier",
+		"HarvestAmountMultiplier", "HarvestHealthMultiplier", "ResourcesRespawnPeriodMultiplier",
+		"DayCycleSpeedScale", "DayTimeSpeedScale", "NightTimeSpeedScale", "DinoDamageMultiplier",
+		"PlayerDamageMultiplier", "StructureDamageMultiplier", "PlayerResistanceMultiplier",
+		"DinoResistanceMultiplier", "StructureResistanceMultiplier", "PlayerCharacterFoodDrainMultiplier",
+		"PlayerCharacterWaterDrainMultiplier", "PlayerCharacterStaminaDrainMultiplier",
+		"DinoCharacterFoodDrainMultiplier", "PvEStructureDecayPeriodMultiplier", "AutoSavePeriodMinutes")
+	register(GameUserSettings, sectionSession, KindString, "SessionName")
+	register(GameUserSettings, sectionSession, KindInt, "Port", "QueryPort")
+	register(GameUserSettings, sectionGameSession, KindInt, "MaxPlayers")
+
+	register(Game, sectionGameMode, KindBool,
+		"bUseSingleplayerSettings", "bDisableStructurePlacementCollision", "bAllowFlyerSpeedLeveling",
+		"bAllowUnlimitedRespecs", "bAllowPlatformSaddleMultiFloors", "bDisableFriendlyFire",
+		"bPvEDisableFriendlyFire", "bAutoPvETimer", "bIncreasePvPRespawnInterval", "bDisableLootCrates")
+	register(Game, sectionGameMode, KindInt, "MaxNumberOfPlayersInTribe", "MaxTribeLogs")
+	register(Game, sectionGameMode, KindFloat,
+		"MatingIntervalMultiplier", "MatingSpeedMultiplier", "EggHatchSpeedMultiplier",
+		"BabyMatureSpeedMultiplier", "BabyFoodConsumptionSpeedMultiplier", "BabyCuddleIntervalMultiplier",
+		"BabyImprintAmountMultiplier", "BabyImprintingStatScaleMultiplier", "CropGrowthSpeedMultiplier",
+		"LayEggIntervalMultiplier", "PoopIntervalMultiplier", "HairGrowthSpeedMultiplier",
+		"CraftXPMultiplier", "GenericXPMultiplier", "HarvestXPMultiplier", "KillXPMultiplier",
+		"SpecialXPMultiplier", "FuelConsumptionIntervalMultiplier", "GlobalSpoilingTimeMultiplier",
+		"GlobalItemDecompositionTimeMultiplier", "GlobalCorpseDecompositionTimeMultiplier",
+		"SupplyCrateLootQualityMultiplier", "FishingLootQualityMultiplier")
+}
+
+// IsSecret reports whether a setting holds a password that should not be
+// returned by the API
+func IsSecret(key string) bool {
+	return strings.HasSuffix(strings.ToLower(key), "password")
+}
+
+// IsEditable reports whether a file name is one of the INI files the API
+// may edit
+func IsEditable(file string) bool {
+	return file == GameUserSettings || file == Game
+}
+
+// Validate checks the value of a known setting against its type. Unknown
+// settings are accepted and reported as unknown.
+func Validate(file, section, key, value string) (known bool, err error) {
+	kind, known := knownKeys[keyRef{file, strings.ToLower(section), strings.ToLower(key)}]
+	if !known {
+		return false, nil
+	}
+
+	switch kind {
+	case KindBool:
+		if !strings.EqualFold(value, "true") && !strings.EqualFold(value, "false") {
+			return true, fmt.Errorf("%s must be True or False", key)
+		}
+	case KindInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return true, fmt.Errorf("%s must be an integer", key)
+		}
+	case KindFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return true, fmt.Errorf("%s must be a number", key)
+		}
+	case KindString:
+		if strings.ContainsAny(value, "\r\n") {
+			return true, fmt.Errorf("%s must not contain line breaks", key)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,413 @@
+// Package inidrift notices changes of the maps' GameUserSettings.ini and
+// Game.ini made outside the manager. The manager records the content it
+// writes as the known-good baseline of a file, the watcher compares the
+// files with it and reports a Drift with a diff when they differ. A drift is
+// resolved by accepting the file as the new baseline or by writing the
+// baseline or an older backup back.
+//
+// Files are compared without comments, blank lines, trailing whitespace and
+// line endings. The server itself rewrites GameUserSettings.ini when it
+// saves its settings, such changes show up as a drift too.
+package inidrift
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/state"
+)
+
+const (
+	bucketBaselines = "ini_baselines"
+	bucketDrifts    = "ini_drifts"
+
+	defaultIntervalSeconds = 300
+
+	// maxDiffLines bounds the lines compared by the diff, larger files
+	// are shown as removed and added in full
+	maxDiffLines = 4000
+	// diffContext is how many unchanged lines are kept around a change
+	diffContext = 3
+
+	secretMask = "********"
+)
+
+// Baseline sources
+const (
+	// SourceInitial is the content found when the watcher first saw a file
+	SourceInitial = "initial"
+	// SourceManager is content the manager wrote
+	SourceManager = "manager"
+	// SourceAccepted is a drift that was accepted
+	SourceAccepted = "accepted"
+)
+
+// Config enables the watcher, a missing file leaves it off
+type Config struct {
+	Enabled         bool `json:"enabled"`
+	IntervalSeconds int  `json:"interval_seconds"`
+}
+
+// Baseline is the known-good content of a map's INI file
+type Baseline struct {
+	Map     string    `json:"map"`
+	File    string    `json:"file"`
+	Hash    string    `json:"hash"`
+	Content string    `json:"content"`
+	Source  string    `json:"source"`
+	Time    time.Time `json:"time"`
+}
+
+// DiffLine is a line of a diff, Op is " " for unchanged lines, "-" for
+// lines of the baseline, "+" for lines of the current file and "@" for
+// unchanged lines left out
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Drift is a map's INI file that differs from its baseline
+type Drift struct {
+	Map          string     `json:"map"`
+	File         string     `json:"file"`
+	DetectedAt   time.Time  `json:"detected_at"`
+	BaselineHash string     `json:"baseline_hash"`
+	BaselineTime time.Time  `json:"baseline_time"`
+	CurrentHash  string     `json:"current_hash"`
+	Diff         []DiffLine `json:"diff"`
+}
+
+type Watcher struct {
+	config Config
+	pm     *processmanager.ProcessManager
+}
+
+// mu keeps the watcher from comparing a file the manager is writing
+var mu sync.Mutex
+
+func NewWatcher(configFile string, pm *processmanager.ProcessManager) (*Watcher, error) {
+	var config Config
+	if err := configfile.Read(configFile, &config); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read drift config: %w", err)
+	}
+	if config.IntervalSeconds <= 0 {
+		config.IntervalSeconds = defaultIntervalSeconds
+	}
+	return &Watcher{config: config, pm: pm}, nil
+}
+
+// Enabled reports whether the files are watched
+func (w *Watcher) Enabled() bool {
+	return w.config.Enabled
+}
+
+// Start compares the INI files of every map with their baselines in the
+// background
+func (w *Watcher) Start() {
+	if !w.config.Enabled {
+		return
+	}
+	log.Printf("Watching INI files for changes every %d seconds", w.config.IntervalSeconds)
+	go func() {
+		for {
+			for _, ms := range w.pm.States() {
+				if _, err := w.Check(ms.Map); err != nil {
+					log.Printf("Failed to check the INI files of %s: %v", ms.Map, err)
+				}
+			}
+			time.Sleep(time.Duration(w.config.IntervalSeconds) * time.Second)
+		}
+	}()
+}
+
+// Check compares a map's INI files with their baselines now and returns
+// the drifts. Files without a baseline get their content as one.
+func (w *Watcher) Check(mapName string) ([]Drift, error) {
+	config, exists := w.pm.Config(mapName)
+	if !exists {
+		return nil, fmt.Errorf("map %s has no process configuration", mapName)
+	}
+
+	drifts := []Drift{}
+	for _, file := range []string{ini.GameUserSettings, ini.Game} {
+		drift, found, err := check(mapName, file, filepath.Join(config.IniDir(), file))
+		if err != nil {
+			return drifts, err
+		}
+		if found {
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts, nil
+}
+
+func check(mapName string, file string, path string) (Drift, bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Drift{}, false, nil
+	}
+	if err != nil {
+		return Drift{}, false, err
+	}
+
+	key := mapName + "/" + file
+	var baseline Baseline
+	found, err := state.Get(bucketBaselines, key, &baseline)
+	if err != nil {
+		return Drift{}, false, err
+	}
+	if !found {
+		return Drift{}, false, record(mapName, file, data, SourceInitial)
+	}
+
+	hash := Hash(data)
+	var drift Drift
+	known, err := state.Get(bucketDrifts, key, &drift)
+	if err != nil {
+		return Drift{}, false, err
+	}
+	if hash == baseline.Hash {
+		if known {
+			log.Printf("%s of %s matches its baseline again", file, mapName)
+			return Drift{}, false, state.Delete(bucketDrifts, key)
+		}
+		return Drift{}, false, nil
+	}
+	// Each change is reported once
+	if known && drift.CurrentHash == hash && drift.BaselineHash == baseline.Hash {
+		return drift, true, nil
+	}
+
+	drift = Drift{
+		Map:          mapName,
+		File:         file,
+		DetectedAt:   time.Now(),
+		BaselineHash: baseline.Hash,
+		BaselineTime: baseline.Time,
+		CurrentHash:  hash,
+		Diff:         Diff([]byte(baseline.Content), data),
+	}
+	if err := state.Put(bucketDrifts, key, drift); err != nil {
+		return Drift{}, false, err
+	}
+	added, removed := drift.Changes()
+	log.Printf("%s of %s was changed outside the manager: %d line(s) added, %d removed", file, mapName, added, removed)
+	notify.Publish(notify.EventConfigDrift, fmt.Sprintf("%s of map %s was changed outside the manager (%d line(s) added, %d removed)", file, mapName, added, removed),
+		map[string]interface{}{"map": mapName, "file": file, "added": added, "removed": removed})
+	return drift, true, nil
+}
+
+// Changes counts the added and removed lines of a drift
+func (d Drift) Changes() (added, removed int) {
+	for _, line := range d.Diff {
+		switch line.Op {
+		case "+":
+			added++
+		case "-":
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// Write writes an INI file for the manager and records it as the file's
+// baseline
+func Write(mapName string, file string, path string, data []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := configfile.WriteFile(path, data); err != nil {
+		return err
+	}
+	if err := record(mapName, file, data, SourceManager); err != nil {
+		return fmt.Errorf("failed to record the baseline of %s: %w", file, err)
+	}
+	return state.Delete(bucketDrifts, mapName+"/"+file)
+}
+
+// Accept makes the current content of a file its baseline
+func Accept(mapName string, file string, path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := record(mapName, file, data, SourceAccepted); err != nil {
+		return err
+	}
+	return state.Delete(bucketDrifts, mapName+"/"+file)
+}
+
+func record(mapName string, file string, data []byte, source string) error {
+	baseline := Baseline{Map: mapName, File: file, Hash: Hash(data), Content: string(data), Source: source, Time: time.Now()}
+	return state.Put(bucketBaselines, mapName+"/"+file, baseline)
+}
+
+// GetBaseline returns the baseline of a map's file
+func GetBaseline(mapName string, file string) (Baseline, bool, error) {
+	var baseline Baseline
+	found, err := state.Get(bucketBaselines, mapName+"/"+file, &baseline)
+	return baseline, found, err
+}
+
+// Drifts returns the unresolved drifts, of one map if mapName is set
+func Drifts(mapName string) ([]Drift, error) {
+	drifts := []Drift{}
+	err := state.ForEach(bucketDrifts, func(key string, value []byte) error {
+		if mapName != "" && !strings.HasPrefix(key, mapName+"/") {
+			return nil
+		}
+		var drift Drift
+		if err := json.Unmarshal(value, &drift); err != nil {
+			return nil
+		}
+		drifts = append(drifts, drift)
+		return nil
+	})
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].DetectedAt.After(drifts[j].DetectedAt) })
+	return drifts, err
+}
+
+// RemoveMap drops the baselines and drifts of an unregistered map
+func RemoveMap(mapName string) error {
+	for _, bucket := range []string{bucketBaselines, bucketDrifts} {
+		for _, file := range []string{ini.GameUserSettings, ini.Game} {
+			if err := state.Delete(bucket, mapName+"/"+file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lines returns the lines that matter for a comparison
+func lines(data []byte) []string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var result []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// Hash identifies the content of an INI file, see lines
+func Hash(data []byte) string {
+	sum := sha256.Sum256([]byte(strings.Join(lines(data), "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// mask hides the values of password settings
+func mask(line string) string {
+	key, value, ok := strings.Cut(line, "=")
+	if ok && value != "" && ini.IsSecret(strings.TrimSpace(key)) {
+		return key + "=" + secretMask
+	}
+	return line
+}
+
+// Diff compares two INI files line by line
+func Diff(old []byte, current []byte) []DiffLine {
+	a, b := lines(old), lines(current)
+	diff := []DiffLine{}
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		for _, line := range a {
+			diff = append(diff, DiffLine{Op: "-", Text: mask(line)})
+		}
+		for _, line := range b {
+			diff = append(diff, DiffLine{Op: "+", Text: mask(line)})
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, DiffLine{Op: " ", Text: mask(a[i])})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, DiffLine{Op: "-", Text: mask(a[i])})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: "+", Text: mask(b[j])})
+			j++
+		}
+	}
+	return withContext(diff)
+}
+
+// withContext keeps the unchanged lines near a change, each run of
+// dropped lines is replaced by a "@" line counting them
+func withContext(diff []DiffLine) []DiffLine {
+	near := make([]bool, len(diff))
+	for i, line := range diff {
+		if line.Op == " " {
+			continue
+		}
+		for j := max(0, i-diffContext); j <= min(len(diff)-1, i+diffContext); j++ {
+			near[j] = true
+		}
+	}
+
+	result := []DiffLine{}
+	skipped := 0
+	for i, line := range diff {
+		if !near[i] {
+			skipped++
+			continue
+		}
+		if skipped > 0 {
+			result = append(result, DiffLine{Op: "@", Text: fmt.Sprintf("%d unchanged line(s)", skipped)})
+			skipped = 0
+		}
+		result = append(result, line)
+	}
+	if skipped > 0 {
+		result = append(result, DiffLine{Op: "@", Text: fmt.Sprintf("%d unchanged line(s)", skipped)})
+	}
+	return result
+}
@@ -0,0 +1,504 @@
+// Package jobs records the long-running operations of the manager, such as
+// backups, restores, updates and restarts, as jobs with an ID, a state, a
+// log and their timing. Jobs are kept in the state store so their history
+// survives restarts of the manager.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/state"
+	"asa_servermanager_api/tracing"
+)
+
+// States of a job. A job starts pending, runs and ends in one of the
+// other states.
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+	// StateSkipped is a scheduled job that did not run, e.g. because its
+	// schedule is paused
+	StateSkipped = "skipped"
+)
+
+// Types of jobs
+const (
+	TypeBackup  = "backup"
+	TypeRestore = "restore"
+	TypeUpdate  = "update"
+	TypeRestart = "restart"
+	TypeStop    = "stop"
+	// TypeRecovery rolls back the save of a crash-looping map
+	TypeRecovery = "recovery"
+	// TypeMacro runs the steps of a macro
+	TypeMacro = "macro"
+)
+
+const (
+	maxHistory  = 500
+	maxLogLines = 200
+
+	bucketJobs = "jobs"
+)
+
+var (
+	ErrNotFound       = errors.New("job not found")
+	ErrNotCancellable = errors.New("job cannot be cancelled")
+	// ErrCancelled ends a job as cancelled, like a cancelled context does
+	ErrCancelled = errors.New("job cancelled")
+	// ErrSkipped ends a job as skipped
+	ErrSkipped = errors.New("job skipped")
+)
+
+// LogLine is a line of a job's log
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Job is a single pending, running or finished operation
+type Job struct {
+	ID    string   `json:"id"`
+	Type  string   `json:"type"`
+	Maps  []string `json:"maps"`
+	State string   `json:"state"`
+	Error string   `json:"error,omitempty"`
+	// Cancellable reports whether the job can be cancelled in its current
+	// state
+	Cancellable bool      `json:"cancellable"`
+	Logs        []LogLine `json:"logs"`
+	// Result is the outcome reported by the job's type, if any
+	Result interface{} `json:"result,omitempty"`
+	// Backups are the archives taken for the job, to roll back to
+	Backups []string `json:"backups,omitempty"`
+
+	Created         time.Time `json:"created"`
+	Started         time.Time `json:"started,omitempty"`
+	Finished        time.Time `json:"finished,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds,omitempty"`
+}
+
+func (j Job) done() bool {
+	return j.State != StatePending && j.State != StateRunning
+}
+
+func (j Job) hasMap(mapName string) bool {
+	for _, m := range j.Maps {
+		if m == mapName {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle is held by the code running a job to report its state
+type Handle struct {
+	job    *Job
+	ctx    context.Context
+	cancel context.CancelFunc
+	// onCancel is called when the job is cancelled, before its context is
+	// cancelled. It is nil while the job cannot be cancelled.
+	onCancel func() error
+	// span traces the job from its creation, its log lines are its events
+	span *tracing.Span
+}
+
+// Filter selects jobs by map, type and state, empty fields match every job
+type Filter struct {
+	Map   string
+	Type  string
+	State string
+}
+
+func (f Filter) matches(job Job) bool {
+	return (f.Map == "" || job.hasMap(f.Map)) &&
+		(f.Type == "" || job.Type == f.Type) &&
+		(f.State == "" || job.State == f.State)
+}
+
+var (
+	mu sync.Mutex
+	// active are the jobs that have not finished yet
+	active = make(map[string]*Handle)
+	// recent are the jobs of this run, newest last, served if the state
+	// store is unavailable
+	recent []*Job
+)
+
+// New records a pending job of the maps and returns its handle. A job
+// cannot be cancelled until the caller says how with SetCancel.
+func New(jobType string, maps ...string) *Handle {
+	mu.Lock()
+	defer mu.Unlock()
+
+	name := jobType
+	if len(maps) == 1 {
+		name = maps[0]
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, span := tracing.Start(ctx, "job "+jobType, tracing.KindInternal)
+	h := &Handle{
+		job: &Job{
+			ID:      fmt.Sprintf("%s-%s", name, strconv.FormatInt(time.Now().UnixNano(), 36)),
+			Type:    jobType,
+			Maps:    append([]string{}, maps...),
+			State:   StatePending,
+			Logs:    []LogLine{},
+			Created: time.Now(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		span:   span,
+	}
+	span.SetAttribute("job.id", h.job.ID)
+	span.SetAttribute("job.type", jobType)
+	span.SetAttribute("job.maps", h.job.Maps)
+	active[h.job.ID] = h
+	recent = append(recent, h.job)
+	if len(recent) > maxHistory {
+		recent = recent[len(recent)-maxHistory:]
+	}
+	persistLocked(h.job)
+	return h
+}
+
+// Run records a job of the maps and runs fn as it. The job succeeds or
+// fails with the error fn returns, which is returned as well.
+func Run(jobType string, maps []string, fn func(h *Handle) error) error {
+	h := New(jobType, maps...)
+	h.Start()
+	err := fn(h)
+	h.Finish(err)
+	return err
+}
+
+// ID returns the job's ID
+func (h *Handle) ID() string {
+	return h.job.ID
+}
+
+// Context is cancelled when the job is cancelled
+func (h *Handle) Context() context.Context {
+	return h.ctx
+}
+
+// Job returns a copy of the job
+func (h *Handle) Job() Job {
+	mu.Lock()
+	defer mu.Unlock()
+	return copyJob(h.job)
+}
+
+// SetCancel makes the job cancellable. Cancelling calls fn, if it is not
+// nil, and cancels the job's context unless fn fails.
+func (h *Handle) SetCancel(fn func() error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if fn == nil {
+		fn = func() error { return nil }
+	}
+	h.onCancel = fn
+	h.job.Cancellable = !h.job.done()
+	persistLocked(h.job)
+}
+
+// NoCancel makes the job no longer cancellable, for example once it reached
+// a step that must not be interrupted
+func (h *Handle) NoCancel() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if h.onCancel == nil {
+		return
+	}
+	h.onCancel = nil
+	h.job.Cancellable = false
+	persistLocked(h.job)
+}
+
+// Start moves the job from pending to running
+func (h *Handle) Start() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if h.job.State != StatePending {
+		return
+	}
+	h.job.State = StateRunning
+	h.job.Started = time.Now()
+	h.span.AddEvent("started")
+	persistLocked(h.job)
+}
+
+// Begin makes the job no longer cancellable and starts it, unless it has
+// been cancelled already. Jobs that can be cancelled while they wait, for
+// example for a map's lock, call it once they stop waiting.
+func (h *Handle) Begin() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if h.job.State == StateCancelled || h.ctx.Err() != nil {
+		return ErrCancelled
+	}
+	h.onCancel = nil
+	h.job.Cancellable = false
+	if h.job.State == StatePending {
+		h.job.State = StateRunning
+		h.job.Started = time.Now()
+		h.span.AddEvent("started")
+	}
+	persistLocked(h.job)
+	return nil
+}
+
+// Logf adds a line to the job's log
+func (h *Handle) Logf(format string, args ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	message := fmt.Sprintf(format, args...)
+	h.job.Logs = append(h.job.Logs, LogLine{Time: time.Now(), Message: message})
+	h.span.AddEvent(message)
+	if len(h.job.Logs) > maxLogLines {
+		h.job.Logs = h.job.Logs[len(h.job.Logs)-maxLogLines:]
+	}
+	persistLocked(h.job)
+}
+
+// AddBackup links an archive taken for the job to it
+func (h *Handle) AddBackup(archive string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	h.job.Backups = append(h.job.Backups, archive)
+	persistLocked(h.job)
+}
+
+// SetResult records the job's outcome
+func (h *Handle) SetResult(result interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	h.job.Result = result
+	persistLocked(h.job)
+}
+
+// Finish ends the job. It succeeds if err is nil, is cancelled if err is a
+// cancellation, is skipped if err is ErrSkipped and fails otherwise. Finishing a finished job does nothing.
+func (h *Handle) Finish(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	h.finishLocked(err)
+}
+
+func (h *Handle) finishLocked(err error) {
+	if h.job.done() {
+		return
+	}
+	switch {
+	case err == nil:
+		h.job.State = StateSucceeded
+	case errors.Is(err, ErrCancelled) || errors.Is(err, context.Canceled):
+		h.job.State = StateCancelled
+	case errors.Is(err, ErrSkipped):
+		h.job.State = StateSkipped
+	default:
+		h.job.State = StateFailed
+		h.job.Error = err.Error()
+	}
+	h.job.Finished = time.Now()
+	if !h.job.Started.IsZero() {
+		h.job.DurationSeconds = h.job.Finished.Sub(h.job.Started).Round(100 * time.Millisecond).Seconds()
+	}
+	h.job.Cancellable = false
+	h.onCancel = nil
+	h.cancel()
+	delete(active, h.job.ID)
+	h.span.SetAttribute("job.state", h.job.State)
+	if h.job.State == StateFailed {
+		h.span.End(err)
+	} else {
+		h.span.End(nil)
+	}
+
+	persistLocked(h.job)
+	trimHistoryLocked()
+}
+
+// persistLocked records the current state of a job and streams it to live
+// subscribers
+func persistLocked(job *Job) {
+	if err := state.Put(bucketJobs, job.ID, job); err != nil {
+		log.Printf("Failed to persist job %s: %v", job.ID, err)
+	}
+	data := map[string]interface{}{"maps": job.Maps, "job": copyJob(job)}
+	if len(job.Maps) == 1 {
+		// Lets /events filter the job by its map
+		data["map"] = job.Maps[0]
+	}
+	notify.Stream(notify.EventJob, fmt.Sprintf("%s job %s is %s", job.Type, job.ID, job.State), data)
+}
+
+func copyJob(job *Job) Job {
+	c := *job
+	c.Maps = append([]string{}, job.Maps...)
+	c.Logs = append([]LogLine{}, job.Logs...)
+	c.Backups = append([]string(nil), job.Backups...)
+	return c
+}
+
+// trimHistoryLocked drops the oldest finished jobs beyond maxHistory
+func trimHistoryLocked() {
+	jobs, err := storedJobs()
+	if err != nil || len(jobs) <= maxHistory {
+		return
+	}
+	for _, job := range jobs[maxHistory:] {
+		if !job.done() {
+			continue
+		}
+		if err := state.Delete(bucketJobs, job.ID); err != nil {
+			log.Printf("Failed to remove job %s from history: %v", job.ID, err)
+		}
+	}
+}
+
+// storedJobs returns the persisted job history, newest first
+func storedJobs() ([]Job, error) {
+	var jobs []Job
+	err := state.ForEach(bucketJobs, func(key string, value []byte) error {
+		var job Job
+		if err := json.Unmarshal(value, &job); err != nil {
+			return nil
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.After(jobs[j].Created) })
+	return jobs, err
+}
+
+// RecoverInterrupted marks jobs that were pending or running when the
+// manager stopped as failed. It is called once the state store is open.
+func RecoverInterrupted() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	jobs, err := storedJobs()
+	if err != nil {
+		log.Printf("Failed to read job history: %v", err)
+		return
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		if job.done() {
+			continue
+		}
+		if _, ok := active[job.ID]; ok {
+			continue
+		}
+		job.State = StateFailed
+		job.Error = "interrupted by manager restart"
+		job.Cancellable = false
+		job.Finished = time.Now()
+		persistLocked(job)
+	}
+}
+
+// List returns the job history, newest first, filtered by f. Jobs of this
+// run are served from memory if the state store is unavailable.
+func List(f Filter) []Job {
+	mu.Lock()
+	defer mu.Unlock()
+
+	jobs, err := storedJobs()
+	if err != nil {
+		log.Printf("Failed to read job history: %v", err)
+		jobs = jobs[:0]
+		for i := len(recent) - 1; i >= 0; i-- {
+			jobs = append(jobs, copyJob(recent[i]))
+		}
+	}
+
+	filtered := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if f.matches(job) {
+			filtered = append(filtered, job)
+		}
+	}
+	return filtered
+}
+
+// Get returns a job by ID
+func Get(id string) (Job, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if h, ok := active[id]; ok {
+		return copyJob(h.job), true
+	}
+	var job Job
+	found, err := state.Get(bucketJobs, id, &job)
+	if err != nil {
+		log.Printf("Failed to read job %s: %v", id, err)
+	}
+	if !found {
+		for _, j := range recent {
+			if j.ID == id {
+				return copyJob(j), true
+			}
+		}
+	}
+	return job, found
+}
+
+// Cancel cancels a job that is cancellable in its current state
+func Cancel(id string) (Job, error) {
+	mu.Lock()
+	h, ok := active[id]
+	if !ok {
+		mu.Unlock()
+		job, found := Get(id)
+		if !found {
+			return job, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return job, fmt.Errorf("%w: job %s is %s", ErrNotCancellable, id, job.State)
+	}
+	onCancel := h.onCancel
+	mu.Unlock()
+
+	if onCancel == nil {
+		job := h.Job()
+		return job, fmt.Errorf("%w: job %s is %s and cannot be interrupted", ErrNotCancellable, id, job.State)
+	}
+	// onCancel may finish the job itself, so mu must not be held
+	if err := onCancel(); err != nil {
+		return h.Job(), fmt.Errorf("%w: %v", ErrNotCancellable, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if h.onCancel == nil && !h.job.done() {
+		// The job began while it was being cancelled
+		return copyJob(h.job), fmt.Errorf("%w: job %s is %s and cannot be interrupted", ErrNotCancellable, id, h.job.State)
+	}
+	h.cancel()
+	// A running job ends once it notices its context is cancelled
+	if h.job.State == StatePending {
+		h.finishLocked(ErrCancelled)
+	}
+	return copyJob(h.job), nil
+}
@@ -0,0 +1,267 @@
+// Package logalerts watches the console and game logs of the servers for
+// lines matching the operator's patterns, such as "Fatal error" or a mod
+// mismatch. A match raises an alert: a log.alert event and the rules with a
+// log_pattern trigger. Each pattern cools down per map, so a server that
+// repeats a line does not flood the webhooks.
+package logalerts
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/gamelog"
+	"asa_servermanager_api/notify"
+)
+
+// Log sources
+const (
+	SourceConsole = "console"
+	SourceGameLog = "gamelog"
+)
+
+const (
+	defaultCooldown = 10 * time.Minute
+
+	// maxAlerts is how many alerts are kept for the API
+	maxAlerts = 100
+	// maxLineLength cuts long lines in alerts
+	maxLineLength = 500
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Pattern raises an alert for the lines of its sources that match Regex,
+// on Maps or every map if empty. After an alert it stays quiet on the map
+// for CooldownMinutes, default 10, and counts the matches it suppressed.
+type Pattern struct {
+	Name  string `json:"name"`
+	Regex string `json:"regex"`
+	// Sources are console and gamelog, empty means both
+	Sources         []string `json:"sources,omitempty"`
+	Maps            []string `json:"maps,omitempty"`
+	CooldownMinutes int      `json:"cooldown_minutes,omitempty"`
+}
+
+type LogAlertsConfig struct {
+	Patterns []Pattern `json:"patterns"`
+}
+
+// Alert is a line that matched a pattern. Suppressed counts the matches of
+// the pattern on the map during the cooldown before it.
+type Alert struct {
+	Time       time.Time `json:"time"`
+	Map        string    `json:"map"`
+	Pattern    string    `json:"pattern"`
+	Source     string    `json:"source"`
+	Line       string    `json:"line"`
+	Suppressed int       `json:"suppressed,omitempty"`
+}
+
+type pattern struct {
+	Pattern
+	regex    *regexp.Regexp
+	cooldown time.Duration
+}
+
+// cooldown is the state of a pattern on a map
+type cooldown struct {
+	until      time.Time
+	suppressed int
+}
+
+type Scanner struct {
+	patterns []pattern
+
+	// OnAlert is called with each alert, e.g. to fire the rules
+	OnAlert func(alert Alert)
+
+	cooldowns map[string]*cooldown
+	alerts    []Alert
+	mu        sync.Mutex
+}
+
+func (p Pattern) validate() (*regexp.Regexp, error) {
+	if !namePattern.MatchString(p.Name) {
+		return nil, fmt.Errorf("pattern name %q must be 1-64 letters, digits, '-' or '_'", p.Name)
+	}
+	for _, source := range p.Sources {
+		if source != SourceConsole && source != SourceGameLog {
+			return nil, fmt.Errorf("pattern %s: unknown source %q, use %s or %s", p.Name, source, SourceConsole, SourceGameLog)
+		}
+	}
+	if p.CooldownMinutes < 0 {
+		return nil, fmt.Errorf("pattern %s: cooldown_minutes must not be negative", p.Name)
+	}
+	if p.Regex == "" {
+		return nil, fmt.Errorf("pattern %s needs a regex", p.Name)
+	}
+	regex, err := regexp.Compile(p.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %s: %w", p.Name, err)
+	}
+	return regex, nil
+}
+
+// NewScanner reads the patterns, a missing file means none
+func NewScanner(configFile string) (*Scanner, error) {
+	var config LogAlertsConfig
+	if err := configfile.Read(configFile, &config); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read log alerts config: %w", err)
+	}
+
+	s := &Scanner{cooldowns: make(map[string]*cooldown), alerts: []Alert{}}
+	seen := make(map[string]bool)
+	for _, p := range config.Patterns {
+		regex, err := p.validate()
+		if err != nil {
+			return nil, fmt.Errorf("log alerts config: %w", err)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("log alerts config: pattern %s is listed twice", p.Name)
+		}
+		seen[p.Name] = true
+		cooldown := defaultCooldown
+		if p.CooldownMinutes > 0 {
+			cooldown = time.Duration(p.CooldownMinutes) * time.Minute
+		}
+		s.patterns = append(s.patterns, pattern{Pattern: p, regex: regex, cooldown: cooldown})
+	}
+	return s, nil
+}
+
+// Patterns returns the configured patterns
+func (s *Scanner) Patterns() []Pattern {
+	patterns := make([]Pattern, 0, len(s.patterns))
+	for _, p := range s.patterns {
+		patterns = append(patterns, p.Pattern)
+	}
+	return patterns
+}
+
+// HasPattern reports whether a pattern is configured
+func (s *Scanner) HasPattern(name string) bool {
+	for _, p := range s.patterns {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Start scans the game log events as the collector stores them. Console
+// lines are passed to Console by the process manager.
+func (s *Scanner) Start() {
+	if len(s.patterns) == 0 {
+		return
+	}
+	events, _ := notify.Subscribe([]string{notify.EventGameLog}, 256)
+	go func() {
+		for payload := range events {
+			if event, ok := payload.Data["event"].(gamelog.Event); ok {
+				s.Scan(SourceGameLog, event.Map, event.Message)
+			}
+		}
+	}()
+}
+
+// Console scans a line of a server's console
+func (s *Scanner) Console(mapName string, line string) {
+	s.Scan(SourceConsole, mapName, line)
+}
+
+func (p pattern) watches(source string, mapName string) bool {
+	if len(p.Sources) > 0 && !contains(p.Sources, source) {
+		return false
+	}
+	return len(p.Maps) == 0 || contains(p.Maps, mapName)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan checks a line of a map's log against the patterns and raises the
+// alerts of those that match and are not cooling down
+func (s *Scanner) Scan(source string, mapName string, line string) {
+	for _, p := range s.patterns {
+		if !p.watches(source, mapName) || !p.regex.MatchString(line) {
+			continue
+		}
+		if alert, ok := s.raise(p, source, mapName, line); ok {
+			s.notify(alert)
+		}
+	}
+}
+
+// raise records an alert unless the pattern is cooling down on the map
+func (s *Scanner) raise(p pattern, source string, mapName string, line string) (Alert, bool) {
+	now := time.Now()
+	key := p.Name + "/" + mapName
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.cooldowns[key]
+	if !ok {
+		c = &cooldown{}
+		s.cooldowns[key] = c
+	}
+	if now.Before(c.until) {
+		c.suppressed++
+		return Alert{}, false
+	}
+
+	line = strings.TrimSpace(line)
+	if len(line) > maxLineLength {
+		line = line[:maxLineLength] + "..."
+	}
+	alert := Alert{Time: now, Map: mapName, Pattern: p.Name, Source: source, Line: line, Suppressed: c.suppressed}
+	c.until = now.Add(p.cooldown)
+	c.suppressed = 0
+
+	s.alerts = append(s.alerts, alert)
+	if len(s.alerts) > maxAlerts {
+		s.alerts = s.alerts[len(s.alerts)-maxAlerts:]
+	}
+	return alert, true
+}
+
+func (s *Scanner) notify(alert Alert) {
+	log.Printf("Log pattern '%s' matched on map '%s' (%s): %s", alert.Pattern, alert.Map, alert.Source, alert.Line)
+	message := fmt.Sprintf("%s matched %s on %s: %s", alert.Pattern, alert.Source, alert.Map, alert.Line)
+	if alert.Suppressed > 0 {
+		message += fmt.Sprintf(" (%d more during the cooldown)", alert.Suppressed)
+	}
+	notify.Publish(notify.EventLogAlert, message, map[string]interface{}{
+		"map": alert.Map, "pattern": alert.Pattern, "source": alert.Source, "line": alert.Line, "suppressed": alert.Suppressed,
+	})
+	if s.OnAlert != nil {
+		go s.OnAlert(alert)
+	}
+}
+
+// Alerts returns the recent alerts, newest first, of a map or of every map
+// if mapName is empty
+func (s *Scanner) Alerts(mapName string) []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts := []Alert{}
+	for i := len(s.alerts) - 1; i >= 0; i-- {
+		if mapName == "" || s.alerts[i].Map == mapName {
+			alerts = append(alerts, s.alerts[i])
+		}
+	}
+	return alerts
+}
@@ -0,0 +1,328 @@
+// Package macros runs named sequences of steps on a map, such as the RCON
+// commands, broadcasts and INI changes that start an event. A macro runs on
+// demand, on its cron schedules or as the action of a rule, each run as a
+// job that reports the result of every step.
+package macros
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/cron"
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/processmanager"
+)
+
+var (
+	ErrMacroNotFound = errors.New("macro not found")
+	ErrMacroExists   = errors.New("macro already exists")
+	ErrInvalidMacro  = errors.New("invalid macro")
+)
+
+// Step types
+const (
+	StepRcon      = "rcon"
+	StepBroadcast = "broadcast"
+	StepIni       = "ini"
+)
+
+const (
+	// maxSteps and maxDelay keep a run within a few hours
+	maxSteps        = 50
+	maxDelaySeconds = 6 * 60 * 60
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Step is one step of a macro. It waits DelaySeconds after the previous
+// step before it runs. {map} in a command or message is the map's name.
+type Step struct {
+	Type         string `json:"type"`
+	DelaySeconds int    `json:"delay_seconds,omitempty"`
+	// Command is the RCON command of an rcon step
+	Command string `json:"command,omitempty"`
+	// Message is broadcast by a broadcast step
+	Message string `json:"message,omitempty"`
+	// File, Section, Key and Value are the setting an ini step sets. The
+	// server reads it when it starts next.
+	File    string `json:"file,omitempty"`
+	Section string `json:"section,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Value   string `json:"value,omitempty"`
+	// ContinueOnError runs the next steps when this one fails, by default
+	// a failed step ends the run
+	ContinueOnError bool `json:"continue_on_error,omitempty"`
+}
+
+// Schedule runs a macro on maps at the times of a cron expression,
+// evaluated in Timezone, by default local time. Maps that are not running
+// or in maintenance are skipped.
+type Schedule struct {
+	Cron     string   `json:"cron"`
+	Timezone string   `json:"timezone,omitempty"`
+	Maps     []string `json:"maps"`
+}
+
+type Macro struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Steps       []Step     `json:"steps"`
+	Schedules   []Schedule `json:"schedules,omitempty"`
+}
+
+// MacroStatus is a macro with when its schedules run next
+type MacroStatus struct {
+	Macro
+	NextRuns []time.Time `json:"next_runs,omitempty"`
+}
+
+type MacrosConfig struct {
+	Macros []Macro `json:"macros"`
+}
+
+func (s Step) validate() error {
+	if s.DelaySeconds < 0 || s.DelaySeconds > maxDelaySeconds {
+		return fmt.Errorf("%w: delay_seconds must be between 0 and %d", ErrInvalidMacro, maxDelaySeconds)
+	}
+	switch s.Type {
+	case StepRcon:
+		if strings.TrimSpace(s.Command) == "" {
+			return fmt.Errorf("%w: rcon step needs a command", ErrInvalidMacro)
+		}
+	case StepBroadcast:
+		if strings.TrimSpace(s.Message) == "" {
+			return fmt.Errorf("%w: broadcast step needs a message", ErrInvalidMacro)
+		}
+	case StepIni:
+		switch {
+		case !ini.IsEditable(s.File):
+			return fmt.Errorf("%w: ini step file must be %s or %s", ErrInvalidMacro, ini.GameUserSettings, ini.Game)
+		case strings.TrimSpace(s.Section) == "" || strings.ContainsAny(s.Section, "[]\r\n"):
+			return fmt.Errorf("%w: ini step section must be a section name without brackets", ErrInvalidMacro)
+		case strings.TrimSpace(s.Key) == "" || strings.ContainsAny(s.Key, "=[]\r\n;"):
+			return fmt.Errorf("%w: ini step key %q is not a valid setting name", ErrInvalidMacro, s.Key)
+		case strings.ContainsAny(s.Value, "\r\n"):
+			return fmt.Errorf("%w: ini step value of %s must not contain line breaks", ErrInvalidMacro, s.Key)
+		}
+		if _, err := ini.Validate(s.File, s.Section, s.Key, s.Value); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidMacro, err)
+		}
+	default:
+		return fmt.Errorf("%w: unknown step type %q", ErrInvalidMacro, s.Type)
+	}
+	return nil
+}
+
+// next returns when the schedule runs after t, the zero time if it never
+// does
+func (s Schedule) next(t time.Time) (time.Time, error) {
+	schedule, err := cron.Parse(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.Local
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("unknown timezone %q", s.Timezone)
+		}
+	}
+	return schedule.Next(t.In(loc)), nil
+}
+
+func (s Schedule) validate() error {
+	next, err := s.next(time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMacro, err)
+	}
+	if next.IsZero() {
+		return fmt.Errorf("%w: cron expression %q never fires", ErrInvalidMacro, s.Cron)
+	}
+	if len(s.Maps) == 0 {
+		return fmt.Errorf("%w: a schedule needs maps", ErrInvalidMacro)
+	}
+	return nil
+}
+
+// Validate checks a macro before it is saved
+func (m Macro) Validate() error {
+	if !namePattern.MatchString(m.Name) {
+		return fmt.Errorf("%w: name must be 1-64 letters, digits, '-' or '_'", ErrInvalidMacro)
+	}
+	if len(m.Steps) == 0 || len(m.Steps) > maxSteps {
+		return fmt.Errorf("%w: a macro needs 1-%d steps", ErrInvalidMacro, maxSteps)
+	}
+	for i, step := range m.Steps {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	for _, schedule := range m.Schedules {
+		if err := schedule.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commands returns the RCON commands of the macro's rcon and broadcast
+// steps, with {map} as it is written
+func (m Macro) Commands() []string {
+	var commands []string
+	for _, step := range m.Steps {
+		if step.Type == StepRcon || step.Type == StepBroadcast {
+			commands = append(commands, step.command("{map}"))
+		}
+	}
+	return commands
+}
+
+type Manager struct {
+	configFile string
+	macros     map[string]Macro
+	pm         *processmanager.ProcessManager
+
+	// BeforeIni is called with the current content of an INI file before
+	// an ini step changes it, to keep a copy
+	BeforeIni func(mapName string, file string, data []byte) error
+
+	// next is when each schedule runs next, by macro and schedule index
+	next map[string][]time.Time
+	mu   sync.Mutex
+}
+
+func NewManager(configFile string, pm *processmanager.ProcessManager) (*Manager, error) {
+	var config MacrosConfig
+	if err := configfile.Read(configFile, &config); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read macros config: %w", err)
+	}
+
+	m := &Manager{configFile: configFile, macros: make(map[string]Macro), pm: pm, next: make(map[string][]time.Time)}
+	for _, macro := range config.Macros {
+		if err := macro.Validate(); err != nil {
+			return nil, fmt.Errorf("macro %s: %w", macro.Name, err)
+		}
+		if _, exists := m.macros[macro.Name]; exists {
+			return nil, fmt.Errorf("macro %s: %w", macro.Name, ErrMacroExists)
+		}
+		m.macros[macro.Name] = macro
+		m.scheduleLocked(macro)
+	}
+	return m, nil
+}
+
+// saveLocked writes the macros back to the config file
+func (m *Manager) saveLocked() error {
+	config := MacrosConfig{Macros: make([]Macro, 0, len(m.macros))}
+	for _, name := range m.namesLocked() {
+		config.Macros = append(config.Macros, m.macros[name])
+	}
+	return configfile.WriteJSON(m.configFile, config)
+}
+
+func (m *Manager) namesLocked() []string {
+	names := make([]string, 0, len(m.macros))
+	for name := range m.macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scheduleLocked computes when the schedules of a macro run next
+func (m *Manager) scheduleLocked(macro Macro) {
+	next := make([]time.Time, len(macro.Schedules))
+	for i, schedule := range macro.Schedules {
+		next[i], _ = schedule.next(time.Now())
+	}
+	m.next[macro.Name] = next
+}
+
+// Macros returns every macro, sorted by name
+func (m *Manager) Macros() []MacroStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]MacroStatus, 0, len(m.macros))
+	for _, name := range m.namesLocked() {
+		statuses = append(statuses, MacroStatus{Macro: m.macros[name], NextRuns: append([]time.Time(nil), m.next[name]...)})
+	}
+	return statuses
+}
+
+// Get returns a macro
+func (m *Manager) Get(name string) (MacroStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	macro, exists := m.macros[name]
+	if !exists {
+		return MacroStatus{}, fmt.Errorf("%w: %s", ErrMacroNotFound, name)
+	}
+	return MacroStatus{Macro: macro, NextRuns: append([]time.Time(nil), m.next[name]...)}, nil
+}
+
+// Create adds a macro and persists it
+func (m *Manager) Create(macro Macro) error {
+	if err := macro.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.macros[macro.Name]; exists {
+		return fmt.Errorf("%w: %s", ErrMacroExists, macro.Name)
+	}
+	m.macros[macro.Name] = macro
+	if err := m.saveLocked(); err != nil {
+		delete(m.macros, macro.Name)
+		return err
+	}
+	m.scheduleLocked(macro)
+	return nil
+}
+
+// Update replaces a macro and persists it. Runs in progress keep the steps
+// they started with.
+func (m *Manager) Update(macro Macro) error {
+	if err := macro.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous, exists := m.macros[macro.Name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrMacroNotFound, macro.Name)
+	}
+	m.macros[macro.Name] = macro
+	if err := m.saveLocked(); err != nil {
+		m.macros[macro.Name] = previous
+		return err
+	}
+	m.scheduleLocked(macro)
+	return nil
+}
+
+// Delete removes a macro and persists the change
+func (m *Manager) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous, exists := m.macros[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrMacroNotFound, name)
+	}
+	delete(m.macros, name)
+	if err := m.saveLocked(); err != nil {
+		m.macros[name] = previous
+		return err
+	}
+	delete(m.next, name)
+	return nil
+}
@@ -0,0 +1,279 @@
+package macros
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/inidrift"
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+)
+
+// scheduleInterval is how often the schedules are checked
+const scheduleInterval = 30 * time.Second
+
+// Result is what a macro run on a map did, the result of its job
+type Result struct {
+	Macro string       `json:"macro"`
+	Map   string       `json:"map"`
+	Steps []StepResult `json:"steps"`
+}
+
+// StepResult is what a step of a run did. Steps after a failed one are
+// skipped.
+type StepResult struct {
+	Step     int       `json:"step"`
+	Type     string    `json:"type"`
+	Action   string    `json:"action"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+	Output   string    `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Skipped  bool      `json:"skipped,omitempty"`
+}
+
+// describe returns what a step does on a map, e.g. the RCON command
+func (s Step) describe(mapName string) string {
+	switch s.Type {
+	case StepRcon, StepBroadcast:
+		return s.command(mapName)
+	case StepIni:
+		return fmt.Sprintf("set %s [%s] %s=%s", s.File, s.Section, s.Key, s.Value)
+	}
+	return s.Type
+}
+
+// command returns the RCON command of an rcon or broadcast step
+func (s Step) command(mapName string) string {
+	if s.Type == StepBroadcast {
+		// The message may come from an API caller
+		return "broadcast " + rcon.SanitizeText(strings.ReplaceAll(s.Message, "{map}", mapName))
+	}
+	return strings.ReplaceAll(s.Command, "{map}", mapName)
+}
+
+// macro returns a macro and checks that the map exists
+func (m *Manager) macro(name string, mapName string) (Macro, error) {
+	m.mu.Lock()
+	macro, exists := m.macros[name]
+	m.mu.Unlock()
+	if !exists {
+		return Macro{}, fmt.Errorf("%w: %s", ErrMacroNotFound, name)
+	}
+	if !m.pm.HasMap(mapName) {
+		return Macro{}, fmt.Errorf("%w: %s", processmanager.ErrMapNotFound, mapName)
+	}
+	return macro, nil
+}
+
+// Run runs a macro on a map in the background and returns its job
+func (m *Manager) Run(name string, mapName string) (jobs.Job, error) {
+	macro, err := m.macro(name, mapName)
+	if err != nil {
+		return jobs.Job{}, err
+	}
+	job := newJob(mapName)
+	go m.runJob(job, macro, mapName)
+	return job.Job(), nil
+}
+
+// RunAndWait runs a macro on a map and returns once it finished, for rules
+func (m *Manager) RunAndWait(name string, mapName string) (Result, error) {
+	macro, err := m.macro(name, mapName)
+	if err != nil {
+		return Result{}, err
+	}
+	return m.runJob(newJob(mapName), macro, mapName)
+}
+
+// newJob records a run as a job that can be cancelled between steps
+func newJob(mapName string) *jobs.Handle {
+	job := jobs.New(jobs.TypeMacro, mapName)
+	job.SetCancel(nil)
+	return job
+}
+
+// runJob runs the steps of a macro as a job. A cancelled job skips the
+// steps it has not started.
+func (m *Manager) runJob(job *jobs.Handle, macro Macro, mapName string) (Result, error) {
+	job.Start()
+	job.Logf("Running macro %s", macro.Name)
+	run := Result{Macro: macro.Name, Map: mapName, Steps: make([]StepResult, len(macro.Steps))}
+	for i, step := range macro.Steps {
+		run.Steps[i] = StepResult{Step: i + 1, Type: step.Type, Action: step.describe(mapName), Skipped: true}
+	}
+	job.SetResult(run.copy())
+
+	var failed error
+	for i, step := range macro.Steps {
+		if failed != nil {
+			break
+		}
+		if err := sleep(job.Context(), time.Duration(step.DelaySeconds)*time.Second); err != nil {
+			failed = err
+			break
+		}
+		result := &run.Steps[i]
+		result.Skipped = false
+		result.Started = time.Now()
+		output, err := m.runStep(step, mapName)
+		result.Finished = time.Now()
+		result.Output = strings.TrimSpace(output)
+		if err != nil {
+			result.Error = err.Error()
+			job.Logf("Step %d (%s) failed: %v", i+1, result.Action, err)
+			if !step.ContinueOnError {
+				failed = fmt.Errorf("step %d failed: %w", i+1, err)
+			}
+		} else {
+			job.Logf("Step %d: %s", i+1, result.Action)
+		}
+		job.SetResult(run.copy())
+	}
+	job.Finish(failed)
+	if failed != nil && !errors.Is(failed, context.Canceled) {
+		log.Printf("Macro '%s' on map '%s' failed: %v", macro.Name, mapName, failed)
+	}
+	return run, failed
+}
+
+// copy returns the result with its own steps, for the job while the run
+// goes on
+func (r Result) copy() Result {
+	r.Steps = append([]StepResult(nil), r.Steps...)
+	return r
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (m *Manager) runStep(step Step, mapName string) (string, error) {
+	switch step.Type {
+	case StepRcon, StepBroadcast:
+		return rcon.Execute(mapName, step.command(mapName))
+	case StepIni:
+		return "", m.setIni(step, mapName)
+	}
+	return "", fmt.Errorf("unknown step type %q", step.Type)
+}
+
+// setIni sets the setting of an ini step in the map's INI file
+func (m *Manager) setIni(step Step, mapName string) error {
+	config, ok := m.pm.Config(mapName)
+	if !ok {
+		return fmt.Errorf("%w: %s", processmanager.ErrMapNotFound, mapName)
+	}
+	path := filepath.Join(config.IniDir(), step.File)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if data != nil && m.BeforeIni != nil {
+		if err := m.BeforeIni(mapName, step.File, data); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", step.File, err)
+		}
+	}
+	f := ini.Parse(data)
+	f.Set(step.Section, step.Key, step.Value)
+	return inidrift.Write(mapName, step.File, path, f.Bytes())
+}
+
+// Plan returns what running a macro on a map would do, for a dry run
+func (m *Manager) Plan(name string, mapName string) (dryrun.Plan, error) {
+	macro, err := m.macro(name, mapName)
+	if err != nil {
+		return dryrun.Plan{}, err
+	}
+	plan := dryrun.Plan{Operation: "macro " + macro.Name, Map: mapName}
+	for i, step := range macro.Steps {
+		if step.DelaySeconds > 0 {
+			plan.Stepf("Wait %d second(s)", step.DelaySeconds)
+		}
+		switch step.Type {
+		case StepRcon, StepBroadcast:
+			plan.Commands = append(plan.Commands, step.command(mapName))
+			plan.Stepf("Step %d: send %s", i+1, step.command(mapName))
+		default:
+			plan.Stepf("Step %d: %s", i+1, step.describe(mapName))
+		}
+	}
+	return plan, nil
+}
+
+// Start runs the scheduled macros in the background
+func (m *Manager) Start() {
+	go func() {
+		for {
+			time.Sleep(scheduleInterval)
+			m.runSchedules()
+		}
+	}()
+}
+
+// runSchedules runs the macros whose schedules are due on those of their
+// maps with a ready server
+func (m *Manager) runSchedules() {
+	type due struct {
+		macro string
+		maps  []string
+	}
+	var runs []due
+	now := time.Now()
+	m.mu.Lock()
+	for _, name := range m.namesLocked() {
+		macro := m.macros[name]
+		for i, schedule := range macro.Schedules {
+			next := m.next[name]
+			if i >= len(next) || next[i].IsZero() || now.Before(next[i]) {
+				continue
+			}
+			next[i], _ = schedule.next(now)
+			runs = append(runs, due{name, schedule.Maps})
+		}
+	}
+	m.mu.Unlock()
+
+	for _, run := range runs {
+		for _, mapName := range run.maps {
+			ms, ok := m.pm.State(mapName)
+			switch {
+			case !ok:
+				log.Printf("Scheduled macro '%s' skipped map '%s', it is not registered", run.macro, mapName)
+			case maintenance.Global() || maintenance.Active(mapName):
+				log.Printf("Scheduled macro '%s' skipped map '%s', it is in maintenance", run.macro, mapName)
+			case !ms.Ready():
+				log.Printf("Scheduled macro '%s' skipped map '%s', its server is not ready", run.macro, mapName)
+			case dryrun.Global():
+				if plan, err := m.Plan(run.macro, mapName); err == nil {
+					plan.Log()
+				}
+			default:
+				log.Printf("Running scheduled macro '%s' on map '%s'", run.macro, mapName)
+				if _, err := m.Run(run.macro, mapName); err != nil {
+					log.Printf("Failed to start scheduled macro '%s' on map '%s': %v", run.macro, mapName, err)
+				}
+			}
+		}
+	}
+}
@@ -2,11 +2,53 @@ package main
 
 import (
 	"asa_servermanager_api/api"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/secrets"
+	"asa_servermanager_api/service"
+	"asa_servermanager_api/state"
+	"context"
+	"flag"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	log.SetOutput(secrets.NewRedactor(os.Stderr))
+
+	installService := flag.Bool("install-service", false, "install the manager as a Windows service or systemd unit and exit")
+	uninstallService := flag.Bool("uninstall-service", false, "remove the installed service and exit")
+	runService := flag.Bool("run", false, "run under the service manager (used by the installed service)")
+	flag.Parse()
+
+	if *installService {
+		if err := service.Install(); err != nil {
+			log.Fatalf("Failed to install service: %v", err)
+		}
+		log.Printf("Service %s installed", service.Name)
+		return
+	}
+	if *uninstallService {
+		if err := service.Uninstall(); err != nil {
+			log.Fatalf("Failed to uninstall service: %v", err)
+		}
+		log.Printf("Service %s uninstalled", service.Name)
+		return
+	}
+
+	if *runService {
+		// Service managers do not start us next to our config directory
+		dir, err := service.WorkDir()
+		if err != nil {
+			log.Fatalf("Failed to determine working directory: %v", err)
+		}
+		if err := os.Chdir(dir); err != nil {
+			log.Fatalf("Failed to change to %s: %v", dir, err)
+		}
+	}
+
 	dataDir := "./data"
 	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
 		err := os.MkdirAll(dataDir, 0755)
@@ -28,5 +70,54 @@ func main() {
 			log.Printf("Failed to create data directory: %v", err)
 		}
 	}
-	api.SetupRoutes()
+	if err := secrets.Unlock(secrets.File); err != nil {
+		log.Fatalf("Failed to unlock secrets: %v", err)
+	}
+	// Loading the RCON config registers its passwords for redaction
+	if _, err := rcon.LoadRconInfos(); err != nil {
+		log.Printf("Failed to load rcon config: %v", err)
+	}
+	if err := state.Open("./data/state.db", dataDir); err != nil {
+		log.Fatalf("Failed to open state store: %v", err)
+	}
+	defer state.Close()
+
+	if *runService {
+		err := service.Run(func(l net.Listener) error {
+			if l != nil {
+				api.SetListener(l)
+			}
+			return api.SetupRoutes()
+		}, api.Ready())
+		shutdown()
+		if err != nil {
+			log.Printf("Service stopped: %v", err)
+			state.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- api.SetupRoutes()
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-stopped:
+		log.Printf("API stopped: %v", err)
+		state.Close()
+		os.Exit(1)
+	case sig := <-signals:
+		log.Printf("Received %s, stopping", sig)
+	}
+	shutdown()
+}
+
+// shutdown lets the API finish the requests in flight
+func shutdown() {
+	if err := api.Shutdown(context.Background()); err != nil {
+		log.Printf("Failed to shut down the API cleanly: %v", err)
+	}
 }
@@ -0,0 +1,142 @@
+// Package maintenance marks maps, or the whole manager, as being worked on
+// by hand. While a map is in maintenance the manager leaves it alone: its
+// server is not restarted automatically, update checks skip it and its
+// backup schedule is paused. Requests made through the API still run.
+// Maintenance modes are kept in the state store and survive restarts.
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/state"
+)
+
+const (
+	bucketMaintenance = "maintenance"
+	// globalKey stores the maintenance mode of every map
+	globalKey = "*"
+)
+
+var ErrNotActive = errors.New("not in maintenance")
+
+// Mode is a maintenance mode of a map, or of every map when Map is empty
+type Mode struct {
+	Map    string    `json:"map,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"`
+	Since  time.Time `json:"since"`
+}
+
+func key(mapName string) string {
+	if mapName == "" {
+		return globalKey
+	}
+	return mapName
+}
+
+func get(mapName string) (Mode, bool) {
+	var mode Mode
+	found, err := state.Get(bucketMaintenance, key(mapName), &mode)
+	if err != nil {
+		log.Printf("Failed to read maintenance mode of %s: %v", key(mapName), err)
+		return Mode{}, false
+	}
+	return mode, found
+}
+
+// Of returns the maintenance mode a map is in, its own or the global one
+func Of(mapName string) (Mode, bool) {
+	if mode, ok := get(""); ok {
+		return mode, true
+	}
+	return get(mapName)
+}
+
+// Active reports whether a map is in maintenance, on its own or because
+// every map is
+func Active(mapName string) bool {
+	_, ok := Of(mapName)
+	return ok
+}
+
+// Global reports whether every map is in maintenance
+func Global() bool {
+	_, ok := get("")
+	return ok
+}
+
+// List returns the maintenance modes, the global one first
+func List() ([]Mode, error) {
+	modes := []Mode{}
+	err := state.ForEach(bucketMaintenance, func(_ string, value []byte) error {
+		var mode Mode
+		if err := json.Unmarshal(value, &mode); err != nil {
+			return err
+		}
+		modes = append(modes, mode)
+		return nil
+	})
+	sort.Slice(modes, func(i, j int) bool { return modes[i].Map < modes[j].Map })
+	return modes, err
+}
+
+// Enable puts a map, or every map when mapName is empty, into maintenance.
+// Enabling it again only updates the reason.
+func Enable(mapName string, reason string, by string) (Mode, error) {
+	mode, active := get(mapName)
+	if !active {
+		mode = Mode{Map: mapName, Since: time.Now()}
+	}
+	mode.Reason = reason
+	mode.By = by
+	if err := state.Put(bucketMaintenance, key(mapName), mode); err != nil {
+		return Mode{}, err
+	}
+	if !active {
+		log.Printf("Maintenance of %s started by %s: %s", describe(mapName), by, orNone(reason))
+		notify.Publish(notify.EventMaintenanceStarted, fmt.Sprintf("%s is in maintenance: %s", describe(mapName), orNone(reason)), data(mode))
+	}
+	return mode, nil
+}
+
+// Disable ends the maintenance of a map, or the global one when mapName is
+// empty. A map stays in the global maintenance.
+func Disable(mapName string, by string) (Mode, error) {
+	mode, active := get(mapName)
+	if !active {
+		return Mode{}, fmt.Errorf("%s is %w", describe(mapName), ErrNotActive)
+	}
+	if err := state.Delete(bucketMaintenance, key(mapName)); err != nil {
+		return Mode{}, err
+	}
+	log.Printf("Maintenance of %s ended by %s", describe(mapName), by)
+	d := data(mode)
+	d["ended_by"] = by
+	d["duration"] = time.Since(mode.Since).Round(time.Second).Seconds()
+	notify.Publish(notify.EventMaintenanceEnded, fmt.Sprintf("%s left maintenance", describe(mapName)), d)
+	return mode, nil
+}
+
+func describe(mapName string) string {
+	if mapName == "" {
+		return "every map"
+	}
+	return "map " + mapName
+}
+
+func orNone(reason string) string {
+	if reason == "" {
+		return "no reason given"
+	}
+	return reason
+}
+
+func data(mode Mode) map[string]interface{} {
+	return map[string]interface{}{"map": mode.Map, "global": mode.Map == "", "reason": mode.Reason, "by": mode.By, "since": mode.Since}
+}
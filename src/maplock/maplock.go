@@ -0,0 +1,141 @@
+// Package maplock lets one operation at a time change a map's server files
+// and saves. Backups, restores, updates and restarts take the map's lock
+// so that, for example, a restore cannot run while a backup is reading the
+// save directory. Background work waits for the lock, requests made through
+// the API are rejected with ErrBusy instead.
+package maplock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operations that take a map's lock
+const (
+	OpBackup  = "backup"
+	OpRestore = "restore"
+	OpUpdate  = "update"
+	OpRestart = "restart"
+	OpCleanup = "cleanup"
+)
+
+var ErrBusy = errors.New("operation in progress")
+
+// Operation is the operation holding a map's lock
+type Operation struct {
+	Map       string    `json:"map"`
+	Operation string    `json:"operation"`
+	Since     time.Time `json:"since"`
+}
+
+// BusyError is returned when a map is locked by another operation
+type BusyError struct {
+	Wanted string
+	Holder Operation
+}
+
+func (e *BusyError) Error() string {
+	if e.Holder.Operation == "" {
+		return fmt.Sprintf("cannot %s map %s: another operation is in progress", e.Wanted, e.Holder.Map)
+	}
+	return fmt.Sprintf("cannot %s map %s: %s in progress since %s", e.Wanted, e.Holder.Map, e.Holder.Operation, e.Holder.Since.Format(time.RFC3339))
+}
+
+func (e *BusyError) Is(target error) bool {
+	return target == ErrBusy
+}
+
+type mapLock struct {
+	// free has a token while the map is unlocked
+	free   chan struct{}
+	holder Operation
+}
+
+var (
+	mu    sync.Mutex
+	locks = make(map[string]*mapLock)
+)
+
+func lockOf(mapName string) *mapLock {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lock, ok := locks[mapName]
+	if !ok {
+		lock = &mapLock{free: make(chan struct{}, 1)}
+		lock.free <- struct{}{}
+		locks[mapName] = lock
+	}
+	return lock
+}
+
+// take records op as the holder, mu must be held
+func (l *mapLock) take(mapName string, op string) func() {
+	l.holder = Operation{Map: mapName, Operation: op, Since: time.Now()}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			l.holder = Operation{}
+			mu.Unlock()
+			l.free <- struct{}{}
+		})
+	}
+}
+
+// busy returns the error for op finding the map locked, mu must be held
+func (l *mapLock) busy(mapName string, op string) *BusyError {
+	holder := l.holder
+	holder.Map = mapName
+	return &BusyError{Wanted: op, Holder: holder}
+}
+
+// TryAcquire locks a map for op and returns the func that unlocks it, or a
+// *BusyError if another operation holds the lock
+func TryAcquire(mapName string, op string) (func(), error) {
+	lock := lockOf(mapName)
+
+	mu.Lock()
+	defer mu.Unlock()
+	select {
+	case <-lock.free:
+		return lock.take(mapName, op), nil
+	default:
+		return nil, lock.busy(mapName, op)
+	}
+}
+
+// Acquire waits until the map is unlocked, then locks it for op
+func Acquire(ctx context.Context, mapName string, op string) (func(), error) {
+	lock := lockOf(mapName)
+	select {
+	case <-lock.free:
+		mu.Lock()
+		defer mu.Unlock()
+		return lock.take(mapName, op), nil
+	case <-ctx.Done():
+		mu.Lock()
+		defer mu.Unlock()
+		return nil, fmt.Errorf("%w: %w", lock.busy(mapName, op), ctx.Err())
+	}
+}
+
+// Held lists the operations currently holding a lock, by map
+func Held() []Operation {
+	mu.Lock()
+	defer mu.Unlock()
+
+	held := []Operation{}
+	for _, lock := range locks {
+		if lock.holder.Operation != "" {
+			held = append(held, lock.holder)
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].Map < held[j].Map })
+	return held
+}
@@ -0,0 +1,236 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/eos"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/secrets"
+)
+
+const (
+	defaultListingIntervalSeconds = 300
+	defaultListingMisses          = 2
+
+	// asaDeploymentID is the EOS deployment ASA's servers are listed in
+	asaDeploymentID = "ad9a8feffb3b4b2ca315546f038c3ae2"
+)
+
+// ListingConfig looks the running maps up in the EOS server list, where
+// crossplay players find them. A server whose process runs but that is
+// missing from Misses lookups in a row is reported with a
+// process.unlisted event.
+type ListingConfig struct {
+	Enabled bool `json:"enabled"`
+	eos.Config
+	// PublicIP is the address the servers are listed with
+	PublicIP            string `json:"public_ip"`
+	IntervalSeconds     int    `json:"interval_seconds"`
+	Misses              int    `json:"misses"`
+	StartupGraceSeconds int    `json:"startup_grace_seconds"`
+	// Ports sets the game port of maps whose process config does not name
+	// one, such as Docker containers. Maps without one are matched by
+	// their session name.
+	Ports map[string]int `json:"ports,omitempty"`
+	Maps  []string       `json:"maps,omitempty"`
+}
+
+// Listing is the outcome of the lookups of a map. Session is set while it
+// is listed, Unlisted from the alert until it is listed again.
+type Listing struct {
+	Listed      bool         `json:"listed"`
+	Session     *eos.Session `json:"session,omitempty"`
+	Misses      int          `json:"misses"`
+	LastChecked time.Time    `json:"last_checked"`
+	LastListed  time.Time    `json:"last_listed,omitempty"`
+	Unlisted    bool         `json:"unlisted,omitempty"`
+}
+
+func (c *ListingConfig) setDefaults() {
+	if c.DeploymentID == "" {
+		c.DeploymentID = asaDeploymentID
+	}
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = defaultListingIntervalSeconds
+	}
+	if c.Misses <= 0 {
+		c.Misses = defaultListingMisses
+	}
+	if c.StartupGraceSeconds <= 0 {
+		c.StartupGraceSeconds = defaultStartupGraceSeconds
+	}
+}
+
+func (c ListingConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if net.ParseIP(c.PublicIP) == nil {
+		return fmt.Errorf("public_ip must be an IP address")
+	}
+	_, err := eos.NewClient(c.Config)
+	return err
+}
+
+func (c ListingConfig) watches(mapName string) bool {
+	if len(c.Maps) == 0 {
+		return true
+	}
+	for _, m := range c.Maps {
+		if m == mapName {
+			return true
+		}
+	}
+	return false
+}
+
+// startListing looks the running maps up in the background, with one
+// lookup of every session of the public IP per interval
+func (m *Monitor) startListing() {
+	config := m.config.Listing
+	if !config.Enabled {
+		return
+	}
+	secret, err := secrets.Resolve(config.ClientSecret)
+	if err != nil {
+		log.Printf("Not checking the EOS server list: failed to resolve client_secret: %v", err)
+		return
+	}
+	config.ClientSecret = secret
+	client, err := eos.NewClient(config.Config)
+	if err != nil {
+		log.Printf("Not checking the EOS server list: %v", err)
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(config.IntervalSeconds) * time.Second)
+			m.checkListing(client)
+		}
+	}()
+}
+
+func (m *Monitor) checkListing(client *eos.Client) {
+	config := m.config.Listing
+	var checked []processmanager.MapState
+	for _, ms := range m.pm.States() {
+		if !config.watches(ms.Map) {
+			continue
+		}
+		// Servers register with EOS once they have loaded their world
+		if ms.Actual != processmanager.ActualRunning || !ms.Ready() {
+			m.clearListing(ms.Map)
+			continue
+		}
+		checked = append(checked, ms)
+	}
+	if len(checked) == 0 {
+		return
+	}
+
+	sessions, err := client.Sessions(config.PublicIP)
+	if err != nil {
+		// EOS being unreachable says nothing about the servers
+		log.Printf("Failed to look up the EOS server list: %v", err)
+		return
+	}
+	for _, ms := range checked {
+		grace := time.Since(ms.ReadySince) < time.Duration(config.StartupGraceSeconds)*time.Second
+		m.recordListing(ms.Map, m.findSession(ms.Map, sessions), grace)
+	}
+}
+
+// findSession returns the session of a map, matched by its game port or
+// else by its session name
+func (m *Monitor) findSession(mapName string, sessions []eos.Session) *eos.Session {
+	port, ok := m.config.Listing.Ports[mapName]
+	var name string
+	if config, found := m.pm.Config(mapName); found {
+		if !ok {
+			port = config.Ports()["port"]
+		}
+		if config.Launch != nil {
+			name = config.Launch.SessionName
+		}
+	}
+	for i, s := range sessions {
+		if (port != 0 && s.Port() == port) || (port == 0 && name != "" && strings.EqualFold(s.Name, name)) {
+			return &sessions[i]
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) recordListing(mapName string, session *eos.Session, grace bool) {
+	config := m.config.Listing
+	m.mu.Lock()
+	l, ok := m.listings[mapName]
+	if !ok {
+		l = &Listing{}
+		m.listings[mapName] = l
+	}
+	l.LastChecked = time.Now()
+	l.Session = session
+	l.Listed = session != nil
+	if session != nil {
+		relisted := l.Unlisted
+		l.Misses = 0
+		l.LastListed = l.LastChecked
+		l.Unlisted = false
+		m.mu.Unlock()
+		if relisted {
+			log.Printf("Server '%s' is listed in EOS again", mapName)
+		}
+		return
+	}
+	if grace || maintenance.Active(mapName) {
+		m.mu.Unlock()
+		return
+	}
+	l.Misses++
+	unlisted := l.Misses >= config.Misses && !l.Unlisted
+	if unlisted {
+		l.Unlisted = true
+	}
+	misses := l.Misses
+	m.mu.Unlock()
+
+	if !unlisted {
+		log.Printf("Server '%s' is missing from the EOS server list (%d/%d)", mapName, misses, config.Misses)
+		return
+	}
+	message := fmt.Sprintf("map %s is running but has been missing from the EOS server list for %d checks, crossplay players cannot find it", mapName, misses)
+	log.Printf("Server '%s': %s", mapName, message)
+	notify.Publish(notify.EventProcessUnlisted, message, map[string]interface{}{"map": mapName, "public_ip": config.PublicIP, "misses": misses})
+}
+
+func (m *Monitor) clearListing(mapName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.listings, mapName)
+}
+
+// Listing returns the outcome of the EOS lookups of a map, false if it is
+// not looked up
+func (m *Monitor) Listing(mapName string) (Listing, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.listings[mapName]
+	if !ok {
+		return Listing{}, false
+	}
+	result := *l
+	if l.Session != nil {
+		session := *l.Session
+		result.Session = &session
+	}
+	return result, true
+}
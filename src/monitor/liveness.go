@@ -0,0 +1,171 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+)
+
+const (
+	defaultProbeIntervalSeconds = 60
+	defaultProbeTimeoutSeconds  = 10
+	defaultProbeFailures        = 3
+	defaultStartupGraceSeconds  = 600
+	defaultProbeCommand         = "listplayers"
+)
+
+// LivenessConfig probes each running map over RCON. A server that fails
+// Failures probes in a row is considered hung.
+type LivenessConfig struct {
+	Enabled             bool     `json:"enabled"`
+	Command             string   `json:"command"`
+	IntervalSeconds     int      `json:"interval_seconds"`
+	TimeoutSeconds      int      `json:"timeout_seconds"`
+	Failures            int      `json:"failures"`
+	StartupGraceSeconds int      `json:"startup_grace_seconds"`
+	Restart             bool     `json:"restart"`
+	Maps                []string `json:"maps,omitempty"`
+}
+
+// Liveness is the probe state of a map
+type Liveness struct {
+	Failures  int       `json:"failures"`
+	LastOK    time.Time `json:"last_ok,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Hangs     int       `json:"hangs"`
+}
+
+func (c *LivenessConfig) setDefaults() {
+	if c.Command == "" {
+		c.Command = defaultProbeCommand
+	}
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = defaultProbeIntervalSeconds
+	}
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = defaultProbeTimeoutSeconds
+	}
+	if c.Failures <= 0 {
+		c.Failures = defaultProbeFailures
+	}
+	if c.StartupGraceSeconds <= 0 {
+		c.StartupGraceSeconds = defaultStartupGraceSeconds
+	}
+}
+
+func (c LivenessConfig) watches(mapName string) bool {
+	if len(c.Maps) == 0 {
+		return true
+	}
+	for _, m := range c.Maps {
+		if m == mapName {
+			return true
+		}
+	}
+	return false
+}
+
+// startLiveness probes the running maps in the background
+func (m *Monitor) startLiveness() {
+	config := m.config.Liveness
+	if !config.Enabled {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(time.Duration(config.IntervalSeconds) * time.Second)
+			for _, ms := range m.pm.States() {
+				if !config.watches(ms.Map) {
+					continue
+				}
+				// Servers take minutes to load and do not answer RCON
+				// until they have
+				if !ms.Ready() || ms.Desired != processmanager.DesiredEnabled ||
+					time.Since(ms.Since) < time.Duration(config.StartupGraceSeconds)*time.Second {
+					m.resetLiveness(ms.Map)
+					continue
+				}
+				go m.probe(ms.Map)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) probe(mapName string) {
+	if maintenance.Active(mapName) {
+		// Servers being worked on are neither probed nor restarted
+		m.resetLiveness(mapName)
+		return
+	}
+	config := m.config.Liveness
+	_, err := rcon.Probe(mapName, config.Command, time.Duration(config.TimeoutSeconds)*time.Second)
+
+	m.mu.Lock()
+	l, ok := m.liveness[mapName]
+	if !ok {
+		l = &Liveness{}
+		m.liveness[mapName] = l
+	}
+	if err == nil {
+		l.Failures = 0
+		l.LastOK = time.Now()
+		l.LastError = ""
+		m.mu.Unlock()
+		return
+	}
+	l.Failures++
+	l.LastError = err.Error()
+	hung := l.Failures >= config.Failures
+	if hung {
+		l.Failures = 0
+		l.Hangs++
+	}
+	failures := l.Failures
+	m.mu.Unlock()
+
+	if !hung {
+		log.Printf("Liveness probe of '%s' failed (%d/%d): %v", mapName, failures, config.Failures, err)
+		return
+	}
+
+	message := fmt.Sprintf("map %s has not answered RCON for %d probes", mapName, config.Failures)
+	if !config.Restart {
+		log.Printf("Server '%s' appears hung: %s", mapName, message)
+		notify.Send(notify.EventProcessHang, message)
+		return
+	}
+
+	log.Printf("Server '%s' appears hung, restarting it: %s", mapName, message)
+	notify.Send(notify.EventProcessHang, message+", restarting it")
+	timeout := time.Duration(m.config.RestartTimeoutSeconds) * time.Second
+	if err := m.pm.RestartProcess(mapName, timeout); err != nil {
+		log.Printf("Failed to restart hung server '%s': %v", mapName, err)
+		notify.Send(notify.EventProcessHang, fmt.Sprintf("restart of hung map %s failed: %v", mapName, err))
+	}
+}
+
+func (m *Monitor) resetLiveness(mapName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, ok := m.liveness[mapName]; ok {
+		l.Failures = 0
+	}
+}
+
+// Liveness returns the probe state of a map, false if it was never probed
+func (m *Monitor) Liveness(mapName string) (Liveness, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.liveness[mapName]
+	if !ok {
+		return Liveness{}, false
+	}
+	return *l, true
+}
@@ -0,0 +1,414 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+const (
+	defaultSampleIntervalSeconds = 10
+	defaultHistoryMinutes        = 24 * 60
+	defaultRestartTimeoutSeconds = 300
+)
+
+var ErrNoStats = errors.New("no stats recorded for map")
+
+// Metrics an alert can watch
+const (
+	MetricCPUPercent = "cpu_percent"
+	MetricMemoryMB   = "memory_mb"
+	MetricDiskUsedMB = "disk_used_mb"
+	MetricIOReadMBs  = "io_read_mb_s"
+	MetricIOWriteMBs = "io_write_mb_s"
+)
+
+// Alert actions
+const (
+	ActionLog     = "log"
+	ActionRestart = "restart"
+)
+
+// AlertConfig fires when a metric of a map stays above threshold for
+// duration_seconds. Map "*" or "" matches every map.
+type AlertConfig struct {
+	Name            string  `json:"name"`
+	Map             string  `json:"map"`
+	Metric          string  `json:"metric"`
+	Threshold       float64 `json:"threshold"`
+	DurationSeconds int     `json:"duration_seconds"`
+	Action          string  `json:"action"`
+}
+
+type MonitorConfig struct {
+	SampleIntervalSeconds int           `json:"sample_interval_seconds"`
+	HistoryMinutes        int           `json:"history_minutes"`
+	RestartTimeoutSeconds int           `json:"restart_timeout_seconds"`
+	Alerts                []AlertConfig `json:"alerts"`
+
+	Liveness   LivenessConfig   `json:"liveness"`
+	Players    PlayersConfig    `json:"players"`
+	Population PopulationConfig `json:"population"`
+	Query      QueryConfig      `json:"query"`
+	Listing    ListingConfig    `json:"listing"`
+}
+
+// Sample is one measurement of a map's server process
+type Sample struct {
+	Time       time.Time `json:"time"`
+	PID        int32     `json:"pid"`
+	CPUPercent float64   `json:"cpu_percent"`
+	MemoryMB   float64   `json:"memory_mb"`
+	IOReadMBs  float64   `json:"io_read_mb_s"`
+	IOWriteMBs float64   `json:"io_write_mb_s"`
+	DiskUsedMB float64   `json:"disk_used_mb"`
+	DiskFreeMB float64   `json:"disk_free_mb"`
+}
+
+func (s Sample) metric(name string) (float64, bool) {
+	switch name {
+	case MetricCPUPercent:
+		return s.CPUPercent, true
+	case MetricMemoryMB:
+		return s.MemoryMB, true
+	case MetricDiskUsedMB:
+		return s.DiskUsedMB, true
+	case MetricIOReadMBs:
+		return s.IOReadMBs, true
+	case MetricIOWriteMBs:
+		return s.IOWriteMBs, true
+	}
+	return 0, false
+}
+
+// AlertEvent records an alert that fired
+type AlertEvent struct {
+	Time   time.Time `json:"time"`
+	Alert  string    `json:"alert"`
+	Map    string    `json:"map"`
+	Metric string    `json:"metric"`
+	Value  float64   `json:"value"`
+	Action string    `json:"action"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// maxAlertEvents is how many fired alerts are kept per map
+const maxAlertEvents = 100
+
+// previous is the last reading of a process, CPU and I/O are rates between
+// two readings
+type previous struct {
+	pid     int32
+	time    time.Time
+	cpu     float64
+	read    uint64
+	written uint64
+}
+
+// alertState tracks since when an alert's condition holds for a map
+type alertState struct {
+	since time.Time
+	fired bool
+}
+
+type Monitor struct {
+	config   MonitorConfig
+	pm       *processmanager.ProcessManager
+	history  map[string][]Sample
+	events   map[string][]AlertEvent
+	prev     map[string]previous
+	alerts   map[string]*alertState
+	liveness map[string]*Liveness
+	queries  map[string]*Query
+	listings map[string]*Listing
+	players  map[string][]rcon.Player
+	mu       sync.Mutex
+}
+
+func NewMonitor(configFile string, pm *processmanager.ProcessManager) (*Monitor, error) {
+	config, err := LoadMonitorConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, alert := range config.Alerts {
+		if err := alert.validate(); err != nil {
+			return nil, fmt.Errorf("alert %s: %w", alert.Name, err)
+		}
+	}
+	if err := config.Listing.validate(); err != nil {
+		return nil, fmt.Errorf("listing: %w", err)
+	}
+
+	return &Monitor{
+		config:   config,
+		pm:       pm,
+		history:  make(map[string][]Sample),
+		events:   make(map[string][]AlertEvent),
+		prev:     make(map[string]previous),
+		alerts:   make(map[string]*alertState),
+		liveness: make(map[string]*Liveness),
+		queries:  make(map[string]*Query),
+		listings: make(map[string]*Listing),
+		players:  make(map[string][]rcon.Player),
+	}, nil
+}
+
+// LoadMonitorConfig reads the monitor settings, a missing file means the
+// defaults and no alerts
+func LoadMonitorConfig(filename string) (MonitorConfig, error) {
+	var config MonitorConfig
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return config, fmt.Errorf("failed to read monitor config: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("failed to parse monitor config: %w", err)
+		}
+	}
+
+	if config.SampleIntervalSeconds <= 0 {
+		config.SampleIntervalSeconds = defaultSampleIntervalSeconds
+	}
+	if config.HistoryMinutes <= 0 {
+		config.HistoryMinutes = defaultHistoryMinutes
+	}
+	if config.RestartTimeoutSeconds <= 0 {
+		config.RestartTimeoutSeconds = defaultRestartTimeoutSeconds
+	}
+	config.Liveness.setDefaults()
+	config.Players.setDefaults()
+	config.Population.setDefaults()
+	config.Query.setDefaults()
+	config.Listing.setDefaults()
+	return config, nil
+}
+
+func (a AlertConfig) validate() error {
+	if _, ok := (Sample{}).metric(a.Metric); !ok {
+		return fmt.Errorf("unknown metric %q", a.Metric)
+	}
+	if a.Action != ActionLog && a.Action != ActionRestart {
+		return fmt.Errorf("action must be %q or %q", ActionLog, ActionRestart)
+	}
+	if a.DurationSeconds < 0 {
+		return errors.New("duration_seconds must not be negative")
+	}
+	return nil
+}
+
+// History is how far back stats are kept
+func (m *Monitor) History() time.Duration {
+	return time.Duration(m.config.HistoryMinutes) * time.Minute
+}
+
+// Start samples every running map, probes their liveness, queries them,
+// looks them up in EOS and tracks their players and population in the
+// background
+func (m *Monitor) Start() {
+	m.startLiveness()
+	m.startQueries()
+	m.startListing()
+	m.startPlayers()
+	m.startPopulation()
+
+	interval := time.Duration(m.config.SampleIntervalSeconds) * time.Second
+	go func() {
+		for {
+			m.sampleAll()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func (m *Monitor) sampleAll() {
+	for _, ms := range m.pm.States() {
+		if ms.PID == 0 {
+			m.mu.Lock()
+			delete(m.prev, ms.Map)
+			m.mu.Unlock()
+			continue
+		}
+
+		config, _ := m.pm.Config(ms.Map)
+		sample, ok, err := m.sample(ms.Map, int32(ms.PID), filepath.Dir(config.Executable))
+		if err != nil {
+			log.Printf("Failed to sample process '%s': %v", ms.Map, err)
+			continue
+		}
+		if ok {
+			m.record(ms.Map, sample)
+		}
+	}
+}
+
+// sample measures a process. The first reading of a process only sets the
+// baseline for the rates and reports ok false.
+func (m *Monitor) sample(mapName string, pid int32, dir string) (Sample, bool, error) {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return Sample{}, false, err
+	}
+	times, err := proc.Times()
+	if err != nil {
+		return Sample{}, false, err
+	}
+	memory, err := proc.MemoryInfo()
+	if err != nil {
+		return Sample{}, false, err
+	}
+	var read, written uint64
+	if io, err := proc.IOCounters(); err == nil {
+		read, written = io.ReadBytes, io.WriteBytes
+	}
+
+	now := time.Now()
+	current := previous{pid: pid, time: now, cpu: times.User + times.System, read: read, written: written}
+
+	m.mu.Lock()
+	last, seen := m.prev[mapName]
+	m.prev[mapName] = current
+	m.mu.Unlock()
+	if !seen || last.pid != pid {
+		return Sample{}, false, nil
+	}
+
+	elapsed := now.Sub(last.time).Seconds()
+	if elapsed <= 0 {
+		return Sample{}, false, nil
+	}
+	cores, err := cpu.Counts(true)
+	if err != nil || cores == 0 {
+		cores = 1
+	}
+
+	sample := Sample{
+		Time:       now,
+		PID:        pid,
+		CPUPercent: (current.cpu - last.cpu) / elapsed / float64(cores) * 100,
+		MemoryMB:   float64(memory.RSS) / (1 << 20),
+		IOReadMBs:  float64(current.read-min(current.read, last.read)) / elapsed / (1 << 20),
+		IOWriteMBs: float64(current.written-min(current.written, last.written)) / elapsed / (1 << 20),
+	}
+	if usage, err := disk.Usage(dir); err == nil {
+		sample.DiskUsedMB = float64(usage.Used) / (1 << 20)
+		sample.DiskFreeMB = float64(usage.Free) / (1 << 20)
+	}
+	return sample, true, nil
+}
+
+func (m *Monitor) record(mapName string, sample Sample) {
+	m.mu.Lock()
+	cutoff := sample.Time.Add(-m.History())
+	samples := append(m.history[mapName], sample)
+	drop := 0
+	for drop < len(samples) && samples[drop].Time.Before(cutoff) {
+		drop++
+	}
+	m.history[mapName] = samples[drop:]
+	m.mu.Unlock()
+
+	m.checkAlerts(mapName, sample)
+}
+
+func (m *Monitor) checkAlerts(mapName string, sample Sample) {
+	for i, alert := range m.config.Alerts {
+		if alert.Map != "" && alert.Map != "*" && alert.Map != mapName {
+			continue
+		}
+		value, _ := sample.metric(alert.Metric)
+		key := fmt.Sprintf("%d/%s", i, mapName)
+
+		m.mu.Lock()
+		st, ok := m.alerts[key]
+		if !ok {
+			st = &alertState{}
+			m.alerts[key] = st
+		}
+		if value <= alert.Threshold {
+			// Fire again once the condition clears and returns
+			st.since = time.Time{}
+			st.fired = false
+			m.mu.Unlock()
+			continue
+		}
+		if st.since.IsZero() {
+			st.since = sample.Time
+		}
+		due := !st.fired && sample.Time.Sub(st.since) >= time.Duration(alert.DurationSeconds)*time.Second
+		if due {
+			st.fired = true
+		}
+		m.mu.Unlock()
+
+		if due {
+			go m.fire(alert, mapName, value)
+		}
+	}
+}
+
+func (m *Monitor) fire(alert AlertConfig, mapName string, value float64) {
+	event := AlertEvent{Time: time.Now(), Alert: alert.Name, Map: mapName, Metric: alert.Metric, Value: value, Action: alert.Action}
+	message := fmt.Sprintf("alert %s on map %s: %s is %.1f, above %.1f", alert.Name, mapName, alert.Metric, value, alert.Threshold)
+	log.Printf("Alert '%s' on map '%s': %s is %.1f, above %.1f", alert.Name, mapName, alert.Metric, value, alert.Threshold)
+	notify.Send(notify.EventResourceAlert, message)
+
+	if alert.Action == ActionRestart && maintenance.Active(mapName) {
+		event.Error = "not restarted, the map is in maintenance"
+	} else if alert.Action == ActionRestart {
+		timeout := time.Duration(m.config.RestartTimeoutSeconds) * time.Second
+		if err := m.pm.RestartProcess(mapName, timeout); err != nil {
+			log.Printf("Alert '%s' failed to restart map '%s': %v", alert.Name, mapName, err)
+			event.Error = err.Error()
+		}
+	}
+
+	m.mu.Lock()
+	events := append(m.events[mapName], event)
+	if len(events) > maxAlertEvents {
+		events = events[len(events)-maxAlertEvents:]
+	}
+	m.events[mapName] = events
+	m.mu.Unlock()
+}
+
+// Stats returns the samples of a map from the last span and the alerts that
+// fired in it
+func (m *Monitor) Stats(mapName string, span time.Duration) ([]Sample, []AlertEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	history, ok := m.history[mapName]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNoStats, mapName)
+	}
+
+	cutoff := time.Now().Add(-span)
+	samples := []Sample{}
+	for _, sample := range history {
+		if !sample.Time.Before(cutoff) {
+			samples = append(samples, sample)
+		}
+	}
+	events := []AlertEvent{}
+	for _, event := range m.events[mapName] {
+		if !event.Time.Before(cutoff) {
+			events = append(events, event)
+		}
+	}
+	return samples, events, nil
+}
@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/rcon"
+)
+
+const (
+	defaultPlayerIntervalSeconds = 60
+	playerListTimeout            = 10 * time.Second
+)
+
+// PlayersConfig polls the player list of each running map and publishes
+// player.joined and player.left events
+type PlayersConfig struct {
+	Enabled         bool `json:"enabled"`
+	IntervalSeconds int  `json:"interval_seconds"`
+}
+
+func (c *PlayersConfig) setDefaults() {
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = defaultPlayerIntervalSeconds
+	}
+}
+
+// startPlayers tracks the connected players in the background
+func (m *Monitor) startPlayers() {
+	config := m.config.Players
+	if !config.Enabled {
+		return
+	}
+	go func() {
+		for {
+			for _, ms := range m.pm.States() {
+				if !ms.Ready() {
+					m.setPlayers(ms.Map, nil)
+					continue
+				}
+				go m.pollPlayers(ms.Map)
+			}
+			time.Sleep(time.Duration(config.IntervalSeconds) * time.Second)
+		}
+	}()
+}
+
+func (m *Monitor) pollPlayers(mapName string) {
+	players, err := rcon.ListPlayers(mapName, playerListTimeout)
+	if err != nil {
+		// Loading servers do not answer yet, keep the last known list
+		return
+	}
+	m.setPlayers(mapName, players)
+}
+
+// setPlayers replaces the player list of a map and publishes who joined and
+// left. nil clears the list without events, the server is gone.
+func (m *Monitor) setPlayers(mapName string, players []rcon.Player) {
+	m.mu.Lock()
+	previous, known := m.players[mapName]
+	if players == nil {
+		delete(m.players, mapName)
+		m.mu.Unlock()
+		return
+	}
+	m.players[mapName] = players
+	m.mu.Unlock()
+
+	// The first list after a start is the baseline
+	if !known {
+		return
+	}
+	before := make(map[string]rcon.Player, len(previous))
+	for _, p := range previous {
+		before[p.ID] = p
+	}
+	for _, p := range players {
+		if _, ok := before[p.ID]; ok {
+			delete(before, p.ID)
+			continue
+		}
+		log.Printf("Player '%s' joined map '%s'", p.Name, mapName)
+		notify.Publish(notify.EventPlayerJoined, fmt.Sprintf("%s joined %s", p.Name, mapName), map[string]interface{}{"map": mapName, "name": p.Name, "id": p.ID})
+	}
+	for _, p := range before {
+		log.Printf("Player '%s' left map '%s'", p.Name, mapName)
+		notify.Publish(notify.EventPlayerLeft, fmt.Sprintf("%s left %s", p.Name, mapName), map[string]interface{}{"map": mapName, "name": p.Name, "id": p.ID})
+	}
+}
+
+// Players returns the players last seen on a map, false if the map is not
+// tracked
+func (m *Monitor) Players(mapName string) ([]rcon.Player, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	players, ok := m.players[mapName]
+	return append([]rcon.Player{}, players...), ok
+}
@@ -0,0 +1,254 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/state"
+)
+
+const (
+	bucketPopulation = "population"
+
+	defaultPopulationIntervalSeconds = 300
+	defaultPopulationRetentionDays   = 30
+	// populationDay is the layout of the day in a population key, samples
+	// are stored per map and day
+	populationDay = "2006-01-02"
+)
+
+// PopulationConfig records the player count of each map for graphs and to
+// find quiet hours
+type PopulationConfig struct {
+	Enabled         bool `json:"enabled"`
+	IntervalSeconds int  `json:"interval_seconds"`
+	RetentionDays   int  `json:"retention_days"`
+}
+
+func (c *PopulationConfig) setDefaults() {
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = defaultPopulationIntervalSeconds
+	}
+	if c.RetentionDays <= 0 {
+		c.RetentionDays = defaultPopulationRetentionDays
+	}
+}
+
+// PopulationSample is the player count of a map at a time. Samples of a
+// stopped or unresponsive server are not Online.
+type PopulationSample struct {
+	Time    time.Time `json:"time"`
+	Players int       `json:"players"`
+	Online  bool      `json:"online"`
+}
+
+// PopulationBucket summarizes the samples of one interval, the averages
+// only count samples of a running server
+type PopulationBucket struct {
+	Start   time.Time `json:"start"`
+	Average float64   `json:"average"`
+	Min     int       `json:"min"`
+	Max     int       `json:"max"`
+	Samples int       `json:"samples"`
+}
+
+// HourAverage is the average player count in an hour of the day, local
+// time
+type HourAverage struct {
+	Hour    int     `json:"hour"`
+	Average float64 `json:"average"`
+	Samples int     `json:"samples"`
+}
+
+// Population is the player count history of a map over a range
+type Population struct {
+	Map     string             `json:"map"`
+	Range   string             `json:"range"`
+	Bucket  string             `json:"bucket"`
+	Buckets []PopulationBucket `json:"buckets"`
+	// Hours are the hours of the day, quietest first
+	Hours []HourAverage `json:"hours"`
+	// QuietestHour is the start of the hour with the fewest players, a
+	// candidate for restarts and the maintenance window
+	QuietestHour string `json:"quietest_hour,omitempty"`
+}
+
+var ErrPopulationDisabled = errors.New("population history is not enabled")
+
+// ParseSpan parses a duration that may also be given in days, e.g. 7d
+func ParseSpan(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid span %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	span, err := time.ParseDuration(value)
+	if err != nil || span <= 0 {
+		return 0, fmt.Errorf("invalid span %q", value)
+	}
+	return span, nil
+}
+
+// PopulationRetention is how far back player counts are kept, zero if they
+// are not recorded
+func (m *Monitor) PopulationRetention() time.Duration {
+	if !m.config.Population.Enabled {
+		return 0
+	}
+	return time.Duration(m.config.Population.RetentionDays) * 24 * time.Hour
+}
+
+// startPopulation records the player counts in the background
+func (m *Monitor) startPopulation() {
+	config := m.config.Population
+	if !config.Enabled {
+		return
+	}
+	go func() {
+		lastPrune := ""
+		for {
+			for _, ms := range m.pm.States() {
+				m.recordPopulation(ms)
+			}
+			if today := time.Now().Format(populationDay); today != lastPrune {
+				lastPrune = today
+				m.prunePopulation()
+			}
+			time.Sleep(time.Duration(config.IntervalSeconds) * time.Second)
+		}
+	}()
+}
+
+func (m *Monitor) recordPopulation(ms processmanager.MapState) {
+	sample := PopulationSample{Time: time.Now()}
+	if ms.Ready() {
+		// The player tracker already knows the count
+		players, ok := m.Players(ms.Map)
+		if !ok || !m.config.Players.Enabled {
+			list, err := rcon.ListPlayers(ms.Map, playerListTimeout)
+			players, ok = list, err == nil
+		}
+		sample.Players = len(players)
+		sample.Online = ok
+	}
+
+	key := ms.Map + "/" + sample.Time.Format(populationDay)
+	var samples []PopulationSample
+	if _, err := state.Get(bucketPopulation, key, &samples); err != nil {
+		log.Printf("Failed to read population of %s: %v", ms.Map, err)
+		return
+	}
+	samples = append(samples, sample)
+	if err := state.Put(bucketPopulation, key, samples); err != nil {
+		log.Printf("Failed to record population of %s: %v", ms.Map, err)
+	}
+}
+
+// prunePopulation drops the days past the retention
+func (m *Monitor) prunePopulation() {
+	cutoff := time.Now().AddDate(0, 0, -m.config.Population.RetentionDays).Format(populationDay)
+	var expired []string
+	err := state.ForEach(bucketPopulation, func(key string, value []byte) error {
+		if i := strings.LastIndex(key, "/"); i >= 0 && key[i+1:] < cutoff {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read population history for pruning: %v", err)
+		return
+	}
+	for _, key := range expired {
+		if err := state.Delete(bucketPopulation, key); err != nil {
+			log.Printf("Failed to remove population history %s: %v", key, err)
+		}
+	}
+}
+
+// defaultBucket keeps graphs at a few hundred points at most
+func defaultBucket(span time.Duration) time.Duration {
+	switch {
+	case span <= 24*time.Hour:
+		return 15 * time.Minute
+	case span <= 7*24*time.Hour:
+		return time.Hour
+	default:
+		return 6 * time.Hour
+	}
+}
+
+// Population returns the player counts of a map over the last span in
+// buckets of the given size, a zero bucket picks one for the span
+func (m *Monitor) Population(mapName string, span time.Duration, bucket time.Duration) (Population, error) {
+	if !m.config.Population.Enabled {
+		return Population{}, ErrPopulationDisabled
+	}
+	if bucket <= 0 {
+		bucket = defaultBucket(span)
+	}
+
+	now := time.Now()
+	from := now.Add(-span)
+	var samples []PopulationSample
+	for day := from; day.Format(populationDay) <= now.Format(populationDay); day = day.AddDate(0, 0, 1) {
+		var daily []PopulationSample
+		if _, err := state.Get(bucketPopulation, mapName+"/"+day.Format(populationDay), &daily); err != nil {
+			return Population{}, err
+		}
+		for _, sample := range daily {
+			if !sample.Time.Before(from) && !sample.Time.After(now) {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+
+	population := Population{Map: mapName, Range: span.String(), Bucket: bucket.String(), Buckets: []PopulationBucket{}, Hours: []HourAverage{}}
+	var hourSum [24]int
+	var hourCount [24]int
+	var current *PopulationBucket
+	sum := 0
+	for _, sample := range samples {
+		start := sample.Time.Truncate(bucket)
+		if current == nil || !current.Start.Equal(start) {
+			population.Buckets = append(population.Buckets, PopulationBucket{Start: start})
+			current = &population.Buckets[len(population.Buckets)-1]
+			sum = 0
+		}
+		if !sample.Online {
+			continue
+		}
+		if current.Samples == 0 || sample.Players < current.Min {
+			current.Min = sample.Players
+		}
+		current.Max = max(current.Max, sample.Players)
+		current.Samples++
+		sum += sample.Players
+		current.Average = float64(sum) / float64(current.Samples)
+
+		hour := sample.Time.Local().Hour()
+		hourSum[hour] += sample.Players
+		hourCount[hour]++
+	}
+
+	for hour := 0; hour < 24; hour++ {
+		if hourCount[hour] == 0 {
+			continue
+		}
+		population.Hours = append(population.Hours, HourAverage{Hour: hour, Average: float64(hourSum[hour]) / float64(hourCount[hour]), Samples: hourCount[hour]})
+	}
+	sort.SliceStable(population.Hours, func(i, j int) bool { return population.Hours[i].Average < population.Hours[j].Average })
+	if len(population.Hours) > 0 {
+		population.QuietestHour = fmt.Sprintf("%02d:00", population.Hours[0].Hour)
+	}
+	return population, nil
+}
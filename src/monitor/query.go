@@ -0,0 +1,194 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"asa_servermanager_api/a2s"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+)
+
+const (
+	defaultQueryIntervalSeconds = 30
+	defaultQueryTimeoutSeconds  = 3
+	defaultQueryFailures        = 3
+	defaultQueryHost            = "127.0.0.1"
+)
+
+// QueryConfig queries each running map over its Steam query port, like
+// the server browser does. A server whose process runs but fails Failures
+// queries in a row is reported with a process.query_down event.
+type QueryConfig struct {
+	Enabled             bool   `json:"enabled"`
+	Host                string `json:"host"`
+	IntervalSeconds     int    `json:"interval_seconds"`
+	TimeoutSeconds      int    `json:"timeout_seconds"`
+	Failures            int    `json:"failures"`
+	StartupGraceSeconds int    `json:"startup_grace_seconds"`
+	// Ports sets the query port of maps whose process config does not
+	// name one, such as Docker containers
+	Ports map[string]int `json:"ports,omitempty"`
+	Maps  []string       `json:"maps,omitempty"`
+}
+
+// Query is the outcome of the queries of a map. Info is the last answer,
+// Down is set from the alert until the server answers again.
+type Query struct {
+	Port      int       `json:"port"`
+	Info      *a2s.Info `json:"info,omitempty"`
+	Failures  int       `json:"failures"`
+	LastOK    time.Time `json:"last_ok,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Down      bool      `json:"down,omitempty"`
+}
+
+func (c *QueryConfig) setDefaults() {
+	if c.Host == "" {
+		c.Host = defaultQueryHost
+	}
+	if c.IntervalSeconds <= 0 {
+		c.IntervalSeconds = defaultQueryIntervalSeconds
+	}
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = defaultQueryTimeoutSeconds
+	}
+	if c.Failures <= 0 {
+		c.Failures = defaultQueryFailures
+	}
+	if c.StartupGraceSeconds <= 0 {
+		c.StartupGraceSeconds = defaultStartupGraceSeconds
+	}
+}
+
+func (c QueryConfig) watches(mapName string) bool {
+	if len(c.Maps) == 0 {
+		return true
+	}
+	for _, m := range c.Maps {
+		if m == mapName {
+			return true
+		}
+	}
+	return false
+}
+
+// queryPort returns the query port of a map, 0 if it has none
+func (m *Monitor) queryPort(mapName string) int {
+	if port, ok := m.config.Query.Ports[mapName]; ok {
+		return port
+	}
+	config, ok := m.pm.Config(mapName)
+	if !ok {
+		return 0
+	}
+	return config.Ports()["query_port"]
+}
+
+// startQueries queries the running maps in the background
+func (m *Monitor) startQueries() {
+	config := m.config.Query
+	if !config.Enabled {
+		return
+	}
+	go func() {
+		for {
+			for _, ms := range m.pm.States() {
+				if !config.watches(ms.Map) {
+					continue
+				}
+				// Servers only advertise themselves once they have
+				// loaded their world
+				if ms.Actual != processmanager.ActualRunning || !ms.Ready() {
+					m.clearQuery(ms.Map)
+					continue
+				}
+				port := m.queryPort(ms.Map)
+				if port == 0 {
+					continue
+				}
+				grace := time.Since(ms.ReadySince) < time.Duration(config.StartupGraceSeconds)*time.Second
+				go m.query(ms.Map, port, grace)
+			}
+			time.Sleep(time.Duration(config.IntervalSeconds) * time.Second)
+		}
+	}()
+}
+
+// query asks a map's server for its info. Failures during the grace
+// period after it got ready are recorded but not counted.
+func (m *Monitor) query(mapName string, port int, grace bool) {
+	config := m.config.Query
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(port))
+	info, err := a2s.QueryInfo(addr, time.Duration(config.TimeoutSeconds)*time.Second)
+
+	m.mu.Lock()
+	q, ok := m.queries[mapName]
+	if !ok || q.Port != port {
+		q = &Query{Port: port}
+		m.queries[mapName] = q
+	}
+	if err == nil {
+		recovered := q.Down
+		q.Info = &info
+		q.Failures = 0
+		q.LastOK = time.Now()
+		q.LastError = ""
+		q.Down = false
+		m.mu.Unlock()
+		if recovered {
+			log.Printf("Query port %d of '%s' answers again", port, mapName)
+		}
+		return
+	}
+	q.LastError = err.Error()
+	if grace || maintenance.Active(mapName) {
+		m.mu.Unlock()
+		return
+	}
+	q.Failures++
+	down := q.Failures >= config.Failures && !q.Down
+	if down {
+		q.Down = true
+		q.Info = nil
+	}
+	failures := q.Failures
+	m.mu.Unlock()
+
+	if !down {
+		log.Printf("Query of '%s' on port %d failed (%d/%d): %v", mapName, port, failures, config.Failures, err)
+		return
+	}
+	message := fmt.Sprintf("map %s is running but its query port %d has not answered %d queries, it is missing from the server browser", mapName, port, failures)
+	log.Printf("Server '%s': %s", mapName, message)
+	notify.Publish(notify.EventQueryDown, message, map[string]interface{}{"map": mapName, "port": port, "failures": failures, "error": err.Error()})
+}
+
+func (m *Monitor) clearQuery(mapName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.queries, mapName)
+}
+
+// Query returns the outcome of the queries of a map, false if it is not
+// queried
+func (m *Monitor) Query(mapName string) (Query, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queries[mapName]
+	if !ok {
+		return Query{}, false
+	}
+	result := *q
+	if q.Info != nil {
+		info := *q.Info
+		result.Info = &info
+	}
+	return result, true
+}
@@ -0,0 +1,130 @@
+package motd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"asa_servermanager_api/configfile"
+)
+
+// dynamicDir holds the dynamicconfig.ini of each map, served to the game
+// servers at /dynamicconfig/<map>
+const dynamicDir = "./data/dynamicconfig"
+
+// dynamicKeys are the settings ASA reads from a dynamic config, all of them
+// multipliers
+var dynamicKeys = []string{
+	"BabyCuddleIntervalMultiplier",
+	"BabyFoodConsumptionSpeedMultiplier",
+	"BabyImprintAmountMultiplier",
+	"BabyMatureSpeedMultiplier",
+	"CropGrowthSpeedMultiplier",
+	"EggHatchSpeedMultiplier",
+	"HarvestAmountMultiplier",
+	"HexagonRewardMultiplier",
+	"MatingIntervalMultiplier",
+	"MatingSpeedMultiplier",
+	"TamingSpeedMultiplier",
+	"XPMultiplier",
+}
+
+// DynamicKeys returns the settings a dynamic config can change
+func DynamicKeys() []string {
+	return append([]string(nil), dynamicKeys...)
+}
+
+// canonicalKey returns the spelling of a dynamic config key, matched
+// case-insensitively
+func canonicalKey(key string) (string, bool) {
+	for _, k := range dynamicKeys {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// DynamicPath returns the dynamic config file of a map
+func DynamicPath(mapName string) string {
+	return filepath.Join(dynamicDir, mapName+".ini")
+}
+
+// ReadDynamic returns the dynamic config values of a map
+func ReadDynamic(mapName string) (map[string]string, error) {
+	values := make(map[string]string)
+	data, err := os.ReadFile(DynamicPath(mapName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return values, nil
+		}
+		return nil, fmt.Errorf("failed to read dynamic config: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok && key != "" {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values, nil
+}
+
+// WriteDynamic changes dynamic config values of a map, an empty value
+// removes the setting. It returns the previous values.
+func WriteDynamic(mapName string, changes map[string]string, caller string) (map[string]string, error) {
+	normalized := make(map[string]string, len(changes))
+	for key, value := range changes {
+		k, ok := canonicalKey(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a dynamic config setting", ErrInvalidValue, key)
+		}
+		if value != "" {
+			if f, err := strconv.ParseFloat(value, 64); err != nil || f < 0 {
+				return nil, fmt.Errorf("%w: %s must be a non-negative number", ErrInvalidValue, k)
+			}
+		}
+		normalized[k] = value
+	}
+
+	old, err := ReadDynamic(mapName)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(old))
+	for k, v := range old {
+		values[k] = v
+	}
+	var history []Change
+	for k, v := range normalized {
+		if old[k] == v {
+			continue
+		}
+		history = append(history, Change{Kind: KindDynamic, Key: k, Old: old[k], New: v, Caller: caller})
+		if v == "" {
+			delete(values, k)
+		} else {
+			values[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\r\n", k, values[k])
+	}
+	if err := os.MkdirAll(dynamicDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := configfile.WriteFile(DynamicPath(mapName), []byte(b.String())); err != nil {
+		return nil, err
+	}
+	return old, record(mapName, history)
+}
@@ -0,0 +1,157 @@
+package motd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/inidrift"
+	"asa_servermanager_api/state"
+)
+
+const (
+	sectionMOTD = "MessageOfTheDay"
+
+	bucketHistory = "motd_history"
+	maxHistory    = 50
+
+	// MaxMessageLength keeps the MOTD readable on screen and within what
+	// SetMessageOfTheDay accepts in one RCON packet
+	MaxMessageLength = 1000
+)
+
+var ErrInvalidValue = errors.New("invalid value")
+
+// historyMu serializes the read-modify-write of the history bucket
+var historyMu sync.Mutex
+
+// MOTD is the message shown to players when they join
+type MOTD struct {
+	Message string `json:"message"`
+	// Duration is how many seconds the message stays on screen
+	Duration int `json:"duration"`
+}
+
+// Change records a previous value
+type Change struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Key    string    `json:"key"`
+	Old    string    `json:"old"`
+	New    string    `json:"new"`
+	Caller string    `json:"caller"`
+}
+
+// Change kinds
+const (
+	KindMOTD    = "motd"
+	KindDynamic = "dynamic"
+)
+
+// ReadMOTD returns the MOTD from GameUserSettings.ini in iniDir
+func ReadMOTD(iniDir string) (MOTD, error) {
+	data, err := os.ReadFile(filepath.Join(iniDir, ini.GameUserSettings))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return MOTD{}, fmt.Errorf("failed to read %s: %w", ini.GameUserSettings, err)
+	}
+	f := ini.Parse(data)
+
+	var m MOTD
+	if values := f.Get(sectionMOTD, "Message"); len(values) > 0 {
+		m.Message = values[0]
+	}
+	if values := f.Get(sectionMOTD, "Duration"); len(values) > 0 {
+		m.Duration, _ = strconv.Atoi(values[0])
+	}
+	return m, nil
+}
+
+// Validate checks a MOTD before it is written
+func (m MOTD) Validate() error {
+	switch {
+	case strings.ContainsAny(m.Message, "\r\n"):
+		return fmt.Errorf("%w: message must be a single line, use \\n for line breaks", ErrInvalidValue)
+	case len(m.Message) > MaxMessageLength:
+		return fmt.Errorf("%w: message must not be longer than %d characters", ErrInvalidValue, MaxMessageLength)
+	case m.Duration < 0:
+		return fmt.Errorf("%w: duration must not be negative", ErrInvalidValue)
+	}
+	return nil
+}
+
+// WriteMOTD sets the MOTD in GameUserSettings.ini and records the previous
+// one in the map's history
+func WriteMOTD(mapName string, iniDir string, m MOTD, caller string) (MOTD, error) {
+	if err := m.Validate(); err != nil {
+		return MOTD{}, err
+	}
+	old, err := ReadMOTD(iniDir)
+	if err != nil {
+		return MOTD{}, err
+	}
+
+	path := filepath.Join(iniDir, ini.GameUserSettings)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return MOTD{}, fmt.Errorf("failed to read %s: %w", ini.GameUserSettings, err)
+	}
+	f := ini.Parse(data)
+	f.Set(sectionMOTD, "Message", m.Message)
+	if m.Duration > 0 {
+		f.Set(sectionMOTD, "Duration", strconv.Itoa(m.Duration))
+	}
+	if err := inidrift.Write(mapName, ini.GameUserSettings, path, f.Bytes()); err != nil {
+		return MOTD{}, err
+	}
+
+	var changes []Change
+	if old.Message != m.Message {
+		changes = append(changes, Change{Kind: KindMOTD, Key: "Message", Old: old.Message, New: m.Message, Caller: caller})
+	}
+	if m.Duration > 0 && old.Duration != m.Duration {
+		changes = append(changes, Change{Kind: KindMOTD, Key: "Duration", Old: strconv.Itoa(old.Duration), New: strconv.Itoa(m.Duration), Caller: caller})
+	}
+	return old, record(mapName, changes)
+}
+
+// History returns the recorded changes of a map, newest first
+func History(mapName string) ([]Change, error) {
+	var changes []Change
+	if _, err := state.Get(bucketHistory, mapName, &changes); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.After(changes[j].Time) })
+	if changes == nil {
+		changes = []Change{}
+	}
+	return changes, nil
+}
+
+func record(mapName string, changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	var history []Change
+	if _, err := state.Get(bucketHistory, mapName, &history); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, c := range changes {
+		c.Time = now
+		history = append(history, c)
+	}
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	return state.Put(bucketHistory, mapName, history)
+}
@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types sent by the manager
+const (
+	EventProcessHang   = "process.hang"
+	EventResourceAlert = "process.alert"
+	EventDiskLow       = "disk.low"
+
+	EventUpdateAvailable = "update.available"
+	EventUpdateCompleted = "update.completed"
+	EventUpdateFailed    = "update.failed"
+
+	EventModUpdated = "mod.updated"
+
+	EventTransferProblem = "cluster.transfer"
+	EventConfigDrift     = "config.drift"
+
+	EventProcessStarted = "process.started"
+	EventProcessReady   = "process.ready"
+	EventStartupFailed  = "process.startup_failed"
+	EventCrashRecovery  = "process.recovery"
+	EventProcessStopped = "process.stopped"
+	EventProcessCrashed = "process.crashed"
+	EventProcessDrained = "process.drained"
+	EventPortConflict   = "process.port_conflict"
+	EventQueryDown      = "process.query_down"
+
+	EventProcessUnlisted = "process.unlisted"
+
+	EventIncident = "process.incident"
+
+	EventMaintenanceStarted = "maintenance.started"
+	EventMaintenanceEnded   = "maintenance.ended"
+
+	EventBackupCompleted = "backup.completed"
+	EventBackupFailed    = "backup.failed"
+
+	EventSelfBackupFailed = "selfbackup.failed"
+
+	EventWipeFailed = "wipe.failed"
+
+	EventLogAlert = "log.alert"
+
+	EventPlayerJoined = "player.joined"
+	EventPlayerLeft   = "player.left"
+
+	// Only streamed to live subscribers, see Stream
+	EventProcessState   = "process.state"
+	EventBackupJob      = "backup.job"
+	EventBackupProgress = "backup.progress"
+	EventJob            = "job"
+	EventRconCommand    = "rcon.command"
+	EventGameLog        = "gamelog"
+)
+
+// DiscordWebhook posts notifications to a Discord channel. Events limits it
+// to the listed event types, a trailing "*" matches a prefix, empty means
+// all events.
+type DiscordWebhook struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+type NotifyConfig struct {
+	DiscordWebhooks []DiscordWebhook `json:"discord_webhooks"`
+}
+
+var (
+	config   NotifyConfig
+	configMu sync.RWMutex
+
+	client = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Load reads the notification targets, a missing file disables
+// notifications
+func Load(configFile string) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read notify config: %w", err)
+	}
+
+	var loaded NotifyConfig
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse notify config: %w", err)
+	}
+	for _, hook := range loaded.DiscordWebhooks {
+		if !strings.HasPrefix(hook.URL, "https://") {
+			return fmt.Errorf("discord webhook %s: url must be https", hook.Name)
+		}
+	}
+
+	configMu.Lock()
+	config = loaded
+	configMu.Unlock()
+	return nil
+}
+
+// Send delivers a notification to every webhook subscribed to event in the
+// background. Failures are logged.
+func Send(event string, message string) {
+	Publish(event, message, nil)
+}
+
+// Publish is Send with structured data for the outgoing webhooks, Discord
+// only gets the message
+func Publish(event string, message string, data map[string]interface{}) {
+	payload := newPayload(event, message, data)
+	broadcast(payload)
+	enqueue(payload)
+
+	configMu.RLock()
+	hooks := config.DiscordWebhooks
+	configMu.RUnlock()
+
+	for _, hook := range hooks {
+		if !subscribed(hook.Events, event) {
+			continue
+		}
+		go func(hook DiscordWebhook) {
+			if err := postDiscord(hook.URL, fmt.Sprintf("**%s**: %s", event, message)); err != nil {
+				log.Printf("Failed to send %s notification to %s: %v", event, hook.Name, err)
+			}
+		}(hook)
+	}
+}
+
+func subscribed(events []string, event string) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == event || (strings.HasSuffix(e, "*") && strings.HasPrefix(event, strings.TrimSuffix(e, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func postDiscord(url string, content string) error {
+	// Discord rejects messages over 2000 characters
+	if len(content) > 2000 {
+		content = content[:1997] + "..."
+	}
+	body, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"sync"
+)
+
+// Live subscribers, such as the /events feed, receive every event as it
+// happens. Nothing is kept for them, a subscriber that falls behind misses
+// events instead of blocking the publisher.
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   = map[chan Payload][]string{}
+)
+
+// Subscribe registers a live subscriber for events, matched like the
+// events of a webhook, with room for buffer pending events. The returned
+// function unsubscribes and closes the channel.
+func Subscribe(events []string, buffer int) (<-chan Payload, func()) {
+	ch := make(chan Payload, buffer)
+
+	subscribersMu.Lock()
+	subscribers[ch] = events
+	subscribersMu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			subscribersMu.Lock()
+			delete(subscribers, ch)
+			subscribersMu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Stream sends an event to the live subscribers only. It is meant for
+// frequent events that are not worth a Discord message or a webhook
+// delivery.
+func Stream(event string, message string, data map[string]interface{}) {
+	broadcast(newPayload(event, message, data))
+}
+
+func broadcast(payload Payload) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	for ch, events := range subscribers {
+		if !subscribed(events, payload.Event) {
+			continue
+		}
+		select {
+		case ch <- payload:
+		default:
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/state"
+)
+
+// Outgoing webhooks receive every event they subscribe to as a signed JSON
+// POST. Deliveries are kept in the state store so retries survive a
+// restart of the manager.
+
+const (
+	bucketDeliveries = "webhook_deliveries"
+
+	// maxDeliveries is how many deliveries are kept per webhook
+	maxDeliveries = 100
+	maxAttempts   = 5
+	firstRetry    = 10 * time.Second
+
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body
+	// keyed with the webhook's secret
+	SignatureHeader = "X-Signature-256"
+	EventHeader     = "X-Event"
+	DeliveryHeader  = "X-Delivery"
+)
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrWebhookExists   = errors.New("webhook already exists")
+	ErrInvalidWebhook  = errors.New("invalid webhook")
+
+	webhookNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// Webhook subscribes a URL to events. Events match like those of a
+// DiscordWebhook, a trailing "*" matches a prefix and empty means all.
+type Webhook struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret,omitempty"`
+	Events  []string `json:"events,omitempty"`
+	Enabled bool     `json:"enabled"`
+}
+
+type WebhooksConfig struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID      string                 `json:"id"`
+	Event   string                 `json:"event"`
+	Time    time.Time              `json:"time"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Delivery is the delivery of one event to one webhook
+type Delivery struct {
+	Payload
+	Webhook    string    `json:"webhook"`
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts"`
+	NextTry    time.Time `json:"next_try,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+var (
+	webhooksFile string
+	webhooks     = make(map[string]Webhook)
+	// outboxMu guards webhooks and the delivery lists in the state store
+	outboxMu sync.Mutex
+)
+
+func (h Webhook) validate() error {
+	if !webhookNamePattern.MatchString(h.Name) {
+		return fmt.Errorf("%w: name must be 1-64 letters, digits, '-' or '_'", ErrInvalidWebhook)
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an http or https URL", ErrInvalidWebhook)
+	}
+	return nil
+}
+
+// LoadWebhooks reads the outgoing webhooks and resumes the deliveries that
+// were pending when the manager stopped. A missing file means none.
+func LoadWebhooks(configFile string) error {
+	var loaded WebhooksConfig
+	data, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read webhooks config: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return fmt.Errorf("failed to parse webhooks config: %w", err)
+		}
+	}
+
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	webhooksFile = configFile
+	webhooks = make(map[string]Webhook)
+	for _, hook := range loaded.Webhooks {
+		if err := hook.validate(); err != nil {
+			return fmt.Errorf("webhook %s: %w", hook.Name, err)
+		}
+		webhooks[hook.Name] = hook
+	}
+
+	for name := range webhooks {
+		deliveries, err := deliveriesLocked(name)
+		if err != nil {
+			return err
+		}
+		for _, d := range deliveries {
+			if d.Status == DeliveryPending {
+				schedule(d.Webhook, d.ID, time.Until(d.NextTry))
+			}
+		}
+	}
+	return nil
+}
+
+func saveWebhooksLocked() error {
+	config := WebhooksConfig{Webhooks: make([]Webhook, 0, len(webhooks))}
+	for _, name := range webhookNamesLocked() {
+		config.Webhooks = append(config.Webhooks, webhooks[name])
+	}
+	return configfile.WriteJSON(webhooksFile, config)
+}
+
+func webhookNamesLocked() []string {
+	names := make([]string, 0, len(webhooks))
+	for name := range webhooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Webhooks returns the outgoing webhooks sorted by name, secrets are
+// masked
+func Webhooks() []Webhook {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	hooks := make([]Webhook, 0, len(webhooks))
+	for _, name := range webhookNamesLocked() {
+		hooks = append(hooks, webhooks[name].masked())
+	}
+	return hooks
+}
+
+func (h Webhook) masked() Webhook {
+	if h.Secret != "" {
+		h.Secret = "********"
+	}
+	return h
+}
+
+// CreateWebhook adds an outgoing webhook and persists it
+func CreateWebhook(hook Webhook) (Webhook, error) {
+	if err := hook.validate(); err != nil {
+		return Webhook{}, err
+	}
+
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	if _, exists := webhooks[hook.Name]; exists {
+		return Webhook{}, fmt.Errorf("%w: %s", ErrWebhookExists, hook.Name)
+	}
+	webhooks[hook.Name] = hook
+	if err := saveWebhooksLocked(); err != nil {
+		delete(webhooks, hook.Name)
+		return Webhook{}, err
+	}
+	return hook.masked(), nil
+}
+
+// UpdateWebhook replaces an outgoing webhook, an empty secret keeps the
+// current one
+func UpdateWebhook(hook Webhook) (Webhook, error) {
+	if err := hook.validate(); err != nil {
+		return Webhook{}, err
+	}
+
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	previous, exists := webhooks[hook.Name]
+	if !exists {
+		return Webhook{}, fmt.Errorf("%w: %s", ErrWebhookNotFound, hook.Name)
+	}
+	if hook.Secret == "" {
+		hook.Secret = previous.Secret
+	}
+	webhooks[hook.Name] = hook
+	if err := saveWebhooksLocked(); err != nil {
+		webhooks[hook.Name] = previous
+		return Webhook{}, err
+	}
+	return hook.masked(), nil
+}
+
+// DeleteWebhook removes an outgoing webhook and its delivery history
+func DeleteWebhook(name string) error {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	previous, exists := webhooks[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrWebhookNotFound, name)
+	}
+	delete(webhooks, name)
+	if err := saveWebhooksLocked(); err != nil {
+		webhooks[name] = previous
+		return err
+	}
+	return state.Delete(bucketDeliveries, name)
+}
+
+// Deliveries returns the recent deliveries of a webhook, newest first
+func Deliveries(name string) ([]Delivery, error) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+
+	if _, exists := webhooks[name]; !exists {
+		return nil, fmt.Errorf("%w: %s", ErrWebhookNotFound, name)
+	}
+	deliveries, err := deliveriesLocked(name)
+	if err != nil {
+		return nil, err
+	}
+	newest := make([]Delivery, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		newest = append(newest, deliveries[i])
+	}
+	return newest, nil
+}
+
+func deliveriesLocked(name string) ([]Delivery, error) {
+	var deliveries []Delivery
+	if _, err := state.Get(bucketDeliveries, name, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func newPayload(event string, message string, data map[string]interface{}) Payload {
+	return Payload{
+		ID:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		Event:   event,
+		Time:    time.Now(),
+		Message: message,
+		Data:    data,
+	}
+}
+
+// enqueue stores a delivery of the payload for every subscribed webhook and
+// sends them in the background
+func enqueue(payload Payload) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	if len(webhooks) == 0 {
+		return
+	}
+
+	event := payload.Event
+	for _, name := range webhookNamesLocked() {
+		hook := webhooks[name]
+		if !hook.Enabled || !subscribed(hook.Events, event) {
+			continue
+		}
+		deliveries, err := deliveriesLocked(name)
+		if err != nil {
+			log.Printf("Failed to queue %s for webhook %s: %v", event, name, err)
+			continue
+		}
+		deliveries = append(deliveries, Delivery{Payload: payload, Webhook: name, Status: DeliveryPending, NextTry: payload.Time})
+		if len(deliveries) > maxDeliveries {
+			deliveries = deliveries[len(deliveries)-maxDeliveries:]
+		}
+		if err := state.Put(bucketDeliveries, name, deliveries); err != nil {
+			log.Printf("Failed to queue %s for webhook %s: %v", event, name, err)
+			continue
+		}
+		schedule(name, payload.ID, 0)
+	}
+}
+
+func schedule(webhook string, id string, delay time.Duration) {
+	time.AfterFunc(max(delay, 0), func() { deliver(webhook, id) })
+}
+
+// deliver makes one attempt of a delivery and schedules the next one with
+// exponential backoff if it fails
+func deliver(webhook string, id string) {
+	outboxMu.Lock()
+	hook, exists := webhooks[webhook]
+	d, found := findDeliveryLocked(webhook, id)
+	outboxMu.Unlock()
+	if !exists || !found || d.Status != DeliveryPending {
+		return
+	}
+
+	statusCode, err := post(hook, d.Payload)
+
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	deliveries, readErr := deliveriesLocked(webhook)
+	if readErr != nil {
+		log.Printf("Failed to record delivery %s to webhook %s: %v", id, webhook, readErr)
+		return
+	}
+	for i := range deliveries {
+		d := &deliveries[i]
+		if d.ID != id {
+			continue
+		}
+		d.Attempts++
+		d.StatusCode = statusCode
+		switch {
+		case err == nil:
+			d.Status = DeliveryDelivered
+			d.Error = ""
+			d.NextTry = time.Time{}
+		case d.Attempts >= maxAttempts:
+			d.Status = DeliveryFailed
+			d.Error = err.Error()
+			d.NextTry = time.Time{}
+			log.Printf("Giving up on delivering %s to webhook %s after %d attempts: %v", d.Event, webhook, d.Attempts, err)
+		default:
+			d.Error = err.Error()
+			delay := firstRetry << (d.Attempts - 1)
+			d.NextTry = time.Now().Add(delay)
+			schedule(webhook, id, delay)
+		}
+		break
+	}
+	if err := state.Put(bucketDeliveries, webhook, deliveries); err != nil {
+		log.Printf("Failed to record delivery %s to webhook %s: %v", id, webhook, err)
+	}
+}
+
+func findDeliveryLocked(webhook string, id string) (Delivery, bool) {
+	deliveries, err := deliveriesLocked(webhook)
+	if err != nil {
+		return Delivery{}, false
+	}
+	for _, d := range deliveries {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return Delivery{}, false
+}
+
+// Sign returns the signature header value of body for a secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func post(hook Webhook, payload Payload) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	req, err := newJSONRequest(hook.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(EventHeader, payload.Event)
+	req.Header.Set(DeliveryHeader, payload.ID)
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func newJSONRequest(url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
@@ -0,0 +1,516 @@
+package processmanager
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/state"
+)
+
+// Maps with a docker config run as a container instead of a process. The
+// manager talks to the Docker Engine API at DOCKER_HOST, by default the
+// local socket, and names each container asa-<map>. The container's host PID
+// stands in for the process PID, so stats and restarts work the same way.
+
+const (
+	dockerAPIVersion  = "v1.41"
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	containerPrefix   = "asa-"
+	mapLabel          = "asa_servermanager.map"
+
+	// dockerRequestTimeout bounds every Docker API call except those that
+	// follow a container, its logs and its exit
+	dockerRequestTimeout = 30 * time.Second
+
+	// HealthUnhealthy is the health of a container whose health check
+	// failed, the monitor kills it and starts it again
+	HealthUnhealthy = "unhealthy"
+)
+
+var (
+	ErrInvalidDocker     = errors.New("invalid docker configuration")
+	errContainerNotFound = errors.New("container not found")
+	errImageNotFound     = errors.New("image not found")
+)
+
+// DockerConfig runs a map's server as a container of Image. The server
+// arguments are passed as the container command. Executable still locates
+// the install and its INI files on this machine, mount it into the
+// container.
+type DockerConfig struct {
+	Image  string            `json:"image"`
+	Mounts []DockerMount     `json:"mounts,omitempty"`
+	Env    map[string]string `json:"env,omitempty"`
+	Ports  []DockerPort      `json:"ports,omitempty"`
+	// Network is the container's network mode, e.g. "host", by default the
+	// bridge network
+	Network     string             `json:"network,omitempty"`
+	HealthCheck *DockerHealthCheck `json:"health_check,omitempty"`
+}
+
+// DockerMount binds a directory of this machine into the container
+type DockerMount struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// DockerPort publishes a container port, Protocol is "udp" or "tcp"
+type DockerPort struct {
+	Host      int    `json:"host"`
+	Container int    `json:"container"`
+	Protocol  string `json:"protocol,omitempty"`
+}
+
+// DockerHealthCheck is run by Docker inside the container. Test is in
+// Docker's form, e.g. ["CMD-SHELL", "pgrep ArkAscendedServer"].
+type DockerHealthCheck struct {
+	Test               []string `json:"test"`
+	IntervalSeconds    int      `json:"interval_seconds,omitempty"`
+	TimeoutSeconds     int      `json:"timeout_seconds,omitempty"`
+	Retries            int      `json:"retries,omitempty"`
+	StartPeriodSeconds int      `json:"start_period_seconds,omitempty"`
+}
+
+func (p DockerPort) protocol() string {
+	if p.Protocol == "" {
+		return "udp"
+	}
+	return p.Protocol
+}
+
+// Validate checks the values of the docker config
+func (dc *DockerConfig) Validate() error {
+	if dc.Image == "" || strings.ContainsAny(dc.Image, " \t\r\n") {
+		return fmt.Errorf("%w: image must be an image name such as repo/asa-server:latest", ErrInvalidDocker)
+	}
+	for _, m := range dc.Mounts {
+		if !filepath.IsAbs(m.Source) || !strings.HasPrefix(m.Target, "/") {
+			return fmt.Errorf("%w: mount %q -> %q needs an absolute source and target", ErrInvalidDocker, m.Source, m.Target)
+		}
+	}
+	for key := range dc.Env {
+		if key == "" || strings.ContainsAny(key, "= \t\r\n") {
+			return fmt.Errorf("%w: env name %q is invalid", ErrInvalidDocker, key)
+		}
+	}
+	for _, p := range dc.Ports {
+		if p.Host <= 0 || p.Host > 65535 || p.Container <= 0 || p.Container > 65535 {
+			return fmt.Errorf("%w: ports must be port numbers", ErrInvalidDocker)
+		}
+		if p.protocol() != "udp" && p.protocol() != "tcp" {
+			return fmt.Errorf("%w: port protocol must be udp or tcp", ErrInvalidDocker)
+		}
+	}
+	if dc.HealthCheck != nil && len(dc.HealthCheck.Test) == 0 {
+		return fmt.Errorf("%w: health_check needs a test", ErrInvalidDocker)
+	}
+	return nil
+}
+
+func containerName(mapName string) string {
+	return containerPrefix + mapName
+}
+
+// dockerClient calls the Docker Engine API
+type dockerClient struct {
+	client *http.Client
+	base   string
+}
+
+// newDockerClient connects to DOCKER_HOST, unix:// and tcp:// hosts are
+// supported
+func newDockerClient() (*dockerClient, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKER_HOST %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &dockerClient{client: &http.Client{Transport: transport}, base: "http://docker/" + dockerAPIVersion}, nil
+	case "tcp", "http":
+		return &dockerClient{client: &http.Client{}, base: "http://" + u.Host + "/" + dockerAPIVersion}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DOCKER_HOST %q, use unix:// or tcp://", host)
+	}
+}
+
+// do calls the API and decodes the response into out. Callers following a
+// stream pass their own context, others get dockerRequestTimeout.
+func (d *dockerClient) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+	resp, err := d.open(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// open calls the API and returns the response, its body must be closed
+func (d *dockerClient) open(ctx context.Context, method string, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := d.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+	if resp.StatusCode == http.StatusNotFound {
+		if strings.Contains(strings.ToLower(apiErr.Message), "image") {
+			return nil, fmt.Errorf("%w: %s", errImageNotFound, apiErr.Message)
+		}
+		return nil, fmt.Errorf("%w: %s", errContainerNotFound, apiErr.Message)
+	}
+	return nil, fmt.Errorf("docker %s %s: %s: %s", method, path, resp.Status, apiErr.Message)
+}
+
+func (d *dockerClient) call(method string, path string, query url.Values, body interface{}, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerRequestTimeout)
+	defer cancel()
+	return d.do(ctx, method, path, query, body, out)
+}
+
+// containerState is the part of a container inspection the manager uses
+type containerState struct {
+	Running  bool `json:"Running"`
+	Pid      int  `json:"Pid"`
+	ExitCode int  `json:"ExitCode"`
+	Health   *struct {
+		Status string `json:"Status"`
+	} `json:"Health"`
+}
+
+func (s containerState) health() string {
+	if s.Health == nil {
+		return ""
+	}
+	return s.Health.Status
+}
+
+func (d *dockerClient) inspect(name string) (containerState, error) {
+	var info struct {
+		State containerState `json:"State"`
+	}
+	err := d.call(http.MethodGet, "/containers/"+name+"/json", nil, nil, &info)
+	return info.State, err
+}
+
+// createBody is the container create request for a map
+func createBody(config ProcessConfig) map[string]interface{} {
+	dc := config.Docker
+
+	env := make([]string, 0, len(dc.Env))
+	for _, key := range sortedKeys(dc.Env) {
+		env = append(env, key+"="+dc.Env[key])
+	}
+	exposed := make(map[string]struct{})
+	bindings := make(map[string][]map[string]string)
+	for _, p := range dc.Ports {
+		port := strconv.Itoa(p.Container) + "/" + p.protocol()
+		exposed[port] = struct{}{}
+		bindings[port] = append(bindings[port], map[string]string{"HostPort": strconv.Itoa(p.Host)})
+	}
+	mounts := make([]map[string]interface{}, 0, len(dc.Mounts))
+	for _, m := range dc.Mounts {
+		mounts = append(mounts, map[string]interface{}{"Type": "bind", "Source": m.Source, "Target": m.Target, "ReadOnly": m.ReadOnly})
+	}
+
+	hostConfig := map[string]interface{}{
+		"Mounts":       mounts,
+		"PortBindings": bindings,
+	}
+	if dc.Network != "" {
+		hostConfig["NetworkMode"] = dc.Network
+	}
+	body := map[string]interface{}{
+		"Image":        dc.Image,
+		"Cmd":          config.CommandArgs(),
+		"Env":          env,
+		"Labels":       map[string]string{mapLabel: config.Map},
+		"ExposedPorts": exposed,
+		"HostConfig":   hostConfig,
+	}
+	if hc := dc.HealthCheck; hc != nil {
+		body["Healthcheck"] = map[string]interface{}{
+			"Test":        hc.Test,
+			"Interval":    time.Duration(hc.IntervalSeconds) * time.Second,
+			"Timeout":     time.Duration(hc.TimeoutSeconds) * time.Second,
+			"Retries":     hc.Retries,
+			"StartPeriod": time.Duration(hc.StartPeriodSeconds) * time.Second,
+		}
+	}
+	return body
+}
+
+// create replaces the map's container, pulling the image if it is missing,
+// so a changed docker config applies with the next start
+func (d *dockerClient) create(config ProcessConfig) error {
+	name := containerName(config.Map)
+	if err := d.remove(name); err != nil {
+		return err
+	}
+
+	query := url.Values{"name": {name}}
+	err := d.call(http.MethodPost, "/containers/create", query, createBody(config), nil)
+	if errors.Is(err, errImageNotFound) {
+		log.Printf("Pulling image %s for map '%s'", config.Docker.Image, config.Map)
+		if err := d.pull(config.Docker.Image); err != nil {
+			return err
+		}
+		err = d.call(http.MethodPost, "/containers/create", query, createBody(config), nil)
+	}
+	return err
+}
+
+func (d *dockerClient) pull(image string) error {
+	name, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	// Pulls can take long, the progress stream ends when it is done
+	return d.do(context.Background(), http.MethodPost, "/images/create", url.Values{"fromImage": {name}, "tag": {tag}}, nil, nil)
+}
+
+// remove deletes a container, missing containers are fine
+func (d *dockerClient) remove(name string) error {
+	err := d.call(http.MethodDelete, "/containers/"+name, url.Values{"force": {"1"}}, nil, nil)
+	if errors.Is(err, errContainerNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (d *dockerClient) start(name string) error {
+	return d.call(http.MethodPost, "/containers/"+name+"/start", nil, nil, nil)
+}
+
+func (d *dockerClient) kill(name string) error {
+	return d.call(http.MethodPost, "/containers/"+name+"/kill", nil, nil, nil)
+}
+
+// wait blocks until the container exits and returns its exit code
+func (d *dockerClient) wait(name string) (int, error) {
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := d.do(context.Background(), http.MethodPost, "/containers/"+name+"/wait", nil, nil, &result); err != nil {
+		return 0, err
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return result.StatusCode, errors.New(result.Error.Message)
+	}
+	return result.StatusCode, nil
+}
+
+// followLogs writes the container's output to the log until it exits.
+// Without a TTY Docker multiplexes stdout and stderr in frames with an 8
+// byte header.
+func (d *dockerClient) followLogs(name string, logFile *RotatingLog) error {
+	query := url.Values{"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	resp, err := d.open(context.Background(), http.MethodGet, "/containers/"+name+"/logs", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(demux(resp.Body, pw))
+	}()
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		if err := logFile.WriteLine(scanner.Text()); err != nil {
+			log.Printf("Failed to write log: %v", err)
+		}
+	}
+	pr.Close()
+	return scanner.Err()
+}
+
+func demux(r io.Reader, w io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// startContainer is startProcess for maps with a docker config
+func (pm *ProcessManager) startContainer(ctx context.Context, mapName string, config ProcessConfig) {
+	if ctx.Err() != nil {
+		return
+	}
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Printf("Failed to start container of '%s': %v", mapName, err)
+		return
+	}
+	name := containerName(mapName)
+
+	if err := docker.create(config); err != nil {
+		log.Printf("Failed to create container of '%s': %v", mapName, err)
+		return
+	}
+
+	// Archive the log of the previous run and start a new one
+	logFile, err := OpenLog(mapName, pm.logConfig)
+	if err != nil {
+		log.Printf("Error creating new log file: %v", err)
+		return
+	}
+	if err := docker.start(name); err != nil {
+		log.Printf("Failed to start container of '%s': %v", mapName, err)
+		logFile.Close()
+		return
+	}
+	cs, err := docker.inspect(name)
+	if err != nil || !cs.Running {
+		log.Printf("Container of '%s' is not running after start: %v", mapName, err)
+		logFile.Close()
+		return
+	}
+	pid := cs.Pid
+	logFile.watch = pm.watchConsole(mapName, pid, config)
+
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		if err := docker.followLogs(name, logFile); err != nil {
+			log.Printf("Failed to follow logs of container '%s': %v", name, err)
+		}
+	}()
+
+	if err := state.SetProcessPID(mapName, pid); err != nil {
+		log.Printf("Failed to save PID for process '%s': %v", mapName, err)
+		docker.kill(name)
+	} else {
+		log.Printf("Container '%s' of '%s' started successfully with PID %d", name, mapName, pid)
+		pm.mu.Lock()
+		ms := pm.stateLocked(mapName)
+		ms.PID = pid
+		ms.Health = cs.health()
+		ms.transitionLocked(ms.Desired, ActualRunning, "container started")
+		ms.startingLocked()
+		pm.mu.Unlock()
+	}
+
+	go func() {
+		code, err := docker.wait(name)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("container exited with status %d", code)
+		}
+		<-logsDone
+		logFile.Close()
+		if err != nil {
+			log.Printf("Process '%s' exited with error: %v", mapName, err)
+		}
+		pm.processExited(mapName, pid, err)
+	}()
+}
+
+// containerRunning reports whether a map's container runs and its health,
+// "" without a health check
+func containerRunning(mapName string) (bool, int, string) {
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Printf("Failed to check container of '%s': %v", mapName, err)
+		return false, 0, ""
+	}
+	cs, err := docker.inspect(containerName(mapName))
+	if err != nil {
+		if !errors.Is(err, errContainerNotFound) {
+			log.Printf("Failed to check container of '%s': %v", mapName, err)
+		}
+		return false, 0, ""
+	}
+	return cs.Running, cs.Pid, cs.health()
+}
+
+// killContainer stops a map's container at once
+func killContainer(mapName string) error {
+	docker, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	if err := docker.kill(containerName(mapName)); err != nil && !errors.Is(err, errContainerNotFound) {
+		return err
+	}
+	return nil
+}
+
+// dockerPorts returns the host ports a docker config publishes
+func (dc *DockerConfig) dockerPorts() map[string]int {
+	ports := make(map[string]int, len(dc.Ports))
+	for _, p := range dc.Ports {
+		ports[fmt.Sprintf("docker_%d/%s", p.Container, p.protocol())] = p.Host
+	}
+	return ports
+}
@@ -0,0 +1,280 @@
+package processmanager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/broadcast"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/whitelist"
+)
+
+const (
+	defaultDrainTimeout = 10 * time.Minute
+	drainPollInterval   = 15 * time.Second
+	// drainAnnounceInterval is how often the broadcast is repeated
+	drainAnnounceInterval = 5 * time.Minute
+	drainListTimeout      = 5 * time.Second
+
+	defaultDrainMessage = "The server is shutting down, please log out"
+)
+
+// DrainOptions control a drain stop, zero values use the defaults
+type DrainOptions struct {
+	// MaxWait overrides the map's drain_timeout_seconds
+	MaxWait time.Duration
+	// Message is broadcast to the players still online as the {reason} of
+	// the drain template
+	Message string
+}
+
+// DrainResult is the outcome of a drain stop
+type DrainResult struct {
+	Map string `json:"map"`
+	// Forced is set when players were still online after the max wait
+	Forced        bool          `json:"forced"`
+	PlayersOnline int           `json:"players_online"`
+	Players       []rcon.Player `json:"players,omitempty"`
+	// JoinsBlocked reports whether players off the server were kept from
+	// joining during the drain, only possible with -exclusivejoin
+	JoinsBlocked  bool    `json:"joins_blocked"`
+	WaitedSeconds float64 `json:"waited_seconds"`
+}
+
+// Drain stops a map once its last player has left, or after the max wait
+// with the players still online. It runs as a job in the background, which
+// can be cancelled while it waits and leaves the server running then.
+func (pm *ProcessManager) Drain(mapName string, opts DrainOptions) (jobs.Job, error) {
+	config, opts, err := pm.prepareDrain(mapName, opts)
+	if err != nil {
+		// Return the running drain job along with the conflict
+		if draining := jobs.List(jobs.Filter{Map: mapName, Type: jobs.TypeStop, State: jobs.StateRunning}); errors.Is(err, ErrAlreadyRunning) && len(draining) > 0 {
+			return draining[0], err
+		}
+		return jobs.Job{}, err
+	}
+
+	job := jobs.New(jobs.TypeStop, mapName)
+	job.SetCancel(nil)
+	job.Start()
+	go pm.drain(job, config, opts)
+	return job.Job(), nil
+}
+
+// prepareDrain checks that a map can be drained and fills in the defaults
+// of its options
+func (pm *ProcessManager) prepareDrain(mapName string, opts DrainOptions) (ProcessConfig, DrainOptions, error) {
+	config, exists := pm.Config(mapName)
+	if !exists {
+		return config, opts, fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
+	}
+	if _, running := pm.Running(mapName); !running {
+		return config, opts, fmt.Errorf("%w: %s", ErrNotRunning, mapName)
+	}
+	if draining := jobs.List(jobs.Filter{Map: mapName, Type: jobs.TypeStop, State: jobs.StateRunning}); len(draining) > 0 {
+		return config, opts, fmt.Errorf("%w: %s is draining in job %s", ErrAlreadyRunning, mapName, draining[0].ID)
+	}
+
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = time.Duration(config.DrainTimeoutSeconds) * time.Second
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = defaultDrainTimeout
+	}
+	if opts.Message == "" {
+		opts.Message = defaultDrainMessage
+	}
+	return config, opts, nil
+}
+
+// PlanDrain returns what Drain would do, for a dry run. It asks the server
+// who is online and reads the exclusive join list, but changes nothing.
+func (pm *ProcessManager) PlanDrain(mapName string, opts DrainOptions) (dryrun.Plan, error) {
+	config, opts, err := pm.prepareDrain(mapName, opts)
+	if err != nil {
+		return dryrun.Plan{}, err
+	}
+	plan := dryrun.Plan{Operation: "drain stop", Map: mapName}
+
+	players, err := rcon.ListPlayers(mapName, drainListTimeout)
+	onlineIDs := make(map[string]bool, len(players))
+	for _, player := range players {
+		onlineIDs[strings.ToLower(player.ID)] = true
+	}
+	if hasFlag(config.CommandArgs(), "-exclusivejoin") {
+		ids, _ := whitelist.Read(whitelist.Path(config.Executable))
+		for _, id := range ids {
+			if !onlineIDs[strings.ToLower(id)] {
+				plan.Commands = append(plan.Commands, "DisallowPlayerToJoinNoCheck "+id)
+			}
+		}
+		if len(plan.Commands) > 0 {
+			plan.Stepf("Take %d player(s) off the exclusive join list for the drain", len(plan.Commands))
+		}
+	}
+
+	minutes := int(opts.MaxWait.Round(time.Minute) / time.Minute)
+	if command := broadcast.Command(broadcast.EventDrain, mapName, broadcast.Minutes(max(minutes, 1)).With(broadcast.VarReason, opts.Message)); command != "" {
+		plan.Commands = append(plan.Commands, command)
+	}
+	if err != nil {
+		plan.Stepf("Wait up to %s for the players to leave, they could not be listed: %v", opts.MaxWait, err)
+	} else {
+		plan.Stepf("Wait up to %s for the %d player(s) online to leave, repeating the broadcast every %s", opts.MaxWait, len(players), drainAnnounceInterval)
+	}
+	plan.Commands = append(plan.Commands, "saveworld", "doexit")
+	plan.Stepf("Disable map %s so it is not started again", mapName)
+	return plan, nil
+}
+
+func (pm *ProcessManager) drain(job *jobs.Handle, config ProcessConfig, opts DrainOptions) {
+	mapName := config.Map
+	started := time.Now()
+	deadline := started.Add(opts.MaxWait)
+	job.Logf("Draining, shutting down when the server is empty or at %s", deadline.Format(time.RFC3339))
+
+	players, err := rcon.ListPlayers(mapName, drainListTimeout)
+	if err != nil {
+		job.Logf("Failed to list players: %v", err)
+	}
+	unblock, blocked := blockJoins(job, config, players)
+
+	var announced time.Time
+	for {
+		if err == nil && len(players) == 0 {
+			job.Logf("No players online")
+			break
+		}
+		if !time.Now().Before(deadline) {
+			job.Logf("Max wait reached with %d player(s) online", len(players))
+			break
+		}
+		if time.Since(announced) >= drainAnnounceInterval {
+			announced = time.Now()
+			minutes := int(time.Until(deadline).Round(time.Minute) / time.Minute)
+			vars := broadcast.Minutes(max(minutes, 1)).With(broadcast.VarReason, opts.Message)
+			if err := broadcast.Send(broadcast.EventDrain, mapName, vars); err != nil {
+				log.Printf("Failed to announce drain of '%s': %v", mapName, err)
+			}
+		}
+
+		select {
+		case <-job.Context().Done():
+			unblock(true)
+			job.Logf("Cancelled, the server keeps running")
+			job.Finish(job.Context().Err())
+			return
+		case <-time.After(min(drainPollInterval, time.Until(deadline))):
+		}
+		players, err = rcon.ListPlayers(mapName, drainListTimeout)
+		if err != nil {
+			job.Logf("Failed to list players: %v", err)
+		}
+	}
+
+	job.NoCancel()
+	if job.Context().Err() != nil {
+		unblock(true)
+		job.Finish(job.Context().Err())
+		return
+	}
+
+	result := DrainResult{Map: mapName, JoinsBlocked: blocked, WaitedSeconds: time.Since(started).Round(time.Second).Seconds()}
+	if err == nil {
+		result.PlayersOnline = len(players)
+		result.Players = players
+		result.Forced = len(players) > 0
+	} else {
+		// The player count is unknown, the server did not answer in time
+		result.Forced = true
+	}
+
+	if _, err := rcon.Execute(mapName, "saveworld"); err != nil {
+		log.Printf("Failed to save map '%s' before stopping it: %v", mapName, err)
+	}
+	_, stopErr := pm.DisableProcess(mapName)
+	unblock(false)
+	job.SetResult(result)
+
+	message := fmt.Sprintf("map %s was stopped after its players left", mapName)
+	if result.Forced {
+		message = fmt.Sprintf("map %s was stopped after %s with %d player(s) still online", mapName, opts.MaxWait, result.PlayersOnline)
+	}
+	log.Printf("Drain of '%s': %s", mapName, message)
+	job.Logf("Stopped, %d player(s) were online", result.PlayersOnline)
+	notify.Publish(notify.EventProcessDrained, message, map[string]interface{}{
+		"map": mapName, "forced": result.Forced, "players_online": result.PlayersOnline, "waited_seconds": result.WaitedSeconds,
+	})
+	job.Finish(stopErr)
+}
+
+// blockJoins keeps players from joining a draining server where it can.
+// Started with -exclusivejoin only the players on the exclusive join list
+// may join, so everyone on it who is not online is taken off it for the
+// drain. The returned func puts them back, over RCON too if the server is
+// still running.
+func blockJoins(job *jobs.Handle, config ProcessConfig, online []rcon.Player) (func(running bool), bool) {
+	noop := func(bool) {}
+	if !hasFlag(config.CommandArgs(), "-exclusivejoin") {
+		job.Logf("New joins cannot be blocked without -exclusivejoin")
+		return noop, false
+	}
+
+	path := whitelist.Path(config.Executable)
+	ids, err := whitelist.Read(path)
+	if err != nil {
+		job.Logf("New joins are not blocked: %v", err)
+		return noop, false
+	}
+	onlineIDs := make(map[string]bool, len(online))
+	for _, player := range online {
+		onlineIDs[strings.ToLower(player.ID)] = true
+	}
+
+	var removed []string
+	for _, id := range ids {
+		if onlineIDs[strings.ToLower(id)] {
+			continue
+		}
+		if _, err := rcon.Execute(config.Map, "DisallowPlayerToJoinNoCheck "+id); err != nil {
+			log.Printf("Failed to block %s from joining '%s': %v", id, config.Map, err)
+		}
+		removed = append(removed, id)
+	}
+	if _, err := whitelist.Update(path, nil, removed); err != nil {
+		log.Printf("Failed to update exclusive join list of '%s': %v", config.Map, err)
+	}
+	job.Logf("Blocked %d player(s) on the exclusive join list from joining", len(removed))
+
+	return func(running bool) {
+		if len(removed) == 0 {
+			return
+		}
+		if _, err := whitelist.Update(path, removed, nil); err != nil {
+			log.Printf("Failed to restore exclusive join list of '%s': %v", config.Map, err)
+		}
+		if !running {
+			return
+		}
+		for _, id := range removed {
+			if _, err := rcon.Execute(config.Map, "AllowPlayerToJoinNoCheck "+id); err != nil {
+				log.Printf("Failed to allow %s to join '%s' again: %v", id, config.Map, err)
+			}
+		}
+	}, true
+}
+
+func hasFlag(args []string, flag string) bool {
+	for _, arg := range args {
+		if strings.EqualFold(arg, flag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package processmanager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidLaunch = errors.New("invalid launch configuration")
+	ErrPortConflict  = errors.New("port conflict")
+
+	launchMapPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+	urlPortPattern   = regexp.MustCompile(`(?i)\?(Port|QueryPort|RCONPort)=(\d+)`)
+)
+
+// LaunchConfig describes an ASA server's command line. ProcessConfig.Args
+// are appended to the command line built from it.
+type LaunchConfig struct {
+	Map            string `json:"map"`
+	SessionName    string `json:"session_name,omitempty"`
+	Port           int    `json:"port,omitempty"`
+	QueryPort      int    `json:"query_port,omitempty"`
+	RCONPort       int    `json:"rcon_port,omitempty"`
+	MaxPlayers     int    `json:"max_players,omitempty"`
+	ServerPassword string `json:"server_password,omitempty"`
+
+	// BattlEye is on by default in ASA, false adds -NoBattlEye
+	BattlEye *bool `json:"battleye,omitempty"`
+	Mods     []int `json:"mods,omitempty"`
+
+	ClusterID  string `json:"cluster_id,omitempty"`
+	ClusterDir string `json:"cluster_dir,omitempty"`
+
+	// Options are extra ?Key=Value map URL options, Flags extra -Flag or
+	// -Flag=Value arguments
+	Options map[string]string `json:"options,omitempty"`
+	Flags   []string          `json:"flags,omitempty"`
+}
+
+// builtinOptions and builtinFlags are set by the builder and must not be
+// repeated in Options and Flags
+var (
+	builtinOptions = []string{"SessionName", "Port", "QueryPort", "RCONPort", "RCONEnabled", "MaxPlayers", "ServerPassword"}
+	builtinFlags   = []string{"-NoBattlEye", "-mods", "-clusterid", "-ClusterDirOverride", "-WinLiveMaxPlayers"}
+)
+
+// Validate checks the values of the launch config
+func (lc *LaunchConfig) Validate() error {
+	if !launchMapPattern.MatchString(lc.Map) {
+		return fmt.Errorf("%w: map must be a map name such as TheIsland_WP", ErrInvalidLaunch)
+	}
+	if strings.ContainsAny(lc.SessionName, "?\"\r\n") || strings.ContainsAny(lc.ServerPassword, "?\" \r\n") {
+		return fmt.Errorf("%w: session_name and server_password must not contain '?' or quotes", ErrInvalidLaunch)
+	}
+	for name, port := range map[string]int{"port": lc.Port, "query_port": lc.QueryPort, "rcon_port": lc.RCONPort} {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("%w: %s must be a port number", ErrInvalidLaunch, name)
+		}
+	}
+	if lc.MaxPlayers < 0 {
+		return fmt.Errorf("%w: max_players must not be negative", ErrInvalidLaunch)
+	}
+	for _, mod := range lc.Mods {
+		if mod <= 0 {
+			return fmt.Errorf("%w: mod IDs must be positive", ErrInvalidLaunch)
+		}
+	}
+	for key, value := range lc.Options {
+		if key == "" || strings.ContainsAny(key+value, "?\"\r\n") || strings.Contains(key, "=") {
+			return fmt.Errorf("%w: option %q must not contain '?', '=' or quotes", ErrInvalidLaunch, key)
+		}
+	}
+	for _, flag := range lc.Flags {
+		if !strings.HasPrefix(flag, "-") {
+			return fmt.Errorf("%w: flag %q must start with '-'", ErrInvalidLaunch, flag)
+		}
+	}
+	return nil
+}
+
+// Build returns the server arguments: the map URL followed by the flags
+func (lc *LaunchConfig) Build() []string {
+	url := lc.Map + "?listen"
+	if lc.SessionName != "" {
+		url += "?SessionName=" + lc.SessionName
+	}
+	if lc.Port != 0 {
+		url += "?Port=" + strconv.Itoa(lc.Port)
+	}
+	if lc.QueryPort != 0 {
+		url += "?QueryPort=" + strconv.Itoa(lc.QueryPort)
+	}
+	if lc.RCONPort != 0 {
+		url += "?RCONEnabled=True?RCONPort=" + strconv.Itoa(lc.RCONPort)
+	}
+	if lc.ServerPassword != "" {
+		url += "?ServerPassword=" + lc.ServerPassword
+	}
+	for _, key := range sortedKeys(lc.Options) {
+		url += "?" + key + "=" + lc.Options[key]
+	}
+
+	args := []string{url}
+	if lc.MaxPlayers != 0 {
+		args = append(args, "-WinLiveMaxPlayers="+strconv.Itoa(lc.MaxPlayers))
+	}
+	if lc.BattlEye != nil && !*lc.BattlEye {
+		args = append(args, "-NoBattlEye")
+	}
+	if len(lc.Mods) > 0 {
+		ids := make([]string, len(lc.Mods))
+		for i, mod := range lc.Mods {
+			ids[i] = strconv.Itoa(mod)
+		}
+		args = append(args, "-mods="+strings.Join(ids, ","))
+	}
+	if lc.ClusterID != "" {
+		args = append(args, "-clusterid="+lc.ClusterID)
+	}
+	if lc.ClusterDir != "" {
+		args = append(args, "-ClusterDirOverride="+lc.ClusterDir)
+	}
+	return append(args, lc.Flags...)
+}
+
+// Warnings lists options and flags that conflict with what the builder
+// already sets or with each other
+func (lc *LaunchConfig) Warnings(extraArgs []string) []string {
+	var warnings []string
+	for key := range lc.Options {
+		for _, builtin := range builtinOptions {
+			if strings.EqualFold(key, builtin) {
+				warnings = append(warnings, fmt.Sprintf("option %s is also set by the launch config", key))
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, flag := range append(append([]string{}, lc.Flags...), extraArgs...) {
+		name, _, _ := strings.Cut(flag, "=")
+		lower := strings.ToLower(name)
+		for _, builtin := range builtinFlags {
+			if lower == strings.ToLower(builtin) {
+				warnings = append(warnings, fmt.Sprintf("flag %s is also set by the launch config", name))
+			}
+		}
+		if seen[lower] {
+			warnings = append(warnings, fmt.Sprintf("flag %s is given more than once", name))
+		}
+		seen[lower] = true
+		if strings.Contains(flag, "?listen") {
+			warnings = append(warnings, "args contain a second map URL")
+		}
+	}
+	if lc.BattlEye != nil && *lc.BattlEye && seen["-nobattleye"] {
+		warnings = append(warnings, "battleye is enabled but -NoBattlEye is passed")
+	}
+	return warnings
+}
+
+// CommandArgs returns the arguments the server is started with
+func (c ProcessConfig) CommandArgs() []string {
+	if c.Launch == nil {
+		return c.Args
+	}
+	return append(c.Launch.Build(), c.Args...)
+}
+
+// Ports returns the ports a server listens on by name, parsed from the map
+// URL for configs without a launch config
+func (c ProcessConfig) Ports() map[string]int {
+	ports := make(map[string]int)
+	if c.Docker != nil {
+		// Containers only take the host ports they publish
+		return c.Docker.dockerPorts()
+	}
+	if c.Launch != nil {
+		for name, port := range map[string]int{"port": c.Launch.Port, "query_port": c.Launch.QueryPort, "rcon_port": c.Launch.RCONPort} {
+			if port != 0 {
+				ports[name] = port
+			}
+		}
+		return ports
+	}
+	for _, arg := range c.Args {
+		for _, match := range urlPortPattern.FindAllStringSubmatch(arg, -1) {
+			port, _ := strconv.Atoi(match[2])
+			ports[strings.ToLower(match[1])] = port
+		}
+	}
+	return ports
+}
+
+// ModIDs returns the CurseForge IDs of the mods a server loads, from the
+// -mods argument of its command line
+func (c ProcessConfig) ModIDs() []int {
+	var ids []int
+	for _, arg := range c.CommandArgs() {
+		name, value, found := strings.Cut(arg, "=")
+		if !found || !strings.EqualFold(name, "-mods") {
+			continue
+		}
+		for _, field := range strings.Split(value, ",") {
+			if id, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && id > 0 {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// withMods returns the config with its mods list set to ids, in the launch
+// options or else the -mods argument. ok is false if it has neither.
+func (c ProcessConfig) withMods(ids []int) (ProcessConfig, bool) {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = strconv.Itoa(id)
+	}
+	inArgs := -1
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		if name, _, found := strings.Cut(arg, "="); found && strings.EqualFold(name, "-mods") {
+			if inArgs < 0 {
+				inArgs = len(args)
+			}
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	switch {
+	case c.Launch != nil && (len(c.Launch.Mods) > 0 || inArgs < 0):
+		launch := *c.Launch
+		launch.Mods = append([]int(nil), ids...)
+		c.Launch = &launch
+	case inArgs >= 0:
+		// The list stays where it was
+		if len(ids) > 0 {
+			args = append(args[:inArgs], append([]string{"-mods=" + strings.Join(values, ",")}, args[inArgs:]...)...)
+		}
+	default:
+		return c, false
+	}
+	c.Args = args
+	return c, true
+}
+
+// ValidateConfigs checks every launch, docker and process setting and that
+// no two maps use the same port
+func ValidateConfigs(configs []ProcessConfig) error {
+	owners := make(map[int]string)
+	for _, config := range configs {
+		if err := config.validateProcess(); err != nil {
+			return fmt.Errorf("map %s: %w", config.Map, err)
+		}
+		if config.Launch != nil {
+			if err := config.Launch.Validate(); err != nil {
+				return fmt.Errorf("map %s: %w", config.Map, err)
+			}
+		}
+		if config.Docker != nil {
+			if err := config.Docker.Validate(); err != nil {
+				return fmt.Errorf("map %s: %w", config.Map, err)
+			}
+		}
+		for _, port := range config.Ports() {
+			if owner, taken := owners[port]; taken && owner != config.Map {
+				return fmt.Errorf("%w: port %d is used by maps %s and %s", ErrPortConflict, port, owner, config.Map)
+			}
+			owners[port] = config.Map
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,441 @@
+package processmanager
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/state"
+)
+
+const (
+	stdoutDir      = "./stdout"
+	logsDir        = "./logs"
+	logConfigFile  = "config/log_config.json"
+	bucketLogIndex = "log_index"
+
+	// MaxLogReadBytes is the most RetrieveLogs returns, older output is cut
+	MaxLogReadBytes = 1 << 20
+)
+
+var ErrLogNotFound = errors.New("log file not found")
+
+// LogConfig controls rotation and retention of the server console logs
+type LogConfig struct {
+	MaxSizeMB     int   `json:"max_size_mb"`
+	MaxAgeHours   int   `json:"max_age_hours"`
+	RetentionDays int   `json:"retention_days"`
+	MaxFiles      int   `json:"max_files"`
+	Compress      *bool `json:"compress,omitempty"`
+}
+
+func defaultLogConfig() LogConfig {
+	compress := true
+	return LogConfig{MaxSizeMB: 100, MaxAgeHours: 24, RetentionDays: 14, MaxFiles: 50, Compress: &compress}
+}
+
+// LoadLogConfig reads config/log_config.json, unset fields keep their
+// defaults and a missing file means all defaults
+func LoadLogConfig() (LogConfig, error) {
+	config := defaultLogConfig()
+	data, err := os.ReadFile(logConfigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to read log config: %w", err)
+	}
+
+	var loaded LogConfig
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return config, fmt.Errorf("failed to parse log config: %w", err)
+	}
+	if loaded.MaxSizeMB > 0 {
+		config.MaxSizeMB = loaded.MaxSizeMB
+	}
+	if loaded.MaxAgeHours > 0 {
+		config.MaxAgeHours = loaded.MaxAgeHours
+	}
+	if loaded.RetentionDays > 0 {
+		config.RetentionDays = loaded.RetentionDays
+	}
+	if loaded.MaxFiles > 0 {
+		config.MaxFiles = loaded.MaxFiles
+	}
+	if loaded.Compress != nil {
+		config.Compress = loaded.Compress
+	}
+	return config, nil
+}
+
+// LogSegment is a rotated log file in logs/<map>/
+type LogSegment struct {
+	File       string    `json:"file"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Size       int64     `json:"size"`
+	Compressed bool      `json:"compressed"`
+}
+
+// LogIndex lists the rotated logs of a map, oldest first, and when the
+// current log in stdout/ was started
+type LogIndex struct {
+	CurrentStart time.Time    `json:"current_start"`
+	Segments     []LogSegment `json:"segments"`
+}
+
+var (
+	// indexMu serializes updates of the log index
+	indexMu sync.Mutex
+	// archiveMu serializes compression and pruning, which run in the
+	// background
+	archiveMu sync.Mutex
+)
+
+// GetLogIndex returns the log index of a map
+func GetLogIndex(mapName string) (LogIndex, error) {
+	var index LogIndex
+	if _, err := state.Get(bucketLogIndex, mapName, &index); err != nil {
+		return LogIndex{}, err
+	}
+	return index, nil
+}
+
+func updateLogIndex(mapName string, fn func(index *LogIndex)) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	index, err := GetLogIndex(mapName)
+	if err != nil {
+		return err
+	}
+	fn(&index)
+	return state.Put(bucketLogIndex, mapName, index)
+}
+
+// FindLog returns the log file that covers t, "" for the current log
+func FindLog(mapName string, t time.Time) (string, error) {
+	index, err := GetLogIndex(mapName)
+	if err != nil {
+		return "", err
+	}
+	if !index.CurrentStart.IsZero() && !t.Before(index.CurrentStart) {
+		return "", nil
+	}
+	for _, segment := range index.Segments {
+		if !t.Before(segment.Start) && !t.After(segment.End) {
+			return segment.File, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no log of %s covers %s", ErrLogNotFound, mapName, t.Format(time.RFC3339))
+}
+
+// RotatingLog is the console log of a running server. It is rotated into
+// logs/<map>/ once it grows past the size or age limit.
+type RotatingLog struct {
+	mu      sync.Mutex
+	mapName string
+	config  LogConfig
+	file    *os.File
+	size    int64
+	started time.Time
+	// watch sees every line before it is written, it is set before the
+	// first line arrives
+	watch func(line string)
+}
+
+// OpenLog archives the log of the previous run and starts a new one
+func OpenLog(mapName string, config LogConfig) (*RotatingLog, error) {
+	l := &RotatingLog{mapName: mapName, config: config}
+	if err := archiveLog(mapName, config, time.Time{}); err != nil {
+		log.Printf("Failed to archive log of %s: %v", mapName, err)
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *RotatingLog) open() error {
+	file, err := os.Create(currentLogPath(l.mapName))
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %w", err)
+	}
+	l.file = file
+	l.size = 0
+	l.started = time.Now()
+	return updateLogIndex(l.mapName, func(index *LogIndex) {
+		index.CurrentStart = l.started
+	})
+}
+
+// WriteLine appends a line and rotates the log when it is due
+func (l *RotatingLog) WriteLine(line string) error {
+	if l.watch != nil {
+		l.watch(line)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return errors.New("log file is closed")
+	}
+	n, err := l.file.WriteString(line + "\n")
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write to log file: %w", err)
+	}
+
+	if l.size >= int64(l.config.MaxSizeMB)<<20 || time.Since(l.started) >= time.Duration(l.config.MaxAgeHours)*time.Hour {
+		if err := l.file.Close(); err != nil {
+			log.Printf("Failed to close log of %s: %v", l.mapName, err)
+		}
+		l.file = nil
+		if err := archiveLog(l.mapName, l.config, time.Now()); err != nil {
+			log.Printf("Failed to rotate log of %s: %v", l.mapName, err)
+		}
+		return l.open()
+	}
+	return nil
+}
+
+// Close closes the log, it is archived when the server starts again
+func (l *RotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func currentLogPath(mapName string) string {
+	return filepath.Join(stdoutDir, mapName+".log")
+}
+
+// archiveLog moves the current log of a map into logs/<map>/, compresses it
+// in the background and applies the retention policy. A zero end is taken
+// from the file's modification time.
+func archiveLog(mapName string, config LogConfig, end time.Time) error {
+	src := currentLogPath(mapName)
+	info, err := os.Stat(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return os.Remove(src)
+	}
+
+	index, err := GetLogIndex(mapName)
+	if err != nil {
+		return err
+	}
+	start := index.CurrentStart
+	if start.IsZero() {
+		start = info.ModTime()
+	}
+
+	dir := filepath.Join(logsDir, mapName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	name := fmt.Sprintf("%s_%s.log", mapName, start.Format("20060102-150405.000"))
+	if err := os.Rename(src, filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("failed to move log file: %w", err)
+	}
+
+	if end.IsZero() {
+		end = info.ModTime()
+	}
+	if end.Before(start) {
+		end = start
+	}
+	segment := LogSegment{File: name, Start: start, End: end, Size: info.Size()}
+	err = updateLogIndex(mapName, func(index *LogIndex) {
+		index.CurrentStart = time.Time{}
+		index.Segments = append(index.Segments, segment)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Log of %s rotated to %s", mapName, name)
+
+	go func() {
+		archiveMu.Lock()
+		defer archiveMu.Unlock()
+
+		if config.Compress != nil && *config.Compress {
+			if err := compressLog(mapName, name); err != nil {
+				log.Printf("Failed to compress log %s: %v", name, err)
+			}
+		}
+		if err := pruneLogs(mapName, config); err != nil {
+			log.Printf("Failed to prune logs of %s: %v", mapName, err)
+		}
+	}()
+	return nil
+}
+
+func compressLog(mapName string, name string) error {
+	path := filepath.Join(logsDir, mapName, name)
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+
+	err = updateLogIndex(mapName, func(index *LogIndex) {
+		for i := range index.Segments {
+			if index.Segments[i].File == name {
+				index.Segments[i].File = name + ".gz"
+				index.Segments[i].Compressed = true
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// pruneLogs deletes segments past the retention period and the oldest ones
+// beyond MaxFiles
+func pruneLogs(mapName string, config LogConfig) error {
+	var expired []LogSegment
+	cutoff := time.Now().AddDate(0, 0, -config.RetentionDays)
+	err := updateLogIndex(mapName, func(index *LogIndex) {
+		sort.Slice(index.Segments, func(i, j int) bool {
+			return index.Segments[i].Start.Before(index.Segments[j].Start)
+		})
+		kept := make([]LogSegment, 0, len(index.Segments))
+		for i, segment := range index.Segments {
+			if segment.End.Before(cutoff) || len(index.Segments)-i > config.MaxFiles {
+				expired = append(expired, segment)
+				continue
+			}
+			kept = append(kept, segment)
+		}
+		index.Segments = kept
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range expired {
+		path := filepath.Join(logsDir, mapName, segment.File)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to delete expired log %s: %v", path, err)
+			continue
+		}
+		log.Printf("Deleted expired log %s", path)
+	}
+	return nil
+}
+
+// RetrieveLogs returns the end of a map's log, at most MaxLogReadBytes.
+// file names a rotated log from the index, "" is the current log.
+func RetrieveLogs(mapName string, file string) (logs string, truncated bool, err error) {
+	path := currentLogPath(mapName)
+	if file != "" {
+		if file != filepath.Base(file) || strings.HasPrefix(file, ".") {
+			return "", false, fmt.Errorf("%w: %s", ErrLogNotFound, file)
+		}
+		path = filepath.Join(logsDir, mapName, file)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if file == "" {
+				return "No logs found for the specified process.", false, nil
+			}
+			return "", false, fmt.Errorf("%w: %s", ErrLogNotFound, file)
+		}
+		return "", false, fmt.Errorf("failed to open log file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read log file %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	} else if info, err := f.Stat(); err == nil && info.Size() > MaxLogReadBytes {
+		if _, err := f.Seek(info.Size()-MaxLogReadBytes, io.SeekStart); err != nil {
+			return "", false, fmt.Errorf("failed to read log file %s: %w", path, err)
+		}
+		truncated = true
+	}
+
+	tail := &tailBuffer{max: MaxLogReadBytes}
+	if _, err := io.Copy(tail, r); err != nil {
+		return "", false, fmt.Errorf("failed to read log file %s: %w", path, err)
+	}
+	data := tail.data
+	if truncated || tail.dropped {
+		truncated = true
+		// Start at a line boundary
+		if i := strings.IndexByte(string(data), '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	if len(data) == 0 && file == "" && !truncated {
+		return "Log file is empty.", false, nil
+	}
+	return string(data), truncated, nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	max     int
+	data    []byte
+	dropped bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.data = append(t.data, p...)
+	if len(t.data) > t.max {
+		t.data = append(t.data[:0], t.data[len(t.data)-t.max:]...)
+		t.dropped = true
+	}
+	return len(p), nil
+}
@@ -0,0 +1,113 @@
+package processmanager
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PortStatus is a port of a map and whether the host already uses it
+type PortStatus struct {
+	Map      string `json:"map"`
+	Name     string `json:"name"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	InUse    bool   `json:"in_use"`
+	// Conflict is set when the port is in use while the map's server is
+	// not running, so another program holds it
+	Conflict bool `json:"conflict"`
+}
+
+// PortProtocol is the protocol a server listens on for a port, the game
+// and query ports are UDP and RCON is TCP
+func PortProtocol(name string) string {
+	if _, protocol, ok := strings.Cut(name, "/"); ok {
+		// docker_<port>/<protocol>
+		return protocol
+	}
+	if name == "rcon_port" {
+		return "tcp"
+	}
+	return "udp"
+}
+
+// PortFree reports whether a port can be bound on every interface of the
+// host
+func PortFree(port int, protocol string) bool {
+	address := ":" + strconv.Itoa(port)
+	if protocol == "tcp" {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return false
+		}
+		listener.Close()
+		return true
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// hostPorts checks the ports of a map on the host, running tells whether
+// the map's own server holds them
+func hostPorts(config ProcessConfig, running bool) []PortStatus {
+	var ports []PortStatus
+	for name, port := range config.Ports() {
+		status := PortStatus{Map: config.Map, Name: name, Port: port, Protocol: PortProtocol(name)}
+		status.InUse = !PortFree(port, status.Protocol)
+		status.Conflict = status.InUse && !running
+		ports = append(ports, status)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+	return ports
+}
+
+// Ports checks the ports of every map on the host
+func (pm *ProcessManager) Ports() []PortStatus {
+	pm.mu.Lock()
+	configs := make([]ProcessConfig, 0, len(pm.configs))
+	for _, config := range pm.configs {
+		configs = append(configs, config)
+	}
+	pm.mu.Unlock()
+
+	ports := []PortStatus{}
+	for _, config := range configs {
+		_, running := pm.Running(config.Map)
+		ports = append(ports, hostPorts(config, running)...)
+	}
+	sort.SliceStable(ports, func(i, j int) bool { return ports[i].Map < ports[j].Map })
+	return ports
+}
+
+// UsedPorts returns the ports of the registered maps and the map using
+// each
+func (pm *ProcessManager) UsedPorts() map[int]string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	used := make(map[int]string)
+	for _, config := range pm.configs {
+		for _, port := range config.Ports() {
+			used[port] = config.Map
+		}
+	}
+	return used
+}
+
+// portConflicts describes the ports of a stopped map that another program
+// holds
+func portConflicts(config ProcessConfig) []string {
+	var conflicts []string
+	for _, status := range hostPorts(config, false) {
+		if status.Conflict {
+			conflicts = append(conflicts, fmt.Sprintf("%s %d/%s", status.Name, status.Port, status.Protocol))
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,140 @@
+package processmanager
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidProcess = errors.New("invalid process configuration")
+
+// Process priorities
+const (
+	PriorityIdle        = "idle"
+	PriorityBelowNormal = "below_normal"
+	PriorityNormal      = "normal"
+	PriorityAboveNormal = "above_normal"
+	PriorityHigh        = "high"
+)
+
+// Priorities lists the process priorities, lowest first. Realtime is left
+// out, a busy server would starve the machine.
+var Priorities = []string{PriorityIdle, PriorityBelowNormal, PriorityNormal, PriorityAboveNormal, PriorityHigh}
+
+// priorityNice is the Linux niceness of each priority
+var priorityNice = map[string]int{
+	PriorityIdle:        19,
+	PriorityBelowNormal: 10,
+	PriorityNormal:      0,
+	PriorityAboveNormal: -5,
+	PriorityHigh:        -10,
+}
+
+// maxCPUs bounds the CPU numbers of an affinity, Windows masks have 64 bits
+const maxCPUs = 64
+
+// ParseAffinity returns the CPUs of an affinity, a list of CPUs and ranges
+// such as "0-3,8" or a hex mask such as "0xF0"
+func ParseAffinity(value string) ([]int, error) {
+	value = strings.TrimSpace(value)
+	if hex, ok := strings.CutPrefix(strings.ToLower(value), "0x"); ok {
+		mask, err := strconv.ParseUint(hex, 16, 64)
+		if err != nil || mask == 0 {
+			return nil, fmt.Errorf("affinity mask %q is invalid", value)
+		}
+		var cpus []int
+		for mask != 0 {
+			cpu := bits.TrailingZeros64(mask)
+			cpus = append(cpus, cpu)
+			mask &^= 1 << cpu
+		}
+		return cpus, nil
+	}
+
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err := strconv.Atoi(first)
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(last)
+		}
+		if err != nil || from < 0 || to < from || to >= maxCPUs {
+			return nil, fmt.Errorf("affinity %q must list CPUs 0-%d such as 0-3,8", value, maxCPUs-1)
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			seen[cpu] = true
+		}
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// affinityMask returns the CPUs as a bit mask
+func affinityMask(cpus []int) uint64 {
+	var mask uint64
+	for _, cpu := range cpus {
+		mask |= 1 << cpu
+	}
+	return mask
+}
+
+// nice is the Linux niceness of a config, ok is false if it sets none
+func (c ProcessConfig) nice() (int, bool) {
+	if c.Nice != nil {
+		return *c.Nice, true
+	}
+	if c.Priority == "" {
+		return 0, false
+	}
+	return priorityNice[c.Priority], true
+}
+
+// validateProcess checks the environment, priority and affinity. They only
+// apply to processes, containers take their environment from the docker
+// config.
+func (c ProcessConfig) validateProcess() error {
+	if c.Docker != nil && (len(c.Env) > 0 || c.Priority != "" || c.Nice != nil || c.Affinity != "") {
+		return fmt.Errorf("%w: env, priority, nice and affinity do not apply to containers, use docker.env", ErrInvalidProcess)
+	}
+	for key := range c.Env {
+		if key == "" || strings.ContainsAny(key, "= \t\r\n\x00") {
+			return fmt.Errorf("%w: env name %q is invalid", ErrInvalidProcess, key)
+		}
+	}
+	if c.Priority != "" {
+		if _, ok := priorityNice[c.Priority]; !ok {
+			return fmt.Errorf("%w: priority must be one of %s", ErrInvalidProcess, strings.Join(Priorities, ", "))
+		}
+	}
+	if c.Nice != nil && (*c.Nice < -20 || *c.Nice > 19) {
+		return fmt.Errorf("%w: nice must be between -20 and 19", ErrInvalidProcess)
+	}
+	if c.Affinity != "" {
+		if _, err := ParseAffinity(c.Affinity); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProcess, err)
+		}
+	}
+	return nil
+}
+
+// environ returns the environment of the server process, the manager's
+// own with Env on top
+func (c ProcessConfig) environ() []string {
+	if len(c.Env) == 0 {
+		return nil
+	}
+	env := os.Environ()
+	for _, key := range sortedKeys(c.Env) {
+		env = append(env, key+"="+c.Env[key])
+	}
+	return env
+}
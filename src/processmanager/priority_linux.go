@@ -0,0 +1,59 @@
+//go:build linux
+
+package processmanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// prepareCommand has nothing to set before the start on Linux
+func prepareCommand(cmd *exec.Cmd, config ProcessConfig) {}
+
+// applyScheduling sets the niceness and affinity of a started process.
+// Both are per thread on Linux, so every thread the process has so far is
+// changed, later ones inherit them.
+func applyScheduling(pid int, config ProcessConfig) error {
+	nice, setNice := config.nice()
+	var cpus *unix.CPUSet
+	if config.Affinity != "" {
+		list, err := ParseAffinity(config.Affinity)
+		if err != nil {
+			return err
+		}
+		cpus = new(unix.CPUSet)
+		for _, cpu := range list {
+			cpus.Set(cpu)
+		}
+	}
+	if !setNice && cpus == nil {
+		return nil
+	}
+
+	tids := []int{pid}
+	if entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid)); err == nil {
+		tids = tids[:0]
+		for _, entry := range entries {
+			if tid, err := strconv.Atoi(entry.Name()); err == nil {
+				tids = append(tids, tid)
+			}
+		}
+	}
+	for _, tid := range tids {
+		if setNice {
+			if err := unix.Setpriority(unix.PRIO_PROCESS, tid, nice); err != nil {
+				return fmt.Errorf("failed to set niceness %d: %w", nice, err)
+			}
+		}
+		if cpus != nil {
+			if err := unix.SchedSetaffinity(tid, cpus); err != nil {
+				return fmt.Errorf("failed to set affinity %s: %w", config.Affinity, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !linux
+
+package processmanager
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func prepareCommand(cmd *exec.Cmd, config ProcessConfig) {}
+
+// applyScheduling refuses priorities and affinities, they are only
+// supported on Windows and Linux
+func applyScheduling(pid int, config ProcessConfig) error {
+	if config.Priority != "" || config.Nice != nil || config.Affinity != "" {
+		return fmt.Errorf("process priority and affinity are not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
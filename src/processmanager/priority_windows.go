@@ -0,0 +1,50 @@
+//go:build windows
+
+package processmanager
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+var priorityClasses = map[string]uint32{
+	PriorityIdle:        windows.IDLE_PRIORITY_CLASS,
+	PriorityBelowNormal: windows.BELOW_NORMAL_PRIORITY_CLASS,
+	PriorityNormal:      windows.NORMAL_PRIORITY_CLASS,
+	PriorityAboveNormal: windows.ABOVE_NORMAL_PRIORITY_CLASS,
+	PriorityHigh:        windows.HIGH_PRIORITY_CLASS,
+}
+
+var procSetProcessAffinityMask = windows.NewLazySystemDLL("kernel32.dll").NewProc("SetProcessAffinityMask")
+
+// prepareCommand creates the process in its priority class, so it never
+// runs at the default one
+func prepareCommand(cmd *exec.Cmd, config ProcessConfig) {
+	if class, ok := priorityClasses[config.Priority]; ok {
+		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: class}
+	}
+}
+
+// applyScheduling pins a started process to the configured CPUs
+func applyScheduling(pid int, config ProcessConfig) error {
+	if config.Affinity == "" {
+		return nil
+	}
+	cpus, err := ParseAffinity(config.Affinity)
+	if err != nil {
+		return err
+	}
+
+	process, err := windows.OpenProcess(windows.PROCESS_SET_INFORMATION|windows.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return fmt.Errorf("failed to open process %d: %w", pid, err)
+	}
+	defer windows.CloseHandle(process)
+	if ok, _, err := procSetProcessAffinityMask.Call(uintptr(process), uintptr(affinityMask(cpus))); ok == 0 {
+		return fmt.Errorf("failed to set affinity %s: %w", config.Affinity, err)
+	}
+	return nil
+}
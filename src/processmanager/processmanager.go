@@ -2,7 +2,8 @@ package processmanager
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,7 +15,15 @@ import (
 	"sync"
 	"time"
 
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/notify"
 	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/state"
+	"asa_servermanager_api/tenants"
 )
 
 type ProcessConfig struct {
@@ -22,115 +31,320 @@ type ProcessConfig struct {
 	Executable      string   `json:"executable"`
 	Args            []string `json:"args"`
 	RestartInterval int      `json:"restart_interval"`
+
+	// Launch builds the ASA command line, Args are appended to it
+	Launch *LaunchConfig `json:"launch,omitempty"`
+
+	// ConfigDir holds GameUserSettings.ini and Game.ini, by default it is
+	// derived from the executable's location
+	ConfigDir string `json:"config_dir,omitempty"`
+
+	// Docker runs the server as a container instead of a process
+	Docker *DockerConfig `json:"docker,omitempty"`
+
+	// DrainTimeoutSeconds is how long a drain stop waits for the players
+	// to leave before it shuts the server down anyway, default 600
+	DrainTimeoutSeconds int `json:"drain_timeout_seconds,omitempty"`
+
+	// Env is added to the manager's environment for the server process
+	Env map[string]string `json:"env,omitempty"`
+	// Priority is the process priority, see Priorities. On Linux it maps to
+	// a niceness unless Nice is given.
+	Priority string `json:"priority,omitempty"`
+	Nice     *int   `json:"nice,omitempty"`
+	// Affinity pins the process to CPUs, a list such as "0-3,8" or a hex
+	// mask such as "0xF0"
+	Affinity string `json:"affinity,omitempty"`
+
+	// ReadyMarkers are console lines that tell the server has finished
+	// loading, matched as substrings regardless of case. Without them
+	// ASA's own messages are used.
+	ReadyMarkers []string `json:"ready_markers,omitempty"`
+	// ReadyTimeoutSeconds is how long a server may load before it is
+	// taken as ready without a marker, default 1800
+	ReadyTimeoutSeconds int `json:"ready_timeout_seconds,omitempty"`
+	// StartupTimeoutSeconds is how long a server may load before it is
+	// killed as hung and started again, instead of being taken as ready.
+	// 0 disables it.
+	StartupTimeoutSeconds int `json:"startup_timeout_seconds,omitempty"`
+	// RollbackOnStartupFailure restores the last backup the server loaded
+	// from when it fails to start within the startup timeout
+	RollbackOnStartupFailure bool `json:"rollback_on_startup_failure,omitempty"`
+	// CrashRecovery rolls the map back to a verified backup when it keeps
+	// crashing before it gets ready
+	CrashRecovery *CrashRecoveryConfig `json:"crash_recovery,omitempty"`
+}
+
+// IniDir returns the directory of the server's INI files
+func (c ProcessConfig) IniDir() string {
+	if c.ConfigDir != "" {
+		return c.ConfigDir
+	}
+	// <install>/ShooterGame/Binaries/Win64/ArkAscendedServer.exe
+	return filepath.Join(filepath.Dir(c.Executable), "..", "..", "Saved", "Config", "WindowsServer")
+}
+
+// InstallDir returns the directory the server was installed to with
+// SteamCMD
+func (c ProcessConfig) InstallDir() string {
+	// <install>/ShooterGame/Binaries/Win64/ArkAscendedServer.exe
+	return filepath.Clean(filepath.Join(filepath.Dir(c.Executable), "..", "..", ".."))
+}
+
+// SaveDir returns the SavedArks directory of the map the launch config
+// starts, "" without a launch config
+func (c ProcessConfig) SaveDir() string {
+	if c.Launch == nil || c.Launch.Map == "" {
+		return ""
+	}
+	return filepath.Join(c.InstallDir(), "ShooterGame", "Saved", "SavedArks", c.Launch.Map)
 }
 
 type ProcessManager struct {
-	configs   map[string]ProcessConfig
-	processes map[string]*exec.Cmd
-	mu        sync.Mutex
+	configFile string
+	logConfig  LogConfig
+	configs    map[string]ProcessConfig
+	processes  map[string]*exec.Cmd
+	states     map[string]*mapState
+	mu         sync.Mutex
+
+	// Rollback restores the newest backup of a map taken at or before
+	// lastReady and returns its name, see RollbackOnStartupFailure
+	Rollback func(mapName string, lastReady time.Time) (string, error)
+	// Recover restores the newest verified backup of a map taken at or
+	// before a time, logging to job, and returns the job's result, see
+	// CrashRecovery
+	Recover func(job *jobs.Handle, mapName string, before time.Time) (interface{}, error)
+	// Snapshot backs up a map for its restart job once its world was saved
+	// and before it stops. An error aborts the restart.
+	Snapshot func(job *jobs.Handle, mapName string) error
+	// ConsoleLine sees every line a server writes to its console, it must
+	// not block
+	ConsoleLine func(mapName string, line string)
 }
 
 var (
-	myMap       = make(map[string]bool)
-	myMapSarted = make(map[string]bool)
+	ErrMapNotFound    = errors.New("map not found")
+	ErrAlreadyRunning = errors.New("map already running")
+	ErrMapExists      = errors.New("map already registered")
+	ErrNotRunning     = errors.New("map not running")
 )
 
+// configFileMu serializes rewrites of the process config file
+var configFileMu sync.Mutex
+
 func NewProcessManager(configFile string) (*ProcessManager, error) {
 	pm := &ProcessManager{
-		configs:   make(map[string]ProcessConfig),
-		processes: make(map[string]*exec.Cmd),
+		configFile: configFile,
+		configs:    make(map[string]ProcessConfig),
+		processes:  make(map[string]*exec.Cmd),
+		states:     make(map[string]*mapState),
 	}
 
 	configs, err := LoadProcessConfigs(configFile)
 	if err != nil {
 		return nil, err
 	}
+	if err := ValidateConfigs(configs); err != nil {
+		return nil, err
+	}
+	if pm.logConfig, err = LoadLogConfig(); err != nil {
+		return nil, err
+	}
 
 	for _, config := range configs {
+		if config.Launch != nil {
+			for _, warning := range config.Launch.Warnings(config.Args) {
+				log.Printf("Launch config of %s: %s", config.Map, warning)
+			}
+		}
 		pm.configs[config.Map] = config
 	}
 
 	return pm, nil
 }
 
+// LoadProcessConfigs reads the process config, ASA_PROCESS_<MAP>_<FIELD>
+// variables override it
 func LoadProcessConfigs(filename string) ([]ProcessConfig, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
 	var configs []ProcessConfig
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&configs); err != nil {
+	if err := configfile.Load(filename, "ASA_PROCESS", &configs); err != nil {
 		return nil, err
 	}
 	return configs, nil
 }
 
-func IsProcessRunning(pid int) bool {
+// RegisterMap adds a map's process configuration and persists it. The map
+// is not started until it is enabled.
+func (pm *ProcessManager) RegisterMap(config ProcessConfig) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	pidStr := strconv.Itoa(pid)
+	if _, exists := pm.configs[config.Map]; exists {
+		return fmt.Errorf("%w: %s", ErrMapExists, config.Map)
+	}
+	if err := pm.validateLocked(config); err != nil {
+		return err
+	}
 
-	cmd := exec.Command("tasklist", "/FI", "PID eq "+pidStr)
-	output, err := cmd.Output()
+	err := pm.updateConfigFile(func(configs []ProcessConfig) []ProcessConfig {
+		return append(configs, config)
+	})
 	if err != nil {
-		log.Printf("Error executing tasklist command: %v", err)
-		return false
+		return err
 	}
+	pm.configs[config.Map] = config
+	return nil
+}
 
-	return strings.Contains(string(output), pidStr)
+// Validate checks a new map's launch config and that its ports are not
+// used by a registered map
+func (pm *ProcessManager) Validate(config ProcessConfig) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.validateLocked(config)
 }
 
-func SavePID(filename string, pid int) error {
-	dir := filepath.Dir(filename)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		log.Printf("Directory %s does not exist. Creating...", dir)
-		if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
-			return fmt.Errorf("failed to create directory %s: %v", dir, mkErr)
-		}
+func (pm *ProcessManager) validateLocked(config ProcessConfig) error {
+	configs := make([]ProcessConfig, 0, len(pm.configs)+1)
+	for _, c := range pm.configs {
+		configs = append(configs, c)
 	}
+	return ValidateConfigs(append(configs, config))
+}
 
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create PID file %s: %v", filename, err)
+// UnregisterMap removes a stopped map's process configuration
+func (pm *ProcessManager) UnregisterMap(mapName string) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if _, exists := pm.configs[mapName]; !exists {
+		return fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
 	}
-	defer func() {
-		if closeErr := file.Close(); closeErr != nil {
-			log.Printf("Failed to close PID file %s: %v", filename, closeErr)
+	if pid, err := ReadPID(mapName); err == nil {
+		if _, running := serverRunning(pm.configs[mapName], pid); running {
+			return fmt.Errorf("%w: %s must be stopped first", ErrAlreadyRunning, mapName)
 		}
-	}()
+	}
 
-	_, err = fmt.Fprintf(file, "%d", pid)
+	err := pm.updateConfigFile(func(configs []ProcessConfig) []ProcessConfig {
+		kept := configs[:0]
+		for _, config := range configs {
+			if config.Map != mapName {
+				kept = append(kept, config)
+			}
+		}
+		return kept
+	})
 	if err != nil {
-		return fmt.Errorf("failed to write PID to file %s: %v", filename, err)
+		return err
 	}
 
-	log.Printf("PID %d saved to file %s", pid, filename)
+	delete(pm.configs, mapName)
+	if ms, exists := pm.states[mapName]; exists && ms.cancel != nil {
+		ms.cancel()
+	}
+	delete(pm.states, mapName)
+	if err := state.Delete(state.BucketProcesses, mapName); err != nil {
+		log.Printf("Failed to remove process state of '%s': %v", mapName, err)
+	}
 	return nil
 }
 
-func ReadPID(filename string) (int, error) {
-	data, err := os.ReadFile(filename)
+// SetMods replaces the mods list of a map and persists it, the server loads
+// the mods when it starts next
+func (pm *ProcessManager) SetMods(mapName string, ids []int) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	config, exists := pm.configs[mapName]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
+	}
+	updated, ok := config.withMods(ids)
+	if !ok {
+		return fmt.Errorf("%w: map %s has no mods in its launch options or arguments", ErrInvalidLaunch, mapName)
+	}
+
+	err := pm.updateConfigFile(func(configs []ProcessConfig) []ProcessConfig {
+		for i := range configs {
+			if configs[i].Map == mapName {
+				configs[i], _ = configs[i].withMods(ids)
+			}
+		}
+		return configs
+	})
 	if err != nil {
-		return 0, fmt.Errorf("failed to read PID file %s: %v", filename, err)
+		return err
 	}
-	var pid int
-	_, err = fmt.Sscanf(string(data), "%d", &pid)
+	pm.configs[mapName] = updated
+	return nil
+}
+
+// updateConfigFile rewrites the process config file, keeping the order of
+// the maps already in it. The file is read without the environment
+// overrides, so they never end up in it.
+func (pm *ProcessManager) updateConfigFile(fn func([]ProcessConfig) []ProcessConfig) error {
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+
+	var configs []ProcessConfig
+	if err := configfile.Read(pm.configFile, &configs); err != nil {
+		return fmt.Errorf("failed to read process config: %w", err)
+	}
+	return configfile.Save(pm.configFile, fn(configs))
+}
+
+func IsProcessRunning(pid int) bool {
+
+	pidStr := strconv.Itoa(pid)
+
+	cmd := exec.Command("tasklist", "/FI", "PID eq "+pidStr)
+	output, err := cmd.Output()
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse PID from file %s: %v", filename, err)
+		log.Printf("Error executing tasklist command: %v", err)
+		return false
 	}
-	return pid, nil
+
+	return strings.Contains(string(output), pidStr)
 }
 
-func RemovePID(filename string) error {
-	return os.Remove(filename)
+// serverRunning reports whether a map's server runs and its PID. Docker is
+// asked about containers, their PID changes with every start.
+func serverRunning(config ProcessConfig, pid int) (int, bool) {
+	if config.Docker != nil {
+		running, containerPID, _ := containerRunning(config.Map)
+		return containerPID, running
+	}
+	return pid, pid != 0 && IsProcessRunning(pid) && processMatches(config, pid)
 }
 
-func GeneratePIDFileName(mapName string) string {
-	return fmt.Sprintf("./data/%s.pid", mapName)
+// Running reports whether a map's server runs and its PID
+func (pm *ProcessManager) Running(mapName string) (int, bool) {
+	config, exists := pm.Config(mapName)
+	if !exists {
+		return 0, false
+	}
+	pid, err := ReadPID(mapName)
+	if err != nil {
+		return 0, false
+	}
+	return serverRunning(config, pid)
 }
 
-func (pm *ProcessManager) MonitorProcess(mapName string) {
+// ReadPID returns the PID recorded for a map, 0 when none is recorded
+func ReadPID(mapName string) (int, error) {
+	ps, err := state.Process(mapName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read PID of %s: %v", mapName, err)
+	}
+	return ps.PID, nil
+}
+
+// MonitorProcess keeps a map's server running until ctx is cancelled. A
+// process that exits is started again after the restart interval, unless
+// the map is in maintenance.
+func (pm *ProcessManager) MonitorProcess(ctx context.Context, mapName string) {
 	pm.mu.Lock()
 	config, exists := pm.configs[mapName]
 	pm.mu.Unlock()
@@ -139,256 +353,528 @@ func (pm *ProcessManager) MonitorProcess(mapName string) {
 		log.Printf("Process '%s' configuration not found. Skipping...", mapName)
 		return
 	}
-
-	pidFile := GeneratePIDFileName(mapName)
-	logFilePath := fmt.Sprintf("./stdout/%s.log", mapName)
+	interval := time.Duration(config.RestartInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
 
 	for {
-		pid, err := ReadPID(pidFile)
-		if err == nil && IsProcessRunning(pid) {
-			time.Sleep(time.Duration(config.RestartInterval) * time.Second)
-			continue
-		}
-
-		if myMap[mapName] {
-			myMap[mapName] = true
-			myMapSarted[mapName] = true
-
-			// Close and remove the old log file
-			if err := pm.CopyAndTimestampLogFile(mapName); err != nil {
-				log.Printf("Error copying log file: %v", err)
-			}
-
-			if err := os.Remove(logFilePath); err != nil {
-				log.Printf("Error removing old log file: %v", err)
-			}
-
-			cmd := exec.Command(config.Executable, config.Args...)
-			cmd.Dir = filepath.Dir(config.Executable)
-
-			stdoutPipe, err := cmd.StdoutPipe()
-			if err != nil {
-				log.Printf("Failed to create stdout pipe for process '%s': %v", mapName, err)
-				time.Sleep(time.Duration(config.RestartInterval) * time.Second)
-				continue
+		pid, err := ReadPID(mapName)
+		if config.Docker != nil {
+			pm.checkContainer(ctx, mapName, config, pid)
+		} else if _, running := serverRunning(config, pid); err == nil && running {
+			if pm.setRunning(mapName, pid) && !maintenance.Active(mapName) {
+				pm.failStartup(mapName, config, pid)
 			}
-			stderrPipe, err := cmd.StderrPipe()
-			if err != nil {
-				log.Printf("Failed to create stderr pipe for process '%s': %v", mapName, err)
-				time.Sleep(time.Duration(config.RestartInterval) * time.Second)
-				continue
-			}
-
-			if err := cmd.Start(); err != nil {
-				log.Printf("Failed to start process '%s': %v", mapName, err)
-				time.Sleep(time.Duration(config.RestartInterval) * time.Second)
-				continue
-			}
-
-			// Create a new log file for the new process
-			logFile, err := CreateLogFile(mapName)
-			if err != nil {
-				log.Printf("Error creating new log file: %v", err)
-				time.Sleep(time.Duration(config.RestartInterval) * time.Second)
-				continue
+		} else {
+			if err == nil && pid != 0 {
+				// The process was not started by this manager, e.g. it
+				// was resumed after the API restarted, so nothing waits
+				// on it. Its PID may also have been reused by another
+				// program.
+				pm.processExited(mapName, pid, nil)
 			}
-			defer logFile.Close()
-
-			go func() {
-				scanner := bufio.NewScanner(stdoutPipe)
-				for scanner.Scan() {
-					logMessage := fmt.Sprintf("%s", scanner.Text())
-					if err := WriteLog(logFile, logMessage); err != nil {
-						log.Printf("Failed to write log: %v", err)
-					}
-				}
-			}()
-			go func() {
-				scanner := bufio.NewScanner(stderrPipe)
-				for scanner.Scan() {
-					logMessage := fmt.Sprintf("%s", scanner.Text())
-					if err := WriteLog(logFile, logMessage); err != nil {
-						log.Printf("Failed to write log: %v", err)
-					}
-				}
-			}()
-
-			if err := SavePID(pidFile, cmd.Process.Pid); err != nil {
-				log.Printf("Failed to save PID for process '%s': %v", mapName, err)
-				cmd.Process.Kill()
-				time.Sleep(time.Duration(config.RestartInterval) * time.Second)
-				continue
+			if pm.startAllowed(mapName) {
+				pm.recoverCrashLoop(mapName, config)
+				pm.startProcess(ctx, mapName, config)
 			}
+		}
 
-			log.Printf("Process '%s' started successfully with PID %d", mapName, cmd.Process.Pid)
-
-			pm.mu.Lock()
-			pm.processes[mapName] = cmd
-			pm.mu.Unlock()
-
-			go func() {
-				err := cmd.Wait()
-				if err != nil {
-					log.Printf("Process '%s' exited with error: %v", mapName, err)
-				}
-				if removeErr := RemovePID(pidFile); removeErr != nil {
-					log.Printf("Failed to remove PID file for process '%s': %v", mapName, removeErr)
-				}
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped monitoring process '%s'", mapName)
+			return
+		case <-time.After(interval):
+		}
+	}
+}
 
-				pm.mu.Lock()
-				delete(pm.processes, mapName)
-				pm.mu.Unlock()
-			}()
-		} else {
-			log.Printf("Process '%s' is not enabled. Skipping...", mapName)
-			break
+// checkContainer is the monitor's check of a map running as a container.
+// An unhealthy container is killed, it is started again like a crashed
+// process.
+func (pm *ProcessManager) checkContainer(ctx context.Context, mapName string, config ProcessConfig, pid int) {
+	running, containerPID, health := containerRunning(mapName)
+	if !running {
+		if pid != 0 {
+			pm.processExited(mapName, pid, nil)
 		}
+		if pm.startAllowed(mapName) {
+			pm.recoverCrashLoop(mapName, config)
+			pm.startContainer(ctx, mapName, config)
+		}
+		return
+	}
 
-		time.Sleep(time.Duration(config.RestartInterval) * time.Second)
+	paused := maintenance.Active(mapName)
+	if pm.setRunning(mapName, containerPID) && !paused {
+		pm.failStartup(mapName, config, containerPID)
+		return
+	}
+	pm.mu.Lock()
+	ms := pm.stateLocked(mapName)
+	changed := ms.Health != health
+	ms.Health = health
+	pm.mu.Unlock()
+	if changed && health == HealthUnhealthy && !paused {
+		log.Printf("Container of '%s' is unhealthy, killing it", mapName)
+		notify.Publish(notify.EventProcessHang, fmt.Sprintf("container of map %s failed its health check and is restarted", mapName),
+			map[string]interface{}{"map": mapName, "pid": containerPID, "health": health})
+		if err := killContainer(mapName); err != nil {
+			log.Printf("Failed to kill container of '%s': %v", mapName, err)
+		}
 	}
 }
 
-func (pm *ProcessManager) CopyAndTimestampLogFile(mapName string) error {
-	srcLogFileName := fmt.Sprintf("./stdout/%s.log", mapName)
-	if _, err := os.Stat(srcLogFileName); os.IsNotExist(err) {
-		log.Printf("No log file found to copy for process '%s'", mapName)
-		return nil // No old log file to copy
+func (pm *ProcessManager) startProcess(ctx context.Context, mapName string, config ProcessConfig) {
+	if ctx.Err() != nil {
+		return
+	}
+	if config.Docker != nil {
+		pm.startContainer(ctx, mapName, config)
+		return
 	}
 
-	timestamp := time.Now().Format("01-02-2006_03-04-05_pm")
-	dstLogFileName := fmt.Sprintf("./logs/%s_%s.log", mapName, timestamp)
+	cmd := exec.Command(config.Executable, config.CommandArgs()...)
+	cmd.Dir = filepath.Dir(config.Executable)
+	cmd.Env = config.environ()
+	prepareCommand(cmd, config)
 
-	inputFile, err := os.Open(srcLogFileName)
+	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to open log file %s: %v", srcLogFileName, err)
+		log.Printf("Failed to create stdout pipe for process '%s': %v", mapName, err)
+		return
 	}
-	defer inputFile.Close()
-
-	outputFile, err := os.Create(dstLogFileName)
+	stderrPipe, err := cmd.StderrPipe()
 	if err != nil {
-		return fmt.Errorf("failed to create log file %s: %v", dstLogFileName, err)
+		log.Printf("Failed to create stderr pipe for process '%s': %v", mapName, err)
+		return
 	}
-	defer outputFile.Close()
 
-	_, err = io.Copy(outputFile, inputFile)
+	// Archive the log of the previous run and start a new one
+	logFile, err := OpenLog(mapName, pm.logConfig)
 	if err != nil {
-		return fmt.Errorf("failed to copy log file to %s: %v", dstLogFileName, err)
+		log.Printf("Error creating new log file: %v", err)
+		return
 	}
 
-	log.Printf("Log file %s copied to %s", srcLogFileName, dstLogFileName)
-	return nil
+	if err := cmd.Start(); err != nil {
+		log.Printf("Failed to start process '%s': %v", mapName, err)
+		logFile.Close()
+		return
+	}
+	pid := cmd.Process.Pid
+	if err := applyScheduling(pid, config); err != nil {
+		// The server runs fine without it
+		log.Printf("Failed to apply the priority and affinity of process '%s': %v", mapName, err)
+	}
+
+	logFile.watch = pm.watchConsole(mapName, pid, config)
+	var pipes sync.WaitGroup
+	for _, pipe := range []io.Reader{stdoutPipe, stderrPipe} {
+		pipes.Add(1)
+		go func(pipe io.Reader) {
+			defer pipes.Done()
+			scanner := bufio.NewScanner(pipe)
+			for scanner.Scan() {
+				if err := logFile.WriteLine(scanner.Text()); err != nil {
+					log.Printf("Failed to write log: %v", err)
+				}
+			}
+		}(pipe)
+	}
+
+	if err := state.SetProcessPID(mapName, pid); err != nil {
+		log.Printf("Failed to save PID for process '%s': %v", mapName, err)
+		cmd.Process.Kill()
+	} else {
+		log.Printf("Process '%s' started successfully with PID %d", mapName, pid)
+		pm.mu.Lock()
+		pm.processes[mapName] = cmd
+		ms := pm.stateLocked(mapName)
+		ms.PID = pid
+		ms.transitionLocked(ms.Desired, ActualRunning, "process started")
+		ms.startingLocked()
+		pm.mu.Unlock()
+	}
+
+	go func() {
+		pipes.Wait()
+		err := cmd.Wait()
+		logFile.Close()
+		if err != nil {
+			log.Printf("Process '%s' exited with error: %v", mapName, err)
+		}
+		pm.processExited(mapName, pid, err)
+	}()
+}
+
+// setRunning records a running process found by the monitor. It reports
+// whether the server has exceeded its startup timeout.
+func (pm *ProcessManager) setRunning(mapName string, pid int) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	ms := pm.stateLocked(mapName)
+	ms.PID = pid
+	ms.startRequested = false
+	ms.transitionLocked(ms.Desired, ActualRunning, "process is running")
+	return ms.checkReadyLocked(pm.configs[mapName])
 }
 
-func CreateLogFile(mapName string) (*os.File, error) {
-	logFileName := fmt.Sprintf("./stdout/%s.log", mapName)
+// startAllowed reports whether the monitor may start a map's server that is
+// not running. A map in maintenance is only started once it is enabled
+// through EnableProcess, e.g. by a start or restart requested through the
+// API.
+func (pm *ProcessManager) startAllowed(mapName string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	file, err := os.Create(logFileName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create log file %s: %v", logFileName, err)
+	ms := pm.stateLocked(mapName)
+	requested := ms.startRequested
+	ms.startRequested = false
+	if requested || !maintenance.Active(mapName) {
+		ms.held = false
+		return true
+	}
+	if !ms.held {
+		ms.held = true
+		log.Printf("Map '%s' is in maintenance, its server is not started until it leaves maintenance", mapName)
 	}
-	return file, nil
+	return false
 }
 
-func WriteLog(file *os.File, message string) error {
+// processExited records the exit of a map's process, which crashed if the
+// map is still enabled. Exits of processes that were replaced are ignored.
+func (pm *ProcessManager) processExited(mapName string, pid int, err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	_, err := file.WriteString(message + "\n")
+	if cmd, exists := pm.processes[mapName]; exists && cmd.Process.Pid == pid {
+		delete(pm.processes, mapName)
+	}
+	if recorded, readErr := ReadPID(mapName); readErr == nil && recorded == pid {
+		if removeErr := state.SetProcessPID(mapName, 0); removeErr != nil {
+			log.Printf("Failed to clear PID for process '%s': %v", mapName, removeErr)
+		}
+	}
+	ms := pm.stateLocked(mapName)
+	if ms.PID != pid {
+		return
+	}
+
+	ms.PID = 0
+	ms.Health = ""
+	reason := "process exited"
 	if err != nil {
-		return fmt.Errorf("failed to write to log file: %v", err)
+		reason = "process exited: " + err.Error()
+	}
+	if ms.exitReason != "" {
+		reason = ms.exitReason
+		ms.exitReason = ""
+	}
+	if ms.Desired == DesiredEnabled {
+		log.Printf("Process '%s' (PID %d) exited while enabled, it will be restarted", mapName, pid)
+		ms.transitionLocked(ms.Desired, ActualCrashed, reason)
+		return
 	}
+	ms.transitionLocked(ms.Desired, ActualStopped, reason)
+}
+
+// StartAllProcesses reconciles the recorded PIDs with the running
+// processes, resumes monitoring of running servers, including orphaned ones
+// it adopts, and starts the maps that were enabled when the API stopped
+func (pm *ProcessManager) StartAllProcesses() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	alive := pm.reconcileLocked()
+	for mapName, config := range pm.configs {
+		ps, err := state.Process(mapName)
+		if err != nil {
+			log.Printf("Failed to read state of process '%s': %v", mapName, err)
+			continue
+		}
 
-	if err := file.Sync(); err != nil {
-		return fmt.Errorf("failed to flush log file: %v", err)
+		pid, running := alive[mapName]
+		if config.Docker != nil {
+			pid, running = serverRunning(config, ps.PID)
+		}
+		if !running {
+			// The map would fail to start or bind next to another server
+			if conflicts := portConflicts(config); len(conflicts) > 0 {
+				log.Printf("Ports of '%s' are already in use on this host: %s", mapName, strings.Join(conflicts, ", "))
+				notify.Publish(notify.EventPortConflict, fmt.Sprintf("ports of map %s are in use by another program: %s", mapName, strings.Join(conflicts, ", ")),
+					map[string]interface{}{"map": mapName, "ports": conflicts})
+			}
+		}
+		switch {
+		case running:
+			log.Printf("Resuming monitoring of existing process '%s' with PID %d", mapName, pid)
+			pm.enableLocked(mapName, "resumed running process")
+		case ps.Enabled:
+			log.Printf("Process '%s' is enabled but not running, starting it", mapName)
+			pm.enableLocked(mapName, "enabled before restart")
+		default:
+			log.Printf("Process '%s' is not enabled. Skipping...", mapName)
+		}
 	}
+}
 
-	return nil
+func (pm *ProcessManager) EnableProcess(mapName string) (string, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if _, exists := pm.configs[mapName]; !exists {
+		return "", fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
+	}
+	if pm.stateLocked(mapName).Desired == DesiredEnabled {
+		return "", fmt.Errorf("%w: %s", ErrAlreadyRunning, mapName)
+	}
+	if err := pm.checkQuotaLocked(mapName); err != nil {
+		return "", err
+	}
+	pm.enableLocked(mapName, "enabled")
+	pm.stateLocked(mapName).startRequested = true
+	return "Successfully started the map " + mapName, nil
 }
 
-func RetrieveLogs(mapName string) (string, error) {
-	logFileName := fmt.Sprintf("./stdout/%s.log", mapName)
+// Enabled returns how many of maps are enabled
+func (pm *ProcessManager) Enabled(maps []string) int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.enabledLocked(maps)
+}
 
-	file, err := os.Open(logFileName)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return "No logs found for the specified process.", nil
+func (pm *ProcessManager) enabledLocked(maps []string) int {
+	enabled := 0
+	for _, m := range maps {
+		if ms, exists := pm.states[m]; exists && ms.Desired == DesiredEnabled {
+			enabled++
 		}
-		return "", fmt.Errorf("failed to open log file %s: %w", logFileName, err)
 	}
-	defer file.Close()
+	return enabled
+}
 
-	stat, err := file.Stat()
-	if err != nil {
-		return "", fmt.Errorf("failed to stat log file %s: %w", logFileName, err)
+// checkQuotaLocked reports why the quota of the tenant owning a map does
+// not allow enabling another of its servers
+func (pm *ProcessManager) checkQuotaLocked(mapName string) error {
+	t, quota, ok := tenants.QuotaOf(mapName)
+	if !ok || quota.Servers == 0 {
+		return nil
 	}
+	if enabled := pm.enabledLocked(t.Maps); enabled >= quota.Servers {
+		return fmt.Errorf("%w: tenant %s may run %d servers at once and runs %d", tenants.ErrQuotaExceeded, t.Name, quota.Servers, enabled)
+	}
+	return nil
+}
 
-	if stat.Size() == 0 {
-		return "Log file is empty.", nil
+// enableLocked marks a map as enabled and starts its monitor
+func (pm *ProcessManager) enableLocked(mapName string, reason string) {
+	ms := pm.stateLocked(mapName)
+	if ms.cancel != nil {
+		ms.cancel()
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ms.cancel = cancel
+	ms.transitionLocked(DesiredEnabled, ms.Actual, reason)
 
-	data := make([]byte, stat.Size())
-	_, err = file.Read(data)
-	if err != nil {
-		return "", fmt.Errorf("failed to read log file %s: %w", logFileName, err)
+	if err := state.SetProcessEnabled(mapName, true); err != nil {
+		log.Printf("Failed to persist enabled state of '%s': %v", mapName, err)
 	}
+	go pm.MonitorProcess(ctx, mapName)
+}
+
+// Config returns the process configuration of a map
+func (pm *ProcessManager) Config(mapName string) (ProcessConfig, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	return string(data), nil
+	config, exists := pm.configs[mapName]
+	return config, exists
 }
 
-func (pm *ProcessManager) StartAllProcesses() {
+// HasMap reports whether a map has a process configuration
+func (pm *ProcessManager) HasMap(mapName string) bool {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	for mapName := range pm.configs {
-		pidFile := GeneratePIDFileName(mapName)
-		if _, err := os.Stat(pidFile); err == nil {
-
-			pid, err := ReadPID(pidFile)
-			if err == nil && IsProcessRunning(pid) {
-				log.Printf("Resuming monitoring of existing process '%s' with PID %d", mapName, pid)
-				myMap[mapName] = true
-				myMapSarted[mapName] = true
-				go pm.MonitorProcess(mapName)
-				continue
-			}
+	_, exists := pm.configs[mapName]
+	return exists
+}
+
+// RestartProcess stops a map's server, killing it if it has not exited
+// within timeout, and starts it again. It returns once the new process is
+// running or timeout has passed. A backup or restore of the map is waited
+// for first, the restart runs as a job that can be cancelled until then.
+func (pm *ProcessManager) RestartProcess(mapName string, timeout time.Duration) (err error) {
+	if !pm.HasMap(mapName) {
+		return fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
+	}
+	job := jobs.New(jobs.TypeRestart, mapName)
+	defer func() { job.Finish(err) }()
+	return pm.RestartForJob(job, mapName, timeout)
+}
+
+// RestartForJob is RestartProcess as a restart job of the caller, which
+// can record why the map restarts and finishes the job
+func (pm *ProcessManager) RestartForJob(job *jobs.Handle, mapName string, timeout time.Duration) error {
+	if !pm.HasMap(mapName) {
+		return fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
+	}
+	job.SetCancel(nil)
+
+	release, err := maplock.Acquire(job.Context(), mapName, maplock.OpRestart)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := job.Begin(); err != nil {
+		return err
+	}
+
+	config, _ := pm.Config(mapName)
+	oldPID, err := ReadPID(mapName)
+	if err != nil {
+		return err
+	}
+	if pm.Snapshot != nil && oldPID != 0 {
+		job.Logf("Saving the world")
+		if _, err := rcon.Execute(mapName, "saveworld"); err != nil {
+			log.Printf("Failed to save map '%s' before the restart: %v", mapName, err)
 		}
+		if err := pm.Snapshot(job, mapName); err != nil {
+			return err
+		}
+	}
+	job.Logf("Stopping PID %d", oldPID)
+	if err := pm.StopAndWait(mapName, timeout); err != nil {
+		return err
+	}
 
-		log.Printf("PID file for '%s' is missing or invalid. Skipping process...", mapName)
+	job.Logf("Starting the server")
+	if _, err := pm.EnableProcess(mapName); err != nil {
+		return err
 	}
+
+	started := waitFor(timeout, func() bool {
+		pid, err := ReadPID(mapName)
+		if err != nil || pid == 0 || pid == oldPID {
+			return false
+		}
+		_, running := serverRunning(config, pid)
+		return running
+	})
+	if !started {
+		return fmt.Errorf("process '%s' did not start within %s", mapName, timeout)
+	}
+	return nil
 }
 
-func (pm *ProcessManager) EnableProcess(mapName string) string {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
+// StopAndWait disables a map and waits for its server to exit, killing it
+// if it has not exited within timeout
+func (pm *ProcessManager) StopAndWait(mapName string, timeout time.Duration) error {
+	config, _ := pm.Config(mapName)
+	pid, err := ReadPID(mapName)
+	if err != nil {
+		return err
+	}
 
-	if _, exists := pm.configs[mapName]; exists {
-		if myMapSarted[mapName] {
-			log.Printf("Map already running")
-			return "Map already running"
+	if _, err := pm.DisableProcess(mapName); err != nil {
+		if errors.Is(err, ErrMapNotFound) {
+			return err
 		}
-		myMap[mapName] = true
-		go pm.MonitorProcess(mapName)
-		return "Successfully started the map " + mapName
+		log.Printf("Graceful shutdown of '%s' failed: %v", mapName, err)
 	}
 
-	return "Eror: Map " + mapName + " not found"
+	if config.Docker != nil {
+		if !waitFor(timeout, func() bool { _, running := serverRunning(config, pid); return !running }) {
+			log.Printf("Container of '%s' did not exit within %s, killing it", mapName, timeout)
+			if err := killContainer(mapName); err != nil {
+				return fmt.Errorf("failed to kill container of '%s': %w", mapName, err)
+			}
+		}
+		return nil
+	}
+	if pid != 0 && !waitFor(timeout, func() bool { return !IsProcessRunning(pid) }) {
+		log.Printf("Process '%s' did not exit within %s, killing PID %d", mapName, timeout, pid)
+		if proc, err := os.FindProcess(pid); err == nil {
+			if err := proc.Kill(); err != nil {
+				return fmt.Errorf("failed to kill process '%s': %w", mapName, err)
+			}
+		}
+	}
+	return nil
 }
 
-func mergedID(m string, e string) string {
-	return fmt.Sprintf("%s%s", m, e)
+// waitFor polls cond every second until it holds or timeout has passed
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
 }
 
-func (pm *ProcessManager) DisableProcess(mapName string) string {
+func (pm *ProcessManager) DisableProcess(mapName string) (string, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	myMap[mapName] = false
-	myMapSarted[mapName] = false
+	if _, exists := pm.configs[mapName]; !exists {
+		return "", fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
+	}
+
+	ms := pm.stateLocked(mapName)
+	if ms.cancel != nil {
+		ms.cancel()
+		ms.cancel = nil
+	}
+	actual := ms.Actual
+	if actual == ActualCrashed {
+		actual = ActualStopped
+	}
+	ms.transitionLocked(DesiredDisabled, actual, "disabled")
+	if err := state.SetProcessEnabled(mapName, false); err != nil {
+		log.Printf("Failed to persist disabled state of '%s': %v", mapName, err)
+	}
 
 	if rcon.DummyRcon(mapName, "doexit") == "Exiting... \n " {
-		delete(pm.processes, mapName)
-		RemovePID(mergedID(mapName, "_saved.pid"))
-		RemovePID(mergedID(mapName, ".save"))
-		return "Successfully stopped the map " + mapName
+		return "Successfully stopped the map " + mapName, nil
 	}
 
-	return "Error: Shutting down the map " + mapName
+	return "", fmt.Errorf("failed to shut down the map %s", mapName)
+}
+
+// PlanStop returns what DisableProcess would do, for a dry run
+func (pm *ProcessManager) PlanStop(mapName string) (dryrun.Plan, error) {
+	if !pm.HasMap(mapName) {
+		return dryrun.Plan{}, fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
+	}
+	plan := dryrun.Plan{Operation: "stop", Map: mapName}
+	plan.Stepf("Disable map %s so it is not started again", mapName)
+	plan.Commands = append(plan.Commands, "doexit")
+	return plan, nil
+}
+
+// PlanStopAndWait returns what StopAndWait would do, for a dry run
+func (pm *ProcessManager) PlanStopAndWait(mapName string, timeout time.Duration) (dryrun.Plan, error) {
+	plan, err := pm.PlanStop(mapName)
+	if err != nil {
+		return plan, err
+	}
+	config, _ := pm.Config(mapName)
+	pid, err := ReadPID(mapName)
+	if err != nil {
+		return plan, err
+	}
+	if config.Docker != nil {
+		plan.Killed = append(plan.Killed, fmt.Sprintf("container %s if it has not exited within %s", containerName(mapName), timeout))
+	} else if pid != 0 && IsProcessRunning(pid) {
+		plan.Killed = append(plan.Killed, fmt.Sprintf("PID %d if it has not exited within %s", pid, timeout))
+	}
+	return plan, nil
 }
@@ -0,0 +1,232 @@
+package processmanager
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/state"
+)
+
+// Readiness is whether a running server has finished loading its world
+type Readiness string
+
+const (
+	// ReadinessStarting is a server process that is up but still loading,
+	// it does not answer RCON or accept players yet
+	ReadinessStarting Readiness = "starting"
+	ReadinessReady    Readiness = "ready"
+
+	defaultReadyTimeoutSeconds = 1800
+
+	// startupKillTimeout is how long a server killed for failing to start
+	// may take to exit
+	startupKillTimeout = 30 * time.Second
+)
+
+// defaultReadyMarkers are console lines ASA prints once the world is loaded
+// and the server is listening for players
+var defaultReadyMarkers = []string{
+	"has successfully started",
+	"Server has completed startup and is now advertising for join",
+}
+
+// Ready reports whether the map's server is running and has finished
+// loading
+func (s MapState) Ready() bool {
+	return s.Actual == ActualRunning && s.Readiness == ReadinessReady
+}
+
+func (c ProcessConfig) readyMarkers() []string {
+	if len(c.ReadyMarkers) == 0 {
+		return defaultReadyMarkers
+	}
+	return c.ReadyMarkers
+}
+
+func (c ProcessConfig) readyTimeout() time.Duration {
+	if c.ReadyTimeoutSeconds <= 0 {
+		return defaultReadyTimeoutSeconds * time.Second
+	}
+	return time.Duration(c.ReadyTimeoutSeconds) * time.Second
+}
+
+func (c ProcessConfig) startupTimeout() time.Duration {
+	return time.Duration(c.StartupTimeoutSeconds) * time.Second
+}
+
+// watchReady returns a function that scans the console output of the
+// process with pid for the map's ready markers. Matching ignores case.
+// watchConsole returns the watch of a server's console log: its readiness
+// and the ConsoleLine hook
+func (pm *ProcessManager) watchConsole(mapName string, pid int, config ProcessConfig) func(line string) {
+	ready := pm.watchReady(mapName, pid, config)
+	return func(line string) {
+		ready(line)
+		if pm.ConsoleLine != nil {
+			pm.ConsoleLine(mapName, line)
+		}
+	}
+}
+
+func (pm *ProcessManager) watchReady(mapName string, pid int, config ProcessConfig) func(line string) {
+	markers := make([]string, 0, len(config.readyMarkers()))
+	for _, marker := range config.readyMarkers() {
+		markers = append(markers, strings.ToLower(marker))
+	}
+	var seen atomic.Bool
+	return func(line string) {
+		if seen.Load() {
+			return
+		}
+		line = strings.ToLower(line)
+		for _, marker := range markers {
+			if strings.Contains(line, marker) {
+				seen.Store(true)
+				pm.mu.Lock()
+				if ms := pm.stateLocked(mapName); ms.PID == pid {
+					ms.readyLocked("console reported " + marker)
+				}
+				pm.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// startingLocked marks a server that was just started as loading
+func (ms *mapState) startingLocked() {
+	ms.Readiness = ReadinessStarting
+	ms.ReadySince = time.Time{}
+	// A map that was off, or never ran, is not down until its server fails
+	// to start
+	if recorded := ms.recordedAvailabilityLocked(); recorded != AvailabilityOff && recorded != "" {
+		ms.availabilityLocked(AvailabilityDown, "server is starting")
+	}
+}
+
+// checkReadyLocked is the monitor's check of a running server's readiness.
+// Servers adopted after a manager restart have no captured output and are
+// taken as ready, as are servers that never printed a marker within the
+// timeout, e.g. because they log to a file only. With a startup timeout it
+// instead reports whether the server has exceeded it.
+func (ms *mapState) checkReadyLocked(config ProcessConfig) bool {
+	switch {
+	case ms.Readiness == "":
+		ms.readyLocked("adopted running server, its console output is not captured")
+	case ms.Readiness != ReadinessStarting:
+	case config.StartupTimeoutSeconds > 0:
+		return time.Since(ms.Since) >= config.startupTimeout()
+	case time.Since(ms.Since) >= config.readyTimeout():
+		ms.readyLocked(fmt.Sprintf("no ready marker within %s, assuming it is ready", config.readyTimeout()))
+	}
+	return false
+}
+
+// readyLocked records that a running server has finished loading
+func (ms *mapState) readyLocked(reason string) {
+	if ms.Actual != ActualRunning || ms.Readiness == ReadinessReady {
+		return
+	}
+	ms.Readiness = ReadinessReady
+	ms.ReadySince = time.Now()
+	ms.StartupFailures = 0
+	ms.CrashLoop = 0
+	ms.recovered = false
+	if err := state.SetProcessReady(ms.Map, ms.ReadySince); err != nil {
+		log.Printf("Failed to save ready time of '%s': %v", ms.Map, err)
+	}
+	ms.availabilityLocked(AvailabilityUp, reason)
+
+	data := map[string]interface{}{
+		"map":       ms.Map,
+		"pid":       ms.PID,
+		"readiness": ms.Readiness,
+		"reason":    reason,
+		"load_time": ms.ReadySince.Sub(ms.Since).Round(time.Second).Seconds(),
+	}
+	message := fmt.Sprintf("map %s is ready: %s", ms.Map, reason)
+	notify.Publish(notify.EventProcessReady, message, data)
+	notify.Stream(notify.EventProcessState, message, data)
+}
+
+// failStartup kills a server that has not finished loading within its
+// startup timeout, which counts as a crash. If the map rolls back on failed
+// starts, the first failure in a row restores the last backup the server
+// loaded from before the monitor starts it again. Corrupted saves are the
+// usual reason for a server that never gets ready.
+func (pm *ProcessManager) failStartup(mapName string, config ProcessConfig, pid int) {
+	reason := fmt.Sprintf("not ready within the startup timeout of %s", config.startupTimeout())
+	pm.mu.Lock()
+	ms := pm.stateLocked(mapName)
+	if ms.PID != pid || ms.Readiness != ReadinessStarting {
+		pm.mu.Unlock()
+		return
+	}
+	ms.exitReason = "killed, " + reason
+	ms.StartupFailures++
+	failures := ms.StartupFailures
+	pm.mu.Unlock()
+
+	log.Printf("Process '%s' (PID %d) is %s, killing it", mapName, pid, reason)
+	if config.Docker != nil {
+		if err := killContainer(mapName); err != nil {
+			log.Printf("Failed to kill container of '%s': %v", mapName, err)
+		}
+	} else if proc, err := os.FindProcess(pid); err == nil {
+		if err := proc.Kill(); err != nil {
+			log.Printf("Failed to kill process '%s': %v", mapName, err)
+		}
+	}
+	if !waitFor(startupKillTimeout, func() bool { _, running := serverRunning(config, pid); return !running }) {
+		// The monitor tries again on its next check
+		log.Printf("Process '%s' (PID %d) did not exit within %s after it was killed", mapName, pid, startupKillTimeout)
+		pm.mu.Lock()
+		pm.stateLocked(mapName).exitReason = ""
+		pm.mu.Unlock()
+		return
+	}
+	// Adopted processes have nobody waiting on them
+	pm.processExited(mapName, pid, nil)
+
+	data := map[string]interface{}{
+		"map":      mapName,
+		"pid":      pid,
+		"timeout":  config.startupTimeout().Seconds(),
+		"failures": failures,
+	}
+	message := fmt.Sprintf("map %s was %s and is restarted", mapName, reason)
+	if config.RollbackOnStartupFailure && failures == 1 {
+		archive, err := pm.rollback(mapName)
+		if err != nil {
+			log.Printf("Rollback of '%s' after its failed start failed: %v", mapName, err)
+			data["rollback_error"] = err.Error()
+			message += ", rolling back its saves failed: " + err.Error()
+		} else {
+			log.Printf("Rolled back '%s' to %s after its failed start", mapName, archive)
+			data["rollback"] = archive
+			message += ", its saves were rolled back to " + archive
+		}
+	}
+	notify.Publish(notify.EventStartupFailed, message, data)
+}
+
+// rollback restores the newest backup of a map from before it was last
+// ready
+func (pm *ProcessManager) rollback(mapName string) (string, error) {
+	if pm.Rollback == nil {
+		return "", fmt.Errorf("no backups are available to the process manager")
+	}
+	ps, err := state.Process(mapName)
+	if err != nil {
+		return "", err
+	}
+	if ps.LastReady.IsZero() {
+		return "", fmt.Errorf("the server has never been ready, there is no known-good save")
+	}
+	return pm.Rollback(mapName, ps.LastReady)
+}
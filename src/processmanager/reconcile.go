@@ -0,0 +1,198 @@
+package processmanager
+
+import (
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"asa_servermanager_api/state"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// processMatches reports whether the process with pid runs the map's
+// executable with its arguments. The OS reuses PIDs, so a recorded PID of a
+// running process does not prove it is still the map's server. A process
+// whose executable cannot be read, e.g. for lack of permissions, is trusted.
+func processMatches(config ProcessConfig, pid int) bool {
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return false
+	}
+	return commandMatches(config, p)
+}
+
+func commandMatches(config ProcessConfig, p *process.Process) bool {
+	exe, err := p.Exe()
+	if err != nil {
+		cmdline, err := p.CmdlineSlice()
+		if err != nil || len(cmdline) == 0 {
+			return true
+		}
+		exe = cmdline[0]
+	}
+	if !samePath(exe, config.Executable) {
+		return false
+	}
+
+	// Maps installed together share the executable, their arguments tell
+	// them apart. Whole arguments are compared, -port=7777 must not match
+	// -port=77770.
+	args, err := processArgs(p)
+	if err != nil {
+		return true
+	}
+	present := make(map[string]bool, len(args))
+	for _, arg := range args {
+		present[arg] = true
+	}
+	for _, arg := range config.CommandArgs() {
+		if !present[arg] {
+			return false
+		}
+	}
+	return true
+}
+
+// processArgs returns the arguments of a process. Windows keeps a process's
+// command line as one string with the arguments that contain spaces quoted.
+func processArgs(p *process.Process) ([]string, error) {
+	if runtime.GOOS != "windows" {
+		return p.CmdlineSlice()
+	}
+	cmdline, err := p.Cmdline()
+	if err != nil {
+		return nil, err
+	}
+	return SplitCommandLine(cmdline), nil
+}
+
+// SplitCommandLine splits a line into arguments at spaces outside double
+// quotes, which are dropped
+func SplitCommandLine(line string) []string {
+	var args []string
+	var arg strings.Builder
+	quoted, started := false, false
+	for _, r := range strings.TrimSpace(line) {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case (r == ' ' || r == '\t' || r == '\r') && !quoted:
+			if started {
+				args = append(args, arg.String())
+				arg.Reset()
+				started = false
+			}
+		default:
+			arg.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+func samePath(a string, b string) bool {
+	if absA, err := filepath.Abs(a); err == nil {
+		a = absA
+	}
+	if absB, err := filepath.Abs(b); err == nil {
+		b = absB
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// findOrphans returns the PIDs of running servers started from the
+// executables of maps without a recorded PID, e.g. because the state store
+// was lost or the manager crashed between starting a server and recording
+// it. The known PIDs belong to other maps.
+func findOrphans(configs map[string]ProcessConfig, maps []string, known map[string]int) map[string]int {
+	orphans := make(map[string]int)
+	if len(maps) == 0 {
+		return orphans
+	}
+	procs, err := process.Processes()
+	if err != nil {
+		log.Printf("Failed to list processes to find orphaned servers: %v", err)
+		return orphans
+	}
+
+	claimed := make(map[int32]bool)
+	for _, pid := range known {
+		claimed[int32(pid)] = true
+	}
+	for _, mapName := range maps {
+		config := configs[mapName]
+		for _, p := range procs {
+			if claimed[p.Pid] {
+				continue
+			}
+			// Unreadable processes cannot be told apart from other servers
+			if _, err := p.Exe(); err != nil {
+				continue
+			}
+			if commandMatches(config, p) {
+				claimed[p.Pid] = true
+				orphans[mapName] = int(p.Pid)
+				break
+			}
+		}
+	}
+	return orphans
+}
+
+// reconcileLocked checks the recorded PIDs against the running processes
+// before the maps are started. PIDs of exited processes and PIDs reused by
+// other programs are cleared, servers running without a recorded PID are
+// adopted. It returns the maps whose server is running.
+func (pm *ProcessManager) reconcileLocked() map[string]int {
+	running := make(map[string]int)
+	var unknown []string
+
+	for mapName, config := range pm.configs {
+		if config.Docker != nil {
+			// Docker knows which container is the map's
+			continue
+		}
+		ps, err := state.Process(mapName)
+		if err != nil {
+			log.Printf("Failed to read state of process '%s': %v", mapName, err)
+			continue
+		}
+
+		switch {
+		case ps.PID == 0:
+			unknown = append(unknown, mapName)
+		case IsProcessRunning(ps.PID) && processMatches(config, ps.PID):
+			running[mapName] = ps.PID
+		default:
+			if IsProcessRunning(ps.PID) {
+				log.Printf("PID %d of process '%s' now belongs to another program, clearing it", ps.PID, mapName)
+			} else {
+				log.Printf("Process '%s' with PID %d is no longer running, clearing its PID", mapName, ps.PID)
+			}
+			if err := state.SetProcessPID(mapName, 0); err != nil {
+				log.Printf("Failed to clear PID for process '%s': %v", mapName, err)
+				continue
+			}
+			unknown = append(unknown, mapName)
+		}
+	}
+
+	for mapName, pid := range findOrphans(pm.configs, unknown, running) {
+		if err := state.SetProcessPID(mapName, pid); err != nil {
+			log.Printf("Failed to record PID of orphaned process '%s': %v", mapName, err)
+			continue
+		}
+		log.Printf("Adopted orphaned process '%s' with PID %d", mapName, pid)
+		running[mapName] = pid
+	}
+	return running
+}
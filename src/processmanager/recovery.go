@@ -0,0 +1,79 @@
+package processmanager
+
+import (
+	"fmt"
+	"log"
+
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/notify"
+)
+
+const defaultRecoveryCrashes = 3
+
+// CrashRecoveryConfig controls the automatic rollback of a map that keeps
+// crashing before its server gets ready, usually on a corrupted save
+type CrashRecoveryConfig struct {
+	Enabled bool `json:"enabled"`
+	// Crashes is how many crashes in a row start a recovery, default 3
+	Crashes int `json:"crashes,omitempty"`
+}
+
+func (c *CrashRecoveryConfig) crashes() int {
+	if c.Crashes <= 0 {
+		return defaultRecoveryCrashes
+	}
+	return c.Crashes
+}
+
+// recoverCrashLoop rolls a crash-looping map back to its newest verified
+// backup from before the loop began, before the monitor starts it again.
+// It runs once per loop, as a recovery job whose log and result record
+// what was restored and where the old save was kept. A failed recovery
+// leaves the save alone, the monitor goes on restarting the server.
+func (pm *ProcessManager) recoverCrashLoop(mapName string, config ProcessConfig) {
+	if config.CrashRecovery == nil || !config.CrashRecovery.Enabled || maintenance.Active(mapName) {
+		return
+	}
+	pm.mu.Lock()
+	ms := pm.stateLocked(mapName)
+	if ms.Actual != ActualCrashed || ms.recovered || ms.CrashLoop < config.CrashRecovery.crashes() {
+		pm.mu.Unlock()
+		return
+	}
+	ms.recovered = true
+	crashes := ms.CrashLoop
+	before := ms.loopStarted
+	pm.mu.Unlock()
+
+	job := jobs.New(jobs.TypeRecovery, mapName)
+	job.NoCancel()
+	job.Start()
+	job.Logf("Crashed %d times in a row since %s without getting ready", crashes, before.Format("2006-01-02 15:04:05"))
+	log.Printf("Process '%s' crashed %d times in a row, rolling back its save", mapName, crashes)
+
+	var err error
+	if pm.Recover == nil {
+		err = fmt.Errorf("no backups are available to the process manager")
+	} else {
+		var result interface{}
+		result, err = pm.Recover(job, mapName, before)
+		job.SetResult(result)
+	}
+	job.Finish(err)
+
+	data := map[string]interface{}{
+		"map":     mapName,
+		"crashes": crashes,
+		"job":     job.ID(),
+	}
+	message := fmt.Sprintf("map %s crashed %d times in a row, its save was rolled back", mapName, crashes)
+	if err != nil {
+		log.Printf("Crash recovery of '%s' failed: %v", mapName, err)
+		data["error"] = err.Error()
+		message = fmt.Sprintf("map %s crashed %d times in a row, rolling back its save failed: %v", mapName, crashes, err)
+	} else {
+		log.Printf("Rolled back the save of '%s' in job %s", mapName, job.ID())
+	}
+	notify.Publish(notify.EventCrashRecovery, message, data)
+}
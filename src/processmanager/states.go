@@ -0,0 +1,182 @@
+package processmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"asa_servermanager_api/notify"
+)
+
+// Desired is whether a map should be kept running
+type Desired string
+
+// Actual is what the map's server process is doing
+type Actual string
+
+const (
+	DesiredEnabled  Desired = "enabled"
+	DesiredDisabled Desired = "disabled"
+
+	ActualRunning Actual = "running"
+	ActualStopped Actual = "stopped"
+	// ActualCrashed is a process that exited while the map was enabled, the
+	// monitor starts it again after the restart interval
+	ActualCrashed Actual = "crashed"
+)
+
+// maxTransitions is how many transitions are kept per map
+const maxTransitions = 20
+
+// Transition is a change of a map's desired or actual state
+type Transition struct {
+	Time    time.Time `json:"time"`
+	Desired Desired   `json:"desired"`
+	Actual  Actual    `json:"actual"`
+	Reason  string    `json:"reason"`
+}
+
+// MapState is the state of a map's server process. Health is the Docker
+// health of containers with a health check, Readiness whether a running
+// server has finished loading.
+type MapState struct {
+	Map        string    `json:"map"`
+	Desired    Desired   `json:"desired"`
+	Actual     Actual    `json:"actual"`
+	PID        int       `json:"pid"`
+	Health     string    `json:"health,omitempty"`
+	Readiness  Readiness `json:"readiness,omitempty"`
+	ReadySince time.Time `json:"ready_since,omitempty"`
+	Since      time.Time `json:"since"`
+	Crashes    int       `json:"crashes"`
+	// StartupFailures counts the starts in a row that did not finish
+	// loading within the startup timeout
+	StartupFailures int `json:"startup_failures,omitempty"`
+	// CrashLoop counts the crashes since the server was last ready
+	CrashLoop   int          `json:"crash_loop,omitempty"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// mapState is the per-map state kept by a ProcessManager, guarded by pm.mu
+type mapState struct {
+	MapState
+	// cancel stops the map's monitor, nil when none is running
+	cancel context.CancelFunc
+	// exitReason replaces the reason recorded for the next exit of the
+	// process, for processes the manager kills
+	exitReason string
+	// loopStarted is the time of the first crash of the crash loop, and
+	// recovered whether the loop was recovered from already
+	loopStarted time.Time
+	recovered   bool
+	// startRequested lets the monitor start a map in maintenance after it
+	// was enabled, held is set once it has logged that it holds it back
+	startRequested bool
+	held           bool
+	// availability is the last recorded availability, see
+	// availabilityLocked
+	availability string
+}
+
+// stateLocked returns the state of a map, creating it as disabled and
+// stopped
+func (pm *ProcessManager) stateLocked(mapName string) *mapState {
+	ms, exists := pm.states[mapName]
+	if !exists {
+		ms = &mapState{MapState: MapState{
+			Map:     mapName,
+			Desired: DesiredDisabled,
+			Actual:  ActualStopped,
+			Since:   time.Now(),
+		}}
+		pm.states[mapName] = ms
+	}
+	return ms
+}
+
+// transitionLocked records a change of state, unchanged states are ignored
+func (ms *mapState) transitionLocked(desired Desired, actual Actual, reason string) {
+	if ms.Desired == desired && ms.Actual == actual {
+		return
+	}
+	changed := ms.Actual != actual
+	if changed {
+		ms.Since = time.Now()
+	}
+	if actual == ActualCrashed {
+		ms.Crashes++
+		if ms.CrashLoop == 0 {
+			ms.loopStarted = time.Now()
+		}
+		ms.CrashLoop++
+	}
+	if actual != ActualRunning {
+		ms.Readiness = ""
+		ms.ReadySince = time.Time{}
+	}
+	ms.Desired = desired
+	ms.Actual = actual
+	ms.Transitions = append(ms.Transitions, Transition{Time: time.Now(), Desired: desired, Actual: actual, Reason: reason})
+	if len(ms.Transitions) > maxTransitions {
+		ms.Transitions = ms.Transitions[len(ms.Transitions)-maxTransitions:]
+	}
+
+	data := map[string]interface{}{
+		"map":     ms.Map,
+		"desired": desired,
+		"actual":  actual,
+		"pid":     ms.PID,
+		"reason":  reason,
+	}
+	message := fmt.Sprintf("map %s is %s: %s", ms.Map, actual, reason)
+	if event, ok := actualEvents[actual]; ok && changed {
+		notify.Publish(event, message, data)
+	}
+	notify.Stream(notify.EventProcessState, message, data)
+
+	// Running servers are up once they are ready, see readyLocked
+	switch {
+	case desired == DesiredDisabled:
+		ms.availabilityLocked(AvailabilityOff, reason)
+	case changed && (actual == ActualCrashed || actual == ActualStopped):
+		ms.availabilityLocked(AvailabilityDown, reason)
+	}
+}
+
+// actualEvents are the notifications sent when a map's process changes
+var actualEvents = map[Actual]string{
+	ActualRunning: notify.EventProcessStarted,
+	ActualStopped: notify.EventProcessStopped,
+	ActualCrashed: notify.EventProcessCrashed,
+}
+
+// State returns the state of a map
+func (pm *ProcessManager) State(mapName string) (MapState, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if _, exists := pm.configs[mapName]; !exists {
+		return MapState{}, false
+	}
+	return pm.stateLocked(mapName).snapshot(), true
+}
+
+// States returns the state of every map, sorted by name
+func (pm *ProcessManager) States() []MapState {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	states := make([]MapState, 0, len(pm.configs))
+	for mapName := range pm.configs {
+		states = append(states, pm.stateLocked(mapName).snapshot())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Map < states[j].Map })
+	return states
+}
+
+func (ms *mapState) snapshot() MapState {
+	s := ms.MapState
+	s.Transitions = append([]Transition{}, ms.Transitions...)
+	return s
+}
@@ -0,0 +1,267 @@
+package processmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/state"
+)
+
+// Availability of a map. A map is up while its server is ready and down
+// while it is enabled but not ready: starting, crashed or stopped. A
+// disabled map is off, which does not count against its uptime.
+const (
+	AvailabilityUp   = "up"
+	AvailabilityDown = "down"
+	AvailabilityOff  = "off"
+)
+
+const (
+	bucketAvailability = "availability"
+	// availabilityDay is the layout of the day in an availability key,
+	// changes are stored per map and day
+	availabilityDay = "2006-01-02"
+
+	// AvailabilityRetention is how long availability changes are kept
+	AvailabilityRetention = 90 * 24 * time.Hour
+
+	// maxRecentOutages is how many outages an uptime report lists
+	maxRecentOutages = 20
+)
+
+// AvailabilityChange is a change of a map's availability
+type AvailabilityChange struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Outage is a time a map was down. End is zero while it lasts.
+type Outage struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end,omitempty"`
+	DurationSeconds int64     `json:"duration_seconds"`
+	Reason          string    `json:"reason,omitempty"`
+}
+
+// Uptime is a map's availability over a range. UptimePercent is the share
+// of the time the map was up out of the time it was up or down, nil if it
+// was neither. The time before the first recorded change is unknown.
+type Uptime struct {
+	Map                  string    `json:"map"`
+	Range                string    `json:"range"`
+	From                 time.Time `json:"from"`
+	To                   time.Time `json:"to"`
+	Status               string    `json:"status,omitempty"`
+	UptimePercent        *float64  `json:"uptime_percent"`
+	UpSeconds            int64     `json:"up_seconds"`
+	DownSeconds          int64     `json:"down_seconds"`
+	OffSeconds           int64     `json:"off_seconds"`
+	UnknownSeconds       int64     `json:"unknown_seconds"`
+	Outages              int       `json:"outages"`
+	MTTRSeconds          int64     `json:"mttr_seconds"`
+	LongestOutageSeconds int64     `json:"longest_outage_seconds"`
+	// RecentOutages are the last outages of the range, newest first
+	RecentOutages []Outage `json:"recent_outages"`
+}
+
+var (
+	// availabilityMu serializes the updates of the availability history
+	availabilityMu sync.Mutex
+	lastPrune      string
+)
+
+// availabilityLocked records a change of a map's availability, the same
+// status as the last one is ignored
+func (ms *mapState) availabilityLocked(status string, reason string) {
+	if ms.recordedAvailabilityLocked() == status {
+		return
+	}
+	ms.availability = status
+	if err := recordAvailability(ms.Map, AvailabilityChange{Time: time.Now(), Status: status, Reason: reason}); err != nil {
+		log.Printf("Failed to record availability of '%s': %v", ms.Map, err)
+	}
+}
+
+// recordedAvailabilityLocked returns the last recorded availability of a
+// map, from before the manager started until it records one
+func (ms *mapState) recordedAvailabilityLocked() string {
+	if ms.availability == "" {
+		ms.availability = lastAvailability(ms.Map)
+	}
+	return ms.availability
+}
+
+func recordAvailability(mapName string, change AvailabilityChange) error {
+	availabilityMu.Lock()
+	defer availabilityMu.Unlock()
+
+	day := change.Time.Format(availabilityDay)
+	key := mapName + "/" + day
+	var changes []AvailabilityChange
+	if _, err := state.Get(bucketAvailability, key, &changes); err != nil {
+		return err
+	}
+	changes = append(changes, change)
+	if err := state.Put(bucketAvailability, key, changes); err != nil {
+		return err
+	}
+	if day != lastPrune {
+		lastPrune = day
+		pruneAvailability()
+	}
+	return nil
+}
+
+// pruneAvailability drops the days past the retention
+func pruneAvailability() {
+	cutoff := time.Now().Add(-AvailabilityRetention).Format(availabilityDay)
+	var expired []string
+	err := state.ForEach(bucketAvailability, func(key string, value []byte) error {
+		if i := strings.LastIndex(key, "/"); i >= 0 && key[i+1:] < cutoff {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read availability history for pruning: %v", err)
+		return
+	}
+	for _, key := range expired {
+		if err := state.Delete(bucketAvailability, key); err != nil {
+			log.Printf("Failed to remove availability history %s: %v", key, err)
+		}
+	}
+}
+
+// availabilityHistory returns the recorded changes of a map, oldest first
+func availabilityHistory(mapName string) ([]AvailabilityChange, error) {
+	var changes []AvailabilityChange
+	err := state.ForEach(bucketAvailability, func(key string, value []byte) error {
+		if !strings.HasPrefix(key, mapName+"/") || strings.Contains(key[len(mapName)+1:], "/") {
+			return nil
+		}
+		var day []AvailabilityChange
+		if err := json.Unmarshal(value, &day); err != nil {
+			return err
+		}
+		changes = append(changes, day...)
+		return nil
+	})
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.Before(changes[j].Time) })
+	return changes, err
+}
+
+// lastAvailability returns the last availability in the history of a map
+func lastAvailability(mapName string) string {
+	changes, err := availabilityHistory(mapName)
+	if err != nil || len(changes) == 0 {
+		return ""
+	}
+	return changes[len(changes)-1].Status
+}
+
+// Uptime reports a map's availability over the last span. The time the
+// manager was not running counts as the availability it last recorded.
+func (pm *ProcessManager) Uptime(mapName string, span time.Duration) (Uptime, error) {
+	if !pm.HasMap(mapName) {
+		return Uptime{}, fmt.Errorf("%w: %s", ErrMapNotFound, mapName)
+	}
+	changes, err := availabilityHistory(mapName)
+	if err != nil {
+		return Uptime{}, err
+	}
+	to := time.Now()
+	uptime := computeUptime(changes, to.Add(-span), to)
+	uptime.Map = mapName
+	uptime.Range = span.String()
+	return uptime, nil
+}
+
+func computeUptime(changes []AvailabilityChange, from time.Time, to time.Time) Uptime {
+	uptime := Uptime{From: from, To: to, RecentOutages: []Outage{}}
+	var outages []Outage
+	status, since := "", from
+	var outage *Outage
+
+	// account adds the time from since to t in the current status
+	account := func(t time.Time) {
+		if t.Before(since) {
+			return
+		}
+		seconds := int64(t.Sub(since).Seconds())
+		switch status {
+		case AvailabilityUp:
+			uptime.UpSeconds += seconds
+		case AvailabilityDown:
+			uptime.DownSeconds += seconds
+		case AvailabilityOff:
+			uptime.OffSeconds += seconds
+		default:
+			uptime.UnknownSeconds += seconds
+		}
+		since = t
+	}
+	for _, change := range changes {
+		if change.Time.After(to) {
+			break
+		}
+		at := change.Time
+		if at.Before(from) {
+			at = from
+		}
+		account(at)
+		if change.Status == status {
+			continue
+		}
+		if outage != nil {
+			// Outages that ended before the range are left out
+			if at.After(from) {
+				outage.End = at
+				outages = append(outages, *outage)
+			}
+			outage = nil
+		}
+		if change.Status == AvailabilityDown {
+			outage = &Outage{Start: at, Reason: change.Reason}
+		}
+		status = change.Status
+	}
+	account(to)
+	uptime.Status = status
+	if outage != nil {
+		outages = append(outages, *outage)
+	}
+
+	var resolved, recovery int64
+	for i := range outages {
+		end := outages[i].End
+		if end.IsZero() {
+			end = to
+		} else {
+			resolved++
+			recovery += int64(end.Sub(outages[i].Start).Seconds())
+		}
+		outages[i].DurationSeconds = int64(end.Sub(outages[i].Start).Seconds())
+		if outages[i].DurationSeconds > uptime.LongestOutageSeconds {
+			uptime.LongestOutageSeconds = outages[i].DurationSeconds
+		}
+	}
+	uptime.Outages = len(outages)
+	if resolved > 0 {
+		uptime.MTTRSeconds = recovery / resolved
+	}
+	for i := len(outages) - 1; i >= 0 && len(uptime.RecentOutages) < maxRecentOutages; i-- {
+		uptime.RecentOutages = append(uptime.RecentOutages, outages[i])
+	}
+	if monitored := uptime.UpSeconds + uptime.DownSeconds; monitored > 0 {
+		percent := float64(uptime.UpSeconds) * 100 / float64(monitored)
+		uptime.UptimePercent = &percent
+	}
+	return uptime
+}
@@ -0,0 +1,1613 @@
+// gRPC API of the ASA server manager. It mirrors the HTTP API: the same
+// process manager, backup manager, RCON permissions and event stream back
+// both. Callers authenticate with the "x-api-key" metadata entry, the
+// counterpart of the X-API-Key header. The server listens on grpc_port of
+// server_config.json.
+//
+// Regenerate the Go code in proto/managerpb after changing this file, from
+// the module root:
+//
+//   protoc --go_out=. --go_opt=module=asa_servermanager_api \
+//     --go-grpc_out=. --go-grpc_opt=module=asa_servermanager_api \
+//     proto/manager.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: proto/manager.proto
+
+package managerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type MapRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Map           string                 `protobuf:"bytes,1,opt,name=map,proto3" json:"map,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MapRequest) Reset() {
+	*x = MapRequest{}
+	mi := &file_proto_manager_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MapRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MapRequest) ProtoMessage() {}
+
+func (x *MapRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MapRequest.ProtoReflect.Descriptor instead.
+func (*MapRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MapRequest) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+type Transition struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Desired       string                 `protobuf:"bytes,2,opt,name=desired,proto3" json:"desired,omitempty"`
+	Actual        string                 `protobuf:"bytes,3,opt,name=actual,proto3" json:"actual,omitempty"`
+	Reason        string                 `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Transition) Reset() {
+	*x = Transition{}
+	mi := &file_proto_manager_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Transition) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Transition) ProtoMessage() {}
+
+func (x *Transition) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Transition.ProtoReflect.Descriptor instead.
+func (*Transition) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Transition) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Transition) GetDesired() string {
+	if x != nil {
+		return x.Desired
+	}
+	return ""
+}
+
+func (x *Transition) GetActual() string {
+	if x != nil {
+		return x.Actual
+	}
+	return ""
+}
+
+func (x *Transition) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type MapState struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Map   string                 `protobuf:"bytes,1,opt,name=map,proto3" json:"map,omitempty"`
+	// enabled or disabled
+	Desired string `protobuf:"bytes,2,opt,name=desired,proto3" json:"desired,omitempty"`
+	// running, stopped or crashed
+	Actual      string                 `protobuf:"bytes,3,opt,name=actual,proto3" json:"actual,omitempty"`
+	Pid         int32                  `protobuf:"varint,4,opt,name=pid,proto3" json:"pid,omitempty"`
+	Since       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=since,proto3" json:"since,omitempty"`
+	Crashes     int32                  `protobuf:"varint,6,opt,name=crashes,proto3" json:"crashes,omitempty"`
+	Transitions []*Transition          `protobuf:"bytes,7,rep,name=transitions,proto3" json:"transitions,omitempty"`
+	// starting or ready while the server runs
+	Readiness     string                 `protobuf:"bytes,8,opt,name=readiness,proto3" json:"readiness,omitempty"`
+	ReadySince    *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=ready_since,json=readySince,proto3" json:"ready_since,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MapState) Reset() {
+	*x = MapState{}
+	mi := &file_proto_manager_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MapState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MapState) ProtoMessage() {}
+
+func (x *MapState) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MapState.ProtoReflect.Descriptor instead.
+func (*MapState) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *MapState) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+func (x *MapState) GetDesired() string {
+	if x != nil {
+		return x.Desired
+	}
+	return ""
+}
+
+func (x *MapState) GetActual() string {
+	if x != nil {
+		return x.Actual
+	}
+	return ""
+}
+
+func (x *MapState) GetPid() int32 {
+	if x != nil {
+		return x.Pid
+	}
+	return 0
+}
+
+func (x *MapState) GetSince() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Since
+	}
+	return nil
+}
+
+func (x *MapState) GetCrashes() int32 {
+	if x != nil {
+		return x.Crashes
+	}
+	return 0
+}
+
+func (x *MapState) GetTransitions() []*Transition {
+	if x != nil {
+		return x.Transitions
+	}
+	return nil
+}
+
+func (x *MapState) GetReadiness() string {
+	if x != nil {
+		return x.Readiness
+	}
+	return ""
+}
+
+func (x *MapState) GetReadySince() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReadySince
+	}
+	return nil
+}
+
+type ListProcessesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only return this map, all maps if empty
+	Map           string `protobuf:"bytes,1,opt,name=map,proto3" json:"map,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListProcessesRequest) Reset() {
+	*x = ListProcessesRequest{}
+	mi := &file_proto_manager_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListProcessesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListProcessesRequest) ProtoMessage() {}
+
+func (x *ListProcessesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListProcessesRequest.ProtoReflect.Descriptor instead.
+func (*ListProcessesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListProcessesRequest) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+type ListProcessesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Processes     []*MapState            `protobuf:"bytes,1,rep,name=processes,proto3" json:"processes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListProcessesResponse) Reset() {
+	*x = ListProcessesResponse{}
+	mi := &file_proto_manager_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListProcessesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListProcessesResponse) ProtoMessage() {}
+
+func (x *ListProcessesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListProcessesResponse.ProtoReflect.Descriptor instead.
+func (*ListProcessesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListProcessesResponse) GetProcesses() []*MapState {
+	if x != nil {
+		return x.Processes
+	}
+	return nil
+}
+
+type ProcessResponse struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Status string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Map    string                 `protobuf:"bytes,2,opt,name=map,proto3" json:"map,omitempty"`
+	Logs   string                 `protobuf:"bytes,3,opt,name=logs,proto3" json:"logs,omitempty"`
+	// Set while the server config makes every stop a dry run, nothing was
+	// changed. plan_json is the JSON encoded plan, as in the plan member of
+	// /stop.
+	DryRun        bool   `protobuf:"varint,4,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	PlanJson      string `protobuf:"bytes,5,opt,name=plan_json,json=planJson,proto3" json:"plan_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProcessResponse) Reset() {
+	*x = ProcessResponse{}
+	mi := &file_proto_manager_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessResponse) ProtoMessage() {}
+
+func (x *ProcessResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessResponse.ProtoReflect.Descriptor instead.
+func (*ProcessResponse) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ProcessResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ProcessResponse) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+func (x *ProcessResponse) GetLogs() string {
+	if x != nil {
+		return x.Logs
+	}
+	return ""
+}
+
+func (x *ProcessResponse) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+func (x *ProcessResponse) GetPlanJson() string {
+	if x != nil {
+		return x.PlanJson
+	}
+	return ""
+}
+
+type QueueBackupRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Map   string                 `protobuf:"bytes,1,opt,name=map,proto3" json:"map,omitempty"`
+	// Take a full instead of an incremental backup
+	Full          bool `protobuf:"varint,2,opt,name=full,proto3" json:"full,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueueBackupRequest) Reset() {
+	*x = QueueBackupRequest{}
+	mi := &file_proto_manager_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueueBackupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueueBackupRequest) ProtoMessage() {}
+
+func (x *QueueBackupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueueBackupRequest.ProtoReflect.Descriptor instead.
+func (*QueueBackupRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *QueueBackupRequest) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+func (x *QueueBackupRequest) GetFull() bool {
+	if x != nil {
+		return x.Full
+	}
+	return false
+}
+
+type BackupJob struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Map   string                 `protobuf:"bytes,2,opt,name=map,proto3" json:"map,omitempty"`
+	// full or incremental
+	Type string `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	// queued, running, done, failed or cancelled
+	Status   string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	Archive  string                 `protobuf:"bytes,5,opt,name=archive,proto3" json:"archive,omitempty"`
+	Error    string                 `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	Queued   *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=queued,proto3" json:"queued,omitempty"`
+	Started  *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=started,proto3" json:"started,omitempty"`
+	Finished *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=finished,proto3" json:"finished,omitempty"`
+	// Why the backup was taken, e.g. before an update
+	Reason        string `protobuf:"bytes,10,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BackupJob) Reset() {
+	*x = BackupJob{}
+	mi := &file_proto_manager_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackupJob) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackupJob) ProtoMessage() {}
+
+func (x *BackupJob) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackupJob.ProtoReflect.Descriptor instead.
+func (*BackupJob) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *BackupJob) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *BackupJob) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+func (x *BackupJob) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *BackupJob) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *BackupJob) GetArchive() string {
+	if x != nil {
+		return x.Archive
+	}
+	return ""
+}
+
+func (x *BackupJob) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *BackupJob) GetQueued() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Queued
+	}
+	return nil
+}
+
+func (x *BackupJob) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *BackupJob) GetFinished() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Finished
+	}
+	return nil
+}
+
+func (x *BackupJob) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type ListBackupJobsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only list jobs of this map, all maps if empty
+	Map           string `protobuf:"bytes,1,opt,name=map,proto3" json:"map,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBackupJobsRequest) Reset() {
+	*x = ListBackupJobsRequest{}
+	mi := &file_proto_manager_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBackupJobsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBackupJobsRequest) ProtoMessage() {}
+
+func (x *ListBackupJobsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBackupJobsRequest.ProtoReflect.Descriptor instead.
+func (*ListBackupJobsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListBackupJobsRequest) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+type ListBackupJobsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Jobs          []*BackupJob           `protobuf:"bytes,1,rep,name=jobs,proto3" json:"jobs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBackupJobsResponse) Reset() {
+	*x = ListBackupJobsResponse{}
+	mi := &file_proto_manager_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBackupJobsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBackupJobsResponse) ProtoMessage() {}
+
+func (x *ListBackupJobsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBackupJobsResponse.ProtoReflect.Descriptor instead.
+func (*ListBackupJobsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListBackupJobsResponse) GetJobs() []*BackupJob {
+	if x != nil {
+		return x.Jobs
+	}
+	return nil
+}
+
+type Archive struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Size          int64                  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	Created       *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created,proto3" json:"created,omitempty"`
+	Tags          []string               `protobuf:"bytes,5,rep,name=tags,proto3" json:"tags,omitempty"`
+	Note          string                 `protobuf:"bytes,6,opt,name=note,proto3" json:"note,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Archive) Reset() {
+	*x = Archive{}
+	mi := &file_proto_manager_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Archive) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Archive) ProtoMessage() {}
+
+func (x *Archive) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Archive.ProtoReflect.Descriptor instead.
+func (*Archive) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Archive) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Archive) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Archive) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Archive) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Archive) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Archive) GetNote() string {
+	if x != nil {
+		return x.Note
+	}
+	return ""
+}
+
+type ListBackupsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Archives      []*Archive             `protobuf:"bytes,1,rep,name=archives,proto3" json:"archives,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBackupsResponse) Reset() {
+	*x = ListBackupsResponse{}
+	mi := &file_proto_manager_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBackupsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBackupsResponse) ProtoMessage() {}
+
+func (x *ListBackupsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBackupsResponse.ProtoReflect.Descriptor instead.
+func (*ListBackupsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListBackupsResponse) GetArchives() []*Archive {
+	if x != nil {
+		return x.Archives
+	}
+	return nil
+}
+
+type RestoreBackupRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Map     string                 `protobuf:"bytes,1,opt,name=map,proto3" json:"map,omitempty"`
+	Archive string                 `protobuf:"bytes,2,opt,name=archive,proto3" json:"archive,omitempty"`
+	// Restore only this file from the archive
+	File          string `protobuf:"bytes,3,opt,name=file,proto3" json:"file,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestoreBackupRequest) Reset() {
+	*x = RestoreBackupRequest{}
+	mi := &file_proto_manager_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestoreBackupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestoreBackupRequest) ProtoMessage() {}
+
+func (x *RestoreBackupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestoreBackupRequest.ProtoReflect.Descriptor instead.
+func (*RestoreBackupRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *RestoreBackupRequest) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+func (x *RestoreBackupRequest) GetArchive() string {
+	if x != nil {
+		return x.Archive
+	}
+	return ""
+}
+
+func (x *RestoreBackupRequest) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+type RestoreBackupResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Files []string               `protobuf:"bytes,1,rep,name=files,proto3" json:"files,omitempty"`
+	// What may not match the map's current server, see /restore
+	Warnings []string `protobuf:"bytes,2,rep,name=warnings,proto3" json:"warnings,omitempty"`
+	// Set while the server config makes every restore a dry run, nothing was
+	// restored. preview_json is the JSON encoded preview of /restore/preview.
+	DryRun        bool   `protobuf:"varint,3,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	PreviewJson   string `protobuf:"bytes,4,opt,name=preview_json,json=previewJson,proto3" json:"preview_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestoreBackupResponse) Reset() {
+	*x = RestoreBackupResponse{}
+	mi := &file_proto_manager_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestoreBackupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestoreBackupResponse) ProtoMessage() {}
+
+func (x *RestoreBackupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestoreBackupResponse.ProtoReflect.Descriptor instead.
+func (*RestoreBackupResponse) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *RestoreBackupResponse) GetFiles() []string {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+func (x *RestoreBackupResponse) GetWarnings() []string {
+	if x != nil {
+		return x.Warnings
+	}
+	return nil
+}
+
+func (x *RestoreBackupResponse) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+func (x *RestoreBackupResponse) GetPreviewJson() string {
+	if x != nil {
+		return x.PreviewJson
+	}
+	return ""
+}
+
+type RconRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Map           string                 `protobuf:"bytes,1,opt,name=map,proto3" json:"map,omitempty"`
+	Command       string                 `protobuf:"bytes,2,opt,name=command,proto3" json:"command,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RconRequest) Reset() {
+	*x = RconRequest{}
+	mi := &file_proto_manager_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RconRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RconRequest) ProtoMessage() {}
+
+func (x *RconRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RconRequest.ProtoReflect.Descriptor instead.
+func (*RconRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *RconRequest) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+func (x *RconRequest) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+type RconResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Response      string                 `protobuf:"bytes,1,opt,name=response,proto3" json:"response,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RconResponse) Reset() {
+	*x = RconResponse{}
+	mi := &file_proto_manager_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RconResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RconResponse) ProtoMessage() {}
+
+func (x *RconResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RconResponse.ProtoReflect.Descriptor instead.
+func (*RconResponse) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *RconResponse) GetResponse() string {
+	if x != nil {
+		return x.Response
+	}
+	return ""
+}
+
+type GetStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatusRequest) Reset() {
+	*x = GetStatusRequest{}
+	mi := &file_proto_manager_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatusRequest) ProtoMessage() {}
+
+func (x *GetStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetStatusRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{16}
+}
+
+type StatusResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UpdatePending bool                   `protobuf:"varint,1,opt,name=update_pending,json=updatePending,proto3" json:"update_pending,omitempty"`
+	LatestBuild   string                 `protobuf:"bytes,2,opt,name=latest_build,json=latestBuild,proto3" json:"latest_build,omitempty"`
+	Updating      bool                   `protobuf:"varint,3,opt,name=updating,proto3" json:"updating,omitempty"`
+	NextWindow    *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=next_window,json=nextWindow,proto3" json:"next_window,omitempty"`
+	Processes     []*MapState            `protobuf:"bytes,5,rep,name=processes,proto3" json:"processes,omitempty"`
+	// Whether every destructive operation is a dry run
+	DryRun        bool `protobuf:"varint,6,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusResponse) Reset() {
+	*x = StatusResponse{}
+	mi := &file_proto_manager_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusResponse) ProtoMessage() {}
+
+func (x *StatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *StatusResponse) GetUpdatePending() bool {
+	if x != nil {
+		return x.UpdatePending
+	}
+	return false
+}
+
+func (x *StatusResponse) GetLatestBuild() string {
+	if x != nil {
+		return x.LatestBuild
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetUpdating() bool {
+	if x != nil {
+		return x.Updating
+	}
+	return false
+}
+
+func (x *StatusResponse) GetNextWindow() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextWindow
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetProcesses() []*MapState {
+	if x != nil {
+		return x.Processes
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+type StreamLogsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Map           string                 `protobuf:"bytes,1,opt,name=map,proto3" json:"map,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamLogsRequest) Reset() {
+	*x = StreamLogsRequest{}
+	mi := &file_proto_manager_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamLogsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamLogsRequest) ProtoMessage() {}
+
+func (x *StreamLogsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamLogsRequest.ProtoReflect.Descriptor instead.
+func (*StreamLogsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *StreamLogsRequest) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+type LogLine struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	Line          string                 `protobuf:"bytes,2,opt,name=line,proto3" json:"line,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogLine) Reset() {
+	*x = LogLine{}
+	mi := &file_proto_manager_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogLine) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogLine) ProtoMessage() {}
+
+func (x *LogLine) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogLine.ProtoReflect.Descriptor instead.
+func (*LogLine) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *LogLine) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *LogLine) GetLine() string {
+	if x != nil {
+		return x.Line
+	}
+	return ""
+}
+
+type StreamEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Event types, a trailing * matches a prefix, all events if empty
+	Events []string `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	// Only events of this map
+	Map           string `protobuf:"bytes,2,opt,name=map,proto3" json:"map,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEventsRequest) Reset() {
+	*x = StreamEventsRequest{}
+	mi := &file_proto_manager_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEventsRequest) ProtoMessage() {}
+
+func (x *StreamEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEventsRequest.ProtoReflect.Descriptor instead.
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *StreamEventsRequest) GetEvents() []string {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *StreamEventsRequest) GetMap() string {
+	if x != nil {
+		return x.Map
+	}
+	return ""
+}
+
+type Event struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Event   string                 `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	Time    *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	Message string                 `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	// JSON encoded event data, as in the data member of /events
+	DataJson      string `protobuf:"bytes,5,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_proto_manager_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_manager_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_proto_manager_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *Event) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Event) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Event) GetDataJson() string {
+	if x != nil {
+		return x.DataJson
+	}
+	return ""
+}
+
+var File_proto_manager_proto protoreflect.FileDescriptor
+
+const file_proto_manager_proto_rawDesc = "" +
+	"\n" +
+	"\x13proto/manager.proto\x12\rasamanager.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x1e\n" +
+	"\n" +
+	"MapRequest\x12\x10\n" +
+	"\x03map\x18\x01 \x01(\tR\x03map\"\x86\x01\n" +
+	"\n" +
+	"Transition\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x18\n" +
+	"\adesired\x18\x02 \x01(\tR\adesired\x12\x16\n" +
+	"\x06actual\x18\x03 \x01(\tR\x06actual\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\"\xc4\x02\n" +
+	"\bMapState\x12\x10\n" +
+	"\x03map\x18\x01 \x01(\tR\x03map\x12\x18\n" +
+	"\adesired\x18\x02 \x01(\tR\adesired\x12\x16\n" +
+	"\x06actual\x18\x03 \x01(\tR\x06actual\x12\x10\n" +
+	"\x03pid\x18\x04 \x01(\x05R\x03pid\x120\n" +
+	"\x05since\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x05since\x12\x18\n" +
+	"\acrashes\x18\x06 \x01(\x05R\acrashes\x12;\n" +
+	"\vtransitions\x18\a \x03(\v2\x19.asamanager.v1.TransitionR\vtransitions\x12\x1c\n" +
+	"\treadiness\x18\b \x01(\tR\treadiness\x12;\n" +
+	"\vready_since\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"readySince\"(\n" +
+	"\x14ListProcessesRequest\x12\x10\n" +
+	"\x03map\x18\x01 \x01(\tR\x03map\"N\n" +
+	"\x15ListProcessesResponse\x125\n" +
+	"\tprocesses\x18\x01 \x03(\v2\x17.asamanager.v1.MapStateR\tprocesses\"\x85\x01\n" +
+	"\x0fProcessResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x10\n" +
+	"\x03map\x18\x02 \x01(\tR\x03map\x12\x12\n" +
+	"\x04logs\x18\x03 \x01(\tR\x04logs\x12\x17\n" +
+	"\adry_run\x18\x04 \x01(\bR\x06dryRun\x12\x1b\n" +
+	"\tplan_json\x18\x05 \x01(\tR\bplanJson\":\n" +
+	"\x12QueueBackupRequest\x12\x10\n" +
+	"\x03map\x18\x01 \x01(\tR\x03map\x12\x12\n" +
+	"\x04full\x18\x02 \x01(\bR\x04full\"\xc3\x02\n" +
+	"\tBackupJob\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03map\x18\x02 \x01(\tR\x03map\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x18\n" +
+	"\aarchive\x18\x05 \x01(\tR\aarchive\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\x122\n" +
+	"\x06queued\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\x06queued\x124\n" +
+	"\astarted\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\astarted\x126\n" +
+	"\bfinished\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\bfinished\x12\x16\n" +
+	"\x06reason\x18\n" +
+	" \x01(\tR\x06reason\")\n" +
+	"\x15ListBackupJobsRequest\x12\x10\n" +
+	"\x03map\x18\x01 \x01(\tR\x03map\"F\n" +
+	"\x16ListBackupJobsResponse\x12,\n" +
+	"\x04jobs\x18\x01 \x03(\v2\x18.asamanager.v1.BackupJobR\x04jobs\"\xa3\x01\n" +
+	"\aArchive\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size\x124\n" +
+	"\acreated\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x12\x12\n" +
+	"\x04tags\x18\x05 \x03(\tR\x04tags\x12\x12\n" +
+	"\x04note\x18\x06 \x01(\tR\x04note\"I\n" +
+	"\x13ListBackupsResponse\x122\n" +
+	"\barchives\x18\x01 \x03(\v2\x16.asamanager.v1.ArchiveR\barchives\"V\n" +
+	"\x14RestoreBackupRequest\x12\x10\n" +
+	"\x03map\x18\x01 \x01(\tR\x03map\x12\x18\n" +
+	"\aarchive\x18\x02 \x01(\tR\aarchive\x12\x12\n" +
+	"\x04file\x18\x03 \x01(\tR\x04file\"\x85\x01\n" +
+	"\x15RestoreBackupResponse\x12\x14\n" +
+	"\x05files\x18\x01 \x03(\tR\x05files\x12\x1a\n" +
+	"\bwarnings\x18\x02 \x03(\tR\bwarnings\x12\x17\n" +
+	"\adry_run\x18\x03 \x01(\bR\x06dryRun\x12!\n" +
+	"\fpreview_json\x18\x04 \x01(\tR\vpreviewJson\"9\n" +
+	"\vRconRequest\x12\x10\n" +
+	"\x03map\x18\x01 \x01(\tR\x03map\x12\x18\n" +
+	"\acommand\x18\x02 \x01(\tR\acommand\"*\n" +
+	"\fRconResponse\x12\x1a\n" +
+	"\bresponse\x18\x01 \x01(\tR\bresponse\"\x12\n" +
+	"\x10GetStatusRequest\"\x83\x02\n" +
+	"\x0eStatusResponse\x12%\n" +
+	"\x0eupdate_pending\x18\x01 \x01(\bR\rupdatePending\x12!\n" +
+	"\flatest_build\x18\x02 \x01(\tR\vlatestBuild\x12\x1a\n" +
+	"\bupdating\x18\x03 \x01(\bR\bupdating\x12;\n" +
+	"\vnext_window\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"nextWindow\x125\n" +
+	"\tprocesses\x18\x05 \x03(\v2\x17.asamanager.v1.MapStateR\tprocesses\x12\x17\n" +
+	"\adry_run\x18\x06 \x01(\bR\x06dryRun\"%\n" +
+	"\x11StreamLogsRequest\x12\x10\n" +
+	"\x03map\x18\x01 \x01(\tR\x03map\"M\n" +
+	"\aLogLine\x12.\n" +
+	"\x04time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x12\n" +
+	"\x04line\x18\x02 \x01(\tR\x04line\"?\n" +
+	"\x13StreamEventsRequest\x12\x16\n" +
+	"\x06events\x18\x01 \x03(\tR\x06events\x12\x10\n" +
+	"\x03map\x18\x02 \x01(\tR\x03map\"\x94\x01\n" +
+	"\x05Event\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05event\x18\x02 \x01(\tR\x05event\x12.\n" +
+	"\x04time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\x12\x1b\n" +
+	"\tdata_json\x18\x05 \x01(\tR\bdataJson2\xfa\x06\n" +
+	"\aManager\x12Z\n" +
+	"\rListProcesses\x12#.asamanager.v1.ListProcessesRequest\x1a$.asamanager.v1.ListProcessesResponse\x12I\n" +
+	"\fStartProcess\x12\x19.asamanager.v1.MapRequest\x1a\x1e.asamanager.v1.ProcessResponse\x12H\n" +
+	"\vStopProcess\x12\x19.asamanager.v1.MapRequest\x1a\x1e.asamanager.v1.ProcessResponse\x12J\n" +
+	"\vQueueBackup\x12!.asamanager.v1.QueueBackupRequest\x1a\x18.asamanager.v1.BackupJob\x12]\n" +
+	"\x0eListBackupJobs\x12$.asamanager.v1.ListBackupJobsRequest\x1a%.asamanager.v1.ListBackupJobsResponse\x12L\n" +
+	"\vListBackups\x12\x19.asamanager.v1.MapRequest\x1a\".asamanager.v1.ListBackupsResponse\x12Z\n" +
+	"\rRestoreBackup\x12#.asamanager.v1.RestoreBackupRequest\x1a$.asamanager.v1.RestoreBackupResponse\x12F\n" +
+	"\vExecuteRcon\x12\x1a.asamanager.v1.RconRequest\x1a\x1b.asamanager.v1.RconResponse\x12K\n" +
+	"\tGetStatus\x12\x1f.asamanager.v1.GetStatusRequest\x1a\x1d.asamanager.v1.StatusResponse\x12H\n" +
+	"\n" +
+	"StreamLogs\x12 .asamanager.v1.StreamLogsRequest\x1a\x16.asamanager.v1.LogLine0\x01\x12J\n" +
+	"\fStreamEvents\x12\".asamanager.v1.StreamEventsRequest\x1a\x14.asamanager.v1.Event0\x01B'Z%asa_servermanager_api/proto/managerpbb\x06proto3"
+
+var (
+	file_proto_manager_proto_rawDescOnce sync.Once
+	file_proto_manager_proto_rawDescData []byte
+)
+
+func file_proto_manager_proto_rawDescGZIP() []byte {
+	file_proto_manager_proto_rawDescOnce.Do(func() {
+		file_proto_manager_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_manager_proto_rawDesc), len(file_proto_manager_proto_rawDesc)))
+	})
+	return file_proto_manager_proto_rawDescData
+}
+
+var file_proto_manager_proto_msgTypes = make([]protoimpl.MessageInfo, 22)
+var file_proto_manager_proto_goTypes = []any{
+	(*MapRequest)(nil),             // 0: asamanager.v1.MapRequest
+	(*Transition)(nil),             // 1: asamanager.v1.Transition
+	(*MapState)(nil),               // 2: asamanager.v1.MapState
+	(*ListProcessesRequest)(nil),   // 3: asamanager.v1.ListProcessesRequest
+	(*ListProcessesResponse)(nil),  // 4: asamanager.v1.ListProcessesResponse
+	(*ProcessResponse)(nil),        // 5: asamanager.v1.ProcessResponse
+	(*QueueBackupRequest)(nil),     // 6: asamanager.v1.QueueBackupRequest
+	(*BackupJob)(nil),              // 7: asamanager.v1.BackupJob
+	(*ListBackupJobsRequest)(nil),  // 8: asamanager.v1.ListBackupJobsRequest
+	(*ListBackupJobsResponse)(nil), // 9: asamanager.v1.ListBackupJobsResponse
+	(*Archive)(nil),                // 10: asamanager.v1.Archive
+	(*ListBackupsResponse)(nil),    // 11: asamanager.v1.ListBackupsResponse
+	(*RestoreBackupRequest)(nil),   // 12: asamanager.v1.RestoreBackupRequest
+	(*RestoreBackupResponse)(nil),  // 13: asamanager.v1.RestoreBackupResponse
+	(*RconRequest)(nil),            // 14: asamanager.v1.RconRequest
+	(*RconResponse)(nil),           // 15: asamanager.v1.RconResponse
+	(*GetStatusRequest)(nil),       // 16: asamanager.v1.GetStatusRequest
+	(*StatusResponse)(nil),         // 17: asamanager.v1.StatusResponse
+	(*StreamLogsRequest)(nil),      // 18: asamanager.v1.StreamLogsRequest
+	(*LogLine)(nil),                // 19: asamanager.v1.LogLine
+	(*StreamEventsRequest)(nil),    // 20: asamanager.v1.StreamEventsRequest
+	(*Event)(nil),                  // 21: asamanager.v1.Event
+	(*timestamppb.Timestamp)(nil),  // 22: google.protobuf.Timestamp
+}
+var file_proto_manager_proto_depIdxs = []int32{
+	22, // 0: asamanager.v1.Transition.time:type_name -> google.protobuf.Timestamp
+	22, // 1: asamanager.v1.MapState.since:type_name -> google.protobuf.Timestamp
+	1,  // 2: asamanager.v1.MapState.transitions:type_name -> asamanager.v1.Transition
+	22, // 3: asamanager.v1.MapState.ready_since:type_name -> google.protobuf.Timestamp
+	2,  // 4: asamanager.v1.ListProcessesResponse.processes:type_name -> asamanager.v1.MapState
+	22, // 5: asamanager.v1.BackupJob.queued:type_name -> google.protobuf.Timestamp
+	22, // 6: asamanager.v1.BackupJob.started:type_name -> google.protobuf.Timestamp
+	22, // 7: asamanager.v1.BackupJob.finished:type_name -> google.protobuf.Timestamp
+	7,  // 8: asamanager.v1.ListBackupJobsResponse.jobs:type_name -> asamanager.v1.BackupJob
+	22, // 9: asamanager.v1.Archive.created:type_name -> google.protobuf.Timestamp
+	10, // 10: asamanager.v1.ListBackupsResponse.archives:type_name -> asamanager.v1.Archive
+	22, // 11: asamanager.v1.StatusResponse.next_window:type_name -> google.protobuf.Timestamp
+	2,  // 12: asamanager.v1.StatusResponse.processes:type_name -> asamanager.v1.MapState
+	22, // 13: asamanager.v1.LogLine.time:type_name -> google.protobuf.Timestamp
+	22, // 14: asamanager.v1.Event.time:type_name -> google.protobuf.Timestamp
+	3,  // 15: asamanager.v1.Manager.ListProcesses:input_type -> asamanager.v1.ListProcessesRequest
+	0,  // 16: asamanager.v1.Manager.StartProcess:input_type -> asamanager.v1.MapRequest
+	0,  // 17: asamanager.v1.Manager.StopProcess:input_type -> asamanager.v1.MapRequest
+	6,  // 18: asamanager.v1.Manager.QueueBackup:input_type -> asamanager.v1.QueueBackupRequest
+	8,  // 19: asamanager.v1.Manager.ListBackupJobs:input_type -> asamanager.v1.ListBackupJobsRequest
+	0,  // 20: asamanager.v1.Manager.ListBackups:input_type -> asamanager.v1.MapRequest
+	12, // 21: asamanager.v1.Manager.RestoreBackup:input_type -> asamanager.v1.RestoreBackupRequest
+	14, // 22: asamanager.v1.Manager.ExecuteRcon:input_type -> asamanager.v1.RconRequest
+	16, // 23: asamanager.v1.Manager.GetStatus:input_type -> asamanager.v1.GetStatusRequest
+	18, // 24: asamanager.v1.Manager.StreamLogs:input_type -> asamanager.v1.StreamLogsRequest
+	20, // 25: asamanager.v1.Manager.StreamEvents:input_type -> asamanager.v1.StreamEventsRequest
+	4,  // 26: asamanager.v1.Manager.ListProcesses:output_type -> asamanager.v1.ListProcessesResponse
+	5,  // 27: asamanager.v1.Manager.StartProcess:output_type -> asamanager.v1.ProcessResponse
+	5,  // 28: asamanager.v1.Manager.StopProcess:output_type -> asamanager.v1.ProcessResponse
+	7,  // 29: asamanager.v1.Manager.QueueBackup:output_type -> asamanager.v1.BackupJob
+	9,  // 30: asamanager.v1.Manager.ListBackupJobs:output_type -> asamanager.v1.ListBackupJobsResponse
+	11, // 31: asamanager.v1.Manager.ListBackups:output_type -> asamanager.v1.ListBackupsResponse
+	13, // 32: asamanager.v1.Manager.RestoreBackup:output_type -> asamanager.v1.RestoreBackupResponse
+	15, // 33: asamanager.v1.Manager.ExecuteRcon:output_type -> asamanager.v1.RconResponse
+	17, // 34: asamanager.v1.Manager.GetStatus:output_type -> asamanager.v1.StatusResponse
+	19, // 35: asamanager.v1.Manager.StreamLogs:output_type -> asamanager.v1.LogLine
+	21, // 36: asamanager.v1.Manager.StreamEvents:output_type -> asamanager.v1.Event
+	26, // [26:37] is the sub-list for method output_type
+	15, // [15:26] is the sub-list for method input_type
+	15, // [15:15] is the sub-list for extension type_name
+	15, // [15:15] is the sub-list for extension extendee
+	0,  // [0:15] is the sub-list for field type_name
+}
+
+func init() { file_proto_manager_proto_init() }
+func file_proto_manager_proto_init() {
+	if File_proto_manager_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_manager_proto_rawDesc), len(file_proto_manager_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   22,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_manager_proto_goTypes,
+		DependencyIndexes: file_proto_manager_proto_depIdxs,
+		MessageInfos:      file_proto_manager_proto_msgTypes,
+	}.Build()
+	File_proto_manager_proto = out.File
+	file_proto_manager_proto_goTypes = nil
+	file_proto_manager_proto_depIdxs = nil
+}
@@ -0,0 +1,459 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/firewall"
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+)
+
+const (
+	// ASA's ports when neither the start script nor the INI sets them
+	defaultGamePort = 7777
+	defaultRCONPort = 27020
+
+	adoptSecretMask = "********"
+)
+
+var (
+	ErrInstallNotFound = errors.New("no ASA server installation found")
+
+	// startScripts are the files a hand-managed server is started with
+	startScripts = []string{".bat", ".cmd", ".ps1", ".sh"}
+)
+
+// AdoptRequest names an existing, hand-managed server installation to bring
+// under management
+type AdoptRequest struct {
+	// Dir is the installation, or a directory with a single installation
+	// among its subdirectories
+	Dir  string `json:"dir"`
+	Name string `json:"name"`
+	// Map is the map the server runs, detected from its start script or
+	// saves when left out
+	Map string `json:"map,omitempty"`
+	// BackupDir is where the archives go, <backup_root>/<name> by default.
+	// Without either no backups are set up.
+	BackupDir string `json:"backup_dir,omitempty"`
+}
+
+// Adoption is what was found in an installation and the config entries
+// made from it. Passwords are masked.
+type Adoption struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+	// Script is the start script the command line was read from
+	Script   string                       `json:"script,omitempty"`
+	Process  processmanager.ProcessConfig `json:"process"`
+	RCON     *rcon.RconInfo               `json:"rcon,omitempty"`
+	Backup   *backup.MapConfig            `json:"backup,omitempty"`
+	Warnings []string                     `json:"warnings"`
+}
+
+// commandLine is what a start script passes to the server
+type commandLine struct {
+	script  string
+	mapName string
+	// options are the map URL options by lower case name, names their
+	// spelling in the script
+	options map[string]string
+	names   map[string]string
+	flags   []string
+}
+
+// PlanAdoption scans an installation and returns the process, RCON and
+// backup config entries adopting it would create. Nothing is written.
+func (p *Provisioner) PlanAdoption(req AdoptRequest) (Adoption, error) {
+	adoption, err := p.planAdoption(req)
+	return adoption.masked(), err
+}
+
+func (p *Provisioner) planAdoption(req AdoptRequest) (Adoption, error) {
+	switch {
+	case !namePattern.MatchString(req.Name):
+		return Adoption{}, fmt.Errorf("%w: name must be 1-64 letters, digits, '-' or '_'", ErrInvalidRequest)
+	case req.Map != "" && !mapPattern.MatchString(req.Map):
+		return Adoption{}, fmt.Errorf("%w: map must be a map name such as TheIsland_WP", ErrInvalidRequest)
+	case !filepath.IsAbs(req.Dir):
+		return Adoption{}, fmt.Errorf("%w: dir must be an absolute path", ErrInvalidRequest)
+	}
+	if p.pm.HasMap(req.Name) {
+		return Adoption{}, fmt.Errorf("%w: %s", processmanager.ErrMapExists, req.Name)
+	}
+	dir, err := p.findInstall(filepath.Clean(req.Dir))
+	if err != nil {
+		return Adoption{}, err
+	}
+	executable := filepath.ToSlash(filepath.Join(dir, p.config.ServerExe))
+	for _, ms := range p.pm.States() {
+		if config, ok := p.pm.Config(ms.Map); ok && filepath.Clean(config.Executable) == filepath.Clean(executable) {
+			return Adoption{}, fmt.Errorf("%w: %s is already managed as map %s", processmanager.ErrMapExists, dir, ms.Map)
+		}
+	}
+
+	adoption := Adoption{Name: req.Name, Dir: dir, Warnings: []string{}}
+	cmd := readStartScript(dir)
+	if cmd.script != "" {
+		adoption.Script = cmd.script
+	} else {
+		adoption.Warnings = append(adoption.Warnings, "no start script found, the settings are read from the INI files only")
+	}
+	launch, adminPassword, rconEnabled := p.adoptLaunch(req, dir, cmd, &adoption)
+	if launch.Map == "" {
+		return Adoption{}, fmt.Errorf("%w: the map could not be detected in %s, pass map", ErrInvalidRequest, dir)
+	}
+
+	config := processmanager.ProcessConfig{Map: req.Name, Executable: executable, RestartInterval: 5, Launch: launch}
+	if err := p.pm.Validate(config); err != nil {
+		return Adoption{}, err
+	}
+	adoption.Process = config
+	adoption.Warnings = append(adoption.Warnings, launch.Warnings(nil)...)
+
+	// A server started by hand keeps its ports until it is stopped, the
+	// manager does not know its process
+	for name, port := range config.Ports() {
+		if !processmanager.PortFree(port, processmanager.PortProtocol(name)) {
+			adoption.Warnings = append(adoption.Warnings, fmt.Sprintf("%s %d is in use on this host, stop the server started by hand before starting the map", name, port))
+		}
+	}
+
+	switch {
+	case !rconEnabled:
+		adoption.Warnings = append(adoption.Warnings, "RCON is not enabled, set RCONEnabled=True in GameUserSettings.ini and add the map's RCON config to use saves, broadcasts and player lists")
+	case adminPassword == "":
+		adoption.Warnings = append(adoption.Warnings, "no ServerAdminPassword found, the RCON config is left out")
+	default:
+		adoption.RCON = &rcon.RconInfo{Map: req.Name, IP: "127.0.0.1", Port: strconv.Itoa(launch.RCONPort), Pass: adminPassword}
+	}
+
+	savedDir := config.SaveDir()
+	for key := range launch.Options {
+		if strings.EqualFold(key, "AltSaveDirectoryName") {
+			adoption.Warnings = append(adoption.Warnings, "the server saves to AltSaveDirectoryName, check the save folder of the backup config")
+		}
+	}
+	if _, err := os.Stat(savedDir); err != nil {
+		adoption.Warnings = append(adoption.Warnings, fmt.Sprintf("the save folder %s does not exist yet", savedDir))
+	}
+	zipDir := req.BackupDir
+	if zipDir == "" && p.config.BackupRoot != "" {
+		zipDir = filepath.Join(p.config.BackupRoot, req.Name)
+	}
+	if zipDir != "" {
+		backupConfig := defaultBackupConfig(zipDir, savedDir, launch.Map)
+		adoption.Backup = &backupConfig
+	} else {
+		adoption.Warnings = append(adoption.Warnings, "no backup_dir given and no backup_root configured, backups are not set up")
+	}
+	return adoption, nil
+}
+
+// Adopt registers an existing installation as a map, see PlanAdoption. The
+// installation, its INI files and saves are left as they are and the server
+// is not started. Failures after the process config was written are
+// returned as warnings.
+func (p *Provisioner) Adopt(req AdoptRequest) (Adoption, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active[req.Name] {
+		return Adoption{}, fmt.Errorf("%w: %s is being provisioned", processmanager.ErrMapExists, req.Name)
+	}
+
+	adoption, err := p.planAdoption(req)
+	if err != nil {
+		return Adoption{}, err
+	}
+	for name, port := range adoption.Process.Ports() {
+		for owner, reserved := range p.reserved {
+			for _, r := range reserved {
+				if r == port {
+					return Adoption{}, fmt.Errorf("%w: %s %d is reserved for %s, which is being provisioned", processmanager.ErrPortConflict, name, port, owner)
+				}
+			}
+		}
+	}
+	if err := p.pm.RegisterMap(adoption.Process); err != nil {
+		return Adoption{}, fmt.Errorf("failed to register process: %w", err)
+	}
+
+	if adoption.RCON != nil {
+		if err := rcon.SaveRconInfo(*adoption.RCON); err != nil {
+			adoption.Warnings = append(adoption.Warnings, "failed to register rcon: "+err.Error())
+		}
+	}
+	if adoption.Backup != nil {
+		if err := p.bm.RegisterMap(req.Name, *adoption.Backup); err != nil {
+			adoption.Warnings = append(adoption.Warnings, "failed to register backups: "+err.Error())
+		}
+	}
+	if firewall.Enabled() {
+		if _, err := firewall.Sync(adoption.Process, false); err != nil {
+			adoption.Warnings = append(adoption.Warnings, "failed to open the firewall: "+err.Error())
+		}
+	}
+	if p.OnRegistered != nil {
+		p.OnRegistered(req.Name)
+	}
+	log.Printf("Adopted the server in %s as map %s", adoption.Dir, req.Name)
+	return adoption.masked(), nil
+}
+
+// Plan returns the steps of an adoption, for a dry run
+func (a Adoption) Plan() dryrun.Plan {
+	plan := dryrun.Plan{Operation: "adopt", Map: a.Name}
+	if a.Script != "" {
+		plan.Stepf("Read the command line of %s", a.Script)
+	}
+	plan.Stepf("Register map %s running %s with %s", a.Name, a.Process.Launch.Map, a.Process.Executable)
+	if a.RCON != nil {
+		plan.Stepf("Add RCON on %s:%s", a.RCON.IP, a.RCON.Port)
+	}
+	if a.Backup != nil {
+		plan.Stepf("Back up %s to %s every %d minutes", a.Backup.ExtractDir, a.Backup.ZipDir, a.Backup.IntervalMinutes)
+	}
+	plan.Stepf("Leave the installation, its INI files and saves as they are and the server stopped")
+	return plan
+}
+
+// masked returns the adoption with its passwords hidden, for responses
+func (a Adoption) masked() Adoption {
+	if a.RCON != nil {
+		info := *a.RCON
+		info.Pass = adoptSecretMask
+		a.RCON = &info
+	}
+	if launch := a.Process.Launch; launch != nil {
+		copied := *launch
+		if copied.ServerPassword != "" {
+			copied.ServerPassword = adoptSecretMask
+		}
+		copied.Options = make(map[string]string, len(launch.Options))
+		for key, value := range launch.Options {
+			if ini.IsSecret(key) {
+				value = adoptSecretMask
+			}
+			copied.Options[key] = value
+		}
+		a.Process.Launch = &copied
+	}
+	return a
+}
+
+// findInstall returns dir if the server is installed in it, else the one
+// subdirectory it is installed in
+func (p *Provisioner) findInstall(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, p.config.ServerExe)); err == nil {
+		return dir, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInstallNotFound, err)
+	}
+	var found []string
+	for _, entry := range entries {
+		sub := filepath.Join(dir, entry.Name())
+		if _, err := os.Stat(filepath.Join(sub, p.config.ServerExe)); entry.IsDir() && err == nil {
+			found = append(found, sub)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%w in %s", ErrInstallNotFound, dir)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("%w: %s holds several installations, pass one of %s", ErrInvalidRequest, dir, strings.Join(found, ", "))
+	}
+}
+
+// adoptLaunch builds the launch config from the start script, falling back
+// to GameUserSettings.ini and the saves. Options and flags it does not
+// know are kept, so the server starts the way it did.
+func (p *Provisioner) adoptLaunch(req AdoptRequest, dir string, cmd commandLine, adoption *Adoption) (launch *processmanager.LaunchConfig, adminPassword string, rconEnabled bool) {
+	launch = &processmanager.LaunchConfig{Map: cmd.mapName}
+	if req.Map != "" {
+		launch.Map = req.Map
+	}
+	if launch.Map == "" {
+		launch.Map = newestSave(filepath.Join(dir, "ShooterGame", "Saved", "SavedArks"))
+	}
+
+	settings := &ini.File{}
+	if data, err := os.ReadFile(filepath.Join(dir, "ShooterGame", "Saved", "Config", "WindowsServer", ini.GameUserSettings)); err == nil {
+		settings = ini.Parse(data)
+	} else {
+		adoption.Warnings = append(adoption.Warnings, ini.GameUserSettings+" not found, ASA's defaults are assumed")
+	}
+	// The command line overrides the INI like it does for ASA
+	setting := func(option string, sections ...string) string {
+		if value, ok := cmd.options[strings.ToLower(option)]; ok {
+			return value
+		}
+		for _, section := range sections {
+			if values := settings.Get(section, option); len(values) > 0 {
+				return values[len(values)-1]
+			}
+		}
+		return ""
+	}
+	port := func(name, option string, fallback int, sections ...string) int {
+		value := setting(option, sections...)
+		if value == "" {
+			if fallback != 0 {
+				adoption.Warnings = append(adoption.Warnings, fmt.Sprintf("%s not found, ASA's default %d is assumed", name, fallback))
+			}
+			return fallback
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			adoption.Warnings = append(adoption.Warnings, fmt.Sprintf("%s %q is not a port number, it is left out", name, value))
+			return 0
+		}
+		return n
+	}
+
+	launch.SessionName = setting("SessionName", "SessionSettings", "ServerSettings")
+	launch.Port = port("game port", "Port", defaultGamePort, "SessionSettings")
+	launch.QueryPort = port("query port", "QueryPort", 0, "SessionSettings")
+	rconEnabled = strings.EqualFold(setting("RCONEnabled", "ServerSettings"), "true")
+	if rconEnabled {
+		launch.RCONPort = port("RCON port", "RCONPort", defaultRCONPort, "ServerSettings")
+	}
+	adminPassword = setting("ServerAdminPassword", "ServerSettings")
+	// Passwords in the INI stay there, only those of the command line move
+	// to the launch config
+	launch.ServerPassword = cmd.options["serverpassword"]
+
+	for key, value := range cmd.options {
+		switch key {
+		case "listen", "sessionname", "port", "queryport", "rconport", "rconenabled", "serverpassword", "maxplayers":
+			continue
+		}
+		if launch.Options == nil {
+			launch.Options = make(map[string]string)
+		}
+		launch.Options[cmd.names[key]] = value
+	}
+	if players := cmd.options["maxplayers"]; players != "" {
+		launch.MaxPlayers, _ = strconv.Atoi(players)
+	}
+	for _, flag := range cmd.flags {
+		name, value, _ := strings.Cut(flag, "=")
+		switch strings.ToLower(name) {
+		case "-mods":
+			for _, field := range strings.Split(value, ",") {
+				if id, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && id > 0 {
+					launch.Mods = append(launch.Mods, id)
+				}
+			}
+		case "-clusterid":
+			launch.ClusterID = value
+		case "-clusterdiroverride":
+			launch.ClusterDir = value
+		case "-winlivemaxplayers":
+			launch.MaxPlayers, _ = strconv.Atoi(value)
+		case "-nobattleye":
+			off := false
+			launch.BattlEye = &off
+		default:
+			launch.Flags = append(launch.Flags, flag)
+		}
+	}
+	return launch, adminPassword, rconEnabled
+}
+
+// readStartScript finds the start script in an installation and parses the
+// server's command line from it
+func readStartScript(dir string) commandLine {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return commandLine{}
+	}
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		known := false
+		for _, script := range startScripts {
+			known = known || ext == script
+		}
+		if entry.IsDir() || !known {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if cmd, ok := parseCommandLine(line); ok {
+				cmd.script = filepath.Join(dir, entry.Name())
+				return cmd
+			}
+		}
+	}
+	return commandLine{}
+}
+
+// parseCommandLine parses the arguments following ArkAscendedServer in a
+// line of a start script: the map URL and the flags
+func parseCommandLine(line string) (commandLine, bool) {
+	args := processmanager.SplitCommandLine(line)
+	start := -1
+	for i, arg := range args {
+		if strings.Contains(strings.ToLower(arg), "arkascendedserver") {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return commandLine{}, false
+	}
+	cmd := commandLine{options: make(map[string]string), names: make(map[string]string)}
+	for _, arg := range args[start:] {
+		switch {
+		case strings.HasPrefix(arg, "-"):
+			cmd.flags = append(cmd.flags, arg)
+		case cmd.mapName == "" && strings.Contains(arg, "?"):
+			parts := strings.Split(arg, "?")
+			cmd.mapName = parts[0]
+			for _, option := range parts[1:] {
+				key, value, _ := strings.Cut(option, "=")
+				if key != "" {
+					cmd.options[strings.ToLower(key)] = value
+					cmd.names[strings.ToLower(key)] = key
+				}
+			}
+		}
+	}
+	return cmd, true
+}
+
+// newestSave returns the map of the most recently written save in a
+// SavedArks directory, "" if it has none
+func newestSave(savedArks string) string {
+	entries, err := os.ReadDir(savedArks)
+	if err != nil {
+		return ""
+	}
+	newest, newestTime := "", time.Time{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !mapPattern.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(savedArks, entry.Name(), entry.Name()+".ark"))
+		if err == nil && info.ModTime().After(newestTime) {
+			newest, newestTime = entry.Name(), info.ModTime()
+		}
+	}
+	return newest
+}
@@ -0,0 +1,144 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/ini"
+	"asa_servermanager_api/processmanager"
+)
+
+var ErrSourceNotFound = errors.New("source map not found")
+
+// CloneRequest describes an instance created from a backup of another map,
+// e.g. a staging or event server with production data
+type CloneRequest struct {
+	Source string `json:"source"`
+	// Archive is the backup to restore, the newest one when left out
+	Archive     string `json:"archive,omitempty"`
+	Name        string `json:"name"`
+	SessionName string `json:"session_name"`
+	// GamePort, QueryPort and RCONPort are picked from the configured
+	// ranges when left out
+	GamePort       int    `json:"game_port,omitempty"`
+	QueryPort      int    `json:"query_port,omitempty"`
+	RCONPort       int    `json:"rcon_port,omitempty"`
+	AdminPassword  string `json:"admin_password"`
+	ServerPassword string `json:"server_password,omitempty"`
+	// The clone joins no cluster unless one is given, so characters can't
+	// be transferred between it and the source's cluster
+	ClusterID  string `json:"cluster_id,omitempty"`
+	ClusterDir string `json:"cluster_dir,omitempty"`
+
+	SkipDownload bool `json:"skip_download,omitempty"`
+	// Start starts the server once it is registered
+	Start bool `json:"start,omitempty"`
+}
+
+// cloneSource is what a clone copies from its source
+type cloneSource struct {
+	source  string
+	archive string
+	config  processmanager.ProcessConfig
+	start   bool
+}
+
+// Clone creates a new instance from a backup of an existing map in the
+// background: the source's INI files with the session name, password and
+// ports rewritten, the restored save and the source's process settings
+func (p *Provisioner) Clone(creq CloneRequest) (Job, error) {
+	source, ok := p.pm.Config(creq.Source)
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrSourceNotFound, creq.Source)
+	}
+	if source.Launch == nil || source.Launch.Map == "" {
+		return Job{}, fmt.Errorf("%w: map %s has no launch config to clone", ErrInvalidRequest, creq.Source)
+	}
+
+	archives, err := p.bm.ListBackups(creq.Source, "")
+	if err != nil {
+		return Job{}, err
+	}
+	archive := ""
+	for _, a := range archives {
+		if creq.Archive == "" || a.Name == creq.Archive {
+			archive = a.Name
+			break
+		}
+	}
+	if archive == "" {
+		if creq.Archive != "" {
+			return Job{}, fmt.Errorf("%w: backup %s of map %s not found", backup.ErrNoRestorePoint, creq.Archive, creq.Source)
+		}
+		return Job{}, fmt.Errorf("%w: map %s has no backups", backup.ErrNoRestorePoint, creq.Source)
+	}
+
+	req := Request{
+		Name:           creq.Name,
+		Map:            source.Launch.Map,
+		SessionName:    creq.SessionName,
+		GamePort:       creq.GamePort,
+		QueryPort:      creq.QueryPort,
+		RCONPort:       creq.RCONPort,
+		AdminPassword:  creq.AdminPassword,
+		ServerPassword: creq.ServerPassword,
+		MaxPlayers:     source.Launch.MaxPlayers,
+		ClusterID:      creq.ClusterID,
+		ClusterDir:     creq.ClusterDir,
+		ExtraArgs:      source.Args,
+		SkipDownload:   creq.SkipDownload,
+		clone:          &cloneSource{source: creq.Source, archive: archive, config: source, start: creq.Start},
+	}
+	if req.SessionName == "" {
+		req.SessionName = creq.Name
+	}
+	if err := req.validate(); err != nil {
+		return Job{}, err
+	}
+	if p.config.ServersRoot == "" {
+		return Job{}, errors.New("servers_root is not configured in the provision config")
+	}
+	if !req.SkipDownload && p.config.SteamCMDPath == "" {
+		return Job{}, errors.New("steamcmd_path is not configured in the provision config")
+	}
+	return p.start(req)
+}
+
+// cloneIni copies the INI files of the source and rewrites the settings
+// that must differ from it. A missing file is rendered from its template.
+func (p *Provisioner) cloneIni(req Request, configDir string) error {
+	sourceDir := req.clone.config.IniDir()
+	for _, name := range []string{ini.GameUserSettings, ini.Game} {
+		dst := filepath.Join(configDir, name)
+		data, err := os.ReadFile(filepath.Join(sourceDir, name))
+		if os.IsNotExist(err) {
+			if err := p.renderTemplate(name, dst, req); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s of %s: %w", name, req.clone.source, err)
+		}
+
+		if name == ini.GameUserSettings {
+			f := ini.Parse(data)
+			f.Set("ServerSettings", "SessionName", req.SessionName)
+			f.Set("ServerSettings", "ServerAdminPassword", req.AdminPassword)
+			f.Set("ServerSettings", "ServerPassword", req.ServerPassword)
+			f.Set("ServerSettings", "RCONEnabled", "True")
+			f.Set("ServerSettings", "RCONPort", strconv.Itoa(req.RCONPort))
+			f.Set("SessionSettings", "SessionName", req.SessionName)
+			f.Set("SessionSettings", "Port", strconv.Itoa(req.GamePort))
+			data = f.Bytes()
+		}
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", dst, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,574 @@
+package provision
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/firewall"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/state"
+	"asa_servermanager_api/steamcmd"
+)
+
+const (
+	defaultServerExe       = "ShooterGame/Binaries/Win64/ArkAscendedServer.exe"
+	defaultSteamCMDMinutes = 120
+
+	// Free game and RCON ports are picked from these ranges by default, the
+	// query port is only assigned with a configured range
+	defaultGamePortStart = 7777
+	defaultGamePortEnd   = 7876
+	defaultRCONPortStart = 27020
+	defaultRCONPortEnd   = 27119
+
+	bucketProvisionJobs = "provision_jobs"
+
+	StepQueued   = "queued"
+	StepLayout   = "creating directories"
+	StepDownload = "downloading server files"
+	StepConfig   = "writing config files"
+	StepRestore  = "restoring backup"
+	StepRegister = "registering map"
+	StepStart    = "starting server"
+	StepDone     = "done"
+	StepFailed   = "failed"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+var (
+	ErrInvalidRequest = errors.New("invalid provisioning request")
+
+	namePattern    = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	mapPattern     = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+	sessionPattern = regexp.MustCompile(`^[^?"\r\n]{1,100}$`)
+)
+
+// ProvisionConfig holds where new server instances are created
+type ProvisionConfig struct {
+	SteamCMDPath    string     `json:"steamcmd_path"`
+	ServersRoot     string     `json:"servers_root"`
+	BackupRoot      string     `json:"backup_root"`
+	TemplateDir     string     `json:"template_dir"`
+	ServerExe       string     `json:"server_executable"`
+	SteamCMDMinutes int        `json:"steamcmd_timeout_minutes"`
+	Ports           PortRanges `json:"ports"`
+}
+
+// PortRange is an inclusive range of ports, a zero Start disables it
+type PortRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// PortRanges are where the ports of requests without them are picked from
+type PortRanges struct {
+	Game  PortRange `json:"game"`
+	Query PortRange `json:"query"`
+	RCON  PortRange `json:"rcon"`
+}
+
+func (r PortRange) validate(name string) error {
+	if r.Start == 0 {
+		return nil
+	}
+	if r.Start < 1 || r.End < r.Start || r.End > 65535 {
+		return fmt.Errorf("ports.%s must be a range of port numbers with start <= end", name)
+	}
+	return nil
+}
+
+// Request describes the server instance to create
+type Request struct {
+	Name        string `json:"name"`
+	Map         string `json:"map"`
+	SessionName string `json:"session_name"`
+	// GamePort, QueryPort and RCONPort are picked from the configured
+	// ranges when left out
+	GamePort       int      `json:"game_port,omitempty"`
+	QueryPort      int      `json:"query_port,omitempty"`
+	RCONPort       int      `json:"rcon_port,omitempty"`
+	AdminPassword  string   `json:"admin_password"`
+	ServerPassword string   `json:"server_password,omitempty"`
+	MaxPlayers     int      `json:"max_players"`
+	ClusterID      string   `json:"cluster_id,omitempty"`
+	ClusterDir     string   `json:"cluster_dir,omitempty"`
+	ExtraArgs      []string `json:"extra_args,omitempty"`
+
+	// SkipDownload registers an instance whose server files are already in
+	// place, e.g. copied from another instance
+	SkipDownload bool `json:"skip_download,omitempty"`
+
+	// clone is set for instances cloned from a backup of another map
+	clone *cloneSource
+}
+
+// Job tracks a provisioning run
+type Job struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Step  string `json:"step"`
+	Error string `json:"error,omitempty"`
+	Dir   string `json:"dir"`
+	// Source and Archive are the map and backup a clone was created from
+	Source  string `json:"source,omitempty"`
+	Archive string `json:"archive,omitempty"`
+	// Ports are the ports of the instance, including assigned ones
+	Ports    map[string]int `json:"ports,omitempty"`
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished,omitempty"`
+}
+
+type Provisioner struct {
+	config ProvisionConfig
+	pm     *processmanager.ProcessManager
+	bm     *backup.BackupManager
+
+	// OnRegistered is called after a new map was added to the configs
+	OnRegistered func(name string)
+
+	jobs   map[string]*Job
+	active map[string]bool
+	// reserved are the ports of the instances being provisioned, they are
+	// not registered yet
+	reserved map[string][]int
+	mu       sync.Mutex
+}
+
+func NewProvisioner(configFile string, pm *processmanager.ProcessManager, bm *backup.BackupManager) (*Provisioner, error) {
+	p := &Provisioner{pm: pm, bm: bm, jobs: make(map[string]*Job), active: make(map[string]bool), reserved: make(map[string][]int)}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read provision config: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &p.config); err != nil {
+			return nil, fmt.Errorf("failed to parse provision config: %w", err)
+		}
+	}
+	if p.config.ServerExe == "" {
+		p.config.ServerExe = defaultServerExe
+	}
+	if p.config.SteamCMDMinutes <= 0 {
+		p.config.SteamCMDMinutes = defaultSteamCMDMinutes
+	}
+	if p.config.Ports.Game.Start == 0 {
+		p.config.Ports.Game = PortRange{Start: defaultGamePortStart, End: defaultGamePortEnd}
+	}
+	if p.config.Ports.RCON.Start == 0 {
+		p.config.Ports.RCON = PortRange{Start: defaultRCONPortStart, End: defaultRCONPortEnd}
+	}
+	for name, r := range map[string]PortRange{"game": p.config.Ports.Game, "query": p.config.Ports.Query, "rcon": p.config.Ports.RCON} {
+		if err := r.validate(name); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (req *Request) validate() error {
+	switch {
+	case !namePattern.MatchString(req.Name):
+		return fmt.Errorf("%w: name must be 1-64 letters, digits, '-' or '_'", ErrInvalidRequest)
+	case !mapPattern.MatchString(req.Map):
+		return fmt.Errorf("%w: map must be a map name such as TheIsland_WP", ErrInvalidRequest)
+	case !sessionPattern.MatchString(req.SessionName):
+		return fmt.Errorf("%w: session_name must be 1-100 characters without '?' or quotes", ErrInvalidRequest)
+	case !validPort(req.GamePort) || !validPort(req.QueryPort) || !validPort(req.RCONPort):
+		return fmt.Errorf("%w: game_port, query_port and rcon_port must be port numbers or left out", ErrInvalidRequest)
+	case samePort(req.GamePort, req.QueryPort) || samePort(req.GamePort, req.RCONPort) || samePort(req.QueryPort, req.RCONPort):
+		return fmt.Errorf("%w: game_port, query_port and rcon_port must differ", ErrInvalidRequest)
+	case req.AdminPassword == "" || strings.ContainsAny(req.AdminPassword, "?\" \r\n"):
+		return fmt.Errorf("%w: admin_password is required and must not contain spaces, '?' or quotes", ErrInvalidRequest)
+	case strings.ContainsAny(req.ServerPassword, "?\" \r\n"):
+		return fmt.Errorf("%w: server_password must not contain spaces, '?' or quotes", ErrInvalidRequest)
+	case req.MaxPlayers < 0 || req.MaxPlayers > 200:
+		return fmt.Errorf("%w: max_players must not exceed 200", ErrInvalidRequest)
+	}
+	if req.MaxPlayers == 0 {
+		req.MaxPlayers = 70
+	}
+	return nil
+}
+
+func validPort(port int) bool {
+	return port >= 0 && port <= 65535
+}
+
+func samePort(a, b int) bool {
+	return a != 0 && a == b
+}
+
+// assignPorts checks the ports given in the request against the registered
+// maps, the instances being provisioned and the host, and picks free ports
+// for the ones left out
+func (p *Provisioner) assignPorts(req *Request) error {
+	taken := p.pm.UsedPorts()
+	for name, ports := range p.reserved {
+		for _, port := range ports {
+			taken[port] = name
+		}
+	}
+
+	fields := []struct {
+		name     string
+		port     *int
+		ports    PortRange
+		protocol string
+	}{
+		{"game_port", &req.GamePort, p.config.Ports.Game, "udp"},
+		{"query_port", &req.QueryPort, p.config.Ports.Query, "udp"},
+		{"rcon_port", &req.RCONPort, p.config.Ports.RCON, "tcp"},
+	}
+	// The given ports first, so the picked ones avoid them
+	for _, field := range fields {
+		if *field.port == 0 {
+			continue
+		}
+		if owner, ok := taken[*field.port]; ok {
+			return fmt.Errorf("%w: %s %d is used by map %s", processmanager.ErrPortConflict, field.name, *field.port, owner)
+		}
+		if !processmanager.PortFree(*field.port, field.protocol) {
+			return fmt.Errorf("%w: %s %d is in use on this host", processmanager.ErrPortConflict, field.name, *field.port)
+		}
+		taken[*field.port] = req.Name
+	}
+	for _, field := range fields {
+		if *field.port != 0 || field.ports.Start == 0 {
+			continue
+		}
+		for port := field.ports.Start; port <= field.ports.End; port++ {
+			if _, ok := taken[port]; !ok && processmanager.PortFree(port, field.protocol) {
+				*field.port = port
+				break
+			}
+		}
+		if *field.port == 0 {
+			return fmt.Errorf("%w: no free %s in %d-%d", processmanager.ErrPortConflict, field.name, field.ports.Start, field.ports.End)
+		}
+		taken[*field.port] = req.Name
+	}
+	return nil
+}
+
+// Provision validates the request and creates the instance in the
+// background. Ports left out of the request are assigned.
+func (p *Provisioner) Provision(req Request) (Job, error) {
+	if err := req.validate(); err != nil {
+		return Job{}, err
+	}
+	if p.config.ServersRoot == "" {
+		return Job{}, errors.New("servers_root is not configured in the provision config")
+	}
+	if !req.SkipDownload && p.config.SteamCMDPath == "" {
+		return Job{}, errors.New("steamcmd_path is not configured in the provision config")
+	}
+	return p.start(req)
+}
+
+// start reserves the ports of a validated request and runs it in the
+// background
+func (p *Provisioner) start(req Request) (Job, error) {
+	if p.pm.HasMap(req.Name) {
+		return Job{}, fmt.Errorf("%w: %s", processmanager.ErrMapExists, req.Name)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active[req.Name] {
+		return Job{}, fmt.Errorf("%w: %s is already being provisioned", processmanager.ErrMapExists, req.Name)
+	}
+	if err := p.assignPorts(&req); err != nil {
+		return Job{}, err
+	}
+	if err := p.pm.Validate(p.processConfig(filepath.Join(p.config.ServersRoot, req.Name), req)); err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:      fmt.Sprintf("%s-%s", req.Name, strconv.FormatInt(time.Now().UnixNano(), 36)),
+		Name:    req.Name,
+		Step:    StepQueued,
+		Dir:     filepath.Join(p.config.ServersRoot, req.Name),
+		Ports:   make(map[string]int),
+		Started: time.Now(),
+	}
+	if req.clone != nil {
+		job.Source = req.clone.source
+		job.Archive = req.clone.archive
+	}
+	for name, port := range map[string]int{"game_port": req.GamePort, "query_port": req.QueryPort, "rcon_port": req.RCONPort} {
+		if port != 0 {
+			job.Ports[name] = port
+			p.reserved[req.Name] = append(p.reserved[req.Name], port)
+		}
+	}
+	p.jobs[job.ID] = job
+	p.active[req.Name] = true
+	p.persistLocked(job)
+
+	go p.run(job, req)
+	return *job, nil
+}
+
+// Job returns a provisioning job by ID
+func (p *Provisioner) Job(id string) (Job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if job, ok := p.jobs[id]; ok {
+		return *job, true
+	}
+	var job Job
+	found, err := state.Get(bucketProvisionJobs, id, &job)
+	return job, err == nil && found
+}
+
+func (p *Provisioner) setStep(job *Job, step string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job.Step = step
+	p.persistLocked(job)
+	log.Printf("Provisioning %s: %s", job.Name, step)
+}
+
+func (p *Provisioner) persistLocked(job *Job) {
+	if err := state.Put(bucketProvisionJobs, job.ID, job); err != nil {
+		log.Printf("Failed to persist provisioning job %s: %v", job.ID, err)
+	}
+}
+
+func (p *Provisioner) run(job *Job, req Request) {
+	err := p.provision(job, req)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job.Finished = time.Now()
+	if err != nil {
+		job.Step = StepFailed
+		job.Error = err.Error()
+		log.Printf("Provisioning %s failed: %v", job.Name, err)
+	} else {
+		job.Step = StepDone
+		log.Printf("Provisioned %s in %s", job.Name, job.Dir)
+	}
+	delete(p.active, req.Name)
+	delete(p.reserved, req.Name)
+	p.persistLocked(job)
+}
+
+func (p *Provisioner) provision(job *Job, req Request) error {
+	configDir := filepath.Join(job.Dir, "ShooterGame", "Saved", "Config", "WindowsServer")
+	savedDir := filepath.Join(job.Dir, "ShooterGame", "Saved", "SavedArks", req.Map)
+
+	p.setStep(job, StepLayout)
+	for _, dir := range []string{job.Dir, configDir, savedDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+
+	if !req.SkipDownload {
+		p.setStep(job, StepDownload)
+		if err := p.steamcmd(job); err != nil {
+			return err
+		}
+	}
+
+	p.setStep(job, StepConfig)
+	if req.clone != nil {
+		if err := p.cloneIni(req, configDir); err != nil {
+			return err
+		}
+		p.setStep(job, StepRestore)
+		if _, err := p.bm.RestoreToDirectory(req.clone.source, req.clone.archive, savedDir); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", req.clone.archive, err)
+		}
+	} else {
+		for _, name := range []string{"GameUserSettings.ini", "Game.ini"} {
+			if err := p.renderTemplate(name, filepath.Join(configDir, name), req); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.setStep(job, StepRegister)
+	if err := p.register(job, req, savedDir); err != nil {
+		return err
+	}
+
+	if req.clone != nil && req.clone.start {
+		p.setStep(job, StepStart)
+		if _, err := p.pm.EnableProcess(req.Name); err != nil {
+			return fmt.Errorf("failed to start %s: %w", req.Name, err)
+		}
+	}
+	return nil
+}
+
+// steamcmd installs or updates the server files, its output goes to
+// logs/provision_<name>.log
+func (p *Provisioner) steamcmd(job *Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.config.SteamCMDMinutes)*time.Minute)
+	defer cancel()
+
+	logFile, err := os.Create(filepath.Join("logs", fmt.Sprintf("provision_%s.log", job.Name)))
+	if err != nil {
+		return fmt.Errorf("failed to create provisioning log: %w", err)
+	}
+	defer logFile.Close()
+
+	if err := steamcmd.Update(ctx, p.config.SteamCMDPath, job.Dir, logFile); err != nil {
+		return fmt.Errorf("%w, see %s", err, logFile.Name())
+	}
+	if _, err := os.Stat(filepath.Join(job.Dir, p.config.ServerExe)); err != nil {
+		return fmt.Errorf("steamcmd finished but the server executable is missing: %w", err)
+	}
+	return nil
+}
+
+// renderTemplate writes an ini file from the template in the configured
+// template dir, falling back to the built-in one
+func (p *Provisioner) renderTemplate(name string, dst string, req Request) error {
+	var text []byte
+	var err error
+	if p.config.TemplateDir != "" {
+		text, err = os.ReadFile(filepath.Join(p.config.TemplateDir, name+".tmpl"))
+	}
+	if p.config.TemplateDir == "" || os.IsNotExist(err) {
+		text, err = defaultTemplates.ReadFile("templates/" + name + ".tmpl")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read template %s: %w", name, err)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	defer file.Close()
+
+	if err := tmpl.Execute(file, req); err != nil {
+		return fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return nil
+}
+
+// launchConfig builds the server's launch config from the request
+func launchConfig(req Request) *processmanager.LaunchConfig {
+	return &processmanager.LaunchConfig{
+		Map:         req.Map,
+		SessionName: req.SessionName,
+		Port:        req.GamePort,
+		QueryPort:   req.QueryPort,
+		RCONPort:    req.RCONPort,
+		MaxPlayers:  req.MaxPlayers,
+		ClusterID:   req.ClusterID,
+		ClusterDir:  req.ClusterDir,
+	}
+}
+
+// processConfig builds the process config of an instance in dir. Clones
+// keep the settings of their source besides ports, session and cluster.
+func (p *Provisioner) processConfig(dir string, req Request) processmanager.ProcessConfig {
+	config := processmanager.ProcessConfig{RestartInterval: 5}
+	if req.clone != nil {
+		config = req.clone.config
+		config.ConfigDir = ""
+		config.Docker = nil
+		config.Env = maps.Clone(config.Env)
+	}
+	config.Map = req.Name
+	config.Executable = filepath.ToSlash(filepath.Join(dir, p.config.ServerExe))
+	config.Args = req.ExtraArgs
+
+	launch := launchConfig(req)
+	if req.clone != nil && req.clone.config.Launch != nil {
+		// Mods, BattlEye and the other options stay as they are
+		source := *req.clone.config.Launch
+		source.SessionName = launch.SessionName
+		source.Port = launch.Port
+		source.QueryPort = launch.QueryPort
+		source.RCONPort = launch.RCONPort
+		source.ServerPassword = req.ServerPassword
+		source.ClusterID = launch.ClusterID
+		source.ClusterDir = launch.ClusterDir
+		launch = &source
+	}
+	config.Launch = launch
+	return config
+}
+
+func (p *Provisioner) register(job *Job, req Request, savedDir string) error {
+	config := p.processConfig(job.Dir, req)
+	if err := p.pm.RegisterMap(config); err != nil {
+		return fmt.Errorf("failed to register process: %w", err)
+	}
+
+	err := rcon.SaveRconInfo(rcon.RconInfo{
+		Map:  req.Name,
+		IP:   "127.0.0.1",
+		Port: strconv.Itoa(req.RCONPort),
+		Pass: req.AdminPassword,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register rcon: %w", err)
+	}
+
+	if p.config.BackupRoot != "" {
+		err = p.bm.RegisterMap(req.Name, defaultBackupConfig(filepath.Join(p.config.BackupRoot, req.Name), savedDir, req.Map))
+		if err != nil {
+			return fmt.Errorf("failed to register backups: %w", err)
+		}
+	}
+
+	// The server is reachable from this host without the rules, so a
+	// failure does not fail the provisioning
+	if firewall.Enabled() {
+		if _, err := firewall.Sync(config, false); err != nil {
+			log.Printf("Failed to open the firewall for %s: %v", req.Name, err)
+		}
+	}
+
+	if p.OnRegistered != nil {
+		p.OnRegistered(req.Name)
+	}
+	return nil
+}
+
+// defaultBackupConfig backs up the world and the player and tribe files of
+// a map's save folder every 30 minutes
+func defaultBackupConfig(zipDir string, savedDir string, mapName string) backup.MapConfig {
+	return backup.MapConfig{
+		ZipDir:          filepath.ToSlash(zipDir),
+		ExtractDir:      filepath.ToSlash(savedDir),
+		FileExtensions:  []string{".arktributetribe", ".arkprofile", ".profilebak", ".arktribe", ".tribebak"},
+		SpecificFiles:   []string{mapName + ".ark"},
+		IntervalMinutes: 30,
+		RetentionDays:   30,
+	}
+}
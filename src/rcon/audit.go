@@ -0,0 +1,108 @@
+package rcon
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/secrets"
+	"asa_servermanager_api/tenants"
+)
+
+const auditLogFile = "./logs/rcon_audit.log"
+
+// AuditEntry records one RCON command and who asked for it. Player is the
+// player a cheat targets, see ExecuteCheat.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Caller  Caller    `json:"caller"`
+	Map     string    `json:"map"`
+	Command string    `json:"command"`
+	Player  string    `json:"player,omitempty"`
+	Allowed bool      `json:"allowed"`
+	Error   string    `json:"error,omitempty"`
+}
+
+var auditMu sync.Mutex
+
+// tenantAuditLogFile is the audit log of the commands on a tenant's maps
+func tenantAuditLogFile(tenant string) string {
+	return "./logs/rcon_audit_" + tenant + ".log"
+}
+
+// audit appends an entry to the audit log, one JSON object per line.
+// Commands on a tenant's maps are also written to the tenant's audit log.
+func audit(caller Caller, m string, c string, allowed bool, err error) {
+	auditPlayer(caller, m, "", c, allowed, err)
+}
+
+// auditPlayer is audit for a command that targets a player
+func auditPlayer(caller Caller, m string, player string, c string, allowed bool, err error) {
+	entry := AuditEntry{Time: time.Now(), Caller: caller, Map: m, Command: c, Player: player, Allowed: allowed}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	data, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		log.Printf("Failed to encode rcon audit entry: %v", jsonErr)
+		return
+	}
+	data = append(secrets.RedactBytes(data), '\n')
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	appendAudit(auditLogFile, data)
+	if tenant := tenants.Owner(m); tenant != "" {
+		appendAudit(tenantAuditLogFile(tenant), data)
+	}
+}
+
+func appendAudit(path string, data []byte) {
+	file, fileErr := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if fileErr != nil {
+		log.Printf("Failed to open rcon audit log: %v", fileErr)
+		return
+	}
+	defer file.Close()
+
+	if _, writeErr := file.Write(data); writeErr != nil {
+		log.Printf("Failed to write rcon audit log: %v", writeErr)
+	}
+}
+
+// AuditLog returns the entries of the audit log, oldest first, or of a
+// tenant's audit log if tenant is not empty. A missing log has no entries.
+func AuditLog(tenant string) ([]AuditEntry, error) {
+	path := auditLogFile
+	if tenant != "" {
+		path = tenantAuditLogFile(tenant)
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
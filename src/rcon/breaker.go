@@ -0,0 +1,316 @@
+package rcon
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorcon/rcon"
+)
+
+const (
+	defaultDialTimeout     = 5 * time.Second
+	defaultTimeout         = 10 * time.Second
+	defaultRetries         = 2
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+
+	// retryBackoff is the wait before the first retry, it doubles for each
+	// further one
+	retryBackoff = 500 * time.Millisecond
+
+	// maxRecentErrors is how many errors the health of a map keeps
+	maxRecentErrors = 10
+)
+
+// Kinds of RCON errors, see HealthError
+const (
+	// ErrorUnreachable: the server could not be connected to, it is down
+	// or the IP or port is wrong
+	ErrorUnreachable = "unreachable"
+	// ErrorAuthFailed: the server rejected the password
+	ErrorAuthFailed = "auth_failed"
+	// ErrorNoResponse: the server took the connection but a command failed
+	// or got no response in time
+	ErrorNoResponse = "no_response"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed = "closed"
+	// CircuitOpen fails commands right away
+	CircuitOpen = "open"
+	// CircuitHalfOpen lets a command through to see if the server is back
+	CircuitHalfOpen = "half_open"
+)
+
+// ErrUnavailable is returned without contacting the server while a map's
+// RCON is marked unavailable. It is an ErrRequestFailed, so callers that
+// handle an unreachable server handle it too.
+var ErrUnavailable = fmt.Errorf("%w: rcon is unavailable", ErrRequestFailed)
+
+// ClientConfig bounds how long the manager waits on a server's RCON and
+// when it stops trying. Zero values take the defaults.
+type ClientConfig struct {
+	// DialTimeoutSeconds is the time to connect and log in, default 5
+	DialTimeoutSeconds int `json:"dial_timeout_seconds,omitempty"`
+	// TimeoutSeconds is the time to send a command and read its response,
+	// default 10
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// Retries is how often a command is tried again when the server could
+	// not be connected to, default 2. Commands are never sent twice, so a
+	// command the server got is not retried. -1 turns retries off.
+	Retries int `json:"retries,omitempty"`
+	// BreakerFailures is how many failed commands in a row mark a map's
+	// RCON unavailable, default 5. -1 turns the breaker off.
+	BreakerFailures int `json:"breaker_failures,omitempty"`
+	// BreakerCooldownSeconds is how long commands fail right away once a
+	// map is marked unavailable, default 30. A command after that is tried
+	// again and clears the mark if it succeeds.
+	BreakerCooldownSeconds int `json:"breaker_cooldown_seconds,omitempty"`
+}
+
+// Availability is the circuit breaker state of a map's RCON
+type Availability struct {
+	Available bool `json:"available"`
+	// Failures are the failed commands in a row
+	Failures  int       `json:"failures,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Since     time.Time `json:"since,omitempty"`
+	// RetryAt is when the next command is tried again, while unavailable
+	RetryAt time.Time `json:"retry_at,omitempty"`
+}
+
+// HealthError is a failed command of a map
+type HealthError struct {
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"`
+	Error string    `json:"error"`
+}
+
+// Health is how a map's RCON has been answering since the manager started.
+// LatencyMS is the round trip of the last successful command.
+type Health struct {
+	Map          string        `json:"map"`
+	Address      string        `json:"address"`
+	Circuit      string        `json:"circuit"`
+	Availability Availability  `json:"availability"`
+	LastSuccess  time.Time     `json:"last_success,omitempty"`
+	LatencyMS    int64         `json:"latency_ms,omitempty"`
+	LastFailure  time.Time     `json:"last_failure,omitempty"`
+	RecentErrors []HealthError `json:"recent_errors"`
+}
+
+type breaker struct {
+	failures  int
+	lastError string
+	openSince time.Time
+	retryAt   time.Time
+
+	lastSuccess time.Time
+	latency     time.Duration
+	recent      []HealthError
+}
+
+var (
+	clientConfig = ClientConfig{}
+	clientMu     sync.RWMutex
+
+	breakers   = make(map[string]*breaker)
+	breakersMu sync.Mutex
+)
+
+// Configure sets the timeouts, retries and circuit breaker of RCON commands
+func Configure(config ClientConfig) error {
+	if config.DialTimeoutSeconds < 0 || config.TimeoutSeconds < 0 || config.BreakerCooldownSeconds < 0 {
+		return errors.New("rcon timeouts must not be negative")
+	}
+	if config.Retries < -1 || config.BreakerFailures < -1 {
+		return errors.New("rcon retries and breaker_failures must be -1 or more")
+	}
+	clientMu.Lock()
+	clientConfig = config
+	clientMu.Unlock()
+	return nil
+}
+
+func currentConfig() ClientConfig {
+	clientMu.RLock()
+	defer clientMu.RUnlock()
+	return clientConfig
+}
+
+func orDefault(seconds int, fallback time.Duration) time.Duration {
+	if seconds == 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func orDefaultCount(value int, fallback int) int {
+	switch value {
+	case 0:
+		return fallback
+	case -1:
+		return 0
+	}
+	return value
+}
+
+// defaultOptions are the timeouts of the config, options passed by the
+// caller come after them and win
+func defaultOptions(options []rcon.Option) []rcon.Option {
+	config := currentConfig()
+	return append([]rcon.Option{
+		rcon.SetDialTimeout(orDefault(config.DialTimeoutSeconds, defaultDialTimeout)),
+		rcon.SetDeadline(orDefault(config.TimeoutSeconds, defaultTimeout)),
+	}, options...)
+}
+
+// allowed reports whether commands may be sent to a map, an error while
+// its RCON is marked unavailable
+func allowed(m string) error {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[m]
+	if !ok || b.openSince.IsZero() {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(b.retryAt) {
+		return fmt.Errorf("%w: %s, %d failed commands in a row, last: %s; retrying after %s", ErrUnavailable, m, b.failures, b.lastError, b.retryAt.Format(time.RFC3339))
+	}
+	// Let one command through to find out whether the server is back
+	b.retryAt = now.Add(orDefault(currentConfig().BreakerCooldownSeconds, defaultBreakerCooldown))
+	return nil
+}
+
+// errorKind tells why a command failed
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, rcon.ErrAuthFailed):
+		return ErrorAuthFailed
+	case connectFailed(err):
+		return ErrorUnreachable
+	}
+	return ErrorNoResponse
+}
+
+// recordResult feeds the result of a command and how long it took to the
+// map's breaker and health. Only failures to reach the server count, a
+// server that answers is available.
+func recordResult(m string, err error, latency time.Duration) {
+	config := currentConfig()
+	threshold := orDefaultCount(config.BreakerFailures, defaultBreakerFailures)
+
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[m]
+	if !ok {
+		b = &breaker{}
+		breakers[m] = b
+	}
+	if err == nil || !errors.Is(err, ErrRequestFailed) {
+		if !b.openSince.IsZero() {
+			log.Printf("RCON of map '%s' is available again after %s", m, time.Since(b.openSince).Round(time.Second))
+		}
+		*b = breaker{lastSuccess: time.Now(), latency: latency, recent: b.recent}
+		return
+	}
+
+	b.recent = append(b.recent, HealthError{Time: time.Now(), Kind: errorKind(err), Error: err.Error()})
+	if len(b.recent) > maxRecentErrors {
+		b.recent = b.recent[len(b.recent)-maxRecentErrors:]
+	}
+	b.failures++
+	b.lastError = err.Error()
+	if threshold == 0 || b.failures < threshold {
+		return
+	}
+	now := time.Now()
+	if b.openSince.IsZero() {
+		b.openSince = now
+		log.Printf("RCON of map '%s' is unavailable after %d failed commands in a row: %v", m, b.failures, err)
+	}
+	b.retryAt = now.Add(orDefault(config.BreakerCooldownSeconds, defaultBreakerCooldown))
+}
+
+// MapAvailability returns the circuit breaker state of a map's RCON
+func MapAvailability(m string) Availability {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[m]
+	if !ok {
+		return Availability{Available: true}
+	}
+	availability := Availability{Available: b.openSince.IsZero(), Failures: b.failures, LastError: b.lastError}
+	if !availability.Available {
+		availability.Since = b.openSince
+		availability.RetryAt = b.retryAt
+	}
+	return availability
+}
+
+// MapHealth returns how a map's RCON has been answering, newest error
+// first
+func MapHealth(m string) (Health, error) {
+	rinfo, err := LoadRconInfo(m)
+	if err != nil {
+		return Health{}, err
+	}
+	health := Health{Map: m, Address: rinfo.IP + ":" + rinfo.Port, Circuit: CircuitClosed, Availability: MapAvailability(m), RecentErrors: []HealthError{}}
+
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[m]
+	if !ok {
+		return health, nil
+	}
+	if !b.openSince.IsZero() {
+		health.Circuit = CircuitOpen
+		if !time.Now().Before(b.retryAt) {
+			health.Circuit = CircuitHalfOpen
+		}
+	}
+	health.LastSuccess = b.lastSuccess
+	if !b.lastSuccess.IsZero() {
+		health.LatencyMS = b.latency.Milliseconds()
+	}
+	for i := len(b.recent) - 1; i >= 0; i-- {
+		health.RecentErrors = append(health.RecentErrors, b.recent[i])
+	}
+	if len(b.recent) > 0 {
+		health.LastFailure = b.recent[len(b.recent)-1].Time
+	}
+	return health, nil
+}
+
+// Available reports whether a map's RCON is not marked unavailable
+func Available(m string) bool {
+	return MapAvailability(m).Available
+}
+
+// connectFailed reports whether a command failed before it reached the
+// server, so sending it again cannot run it twice
+func connectFailed(err error) bool {
+	var connectErr *connectError
+	return errors.As(err, &connectErr)
+}
+
+// connectError marks a failure to connect or log in
+type connectError struct {
+	err error
+}
+
+func (e *connectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connectError) Unwrap() error {
+	return e.err
+}
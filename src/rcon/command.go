@@ -0,0 +1,229 @@
+package rcon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Argument kinds of a CommandRule
+const (
+	// ArgInt is a whole number, e.g. a player ID
+	ArgInt = "int"
+	// ArgID is letters, digits, - and _, e.g. an EOS ID
+	ArgID = "id"
+	// ArgWord is anything without whitespace, or a quoted string
+	ArgWord = "word"
+	// ArgText is the rest of the command as typed, it must come last
+	ArgText = "text"
+)
+
+// ErrInvalidCommand is returned for malformed commands and for arguments
+// that do not match the command's rule
+var ErrInvalidCommand = errors.New("invalid rcon command")
+
+// CommandRule lists the kinds of a command's arguments. Commands without a
+// rule take any arguments, a rule without Args takes none.
+type CommandRule struct {
+	Args []string `json:"args"`
+	// Optional is how many of the trailing Args may be left out
+	Optional int `json:"optional,omitempty"`
+}
+
+// defaultRules cover the commands the manager and its usual callers send,
+// rules in the permissions file replace them
+var defaultRules = map[string]CommandRule{
+	"broadcast":                   {Args: []string{ArgText}},
+	"serverchat":                  {Args: []string{ArgText}},
+	"serverchatto":                {Args: []string{ArgID, ArgText}},
+	"setmessageoftheday":          {Args: []string{ArgText}},
+	"kickplayer":                  {Args: []string{ArgID}},
+	"banplayer":                   {Args: []string{ArgID}},
+	"unbanplayer":                 {Args: []string{ArgID}},
+	"allowplayertojoinnocheck":    {Args: []string{ArgID}},
+	"disallowplayertojoinnocheck": {Args: []string{ArgID}},
+	"listplayers":                 {},
+	"saveworld":                   {},
+	"doexit":                      {},
+	"destroywilddinos":            {},
+	"forceupdatedynamicconfig":    {},
+}
+
+// Command is a parsed command line
+type Command struct {
+	// Name is lowercased, the server ignores its case
+	Name string
+	// Args are unquoted
+	Args []string
+	// Line is the command as typed, without surrounding whitespace
+	Line string
+	// starts holds the offset of each argument in Line
+	starts []int
+}
+
+// ParseCommand splits a command line into its name and arguments.
+// Arguments are separated by whitespace, double quotes group an argument
+// and \" and \\ escape within them. Control characters and | are refused:
+// the server's console reads a line break or | as the start of another
+// command, which would slip past the permissions, e.g. a doexit after a
+// permitted broadcast.
+func ParseCommand(c string) (Command, error) {
+	line := strings.TrimSpace(c)
+	if line == "" {
+		return Command{}, fmt.Errorf("%w: command is blank", ErrInvalidCommand)
+	}
+	for _, r := range line {
+		if unicode.IsControl(r) {
+			return Command{}, fmt.Errorf("%w: command must not contain control characters", ErrInvalidCommand)
+		}
+		if r == '|' {
+			return Command{}, fmt.Errorf("%w: command must not contain |, it separates console commands", ErrInvalidCommand)
+		}
+	}
+
+	cmd := Command{Line: line}
+	var tokens []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		var token strings.Builder
+		if line[i] == '"' {
+			i++
+			closed := false
+			for i < len(line) {
+				ch := line[i]
+				if ch == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					token.WriteByte(line[i+1])
+					i += 2
+					continue
+				}
+				i++
+				if ch == '"' {
+					closed = true
+					break
+				}
+				token.WriteByte(ch)
+			}
+			if !closed {
+				return Command{}, fmt.Errorf("%w: unbalanced quote at position %d", ErrInvalidCommand, start+1)
+			}
+		} else {
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				token.WriteByte(line[i])
+				i++
+			}
+		}
+		tokens = append(tokens, token.String())
+		cmd.starts = append(cmd.starts, start)
+	}
+
+	cmd.Name = strings.ToLower(tokens[0])
+	cmd.Args = tokens[1:]
+	cmd.starts = cmd.starts[1:]
+	return cmd, nil
+}
+
+// Rest returns the command as typed from argument i on
+func (c Command) Rest(i int) string {
+	if i >= len(c.starts) {
+		return ""
+	}
+	return c.Line[c.starts[i]:]
+}
+
+// check validates the arguments of a command against the rule
+func (r CommandRule) check(cmd Command) error {
+	required := len(r.Args) - r.Optional
+	for i, kind := range r.Args {
+		if i >= len(cmd.Args) {
+			if i < required {
+				return fmt.Errorf("%w: %s takes %d argument(s), got %d", ErrInvalidCommand, cmd.Name, required, len(cmd.Args))
+			}
+			return nil
+		}
+		arg := cmd.Args[i]
+		switch kind {
+		case ArgText:
+			return nil
+		case ArgInt:
+			if !isInt(arg) {
+				return fmt.Errorf("%w: argument %d of %s must be a number, got %q", ErrInvalidCommand, i+1, cmd.Name, arg)
+			}
+		case ArgID:
+			if !isID(arg) {
+				return fmt.Errorf("%w: argument %d of %s must be an ID of letters, digits, - and _, got %q", ErrInvalidCommand, i+1, cmd.Name, arg)
+			}
+		case ArgWord:
+			if arg == "" {
+				return fmt.Errorf("%w: argument %d of %s must not be empty", ErrInvalidCommand, i+1, cmd.Name)
+			}
+		}
+	}
+	if len(cmd.Args) > len(r.Args) {
+		return fmt.Errorf("%w: %s takes at most %d argument(s), got %d", ErrInvalidCommand, cmd.Name, len(r.Args), len(cmd.Args))
+	}
+	return nil
+}
+
+// validate checks a rule from the permissions file
+func (r CommandRule) validate() error {
+	for i, kind := range r.Args {
+		switch kind {
+		case ArgInt, ArgID, ArgWord:
+		case ArgText:
+			if i != len(r.Args)-1 {
+				return errors.New("a text argument must come last")
+			}
+		default:
+			return fmt.Errorf("unknown argument kind %q, expected %s, %s, %s or %s", kind, ArgInt, ArgID, ArgWord, ArgText)
+		}
+	}
+	if r.Optional < 0 || r.Optional > len(r.Args) {
+		return fmt.Errorf("optional must be between 0 and %d", len(r.Args))
+	}
+	return nil
+}
+
+func isInt(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// SanitizeText makes free text, such as a message from a template, safe to
+// send as the last argument of a command: control characters become spaces
+// and | becomes /, so the text cannot start another command
+func SanitizeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '|':
+			return '/'
+		case unicode.IsControl(r):
+			return ' '
+		}
+		return r
+	}, text)
+}
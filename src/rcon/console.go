@@ -0,0 +1,446 @@
+package rcon
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	packetResponse     = 0
+	packetCommand      = 2
+	packetAuthResponse = 2
+	packetAuth         = 3
+
+	// maxPacketSize bounds a packet. Servers send long responses in packets
+	// past the 4096 bytes of the protocol, which one-shot commands cut off.
+	maxPacketSize = 1 << 20
+	// responseQuiet is how long a response may pause before it is taken as
+	// complete, for servers that do not echo the end marker
+	responseQuiet  = 500 * time.Millisecond
+	consoleTimeout = 10 * time.Second
+
+	maxSessions = 20
+	// maxSessionHistory is how many commands a session remembers
+	maxSessionHistory = 200
+	// sessionIdle is how long a session is kept without commands
+	sessionIdle = 15 * time.Minute
+)
+
+var (
+	// ErrSessionNotFound is returned for unknown, expired and closed
+	// sessions and for sessions of other callers
+	ErrSessionNotFound = errors.New("rcon session not found")
+	// ErrTooManySessions is returned when maxSessions are open
+	ErrTooManySessions = errors.New("too many open rcon sessions")
+
+	errConsoleAuth   = errors.New("authentication failed")
+	errConsoleBroken = errors.New("connection lost in the middle of a packet")
+)
+
+// Exchange is a command sent in a session and the server's response
+type Exchange struct {
+	Command  string `json:"command"`
+	Response string `json:"response"`
+	Error    string `json:"error,omitempty"`
+	// Parsed is the typed response of commands with a parser, see Parse
+	Parsed     interface{} `json:"parsed,omitempty"`
+	Time       time.Time   `json:"time"`
+	DurationMS int64       `json:"duration_ms"`
+}
+
+// SessionInfo describes an open session
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Map       string    `json:"map"`
+	Caller    string    `json:"caller"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used"`
+	Commands  int       `json:"commands"`
+	Connected bool      `json:"connected"`
+}
+
+// Session is an RCON console of a map: one connection kept open for a
+// series of commands, with the history of what was sent. Only the caller
+// who opened a session may use it.
+type Session struct {
+	id      string
+	mapName string
+	caller  Caller
+	created time.Time
+
+	mu       sync.Mutex
+	conn     *consoleConn
+	history  []Exchange
+	lastUsed time.Time
+	closed   bool
+}
+
+var (
+	sessions     = make(map[string]*Session)
+	sessionsMu   sync.Mutex
+	sessionsOnce sync.Once
+)
+
+// OpenSession connects to a map's server on behalf of caller
+func OpenSession(caller Caller, m string) (*Session, error) {
+	rinfo, err := LoadRconInfo(m)
+	if err != nil {
+		return nil, err
+	}
+	sessionsMu.Lock()
+	open := len(sessions)
+	sessionsMu.Unlock()
+	if open >= maxSessions {
+		return nil, fmt.Errorf("%w: %d", ErrTooManySessions, maxSessions)
+	}
+
+	conn, err := dialConsole(rinfo)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		conn.close()
+		return nil, err
+	}
+	s := &Session{id: hex.EncodeToString(buf), mapName: m, caller: caller, created: time.Now(), lastUsed: time.Now(), conn: conn}
+
+	sessionsOnce.Do(func() { go expireSessions() })
+	sessionsMu.Lock()
+	sessions[s.id] = s
+	sessionsMu.Unlock()
+	log.Printf("Opened rcon session %s on %s for %s", s.id, m, caller.Name)
+	return s, nil
+}
+
+// FindSession returns a session of caller
+func FindSession(caller Caller, id string) (*Session, error) {
+	sessionsMu.Lock()
+	s, ok := sessions[id]
+	sessionsMu.Unlock()
+	if !ok || s.caller.Name != caller.Name {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return s, nil
+}
+
+// ListSessions returns the sessions of caller, oldest first
+func ListSessions(caller Caller) []SessionInfo {
+	sessionsMu.Lock()
+	var list []*Session
+	for _, s := range sessions {
+		if s.caller.Name == caller.Name {
+			list = append(list, s)
+		}
+	}
+	sessionsMu.Unlock()
+
+	infos := make([]SessionInfo, 0, len(list))
+	for _, s := range list {
+		infos = append(infos, s.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+	return infos
+}
+
+// expireSessions closes the sessions left idle
+func expireSessions() {
+	for range time.Tick(time.Minute) {
+		sessionsMu.Lock()
+		var idle []*Session
+		for _, s := range sessions {
+			s.mu.Lock()
+			if time.Since(s.lastUsed) > sessionIdle {
+				idle = append(idle, s)
+			}
+			s.mu.Unlock()
+		}
+		sessionsMu.Unlock()
+		for _, s := range idle {
+			log.Printf("Closing rcon session %s on %s, idle for %s", s.id, s.mapName, sessionIdle)
+			s.Close()
+		}
+	}
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Info describes the session
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionInfo{
+		ID: s.id, Map: s.mapName, Caller: s.caller.Name, Created: s.created, LastUsed: s.lastUsed,
+		Commands: len(s.history), Connected: s.conn != nil,
+	}
+}
+
+// History returns the commands sent in the session, oldest first
+func (s *Session) History() []Exchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Exchange(nil), s.history...)
+}
+
+// Close disconnects the session and forgets it
+func (s *Session) Close() {
+	sessionsMu.Lock()
+	delete(sessions, s.id)
+	sessionsMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn != nil {
+		s.conn.close()
+		s.conn = nil
+	}
+}
+
+// Execute sends a command as-is, after the caller's permissions are
+// checked like for ExecuteAs. A connection lost since the last command, e.g.
+// to a server restart, is opened again. Commands of a session run one at a
+// time.
+func (s *Session) Execute(c string) (Exchange, error) {
+	perms, err := LoadPermissions()
+	if err != nil {
+		audit(s.caller, s.mapName, c, false, err)
+		return Exchange{}, err
+	}
+	if err := perms.Authorize(s.caller, c); err != nil {
+		log.Printf("Denied RCON command %q on %s for %s: %v", c, s.mapName, s.caller.Name, err)
+		audit(s.caller, s.mapName, c, false, err)
+		return Exchange{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return Exchange{}, fmt.Errorf("%w: %s", ErrSessionNotFound, s.id)
+	}
+
+	started := time.Now()
+	response, err := s.execute(c)
+	exchange := Exchange{Command: c, Response: response, Time: started, DurationMS: time.Since(started).Milliseconds()}
+	if err != nil {
+		exchange.Error = err.Error()
+	} else if parsed, ok := Parse(c, response); ok {
+		exchange.Parsed = parsed
+	}
+	s.lastUsed = time.Now()
+	s.history = append(s.history, exchange)
+	if len(s.history) > maxSessionHistory {
+		s.history = s.history[len(s.history)-maxSessionHistory:]
+	}
+
+	audit(s.caller, s.mapName, c, true, err)
+	streamResult(s.caller, s.mapName, c, response, err)
+	return exchange, err
+}
+
+func (s *Session) execute(c string) (string, error) {
+	reconnected := false
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return "", err
+		}
+		reconnected = true
+	}
+	response, err := s.conn.execute(c)
+	if err == nil {
+		return response, nil
+	}
+	s.conn.close()
+	s.conn = nil
+	if reconnected {
+		return "", err
+	}
+
+	// The server may have restarted since the last command
+	if err := s.connect(); err != nil {
+		return "", err
+	}
+	response, err = s.conn.execute(c)
+	if err != nil {
+		s.conn.close()
+		s.conn = nil
+	}
+	return response, err
+}
+
+func (s *Session) connect() error {
+	rinfo, err := LoadRconInfo(s.mapName)
+	if err != nil {
+		return err
+	}
+	conn, err := dialConsole(rinfo)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// consoleConn speaks the Source RCON protocol over a connection that stays
+// open between commands
+type consoleConn struct {
+	conn   net.Conn
+	nextID int32
+}
+
+// dialConsole connects with the map's password, falling back to the
+// password it replaced like dial
+func dialConsole(rinfo RconInfo) (*consoleConn, error) {
+	address := rinfo.IP + ":" + rinfo.Port
+	conn, err := openConsole(address, rinfo.Pass)
+	if !errors.Is(err, errConsoleAuth) {
+		return conn, err
+	}
+
+	previousPassesMu.Lock()
+	previous, ok := previousPasses[rinfo.Map]
+	previousPassesMu.Unlock()
+	if !ok {
+		return nil, err
+	}
+	return openConsole(address, previous)
+}
+
+func openConsole(address string, pass string) (*consoleConn, error) {
+	conn, err := net.DialTimeout("tcp", address, consoleTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not connect to %s: %w", ErrRequestFailed, address, err)
+	}
+	c := &consoleConn{conn: conn}
+
+	id, err := c.write(packetAuth, pass)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	deadline := time.Now().Add(consoleTimeout)
+	for {
+		// Servers may send an empty response before the auth response
+		respID, respType, _, err := c.read(deadline)
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("%w: could not authenticate to %s: %w", ErrRequestFailed, address, err)
+		}
+		if respType != packetAuthResponse {
+			continue
+		}
+		if respID != id {
+			c.close()
+			return nil, fmt.Errorf("%w: %s: %w", ErrRequestFailed, address, errConsoleAuth)
+		}
+		return c, nil
+	}
+}
+
+func (c *consoleConn) close() {
+	c.conn.Close()
+}
+
+// execute sends a command followed by an empty response packet. Servers
+// answer packets in order and echo the empty one, so the response is every
+// packet before the echo. Servers that do not echo it get responseQuiet to
+// finish their response.
+func (c *consoleConn) execute(command string) (string, error) {
+	id, err := c.write(packetCommand, command)
+	if err != nil {
+		return "", err
+	}
+	end, err := c.write(packetResponse, "")
+	if err != nil {
+		return "", err
+	}
+
+	var response strings.Builder
+	deadline := time.Now().Add(consoleTimeout)
+	received := false
+	for {
+		wait := deadline
+		if received && time.Now().Add(responseQuiet).Before(deadline) {
+			wait = time.Now().Add(responseQuiet)
+		}
+		respID, _, body, err := c.read(wait)
+		if err != nil {
+			var netErr net.Error
+			if received && errors.As(err, &netErr) && netErr.Timeout() {
+				return normalizeResponse(response.String()), nil
+			}
+			return "", fmt.Errorf("%w: error executing %q: %w", ErrRequestFailed, command, err)
+		}
+		switch respID {
+		case id:
+			response.WriteString(body)
+			received = true
+		case end:
+			return normalizeResponse(response.String()), nil
+		}
+		// Other packets answer earlier commands that timed out
+	}
+}
+
+// normalizeResponse drops the carriage returns and the trailing blank the
+// server ends its responses with
+func normalizeResponse(response string) string {
+	return strings.TrimRight(strings.ReplaceAll(response, "\r", ""), " \n")
+}
+
+func (c *consoleConn) write(packetType int32, body string) (int32, error) {
+	c.nextID++
+	id := c.nextID
+
+	packet := make([]byte, 0, 14+len(body))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(10+len(body)))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(id))
+	packet = binary.LittleEndian.AppendUint32(packet, uint32(packetType))
+	packet = append(packet, body...)
+	packet = append(packet, 0, 0)
+
+	c.conn.SetWriteDeadline(time.Now().Add(consoleTimeout))
+	if _, err := c.conn.Write(packet); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrRequestFailed, err)
+	}
+	return id, nil
+}
+
+// read returns the next packet. The deadline applies until the packet
+// starts, a timeout within a packet breaks the connection.
+func (c *consoleConn) read(deadline time.Time) (int32, int32, string, error) {
+	c.conn.SetReadDeadline(deadline)
+	header := make([]byte, 4)
+	if n, err := io.ReadFull(c.conn, header); err != nil {
+		if n > 0 {
+			return 0, 0, "", errConsoleBroken
+		}
+		return 0, 0, "", err
+	}
+	size := int32(binary.LittleEndian.Uint32(header))
+	if size < 10 || size > maxPacketSize {
+		return 0, 0, "", fmt.Errorf("invalid packet size %d", size)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(consoleTimeout))
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return 0, 0, "", errConsoleBroken
+	}
+	id := int32(binary.LittleEndian.Uint32(data[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(data[4:8]))
+	return id, packetType, string(bytes.TrimRight(data[8:], "\x00")), nil
+}
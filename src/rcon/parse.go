@@ -0,0 +1,154 @@
+package rcon
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// noResponse is what the server answers commands without output with
+const noResponse = "Server received, But no response!!"
+
+var (
+	gameLogPattern  = regexp.MustCompile(`^(\d{4}\.\d{2}\.\d{2}_\d{2}\.\d{2}\.\d{2}): (.*)$`)
+	tribeLogPattern = regexp.MustCompile(`^Tribe (.+?), ID (\d+): Day (\d+), (\d{2}:\d{2}:\d{2}): (.*)$`)
+	// richTextPattern matches the color tags of tribe log entries
+	richTextPattern = regexp.MustCompile(`<RichColor[^>]*>|</>`)
+	chatPattern     = regexp.MustCompile(`^(.+?) \((.*?)\): (.*)$`)
+)
+
+// ChatMessage is a line of GetChat
+type ChatMessage struct {
+	// Sender is the player's platform name, or SERVER for server messages
+	Sender string `json:"sender"`
+	// Character is the name of the player's survivor
+	Character string `json:"character,omitempty"`
+	Message   string `json:"message"`
+	Server    bool   `json:"server"`
+}
+
+// GameLogEntry is a line of GetGameLog
+type GameLogEntry struct {
+	// Time is when the server logged the entry, in its local time
+	Time time.Time `json:"time"`
+	// Tribe, TribeID, Day and GameTime are set for tribe log entries
+	Tribe    string `json:"tribe,omitempty"`
+	TribeID  string `json:"tribe_id,omitempty"`
+	Day      int    `json:"day,omitempty"`
+	GameTime string `json:"game_time,omitempty"`
+	// Message is without the color tags of the tribe log
+	Message string `json:"message"`
+}
+
+// responseLines returns the non-empty lines of a response, none for the
+// server's answer to commands without output
+func responseLines(response string) []string {
+	var lines []string
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == noResponse {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// ParseChat parses a GetChat response. Server messages read "SERVER: text",
+// player messages "Name (Character): text".
+func ParseChat(response string) []ChatMessage {
+	messages := []ChatMessage{}
+	for _, line := range responseLines(response) {
+		if text, ok := strings.CutPrefix(line, "SERVER: "); ok {
+			messages = append(messages, ChatMessage{Sender: "SERVER", Message: text, Server: true})
+			continue
+		}
+		if m := chatPattern.FindStringSubmatch(line); m != nil {
+			messages = append(messages, ChatMessage{Sender: m[1], Character: m[2], Message: m[3]})
+			continue
+		}
+		if sender, text, ok := strings.Cut(line, ": "); ok {
+			messages = append(messages, ChatMessage{Sender: sender, Message: text})
+		}
+	}
+	return messages
+}
+
+// ParseGameLog parses a GetGameLog response. Lines without a timestamp
+// continue the entry before them.
+func ParseGameLog(response string) []GameLogEntry {
+	entries := []GameLogEntry{}
+	for _, line := range responseLines(response) {
+		m := gameLogPattern.FindStringSubmatch(line)
+		if m == nil {
+			if len(entries) > 0 {
+				last := &entries[len(entries)-1]
+				last.Message += "\n" + richTextPattern.ReplaceAllString(line, "")
+			}
+			continue
+		}
+
+		entry := GameLogEntry{Message: m[2]}
+		if at, err := time.ParseInLocation("2006.01.02_15.04.05", m[1], time.Local); err == nil {
+			entry.Time = at
+		}
+		if t := tribeLogPattern.FindStringSubmatch(entry.Message); t != nil {
+			entry.Tribe = t[1]
+			entry.TribeID = t[2]
+			entry.Day, _ = strconv.Atoi(t[3])
+			entry.GameTime = t[4]
+			entry.Message = t[5]
+		}
+		entry.Message = richTextPattern.ReplaceAllString(entry.Message, "")
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ParseMOTD returns the message of a ShowMessageOfTheDay response, "" if
+// none is set
+func ParseMOTD(response string) string {
+	return strings.Join(responseLines(response), "\n")
+}
+
+// Parse returns the typed form of the response to a command, if the
+// command has a parser
+func Parse(command string, response string) (interface{}, bool) {
+	cmd, err := ParseCommand(command)
+	if err != nil {
+		return nil, false
+	}
+	switch cmd.Name {
+	case "listplayers":
+		return ParsePlayers(response), true
+	case "getchat":
+		return ParseChat(response), true
+	case "getgamelog":
+		return ParseGameLog(response), true
+	case "showmessageoftheday":
+		return ParseMOTD(response), true
+	}
+	return nil, false
+}
+
+// GetChat returns the chat messages sent since the last call. Like
+// ListPlayers it is meant for polling, so it is not permission checked or
+// audited.
+func GetChat(m string, timeout time.Duration) ([]ChatMessage, error) {
+	response, err := Probe(m, "GetChat", timeout)
+	if err != nil {
+		return nil, err
+	}
+	return ParseChat(response), nil
+}
+
+// GetGameLog returns the game log entries written since the last call,
+// like GetChat
+func GetGameLog(m string, timeout time.Duration) ([]GameLogEntry, error) {
+	response, err := Probe(m, "GetGameLog", timeout)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGameLog(response), nil
+}
@@ -0,0 +1,130 @@
+package rcon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const permissionsFile = "config/rcon_permissions.json"
+
+// ErrCommandDenied is returned when a caller's role may not run a command
+var ErrCommandDenied = errors.New("rcon command not permitted")
+
+// Caller identifies who asked for an RCON command
+type Caller struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	Addr string `json:"addr,omitempty"`
+	// Tenant limits the caller to the maps of a tenant, see package tenants
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// System is the caller of commands issued by the manager itself, such as
+// backup hooks. It is not subject to role permissions.
+var System = Caller{Name: "system", Role: "system"}
+
+// RolePermissions lists the commands a role may run. Entries match the
+// command name case-insensitively, "*" matches every command. Deny wins
+// over Allow.
+type RolePermissions struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+type Permissions struct {
+	// DefaultRole is the role of callers without a login or API key, if
+	// the server config allows anonymous callers
+	DefaultRole string                     `json:"default_role"`
+	Roles       map[string]RolePermissions `json:"roles"`
+	// Commands adds or replaces the argument rules of commands by name
+	Commands map[string]CommandRule `json:"commands,omitempty"`
+}
+
+// defaultPermissions let admins run every command, moderators the commands
+// of day to day moderation and viewers only list the players
+func defaultPermissions() Permissions {
+	return Permissions{
+		DefaultRole: "viewer",
+		Roles: map[string]RolePermissions{
+			"admin":     {Allow: []string{"*"}},
+			"moderator": {Allow: []string{"broadcast", "serverchat", "listplayers", "kickplayer", "saveworld"}, Deny: []string{"destroywilddinos", "doexit", "banplayer"}},
+			"viewer":    {Allow: []string{"listplayers"}},
+		},
+	}
+}
+
+// LoadPermissions reads the role permissions, a missing file yields the
+// defaults
+func LoadPermissions() (Permissions, error) {
+	data, err := os.ReadFile(permissionsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultPermissions(), nil
+		}
+		return Permissions{}, fmt.Errorf("failed to read rcon permissions: %w", err)
+	}
+
+	var perms Permissions
+	if err := json.Unmarshal(data, &perms); err != nil {
+		return Permissions{}, fmt.Errorf("failed to parse rcon permissions: %w", err)
+	}
+	for name, rule := range perms.Commands {
+		if err := rule.validate(); err != nil {
+			return Permissions{}, fmt.Errorf("invalid rcon permissions: command %s: %w", name, err)
+		}
+	}
+	return perms, nil
+}
+
+// rule returns the argument rule of a command
+func (p Permissions) rule(name string) (CommandRule, bool) {
+	for n, rule := range p.Commands {
+		if strings.ToLower(n) == name {
+			return rule, true
+		}
+	}
+	rule, ok := defaultRules[name]
+	return rule, ok
+}
+
+func matchesCommand(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || strings.ToLower(pattern) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks whether the caller's role may run the command and
+// whether its arguments match the command's rule. Commands of System are
+// trusted, callers without a role may run none.
+func (p Permissions) Authorize(caller Caller, c string) error {
+	if caller == System {
+		return nil
+	}
+
+	role := caller.Role
+	if role == "" {
+		return fmt.Errorf("%w: callers without a role may not run commands, send an API key or log in", ErrCommandDenied)
+	}
+	perms, ok := p.Roles[role]
+	if !ok {
+		return fmt.Errorf("%w: unknown role %q", ErrCommandDenied, role)
+	}
+
+	cmd, err := ParseCommand(c)
+	if err != nil {
+		return err
+	}
+	if matchesCommand(perms.Deny, cmd.Name) || !matchesCommand(perms.Allow, cmd.Name) {
+		return fmt.Errorf("%w: role %q may not run %q", ErrCommandDenied, role, cmd.Name)
+	}
+	if rule, ok := p.rule(cmd.Name); ok {
+		return rule.check(cmd)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package rcon
+
+import (
+	"strings"
+	"time"
+)
+
+// Player is a connected player as listed by ListPlayers
+type Player struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// ListPlayers returns the players connected to a map. Like Probe it is
+// meant for polling, so it is not permission checked or audited.
+func ListPlayers(m string, timeout time.Duration) ([]Player, error) {
+	response, err := Probe(m, "ListPlayers", timeout)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePlayers(response), nil
+}
+
+// ParsePlayers parses the "0. Name, EOSID" lines of a ListPlayers response
+func ParsePlayers(response string) []Player {
+	players := []Player{}
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSpace(line)
+		index, rest, ok := strings.Cut(line, ". ")
+		if !ok || strings.Trim(index, "0123456789") != "" || index == "" {
+			continue
+		}
+		// Names may contain commas, the ID never does
+		i := strings.LastIndex(rest, ",")
+		if i < 0 {
+			continue
+		}
+		players = append(players, Player{Name: strings.TrimSpace(rest[:i]), ID: strings.TrimSpace(rest[i+1:])})
+	}
+	return players
+}
@@ -1,15 +1,33 @@
 package rcon
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"regexp"
 	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/secrets"
 
 	"github.com/gorcon/rcon"
 )
 
+const rconConfigFile = "config/rcon_config.json"
+
+// configFileMu serializes rewrites of the rcon config file
+var configFileMu sync.Mutex
+
+// previousPasses holds the passwords replaced by SetPassword, servers keep
+// using them until they restart
+var (
+	previousPasses   = make(map[string]string)
+	previousPassesMu sync.Mutex
+)
+
 type RconInfo struct {
 	Map  string `json:"map"`
 	IP   string `json:"ip"`
@@ -17,45 +35,365 @@ type RconInfo struct {
 	Pass string `json:"pass"`
 }
 
-func RconCommand(m string, c string) string {
-	re := regexp.MustCompile(`[^a-zA-Z0-9\s]+`)
-	res := re.ReplaceAllString(c, "")
-	cl := strings.ToLower(res)
+var (
+	// ErrMapNotConfigured is returned for maps missing from rcon_config.json
+	ErrMapNotConfigured = errors.New("no rcon configuration found for map")
+	// ErrRequestFailed wraps connection and execution errors of the server
+	ErrRequestFailed = errors.New("rcon request failed")
+)
 
-	data, err := os.ReadFile("config/rcon_config.json")
+// RconCommand executes a command typed by caller as-is, if the caller's
+// role permits the command and its arguments are valid, see Authorize
+func RconCommand(caller Caller, m string, c string) (string, error) {
+	response, err := ExecuteAs(caller, m, strings.TrimSpace(c))
 	if err != nil {
-		log.Fatalf("Error unmarshaling JSON: %v", err)
+		log.Printf("RCON command failed: %v", err)
 	}
+	return response, err
+}
 
-	var rdata []RconInfo
-	err = json.Unmarshal(data, &rdata)
+// Execute sends a command to the map's RCON endpoint as-is and reports
+// connection and execution errors to the caller. It runs as System.
+func Execute(m string, c string) (string, error) {
+	return ExecuteAs(System, m, c)
+}
+
+// ExecuteAs checks the caller's permissions and executes the command.
+// Every attempt, permitted or not, is written to the audit log.
+func ExecuteAs(caller Caller, m string, c string) (string, error) {
+	return executeAs(caller, m, c)
+}
+
+// ExecuteAsTimeout is ExecuteAs giving up on the server after timeout
+func ExecuteAsTimeout(caller Caller, m string, c string, timeout time.Duration) (string, error) {
+	return executeAs(caller, m, c, rcon.SetDialTimeout(timeout), rcon.SetDeadline(timeout))
+}
+
+func executeAs(caller Caller, m string, c string, options ...rcon.Option) (string, error) {
+	perms, err := LoadPermissions()
+	if err != nil {
+		audit(caller, m, c, false, err)
+		return "", err
+	}
+	if err := perms.Authorize(caller, c); err != nil {
+		log.Printf("Denied RCON command %q on %s for %s: %v", c, m, caller.Name, err)
+		audit(caller, m, c, false, err)
+		return "", err
+	}
+
+	rinfo, err := LoadRconInfo(m)
+	if err != nil {
+		audit(caller, m, c, true, err)
+		return "", err
+	}
+
+	log.Printf("Map: %s\nCommands: %s\nCaller: %s", rinfo.Map, c, caller.Name)
+	response, err := dial(rinfo, []string{c}, options...)
+	audit(caller, m, c, true, err)
+	streamResult(caller, m, c, response, err)
+	return response, err
+}
+
+// ExecuteCheat sends an admin cheat on behalf of caller after EnableCheats
+// with the map's admin password, on one connection. The cheat is not
+// checked against the caller's role, callers choose it from a curated set,
+// see package cheats. It is written to the audit log with the player it
+// targets, the password is not.
+func ExecuteCheat(caller Caller, m string, player string, c string) (string, error) {
+	if _, err := ParseCommand(c); err != nil {
+		auditPlayer(caller, m, player, c, false, err)
+		return "", err
+	}
+	rinfo, err := LoadRconInfo(m)
+	if err != nil {
+		auditPlayer(caller, m, player, c, true, err)
+		return "", err
+	}
+
+	log.Printf("Map: %s\nCheat: %s\nPlayer: %s\nCaller: %s", rinfo.Map, c, player, caller.Name)
+	response, err := dial(rinfo, []string{"EnableCheats " + rinfo.Pass, c})
+	auditPlayer(caller, m, player, c, true, err)
+	streamResult(caller, m, c, response, err)
+	return response, err
+}
+
+// redactedError hides a secret in the message of err, e.g. the password of
+// a failed EnableCheats. Errors are kept for the health of a map, see
+// MapHealth.
+type redactedError struct {
+	err    error
+	secret string
+}
+
+func (e redactedError) Error() string {
+	if e.secret == "" {
+		return e.err.Error()
+	}
+	return strings.ReplaceAll(e.err.Error(), e.secret, "***")
+}
+
+func (e redactedError) Unwrap() error {
+	return e.err
+}
+
+// streamResult sends the result of an executed command to the live
+// subscribers
+func streamResult(caller Caller, m string, c string, response string, err error) {
+	data := map[string]interface{}{"map": m, "command": c, "caller": caller.Name, "response": response}
+	message := fmt.Sprintf("%s ran %q on %s", caller.Name, c, m)
 	if err != nil {
-		log.Printf("Error unmarshaling JSON: %v", err)
+		data["error"] = err.Error()
+		message += ": " + err.Error()
+	}
+	notify.Stream(notify.EventRconCommand, message, data)
+}
+
+// Probe executes a command with the given timeout to check that a map's
+// server responds. Probes run often, so they are not permission checked or
+// audited. They are sent while the map's RCON is marked unavailable and
+// clear the mark once the server answers.
+func Probe(m string, c string, timeout time.Duration) (string, error) {
+	rinfo, err := LoadRconInfo(m)
+	if err != nil {
+		return "", err
+	}
+	started := time.Now()
+	response, err := dialOnce(rinfo, []string{c}, rcon.SetDialTimeout(timeout), rcon.SetDeadline(timeout))
+	recordResult(m, err, time.Since(started))
+	return response, err
+}
+
+// dial executes commands on one connection within the configured timeouts.
+// It fails right away while the map's RCON is marked unavailable, and
+// tries again when the server could not be connected to.
+func dial(rinfo RconInfo, commands []string, options ...rcon.Option) (string, error) {
+	if err := allowed(rinfo.Map); err != nil {
+		return "", err
+	}
+	options = defaultOptions(options)
+	retries := orDefaultCount(currentConfig().Retries, defaultRetries)
+
+	started := time.Now()
+	response, err := dialOnce(rinfo, commands, options...)
+	for attempt := 0; attempt < retries && connectFailed(err) && !errors.Is(err, rcon.ErrAuthFailed); attempt++ {
+		time.Sleep(retryBackoff << attempt)
+		started = time.Now()
+		response, err = dialOnce(rinfo, commands, options...)
+	}
+	recordResult(rinfo.Map, err, time.Since(started))
+	return response, err
+}
+
+// dialOnce is dialPass hiding the password in its errors
+func dialOnce(rinfo RconInfo, commands []string, options ...rcon.Option) (string, error) {
+	response, err := dialPass(rinfo, commands, options...)
+	if err != nil {
+		err = redactedError{err: err, secret: rinfo.Pass}
+	}
+	return response, err
+}
+
+// dialPass executes commands on one connection with the map's password,
+// falling back to the password it replaced while the server still uses
+// that one. It returns the response of the last command.
+func dialPass(rinfo RconInfo, commands []string, options ...rcon.Option) (string, error) {
+	address := rinfo.IP + ":" + rinfo.Port
+	response, err := doRcon(commands, address, rinfo.Pass, options...)
+	if !errors.Is(err, rcon.ErrAuthFailed) {
+		return response, err
+	}
+
+	previousPassesMu.Lock()
+	previous, ok := previousPasses[rinfo.Map]
+	previousPassesMu.Unlock()
+	if !ok {
+		return response, err
+	}
+	response, previousErr := doRcon(commands, address, previous, options...)
+	if errors.Is(previousErr, rcon.ErrAuthFailed) {
+		return response, err
+	}
+	return response, previousErr
+}
+
+// LoadRconInfo returns the RCON connection details of a map
+func LoadRconInfo(m string) (RconInfo, error) {
+	rdata, err := LoadRconInfos()
+	if err != nil {
+		return RconInfo{}, err
 	}
 
 	for _, rinfo := range rdata {
 		if rinfo.Map == m {
-			log.Printf("Map: %s\nCommands: %s", rinfo.Map, cl)
-			ip := rinfo.IP + ":" + rinfo.Port
-			return doRcon(cl, ip, rinfo.Pass)
+			return rinfo, nil
 		}
 	}
-	return ""
+	return RconInfo{}, fmt.Errorf("%w: %s", ErrMapNotConfigured, m)
+}
+
+// LoadRconInfos returns the RCON connection details of every map,
+// ASA_RCON_<MAP>_<FIELD> variables override them, e.g. ASA_RCON_ISLAND_PASS.
+// Passwords of the form secret:<name> are read from the secrets file.
+func LoadRconInfos() ([]RconInfo, error) {
+	var rdata []RconInfo
+	if err := configfile.Load(rconConfigFile, "ASA_RCON", &rdata); err != nil {
+		return nil, fmt.Errorf("failed to load rcon config: %w", err)
+	}
+	for i := range rdata {
+		pass, err := secrets.Resolve(rdata[i].Pass)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rcon password of %s: %w", rdata[i].Map, err)
+		}
+		rdata[i].Pass = pass
+	}
+	return rdata, nil
 }
 
-func doRcon(c string, s string, p string) string {
-	conn, err := rcon.Dial(s, p)
+// secretName is the name a map's password is kept under in the secrets file
+func secretName(m string) string {
+	return "rcon/" + m
+}
+
+// sealPass moves a map's password to the secrets file when it is unlocked
+// and returns the reference to keep in the config instead
+func sealPass(info RconInfo) (RconInfo, error) {
+	if !secrets.Enabled() || info.Pass == "" || strings.HasPrefix(info.Pass, secrets.RefPrefix) {
+		secrets.Register(info.Pass)
+		return info, nil
+	}
+	if err := secrets.Set(secretName(info.Map), info.Pass); err != nil {
+		return info, fmt.Errorf("failed to store rcon password of %s: %w", info.Map, err)
+	}
+	info.Pass = secrets.RefPrefix + secretName(info.Map)
+	return info, nil
+}
+
+// PassOverridden reports whether ASA_RCON_<MAP>_PASS sets the map's
+// password, changes to the config do not apply then
+func PassOverridden(m string) bool {
+	want := "ASA_RCON_" + m + "_PASS"
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.EqualFold(name, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPassword changes the RCON password of a configured map. The old one
+// is still tried until the manager restarts, in case the server has not
+// been restarted with the new one yet.
+func SetPassword(m string, pass string) error {
+	old, err := LoadRconInfo(m)
 	if err != nil {
-		log.Printf("Could not connect: %v", err)
+		return err
 	}
-	defer conn.Close()
 
-	response, err := conn.Execute(c)
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+
+	rdata, err := readRconFile()
 	if err != nil {
-		log.Printf("Error executing: %v", err)
+		return err
+	}
+	found := false
+	for i := range rdata {
+		if rdata[i].Map != m {
+			continue
+		}
+		rdata[i].Pass = pass
+		if rdata[i], err = sealPass(rdata[i]); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrMapNotConfigured, m)
+	}
+	if err := configfile.Save(rconConfigFile, rdata); err != nil {
+		return err
+	}
+
+	previousPassesMu.Lock()
+	previousPasses[m] = old.Pass
+	previousPassesMu.Unlock()
+	return nil
+}
+
+// readRconFile returns the RCON config file as written, without the
+// environment overrides, for changes to it
+func readRconFile() ([]RconInfo, error) {
+	var rdata []RconInfo
+	if err := configfile.Read(rconConfigFile, &rdata); err != nil {
+		return nil, fmt.Errorf("failed to load rcon config: %w", err)
+	}
+	return rdata, nil
+}
+
+// SaveRconInfo adds or replaces the RCON connection details of a map. The
+// password is kept in the secrets file when it is unlocked.
+func SaveRconInfo(info RconInfo) error {
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+
+	info, err := sealPass(info)
+	if err != nil {
+		return err
+	}
+
+	rdata, err := readRconFile()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	replaced := false
+	for i := range rdata {
+		if rdata[i].Map == info.Map {
+			rdata[i] = info
+			replaced = true
+		}
+	}
+	if !replaced {
+		rdata = append(rdata, info)
+	}
+	return configfile.Save(rconConfigFile, rdata)
+}
+
+// RemoveRconInfo removes the RCON connection details of a map
+func RemoveRconInfo(m string) error {
+	configFileMu.Lock()
+	defer configFileMu.Unlock()
+
+	rdata, err := readRconFile()
+	if err != nil {
+		return err
+	}
+	kept := rdata[:0]
+	for _, rinfo := range rdata {
+		if rinfo.Map != m {
+			kept = append(kept, rinfo)
+		}
+	}
+	if len(kept) == len(rdata) {
+		return nil
+	}
+	return configfile.Save(rconConfigFile, kept)
+}
+
+func doRcon(commands []string, s string, p string, options ...rcon.Option) (string, error) {
+	conn, err := rcon.Dial(s, p, options...)
+	if err != nil {
+		return "", &connectError{fmt.Errorf("%w: could not connect to %s: %w", ErrRequestFailed, s, err)}
+	}
+	defer conn.Close()
+
+	var response string
+	for _, c := range commands {
+		if response, err = conn.Execute(c); err != nil {
+			return "", fmt.Errorf("%w: error executing %q: %w", ErrRequestFailed, c, err)
+		}
 	}
 
-	return response
+	return response, nil
 }
 
 func DummyRcon(m string, c string) string {
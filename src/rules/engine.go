@@ -0,0 +1,334 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const (
+	evaluateInterval = time.Minute
+
+	playersTimeout = 10 * time.Second
+	stopTimeout    = 5 * time.Minute
+	restartTimeout = 5 * time.Minute
+	scriptTimeout  = 5 * time.Minute
+	webhookTimeout = 10 * time.Second
+	bytesPerGB     = 1 << 30
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// Start evaluates the rules in the background
+func (e *Engine) Start() {
+	// Events from before the start are not new
+	for _, ms := range e.pm.States() {
+		e.crashes[ms.Map] = ms.Crashes
+	}
+	for _, job := range e.bm.BackupJobs("") {
+		e.seenJobs[job.ID] = true
+	}
+
+	go func() {
+		for {
+			time.Sleep(evaluateInterval)
+			e.evaluate()
+		}
+	}()
+}
+
+func (e *Engine) evaluate() {
+	e.mu.Lock()
+	rules := make([]Rule, 0, len(e.rules))
+	for _, name := range e.namesLocked() {
+		if e.rules[name].Enabled {
+			rules = append(rules, e.rules[name])
+		}
+	}
+	e.mu.Unlock()
+
+	states := e.pm.States()
+	crashed := e.newCrashes(states)
+	failed := e.newBackupFailures()
+	// Measure each map once however many rules look at it
+	players := make(map[string]float64)
+	diskFree := make(map[string]float64)
+
+	for _, rule := range rules {
+		for _, ms := range states {
+			if !rule.applies(ms.Map) {
+				continue
+			}
+			switch rule.Trigger.Type {
+			case TriggerCrash:
+				if crashed[ms.Map] {
+					go e.fire(rule, ms.Map, "the server crashed")
+				}
+			case TriggerBackupFailed:
+				if reason, ok := failed[ms.Map]; ok {
+					go e.fire(rule, ms.Map, "backup failed: "+reason)
+				}
+			case TriggerPlayerCount:
+				if !ms.Ready() {
+					e.clear(rule, ms.Map)
+					continue
+				}
+				count, ok := players[ms.Map]
+				if !ok {
+					list, err := rcon.ListPlayers(ms.Map, playersTimeout)
+					if err != nil {
+						// A server that does not answer has no known
+						// player count, it neither holds nor clears
+						continue
+					}
+					count = float64(len(list))
+					players[ms.Map] = count
+				}
+				e.check(rule, ms.Map, count, fmt.Sprintf("player count is %.0f", count))
+			case TriggerDiskFreeGB:
+				free, ok := diskFree[ms.Map]
+				if !ok {
+					config, _ := e.pm.Config(ms.Map)
+					usage, err := disk.Usage(config.InstallDir())
+					if err != nil {
+						continue
+					}
+					free = float64(usage.Free) / bytesPerGB
+					diskFree[ms.Map] = free
+				}
+				e.check(rule, ms.Map, free, fmt.Sprintf("%.1f GB disk free", free))
+			}
+		}
+	}
+}
+
+// LogAlert fires the enabled log_pattern rules of a map on an alert of a
+// log pattern
+func (e *Engine) LogAlert(pattern string, mapName string, line string) {
+	e.mu.Lock()
+	var rules []Rule
+	for _, name := range e.namesLocked() {
+		rule := e.rules[name]
+		if rule.Enabled && rule.Trigger.Type == TriggerLogPattern && (rule.Trigger.Pattern == "" || rule.Trigger.Pattern == pattern) && rule.applies(mapName) {
+			rules = append(rules, rule)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, rule := range rules {
+		go e.fire(rule, mapName, fmt.Sprintf("log pattern %s matched: %s", pattern, line))
+	}
+}
+
+// newCrashes returns the maps that crashed since the last evaluation
+func (e *Engine) newCrashes(states []processmanager.MapState) map[string]bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	crashed := make(map[string]bool)
+	for _, ms := range states {
+		if ms.Crashes > e.crashes[ms.Map] {
+			crashed[ms.Map] = true
+		}
+		e.crashes[ms.Map] = ms.Crashes
+	}
+	return crashed
+}
+
+// newBackupFailures returns the error of each map's backup that failed
+// since the last evaluation
+func (e *Engine) newBackupFailures() map[string]string {
+	jobs := e.bm.BackupJobs("")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	failed := make(map[string]string)
+	for _, job := range jobs {
+		if job.Status != backup.JobFailed || e.seenJobs[job.ID] {
+			continue
+		}
+		e.seenJobs[job.ID] = true
+		failed[job.Map] = job.Error
+	}
+	return failed
+}
+
+// check fires a condition rule once its condition held for ForMinutes, it
+// fires again only after the condition cleared
+func (e *Engine) check(rule Rule, mapName string, value float64, reason string) {
+	holds, _ := compare(rule.Trigger.Op, value, rule.Trigger.Value)
+	if !holds {
+		e.clear(rule, mapName)
+		return
+	}
+
+	key := rule.Name + "/" + mapName
+	e.mu.Lock()
+	c, ok := e.conditions[key]
+	if !ok {
+		c = &condition{since: time.Now()}
+		e.conditions[key] = c
+	}
+	due := !c.fired && time.Since(c.since) >= time.Duration(rule.Trigger.ForMinutes)*time.Minute
+	if due {
+		c.fired = true
+	}
+	e.mu.Unlock()
+
+	if due {
+		if rule.Trigger.ForMinutes > 0 {
+			reason = fmt.Sprintf("%s for %d minutes", reason, rule.Trigger.ForMinutes)
+		}
+		go e.fire(rule, mapName, reason)
+	}
+}
+
+func (e *Engine) clear(rule Rule, mapName string) {
+	e.mu.Lock()
+	delete(e.conditions, rule.Name+"/"+mapName)
+	e.mu.Unlock()
+}
+
+// fire runs the actions of a rule unless it is cooling down. Actions such
+// as stop can take minutes, so it runs outside the evaluation loop.
+func (e *Engine) fire(rule Rule, mapName string, reason string) {
+	key := rule.Name + "/" + mapName
+	e.mu.Lock()
+	if last, ok := e.lastFired[key]; ok && time.Since(last) < time.Duration(rule.CooldownMinutes)*time.Minute {
+		e.mu.Unlock()
+		return
+	}
+	e.lastFired[key] = time.Now()
+	e.mu.Unlock()
+
+	log.Printf("Rule '%s' fired on map '%s': %s", rule.Name, mapName, reason)
+	firing := Firing{Time: time.Now(), Map: mapName, Reason: reason, DryRun: rule.DryRun || dryrun.Global()}
+	for _, action := range rule.Actions {
+		if firing.DryRun {
+			plan, err := e.plan(action, rule, mapName)
+			if err != nil {
+				firing.Errors = append(firing.Errors, fmt.Sprintf("%s: %v", action.Type, err))
+				continue
+			}
+			plan.Log()
+			firing.Plans = append(firing.Plans, plan)
+			continue
+		}
+		if err := e.run(action, rule, mapName, reason); err != nil {
+			log.Printf("Rule '%s' action %s on map '%s' failed: %v", rule.Name, action.Type, mapName, err)
+			firing.Errors = append(firing.Errors, fmt.Sprintf("%s: %v", action.Type, err))
+		}
+	}
+
+	e.mu.Lock()
+	firings := append(e.firings[rule.Name], firing)
+	if len(firings) > maxFirings {
+		firings = firings[len(firings)-maxFirings:]
+	}
+	e.firings[rule.Name] = firings
+	e.mu.Unlock()
+}
+
+func (e *Engine) run(action Action, rule Rule, mapName string, reason string) error {
+	switch action.Type {
+	case ActionStop:
+		return e.pm.StopAndWait(mapName, stopTimeout)
+	case ActionRestart:
+		return e.pm.RestartProcess(mapName, restartTimeout)
+	case ActionRcon:
+		_, err := rcon.Execute(mapName, action.Command)
+		return err
+	case ActionBackup:
+		_, err := e.bm.QueueBackup(mapName, false)
+		return err
+	case ActionWebhook:
+		return postWebhook(action.URL, rule, mapName, reason)
+	case ActionScript:
+		ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, filepath.Join(ScriptDir, action.Script), action.Args...)
+		cmd.Env = append(os.Environ(), "RULE_NAME="+rule.Name, "RULE_MAP="+mapName, "RULE_REASON="+reason)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%w: %s", err, bytes.TrimSpace(output))
+		}
+		return nil
+	case ActionMacro:
+		if e.RunMacro == nil {
+			return fmt.Errorf("macros are not available")
+		}
+		return e.RunMacro(action.Macro, mapName)
+	}
+	return fmt.Errorf("unknown action type %q", action.Type)
+}
+
+// plan returns what run would do, for a dry run
+func (e *Engine) plan(action Action, rule Rule, mapName string) (dryrun.Plan, error) {
+	plan := dryrun.Plan{Operation: fmt.Sprintf("%s action of rule %s", action.Type, rule.Name), Map: mapName}
+	switch action.Type {
+	case ActionStop:
+		stop, err := e.pm.PlanStopAndWait(mapName, stopTimeout)
+		stop.Operation = plan.Operation
+		return stop, err
+	case ActionRestart:
+		restart, err := e.pm.PlanStopAndWait(mapName, restartTimeout)
+		restart.Operation = plan.Operation
+		restart.Stepf("Start the server again")
+		return restart, err
+	case ActionRcon:
+		plan.Commands = append(plan.Commands, action.Command)
+	case ActionBackup:
+		plan.Stepf("Queue a backup")
+	case ActionWebhook:
+		plan.Stepf("POST the firing to %s", action.URL)
+	case ActionMacro:
+		if e.PlanMacro == nil {
+			return plan, fmt.Errorf("macros are not available")
+		}
+		macro, err := e.PlanMacro(action.Macro, mapName)
+		macro.Operation = plan.Operation
+		return macro, err
+	case ActionScript:
+		plan.Stepf("Run %s", strings.TrimSpace(filepath.Join(ScriptDir, action.Script)+" "+strings.Join(action.Args, " ")))
+	default:
+		return plan, fmt.Errorf("unknown action type %q", action.Type)
+	}
+	return plan, nil
+}
+
+func postWebhook(url string, rule Rule, mapName string, reason string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"rule":    rule.Name,
+		"map":     mapName,
+		"trigger": rule.Trigger.Type,
+		"reason":  reason,
+		"time":    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
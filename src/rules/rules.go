@@ -0,0 +1,404 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/processmanager"
+)
+
+var (
+	ErrRuleNotFound = errors.New("rule not found")
+	ErrRuleExists   = errors.New("rule already exists")
+	ErrInvalidRule  = errors.New("invalid rule")
+)
+
+// Trigger types. PlayerCount and DiskFreeGB are conditions that must hold
+// for ForMinutes, Crash, BackupFailed and LogPattern fire on each event.
+const (
+	TriggerPlayerCount  = "player_count"
+	TriggerDiskFreeGB   = "disk_free_gb"
+	TriggerCrash        = "crash"
+	TriggerBackupFailed = "backup_failed"
+	// TriggerLogPattern fires on the alerts of a log pattern, see package
+	// logalerts
+	TriggerLogPattern = "log_pattern"
+)
+
+// Action types
+const (
+	ActionStop    = "stop"
+	ActionRestart = "restart"
+	ActionRcon    = "rcon"
+	ActionBackup  = "backup"
+	ActionWebhook = "webhook"
+	ActionScript  = "script"
+	ActionMacro   = "macro"
+)
+
+// ScriptDir holds the scripts rules may run
+const ScriptDir = "./scripts"
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Trigger is what makes a rule fire. Op compares the measured value to
+// Value for condition triggers: ==, !=, <, <=, > or >=.
+type Trigger struct {
+	Type       string  `json:"type"`
+	Op         string  `json:"op,omitempty"`
+	Value      float64 `json:"value,omitempty"`
+	ForMinutes int     `json:"for_minutes,omitempty"`
+	// Pattern is the log pattern a log_pattern trigger fires on, empty
+	// fires on every pattern
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// Action is run when a rule fires, on the map that triggered it
+type Action struct {
+	Type string `json:"type"`
+	// Command is the RCON command of an rcon action
+	Command string `json:"command,omitempty"`
+	// URL receives a JSON POST for a webhook action
+	URL string `json:"url,omitempty"`
+	// Script and Args are run for a script action, with the rule and map
+	// in RULE_NAME and RULE_MAP. Script is a file name in ScriptDir, so the
+	// API can only point rules at scripts the operator installed.
+	Script string   `json:"script,omitempty"`
+	Args   []string `json:"args,omitempty"`
+	// Macro is the macro a macro action runs, it is looked up when the
+	// rule fires
+	Macro string `json:"macro,omitempty"`
+}
+
+// Rule runs its actions when its trigger fires on one of its maps. Maps
+// empty means every map. A DryRun rule only records what its actions would
+// do in its firings, to try it out on live servers.
+type Rule struct {
+	Name            string   `json:"name"`
+	Enabled         bool     `json:"enabled"`
+	DryRun          bool     `json:"dry_run,omitempty"`
+	Maps            []string `json:"maps,omitempty"`
+	Trigger         Trigger  `json:"trigger"`
+	Actions         []Action `json:"actions"`
+	CooldownMinutes int      `json:"cooldown_minutes,omitempty"`
+}
+
+type RulesConfig struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Firing records a rule that fired. In a dry run Plans lists what its
+// actions would have done.
+type Firing struct {
+	Time   time.Time     `json:"time"`
+	Map    string        `json:"map"`
+	Reason string        `json:"reason"`
+	Errors []string      `json:"errors,omitempty"`
+	DryRun bool          `json:"dry_run,omitempty"`
+	Plans  []dryrun.Plan `json:"plans,omitempty"`
+}
+
+// RuleStatus is a rule with its recent firings
+type RuleStatus struct {
+	Rule
+	Firings []Firing `json:"firings"`
+}
+
+// maxFirings is how many firings are kept per rule
+const maxFirings = 20
+
+func (t Trigger) validate() error {
+	switch t.Type {
+	case TriggerPlayerCount, TriggerDiskFreeGB:
+		if _, ok := compare(t.Op, 0, 0); !ok {
+			return fmt.Errorf("%w: trigger op must be one of ==, !=, <, <=, >, >=", ErrInvalidRule)
+		}
+		if t.ForMinutes < 0 {
+			return fmt.Errorf("%w: trigger for_minutes must not be negative", ErrInvalidRule)
+		}
+	case TriggerCrash, TriggerBackupFailed, TriggerLogPattern:
+	default:
+		return fmt.Errorf("%w: unknown trigger type %q", ErrInvalidRule, t.Type)
+	}
+	return nil
+}
+
+func (a Action) validate() error {
+	switch a.Type {
+	case ActionStop, ActionRestart, ActionBackup:
+	case ActionRcon:
+		if a.Command == "" {
+			return fmt.Errorf("%w: rcon action needs a command", ErrInvalidRule)
+		}
+	case ActionWebhook:
+		if a.URL == "" {
+			return fmt.Errorf("%w: webhook action needs a url", ErrInvalidRule)
+		}
+	case ActionMacro:
+		if a.Macro == "" {
+			return fmt.Errorf("%w: macro action needs a macro", ErrInvalidRule)
+		}
+	case ActionScript:
+		if a.Script == "" || a.Script != filepath.Base(a.Script) || strings.ContainsAny(a.Script, "/\\") || a.Script == ".." {
+			return fmt.Errorf("%w: script action needs the file name of a script in %s", ErrInvalidRule, ScriptDir)
+		}
+	default:
+		return fmt.Errorf("%w: unknown action type %q", ErrInvalidRule, a.Type)
+	}
+	return nil
+}
+
+// Validate checks a rule before it is saved
+func (r Rule) Validate() error {
+	if !namePattern.MatchString(r.Name) {
+		return fmt.Errorf("%w: name must be 1-64 letters, digits, '-' or '_'", ErrInvalidRule)
+	}
+	if err := r.Trigger.validate(); err != nil {
+		return err
+	}
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("%w: a rule needs at least one action", ErrInvalidRule)
+	}
+	for _, action := range r.Actions {
+		if err := action.validate(); err != nil {
+			return err
+		}
+	}
+	if r.CooldownMinutes < 0 {
+		return fmt.Errorf("%w: cooldown_minutes must not be negative", ErrInvalidRule)
+	}
+	return nil
+}
+
+func (r Rule) applies(mapName string) bool {
+	if len(r.Maps) == 0 {
+		return true
+	}
+	for _, m := range r.Maps {
+		if m == mapName {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies op to a and b, ok is false for an unknown op
+func compare(op string, a, b float64) (result bool, ok bool) {
+	switch op {
+	case "==":
+		return a == b, true
+	case "!=":
+		return a != b, true
+	case "<":
+		return a < b, true
+	case "<=":
+		return a <= b, true
+	case ">":
+		return a > b, true
+	case ">=":
+		return a >= b, true
+	}
+	return false, false
+}
+
+type Engine struct {
+	configFile string
+	rules      map[string]Rule
+	pm         *processmanager.ProcessManager
+	bm         *backup.BackupManager
+
+	// RunMacro runs a macro on a map until it finished, PlanMacro returns
+	// what it would do
+	RunMacro  func(name string, mapName string) error
+	PlanMacro func(name string, mapName string) (dryrun.Plan, error)
+
+	firings map[string][]Firing
+	// conditions tracks since when a condition holds, by rule and map
+	conditions map[string]*condition
+	lastFired  map[string]time.Time
+	crashes    map[string]int
+	seenJobs   map[string]bool
+	mu         sync.Mutex
+}
+
+type condition struct {
+	since time.Time
+	fired bool
+}
+
+func NewEngine(configFile string, pm *processmanager.ProcessManager, bm *backup.BackupManager) (*Engine, error) {
+	config, err := LoadRulesConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{
+		configFile: configFile,
+		rules:      make(map[string]Rule),
+		pm:         pm,
+		bm:         bm,
+		firings:    make(map[string][]Firing),
+		conditions: make(map[string]*condition),
+		lastFired:  make(map[string]time.Time),
+		crashes:    make(map[string]int),
+		seenJobs:   make(map[string]bool),
+	}
+	for _, rule := range config.Rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		if _, exists := e.rules[rule.Name]; exists {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, ErrRuleExists)
+		}
+		e.rules[rule.Name] = rule
+	}
+	return e, nil
+}
+
+// LoadRulesConfig reads the rules, a missing file means none
+func LoadRulesConfig(filename string) (RulesConfig, error) {
+	var config RulesConfig
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, fmt.Errorf("failed to read rules config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse rules config: %w", err)
+	}
+	return config, nil
+}
+
+// saveLocked writes the rules back to the config file
+func (e *Engine) saveLocked() error {
+	config := RulesConfig{Rules: make([]Rule, 0, len(e.rules))}
+	for _, name := range e.namesLocked() {
+		config.Rules = append(config.Rules, e.rules[name])
+	}
+	return configfile.WriteJSON(e.configFile, config)
+}
+
+func (e *Engine) namesLocked() []string {
+	names := make([]string, 0, len(e.rules))
+	for name := range e.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rules returns every rule with its recent firings, sorted by name
+func (e *Engine) Rules() []RuleStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	statuses := make([]RuleStatus, 0, len(e.rules))
+	for _, name := range e.namesLocked() {
+		statuses = append(statuses, e.statusLocked(name))
+	}
+	return statuses
+}
+
+// Get returns a rule with its recent firings
+func (e *Engine) Get(name string) (RuleStatus, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, exists := e.rules[name]; !exists {
+		return RuleStatus{}, fmt.Errorf("%w: %s", ErrRuleNotFound, name)
+	}
+	return e.statusLocked(name), nil
+}
+
+func (e *Engine) statusLocked(name string) RuleStatus {
+	firings := make([]Firing, 0, len(e.firings[name]))
+	for i := len(e.firings[name]) - 1; i >= 0; i-- {
+		firings = append(firings, e.firings[name][i])
+	}
+	return RuleStatus{Rule: e.rules[name], Firings: firings}
+}
+
+// Create adds a rule and persists it
+func (e *Engine) Create(rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, exists := e.rules[rule.Name]; exists {
+		return fmt.Errorf("%w: %s", ErrRuleExists, rule.Name)
+	}
+	e.rules[rule.Name] = rule
+	if err := e.saveLocked(); err != nil {
+		delete(e.rules, rule.Name)
+		return err
+	}
+	return nil
+}
+
+// Update replaces a rule and persists it, its trigger state starts over
+func (e *Engine) Update(rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous, exists := e.rules[rule.Name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, rule.Name)
+	}
+	e.rules[rule.Name] = rule
+	if err := e.saveLocked(); err != nil {
+		e.rules[rule.Name] = previous
+		return err
+	}
+	e.resetLocked(rule.Name)
+	return nil
+}
+
+// Delete removes a rule and persists the change
+func (e *Engine) Delete(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous, exists := e.rules[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, name)
+	}
+	delete(e.rules, name)
+	if err := e.saveLocked(); err != nil {
+		e.rules[name] = previous
+		return err
+	}
+	e.resetLocked(name)
+	delete(e.firings, name)
+	return nil
+}
+
+func (e *Engine) resetLocked(name string) {
+	prefix := name + "/"
+	for key := range e.conditions {
+		if strings.HasPrefix(key, prefix) {
+			delete(e.conditions, key)
+		}
+	}
+	for key := range e.lastFired {
+		if strings.HasPrefix(key, prefix) {
+			delete(e.lastFired, key)
+		}
+	}
+}
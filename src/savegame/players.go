@@ -0,0 +1,85 @@
+package savegame
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of player files
+const (
+	KindProfile = "profile"
+	KindTribe   = "tribe"
+)
+
+const (
+	extProfile = ".arkprofile"
+	extTribe   = ".arktribe"
+)
+
+var ErrInvalidPlayerFile = errors.New("not a player profile or tribe file")
+
+// PlayerFile is a player's .arkprofile or a tribe's .arktribe. OwnerID is
+// the player's EOS ID or the tribe ID, taken from the file name.
+type PlayerFile struct {
+	Name    string    `json:"name"`
+	Kind    string    `json:"kind"`
+	OwnerID string    `json:"owner_id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// PlayerFileKind returns the kind of a player file name, which must be a
+// bare file name without a path
+func PlayerFileKind(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/\\\x00") || name != filepath.Base(name) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidPlayerFile, name)
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case extProfile:
+		return KindProfile, nil
+	case extTribe:
+		return KindTribe, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrInvalidPlayerFile, name)
+}
+
+// ListPlayerFiles returns the profile and tribe files in a map's save
+// directory, kind "" lists both. They are sorted newest first.
+func ListPlayerFiles(dir string, kind string) ([]PlayerFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []PlayerFile{}, nil
+		}
+		return nil, err
+	}
+
+	files := []PlayerFile{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		k, err := PlayerFileKind(entry.Name())
+		if err != nil || (kind != "" && k != kind) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, PlayerFile{
+			Name:    entry.Name(),
+			Kind:    k,
+			OwnerID: strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime.After(files[j].ModTime) })
+	return files, nil
+}
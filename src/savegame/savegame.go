@@ -0,0 +1,148 @@
+package savegame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrNoSave = errors.New("no save file found")
+
+// secondsPerDay is how long an in-game day lasts at the default
+// DayCycleSpeedScale, Day is an estimate on servers that change it
+const secondsPerDay = 3600
+
+// Info describes the save of a map
+type Info struct {
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	SavedAt time.Time `json:"saved_at"`
+
+	// SaveVersion and GameTime come from the world's SaveHeader, GameTime
+	// is the seconds the world has been running
+	SaveVersion int     `json:"save_version,omitempty"`
+	GameTime    float64 `json:"game_time,omitempty"`
+	Day         int     `json:"day,omitempty"`
+	// HeaderError is set when the save could not be parsed, the file
+	// fields are still valid
+	HeaderError string `json:"header_error,omitempty"`
+
+	Tribes       int   `json:"tribes"`
+	TribesSize   int64 `json:"tribes_size"`
+	Profiles     int   `json:"profiles"`
+	ProfilesSize int64 `json:"profiles_size"`
+}
+
+// Read reports the save in dir, the SavedArks directory of a map. The
+// world is the most recently written .ark file, older ones are the
+// server's own rolling backups.
+func Read(dir string) (Info, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Info{}, fmt.Errorf("%w in %s", ErrNoSave, dir)
+		}
+		return Info{}, err
+	}
+
+	var info Info
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".ark":
+			if fi.ModTime().After(info.SavedAt) {
+				info.File = entry.Name()
+				info.Size = fi.Size()
+				info.SavedAt = fi.ModTime()
+			}
+		case ".arktribe":
+			info.Tribes++
+			info.TribesSize += fi.Size()
+		case ".arkprofile":
+			info.Profiles++
+			info.ProfilesSize += fi.Size()
+		}
+	}
+	if info.File == "" {
+		return Info{}, fmt.Errorf("%w in %s", ErrNoSave, dir)
+	}
+
+	if err := info.readHeader(filepath.Join(dir, info.File)); err != nil {
+		info.HeaderError = err.Error()
+	}
+	return info, nil
+}
+
+func (info *Info) readHeader(path string) error {
+	db, err := openDatabase(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	root, err := db.tableRoot("custom")
+	if err != nil {
+		return err
+	}
+	var header []byte
+	err = db.scan(root, func(record []interface{}) bool {
+		if len(record) < 2 {
+			return true
+		}
+		if key, _ := record[0].(string); key != "SaveHeader" {
+			return true
+		}
+		header, _ = record[1].([]byte)
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return errors.New("save has no SaveHeader")
+	}
+	return info.parseHeader(header)
+}
+
+// parseHeader decodes the start of the SaveHeader blob: a little-endian
+// int16 version, two extra uint32 since version 14, the int32 offset of
+// the name table and the game time as a double
+func (info *Info) parseHeader(header []byte) error {
+	r := bytes.NewReader(header)
+	var version int16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return fmt.Errorf("SaveHeader is truncated: %w", err)
+	}
+	skip := 4
+	if version >= 14 {
+		skip += 8
+	}
+	if _, err := r.Seek(int64(skip), io.SeekCurrent); err != nil {
+		return fmt.Errorf("SaveHeader is truncated: %w", err)
+	}
+	var gameTime float64
+	if err := binary.Read(r, binary.LittleEndian, &gameTime); err != nil {
+		return fmt.Errorf("SaveHeader is truncated: %w", err)
+	}
+	if math.IsNaN(gameTime) || math.IsInf(gameTime, 0) || gameTime < 0 {
+		return fmt.Errorf("unsupported SaveHeader version %d", version)
+	}
+
+	info.SaveVersion = int(version)
+	info.GameTime = gameTime
+	info.Day = int(gameTime/secondsPerDay) + 1
+	return nil
+}
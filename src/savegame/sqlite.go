@@ -0,0 +1,331 @@
+package savegame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// ASA stores the world in an SQLite database. This is a minimal read-only
+// reader for the few rows the manager needs, it walks table b-trees and
+// follows overflow pages but does not read a WAL that was not checkpointed.
+
+var errNotSQLite = errors.New("not an SQLite database")
+
+const (
+	sqliteMagic = "SQLite format 3\x00"
+
+	pageLeafTable     = 0x0d
+	pageInteriorTable = 0x05
+
+	// maxPages bounds a b-tree walk so a corrupt file cannot loop forever
+	maxPages = 1 << 20
+)
+
+type database struct {
+	f        *os.File
+	pageSize int
+	usable   int
+}
+
+func openDatabase(path string) (*database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 100)
+	if _, err := io.ReadFull(f, header); err != nil {
+		f.Close()
+		return nil, errNotSQLite
+	}
+	if string(header[:16]) != sqliteMagic {
+		f.Close()
+		return nil, errNotSQLite
+	}
+	pageSize := int(binary.BigEndian.Uint16(header[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%w: invalid page size %d", errNotSQLite, pageSize)
+	}
+	return &database{f: f, pageSize: pageSize, usable: pageSize - int(header[20])}, nil
+}
+
+func (db *database) Close() error {
+	return db.f.Close()
+}
+
+func (db *database) page(n uint32) ([]byte, error) {
+	if n == 0 {
+		return nil, errors.New("invalid page number 0")
+	}
+	buf := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(buf, int64(n-1)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("failed to read page %d: %w", n, err)
+	}
+	return buf, nil
+}
+
+// tableRoot looks up the root page of a table in sqlite_schema
+func (db *database) tableRoot(name string) (uint32, error) {
+	var root uint32
+	err := db.scan(1, func(record []interface{}) bool {
+		if len(record) < 4 {
+			return true
+		}
+		if kind, _ := record[0].(string); kind != "table" {
+			return true
+		}
+		if tbl, _ := record[1].(string); tbl != name {
+			return true
+		}
+		if page, ok := record[3].(int64); ok {
+			root = uint32(page)
+		}
+		return false
+	})
+	if err != nil {
+		return 0, err
+	}
+	if root == 0 {
+		return 0, fmt.Errorf("table %s not found", name)
+	}
+	return root, nil
+}
+
+// scan calls fn with the columns of every row of the table b-tree at root
+// until fn returns false
+func (db *database) scan(root uint32, fn func([]interface{}) bool) error {
+	stack := []uint32{root}
+	visited := 0
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited++; visited > maxPages {
+			return errors.New("b-tree is too large or corrupt")
+		}
+
+		page, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		offset := 0
+		if n == 1 {
+			offset = 100
+		}
+		if offset+8 > len(page) {
+			return fmt.Errorf("page %d is truncated", n)
+		}
+		kind := page[offset]
+		cells := int(binary.BigEndian.Uint16(page[offset+3:]))
+
+		switch kind {
+		case pageInteriorTable:
+			pointers := offset + 12
+			children := make([]uint32, 0, cells+1)
+			for i := 0; i < cells; i++ {
+				cell, err := cellOffset(page, pointers, i)
+				if err != nil || cell+4 > len(page) {
+					return fmt.Errorf("page %d has an invalid cell", n)
+				}
+				children = append(children, binary.BigEndian.Uint32(page[cell:]))
+			}
+			children = append(children, binary.BigEndian.Uint32(page[offset+8:]))
+			// Push in reverse so rows are visited in rowid order
+			for i := len(children) - 1; i >= 0; i-- {
+				stack = append(stack, children[i])
+			}
+		case pageLeafTable:
+			pointers := offset + 8
+			for i := 0; i < cells; i++ {
+				cell, err := cellOffset(page, pointers, i)
+				if err != nil {
+					return fmt.Errorf("page %d has an invalid cell", n)
+				}
+				payload, err := db.leafPayload(page, cell)
+				if err != nil {
+					return fmt.Errorf("page %d: %w", n, err)
+				}
+				record, err := decodeRecord(payload)
+				if err != nil {
+					return fmt.Errorf("page %d: %w", n, err)
+				}
+				if !fn(record) {
+					return nil
+				}
+			}
+		default:
+			return fmt.Errorf("page %d is not a table b-tree page", n)
+		}
+	}
+	return nil
+}
+
+func cellOffset(page []byte, pointers int, i int) (int, error) {
+	at := pointers + 2*i
+	if at+2 > len(page) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	cell := int(binary.BigEndian.Uint16(page[at:]))
+	if cell >= len(page) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return cell, nil
+}
+
+// leafPayload returns the full payload of a leaf table cell, reading its
+// overflow pages if it spills
+func (db *database) leafPayload(page []byte, cell int) ([]byte, error) {
+	size, n := varint(page[cell:])
+	if n == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	cell += n
+	if _, n = varint(page[cell:]); n == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	cell += n
+
+	total := int(size)
+	if total < 0 || total > 1<<30 {
+		return nil, errors.New("invalid payload size")
+	}
+	local := db.localSize(total)
+	if cell+local > len(page) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[cell:cell+local]...)
+	if local == total {
+		return payload, nil
+	}
+
+	if cell+local+4 > len(page) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	next := binary.BigEndian.Uint32(page[cell+local:])
+	for visited := 0; len(payload) < total; visited++ {
+		if next == 0 || visited > maxPages {
+			return nil, errors.New("overflow chain is truncated")
+		}
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(overflow)
+		chunk := min(total-len(payload), db.usable-4)
+		payload = append(payload, overflow[4:4+chunk]...)
+	}
+	return payload, nil
+}
+
+// localSize is how much of a payload is stored on the leaf page itself
+func (db *database) localSize(total int) int {
+	maxLocal := db.usable - 35
+	if total <= maxLocal {
+		return total
+	}
+	minLocal := (db.usable-12)*32/255 - 23
+	k := minLocal + (total-minLocal)%(db.usable-4)
+	if k <= maxLocal {
+		return k
+	}
+	return minLocal
+}
+
+// decodeRecord returns the columns of a record as nil, int64, float64,
+// string or []byte
+func decodeRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := varint(payload)
+	if n == 0 || int(headerSize) > len(payload) || headerSize < uint64(n) {
+		return nil, errors.New("invalid record header")
+	}
+	header := payload[n:headerSize]
+	body := payload[headerSize:]
+
+	var columns []interface{}
+	for len(header) > 0 {
+		serial, n := varint(header)
+		if n == 0 {
+			return nil, errors.New("invalid record header")
+		}
+		header = header[n:]
+
+		size := serialSize(serial)
+		if size > len(body) {
+			return nil, errors.New("record is truncated")
+		}
+		value := body[:size]
+		body = body[size:]
+
+		switch {
+		case serial == 0:
+			columns = append(columns, nil)
+		case serial >= 1 && serial <= 6:
+			columns = append(columns, signed(value))
+		case serial == 7:
+			columns = append(columns, math.Float64frombits(binary.BigEndian.Uint64(value)))
+		case serial == 8:
+			columns = append(columns, int64(0))
+		case serial == 9:
+			columns = append(columns, int64(1))
+		case serial >= 12 && serial%2 == 0:
+			columns = append(columns, bytes.Clone(value))
+		case serial >= 13:
+			columns = append(columns, string(value))
+		default:
+			return nil, fmt.Errorf("unsupported serial type %d", serial)
+		}
+	}
+	return columns, nil
+}
+
+func serialSize(serial uint64) int {
+	switch serial {
+	case 1, 2, 3, 4:
+		return int(serial)
+	case 5:
+		return 6
+	case 6, 7:
+		return 8
+	}
+	if serial >= 12 {
+		return int((serial - 12) / 2)
+	}
+	return 0
+}
+
+// signed decodes a big-endian two's complement integer of 1 to 8 bytes
+func signed(b []byte) int64 {
+	var v int64
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		v = -1
+	}
+	for _, c := range b {
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+// varint decodes an SQLite variable-length integer, n is 0 if b is too short
+func varint(b []byte) (v uint64, n int) {
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
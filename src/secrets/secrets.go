@@ -0,0 +1,271 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"asa_servermanager_api/configfile"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Secrets such as RCON passwords can be kept out of the configs in
+// config/secrets.enc, a JSON object of names to values sealed with NaCl
+// secretbox. Its key is derived with scrypt from the passphrase in
+// ASA_SECRETS_PASSPHRASE, which unlocks the file at startup. Config values
+// of the form secret:<name> are resolved from it.
+//
+// Every secret the manager handles is also redacted from the logs and the
+// API responses.
+
+const (
+	// File is where the sealed secrets are kept
+	File = "config/secrets.enc"
+	// PassphraseEnv names the variable holding the passphrase
+	PassphraseEnv = "ASA_SECRETS_PASSPHRASE"
+	// RefPrefix marks config values that name a secret
+	RefPrefix = "secret:"
+	// Redacted replaces secrets in logs and responses
+	Redacted = "[REDACTED]"
+
+	fileMagic = "ASASEC1\n"
+	saltSize  = 16
+	nonceSize = 24
+
+	// Shorter values are not redacted, they would match unrelated text
+	minRedactLength = 4
+)
+
+var (
+	ErrLocked          = errors.New("secrets file is locked, set " + PassphraseEnv)
+	ErrSecretNotFound  = errors.New("secret not found")
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupt secrets file")
+
+	mu     sync.RWMutex
+	store  map[string]string
+	key    *[32]byte
+	salt   []byte
+	path   string
+	known  = make(map[string]bool)
+	sorted []string
+)
+
+func deriveKey(passphrase string, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	var k [32]byte
+	copy(k[:], derived)
+	return &k, nil
+}
+
+// Unlock opens the secrets file with the passphrase from the environment.
+// Without a passphrase secrets stay disabled, unless the file exists.
+func Unlock(file string) error {
+	passphrase := os.Getenv(PassphraseEnv)
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read secrets: %w", err)
+	}
+	if passphrase == "" {
+		if data != nil {
+			return fmt.Errorf("%s exists but %s is not set", file, PassphraseEnv)
+		}
+		return nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	path = file
+	store = make(map[string]string)
+	if data == nil {
+		salt = make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		key, err = deriveKey(passphrase, salt)
+		return err
+	}
+
+	if !bytes.HasPrefix(data, []byte(fileMagic)) || len(data) < len(fileMagic)+saltSize+nonceSize+secretbox.Overhead {
+		return fmt.Errorf("%s is not a secrets file", file)
+	}
+	data = data[len(fileMagic):]
+	salt = append([]byte(nil), data[:saltSize]...)
+	var nonce [nonceSize]byte
+	copy(nonce[:], data[saltSize:saltSize+nonceSize])
+	if key, err = deriveKey(passphrase, salt); err != nil {
+		return err
+	}
+	plain, ok := secretbox.Open(nil, data[saltSize+nonceSize:], &nonce, key)
+	if !ok {
+		key = nil
+		return ErrWrongPassphrase
+	}
+	if err := json.Unmarshal(plain, &store); err != nil {
+		return fmt.Errorf("failed to decode secrets: %w", err)
+	}
+	for _, value := range store {
+		registerLocked(value)
+	}
+	return nil
+}
+
+// Enabled reports whether the secrets file is unlocked
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return key != nil
+}
+
+// Get returns a secret
+func Get(name string) (string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if key == nil {
+		return "", ErrLocked
+	}
+	value, ok := store[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// Set stores a secret and seals the file again
+func Set(name string, value string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if key == nil {
+		return ErrLocked
+	}
+
+	previous, existed := store[name]
+	store[name] = value
+	if err := sealLocked(); err != nil {
+		if existed {
+			store[name] = previous
+		} else {
+			delete(store, name)
+		}
+		return err
+	}
+	registerLocked(value)
+	return nil
+}
+
+// Names lists the stored secrets, not their values
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(store))
+	for name := range store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sealLocked() error {
+	plain, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	out := append([]byte(fileMagic), salt...)
+	out = append(out, nonce[:]...)
+	out = secretbox.Seal(out, plain, &nonce, key)
+	return configfile.WriteFile(path, out)
+}
+
+// Resolve returns the secret a secret:<name> reference names, other values
+// are returned as they are. Either way the value is redacted from now on.
+func Resolve(value string) (string, error) {
+	if name, ok := strings.CutPrefix(value, RefPrefix); ok {
+		secret, err := Get(name)
+		if err != nil {
+			return "", err
+		}
+		value = secret
+	}
+	Register(value)
+	return value, nil
+}
+
+// Register adds a value to redact
+func Register(value string) {
+	mu.Lock()
+	defer mu.Unlock()
+	registerLocked(value)
+}
+
+func registerLocked(value string) {
+	if len(value) < minRedactLength || known[value] {
+		return
+	}
+	known[value] = true
+	// Responses are JSON, so also match the value as it is escaped there
+	if escaped, err := json.Marshal(value); err == nil {
+		known[string(escaped[1:len(escaped)-1])] = true
+	}
+	sorted = sorted[:0]
+	for v := range known {
+		sorted = append(sorted, v)
+	}
+	// Longest first, so a secret containing another is replaced whole
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+}
+
+// Redact replaces the known secrets in s
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, value := range sorted {
+		if strings.Contains(s, value) {
+			s = strings.ReplaceAll(s, value, Redacted)
+		}
+	}
+	return s
+}
+
+// RedactBytes is Redact for byte slices
+func RedactBytes(b []byte) []byte {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, value := range sorted {
+		if bytes.Contains(b, []byte(value)) {
+			b = bytes.ReplaceAll(b, []byte(value), []byte(Redacted))
+		}
+	}
+	return b
+}
+
+type redactor struct {
+	w io.Writer
+}
+
+// NewRedactor returns a writer that redacts secrets before writing to w,
+// for log output. The log package writes whole lines at once.
+func NewRedactor(w io.Writer) io.Writer {
+	return redactor{w: w}
+}
+
+func (r redactor) Write(p []byte) (int, error) {
+	if _, err := r.w.Write(RedactBytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
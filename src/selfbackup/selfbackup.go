@@ -0,0 +1,362 @@
+// Package selfbackup backs up the manager itself, apart from the backups
+// of the game saves: the state database, the config files and the player
+// lists next to the server executables. Archives are kept in a directory
+// of their own with their own retention and copied to remote targets, so
+// the manager can be recovered when its host is lost.
+package selfbackup
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/backup"
+	"asa_servermanager_api/configfile"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/state"
+	"asa_servermanager_api/whitelist"
+)
+
+const (
+	defaultIntervalMinutes = 24 * 60
+	defaultDir             = "./data/self_backups"
+	defaultKeep            = 7
+
+	configDir     = "config"
+	archivePrefix = "manager_"
+	archiveSuffix = ".zip"
+	timeLayout    = "2006-01-02_15-04-05"
+
+	// banListFile is where ASA keeps the banned players, next to the
+	// server executable like the exclusive join list
+	banListFile = "BanList.txt"
+)
+
+var ErrDisabled = errors.New("self-backups are not enabled")
+
+// Config enables the self-backups, a missing file leaves them off
+type Config struct {
+	Enabled         bool `json:"enabled"`
+	IntervalMinutes int  `json:"interval_minutes"`
+	// Dir keeps the archives, Keep is how many of them
+	Dir  string `json:"dir"`
+	Keep int    `json:"keep"`
+	// RemoteTargets get a copy of every archive and apply their own
+	// retention_days. Give them a prefix of their own, apart from the
+	// game backups.
+	RemoteTargets []backup.StorageConfig `json:"remote_targets,omitempty"`
+}
+
+// Archive is a self-backup
+type Archive struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// Status reports the self-backups and how the last one went
+type Status struct {
+	Enabled         bool      `json:"enabled"`
+	IntervalMinutes int       `json:"interval_minutes,omitempty"`
+	NextRun         time.Time `json:"next_run,omitempty"`
+	LastRun         time.Time `json:"last_run,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+	Archives        []Archive `json:"archives"`
+}
+
+type Manager struct {
+	config Config
+	pm     *processmanager.ProcessManager
+
+	// runMu serializes the runs, mu guards their outcome
+	runMu     sync.Mutex
+	mu        sync.Mutex
+	nextRun   time.Time
+	lastRun   time.Time
+	lastError string
+}
+
+func NewManager(configFile string, pm *processmanager.ProcessManager) (*Manager, error) {
+	var config Config
+	if err := configfile.Read(configFile, &config); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read self-backup config: %w", err)
+	}
+	if config.IntervalMinutes <= 0 {
+		config.IntervalMinutes = defaultIntervalMinutes
+	}
+	if config.Dir == "" {
+		config.Dir = defaultDir
+	}
+	if config.Keep <= 0 {
+		config.Keep = defaultKeep
+	}
+	for _, target := range config.RemoteTargets {
+		if _, err := backup.NewStorage(target); err != nil {
+			return nil, fmt.Errorf("invalid self-backup target: %w", err)
+		}
+	}
+	return &Manager{config: config, pm: pm}, nil
+}
+
+// Start backs the manager up every interval, the first time once an
+// interval has passed since the newest archive
+func (m *Manager) Start() {
+	if !m.config.Enabled {
+		return
+	}
+	interval := time.Duration(m.config.IntervalMinutes) * time.Minute
+	next := time.Now()
+	if archives, err := m.List(); err == nil && len(archives) > 0 && archives[0].Created.Add(interval).After(next) {
+		next = archives[0].Created.Add(interval)
+	}
+	log.Printf("Backing up the manager every %d minutes to %s, keeping %d archives", m.config.IntervalMinutes, m.config.Dir, m.config.Keep)
+
+	go func() {
+		for {
+			m.mu.Lock()
+			m.nextRun = next
+			m.mu.Unlock()
+
+			time.Sleep(time.Until(next))
+			if _, err := m.Run(); err != nil {
+				log.Printf("Self-backup failed: %v", err)
+			}
+			next = time.Now().Add(interval)
+		}
+	}()
+}
+
+// Run backs the manager up now
+func (m *Manager) Run() (Archive, error) {
+	if !m.config.Enabled {
+		return Archive{}, ErrDisabled
+	}
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	archive, err := m.run()
+	m.mu.Lock()
+	m.lastRun = time.Now()
+	m.lastError = ""
+	if err != nil {
+		m.lastError = err.Error()
+	}
+	m.mu.Unlock()
+	if err != nil {
+		notify.Publish(notify.EventSelfBackupFailed, "backup of the manager failed: "+err.Error(), map[string]interface{}{"error": err.Error()})
+	}
+	return archive, err
+}
+
+func (m *Manager) run() (Archive, error) {
+	if err := os.MkdirAll(m.config.Dir, 0700); err != nil {
+		return Archive{}, fmt.Errorf("failed to create %s: %w", m.config.Dir, err)
+	}
+	created := time.Now()
+	name := archivePrefix + created.Format(timeLayout) + archiveSuffix
+	path := filepath.Join(m.config.Dir, name)
+	if err := m.write(path); err != nil {
+		os.Remove(path)
+		return Archive{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Archive{}, err
+	}
+	archive := Archive{Name: name, Size: info.Size(), Created: created}
+	log.Printf("Backed up the manager to %s (%d bytes)", path, archive.Size)
+
+	m.prune()
+
+	var failed []string
+	for _, target := range m.config.RemoteTargets {
+		store, err := backup.NewStorage(target)
+		if err == nil {
+			err = backup.UploadFile(store, target, path, name)
+		}
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		log.Printf("Uploaded %s to %s", name, store)
+		if err := backup.RemoveOldRemoteBackups(store, target, isArchive); err != nil {
+			log.Printf("Failed to clean up old self-backups on %s: %v", store, err)
+		}
+	}
+	if len(failed) > 0 {
+		return archive, fmt.Errorf("archive %s was kept locally, but uploads failed: %s", name, strings.Join(failed, "; "))
+	}
+	return archive, nil
+}
+
+// write creates the archive at path
+func (m *Manager) write(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+	zw := zip.NewWriter(file)
+
+	w, err := zw.Create("state.db")
+	if err != nil {
+		return err
+	}
+	if err := state.Snapshot(w); err != nil {
+		return fmt.Errorf("failed to snapshot the state database: %w", err)
+	}
+
+	files, err := m.files()
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(files) {
+		if err := addFile(zw, name, files[name]); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// files returns the files to back up besides the state, by archive name:
+// the config files and the player lists of every map
+func (m *Manager) files() (map[string]string, error) {
+	files := map[string]string{}
+	entries, err := os.ReadDir(configDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list config files: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasSuffix(entry.Name(), ".tmp") {
+			files[configDir+"/"+entry.Name()] = filepath.Join(configDir, entry.Name())
+		}
+	}
+
+	for _, ms := range m.pm.States() {
+		config, ok := m.pm.Config(ms.Map)
+		if !ok {
+			continue
+		}
+		for _, path := range []string{whitelist.Path(config.Executable), filepath.Join(filepath.Dir(config.Executable), banListFile)} {
+			if _, err := os.Stat(path); err == nil {
+				files["players/"+ms.Map+"/"+filepath.Base(path)] = path
+			}
+		}
+	}
+	return files, nil
+}
+
+func addFile(zw *zip.Writer, name string, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer src.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", path, err)
+	}
+	return nil
+}
+
+// prune deletes the oldest archives beyond Keep
+func (m *Manager) prune() {
+	archives, err := m.List()
+	if err != nil {
+		log.Printf("Failed to list self-backups: %v", err)
+		return
+	}
+	for i := m.config.Keep; i < len(archives); i++ {
+		if err := os.Remove(filepath.Join(m.config.Dir, archives[i].Name)); err != nil {
+			log.Printf("Failed to remove self-backup %s: %v", archives[i].Name, err)
+			continue
+		}
+		log.Printf("Removed self-backup %s", archives[i].Name)
+	}
+}
+
+// List returns the archives, newest first
+func (m *Manager) List() ([]Archive, error) {
+	entries, err := os.ReadDir(m.config.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Archive{}, nil
+		}
+		return nil, err
+	}
+	archives := []Archive{}
+	for _, entry := range entries {
+		created, ok := archiveTime(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		archives = append(archives, Archive{Name: entry.Name(), Size: info.Size(), Created: created})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Created.After(archives[j].Created) })
+	return archives, nil
+}
+
+// archiveTime returns when an archive was created, from its name. It
+// reports false for files that are not self-backups.
+func archiveTime(name string) (time.Time, bool) {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, archivePrefix), archiveSuffix)
+	created, err := time.ParseInLocation(timeLayout, stamp, time.Local)
+	if err != nil || name != archivePrefix+stamp+archiveSuffix {
+		return time.Time{}, false
+	}
+	return created, true
+}
+
+// isArchive reports whether a file is a self-backup, for the retention of
+// remote targets shared with other files
+func isArchive(name string) bool {
+	_, ok := archiveTime(name)
+	return ok
+}
+
+// Status returns the archives, when the next backup runs and how the last
+// one went
+func (m *Manager) Status() (Status, error) {
+	archives, err := m.List()
+	if err != nil {
+		return Status{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := Status{Enabled: m.config.Enabled, LastRun: m.lastRun, LastError: m.lastError, Archives: archives}
+	if m.config.Enabled {
+		status.IntervalMinutes = m.config.IntervalMinutes
+		status.NextRun = m.nextRun
+	}
+	return status, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
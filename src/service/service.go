@@ -0,0 +1,40 @@
+// Package service runs the manager under the platform's service manager:
+// the Windows service control manager or systemd.
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+const (
+	Name        = "asa-servermanager"
+	DisplayName = "ASA Server Manager"
+	Description = "Manages ARK: Survival Ascended servers, backups and RCON"
+)
+
+// ServeFunc starts the API on l, or on the default address when l is nil.
+// It only returns once the API has stopped serving, with the error that
+// stopped it.
+type ServeFunc func(l net.Listener) error
+
+// executable returns the absolute path of the running binary
+func executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate executable: %w", err)
+	}
+	return filepath.Abs(exe)
+}
+
+// WorkDir returns the directory the manager expects to run in, the one
+// holding the binary and its config directory.
+func WorkDir() (string, error) {
+	exe, err := executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(exe), nil
+}
@@ -0,0 +1,195 @@
+//go:build linux
+
+package service
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const unitDir = "/etc/systemd/system"
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+var unitPath = filepath.Join(unitDir, Name+".service")
+
+// Install writes a systemd unit running the manager with --run and enables
+// it. The unit uses Type=notify so systemd waits until the API is listening.
+func Install() error {
+	exe, err := executable()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(unitPath); err == nil {
+		return fmt.Errorf("unit %s already exists", unitPath)
+	}
+
+	unit := fmt.Sprintf(`[Unit]
+Description=%s
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s --run
+WorkingDirectory=%s
+Restart=on-failure
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`, Description, exe, filepath.Dir(exe))
+
+	if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("failed to write unit %s: %w", unitPath, err)
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", Name+".service")
+}
+
+// Uninstall stops and disables the unit and removes it
+func Uninstall() error {
+	if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+		return fmt.Errorf("unit %s is not installed", unitPath)
+	}
+	if err := systemctl("disable", "--now", Name+".service"); err != nil {
+		log.Printf("Failed to disable unit %s: %v", Name, err)
+	}
+	if err := os.Remove(unitPath); err != nil {
+		return fmt.Errorf("failed to remove unit %s: %w", unitPath, err)
+	}
+	return systemctl("daemon-reload")
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %v failed: %w: %s", args, err, out)
+	}
+	return nil
+}
+
+// Run serves the API on a socket passed by systemd if there is one,
+// reports readiness and watchdog pings over NOTIFY_SOCKET and returns on
+// SIGTERM or SIGINT. The caller shuts the API down.
+func Run(serve ServeFunc, ready <-chan struct{}) error {
+	l, err := activationListener()
+	if err != nil {
+		return err
+	}
+	if l != nil {
+		log.Printf("Using socket activated listener on %s", l.Addr())
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- serve(l)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	select {
+	case <-ready:
+	case err := <-stopped:
+		return fmt.Errorf("API stopped before it was ready: %v", err)
+	case sig := <-signals:
+		log.Printf("Received %s during startup, stopping", sig)
+		return nil
+	}
+	notify("READY=1")
+
+	var watchdog <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	for {
+		select {
+		case <-watchdog:
+			notify("WATCHDOG=1")
+		case err := <-stopped:
+			return fmt.Errorf("API stopped serving: %v", err)
+		case sig := <-signals:
+			log.Printf("Received %s, stopping", sig)
+			notify("STOPPING=1")
+			return nil
+		}
+	}
+}
+
+// activationListener returns the first socket passed by systemd socket
+// activation, nil when the process was not socket activated.
+func activationListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		log.Printf("Socket activation passed %d sockets, only the first is used", n)
+	}
+
+	syscall.CloseOnExec(listenFDsStart)
+	f := os.NewFile(uintptr(listenFDsStart), "LISTEN_FD_"+strconv.Itoa(listenFDsStart))
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use socket activated listener: %w", err)
+	}
+	return l, nil
+}
+
+// notify sends a state update to systemd, a no-op outside of a notify unit
+func notify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("Failed to connect to systemd notify socket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("Failed to notify systemd of %s: %v", state, err)
+	}
+}
+
+// watchdogInterval returns the watchdog timeout systemd expects pings
+// within, 0 when the watchdog is disabled.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,21 @@
+//go:build !windows && !linux
+
+package service
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func Install() error {
+	return fmt.Errorf("service installation is not supported on %s", runtime.GOOS)
+}
+
+func Uninstall() error {
+	return fmt.Errorf("service installation is not supported on %s", runtime.GOOS)
+}
+
+// Run serves the API in the foreground
+func Run(serve ServeFunc, ready <-chan struct{}) error {
+	return serve(nil)
+}
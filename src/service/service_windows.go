@@ -0,0 +1,145 @@
+//go:build windows
+
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"asa_servermanager_api/secrets"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Install registers the manager as an automatically started service that
+// is restarted by the service control manager when it fails.
+func Install() error {
+	exe, err := executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(Name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", Name)
+	}
+
+	s, err := m.CreateService(Name, exe, mgr.Config{
+		DisplayName: DisplayName,
+		Description: Description,
+		StartType:   mgr.StartAutomatic,
+	}, "--run")
+	if err != nil {
+		return fmt.Errorf("failed to create service %s: %w", Name, err)
+	}
+	defer s.Close()
+
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}
+	if err := s.SetRecoveryActions(recovery, uint32((24 * time.Hour).Seconds())); err != nil {
+		log.Printf("Failed to set recovery actions of service %s: %v", Name, err)
+	}
+	return nil
+}
+
+// Uninstall stops and removes the service
+func Uninstall() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(Name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", Name, err)
+	}
+	defer s.Close()
+
+	if status, err := s.Query(); err == nil && status.State != svc.Stopped {
+		if _, err := s.Control(svc.Stop); err != nil {
+			log.Printf("Failed to stop service %s: %v", Name, err)
+		}
+	}
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service %s: %w", Name, err)
+	}
+	return nil
+}
+
+// Run serves the API under the service control manager. Started from a
+// console it just serves in the foreground.
+func Run(serve ServeFunc, ready <-chan struct{}) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return fmt.Errorf("failed to detect service session: %w", err)
+	}
+	if !isService {
+		return serve(nil)
+	}
+
+	// Services have no console, keep the log next to the process logs
+	logFile, err := os.OpenFile(filepath.Join("logs", "service.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		log.SetOutput(secrets.NewRedactor(logFile))
+		defer logFile.Close()
+	}
+
+	return svc.Run(Name, &handler{serve: serve, ready: ready})
+}
+
+type handler struct {
+	serve ServeFunc
+	ready <-chan struct{}
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+
+	changes <- svc.Status{State: svc.StartPending}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- h.serve(nil)
+	}()
+
+	select {
+	case <-h.ready:
+	case err := <-stopped:
+		log.Printf("API stopped before the service was ready: %v", err)
+		return true, 1
+	}
+	changes <- svc.Status{State: svc.Running, Accepts: accepted}
+	log.Printf("Service %s running", Name)
+
+	for {
+		select {
+		case err := <-stopped:
+			log.Printf("API stopped, stopping service %s: %v", Name, err)
+			return true, 1
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Printf("Service %s stopping", Name)
+				changes <- svc.Status{State: svc.StopPending}
+				return false, 0
+			}
+		}
+	}
+}
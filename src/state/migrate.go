@@ -0,0 +1,123 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const migratedKey = "legacy_files_migrated"
+
+// migrateLegacyFiles imports the state kept in ./data before the store
+// existed: <map>.pid, <map>.save, <map>_saved.txt and the backup chains in
+// <map>_backup_state.json. PID files were also written to ./data/data by
+// older builds. The imported files are renamed to <name>.migrated.
+func migrateLegacyFiles(store Store, dataDir string) error {
+	var migrated bool
+	if _, err := store.Get(BucketMeta, migratedKey, &migrated); err != nil {
+		return err
+	}
+	if migrated {
+		return nil
+	}
+
+	processes := make(map[string]ProcessState)
+	schedules := make(map[string]BackupSchedule)
+	chains := make(map[string]json.RawMessage)
+	var imported []string
+
+	for _, dir := range []string{dataDir, filepath.Join(dataDir, "data")} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			name := entry.Name()
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				return err
+			}
+			content := strings.TrimSpace(string(data))
+
+			switch {
+			case strings.HasSuffix(name, ".pid"):
+				mapName := strings.TrimSuffix(name, ".pid")
+				var pid int
+				if _, err := fmt.Sscanf(content, "%d", &pid); err != nil {
+					log.Printf("Skipping unreadable PID file %s: %v", name, err)
+					continue
+				}
+				// Only the top-level data dir was authoritative
+				if _, seen := processes[mapName]; !seen {
+					processes[mapName] = ProcessState{PID: pid, Enabled: true, UpdatedAt: time.Now()}
+				}
+			case strings.HasSuffix(name, ".save"):
+				mapName := strings.TrimSuffix(name, ".save")
+				bs := schedules[mapName]
+				bs.Enabled = content == "true"
+				schedules[mapName] = bs
+			case strings.HasSuffix(name, "_saved.txt"):
+				mapName := strings.TrimSuffix(name, "_saved.txt")
+				t, err := time.ParseInLocation("20060102_150405", content, time.Local)
+				if err != nil {
+					log.Printf("Skipping unreadable backup timestamp %s: %v", name, err)
+					continue
+				}
+				bs := schedules[mapName]
+				bs.LastBackup = t
+				schedules[mapName] = bs
+			case strings.HasSuffix(name, "_backup_state.json"):
+				mapName := strings.TrimSuffix(name, "_backup_state.json")
+				if !json.Valid(data) {
+					log.Printf("Skipping unreadable backup chain %s", name)
+					continue
+				}
+				if _, seen := chains[mapName]; !seen {
+					chains[mapName] = json.RawMessage(data)
+				}
+			default:
+				continue
+			}
+			imported = append(imported, filepath.Join(dir, name))
+		}
+	}
+
+	for mapName, ps := range processes {
+		if err := store.Put(BucketProcesses, mapName, ps); err != nil {
+			return err
+		}
+	}
+	for mapName, bs := range schedules {
+		if err := store.Put(BucketBackupSchedules, mapName, bs); err != nil {
+			return err
+		}
+	}
+
+	for mapName, chain := range chains {
+		if err := store.Put(BucketBackupChains, mapName, chain); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Migrated legacy state of %d process(es), %d backup schedule(s) and %d backup chain(s)", len(processes), len(schedules), len(chains))
+	if err := store.Put(BucketMeta, migratedKey, true); err != nil {
+		return err
+	}
+	for _, path := range imported {
+		if err := os.Rename(path, path+".migrated"); err != nil {
+			log.Printf("Failed to rename migrated state file %s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	BucketProcesses       = "processes"
+	BucketBackupSchedules = "backup_schedules"
+	BucketMeta            = "meta"
+	// BucketBackupChains holds the incremental backup chain of each map
+	BucketBackupChains = "backup_chains"
+)
+
+// ProcessState is the persisted state of a map's server process
+type ProcessState struct {
+	PID       int       `json:"pid"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// LastReady is when the server last finished loading its world
+	LastReady time.Time `json:"last_ready,omitempty"`
+}
+
+// BackupSchedule is the persisted state of a map's backup schedule
+type BackupSchedule struct {
+	Enabled    bool      `json:"enabled"`
+	LastBackup time.Time `json:"last_backup"`
+	// Paused schedules keep running but skip their backups
+	Paused bool `json:"paused,omitempty"`
+}
+
+// recordsMu serializes read-modify-write updates of typed records
+var recordsMu sync.Mutex
+
+func Process(mapName string) (ProcessState, error) {
+	var ps ProcessState
+	_, err := Get(BucketProcesses, mapName, &ps)
+	return ps, err
+}
+
+func updateProcess(mapName string, fn func(ps *ProcessState)) error {
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+
+	ps, err := Process(mapName)
+	if err != nil {
+		return err
+	}
+	fn(&ps)
+	ps.UpdatedAt = time.Now()
+	return Put(BucketProcesses, mapName, ps)
+}
+
+// SetProcessPID records the PID of a running process, 0 clears it
+func SetProcessPID(mapName string, pid int) error {
+	return updateProcess(mapName, func(ps *ProcessState) { ps.PID = pid })
+}
+
+// SetProcessEnabled records whether a map should be kept running
+func SetProcessEnabled(mapName string, enabled bool) error {
+	return updateProcess(mapName, func(ps *ProcessState) { ps.Enabled = enabled })
+}
+
+// SetProcessReady records when a map's server finished loading
+func SetProcessReady(mapName string, t time.Time) error {
+	return updateProcess(mapName, func(ps *ProcessState) { ps.LastReady = t })
+}
+
+func Schedule(mapName string) (BackupSchedule, error) {
+	var bs BackupSchedule
+	_, err := Get(BucketBackupSchedules, mapName, &bs)
+	return bs, err
+}
+
+func updateSchedule(mapName string, fn func(bs *BackupSchedule)) error {
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+
+	bs, err := Schedule(mapName)
+	if err != nil {
+		return err
+	}
+	fn(&bs)
+	return Put(BucketBackupSchedules, mapName, bs)
+}
+
+// SetScheduleEnabled records whether a map's backup schedule is active
+func SetScheduleEnabled(mapName string, enabled bool) error {
+	return updateSchedule(mapName, func(bs *BackupSchedule) { bs.Enabled = enabled })
+}
+
+// SetSchedulePaused records whether a map's backup schedule skips its runs
+func SetSchedulePaused(mapName string, paused bool) error {
+	return updateSchedule(mapName, func(bs *BackupSchedule) { bs.Paused = paused })
+}
+
+// SetLastBackup records when the last backup of a map finished
+func SetLastBackup(mapName string, t time.Time) error {
+	return updateSchedule(mapName, func(bs *BackupSchedule) { bs.LastBackup = t })
+}
@@ -0,0 +1,202 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store persists the manager's runtime state. Values are stored as JSON
+// under a key inside a named bucket.
+type Store interface {
+	Get(bucket string, key string, v interface{}) (bool, error)
+	Put(bucket string, key string, v interface{}) error
+	Delete(bucket string, key string) error
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+var (
+	ErrNotOpen     = errors.New("state store is not open")
+	ErrNoSnapshots = errors.New("state store cannot be snapshotted")
+)
+
+var (
+	current Store
+	mu      sync.RWMutex
+)
+
+// Open opens the bbolt database at path, migrates the legacy state files
+// from dataDir on first use and makes it the store used by every package.
+func Open(path string, dataDir string) error {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open state store %s: %w", path, err)
+	}
+	store := &boltStore{db: db}
+
+	if err := migrateLegacyFiles(store, dataDir); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to migrate legacy state files: %w", err)
+	}
+
+	Use(store)
+	return nil
+}
+
+// Use replaces the store used by every package
+func Use(store Store) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = store
+}
+
+// Close closes the current store
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current == nil {
+		return nil
+	}
+	err := current.Close()
+	current = nil
+	return err
+}
+
+func get() (Store, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if current == nil {
+		return nil, ErrNotOpen
+	}
+	return current, nil
+}
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func (s *boltStore) Get(bucket string, key string, v interface{}) (bool, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if value := b.Get([]byte(key)); value != nil {
+			data = append([]byte(nil), value...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+func (s *boltStore) Put(bucket string, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s/%s: %w", bucket, key, err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+func (s *boltStore) Delete(bucket string, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+func (s *boltStore) snapshot(w io.Writer) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// Get reads a value from the current store
+func Get(bucket string, key string, v interface{}) (bool, error) {
+	store, err := get()
+	if err != nil {
+		return false, err
+	}
+	return store.Get(bucket, key, v)
+}
+
+// Put writes a value to the current store
+func Put(bucket string, key string, v interface{}) error {
+	store, err := get()
+	if err != nil {
+		return err
+	}
+	return store.Put(bucket, key, v)
+}
+
+// Delete removes a value from the current store
+func Delete(bucket string, key string) error {
+	store, err := get()
+	if err != nil {
+		return err
+	}
+	return store.Delete(bucket, key)
+}
+
+// ForEach iterates over a bucket of the current store in key order
+func ForEach(bucket string, fn func(key string, value []byte) error) error {
+	store, err := get()
+	if err != nil {
+		return err
+	}
+	return store.ForEach(bucket, fn)
+}
+
+// Snapshot writes a consistent copy of the state database to w while the
+// manager keeps using it
+func Snapshot(w io.Writer) error {
+	store, err := get()
+	if err != nil {
+		return err
+	}
+	snapshotter, ok := store.(interface{ snapshot(io.Writer) error })
+	if !ok {
+		return ErrNoSnapshots
+	}
+	return snapshotter.snapshot(w)
+}
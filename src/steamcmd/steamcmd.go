@@ -0,0 +1,86 @@
+package steamcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// AppID is the Steam app of the ARK: Survival Ascended dedicated server
+const AppID = "2430930"
+
+var (
+	ErrBuildNotFound = errors.New("build id not found")
+
+	buildIDPattern = regexp.MustCompile(`"buildid"\s+"(\d+)"`)
+)
+
+// Update installs or updates the server into dir, steamcmd's output is
+// written to output
+func Update(ctx context.Context, steamcmdPath string, dir string, output io.Writer) error {
+	cmd := exec.CommandContext(ctx, steamcmdPath,
+		"+force_install_dir", dir,
+		"+login", "anonymous",
+		"+app_update", AppID, "validate",
+		"+quit")
+	cmd.Dir = filepath.Dir(steamcmdPath)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("steamcmd failed: %w", err)
+	}
+	return nil
+}
+
+// LatestBuild asks Steam for the build id of the public branch
+func LatestBuild(ctx context.Context, steamcmdPath string) (string, error) {
+	cmd := exec.CommandContext(ctx, steamcmdPath,
+		"+login", "anonymous",
+		"+app_info_update", "1",
+		"+app_info_print", AppID,
+		"+quit")
+	cmd.Dir = filepath.Dir(steamcmdPath)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("steamcmd failed: %w", err)
+	}
+
+	// The public branch is listed under "branches", other branches have
+	// build ids of their own
+	text := string(output)
+	i := strings.Index(text, `"branches"`)
+	if i < 0 {
+		return "", fmt.Errorf("%w: no branches in app info", ErrBuildNotFound)
+	}
+	j := strings.Index(text[i:], `"public"`)
+	if j < 0 {
+		return "", fmt.Errorf("%w: no public branch in app info", ErrBuildNotFound)
+	}
+	match := buildIDPattern.FindStringSubmatch(text[i+j:])
+	if match == nil {
+		return "", fmt.Errorf("%w: in public branch", ErrBuildNotFound)
+	}
+	return match[1], nil
+}
+
+// InstalledBuild reads the build id of the server installed in dir from its
+// app manifest
+func InstalledBuild(dir string) (string, error) {
+	manifest := filepath.Join(dir, "steamapps", "appmanifest_"+AppID+".acf")
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to read app manifest: %w", err)
+	}
+	match := buildIDPattern.FindSubmatch(data)
+	if match == nil {
+		return "", fmt.Errorf("%w: in %s", ErrBuildNotFound, manifest)
+	}
+	return string(match[1]), nil
+}
@@ -0,0 +1,167 @@
+// Package tenants lets one manager host the servers of several
+// communities. A tenant owns a group of maps. Users and API keys of a
+// tenant only see and act on its maps, share its rate limit and have
+// their RCON commands written to its own audit log. Callers without a
+// tenant are limited by their role and map list alone.
+package tenants
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	"asa_servermanager_api/configfile"
+)
+
+var (
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrInvalidConfig = errors.New("invalid tenant config")
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+	namePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+)
+
+// Tenant is a community owning some of the maps
+type Tenant struct {
+	Name string   `json:"name"`
+	Maps []string `json:"maps"`
+	// RateLimit replaces the server's rate limit for the tenant's callers,
+	// who share one bucket
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+	// Quota limits what the tenant's maps may use, nil is no limit
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// RateLimit is the token bucket of a tenant
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// Quota limits the resources of a tenant, a zero value is no limit
+type Quota struct {
+	// BackupBytes caps the size of the backup archives of the tenant's maps
+	BackupBytes int64 `json:"backup_bytes,omitempty"`
+	// Servers caps how many of the tenant's servers are enabled at once
+	Servers int `json:"servers,omitempty"`
+	// BackupIntervalMinutes is the shortest time between two backups of one
+	// of the tenant's maps
+	BackupIntervalMinutes int `json:"backup_interval_minutes,omitempty"`
+}
+
+// Config lists the tenants, a missing file means there are none
+type Config struct {
+	Tenants []Tenant `json:"tenants"`
+}
+
+var (
+	mu      sync.RWMutex
+	tenants = map[string]Tenant{}
+	// owners maps each owned map to its tenant
+	owners = map[string]string{}
+)
+
+// Load reads the tenants from configFile
+func Load(configFile string) error {
+	var config Config
+	if err := configfile.Read(configFile, &config); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read tenant config: %w", err)
+	}
+
+	loaded := make(map[string]Tenant, len(config.Tenants))
+	loadedOwners := make(map[string]string)
+	for _, t := range config.Tenants {
+		if !namePattern.MatchString(t.Name) {
+			return fmt.Errorf("%w: tenant name %q must be 1 to 32 lowercase letters, digits, - or _", ErrInvalidConfig, t.Name)
+		}
+		if _, exists := loaded[t.Name]; exists {
+			return fmt.Errorf("%w: tenant %s is listed twice", ErrInvalidConfig, t.Name)
+		}
+		if t.RateLimit != nil && (t.RateLimit.RequestsPerSecond <= 0 || t.RateLimit.Burst <= 0) {
+			return fmt.Errorf("%w: rate limit of tenant %s needs positive requests_per_second and burst", ErrInvalidConfig, t.Name)
+		}
+		if q := t.Quota; q != nil && (q.BackupBytes < 0 || q.Servers < 0 || q.BackupIntervalMinutes < 0) {
+			return fmt.Errorf("%w: quota of tenant %s must not be negative", ErrInvalidConfig, t.Name)
+		}
+		for _, m := range t.Maps {
+			if owner, owned := loadedOwners[m]; owned {
+				return fmt.Errorf("%w: map %s belongs to tenants %s and %s", ErrInvalidConfig, m, owner, t.Name)
+			}
+			loadedOwners[m] = t.Name
+		}
+		loaded[t.Name] = t
+	}
+
+	mu.Lock()
+	tenants, owners = loaded, loadedOwners
+	mu.Unlock()
+	return nil
+}
+
+// Get returns a tenant by name
+func Get(name string) (Tenant, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	t, ok := tenants[name]
+	if !ok {
+		return Tenant{}, fmt.Errorf("%w: %s", ErrUnknownTenant, name)
+	}
+	return t, nil
+}
+
+// List returns the tenants sorted by name
+func List() []Tenant {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]Tenant, 0, len(tenants))
+	for _, t := range tenants {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Validate checks that a tenant a user or API key is assigned to exists,
+// empty is no tenant
+func Validate(name string) error {
+	if name == "" {
+		return nil
+	}
+	_, err := Get(name)
+	return err
+}
+
+// Owner returns the tenant owning a map, empty if no tenant does
+func Owner(mapName string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return owners[mapName]
+}
+
+// QuotaOf returns the tenant owning a map and its quota, false if the map
+// belongs to no tenant or the tenant has no quota
+func QuotaOf(mapName string) (Tenant, Quota, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	t, owned := tenants[owners[mapName]]
+	if !owned || t.Quota == nil {
+		return Tenant{}, Quota{}, false
+	}
+	return t, *t.Quota, true
+}
+
+// Owns reports whether a tenant owns a map
+func Owns(name string, mapName string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return owners[mapName] == name
+}
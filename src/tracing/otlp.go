@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultServiceName = "asa_servermanager_api"
+
+	// queueSize spans are buffered, more are dropped while the collector
+	// is slow or down
+	queueSize     = 2048
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+type otlpExporter struct {
+	url     string
+	headers map[string]string
+	service string
+	client  *http.Client
+
+	spans   chan otlpSpan
+	flush   chan chan error
+	dropped atomic.Int64
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string `json:"timeUnixNano"`
+	Name         string `json:"name"`
+}
+
+type otlpStatus struct {
+	// Code is 0 unset, 1 ok or 2 error
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func newExporter(config Config) (*otlpExporter, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("tracing endpoint %q must be an http or https url", config.Endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	service := config.ServiceName
+	if service == "" {
+		service = defaultServiceName
+	}
+	return &otlpExporter{
+		url:     u.String(),
+		headers: config.Headers,
+		service: service,
+		client:  &http.Client{Timeout: exportTimeout},
+		spans:   make(chan otlpSpan, queueSize),
+		flush:   make(chan chan error),
+	}, nil
+}
+
+func (e *otlpExporter) queue(span otlpSpan) {
+	select {
+	case e.spans <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// run sends the queued spans in batches until shutdown
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case done := <-e.flush:
+			batch = e.drain(batch)
+			err := e.export(batch)
+			batch = nil
+			done <- err
+			return
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("Failed to export %d span(s): %v", len(batch), err)
+		}
+		batch = nil
+		if dropped := e.dropped.Swap(0); dropped > 0 {
+			log.Printf("Dropped %d span(s), the trace collector is not keeping up", dropped)
+		}
+	}
+}
+
+func (e *otlpExporter) drain(batch []otlpSpan) []otlpSpan {
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+		default:
+			return batch
+		}
+	}
+}
+
+func (e *otlpExporter) shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case e.flush <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *otlpExporter) export(batch []otlpSpan) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{attribute("service.name", e.service)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": defaultServiceName},
+				"spans": batch,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpLocked converts the span to its OTLP JSON form
+func (s *Span) otlpLocked(end time.Time) otlpSpan {
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(end),
+	}
+	if s.parent != ([8]byte{}) {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for key, value := range s.attributes {
+		span.Attributes = append(span.Attributes, attribute(key, value))
+	}
+	for _, ev := range s.events {
+		span.Events = append(span.Events, otlpEvent{TimeUnixNano: unixNano(ev.time), Name: ev.name})
+	}
+	if s.err != "" {
+		span.Status = otlpStatus{Code: 2, Message: s.err}
+	}
+	return span
+}
+
+func attribute(key string, value interface{}) otlpAttribute {
+	var v map[string]interface{}
+	switch value := value.(type) {
+	case bool:
+		v = map[string]interface{}{"boolValue": value}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": value}
+	case []string:
+		values := make([]interface{}, 0, len(value))
+		for _, s := range value {
+			values = append(values, map[string]interface{}{"stringValue": s})
+		}
+		v = map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,221 @@
+// Package tracing records spans of API requests and jobs and exports them
+// to an OpenTelemetry collector over OTLP/HTTP with JSON encoding. Trace
+// context is read from and passed on in W3C traceparent headers, so the
+// spans of a request forwarded to an agent join the control plane's trace.
+// Without an endpoint tracing is off and spans are nil, their methods do
+// nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the role of a span in a trace
+type Kind int
+
+// Kinds as numbered by OTLP
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+const traceparentHeader = "traceparent"
+
+// Config enables trace export
+type Config struct {
+	// Endpoint is the OTLP/HTTP endpoint of a collector, e.g.
+	// http://localhost:4318. Empty disables tracing.
+	Endpoint string `json:"endpoint"`
+	// ServiceName defaults to asa_servermanager_api
+	ServiceName string `json:"service_name,omitempty"`
+	// Headers are sent with every export, e.g. an API key of the
+	// collector
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Span is a timed operation of a trace
+type Span struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	kind    Kind
+
+	mu         sync.Mutex
+	name       string
+	start      time.Time
+	attributes map[string]interface{}
+	events     []event
+	err        string
+	ended      bool
+}
+
+type event struct {
+	time time.Time
+	name string
+}
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+type contextKey struct{}
+
+var (
+	mu       sync.Mutex
+	exporter *otlpExporter
+)
+
+// Setup starts exporting spans to the configured collector
+func Setup(config Config) error {
+	if config.Endpoint == "" {
+		return nil
+	}
+	e, err := newExporter(config)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	exporter = e
+	mu.Unlock()
+	go e.run()
+	log.Printf("Exporting traces to %s", e.url)
+	return nil
+}
+
+// Enabled reports whether spans are recorded
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return exporter != nil
+}
+
+// Shutdown exports the spans still buffered
+func Shutdown(ctx context.Context) error {
+	mu.Lock()
+	e := exporter
+	exporter = nil
+	mu.Unlock()
+	if e == nil {
+		return nil
+	}
+	return e.shutdown(ctx)
+}
+
+// Start begins a span, a child of the span or remote parent in ctx. It
+// returns nil while tracing is off.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+	span := &Span{kind: kind, name: name, start: time.Now(), attributes: make(map[string]interface{})}
+	if parent, ok := ctx.Value(contextKey{}).(spanContext); ok {
+		span.traceID = parent.traceID
+		span.parent = parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, contextKey{}, spanContext{traceID: span.traceID, spanID: span.spanID}), span
+}
+
+// Extract returns ctx with the remote parent of a traceparent header
+func Extract(ctx context.Context, header http.Header) context.Context {
+	parts := strings.Split(header.Get(traceparentHeader), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return ctx
+	}
+	var parent spanContext
+	if n, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil || n != len(parent.traceID) {
+		return ctx
+	}
+	if n, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil || n != len(parent.spanID) {
+		return ctx
+	}
+	if parent.traceID == ([16]byte{}) || parent.spanID == ([8]byte{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, parent)
+}
+
+// Inject sets the traceparent header of an outgoing request to the span
+// in ctx
+func Inject(ctx context.Context, header http.Header) {
+	if parent, ok := ctx.Value(contextKey{}).(spanContext); ok {
+		header.Set(traceparentHeader, fmt.Sprintf("00-%x-%x-01", parent.traceID, parent.spanID))
+	}
+}
+
+// TraceID returns the hex ID of the span's trace
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// SetName renames the span, e.g. once a request is routed
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute records a string, bool, integer or float value
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// AddEvent records something that happened during the span
+func (s *Span) AddEvent(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.events = append(s.events, event{time: time.Now(), name: name})
+	s.mu.Unlock()
+}
+
+// End finishes the span, failed if err is not nil, and queues it for
+// export. Ending a span again does nothing.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	if err != nil {
+		s.err = err.Error()
+	}
+	data := s.otlpLocked(end)
+	s.mu.Unlock()
+
+	mu.Lock()
+	e := exporter
+	mu.Unlock()
+	if e != nil {
+		e.queue(data)
+	}
+}
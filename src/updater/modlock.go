@@ -0,0 +1,312 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"asa_servermanager_api/curseforge"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/state"
+)
+
+const (
+	bucketModLocks = "mod_locks"
+
+	// maxModLocks is how many ready starts are kept per map
+	maxModLocks = 20
+)
+
+var (
+	ErrModsDisabled = errors.New("mod checks are not enabled")
+	ErrNoModLock    = errors.New("no known-good mod set recorded for map")
+)
+
+// ModLock is the mod set a map's server started with and got ready
+type ModLock struct {
+	Started time.Time        `json:"started"`
+	Ready   time.Time        `json:"ready"`
+	Mods    []curseforge.Mod `json:"mods"`
+}
+
+// FlaggedMod is a mod file suspected to break a map. The flag is cleared
+// once the map gets ready with the file.
+type FlaggedMod struct {
+	curseforge.Mod
+	Flagged time.Time `json:"flagged"`
+	Reason  string    `json:"reason"`
+}
+
+// ModLocks are the recorded mod sets of a map, newest first, and its
+// flagged mods
+type ModLocks struct {
+	Map     string       `json:"map"`
+	Locks   []ModLock    `json:"locks"`
+	Flagged []FlaggedMod `json:"flagged"`
+}
+
+// ModRollback is what rolling a map's mods back to its last known-good set
+// does
+type ModRollback struct {
+	Map  string  `json:"map"`
+	Lock ModLock `json:"lock"`
+	// Mods is the mods list of the map after the rollback
+	Mods []int `json:"mods"`
+	// Removed were added since the lock, Restored were removed since
+	Removed  []int `json:"removed"`
+	Restored []int `json:"restored"`
+	// Updated have a newer file than in the lock. ASA downloads the newest
+	// file of each mod, so they cannot be pinned to the file of the lock.
+	Updated []curseforge.Mod `json:"updated"`
+	Flagged []FlaggedMod     `json:"flagged"`
+}
+
+// watchReady records the mod set of each map that gets ready
+func (u *Updater) watchReady() {
+	ready, _ := notify.Subscribe([]string{notify.EventProcessReady}, 16)
+	go func() {
+		for payload := range ready {
+			mapName, _ := payload.Data["map"].(string)
+			if err := u.recordModLock(mapName); err != nil {
+				log.Printf("Failed to record the mods of '%s': %v", mapName, err)
+			}
+		}
+	}()
+}
+
+// recordModLock records the mods a map's server got ready with. A mod's
+// newest file is taken if it was available when the server started, else
+// the file of the previous lock.
+func (u *Updater) recordModLock(mapName string) error {
+	config, ok := u.pm.Config(mapName)
+	ids := config.ModIDs()
+	if !ok || len(ids) == 0 {
+		return nil
+	}
+	var ms processmanager.MapState
+	for _, s := range u.pm.States() {
+		if s.Map == mapName {
+			ms = s
+		}
+	}
+	if !ms.Ready() {
+		return nil
+	}
+	mods, err := u.modClient.Mods(ids)
+	if err != nil {
+		return err
+	}
+
+	u.modLockMu.Lock()
+	defer u.modLockMu.Unlock()
+	locks, err := loadModLocks(mapName)
+	if err != nil {
+		return err
+	}
+	var previous map[int]curseforge.Mod
+	if len(locks.Locks) > 0 {
+		previous = modsByID(locks.Locks[0].Mods)
+	}
+
+	lock := ModLock{Started: ms.Since, Ready: ms.ReadySince, Mods: []curseforge.Mod{}}
+	for _, id := range ids {
+		mod, found := mods[id]
+		if !found {
+			continue
+		}
+		if old, known := previous[id]; known && u.modAvailable(mod).After(ms.Since) {
+			mod = old
+		}
+		lock.Mods = append(lock.Mods, mod)
+	}
+	locks.Locks = append([]ModLock{lock}, locks.Locks...)
+	if len(locks.Locks) > maxModLocks {
+		locks.Locks = locks.Locks[:maxModLocks]
+	}
+
+	// A flagged file the map got ready with works
+	active := modsByID(lock.Mods)
+	flagged := locks.Flagged[:0]
+	for _, f := range locks.Flagged {
+		if mod, found := active[f.ID]; found && mod.FileID == f.FileID {
+			log.Printf("Map '%s' is ready with flagged mod %s (%d) file %s, clearing the flag", mapName, f.Name, f.ID, f.File)
+			continue
+		}
+		flagged = append(flagged, f)
+	}
+	locks.Flagged = flagged
+	return state.Put(bucketModLocks, mapName, locks)
+}
+
+// modAvailable returns since when a mod's newest file is available
+func (u *Updater) modAvailable(mod curseforge.Mod) time.Time {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if seen, ok := u.modFiles[mod.FileID]; ok {
+		return seen
+	}
+	return mod.FileDate
+}
+
+func loadModLocks(mapName string) (ModLocks, error) {
+	locks := ModLocks{Map: mapName}
+	if _, err := state.Get(bucketModLocks, mapName, &locks); err != nil {
+		return ModLocks{}, err
+	}
+	if locks.Locks == nil {
+		locks.Locks = []ModLock{}
+	}
+	if locks.Flagged == nil {
+		locks.Flagged = []FlaggedMod{}
+	}
+	return locks, nil
+}
+
+// ModLocks returns the recorded mod sets and flagged mods of a map
+func (u *Updater) ModLocks(mapName string) (ModLocks, error) {
+	if !u.config.Mods.Enabled {
+		return ModLocks{}, ErrModsDisabled
+	}
+	if !u.pm.HasMap(mapName) {
+		return ModLocks{}, fmt.Errorf("%w: %s", processmanager.ErrMapNotFound, mapName)
+	}
+	return loadModLocks(mapName)
+}
+
+// ActiveMods returns the mod files of the last set a map got ready with,
+// nil when mod checks are off or none was recorded
+func (u *Updater) ActiveMods(mapName string) []curseforge.Mod {
+	if !u.config.Mods.Enabled {
+		return nil
+	}
+	locks, err := loadModLocks(mapName)
+	if err != nil || len(locks.Locks) == 0 {
+		return nil
+	}
+	return locks.Locks[0].Mods
+}
+
+// PlanModRollback returns what rolling a map's mods back to the last set it
+// got ready with would do. With dropUpdated the mods updated since are
+// removed from the list as well.
+func (u *Updater) PlanModRollback(mapName string, dropUpdated bool) (ModRollback, error) {
+	if !u.config.Mods.Enabled {
+		return ModRollback{}, ErrModsDisabled
+	}
+	config, ok := u.pm.Config(mapName)
+	if !ok {
+		return ModRollback{}, fmt.Errorf("%w: %s", processmanager.ErrMapNotFound, mapName)
+	}
+	locks, err := loadModLocks(mapName)
+	if err != nil {
+		return ModRollback{}, err
+	}
+	if len(locks.Locks) == 0 {
+		return ModRollback{}, fmt.Errorf("%w %s", ErrNoModLock, mapName)
+	}
+	lock := locks.Locks[0]
+	current := config.ModIDs()
+	latest, err := u.modClient.Mods(current)
+	if err != nil {
+		return ModRollback{}, err
+	}
+
+	rollback := ModRollback{Map: mapName, Lock: lock, Mods: []int{}, Removed: []int{}, Restored: []int{}, Updated: []curseforge.Mod{}, Flagged: []FlaggedMod{}}
+	now := time.Now()
+	good := modsByID(lock.Mods)
+	inCurrent := make(map[int]bool, len(current))
+	for _, id := range current {
+		inCurrent[id] = true
+		if _, found := good[id]; !found {
+			rollback.Removed = append(rollback.Removed, id)
+			flag := FlaggedMod{Mod: latest[id], Flagged: now, Reason: "added after the last known-good start"}
+			flag.ID = id
+			rollback.Flagged = append(rollback.Flagged, flag)
+		}
+	}
+	for _, mod := range lock.Mods {
+		if !inCurrent[mod.ID] {
+			rollback.Restored = append(rollback.Restored, mod.ID)
+		}
+		if newest, found := latest[mod.ID]; found && inCurrent[mod.ID] && newest.FileID != mod.FileID {
+			rollback.Updated = append(rollback.Updated, newest)
+			rollback.Flagged = append(rollback.Flagged, FlaggedMod{Mod: newest, Flagged: now, Reason: fmt.Sprintf("updated from %s after the last known-good start", mod.File)})
+			if dropUpdated {
+				rollback.Removed = append(rollback.Removed, mod.ID)
+				continue
+			}
+		}
+		rollback.Mods = append(rollback.Mods, mod.ID)
+	}
+	return rollback, nil
+}
+
+// Plan returns the steps of a rollback, for a dry run
+func (r ModRollback) Plan() dryrun.Plan {
+	plan := dryrun.Plan{Operation: "mod rollback", Map: r.Map}
+	plan.Stepf("Set the mods list to %v, the set the server got ready with at %s", r.Mods, r.Lock.Ready.Format(time.RFC3339))
+	for _, f := range r.Flagged {
+		plan.Stepf("Flag mod %d file %s as incompatible: %s", f.ID, f.File, f.Reason)
+	}
+	kept := make(map[int]bool, len(r.Mods))
+	for _, id := range r.Mods {
+		kept[id] = true
+	}
+	for _, mod := range r.Updated {
+		if kept[mod.ID] {
+			plan.Stepf("Keep mod %d at its newest file %s, ASA cannot load older files", mod.ID, mod.File)
+		}
+	}
+	plan.Stepf("The server loads the mods when it starts next")
+	return plan
+}
+
+// RollbackMods sets a map's mods list to the last set it got ready with and
+// flags the mods added or updated since, see PlanModRollback
+func (u *Updater) RollbackMods(mapName string, dropUpdated bool) (ModRollback, error) {
+	rollback, err := u.PlanModRollback(mapName, dropUpdated)
+	if err != nil {
+		return ModRollback{}, err
+	}
+	if err := u.pm.SetMods(mapName, rollback.Mods); err != nil {
+		return ModRollback{}, err
+	}
+
+	u.modLockMu.Lock()
+	defer u.modLockMu.Unlock()
+	locks, err := loadModLocks(mapName)
+	if err != nil {
+		return rollback, err
+	}
+	for _, f := range rollback.Flagged {
+		if !flagged(locks.Flagged, f.Mod) {
+			locks.Flagged = append(locks.Flagged, f)
+		}
+	}
+	if err := state.Put(bucketModLocks, mapName, locks); err != nil {
+		return rollback, err
+	}
+	log.Printf("Rolled the mods of '%s' back to %v, flagged %d mod(s)", mapName, rollback.Mods, len(rollback.Flagged))
+	return rollback, nil
+}
+
+func flagged(flags []FlaggedMod, mod curseforge.Mod) bool {
+	for _, f := range flags {
+		if f.ID == mod.ID && f.FileID == mod.FileID {
+			return true
+		}
+	}
+	return false
+}
+
+func modsByID(mods []curseforge.Mod) map[int]curseforge.Mod {
+	byID := make(map[int]curseforge.Mod, len(mods))
+	for _, mod := range mods {
+		byID[mod.ID] = mod
+	}
+	return byID
+}
@@ -0,0 +1,265 @@
+package updater
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/broadcast"
+	"asa_servermanager_api/curseforge"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+)
+
+// ModsConfig checks the mods of the maps for new files on CurseForge. ASA
+// downloads them when a server starts, so with Restart the maps running
+// an outdated mod are restarted in the maintenance window after their
+// players were warned.
+type ModsConfig struct {
+	Enabled bool `json:"enabled"`
+	// APIKey is a CurseForge API key or a reference to the secrets file
+	APIKey               string `json:"api_key"`
+	BaseURL              string `json:"base_url,omitempty"`
+	CheckIntervalMinutes int    `json:"check_interval_minutes"`
+	Restart              bool   `json:"restart"`
+}
+
+// ModStatus is the state of the mod checks in /status
+type ModStatus struct {
+	LastCheck  time.Time `json:"last_check,omitempty"`
+	CheckError string    `json:"check_error,omitempty"`
+	Maps       []MapMods `json:"maps"`
+}
+
+// MapMods are the mods of a running map. Outdated are those with a file
+// that became available after the server started, it loads them when it
+// restarts.
+type MapMods struct {
+	Map      string           `json:"map"`
+	Mods     []curseforge.Mod `json:"mods"`
+	Outdated []curseforge.Mod `json:"outdated,omitempty"`
+	// Maintenance is set for maps in maintenance, which are not restarted
+	Maintenance bool `json:"maintenance,omitempty"`
+	// RestartAt is when the map is restarted for its outdated mods
+	RestartAt time.Time `json:"restart_at,omitempty"`
+}
+
+// ModRestart is the result of a restart job for updated mods, with the
+// versions the server loads after it
+type ModRestart struct {
+	Mods    []curseforge.Mod `json:"mods"`
+	Updated []curseforge.Mod `json:"updated"`
+}
+
+// startMods checks the mods in the background and restarts the maps with
+// outdated mods inside the maintenance window
+func (u *Updater) startMods() {
+	interval := time.Duration(u.config.Mods.CheckIntervalMinutes) * time.Minute
+	go func() {
+		for {
+			if !maintenance.Global() {
+				if due := u.checkMods(); len(due) > 0 {
+					u.restartForMods(due)
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// checkMods looks up the mods of the running maps and returns the maps to
+// restart now
+func (u *Updater) checkMods() []MapMods {
+	type running struct {
+		ms  processmanager.MapState
+		ids []int
+	}
+	var maps []running
+	var ids []int
+	for _, ms := range u.pm.States() {
+		if !u.watches(ms.Map) || ms.Actual != processmanager.ActualRunning || ms.Desired != processmanager.DesiredEnabled {
+			continue
+		}
+		config, _ := u.pm.Config(ms.Map)
+		if mapIDs := config.ModIDs(); len(mapIDs) > 0 {
+			maps = append(maps, running{ms, mapIDs})
+			ids = append(ids, mapIDs...)
+		}
+	}
+	if u.config.Mods.Restart {
+		u.schedule()
+	}
+
+	var mods map[int]curseforge.Mod
+	var err error
+	if len(ids) > 0 {
+		mods, err = u.modClient.Mods(uniqueIDs(ids))
+	}
+	now := time.Now()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.mods.LastCheck = now
+	if err != nil {
+		log.Printf("Failed to check for mod updates: %v", err)
+		u.mods.CheckError = err.Error()
+		return nil
+	}
+	u.mods.CheckError = ""
+	u.recordModFiles(mods, now)
+
+	status := []MapMods{}
+	var due []MapMods
+	for _, m := range maps {
+		mm := MapMods{Map: m.ms.Map, Mods: []curseforge.Mod{}, Maintenance: maintenance.Active(m.ms.Map)}
+		for _, id := range m.ids {
+			mod, ok := mods[id]
+			if !ok {
+				continue
+			}
+			mm.Mods = append(mm.Mods, mod)
+			if u.modFiles[mod.FileID].After(m.ms.Since) {
+				mm.Outdated = append(mm.Outdated, mod)
+			}
+		}
+		if len(mm.Outdated) > 0 && u.config.Mods.Restart && !mm.Maintenance {
+			mm.RestartAt = u.nextWindow(now)
+			if !u.status.Updating && !u.restartingMods && u.inWindow(now) {
+				due = append(due, mm)
+			}
+		}
+		status = append(status, mm)
+	}
+	u.mods.Maps = status
+	return due
+}
+
+// recordModFiles notes when each mod file was first seen and announces the
+// new ones. The files of the first check are taken as available since
+// their upload, later ones since they were seen, as uploads can take a
+// while to be approved.
+func (u *Updater) recordModFiles(mods map[int]curseforge.Mod, now time.Time) {
+	first := u.modFiles == nil
+	if first {
+		u.modFiles = make(map[int]time.Time)
+	}
+	for _, id := range sortedModIDs(mods) {
+		mod := mods[id]
+		if _, seen := u.modFiles[mod.FileID]; seen {
+			continue
+		}
+		if first {
+			u.modFiles[mod.FileID] = mod.FileDate
+			continue
+		}
+		u.modFiles[mod.FileID] = now
+		message := fmt.Sprintf("mod %s (%d) was updated to %s", mod.Name, mod.ID, mod.File)
+		if u.config.Mods.Restart {
+			message += ", the maps running it are restarted in the maintenance window"
+		}
+		log.Printf("Mod %s (%d) was updated to %s", mod.Name, mod.ID, mod.File)
+		notify.Publish(notify.EventModUpdated, message, map[string]interface{}{"mod_id": mod.ID, "name": mod.Name, "file_id": mod.FileID, "file": mod.File})
+	}
+}
+
+// restartForMods warns the players of maps and restarts them one after
+// another, each as a restart job recording the mod versions
+func (u *Updater) restartForMods(due []MapMods) {
+	u.mu.Lock()
+	if u.restartingMods {
+		u.mu.Unlock()
+		return
+	}
+	u.restartingMods = true
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.restartingMods = false
+		u.mu.Unlock()
+	}()
+
+	if dryrun.Global() {
+		for _, mm := range due {
+			plan := dryrun.Plan{Operation: "restart for updated mods", Map: mm.Map}
+			plan.Stepf("Warn the players for %d minute(s) of the update of %s", u.config.WarningMinutes, modNames(mm.Outdated))
+			plan.Stepf("Restart the map, the server downloads the mods when it starts")
+			plan.Log()
+		}
+		return
+	}
+
+	restartJobs := make([]*jobs.Handle, len(due))
+	maps := make([]string, len(due))
+	var names []string
+	for i, mm := range due {
+		job := jobs.New(jobs.TypeRestart, mm.Map)
+		for _, mod := range mm.Outdated {
+			job.Logf("Mod %s (%d) was updated to %s", mod.Name, mod.ID, mod.File)
+		}
+		job.SetResult(ModRestart{Mods: mm.Mods, Updated: mm.Outdated})
+		job.SetCancel(nil)
+		restartJobs[i] = job
+		maps[i] = mm.Map
+		names = append(names, modNames(mm.Outdated))
+	}
+	log.Printf("Restarting %v for updated mods", maps)
+	u.warn(maps, broadcast.Vars{broadcast.VarReason: "mod update", "mods": strings.Join(names, ", ")})
+
+	timeout := time.Duration(u.config.StopTimeoutSeconds) * time.Second
+	for i, job := range restartJobs {
+		if err := job.Context().Err(); err != nil {
+			job.Finish(err)
+			continue
+		}
+		err := u.pm.RestartForJob(job, maps[i], timeout)
+		job.Finish(err)
+		if err != nil {
+			log.Printf("Restart of '%s' for updated mods failed: %v", maps[i], err)
+		}
+	}
+}
+
+// modStatusLocked returns the state of the mod checks, nil if they are off
+func (u *Updater) modStatusLocked() *ModStatus {
+	if !u.config.Mods.Enabled {
+		return nil
+	}
+	status := u.mods
+	status.Maps = append([]MapMods{}, status.Maps...)
+	return &status
+}
+
+func modNames(mods []curseforge.Mod) string {
+	names := make([]string, len(mods))
+	for i, mod := range mods {
+		names[i] = mod.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	var unique []int
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Ints(unique)
+	return unique
+}
+
+func sortedModIDs(mods map[int]curseforge.Mod) []int {
+	ids := make([]int, 0, len(mods))
+	for id := range mods {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
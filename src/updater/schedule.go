@@ -0,0 +1,129 @@
+package updater
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Bases of a schedule
+const (
+	// BasisFixed opens at the start of the window
+	BasisFixed = "fixed"
+	// BasisPopulation opens at the quietest hour of the window
+	BasisPopulation = "population"
+	// BasisNoHistory opens at the start of an automatic window because no
+	// player counts were recorded for its hours
+	BasisNoHistory = "no_history"
+)
+
+// Schedule is when updates start inside the maintenance window. It is
+// planned ahead and kept while the window is open.
+type Schedule struct {
+	Auto bool `json:"auto"`
+	// Opens is the time of day updates may start at, "HH:MM"
+	Opens string    `json:"opens"`
+	Next  time.Time `json:"next"`
+	// AveragePlayers is the combined average player count of the updated
+	// maps in the chosen hour
+	AveragePlayers *float64  `json:"average_players,omitempty"`
+	Basis          string    `json:"basis"`
+	PlannedAt      time.Time `json:"planned_at"`
+}
+
+func formatClock(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+}
+
+// schedule plans when updates start in the next maintenance window
+func (u *Updater) schedule() {
+	now := time.Now()
+	u.mu.Lock()
+	previous := u.status.Schedule
+	u.mu.Unlock()
+	// An update is not moved once its window is open
+	if previous != nil && between(now, u.start, u.end) {
+		return
+	}
+
+	opens, average, basis := u.start, (*float64)(nil), BasisFixed
+	if u.config.MaintenanceWindow.Auto {
+		opens, average, basis = u.quietest()
+	}
+
+	u.mu.Lock()
+	u.opens = opens
+	schedule := &Schedule{Auto: u.config.MaintenanceWindow.Auto, Opens: formatClock(opens), Next: u.nextWindow(now), AveragePlayers: average, Basis: basis, PlannedAt: now}
+	u.status.Schedule = schedule
+	u.mu.Unlock()
+
+	if schedule.Auto && (previous == nil || previous.Opens != schedule.Opens || previous.Basis != schedule.Basis) {
+		if basis == BasisPopulation {
+			log.Printf("Maintenance window opens at %s, the quietest hour with %.1f players on average", schedule.Opens, *average)
+		} else {
+			log.Printf("Maintenance window opens at %s, no player counts were recorded for its hours", schedule.Opens)
+		}
+	}
+}
+
+// candidates are the times of day updates may start at in the window: its
+// start and each full hour that ends inside it
+func (u *Updater) candidates() []time.Duration {
+	length := u.end - u.start
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+	offsets := []time.Duration{u.start}
+	for offset := u.start.Truncate(time.Hour) + time.Hour; offset+time.Hour <= u.start+length; offset += time.Hour {
+		offsets = append(offsets, offset%(24*time.Hour))
+	}
+	return offsets
+}
+
+// quietest picks the candidate hour with the fewest players summed over
+// the updated maps. Only hours with counts for every map that has any are
+// considered, the earliest wins a tie.
+func (u *Updater) quietest() (time.Duration, *float64, string) {
+	if u.HourlyPlayers == nil {
+		return u.start, nil, BasisNoHistory
+	}
+	span := time.Duration(u.config.MaintenanceWindow.HistoryDays) * 24 * time.Hour
+
+	totals := make(map[int]float64)
+	counts := make(map[int]int)
+	recorded := 0
+	for _, ms := range u.pm.States() {
+		if !u.watches(ms.Map) {
+			continue
+		}
+		hours, err := u.HourlyPlayers(ms.Map, span)
+		if err != nil {
+			log.Printf("Failed to read the player counts of %s for the maintenance window: %v", ms.Map, err)
+			continue
+		}
+		if len(hours) == 0 {
+			continue
+		}
+		recorded++
+		for hour, average := range hours {
+			totals[hour] += average
+			counts[hour]++
+		}
+	}
+
+	best, found := u.start, false
+	var lowest float64
+	for _, offset := range u.candidates() {
+		hour := int(offset / time.Hour)
+		if recorded == 0 || counts[hour] != recorded {
+			continue
+		}
+		if !found || totals[hour] < lowest {
+			best, lowest, found = offset, totals[hour], true
+		}
+	}
+	if !found {
+		return u.start, nil, BasisNoHistory
+	}
+	return best, &lowest, BasisPopulation
+}
@@ -0,0 +1,600 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/broadcast"
+	"asa_servermanager_api/curseforge"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/jobs"
+	"asa_servermanager_api/maintenance"
+	"asa_servermanager_api/maplock"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/secrets"
+	"asa_servermanager_api/steamcmd"
+)
+
+const (
+	defaultCheckIntervalMinutes   = 30
+	defaultWarningMinutes         = 15
+	defaultStopTimeoutSeconds     = 300
+	defaultSteamCMDTimeoutMinutes = 60
+	defaultHistoryDays            = 14
+)
+
+// Window is a daily maintenance window in local time, "HH:MM". An end
+// before the start wraps past midnight. With Auto, updates wait for the
+// hour of the window with the fewest players over the last HistoryDays.
+type Window struct {
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Auto        bool   `json:"auto,omitempty"`
+	HistoryDays int    `json:"history_days,omitempty"`
+}
+
+type UpdateConfig struct {
+	Enabled                bool     `json:"enabled"`
+	SteamCMDPath           string   `json:"steamcmd_path"`
+	CheckIntervalMinutes   int      `json:"check_interval_minutes"`
+	MaintenanceWindow      Window   `json:"maintenance_window"`
+	WarningMinutes         int      `json:"warning_minutes"`
+	StopTimeoutSeconds     int      `json:"stop_timeout_seconds"`
+	SteamCMDTimeoutMinutes int      `json:"steamcmd_timeout_minutes"`
+	Maps                   []string `json:"maps,omitempty"`
+
+	Mods ModsConfig `json:"mods"`
+}
+
+// MapUpdate is the update state of one map
+type MapUpdate struct {
+	Map            string `json:"map"`
+	InstallDir     string `json:"install_dir"`
+	InstalledBuild string `json:"installed_build"`
+	Pending        bool   `json:"pending"`
+	// Maintenance is set for maps in maintenance, which are not updated
+	Maintenance bool   `json:"maintenance,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Result is the outcome of an update run
+type Result struct {
+	Build    string            `json:"build"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Updated  []string          `json:"updated"`
+	Failed   map[string]string `json:"failed,omitempty"`
+	// Cancelled are the maps whose update job was cancelled before it began
+	Cancelled []string `json:"cancelled,omitempty"`
+	// DryRun is set when the update only planned what it would do, in
+	// Plans, because of a global dry run
+	DryRun bool          `json:"dry_run,omitempty"`
+	Plans  []dryrun.Plan `json:"plans,omitempty"`
+}
+
+// Status is reported by /status
+type Status struct {
+	Enabled     bool        `json:"enabled"`
+	LatestBuild string      `json:"latest_build,omitempty"`
+	LastCheck   time.Time   `json:"last_check,omitempty"`
+	CheckError  string      `json:"check_error,omitempty"`
+	Pending     bool        `json:"pending"`
+	NextWindow  time.Time   `json:"next_window,omitempty"`
+	Schedule    *Schedule   `json:"schedule,omitempty"`
+	Updating    bool        `json:"updating"`
+	Maps        []MapUpdate `json:"maps"`
+	LastResult  *Result     `json:"last_result,omitempty"`
+
+	Mods *ModStatus `json:"mods,omitempty"`
+}
+
+type Updater struct {
+	config UpdateConfig
+	pm     *processmanager.ProcessManager
+	start  time.Duration
+	end    time.Duration
+	// opens is when updates may start inside the window, the window start
+	// or its quietest hour
+	opens time.Duration
+
+	// HourlyPlayers returns a map's average player count per hour of the
+	// day over a span, it is needed for an automatic maintenance window
+	HourlyPlayers func(mapName string, span time.Duration) (map[int]float64, error)
+	// Snapshot backs up a map for the update job once its world was saved
+	// and before it stops, while the update holds the map's lock. An error
+	// aborts the update.
+	Snapshot func(job *jobs.Handle, mapName string) error
+
+	status Status
+	// announced is the latest build a notification was sent for
+	announced string
+	mu        sync.Mutex
+
+	modClient *curseforge.Client
+	mods      ModStatus
+	// modFiles is since when each mod file is available, by file ID
+	modFiles       map[int]time.Time
+	restartingMods bool
+	// modLockMu serializes the updates of the mod locks
+	modLockMu sync.Mutex
+}
+
+func NewUpdater(configFile string, pm *processmanager.ProcessManager) (*Updater, error) {
+	config, err := LoadUpdateConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Updater{config: config, pm: pm, status: Status{Enabled: config.Enabled, Maps: []MapUpdate{}}, mods: ModStatus{Maps: []MapMods{}}}
+	if config.Enabled && config.SteamCMDPath == "" {
+		return nil, errors.New("steamcmd_path is required when updates are enabled")
+	}
+	if config.Mods.Enabled {
+		apiKey, err := secrets.Resolve(config.Mods.APIKey)
+		if err != nil {
+			return nil, fmt.Errorf("mods.api_key: %w", err)
+		}
+		if u.modClient, err = curseforge.NewClient(apiKey, config.Mods.BaseURL); err != nil {
+			return nil, fmt.Errorf("mods: %w", err)
+		}
+	}
+	// Mod updates only need the window to restart in
+	if !config.Enabled && !(config.Mods.Enabled && config.Mods.Restart) {
+		return u, nil
+	}
+	if u.start, err = parseClock(config.MaintenanceWindow.Start); err != nil {
+		return nil, fmt.Errorf("maintenance_window.start: %w", err)
+	}
+	if u.end, err = parseClock(config.MaintenanceWindow.End); err != nil {
+		return nil, fmt.Errorf("maintenance_window.end: %w", err)
+	}
+	u.opens = u.start
+	return u, nil
+}
+
+// LoadUpdateConfig reads the update settings, a missing file disables
+// update checks
+func LoadUpdateConfig(filename string) (UpdateConfig, error) {
+	var config UpdateConfig
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return config, fmt.Errorf("failed to read update config: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("failed to parse update config: %w", err)
+		}
+	}
+
+	if config.CheckIntervalMinutes <= 0 {
+		config.CheckIntervalMinutes = defaultCheckIntervalMinutes
+	}
+	if config.WarningMinutes < 0 {
+		config.WarningMinutes = 0
+	} else if config.WarningMinutes == 0 {
+		config.WarningMinutes = defaultWarningMinutes
+	}
+	if config.StopTimeoutSeconds <= 0 {
+		config.StopTimeoutSeconds = defaultStopTimeoutSeconds
+	}
+	if config.Mods.CheckIntervalMinutes <= 0 {
+		config.Mods.CheckIntervalMinutes = config.CheckIntervalMinutes
+	}
+	if config.SteamCMDTimeoutMinutes <= 0 {
+		config.SteamCMDTimeoutMinutes = defaultSteamCMDTimeoutMinutes
+	}
+	if config.MaintenanceWindow.HistoryDays <= 0 {
+		config.MaintenanceWindow.HistoryDays = defaultHistoryDays
+	}
+	return config, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New("must be a time of day such as 04:00")
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// sinceMidnight is the time of day of t
+func sinceMidnight(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.Sub(midnight)
+}
+
+// between reports whether the time of day of t is in [from, to), wrapping
+// past midnight if to is before from
+func between(t time.Time, from, to time.Duration) bool {
+	offset := sinceMidnight(t)
+	if from <= to {
+		return offset >= from && offset < to
+	}
+	return offset >= from || offset < to
+}
+
+// inWindow reports whether updates may run at t, from the planned opening
+// to the end of the maintenance window
+func (u *Updater) inWindow(t time.Time) bool {
+	return between(t, u.opens, u.end)
+}
+
+// nextWindow returns when updates may run next, t itself if they may run
+// now
+func (u *Updater) nextWindow(t time.Time) time.Time {
+	if u.inWindow(t) {
+		return t
+	}
+	midnight := t.Add(-sinceMidnight(t))
+	next := midnight.Add(u.opens)
+	if !next.After(t) {
+		next = midnight.AddDate(0, 0, 1).Add(u.opens)
+	}
+	return next
+}
+
+// Start checks for server and mod updates in the background and applies
+// them inside the maintenance window. Nothing is checked while every map is
+// in maintenance mode.
+func (u *Updater) Start() {
+	if u.config.Mods.Enabled {
+		u.startMods()
+		u.watchReady()
+	}
+	if !u.config.Enabled {
+		return
+	}
+	go func() {
+		for {
+			if maintenance.Global() {
+				time.Sleep(time.Duration(u.config.CheckIntervalMinutes) * time.Minute)
+				continue
+			}
+			u.check()
+
+			u.mu.Lock()
+			due := u.status.Pending && u.inWindow(time.Now())
+			u.mu.Unlock()
+			if due {
+				u.apply()
+			}
+			time.Sleep(time.Duration(u.config.CheckIntervalMinutes) * time.Minute)
+		}
+	}()
+}
+
+func (u *Updater) watches(mapName string) bool {
+	if len(u.config.Maps) == 0 {
+		return true
+	}
+	for _, m := range u.config.Maps {
+		if m == mapName {
+			return true
+		}
+	}
+	return false
+}
+
+// check compares the latest build on Steam with the installed builds
+func (u *Updater) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	latest, err := steamcmd.LatestBuild(ctx, u.config.SteamCMDPath)
+	u.schedule()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status.LastCheck = time.Now()
+	if err != nil {
+		log.Printf("Failed to check for server updates: %v", err)
+		u.status.CheckError = err.Error()
+		return
+	}
+	u.status.CheckError = ""
+	u.status.LatestBuild = latest
+
+	maps := []MapUpdate{}
+	pending := false
+	for _, ms := range u.pm.States() {
+		if !u.watches(ms.Map) {
+			continue
+		}
+		config, _ := u.pm.Config(ms.Map)
+		mu := MapUpdate{Map: ms.Map, InstallDir: config.InstallDir(), Maintenance: maintenance.Active(ms.Map)}
+		installed, err := steamcmd.InstalledBuild(mu.InstallDir)
+		if err != nil {
+			mu.Error = err.Error()
+		} else {
+			mu.InstalledBuild = installed
+			mu.Pending = installed != latest && !mu.Maintenance
+			pending = pending || mu.Pending
+		}
+		maps = append(maps, mu)
+	}
+	u.status.Maps = maps
+	u.status.Pending = pending
+	u.status.NextWindow = time.Time{}
+	if pending {
+		u.status.NextWindow = u.nextWindow(time.Now())
+		if u.announced != latest {
+			u.announced = latest
+			log.Printf("Server build %s is available, updating in the maintenance window at %s", latest, u.status.NextWindow.Format(time.RFC3339))
+			notify.Send(notify.EventUpdateAvailable, fmt.Sprintf("build %s is available, maps are updated at %s", latest, u.status.NextWindow.Format(time.RFC3339)))
+		}
+	}
+}
+
+// apply updates every install dir with a pending map. Maps sharing an
+// install dir are stopped together and only the ones that were enabled are
+// started again.
+func (u *Updater) apply() {
+	u.mu.Lock()
+	if u.status.Updating {
+		u.mu.Unlock()
+		return
+	}
+	u.status.Updating = true
+	result := &Result{Build: u.status.LatestBuild, Started: time.Now(), Updated: []string{}, Failed: make(map[string]string)}
+	dirs := make(map[string][]string)
+	held := make(map[string]bool)
+	for _, mu := range u.status.Maps {
+		if mu.Pending {
+			dirs[mu.InstallDir] = append(dirs[mu.InstallDir], mu.Map)
+		}
+		// Maps in maintenance keep their install dir as it is, also for
+		// the maps sharing it
+		if maintenance.Active(mu.Map) {
+			held[mu.InstallDir] = true
+		}
+	}
+	for dir := range held {
+		delete(dirs, dir)
+	}
+	u.mu.Unlock()
+
+	// Only the running maps that are updated go down
+	var running []string
+	for _, ms := range u.pm.States() {
+		config, _ := u.pm.Config(ms.Map)
+		if ms.Desired == processmanager.DesiredEnabled && contains(dirs[config.InstallDir()])(ms.Map) {
+			running = append(running, ms.Map)
+		}
+	}
+
+	installDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		installDirs = append(installDirs, dir)
+	}
+	sort.Strings(installDirs)
+
+	if dryrun.Global() {
+		result.DryRun = true
+		for _, dir := range installDirs {
+			for _, m := range dirs[dir] {
+				plan := u.plan(m, dir, contains(running)(m), result.Build)
+				plan.Log()
+				result.Plans = append(result.Plans, plan)
+			}
+		}
+		result.Finished = time.Now()
+		u.mu.Lock()
+		u.status.Updating = false
+		u.status.LastResult = result
+		u.mu.Unlock()
+		return
+	}
+
+	// Each install dir is updated as a job that can be cancelled until its
+	// maps are stopped
+	updateJobs := make(map[string]*jobs.Handle, len(installDirs))
+	for _, dir := range installDirs {
+		job := jobs.New(jobs.TypeUpdate, dirs[dir]...)
+		job.Logf("Waiting to update %s to build %s", dir, result.Build)
+		job.SetCancel(nil)
+		updateJobs[dir] = job
+	}
+	u.warn(running, broadcast.Vars{broadcast.VarReason: "update to build " + result.Build, "build": result.Build})
+
+	for _, dir := range installDirs {
+		maps := dirs[dir]
+		job := updateJobs[dir]
+		err := u.update(job, dir, maps, contains(running))
+		job.Finish(err)
+		switch {
+		case errors.Is(err, jobs.ErrCancelled):
+			log.Printf("Update of %s was cancelled", dir)
+			result.Cancelled = append(result.Cancelled, maps...)
+		case err != nil:
+			log.Printf("Update of %s failed: %v", dir, err)
+			for _, m := range maps {
+				result.Failed[m] = err.Error()
+			}
+		default:
+			result.Updated = append(result.Updated, maps...)
+		}
+	}
+	result.Finished = time.Now()
+
+	u.mu.Lock()
+	u.status.Updating = false
+	u.status.LastResult = result
+	u.mu.Unlock()
+
+	if len(result.Failed) > 0 {
+		notify.Send(notify.EventUpdateFailed, fmt.Sprintf("update to build %s failed for %d map(s): %v", result.Build, len(result.Failed), result.Failed))
+	} else if len(result.Updated) > 0 {
+		notify.Send(notify.EventUpdateCompleted, fmt.Sprintf("updated %v to build %s", result.Updated, result.Build))
+	}
+	// Refresh the installed builds
+	u.check()
+}
+
+// warn broadcasts the upcoming restart with vars to the running maps and
+// waits for the warning period to pass
+func (u *Updater) warn(maps []string, extra broadcast.Vars) {
+	if u.config.WarningMinutes == 0 || len(maps) == 0 {
+		return
+	}
+	for remaining := u.config.WarningMinutes; remaining > 0; {
+		vars := broadcast.Minutes(remaining)
+		for key, value := range extra {
+			vars = vars.With(key, value)
+		}
+		for _, m := range maps {
+			if err := broadcast.Send(broadcast.EventUpdate, m, vars); err != nil {
+				log.Printf("Failed to warn map '%s' of the update: %v", m, err)
+			}
+		}
+		// Warn again one minute before the restart
+		wait := remaining - 1
+		if wait == 0 {
+			wait = 1
+		}
+		time.Sleep(time.Duration(wait) * time.Minute)
+		remaining -= wait
+	}
+}
+
+// update stops the maps of an install dir, runs SteamCMD and starts the
+// maps for which wasRunning holds. Running backups and restores of the maps
+// are waited for, and none start until the update is done. Cancelling the
+// job while it waits returns jobs.ErrCancelled.
+func (u *Updater) update(job *jobs.Handle, dir string, maps []string, wasRunning func(string) bool) error {
+	// Lock in a fixed order so two callers cannot wait on each other
+	locked := append([]string(nil), maps...)
+	sort.Strings(locked)
+	for _, m := range locked {
+		release, err := maplock.Acquire(job.Context(), m, maplock.OpUpdate)
+		if err != nil {
+			if job.Context().Err() != nil {
+				return jobs.ErrCancelled
+			}
+			return err
+		}
+		defer release()
+	}
+	if err := job.Begin(); err != nil {
+		return err
+	}
+
+	// Each map is backed up between saving and stopping it, so the backup
+	// holds the world as it was saved
+	timeout := time.Duration(u.config.StopTimeoutSeconds) * time.Second
+	var updateErr error
+	var stopped []string
+	for _, m := range maps {
+		if wasRunning(m) {
+			job.Logf("Saving map %s", m)
+			if _, err := rcon.Execute(m, "saveworld"); err != nil {
+				log.Printf("Failed to save map '%s' before the update: %v", m, err)
+			}
+		}
+		if u.Snapshot != nil {
+			if updateErr = u.Snapshot(job, m); updateErr != nil {
+				break
+			}
+		}
+		if !wasRunning(m) {
+			continue
+		}
+		job.Logf("Stopping map %s", m)
+		if err := u.pm.StopAndWait(m, timeout); err != nil {
+			return fmt.Errorf("failed to stop map %s: %w", m, err)
+		}
+		stopped = append(stopped, m)
+	}
+	if updateErr == nil {
+		updateErr = u.steamcmd(job, dir, maps)
+	}
+
+	// Start the maps again even if the update failed, the old files are
+	// usually still intact
+	for _, m := range stopped {
+		job.Logf("Starting map %s", m)
+		if _, err := u.pm.EnableProcess(m); err != nil {
+			log.Printf("Failed to start map '%s' after the update: %v", m, err)
+		}
+	}
+	return updateErr
+}
+
+// plan returns what updating a map in dir would do, for a dry run
+func (u *Updater) plan(mapName string, dir string, running bool, build string) dryrun.Plan {
+	plan := dryrun.Plan{Operation: "update to build " + build, Map: mapName}
+	if running {
+		if u.config.WarningMinutes > 0 {
+			vars := broadcast.Minutes(u.config.WarningMinutes).With(broadcast.VarReason, "update to build "+build).With("build", build)
+			if command := broadcast.Command(broadcast.EventUpdate, mapName, vars); command != "" {
+				plan.Commands = append(plan.Commands, command)
+			}
+			plan.Stepf("Wait %d minute(s) for the warning, repeating it one minute before the restart", u.config.WarningMinutes)
+		}
+		plan.Commands = append(plan.Commands, "saveworld")
+		stop, err := u.pm.PlanStopAndWait(mapName, time.Duration(u.config.StopTimeoutSeconds)*time.Second)
+		if err != nil {
+			plan.Stepf("Stop the map, which fails: %v", err)
+		}
+		plan.Commands = append(plan.Commands, stop.Commands...)
+		plan.Killed = append(plan.Killed, stop.Killed...)
+	}
+	if u.Snapshot != nil {
+		plan.Stepf("Take a verified snapshot backup of the saves before the map stops")
+	}
+	plan.Stepf("Run SteamCMD to update %s", dir)
+	if running {
+		plan.Stepf("Start the map again")
+	}
+	return plan
+}
+
+// steamcmd updates the server files in dir, its output goes to
+// logs/update_<dir>.log
+func (u *Updater) steamcmd(job *jobs.Handle, dir string, maps []string) error {
+	logFile, err := os.Create(filepath.Join("logs", fmt.Sprintf("update_%s.log", filepath.Base(dir))))
+	if err != nil {
+		return fmt.Errorf("failed to create update log: %w", err)
+	}
+	defer logFile.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(u.config.SteamCMDTimeoutMinutes)*time.Minute)
+	defer cancel()
+	log.Printf("Updating server files in %s for %v", dir, maps)
+	job.Logf("Running SteamCMD, output goes to %s", logFile.Name())
+	if err := steamcmd.Update(ctx, u.config.SteamCMDPath, dir, logFile); err != nil {
+		return fmt.Errorf("%w, see %s", err, logFile.Name())
+	}
+	return nil
+}
+
+func contains(list []string) func(string) bool {
+	return func(value string) bool {
+		for _, v := range list {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Status returns the update state
+func (u *Updater) Status() Status {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	status := u.status
+	status.Maps = append([]MapUpdate{}, u.status.Maps...)
+	status.Mods = u.modStatusLocked()
+	return status
+}
@@ -0,0 +1,94 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"asa_servermanager_api/state"
+)
+
+// Export returns the stored users for a config bundle, by name. Their
+// two-factor secrets and recovery codes stay with this manager, users who
+// had two-factor authentication turned on enroll again after an import.
+func Export() (map[string]json.RawMessage, error) {
+	records, err := loadAll()
+	if err != nil {
+		return nil, err
+	}
+	exported := make(map[string]json.RawMessage, len(records))
+	for _, rec := range records {
+		rec.TwoFactor = false
+		rec.TOTPSecret, rec.TOTPPending, rec.TOTPLastStep, rec.RecoveryCodes = "", "", 0, nil
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode user %s: %w", rec.Name, err)
+		}
+		exported[rec.Name] = data
+	}
+	return exported, nil
+}
+
+// ValidateImport checks the users of a config bundle. Unless there are
+// none, an enabled admin must be among them.
+func ValidateImport(exported map[string]json.RawMessage) error {
+	_, err := decodeImport(exported)
+	return err
+}
+
+func decodeImport(exported map[string]json.RawMessage) ([]record, error) {
+	records := make([]record, 0, len(exported))
+	admin := false
+	for name, data := range exported {
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("%w: user %s: %v", ErrInvalidUser, name, err)
+		}
+		if rec.Name != name || !namePattern.MatchString(name) {
+			return nil, fmt.Errorf("%w: user %q has an invalid name", ErrInvalidUser, name)
+		}
+		if err := validateRole(rec.Role); err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+		if len(rec.PasswordHash) == 0 {
+			return nil, fmt.Errorf("%w: user %s has no password", ErrInvalidUser, name)
+		}
+		rec.TwoFactor = rec.TOTPSecret != ""
+		admin = admin || isEnabledAdmin(rec.User)
+		records = append(records, rec)
+	}
+	if len(records) > 0 && !admin {
+		return nil, fmt.Errorf("%w: no enabled admin", ErrInvalidUser)
+	}
+	return records, nil
+}
+
+// Import replaces every user with those of a config bundle and ends every
+// session
+func Import(exported map[string]json.RawMessage) error {
+	records, err := decodeImport(exported)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	existing, err := loadAll()
+	if err != nil {
+		return err
+	}
+	for _, rec := range existing {
+		if _, kept := exported[rec.Name]; !kept {
+			if err := state.Delete(bucketUsers, rec.Name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rec := range records {
+		if err := state.Put(bucketUsers, rec.Name, rec); err != nil {
+			return err
+		}
+	}
+	deleteSessions(func(Session) bool { return true })
+	return nil
+}
@@ -0,0 +1,116 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"asa_servermanager_api/state"
+)
+
+const (
+	bucketSessions = "sessions"
+
+	// SessionTTL is how long a login lasts
+	SessionTTL = 12 * time.Hour
+)
+
+// ErrInvalidSession is returned for unknown, expired and revoked sessions
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// Session is a login of a user. Only a hash of the token is stored, so the
+// state store cannot be used to take over sessions.
+type Session struct {
+	User    string    `json:"user"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSession starts a session for a user and returns its token
+func NewSession(name string) (string, Session, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", Session{}, err
+	}
+	token := hex.EncodeToString(buf)
+
+	pruneSessions()
+	session := Session{User: name, Created: time.Now(), Expires: time.Now().Add(SessionTTL)}
+	if err := state.Put(bucketSessions, tokenKey(token), session); err != nil {
+		return "", Session{}, err
+	}
+	return token, session, nil
+}
+
+// Lookup returns the user of a session, the user must still exist and be
+// enabled
+func Lookup(token string) (User, error) {
+	var session Session
+	found, err := state.Get(bucketSessions, tokenKey(token), &session)
+	if err != nil {
+		return User{}, err
+	}
+	if !found {
+		return User{}, ErrInvalidSession
+	}
+	if time.Now().After(session.Expires) {
+		EndSession(token)
+		return User{}, ErrInvalidSession
+	}
+
+	user, err := Get(session.User)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return User{}, ErrInvalidSession
+		}
+		return User{}, err
+	}
+	if user.Disabled {
+		return User{}, ErrInvalidSession
+	}
+	return user, nil
+}
+
+// EndSession logs a session out
+func EndSession(token string) error {
+	return state.Delete(bucketSessions, tokenKey(token))
+}
+
+// deleteSessions removes the sessions matching fn
+func deleteSessions(fn func(Session) bool) {
+	var keys []string
+	err := state.ForEach(bucketSessions, func(key string, value []byte) error {
+		var session Session
+		if err := json.Unmarshal(value, &session); err != nil || fn(session) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read sessions: %v", err)
+		return
+	}
+	for _, key := range keys {
+		if err := state.Delete(bucketSessions, key); err != nil {
+			log.Printf("Failed to remove session: %v", err)
+		}
+	}
+}
+
+func revokeSessions(name string) {
+	deleteSessions(func(s Session) bool { return s.User == name })
+}
+
+func pruneSessions() {
+	now := time.Now()
+	deleteSessions(func(s Session) bool { return now.After(s.Expires) })
+}
@@ -0,0 +1,274 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"asa_servermanager_api/state"
+)
+
+// Two-factor authentication uses TOTP (RFC 6238) with the parameters every
+// authenticator app supports: SHA-1, 6 digits and 30 second steps.
+
+const (
+	totpIssuer = "ASA Server Manager"
+	totpDigits = 6
+	totpModulo = 1000000
+	totpPeriod = 30
+	// totpSkew is how many steps a code may be off, for clock drift
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	bucketSettings   = "auth_settings"
+	keyTwoFactorRole = "two_factor_roles"
+)
+
+var (
+	ErrInvalidCode     = errors.New("invalid two-factor code")
+	ErrNotEnrolling    = errors.New("no two-factor enrollment in progress, start one first")
+	ErrAlreadyEnrolled = errors.New("two-factor authentication is already enabled")
+	ErrNotEnrolled     = errors.New("two-factor authentication is not enabled")
+
+	base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// Enrollment is a pending TOTP secret to add to an authenticator app
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+func totpAt(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulo)
+}
+
+// matchTOTP returns the step a code is valid for, checking the steps
+// around now and skipping those up to lastStep
+func matchTOTP(secret string, code string, lastStep int64) (int64, bool) {
+	key, err := base32NoPad.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpAt(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns fresh codes and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 6)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32NoPad.EncodeToString(buf))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code. The
+// record must be saved afterwards.
+func (rec *record) verifySecondFactor(code string) bool {
+	code = strings.TrimSpace(code)
+	if step, ok := matchTOTP(rec.TOTPSecret, code, rec.TOTPLastStep); ok {
+		rec.TOTPLastStep = step
+		return true
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, stored := range rec.RecoveryCodes {
+		if hmac.Equal([]byte(stored), []byte(hash)) {
+			rec.RecoveryCodes = append(rec.RecoveryCodes[:i], rec.RecoveryCodes[i+1:]...)
+			log.Printf("User %s used a recovery code, %d left", rec.Name, len(rec.RecoveryCodes))
+			return true
+		}
+	}
+	return false
+}
+
+func (rec *record) resetTwoFactor() {
+	rec.TwoFactor = false
+	rec.TOTPSecret = ""
+	rec.TOTPPending = ""
+	rec.TOTPLastStep = 0
+	rec.RecoveryCodes = nil
+}
+
+// BeginEnrollment creates a new TOTP secret for a user. It only takes
+// effect once ConfirmEnrollment sees a code generated from it.
+func BeginEnrollment(name string) (Enrollment, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rec, err := load(name)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	if rec.TwoFactor {
+		return Enrollment{}, ErrAlreadyEnrolled
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return Enrollment{}, err
+	}
+	rec.TOTPPending = base32NoPad.EncodeToString(key)
+	if err := state.Put(bucketUsers, name, rec); err != nil {
+		return Enrollment{}, err
+	}
+
+	query := url.Values{}
+	query.Set("secret", rec.TOTPPending)
+	query.Set("issuer", totpIssuer)
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + name,
+		RawQuery: query.Encode(),
+	}
+	return Enrollment{Secret: rec.TOTPPending, URI: uri.String()}, nil
+}
+
+// ConfirmEnrollment enables two-factor authentication if code matches the
+// pending secret and returns the recovery codes, they are only shown once
+func ConfirmEnrollment(name string, code string) ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rec, err := load(name)
+	if err != nil {
+		return nil, err
+	}
+	if rec.TwoFactor {
+		return nil, ErrAlreadyEnrolled
+	}
+	if rec.TOTPPending == "" {
+		return nil, ErrNotEnrolling
+	}
+	step, ok := matchTOTP(rec.TOTPPending, strings.TrimSpace(code), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	rec.TwoFactor = true
+	rec.TOTPSecret = rec.TOTPPending
+	rec.TOTPPending = ""
+	rec.TOTPLastStep = step
+	rec.RecoveryCodes = hashes
+	if err := state.Put(bucketUsers, name, rec); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes after checking
+// a current TOTP code
+func RegenerateRecoveryCodes(name string, code string) ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rec, err := load(name)
+	if err != nil {
+		return nil, err
+	}
+	if !rec.TwoFactor {
+		return nil, ErrNotEnrolled
+	}
+	step, ok := matchTOTP(rec.TOTPSecret, strings.TrimSpace(code), rec.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	rec.TOTPLastStep = step
+	rec.RecoveryCodes = hashes
+	if err := state.Put(bucketUsers, name, rec); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// TwoFactorRoles returns the roles that must use two-factor authentication
+func TwoFactorRoles() ([]string, error) {
+	roles := []string{}
+	if _, err := state.Get(bucketSettings, keyTwoFactorRole, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SetTwoFactorRoles sets the roles that must use two-factor
+// authentication. Their users without it can only enroll until they do.
+func SetTwoFactorRoles(roles []string) error {
+	for _, role := range roles {
+		if err := validateRole(role); err != nil {
+			return err
+		}
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	return state.Put(bucketSettings, keyTwoFactorRole, roles)
+}
+
+// NeedsEnrollment reports whether a user's role requires two-factor
+// authentication the user has not enabled yet
+func NeedsEnrollment(user User) (bool, error) {
+	if user.TwoFactor {
+		return false, nil
+	}
+	roles, err := TwoFactorRoles()
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if role == user.Role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,356 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/state"
+	"asa_servermanager_api/tenants"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users are kept in the state store next to the rest of the manager's
+// state. Their role doubles as the RCON role, see config/rcon_permissions.json.
+
+const (
+	bucketUsers = "users"
+
+	minPasswordLength = 8
+)
+
+// Roles of a user, in increasing order of privilege
+const (
+	RoleViewer    = "viewer"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleModerator: 2, RoleAdmin: 3}
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrInvalidUser        = errors.New("invalid user")
+	ErrInvalidCredentials = errors.New("invalid name, password or code")
+	ErrTwoFactorRequired  = errors.New("two-factor code required")
+	ErrLastAdmin          = errors.New("the last enabled admin cannot be removed, disabled or demoted")
+
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+	// mu serializes changes to users, so the last admin check holds
+	mu sync.Mutex
+)
+
+// User is an account that can log in to the dashboard and API. Maps limits
+// the maps the user may act on, empty means every map. A user of a tenant
+// is further limited to the tenant's maps.
+type User struct {
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Maps      []string  `json:"maps,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Disabled  bool      `json:"disabled"`
+	TwoFactor bool      `json:"two_factor"`
+	Created   time.Time `json:"created"`
+	LastLogin time.Time `json:"last_login,omitempty"`
+}
+
+// record is a user as stored, the password hash never leaves the package
+type record struct {
+	User
+	PasswordHash []byte `json:"password_hash"`
+
+	TOTPSecret  string `json:"totp_secret,omitempty"`
+	TOTPPending string `json:"totp_pending,omitempty"`
+	// TOTPLastStep is the time step of the last accepted code, so a code
+	// cannot be used twice
+	TOTPLastStep int64 `json:"totp_last_step,omitempty"`
+	// RecoveryCodes are SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// Changes to a user, nil fields are kept
+type Changes struct {
+	Role     *string   `json:"role,omitempty"`
+	Maps     *[]string `json:"maps,omitempty"`
+	Tenant   *string   `json:"tenant,omitempty"`
+	Disabled *bool     `json:"disabled,omitempty"`
+	Password *string   `json:"password,omitempty"`
+	// ResetTwoFactor turns two-factor authentication off, e.g. for a user
+	// who lost both the authenticator and the recovery codes
+	ResetTwoFactor bool `json:"reset_two_factor,omitempty"`
+}
+
+// HasRole reports whether role grants at least the privileges of min.
+// Roles other than the user roles, e.g. custom API key roles, grant none.
+func HasRole(role string, min string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[min]
+}
+
+// CanAccess reports whether the user may act on a map
+func (u User) CanAccess(mapName string) bool {
+	if u.Tenant != "" && !tenants.Owns(u.Tenant, mapName) {
+		return false
+	}
+	if len(u.Maps) == 0 {
+		return true
+	}
+	for _, m := range u.Maps {
+		if m == mapName {
+			return true
+		}
+	}
+	return false
+}
+
+func validateRole(role string) error {
+	if _, ok := roleRanks[role]; !ok {
+		return fmt.Errorf("%w: role must be %s, %s or %s", ErrInvalidUser, RoleViewer, RoleModerator, RoleAdmin)
+	}
+	return nil
+}
+
+func hashPassword(password string) ([]byte, error) {
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at least %d characters", ErrInvalidUser, minPasswordLength)
+	}
+	if len(password) > 72 {
+		return nil, fmt.Errorf("%w: password must be at most 72 bytes", ErrInvalidUser)
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func load(name string) (record, error) {
+	var rec record
+	found, err := state.Get(bucketUsers, name, &rec)
+	if err != nil {
+		return record{}, err
+	}
+	if !found {
+		return record{}, fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	return rec, nil
+}
+
+func loadAll() ([]record, error) {
+	var records []record
+	err := state.ForEach(bucketUsers, func(key string, value []byte) error {
+		var rec record
+		if err := json.Unmarshal(value, &rec); err != nil {
+			return fmt.Errorf("failed to decode user %s: %w", key, err)
+		}
+		records = append(records, rec)
+		return nil
+	})
+	return records, err
+}
+
+// List returns every user sorted by name
+func List() ([]User, error) {
+	records, err := loadAll()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(records))
+	for _, rec := range records {
+		users = append(users, rec.User)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+// Exist reports whether any user account has been created
+func Exist() (bool, error) {
+	found := errors.New("found")
+	err := state.ForEach(bucketUsers, func(string, []byte) error {
+		return found
+	})
+	if errors.Is(err, found) {
+		return true, nil
+	}
+	return false, err
+}
+
+// Get returns a user
+func Get(name string) (User, error) {
+	rec, err := load(name)
+	return rec.User, err
+}
+
+// Create adds a user with the given password
+func Create(user User, password string) (User, error) {
+	if !namePattern.MatchString(user.Name) {
+		return User{}, fmt.Errorf("%w: name must be 1-64 letters, digits, '.', '_' or '-'", ErrInvalidUser)
+	}
+	if err := validateRole(user.Role); err != nil {
+		return User{}, err
+	}
+	if err := tenants.Validate(user.Tenant); err != nil {
+		return User{}, fmt.Errorf("%w: %w", ErrInvalidUser, err)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, err := load(user.Name); err == nil {
+		return User{}, fmt.Errorf("%w: %s", ErrUserExists, user.Name)
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return User{}, err
+	}
+
+	user.Created = time.Now()
+	user.LastLogin = time.Time{}
+	if err := state.Put(bucketUsers, user.Name, record{User: user, PasswordHash: hash}); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// Update applies changes to a user. Disabling a user or changing the
+// password ends the user's sessions.
+func Update(name string, changes Changes) (User, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rec, err := load(name)
+	if err != nil {
+		return User{}, err
+	}
+	wasAdmin := isEnabledAdmin(rec.User)
+	revoke := false
+
+	if changes.Role != nil {
+		if err := validateRole(*changes.Role); err != nil {
+			return User{}, err
+		}
+		rec.Role = *changes.Role
+	}
+	if changes.Maps != nil {
+		rec.Maps = *changes.Maps
+	}
+	if changes.Tenant != nil {
+		if err := tenants.Validate(*changes.Tenant); err != nil {
+			return User{}, fmt.Errorf("%w: %w", ErrInvalidUser, err)
+		}
+		rec.Tenant = *changes.Tenant
+	}
+	if changes.Disabled != nil {
+		rec.Disabled = *changes.Disabled
+		revoke = revoke || rec.Disabled
+	}
+	if changes.Password != nil {
+		hash, err := hashPassword(*changes.Password)
+		if err != nil {
+			return User{}, err
+		}
+		rec.PasswordHash = hash
+		revoke = true
+	}
+	if changes.ResetTwoFactor {
+		rec.resetTwoFactor()
+	}
+
+	if wasAdmin && !isEnabledAdmin(rec.User) {
+		if err := checkOtherAdminLocked(name); err != nil {
+			return User{}, err
+		}
+	}
+	if err := state.Put(bucketUsers, name, rec); err != nil {
+		return User{}, err
+	}
+	if revoke {
+		revokeSessions(name)
+	}
+	return rec.User, nil
+}
+
+// Delete removes a user and ends the user's sessions
+func Delete(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	rec, err := load(name)
+	if err != nil {
+		return err
+	}
+	if isEnabledAdmin(rec.User) {
+		if err := checkOtherAdminLocked(name); err != nil {
+			return err
+		}
+	}
+	if err := state.Delete(bucketUsers, name); err != nil {
+		return err
+	}
+	revokeSessions(name)
+	return nil
+}
+
+// isEnabledAdmin reports whether u administers the whole manager, admins
+// of a tenant only administer its maps
+func isEnabledAdmin(u User) bool {
+	return u.Role == RoleAdmin && !u.Disabled && u.Tenant == ""
+}
+
+// checkOtherAdminLocked makes sure an enabled admin besides name is left
+func checkOtherAdminLocked(name string) error {
+	records, err := loadAll()
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if rec.Name != name && isEnabledAdmin(rec.User) {
+			return nil
+		}
+	}
+	return ErrLastAdmin
+}
+
+// Authenticate checks a user's password and, if the user enrolled in
+// two-factor authentication, the TOTP or recovery code. Unknown users,
+// wrong passwords or codes and disabled users all fail with
+// ErrInvalidCredentials, a missing code with ErrTwoFactorRequired.
+func Authenticate(name string, password string, code string) (User, error) {
+	rec, err := load(name)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			// Spend the time of a hash comparison anyway, so response
+			// times do not reveal which users exist
+			bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+			return User{}, ErrInvalidCredentials
+		}
+		return User{}, err
+	}
+	if bcrypt.CompareHashAndPassword(rec.PasswordHash, []byte(password)) != nil || rec.Disabled {
+		return User{}, ErrInvalidCredentials
+	}
+	if rec.TwoFactor && code == "" {
+		return User{}, ErrTwoFactorRequired
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current, err := load(name)
+	if err != nil {
+		return User{}, err
+	}
+	if current.TwoFactor && !current.verifySecondFactor(code) {
+		return User{}, ErrInvalidCredentials
+	}
+	current.LastLogin = time.Now()
+	if err := state.Put(bucketUsers, name, current); err != nil {
+		return User{}, err
+	}
+	return current.User, nil
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
@@ -0,0 +1,104 @@
+package whitelist
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"asa_servermanager_api/configfile"
+)
+
+// FileName is the exclusive join list ASA reads from the directory of the
+// server executable when started with -exclusivejoin
+const FileName = "PlayersExclusiveJoinList.txt"
+
+var (
+	ErrInvalidID = errors.New("invalid EOS ID")
+
+	eosIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	// fileMu serializes read-modify-write updates of the list files
+	fileMu sync.Mutex
+)
+
+// NormalizeID lowercases an EOS ID and checks its format
+func NormalizeID(id string) (string, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if !eosIDPattern.MatchString(id) {
+		return "", fmt.Errorf("%w: %q must be 32 hexadecimal characters", ErrInvalidID, id)
+	}
+	return id, nil
+}
+
+// Path returns the list file of the server executable
+func Path(executable string) string {
+	return filepath.Join(filepath.Dir(executable), FileName)
+}
+
+// Read returns the IDs in a list file, a missing file is an empty list
+func Read(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	ids := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// Update adds and removes IDs in a list file and returns the resulting
+// list. IDs already present are not added twice.
+func Update(path string, add []string, remove []string) ([]string, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	ids, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(map[string]bool, len(remove))
+	for _, id := range remove {
+		removed[strings.ToLower(id)] = true
+	}
+	present := make(map[string]bool, len(ids))
+	kept := make([]string, 0, len(ids)+len(add))
+	for _, id := range ids {
+		if removed[strings.ToLower(id)] {
+			continue
+		}
+		present[strings.ToLower(id)] = true
+		kept = append(kept, id)
+	}
+	for _, id := range add {
+		if !present[id] {
+			present[id] = true
+			kept = append(kept, id)
+		}
+	}
+
+	// ASA is a Windows server, keep the file in CRLF like it writes it
+	var buf bytes.Buffer
+	for _, id := range kept {
+		buf.WriteString(id + "\r\n")
+	}
+	if err := configfile.WriteFile(path, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return kept, nil
+}
@@ -0,0 +1,509 @@
+package wipe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"asa_servermanager_api/broadcast"
+	"asa_servermanager_api/dryrun"
+	"asa_servermanager_api/notify"
+	"asa_servermanager_api/processmanager"
+	"asa_servermanager_api/rcon"
+	"asa_servermanager_api/state"
+)
+
+const (
+	bucketWipes = "dino_wipes"
+	maxEvents   = 50
+)
+
+var defaultWarningMinutes = []int{10, 5, 1}
+
+const (
+	// defaultCountDelay is how long after DestroyWildDinos the wild dinos
+	// are counted again
+	defaultCountDelay = 10 * time.Second
+
+	// A wipe that leaves more than this share of the wild dinos had no
+	// effect
+	maxRemainingRatio = 0.5
+)
+
+var defaultCountPattern = regexp.MustCompile(`\d[\d,]*`)
+
+var (
+	ErrInProgress = errors.New("wild dino wipe already in progress")
+	ErrNotRunning = errors.New("map is not running")
+	ErrNoEffect   = errors.New("DestroyWildDinos appears to have had no effect")
+)
+
+// Triggers of a wipe
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Schedule wipes the wild dinos of a map every day at Times, local "HH:MM".
+// WarningMinutes are broadcast before each wipe with the wipe template of
+// the broadcast config. Message overrides the template for the map, %d is
+// replaced with the minutes left like {minutes_remaining}.
+type Schedule struct {
+	Map            string   `json:"map"`
+	Times          []string `json:"times"`
+	WarningMinutes []int    `json:"warning_minutes,omitempty"`
+	Message        string   `json:"message,omitempty"`
+}
+
+// DinoCount counts the wild dinos of a map before and after each wipe. ASA
+// has no console command that reports the count, Command is usually one of
+// a server plugin. Pattern finds the count in its output, its first group
+// if it has one, by default the first number.
+type DinoCount struct {
+	Command      string `json:"command"`
+	Pattern      string `json:"pattern,omitempty"`
+	DelaySeconds int    `json:"delay_seconds,omitempty"`
+}
+
+type WipeConfig struct {
+	Schedules []Schedule `json:"schedules"`
+	DinoCount *DinoCount `json:"dino_count,omitempty"`
+}
+
+// Event records a wipe. DinosBefore and DinosAfter are the wild dinos
+// counted around it, nil if they were not counted.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Map         string    `json:"map"`
+	Trigger     string    `json:"trigger"`
+	Caller      string    `json:"caller"`
+	DinosBefore *int      `json:"dinos_before,omitempty"`
+	DinosAfter  *int      `json:"dinos_after,omitempty"`
+	CountError  string    `json:"count_error,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Status is the wipe state of a map
+type Status struct {
+	Map        string    `json:"map"`
+	Scheduled  bool      `json:"scheduled"`
+	Next       time.Time `json:"next,omitempty"`
+	InProgress bool      `json:"in_progress"`
+	History    []Event   `json:"history"`
+}
+
+type Wiper struct {
+	schedules map[string]Schedule
+	times     map[string][]time.Duration
+	pm        *processmanager.ProcessManager
+
+	countCommand string
+	countPattern *regexp.Regexp
+	countDelay   time.Duration
+
+	next    map[string]time.Time
+	running map[string]bool
+	mu      sync.Mutex
+}
+
+func NewWiper(configFile string, pm *processmanager.ProcessManager) (*Wiper, error) {
+	config, err := LoadWipeConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Wiper{
+		schedules: make(map[string]Schedule),
+		times:     make(map[string][]time.Duration),
+		pm:        pm,
+		next:      make(map[string]time.Time),
+		running:   make(map[string]bool),
+	}
+	if c := config.DinoCount; c != nil && c.Command != "" {
+		w.countCommand = c.Command
+		w.countPattern = defaultCountPattern
+		if c.Pattern != "" {
+			if w.countPattern, err = regexp.Compile(c.Pattern); err != nil {
+				return nil, fmt.Errorf("wipe config: dino count pattern: %w", err)
+			}
+		}
+		if c.DelaySeconds < 0 {
+			return nil, fmt.Errorf("wipe config: dino count delay_seconds must not be negative")
+		}
+		w.countDelay = defaultCountDelay
+		if c.DelaySeconds > 0 {
+			w.countDelay = time.Duration(c.DelaySeconds) * time.Second
+		}
+	}
+	for _, s := range config.Schedules {
+		if _, exists := w.schedules[s.Map]; exists {
+			return nil, fmt.Errorf("map %s has more than one wipe schedule", s.Map)
+		}
+		if !pm.HasMap(s.Map) {
+			log.Printf("Wipe schedule for map '%s' without a process configuration", s.Map)
+		}
+		if len(s.Times) == 0 {
+			return nil, fmt.Errorf("wipe schedule of map %s has no times", s.Map)
+		}
+		var times []time.Duration
+		for _, value := range s.Times {
+			t, err := time.Parse("15:04", value)
+			if err != nil {
+				return nil, fmt.Errorf("wipe schedule of map %s: time %q must be a time of day such as 04:00", s.Map, value)
+			}
+			times = append(times, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+		if s.WarningMinutes == nil {
+			s.WarningMinutes = defaultWarningMinutes
+		}
+		w.schedules[s.Map] = s
+		w.times[s.Map] = times
+	}
+	return w, nil
+}
+
+// LoadWipeConfig reads the wipe schedules, a missing file means none
+func LoadWipeConfig(filename string) (WipeConfig, error) {
+	var config WipeConfig
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, fmt.Errorf("failed to read wipe config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse wipe config: %w", err)
+	}
+	return config, nil
+}
+
+// nextTime returns the first scheduled wipe of a map after t
+func (w *Wiper) nextTime(mapName string, t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for day := 0; day < 2; day++ {
+		for _, offset := range w.times[mapName] {
+			next := midnight.AddDate(0, 0, day).Add(offset)
+			if next.After(t) {
+				return next
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Start runs the wipe schedules in the background
+func (w *Wiper) Start() {
+	for mapName := range w.schedules {
+		go w.schedule(mapName)
+	}
+}
+
+func (w *Wiper) schedule(mapName string) {
+	for {
+		next := w.nextTime(mapName, time.Now())
+		w.mu.Lock()
+		w.next[mapName] = next
+		w.mu.Unlock()
+
+		// Wake up in time for the first warning
+		lead := 0
+		for _, m := range w.schedules[mapName].WarningMinutes {
+			lead = max(lead, m)
+		}
+		time.Sleep(time.Until(next.Add(-time.Duration(lead) * time.Minute)))
+
+		if dryrun.Global() {
+			if plan, err := w.plan(rcon.System, mapName, true, TriggerSchedule); err != nil {
+				log.Printf("Dry run of the scheduled wild dino wipe of map '%s' failed: %v", mapName, err)
+			} else {
+				plan.Log()
+			}
+		} else if err := w.run(mapName, next, TriggerSchedule, rcon.System); err != nil {
+			log.Printf("Scheduled wild dino wipe of map '%s' failed: %v", mapName, err)
+		}
+		// Do not run the same slot twice if the wipe finished early
+		time.Sleep(time.Until(next.Add(time.Second)))
+	}
+}
+
+// Trigger wipes the wild dinos of a map on behalf of caller. With warn set
+// the wipe runs in the background after the map's warnings, otherwise it
+// runs right away.
+func (w *Wiper) Trigger(caller rcon.Caller, mapName string, warn bool) (time.Time, error) {
+	if err := w.check(caller, mapName); err != nil {
+		return time.Time{}, err
+	}
+	if !warn {
+		return time.Now(), w.run(mapName, time.Now(), TriggerManual, caller)
+	}
+
+	lead := 0
+	for _, m := range w.warnings(mapName) {
+		lead = max(lead, m)
+	}
+	at := time.Now().Add(time.Duration(lead) * time.Minute)
+	if err := w.begin(mapName); err != nil {
+		return time.Time{}, err
+	}
+	go func() {
+		if err := w.wipe(mapName, at, TriggerManual, caller); err != nil {
+			log.Printf("Wild dino wipe of map '%s' failed: %v", mapName, err)
+		}
+	}()
+	return at, nil
+}
+
+// check reports why caller cannot wipe the wild dinos of a map. It is done
+// up front, a denied wipe would otherwise only fail after the warnings went
+// out.
+func (w *Wiper) check(caller rcon.Caller, mapName string) error {
+	if !w.pm.HasMap(mapName) {
+		return fmt.Errorf("%w: %s", processmanager.ErrMapNotFound, mapName)
+	}
+	perms, err := rcon.LoadPermissions()
+	if err != nil {
+		return err
+	}
+	if err := perms.Authorize(caller, "DestroyWildDinos"); err != nil {
+		return err
+	}
+	if !w.isRunning(mapName) {
+		return fmt.Errorf("%w: %s", ErrNotRunning, mapName)
+	}
+	return nil
+}
+
+// Plan returns the commands Trigger would send, for a dry run
+func (w *Wiper) Plan(caller rcon.Caller, mapName string, warn bool) (dryrun.Plan, error) {
+	return w.plan(caller, mapName, warn, TriggerManual)
+}
+
+func (w *Wiper) plan(caller rcon.Caller, mapName string, warn bool, trigger string) (dryrun.Plan, error) {
+	if err := w.check(caller, mapName); err != nil {
+		return dryrun.Plan{}, err
+	}
+	plan := dryrun.Plan{Operation: "wild dino wipe", Map: mapName}
+	if warn {
+		warnings := w.sortedWarnings(mapName)
+		for _, minutes := range warnings {
+			if command := w.warning(mapName, minutes, trigger); command != "" {
+				plan.Commands = append(plan.Commands, command)
+			}
+		}
+		if len(warnings) > 0 {
+			plan.Stepf("Wipe %d minute(s) after the first warning", warnings[0])
+		}
+	}
+	if w.countCommand == "" {
+		plan.Commands = append(plan.Commands, "DestroyWildDinos")
+		return plan, nil
+	}
+	plan.Commands = append(plan.Commands, w.countCommand, "DestroyWildDinos", w.countCommand)
+	plan.Stepf("Count the wild dinos before the wipe and %s after it, fail if more than %d%% are left", w.countDelay, int(maxRemainingRatio*100))
+	return plan, nil
+}
+
+func (w *Wiper) warnings(mapName string) []int {
+	if s, ok := w.schedules[mapName]; ok {
+		return s.WarningMinutes
+	}
+	return defaultWarningMinutes
+}
+
+func (w *Wiper) begin(mapName string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.running[mapName] {
+		return fmt.Errorf("%w: %s", ErrInProgress, mapName)
+	}
+	w.running[mapName] = true
+	return nil
+}
+
+func (w *Wiper) run(mapName string, at time.Time, trigger string, caller rcon.Caller) error {
+	if err := w.begin(mapName); err != nil {
+		return err
+	}
+	return w.wipe(mapName, at, trigger, caller)
+}
+
+// wipe broadcasts the warnings that are still ahead of at, then destroys
+// the wild dinos and records the event. The caller must have called begin.
+func (w *Wiper) wipe(mapName string, at time.Time, trigger string, caller rcon.Caller) error {
+	defer func() {
+		w.mu.Lock()
+		delete(w.running, mapName)
+		w.mu.Unlock()
+	}()
+
+	for _, minutes := range w.sortedWarnings(mapName) {
+		warnAt := at.Add(-time.Duration(minutes) * time.Minute)
+		if time.Until(warnAt) < -time.Minute {
+			// Too late for this warning
+			continue
+		}
+		time.Sleep(time.Until(warnAt))
+		if !w.isRunning(mapName) {
+			break
+		}
+		command := w.warning(mapName, minutes, trigger)
+		if command == "" {
+			continue
+		}
+		if _, err := rcon.ExecuteAs(caller, mapName, command); err != nil {
+			log.Printf("Failed to announce wild dino wipe on map '%s': %v", mapName, err)
+		}
+	}
+	time.Sleep(time.Until(at))
+
+	event := Event{Time: time.Now(), Map: mapName, Trigger: trigger, Caller: caller.Name}
+	var err error
+	if !w.isRunning(mapName) {
+		err = fmt.Errorf("%w: %s", ErrNotRunning, mapName)
+	} else {
+		err = w.destroy(&event, caller)
+	}
+	if err != nil {
+		event.Error = err.Error()
+		notify.Publish(notify.EventWipeFailed, fmt.Sprintf("wild dino wipe of %s failed: %v", mapName, err), map[string]interface{}{
+			"map": mapName, "trigger": trigger, "dinos_before": event.DinosBefore, "dinos_after": event.DinosAfter, "error": err.Error(),
+		})
+	}
+	if recordErr := record(event); recordErr != nil {
+		log.Printf("Failed to record wild dino wipe of map '%s': %v", mapName, recordErr)
+	}
+	return err
+}
+
+// destroy sends DestroyWildDinos and, if a count command is configured,
+// counts the wild dinos before and after it. A wipe that leaves most of
+// them fails with ErrNoEffect.
+func (w *Wiper) destroy(event *Event, caller rcon.Caller) error {
+	mapName := event.Map
+	if w.countCommand != "" {
+		if before, err := w.countDinos(mapName); err != nil {
+			event.CountError = "before the wipe: " + err.Error()
+		} else {
+			event.DinosBefore = &before
+		}
+	}
+
+	if _, err := rcon.ExecuteAs(caller, mapName, "DestroyWildDinos"); err != nil {
+		return err
+	}
+	if event.DinosBefore == nil {
+		log.Printf("Wiped wild dinos on map '%s' (%s)", mapName, event.Trigger)
+		return nil
+	}
+
+	time.Sleep(w.countDelay)
+	after, err := w.countDinos(mapName)
+	if err != nil {
+		event.CountError = "after the wipe: " + err.Error()
+		log.Printf("Wiped wild dinos on map '%s' (%s), failed to count them again: %v", mapName, event.Trigger, err)
+		return nil
+	}
+	event.DinosAfter = &after
+	before := *event.DinosBefore
+	if before > 0 && float64(after) > float64(before)*maxRemainingRatio {
+		return fmt.Errorf("%w on map %s: %d wild dinos before, %d after", ErrNoEffect, mapName, before, after)
+	}
+	log.Printf("Wiped wild dinos on map '%s' (%s): %d before, %d after", mapName, event.Trigger, before, after)
+	return nil
+}
+
+// countDinos runs the count command on a map and reads the count from its
+// output
+func (w *Wiper) countDinos(mapName string) (int, error) {
+	output, err := rcon.Execute(mapName, w.countCommand)
+	if err != nil {
+		return 0, err
+	}
+	match := w.countPattern.FindStringSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("no count in the output of %q: %q", w.countCommand, strings.TrimSpace(output))
+	}
+	value := match[0]
+	if len(match) > 1 {
+		value = match[1]
+	}
+	count, err := strconv.Atoi(strings.ReplaceAll(value, ",", ""))
+	if err != nil {
+		return 0, fmt.Errorf("count %q in the output of %q is not a number", value, w.countCommand)
+	}
+	return count, nil
+}
+
+// sortedWarnings returns the warning minutes of a map, first warning first
+func (w *Wiper) sortedWarnings(mapName string) []int {
+	warnings := append([]int(nil), w.warnings(mapName)...)
+	sort.Sort(sort.Reverse(sort.IntSlice(warnings)))
+	return warnings
+}
+
+// warning returns the broadcast command of the warning minutes before a
+// wipe, "" if the wipe template is empty
+func (w *Wiper) warning(mapName string, minutes int, trigger string) string {
+	vars := broadcast.Minutes(minutes).With(broadcast.VarReason, trigger+" wild dino wipe")
+	if s, ok := w.schedules[mapName]; ok && s.Message != "" {
+		message := strings.ReplaceAll(s.Message, "%d", "{"+broadcast.VarMinutesRemaining+"}")
+		text, _ := broadcast.RenderTemplate(message, mapName, vars)
+		return "broadcast " + text
+	}
+	return broadcast.Command(broadcast.EventWipe, mapName, vars)
+}
+
+func (w *Wiper) isRunning(mapName string) bool {
+	ms, ok := w.pm.State(mapName)
+	return ok && ms.Actual == processmanager.ActualRunning
+}
+
+func record(event Event) error {
+	var events []Event
+	if _, err := state.Get(bucketWipes, event.Map, &events); err != nil {
+		return err
+	}
+	events = append(events, event)
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+	return state.Put(bucketWipes, event.Map, events)
+}
+
+// Status returns the next scheduled wipe of a map and its past wipes,
+// newest first
+func (w *Wiper) Status(mapName string) (Status, error) {
+	var events []Event
+	if _, err := state.Get(bucketWipes, mapName, &events); err != nil {
+		return Status{}, err
+	}
+	history := make([]Event, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		history = append(history, events[i])
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, scheduled := w.schedules[mapName]
+	status := Status{Map: mapName, Scheduled: scheduled, InProgress: w.running[mapName], History: history}
+	if scheduled {
+		status.Next = w.next[mapName]
+		if status.Next.IsZero() {
+			status.Next = w.nextTime(mapName, time.Now())
+		}
+	}
+	return status, nil
+}